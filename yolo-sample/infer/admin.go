@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/jpeg"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The admin listener (see diagnostics.go) also serves /admin/, a page of safe
+// diagnostic actions for field support, so most problems can be looked at
+// without kubectl exec or ssh into the device:
+//
+//	POST /admin/ping        reach the central endpoints the node is configured
+//	                        with (sync, fleet, update, ...): DNS, then HTTP or TCP
+//	POST /admin/camera      grab one frame from a camera source
+//	POST /admin/readiness   re-run the readiness checks (state dir, model,
+//	                        worker, backend, clock, thermal, power, network,
+//	                        ffmpeg, sources)
+//	GET  /admin/logs        the recent log lines (?lines=200&grep=text)
+//	POST /admin/maintenance switch maintenance mode (see maintenance.go)
+//	POST /admin/faults      inject failures for resilience tests (see faults.go)
+//	POST /admin/logout      end the page's session
+//
+// The actions only read state or open outbound connections to configured
+// addresses; none take a URL or a command. With ADMIN_TOKEN set a browser
+// signs in at /admin/login, which marks its session admin (see sessions.go).
+
+// adminActionTimeout bounds each action
+const adminActionTimeout = 20 * time.Second
+
+var adminClient = &http.Client{
+	Timeout: 10 * time.Second,
+	// Report the endpoint itself, not where it redirects to
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// PingResult is the outcome of reaching one central endpoint
+type PingResult struct {
+	Target    string   `json:"target"`
+	URL       string   `json:"url"` // credentials redacted
+	OK        bool     `json:"ok"`
+	Addresses []string `json:"addresses,omitempty"`
+	DNSMS     float64  `json:"dns_ms"`
+	Status    string   `json:"status,omitempty"`
+	ElapsedMS float64  `json:"elapsed_ms"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ReadinessCheck is the outcome of one readiness check
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// registerAdminPage adds the admin page and its actions to the admin mux
+func registerAdminPage(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/", adminPageHandler)
+	mux.HandleFunc("/admin/login", adminLoginHandler)
+	mux.HandleFunc("/admin/logout", adminLogoutHandler)
+	mux.HandleFunc("/admin/logs", adminLogsHandler)
+	mux.HandleFunc("/admin/ping", adminAction(adminPing))
+	mux.HandleFunc("/admin/camera", adminAction(adminCamera))
+	mux.HandleFunc("/admin/readiness", adminAction(adminReadiness))
+	mux.HandleFunc("/admin/maintenance", adminAction(adminMaintenance))
+	mux.HandleFunc("/admin/faults", adminAction(adminFaults))
+}
+
+// adminAuthorized reports whether r carries ADMIN_TOKEN as a bearer token,
+// or the cookie of an admin page session
+func adminAuthorized(r *http.Request) bool {
+	token := config().AdminToken
+	if token == "" {
+		return true
+	}
+	if got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); got != "" {
+		return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+	s, ok := currentSession(r)
+	return ok && s.Admin
+}
+
+// adminAction serves a POST action returning JSON; the request body, if
+// any, is the action's JSON arguments
+func adminAction(action func(ctx context.Context, args map[string]string) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		args := map[string]string{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&args); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+		}
+		log.Printf("Admin action %s %v from %s", r.URL.Path, args, r.RemoteAddr)
+		ctx, cancel := context.WithTimeout(r.Context(), adminActionTimeout)
+		defer cancel()
+		result, err := action(ctx, args)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// centralEndpoints returns the configured off-node endpoints by target name
+func centralEndpoints(cfg *Config) map[string]string {
+	all := map[string]string{
+		"sync":      cfg.SyncURL,
+		"fleet":     cfg.FleetConfigURL,
+		"update":    cfg.UpdateURL,
+		"clock":     cfg.ClockCheckURL,
+		"webhook":   cfg.EventWebhookURL,
+		"crash":     cfg.CrashReportURL,
+		"fallback":  cfg.FallbackInferenceURL,
+		"probe":     cfg.NetworkProbeURL,
+		"heartbeat": cfg.HeartbeatURL,
+		"logs":      cfg.LogShipURL,
+		"mqtt":      cfg.MQTTURL,
+	}
+	for name, u := range all {
+		if u == "" {
+			delete(all, name)
+		}
+	}
+	return all
+}
+
+// adminPing reaches args["target"], or every central endpoint
+func adminPing(ctx context.Context, args map[string]string) (interface{}, error) {
+	endpoints := centralEndpoints(config())
+	var targets []string
+	if t := args["target"]; t != "" {
+		if _, ok := endpoints[t]; !ok {
+			return nil, fmt.Errorf("no %s endpoint is configured", t)
+		}
+		targets = []string{t}
+	} else {
+		for t := range endpoints {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+	}
+	results := make([]PingResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			results[i] = pingEndpoint(ctx, t, endpoints[t])
+		}(i, t)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func pingEndpoint(ctx context.Context, target, rawURL string) PingResult {
+	res := PingResult{Target: target, URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.URL = u.Redacted()
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	res.DNSMS = msSince(start)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Addresses = addrs
+
+	start = time.Now()
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		resp, err := adminClient.Do(req)
+		if err != nil {
+			res.Error = err.Error()
+			break
+		}
+		resp.Body.Close()
+		// Any answer below 500 shows the endpoint is reachable; many
+		// answer HEAD or an unauthenticated request with 4xx
+		res.Status, res.OK = resp.Status, resp.StatusCode < 500
+	default:
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"mqtt": "1883", "mqtts": "8883"}[u.Scheme]
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+		if err != nil {
+			res.Error = err.Error()
+			break
+		}
+		conn.Close()
+		res.Status, res.OK = "connected", true
+	}
+	res.ElapsedMS = msSince(start)
+	return res
+}
+
+// adminCamera grabs one frame from args["source"]
+func adminCamera(ctx context.Context, args map[string]string) (interface{}, error) {
+	src, ok := sources.get(args["source"])
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", args["source"])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var frame []byte
+	start := time.Now()
+	err := captureFrames(ctx, src, func(f []byte) {
+		once.Do(func() {
+			frame = f
+			cancel()
+		})
+	})
+	result := map[string]interface{}{"source": src.Name, "elapsed_ms": msSince(start)}
+	if view, ok := sources.view(src.Name); ok {
+		result["url"] = view.URL
+		result["status"] = view.Status
+	}
+	if frame == nil {
+		if err == nil {
+			err = fmt.Errorf("no frame within %s", adminActionTimeout)
+		}
+		result["error"] = err.Error()
+		return result, nil
+	}
+	result["bytes"] = len(frame)
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(frame)); err == nil {
+		result["width"], result["height"] = cfg.Width, cfg.Height
+	}
+	result["frame"] = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(frame)
+	return result, nil
+}
+
+// adminReadiness re-runs the readiness checks
+func adminReadiness(ctx context.Context, args map[string]string) (interface{}, error) {
+	cfg := config()
+	var checks []ReadinessCheck
+	add := func(name string, ok bool, detail string, a ...interface{}) {
+		checks = append(checks, ReadinessCheck{name, ok, fmt.Sprintf(detail, a...)})
+	}
+
+	probe, err := os.CreateTemp(cfg.StateDir, ".readiness-*")
+	if err == nil {
+		probe.Close()
+		os.Remove(probe.Name())
+		if _, free, err := diskFree(cfg.StateDir); err == nil {
+			add("state_dir", free > 0.05, "%s writable, %.0f%% free", cfg.StateDir, free*100)
+		} else {
+			add("state_dir", true, "%s writable", cfg.StateDir)
+		}
+	} else {
+		add("state_dir", false, "%v", err)
+	}
+
+	if m := maintenanceView(); m.Enabled {
+		add("maintenance", false, "since %s: %s", m.Since.Format(time.RFC3339), m.Reason)
+	}
+	if t := thermalView(); t.Throttled {
+		add("thermal", false, "throttled since %s: %s", t.Since.Format(time.RFC3339), t.Reason)
+	}
+	if p := powerView(); p.OnBattery {
+		add("power", false, "on battery since %s (%s %s)", p.Since.Format(time.RFC3339), p.Provider, p.UPSStatus)
+	}
+	if f := faultsView(); f.Active {
+		add("faults", false, "injected until %s", f.Until.Format(time.RFC3339))
+	}
+	version := activeModelVersion()
+	add("model", modelExists(version), "%s", modelPath(version))
+	wv := worker.view()
+	add("worker", wv.State == workerRunning, "%s, %d restarts %s", wv.State, wv.Restarts, wv.LastExit)
+	if backendBreaker.allow() {
+		add("backend", true, "circuit breaker %s", breakerClosed)
+	} else {
+		add("backend", false, "circuit breaker open, requests go to the fallback or fail")
+	}
+
+	checkClock()
+	cs := clockView()
+	add("clock", cs.Synchronized, "%s %s", cs.Kernel, cs.Reason)
+
+	networkProbe.Lock()
+	networkProbe.status = "" // probe again rather than report the cached answer
+	networkProbe.Unlock()
+	status, staleSince := networkStatus()
+	if !staleSince.IsZero() {
+		add("network", false, "%s (%s provider), stale since %s", status, networkProvider(cfg), staleSince.Format(time.RFC3339))
+	} else {
+		add("network", status == "online", "%s (%s provider)", status, networkProvider(cfg))
+	}
+
+	if path, err := exec.LookPath(cfg.FFmpegPath); err == nil {
+		add("ffmpeg", true, "%s", path)
+	} else {
+		add("ffmpeg", false, "%v; rtsp and v4l2 sources need it", err)
+	}
+	for _, v := range sources.list() {
+		if v.Enabled {
+			add("source "+v.Name, v.Status.Connected, "%s %s", v.Status.State, v.Status.LastError)
+		}
+	}
+
+	ready := true
+	for i := range checks {
+		checks[i].Detail = strings.TrimSpace(checks[i].Detail)
+		ready = ready && checks[i].OK
+	}
+	return map[string]interface{}{"ready": ready, "checks": checks, "checked_at": time.Now().UTC()}, nil
+}
+
+// adminLogsHandler serves GET /admin/logs, the last `lines` (default 200)
+// kept log lines, optionally only those containing `grep`
+func adminLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || n <= 0 {
+		n = 200
+	}
+	grep := r.URL.Query().Get("grep")
+	var lines []string
+	for _, line := range strings.SplitAfter(string(recentLogs.snapshot()), "\n") {
+		if line != "" && strings.Contains(line, grep) {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, strings.Join(lines, ""))
+}
+
+// adminLoginHandler serves the sign-in form and sets the admin cookie
+func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	token := config().AdminToken
+	if token == "" {
+		redirectTo(w, r, "/admin/", http.StatusSeeOther)
+		return
+	}
+	failed := false
+	if r.Method == http.MethodPost {
+		got := r.PostFormValue("token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			if _, _, err := startSession(w, r, session{Admin: true, Token: tokenHash(token)}); err != nil {
+				log.Printf("Warning: admin page sign-in from %s failed: %v", r.RemoteAddr, err)
+				http.Error(w, "Sessions are unavailable: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			log.Printf("Admin page sign-in from %s", r.RemoteAddr)
+			redirectTo(w, r, "/admin/", http.StatusSeeOther)
+			return
+		}
+		log.Printf("Warning: failed admin page sign-in from %s", r.RemoteAddr)
+		failed = true
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	tmpl := `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head><title>{{t "Admin"}} - {{brandTitle}}</title><style>` + adminStyle + `</style></head>
+<body>
+    <h1>{{t "Admin"}}</h1>
+    <div class="panel">
+        <form method="post">
+            <label>{{t "Admin token"}}</label>
+            {{csrfField}}
+            <input type="password" name="token" autofocus required>
+            <button type="submit">{{t "Sign in"}}</button>
+            {{if .}}<div class="fail">{{t "Wrong token"}}</div>{{end}}
+        </form>
+    </div>
+</body>
+</html>`
+	t, err := template.New("login").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, failed)
+}
+
+// adminLogoutHandler ends the admin page session
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	endSession(w, r)
+	redirectTo(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+const adminStyle = `
+        body { font-family: Arial, sans-serif; max-width: 900px; margin: 30px auto; padding: 20px; background-color: #f5f5f5; }
+        h1 { color: #333; }
+        .panel { background: white; padding: 20px; margin-bottom: 20px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        button { background-color: #4CAF50; color: white; padding: 8px 16px; border: none; border-radius: 4px; cursor: pointer; }
+        input, select { padding: 8px; margin: 4px 0; }
+        table { width: 100%; border-collapse: collapse; font-size: 14px; margin-top: 10px; }
+        td, th { text-align: left; padding: 6px; border-bottom: 1px solid #eee; vertical-align: top; }
+        pre { background: #222; color: #ddd; padding: 10px; overflow: auto; max-height: 400px; font-size: 12px; }
+        .ok { color: #4CAF50; font-weight: bold; }
+        .fail { color: #d32f2f; font-weight: bold; }
+        img { max-width: 100%; margin-top: 10px; }
+`
+
+// adminPageHandler serves GET /admin/
+func adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/" {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var targets, names []string
+	for t := range centralEndpoints(config()) {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	for _, v := range sources.list() {
+		names = append(names, v.Name)
+	}
+	data := struct {
+		Node        string
+		Targets     []string
+		Sources     []string
+		Maintenance MaintenanceView
+		SignedIn    bool
+	}{getEnv("NODE_NAME", "unknown"), targets, names, maintenanceView(), false}
+	_, data.SignedIn = currentSession(r)
+
+	tmpl := `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head><title>{{t "Admin"}} - {{brandTitle}}</title><style>` + adminStyle + `</style></head>
+<body>
+    <h1>{{t "Admin"}}: {{.Node}}</h1>
+    {{if .SignedIn}}<form method="post" action="{{base}}/admin/logout">{{csrfField}}<button type="submit">{{t "Sign out"}}</button></form>{{end}}
+    <div class="panel">
+        <h2>{{t "Maintenance"}}</h2>
+        <p id="maintenance">{{if .Maintenance.Enabled}}<span class="fail">{{t "In maintenance"}}</span> {{.Maintenance.Reason}}{{else}}<span class="ok">{{t "In service"}}</span>{{end}}</p>
+        <input type="text" id="reason" placeholder="{{t "Reason"}}" value="{{.Maintenance.Reason}}">
+        <button id="maintenanceBtn" data-enabled="{{not .Maintenance.Enabled}}">{{if .Maintenance.Enabled}}{{t "End maintenance"}}{{else}}{{t "Start maintenance"}}{{end}}</button>
+    </div>
+    <div class="panel">
+        <h2>{{t "Readiness"}}</h2>
+        <button data-action="readiness">{{t "Run checks"}}</button>
+        <table id="readiness"></table>
+    </div>
+    <div class="panel">
+        <h2>{{t "Central endpoints"}}</h2>
+        {{if .Targets}}
+        <select id="target"><option value="">{{t "All"}}</option>{{range .Targets}}<option>{{.}}</option>{{end}}</select>
+        <button data-action="ping">{{t "Ping"}}</button>
+        <table id="ping"></table>
+        {{else}}<p>{{t "No central endpoints are configured."}}</p>{{end}}
+    </div>
+    <div class="panel">
+        <h2>{{t "Camera connection"}}</h2>
+        {{if .Sources}}
+        <select id="source">{{range .Sources}}<option>{{.}}</option>{{end}}</select>
+        <button data-action="camera">{{t "Grab a frame"}}</button>
+        <div id="camera"></div>
+        {{else}}<p>{{t "No camera sources configured yet."}}</p>{{end}}
+    </div>
+    <div class="panel">
+        <h2>{{t "Recent logs"}}</h2>
+        <input type="text" id="grep" placeholder="{{t "Filter"}}">
+        <button id="logsBtn">{{t "Refresh"}}</button>
+        <label><input type="checkbox" id="follow"> {{t "Follow"}}</label>
+        <pre id="logs"></pre>
+    </div>
+    <script nonce="{{cspNonce}}">
+        function esc(s) {
+            return String(s === undefined ? '' : s).replace(/[&<>"]/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;'}[c]));
+        }
+        function mark(ok) {
+            return ok ? '<span class="ok">OK</span>' : '<span class="fail">FAIL</span>';
+        }
+        async function run(action, args) {
+            const resp = await fetch({{base}} + '/admin/' + action, {
+                method: 'POST', headers: {'Content-Type': 'application/json', 'X-CSRF-Token': {{csrfToken}}},
+                body: JSON.stringify(args || {})
+            });
+            const data = await resp.json();
+            if (!resp.ok) throw new Error(data.error || resp.statusText);
+            return data;
+        }
+        const render = {
+            readiness: async function() {
+                const data = await run('readiness');
+                document.getElementById('readiness').innerHTML =
+                    '<tr><th>' + mark(data.ready) + '</th><th>' + esc(data.checked_at) + '</th><th></th></tr>' +
+                    data.checks.map(c => '<tr><td>' + mark(c.ok) + '</td><td>' + esc(c.name) + '</td><td>' + esc(c.detail) + '</td></tr>').join('');
+            },
+            ping: async function() {
+                const data = await run('ping', {target: document.getElementById('target').value});
+                document.getElementById('ping').innerHTML = data.map(p => '<tr><td>' + mark(p.ok) + '</td><td>' + esc(p.target) +
+                    '<br><small>' + esc(p.url) + '</small></td><td>' + esc((p.addresses || []).join(' ')) + '<br><small>DNS ' +
+                    p.dns_ms.toFixed(0) + ' ms</small></td><td>' + esc(p.status || p.error) + '<br><small>' + p.elapsed_ms.toFixed(0) + ' ms</small></td></tr>').join('');
+            },
+            camera: async function() {
+                const data = await run('camera', {source: document.getElementById('source').value});
+                document.getElementById('camera').innerHTML = '<p>' + mark(!data.error) + ' ' + esc(data.url) + ' ' +
+                    (data.error ? esc(data.error) : data.width + '×' + data.height + ', ' + data.bytes + ' bytes') +
+                    ', ' + data.elapsed_ms.toFixed(0) + ' ms</p>' + (data.frame ? '<img src="' + data.frame + '">' : '');
+            }
+        };
+        document.querySelectorAll('[data-action]').forEach(btn => {
+            btn.addEventListener('click', async function() {
+                const action = this.dataset.action;
+                this.disabled = true;
+                try {
+                    await render[action]();
+                } catch (err) {
+                    document.getElementById(action).innerHTML = '<tr><td class="fail">' + esc(err.message) + '</td></tr>';
+                }
+                this.disabled = false;
+            });
+        });
+        async function logs() {
+            const resp = await fetch({{base}} + '/admin/logs?lines=500&grep=' + encodeURIComponent(document.getElementById('grep').value));
+            const pre = document.getElementById('logs');
+            pre.textContent = await resp.text();
+            pre.scrollTop = pre.scrollHeight;
+        }
+        document.getElementById('maintenanceBtn').addEventListener('click', async function() {
+            try {
+                await run('maintenance', {enabled: this.dataset.enabled, reason: document.getElementById('reason').value});
+                location.reload();
+            } catch (err) {
+                document.getElementById('maintenance').innerHTML = '<span class="fail">' + esc(err.message) + '</span>';
+            }
+        });
+        document.getElementById('logsBtn').addEventListener('click', logs);
+        setInterval(() => { if (document.getElementById('follow').checked) logs(); }, 3000);
+        logs();
+    </script>
+</body>
+</html>`
+	t, err := template.New("admin").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	t.Execute(w, data)
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
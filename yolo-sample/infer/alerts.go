@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ALERT_RULES names a JSON file of rules checked against every new result.
+// Label is a model class or any taxonomy label (see taxonomy.go):
+//
+//	[
+//	  {"name": "dock-vehicles", "label": "vehicle", "source": "dock", "min_count": 3},
+//	  {"name": "person-seen", "label": "person"}
+//	]
+//
+// A rule fires when a result, from Source if set, has at least MinCount
+// (default 1) detections under Label, unless the result's camera source is in
+// quiet hours (see quiethours.go). Recent alerts are listed at /api/v1/alerts.
+// Rules given by the desired state (see desired.go) replace the file's.
+
+// AlertRule is one entry of ALERT_RULES
+type AlertRule struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Source   string `json:"source,omitempty"`
+	MinCount int    `json:"min_count,omitempty"`
+}
+
+// Alert is a rule firing for a result
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Label     string    `json:"label"`
+	Count     int       `json:"count"`
+	ResultID  string    `json:"result_id"`
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// alertHistory is the number of recent alerts kept in memory
+const alertHistory = 200
+
+var alerting = struct {
+	sync.Mutex
+	rules  []AlertRule
+	recent []Alert // oldest first
+}{}
+
+var alertsFired = newCounterVec("yolo_alerts_total",
+	"Alerts fired by rule.", "rule")
+
+// loadAlertRules reads ALERT_RULES; a broken file is logged and ignored
+func loadAlertRules() {
+	if rules, ok := desiredRules(config()); ok {
+		setAlertRules(rules)
+		log.Printf("Loaded %d alert rules from the desired state", len(rules))
+		return
+	}
+	if config().AlertRulesFile == "" {
+		return
+	}
+	rules, err := readAlertRules(config().AlertRulesFile)
+	if err != nil {
+		log.Printf("Warning: ignoring alert rules %s: %v", config().AlertRulesFile, err)
+		return
+	}
+	setAlertRules(rules)
+	log.Printf("Loaded %d alert rules from %s", len(rules), config().AlertRulesFile)
+}
+
+// readAlertRules reads and checks a rules file; MinCount defaults to 1
+func readAlertRules(path string) ([]AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	if err := checkAlertRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// checkAlertRules checks rules, defaulting MinCount to 1
+func checkAlertRules(rules []AlertRule) error {
+	for i := range rules {
+		if rules[i].Name == "" || rules[i].Label == "" {
+			return fmt.Errorf("rule %d has no name or label", i)
+		}
+		if rules[i].MinCount < 1 {
+			rules[i].MinCount = 1
+		}
+	}
+	return nil
+}
+
+func setAlertRules(rules []AlertRule) {
+	alerting.Lock()
+	alerting.rules = rules
+	alerting.Unlock()
+}
+
+// startAlerting evaluates the alert rules against each result.created event
+func startAlerting() {
+	bus.subscribe("alerts", []string{eventResultCreated}, nil, 256).consume(func(ev Event) {
+		evaluateAlerts(ev.Data.(InferenceResult))
+	})
+}
+
+// evaluateAlerts checks a new result against the alert rules, publishing
+// alert.fired for each match
+func evaluateAlerts(r InferenceResult) {
+	if r.Error != "" {
+		return
+	}
+	quiet := !sources.alertsActive(r.Source, time.Now())
+	tax := labels()
+	alerting.Lock()
+	defer alerting.Unlock()
+	for _, rule := range alerting.rules {
+		if rule.Source != "" && rule.Source != r.Source {
+			continue
+		}
+		n := 0
+		for _, d := range r.Detections {
+			if tax.matches(d.ClassName, rule.Label) {
+				n++
+			}
+		}
+		if n < rule.MinCount {
+			continue
+		}
+		if quiet {
+			alertsSuppressed.inc(rule.Name)
+			continue
+		}
+		a := Alert{Rule: rule.Name, Label: rule.Label, Count: n, ResultID: r.ID, Source: r.Source, CreatedAt: time.Now().UTC()}
+		log.Printf("Alert %s: %d %s in result %s", rule.Name, n, rule.Label, r.ID)
+		alertsFired.inc(rule.Name)
+		bus.publish(eventAlertFired, a)
+		alerting.recent = append(alerting.recent, a)
+		if len(alerting.recent) > alertHistory {
+			alerting.recent = alerting.recent[len(alerting.recent)-alertHistory:]
+		}
+	}
+}
+
+// alertsHandler serves GET /api/v1/alerts: the rules and recent alerts, newest first
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	alerting.Lock()
+	defer alerting.Unlock()
+	recent := make([]Alert, 0, len(alerting.recent))
+	for i := len(alerting.recent) - 1; i >= 0; i-- {
+		recent = append(recent, alerting.recent[i])
+	}
+	rules := append([]AlertRule{}, alerting.rules...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": rules, "alerts": recent})
+}
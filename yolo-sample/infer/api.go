@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("JSON encode error: %v", err)
+	}
+}
+
+// writeJSONError writes an {"error": "..."} body, matching the InferenceResult
+// error shape, translated to the request's language
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": translate(responseLanguage(w), msg)})
+}
+
+// decodeJSON reads a JSON request body into v, limited to 1 MB
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return decodeJSONLimit(w, r, v, 1<<20)
+}
+
+// decodeJSONLimit is decodeJSON for bodies of up to limit bytes
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API is versioned by path prefix. Every version is served by the
+// same handlers: requests for /api/v2/... are routed to the /api/v1/...
+// handler with the version recorded in the request context, and handlers
+// that return versioned schemas (currently results; see resultForAPI) pick
+// the serializer for it. Routes that have not changed need no work to appear
+// in a new version.
+//
+// A version can be deprecated and later sunset from config; deprecated
+// versions carry Deprecation, Sunset and successor Link headers, and sunset
+// versions answer 410 Gone.
+
+// apiVersion describes one API version
+type apiVersion struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"` // "current", "supported", "deprecated" or "sunset"
+	Deprecated *time.Time `json:"deprecated_at,omitempty"`
+	Sunset     *time.Time `json:"sunset_at,omitempty"`
+	Successor  string     `json:"successor,omitempty"`
+}
+
+// apiVersionNames lists the versions from oldest to newest; the last is current
+var apiVersionNames = []string{"v1", "v2"}
+
+type apiVersionKey struct{}
+
+// apiVersions returns every version with its lifecycle as configured at t
+func apiVersions(t time.Time) []apiVersion {
+	out := make([]apiVersion, len(apiVersionNames))
+	for i, name := range apiVersionNames {
+		v := apiVersion{Name: name, Status: "supported"}
+		if i == len(apiVersionNames)-1 {
+			v.Status = "current"
+		} else {
+			v.Successor = apiVersionNames[i+1]
+		}
+		if name == "v1" {
+			v.Deprecated, v.Sunset = config().APIV1DeprecatedAt, config().APIV1SunsetAt
+		}
+		switch {
+		case v.Sunset != nil && !t.Before(*v.Sunset):
+			v.Status = "sunset"
+		case v.Deprecated != nil && !t.Before(*v.Deprecated):
+			v.Status = "deprecated"
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// requestAPIVersion returns the API version a request was made against, "v1" by default
+func requestAPIVersion(r *http.Request) string {
+	if v, ok := r.Context().Value(apiVersionKey{}).(string); ok {
+		return v
+	}
+	return "v1"
+}
+
+// withAPIVersion routes /api/{version}/... to the shared handlers and adds
+// lifecycle headers; unversioned paths (including the Frigate-compatible
+// /api/events, see frigate.go) pass through untouched
+func withAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/api/")
+		if !ok || rest == "versions" || rest == "events" || strings.HasPrefix(rest, "events/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, tail, _ := strings.Cut(rest, "/")
+
+		var version *apiVersion
+		for _, v := range apiVersions(time.Now()) {
+			if v.Name == name {
+				v := v
+				version = &v
+			}
+		}
+		if version == nil {
+			writeJSONError(w, http.StatusNotFound, "Unknown API version")
+			return
+		}
+
+		h := w.Header()
+		if version.Deprecated != nil && !time.Now().Before(*version.Deprecated) {
+			h.Set("Deprecation", "@"+strconv.FormatInt(version.Deprecated.Unix(), 10))
+		}
+		if version.Successor != "" && (version.Status == "deprecated" || version.Status == "sunset") {
+			h.Add("Link", `</api/`+version.Successor+"/"+tail+`>; rel="successor-version"`)
+		}
+		if version.Sunset != nil {
+			h.Set("Sunset", version.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if version.Status == "sunset" {
+			writeJSONError(w, http.StatusGone, "This API version has been retired; use /api/"+version.Successor)
+			return
+		}
+		h.Set("API-Version", version.Name)
+
+		if version.Name != "v1" {
+			r = r.Clone(context.WithValue(r.Context(), apiVersionKey{}, version.Name))
+			r.URL.Path = "/api/v1/" + tail
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiVersionsHandler serves GET /api/versions
+func apiVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiVersions(time.Now()))
+}
+
+// ResultV2 is the v2 result schema. Compared with v1 it groups the model,
+// image and class fields, and boxes are given as origin and size.
+type ResultV2 struct {
+	ID         string                 `json:"id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	Source     string                 `json:"source,omitempty"`
+	Model      ModelRefV2             `json:"model"`
+	Image      ImageRefV2             `json:"image"`
+	CapturedAt *time.Time             `json:"captured_at,omitempty"`
+	Location   *GeoPoint              `json:"location,omitempty"`
+	Detections []DetectionV2          `json:"detections"`
+	Barcodes   []BarcodeV2            `json:"barcodes,omitempty"`
+	Feedback   []Feedback             `json:"feedback,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Sequence   uint64                 `json:"sequence,omitempty"`
+	Clock      *ClockRefV2            `json:"clock,omitempty"`
+	Timing     *ResultTiming          `json:"timing,omitempty"`
+}
+
+// ClockRefV2 flags results produced while the node clock was not trusted
+type ClockRefV2 struct {
+	Synchronized bool `json:"synchronized"`
+}
+
+type ModelRefV2 struct {
+	Version string `json:"version,omitempty"`
+	Canary  bool   `json:"canary"`
+}
+
+type ImageRefV2 struct {
+	Name      string            `json:"name"`
+	URL       string            `json:"url,omitempty"`   // unset when no copy was retained
+	Sizes     map[string]string `json:"sizes,omitempty"` // URLs of the downscaled copies by size
+	Redacted  bool              `json:"redacted"`
+	Retention string            `json:"retention,omitempty"`
+	Width     int               `json:"width,omitempty"`
+	Height    int               `json:"height,omitempty"`
+}
+
+type DetectionV2 struct {
+	Class      ClassRefV2 `json:"class"`
+	Confidence float64    `json:"confidence"`
+	Box        BoxV2      `json:"box"`
+}
+
+type ClassRefV2 struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Display  string `json:"display,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+type BarcodeV2 struct {
+	Format string `json:"format"`
+	Text   string `json:"text"`
+	Box    BoxV2  `json:"box"`
+	Class  string `json:"class,omitempty"`
+}
+
+// BoxV2 is a bounding box in pixels as its top-left corner and size
+type BoxV2 struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func resultV2(r InferenceResult, base string) ResultV2 {
+	v := ResultV2{
+		ID:         r.ID,
+		CreatedAt:  r.CreatedAt,
+		Source:     r.Source,
+		Model:      ModelRefV2{Version: r.Model, Canary: r.Canary},
+		Image:      ImageRefV2{Name: r.Image, Redacted: r.Redacted, Retention: r.ImageRetention, Width: r.ImageWidth, Height: r.ImageHeight},
+		CapturedAt: r.CapturedAt,
+		Location:   r.Location,
+		Detections: make([]DetectionV2, len(r.Detections)),
+		Feedback:   r.Feedback,
+		Attributes: r.Attributes,
+		Error:      r.Error,
+		Sequence:   r.Sequence,
+		Timing:     r.Timing,
+	}
+	if r.ClockUnsynchronized {
+		v.Clock = &ClockRefV2{Synchronized: false}
+	}
+	if r.StoredImage != "" {
+		v.Image.URL = imageURL(base, r, "")
+		v.Image.Sizes = map[string]string{}
+		for _, d := range imageDerivatives {
+			v.Image.Sizes[d.name] = imageURL(base, r, d.name)
+		}
+	}
+	for i, d := range r.Detections {
+		v.Detections[i] = DetectionV2{
+			Class:      ClassRefV2{ID: d.ClassID, Name: d.ClassName, Display: d.DisplayName, Category: d.Category},
+			Confidence: d.Confidence,
+			Box:        BoxV2{X: d.BBox.X1, Y: d.BBox.Y1, Width: d.BBox.X2 - d.BBox.X1, Height: d.BBox.Y2 - d.BBox.Y1},
+		}
+	}
+	for _, b := range r.Barcodes {
+		v.Barcodes = append(v.Barcodes, BarcodeV2{Format: b.Format, Text: b.Text, Class: b.Class,
+			Box: BoxV2{X: b.BBox.X1, Y: b.BBox.Y1, Width: b.BBox.X2 - b.BBox.X1, Height: b.BBox.Y2 - b.BBox.Y1}})
+	}
+	return v
+}
+
+// resultForAPI returns res in the schema of the request's API version
+func resultForAPI(r *http.Request, res InferenceResult) interface{} {
+	if requestAPIVersion(r) == "v2" {
+		return resultV2(res, basePath(r))
+	}
+	return res
+}
+
+// resultsForAPI is resultForAPI for a list
+func resultsForAPI(r *http.Request, list []InferenceResult) interface{} {
+	if requestAPIVersion(r) == "v1" {
+		return list
+	}
+	out := make([]interface{}, len(list))
+	for i, res := range list {
+		out[i] = resultForAPI(r, res)
+	}
+	return out
+}
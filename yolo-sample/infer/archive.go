@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The retention sweep deletes results, and their images, after
+// RETENTION_DAYS; their statistics outlive them. Before results go they are
+// folded into per-day, per-source counts in STATE_DIR/stats-archive.json:
+// results and detections by class, with no images, boxes or result IDs.
+// Alerts are counted there by rule as they fire, so they outlast the short
+// in-memory list of /api/v1/alerts and restarts. /api/v1/stats adds the
+// archived days of its window, so ?since=8760h still answers once the
+// results are gone. Archived days are whole local days, kept for
+// STATS_ARCHIVE_DAYS (default 1825).
+
+// archiveDay is the archived activity of one source on one day
+type archiveDay struct {
+	Results    int            `json:"results"`
+	Detections int            `json:"detections"`
+	Classes    map[string]int `json:"classes,omitempty"`
+	Alerts     map[string]int `json:"alerts,omitempty"` // by rule
+}
+
+func (a *archiveDay) merge(o *archiveDay) {
+	a.Results += o.Results
+	a.Detections += o.Detections
+	for class, n := range o.Classes {
+		if a.Classes == nil {
+			a.Classes = map[string]int{}
+		}
+		a.Classes[class] += n
+	}
+	for rule, n := range o.Alerts {
+		if a.Alerts == nil {
+			a.Alerts = map[string]int{}
+		}
+		a.Alerts[rule] += n
+	}
+}
+
+// statsArchive maps day (2006-01-02, local time), then source
+var statsArchive = struct {
+	sync.Mutex
+	days  map[string]map[string]*archiveDay
+	dirty bool
+}{days: map[string]map[string]*archiveDay{}}
+
+func statsArchivePath() string {
+	return filepath.Join(config().StateDir, "stats-archive.json")
+}
+
+// startStatsArchive loads the archive, counts alerts into it and saves it
+// every minute
+func startStatsArchive() {
+	statsArchive.Lock()
+	if data, err := os.ReadFile(statsArchivePath()); err == nil {
+		if err := json.Unmarshal(data, &statsArchive.days); err != nil {
+			log.Printf("Warning: ignoring unreadable %s: %v", statsArchivePath(), err)
+			statsArchive.days = map[string]map[string]*archiveDay{}
+		}
+	}
+	statsArchive.Unlock()
+	bus.subscribe("stats-archive", []string{eventAlertFired}, nil, 256).consume(func(ev Event) {
+		a := ev.Data.(Alert)
+		archive(a.CreatedAt, a.Source, &archiveDay{Alerts: map[string]int{a.Rule: 1}})
+	})
+	go func() {
+		for range time.Tick(time.Minute) {
+			saveStatsArchive()
+		}
+	}()
+}
+
+// archiveResults folds deleted results into the archive
+func archiveResults(res []InferenceResult) {
+	if len(res) == 0 {
+		return
+	}
+	for _, r := range res {
+		day := &archiveDay{Results: 1}
+		for _, d := range r.Detections {
+			if day.Classes == nil {
+				day.Classes = map[string]int{}
+			}
+			day.Detections++
+			day.Classes[d.ClassName]++
+		}
+		archive(r.CreatedAt, r.Source, day)
+	}
+	saveStatsArchive()
+}
+
+func archive(t time.Time, source string, a *archiveDay) {
+	key := t.Local().Format("2006-01-02")
+	statsArchive.Lock()
+	defer statsArchive.Unlock()
+	if statsArchive.days[key] == nil {
+		statsArchive.days[key] = map[string]*archiveDay{}
+	}
+	if statsArchive.days[key][source] == nil {
+		statsArchive.days[key][source] = &archiveDay{}
+	}
+	statsArchive.days[key][source].merge(a)
+	statsArchive.dirty = true
+}
+
+// saveStatsArchive drops days past STATS_ARCHIVE_DAYS and writes the archive
+func saveStatsArchive() {
+	cutoff := time.Now().AddDate(0, 0, -config().StatsArchiveDays).Format("2006-01-02")
+	statsArchive.Lock()
+	defer statsArchive.Unlock()
+	for key := range statsArchive.days {
+		if key < cutoff {
+			delete(statsArchive.days, key)
+			statsArchive.dirty = true
+		}
+	}
+	if !statsArchive.dirty {
+		return
+	}
+	data, err := json.Marshal(statsArchive.days)
+	if err == nil {
+		err = writeFileAtomic(statsArchivePath(), data)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save the stats archive: %v", err)
+		return
+	}
+	statsArchive.dirty = false
+}
+
+// archivedSince sums the archived days from the day of since on, for source
+// or, when it is empty, every source
+func archivedSince(since time.Time, source string) archiveDay {
+	from := since.Local().Format("2006-01-02")
+	var sum archiveDay
+	statsArchive.Lock()
+	defer statsArchive.Unlock()
+	for key, bySource := range statsArchive.days {
+		if key < from {
+			continue
+		}
+		for s, a := range bySource {
+			if source == "" || s == source {
+				sum.merge(a)
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Barcode and QR code decoding, for logistics sites where the labels matter
+// as much as the parcels. BARCODE_DECODE=image reads the codes anywhere in
+// the image; BARCODE_DECODE=boxes reads them only inside the detections of
+// BARCODE_CLASSES (say, the model's "label" or "barcode" class), which is
+// faster and keeps the code tied to the object it was found on. Codes are
+// added to the result's "barcodes" with their format, text and box.
+//
+// QR codes are read by qrdecode.go; this file reads Code 128 (GS1-128 when
+// it starts with FNC1, whose later occurrences become the GS character),
+// Code 39, EAN-13, EAN-8 and UPC-A along scan lines in both directions,
+// horizontally and vertically. A 1D code counts once it reads the same on
+// two lines. The image is thresholded globally first and, if that finds
+// nothing, against the local mean, which copes with uneven lighting.
+
+// Barcode is a code read from a result's image
+type Barcode struct {
+	// Format is "qr_code", "code_128", "gs1_128", "code_39", "ean_13", "ean_8" or "upc_a"
+	Format string `json:"format"`
+	Text   string `json:"text"`
+	BBox   BBox   `json:"bbox"`
+	// Class is that of the detection the code was found in (BARCODE_DECODE=boxes)
+	Class string `json:"class,omitempty"`
+}
+
+var barcodesDecoded = newCounterVec("yolo_barcodes_decoded_total",
+	"Barcodes and QR codes decoded from images, by format.", "format")
+
+// barcodeClasses returns the classes whose boxes are decoded, lowercased
+func barcodeClasses() map[string]bool {
+	classes := map[string]bool{}
+	for _, c := range strings.Split(config().BarcodeClasses, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			classes[c] = true
+		}
+	}
+	return classes
+}
+
+// decodeBarcodes adds the codes in the image at path to result
+func decodeBarcodes(result *InferenceResult, path string) {
+	mode := config().BarcodeDecode
+	if mode == "off" || (mode == "boxes" && len(result.Detections) == 0) {
+		return
+	}
+	img, err := decodeImageFile(path)
+	if err != nil {
+		log.Printf("Warning: cannot decode barcodes of %s: %v", filepath.Base(path), err)
+		return
+	}
+
+	var found []Barcode
+	if mode == "image" {
+		found = scanBarcodes(img, img.Bounds())
+	} else {
+		classes := barcodeClasses()
+		for _, d := range result.Detections {
+			if !classes[strings.ToLower(d.ClassName)] {
+				continue
+			}
+			// A margin around the box keeps the code's quiet zone
+			mx, my := (d.BBox.X2-d.BBox.X1)/10, (d.BBox.Y2-d.BBox.Y1)/10
+			r := image.Rect(int(d.BBox.X1-mx), int(d.BBox.Y1-my), int(math.Ceil(d.BBox.X2+mx)), int(math.Ceil(d.BBox.Y2+my)))
+		codes:
+			for _, code := range scanBarcodes(img, r.Add(img.Bounds().Min)) {
+				code.Class = d.ClassName
+				// Overlapping boxes of one object see the same code
+				for _, f := range found {
+					if f.Format == code.Format && f.Text == code.Text && iou(f.BBox, code.BBox) > 0.5 {
+						continue codes
+					}
+				}
+				found = append(found, code)
+			}
+		}
+	}
+	for _, code := range found {
+		barcodesDecoded.inc(code.Format)
+	}
+	result.Barcodes = append(result.Barcodes, found...)
+}
+
+// scanBarcodes reads the codes in region r of img, with boxes in the
+// coordinates of img relative to its origin
+func scanBarcodes(img image.Image, r image.Rectangle) []Barcode {
+	r = r.Intersect(img.Bounds())
+	g := grayOf(img, r)
+	if g.w < 16 || g.h < 16 {
+		return nil
+	}
+	var found []Barcode
+	for _, threshold := range []func() *bitMatrix{g.globalThreshold, g.localThreshold} {
+		b := threshold()
+		if b == nil {
+			continue
+		}
+		found = append(scanQR(b), scanLinear(b)...)
+		if len(found) > 0 {
+			break
+		}
+	}
+	dx := float64(r.Min.X - img.Bounds().Min.X)
+	dy := float64(r.Min.Y - img.Bounds().Min.Y)
+	for i := range found {
+		b := &found[i].BBox
+		b.X1, b.X2, b.Y1, b.Y2 = b.X1+dx, b.X2+dx, b.Y1+dy, b.Y2+dy
+	}
+	return found
+}
+
+// scanQR reads the QR codes in b
+func scanQR(b *bitMatrix) []Barcode {
+	var found []Barcode
+	used := map[[2]float64]bool{}
+	for _, f := range qrTriples(findQRFinders(b)) {
+		if used[[2]float64{f[0].x, f[0].y}] || used[[2]float64{f[1].x, f[1].y}] || used[[2]float64{f[2].x, f[2].y}] {
+			continue
+		}
+		text, corners, err := readQR(b, f)
+		if err != nil {
+			continue
+		}
+		for _, p := range f {
+			used[[2]float64{p.x, p.y}] = true
+		}
+		box := BBox{X1: corners[0].x, Y1: corners[0].y, X2: corners[0].x, Y2: corners[0].y}
+		for _, c := range corners[1:] {
+			box.X1, box.Y1 = math.Min(box.X1, c.x), math.Min(box.Y1, c.y)
+			box.X2, box.Y2 = math.Max(box.X2, c.x), math.Max(box.Y2, c.y)
+		}
+		found = append(found, Barcode{Format: "qr_code", Text: text, BBox: clampBox(box, b.w, b.h)})
+	}
+	return found
+}
+
+func clampBox(b BBox, w, h int) BBox {
+	b.X1, b.X2 = math.Max(0, b.X1), math.Min(float64(w), b.X2)
+	b.Y1, b.Y2 = math.Max(0, b.Y1), math.Min(float64(h), b.Y2)
+	return b
+}
+
+// grayImage is the luminance of an image region
+type grayImage struct {
+	w, h int
+	pix  []byte
+}
+
+// grayOf returns the luminance of region r, which must lie within img
+func grayOf(img image.Image, r image.Rectangle) *grayImage {
+	g := &grayImage{w: r.Dx(), h: r.Dy(), pix: make([]byte, r.Dx()*r.Dy())}
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < g.h; y++ {
+			off := src.YOffset(r.Min.X, r.Min.Y+y)
+			copy(g.pix[y*g.w:(y+1)*g.w], src.Y[off:off+g.w])
+		}
+	case *image.Gray:
+		for y := 0; y < g.h; y++ {
+			off := src.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(g.pix[y*g.w:(y+1)*g.w], src.Pix[off:off+g.w])
+		}
+	default:
+		for y := 0; y < g.h; y++ {
+			for x := 0; x < g.w; x++ {
+				g.pix[y*g.w+x] = color.GrayModel.Convert(img.At(r.Min.X+x, r.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+	return g
+}
+
+// bitMatrix is a thresholded image; dark pixels are true
+type bitMatrix struct {
+	w, h int
+	dark []bool
+}
+
+func (b *bitMatrix) at(x, y int) bool { return b.dark[y*b.w+x] }
+
+// globalThreshold splits the pixels at Otsu's threshold, or returns nil for
+// an image without the contrast of a printed code
+func (g *grayImage) globalThreshold() *bitMatrix {
+	var hist [256]int
+	lo, hi := 255, 0
+	for _, p := range g.pix {
+		hist[p]++
+		lo, hi = minInt(lo, int(p)), maxInt(hi, int(p))
+	}
+	if hi-lo < 32 {
+		return nil
+	}
+	total, sum := len(g.pix), 0
+	for v, n := range hist {
+		sum += v * n
+	}
+	best, threshold := -1.0, 0
+	below, belowSum := 0, 0
+	for t := 0; t < 256; t++ {
+		below += hist[t]
+		belowSum += t * hist[t]
+		if below == 0 || below == total {
+			continue
+		}
+		m1 := float64(belowSum) / float64(below)
+		m2 := float64(sum-belowSum) / float64(total-below)
+		if v := float64(below) * float64(total-below) * (m1 - m2) * (m1 - m2); v > best {
+			best, threshold = v, t
+		}
+	}
+	b := &bitMatrix{w: g.w, h: g.h, dark: make([]bool, len(g.pix))}
+	for i, p := range g.pix {
+		b.dark[i] = int(p) <= threshold
+	}
+	return b
+}
+
+// localThreshold marks the pixels noticeably darker than their neighbourhood
+func (g *grayImage) localThreshold() *bitMatrix {
+	w, h := g.w, g.h
+	integral := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0
+		for x := 0; x < w; x++ {
+			row += int(g.pix[y*w+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+	half := maxInt(minInt(w, h)/16, 8)
+	b := &bitMatrix{w: w, h: h, dark: make([]bool, len(g.pix))}
+	for y := 0; y < h; y++ {
+		y0, y1 := maxInt(y-half, 0), minInt(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := maxInt(x-half, 0), minInt(x+half+1, w)
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			count := (x1 - x0) * (y1 - y0)
+			b.dark[y*w+x] = int(g.pix[y*w+x])*count*100 < sum*85
+		}
+	}
+	return b
+}
+
+// lineRuns returns the lengths of the alternating light and dark runs of a
+// line of n pixels, starting with a light run (empty if the line starts
+// dark), and the position each run starts at
+func lineRuns(n int, dark func(i int) bool) (runs, starts []int) {
+	runs, starts = []int{0}, []int{0}
+	for i := 0; i < n; i++ {
+		if dark(i) != (len(runs)%2 == 0) {
+			runs, starts = append(runs, 0), append(starts, i)
+		}
+		runs[len(runs)-1]++
+	}
+	return runs, starts
+}
+
+// linearHit is a 1D code read on one scan line, spanning [from, to) along it
+type linearHit struct {
+	format, text string
+	from, to     int
+}
+
+// scanLinear reads the 1D codes in b
+func scanLinear(b *bitMatrix) []Barcode {
+	type key struct{ format, text string }
+	boxes := map[key]*BBox{}
+	lines := map[key]int{}
+	var order []key
+	add := func(h linearHit, box BBox) {
+		k := key{h.format, h.text}
+		if bb, ok := boxes[k]; ok {
+			bb.X1, bb.Y1 = math.Min(bb.X1, box.X1), math.Min(bb.Y1, box.Y1)
+			bb.X2, bb.Y2 = math.Max(bb.X2, box.X2), math.Max(bb.Y2, box.Y2)
+		} else {
+			boxes[k] = &box
+			order = append(order, k)
+		}
+		lines[k]++
+	}
+
+	for y, step := 0, maxInt(b.h/120, 1); y < b.h; y += step {
+		for _, h := range scanLine(b.w, func(x int) bool { return b.at(x, y) }) {
+			add(h, BBox{X1: float64(h.from), Y1: float64(y), X2: float64(h.to), Y2: float64(y + 1)})
+		}
+	}
+	for x, step := 0, maxInt(b.w/120, 1); x < b.w; x += step {
+		for _, h := range scanLine(b.h, func(y int) bool { return b.at(x, y) }) {
+			add(h, BBox{X1: float64(x), Y1: float64(h.from), X2: float64(x + 1), Y2: float64(h.to)})
+		}
+	}
+
+	var found []Barcode
+	for _, k := range order {
+		if lines[k] >= 2 {
+			found = append(found, Barcode{Format: k.format, Text: k.text, BBox: *boxes[k]})
+		}
+	}
+	return found
+}
+
+// scanLine reads the 1D codes along a line of n pixels, in both directions
+func scanLine(n int, dark func(i int) bool) []linearHit {
+	var hits []linearHit
+	for _, reversed := range []bool{false, true} {
+		at := dark
+		if reversed {
+			at = func(i int) bool { return dark(n - 1 - i) }
+		}
+		runs, starts := lineRuns(n, at)
+		for i := 1; i < len(runs); i += 2 {
+			format, text, end, ok := decodeLinear(runs, i)
+			if !ok {
+				continue
+			}
+			h := linearHit{format, text, starts[i], starts[end-1] + runs[end-1]}
+			if reversed {
+				h.from, h.to = n-h.to, n-h.from
+			}
+			hits = append(hits, h)
+			i = end - 1
+		}
+	}
+	return hits
+}
+
+// decodeLinear reads a 1D code whose first bar is runs[i], returning the
+// index of the run after its last bar
+func decodeLinear(runs []int, i int) (format, text string, end int, ok bool) {
+	if text, end, ok = decodeEAN(runs, i, 6); ok {
+		if text[0] == '0' {
+			return "upc_a", text[1:], end, true
+		}
+		return "ean_13", text, end, true
+	}
+	if text, end, ok = decodeEAN(runs, i, 4); ok {
+		return "ean_8", text, end, true
+	}
+	if format, text, end, ok = decodeCode128(runs, i); ok {
+		return format, text, end, true
+	}
+	if text, end, ok = decodeCode39(runs, i); ok {
+		return "code_39", text, end, true
+	}
+	return "", "", 0, false
+}
+
+// runsMatch compares run widths with a pattern of module widths
+func runsMatch(runs, pattern []int) (float64, bool) {
+	total, modules := 0, 0
+	for i, p := range pattern {
+		total += runs[i]
+		modules += p
+	}
+	unit := float64(total) / float64(modules)
+	var sum, worst float64
+	for i, p := range pattern {
+		d := math.Abs(float64(runs[i])/unit - float64(p))
+		sum += d
+		worst = math.Max(worst, d)
+	}
+	mean := sum / float64(len(pattern))
+	return mean, mean < 0.48 && worst < 0.7
+}
+
+func sumRuns(runs []int) int {
+	n := 0
+	for _, r := range runs {
+		n += r
+	}
+	return n
+}
+
+// quietZone reports whether the light run at index i is at least modules
+// wide, or is the line's margin
+func quietZone(runs []int, i int, unit, modules float64) bool {
+	return i <= 0 || i >= len(runs)-1 || float64(runs[i]) >= unit*modules
+}
+
+// EAN digit patterns as space, bar, space, bar widths. The left half uses
+// these (L) or their reverse (G), the right half the same widths starting
+// with a bar; the L/G parity of the left half encodes EAN-13's first digit.
+var eanPatterns = [10][4]int{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+var eanParity = [10]int{0x00, 0x0B, 0x0D, 0x0E, 0x13, 0x19, 0x1C, 0x15, 0x16, 0x1A}
+
+// eanDigit matches four runs with the digit patterns, reversed ones too if allowG
+func eanDigit(runs []int, allowG bool) (digit int, g bool, ok bool) {
+	best := math.Inf(1)
+	digit = -1
+	for d, p := range eanPatterns {
+		if v, match := runsMatch(runs, p[:]); match && v < best {
+			best, digit, g = v, d, false
+		}
+		if allowG {
+			if v, match := runsMatch(runs, []int{p[3], p[2], p[1], p[0]}); match && v < best {
+				best, digit, g = v, d, true
+			}
+		}
+	}
+	return digit, g, digit >= 0
+}
+
+// decodeEAN reads an EAN-13 (half = 6) or EAN-8 (half = 4) code starting at runs[i]
+func decodeEAN(runs []int, i, half int) (string, int, bool) {
+	n := 3 + 4*half + 5 + 4*half + 3
+	if i+n > len(runs) {
+		return "", 0, false
+	}
+	r := runs[i : i+n]
+	mid := 3 + 4*half
+	unit := float64(sumRuns(r)) / float64(3+7*half+5+7*half+3)
+	if !quietZone(runs, i-1, unit, 3) || !quietZone(runs, i+n, unit, 3) {
+		return "", 0, false
+	}
+	guard := []int{1, 1, 1, 1, 1}
+	if _, ok := runsMatch(r[:3], guard[:3]); !ok {
+		return "", 0, false
+	}
+	if _, ok := runsMatch(r[mid:mid+5], guard); !ok {
+		return "", 0, false
+	}
+	if _, ok := runsMatch(r[n-3:], guard[:3]); !ok {
+		return "", 0, false
+	}
+
+	var digits []byte
+	parity := 0
+	for k := 0; k < half; k++ {
+		d, g, ok := eanDigit(r[3+4*k:7+4*k], half == 6)
+		if !ok {
+			return "", 0, false
+		}
+		parity <<= 1
+		if g {
+			parity |= 1
+		}
+		digits = append(digits, byte('0'+d))
+	}
+	for k := 0; k < half; k++ {
+		d, _, ok := eanDigit(r[mid+5+4*k:mid+9+4*k], false)
+		if !ok {
+			return "", 0, false
+		}
+		digits = append(digits, byte('0'+d))
+	}
+	if half == 6 {
+		first := -1
+		for d, p := range eanParity {
+			if p == parity {
+				first = d
+			}
+		}
+		if first < 0 {
+			return "", 0, false
+		}
+		digits = append([]byte{byte('0' + first)}, digits...)
+	}
+
+	// The check digit makes the digits weighted 3, 1, 3, ... from the right sum to a multiple of 10
+	sum := 0
+	for k := len(digits) - 1; k >= 0; k-- {
+		w := 1
+		if (len(digits)-1-k)%2 == 1 {
+			w = 3
+		}
+		sum += w * int(digits[k]-'0')
+	}
+	if sum%10 != 0 {
+		return "", 0, false
+	}
+	return string(digits), i + n, true
+}
+
+// code128Patterns are the bar and space widths of the Code 128 symbols;
+// 103-105 are the start codes and 106 the stop code, whose final 2-module
+// bar is checked separately
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "233111",
+}
+
+// code128Symbol returns the symbol six runs match best, or -1
+func code128Symbol(runs []int, from, to int) int {
+	best, symbol := math.Inf(1), -1
+	var p [6]int
+	for s := from; s <= to; s++ {
+		for k := range p {
+			p[k] = int(code128Patterns[s][k] - '0')
+		}
+		if v, ok := runsMatch(runs, p[:]); ok && v < best {
+			best, symbol = v, s
+		}
+	}
+	return symbol
+}
+
+// decodeCode128 reads a Code 128 code starting at runs[i]
+func decodeCode128(runs []int, i int) (format, text string, end int, ok bool) {
+	if i+6 > len(runs) {
+		return
+	}
+	start := code128Symbol(runs[i:i+6], 103, 105)
+	if start < 0 || !quietZone(runs, i-1, float64(sumRuns(runs[i:i+6]))/11, 5) {
+		return
+	}
+	codes := []int{start}
+	for k := i + 6; ; k += 6 {
+		if k+7 > len(runs) || len(codes) > 80 {
+			return
+		}
+		c := code128Symbol(runs[k:k+6], 0, 106)
+		if c < 0 || (c >= 103 && c <= 105) {
+			return
+		}
+		if c < 106 {
+			codes = append(codes, c)
+			continue
+		}
+		unit := float64(sumRuns(runs[k:k+6])) / 11
+		if math.Abs(float64(runs[k+6])/unit-2) > 0.7 || !quietZone(runs, k+7, unit, 5) {
+			return
+		}
+		end = k + 7
+		break
+	}
+
+	// The last symbol checks the start code plus each symbol weighted by its position
+	if len(codes) < 3 {
+		return
+	}
+	sum := codes[0]
+	for k := 1; k < len(codes)-1; k++ {
+		sum += k * codes[k]
+	}
+	if sum%103 != codes[len(codes)-1] {
+		return
+	}
+	text, gs1, ok := code128Text(codes[0], codes[1:len(codes)-1])
+	if !ok {
+		return
+	}
+	format = "code_128"
+	if gs1 {
+		format = "gs1_128"
+	}
+	return format, text, end, true
+}
+
+// code128Text decodes the data symbols of a code started in code set A
+// (103), B (104) or C (105)
+func code128Text(start int, codes []int) (string, bool, bool) {
+	set := 'A' + rune(start-103)
+	var out []byte
+	gs1, shift := false, false
+	for k, c := range codes {
+		cur := set
+		if shift {
+			cur, shift = 'A'+'B'-set, false
+		}
+		if c == 102 { // FNC1
+			if k == 0 {
+				gs1 = true
+			} else {
+				out = append(out, 0x1D)
+			}
+			continue
+		}
+		switch cur {
+		case 'C':
+			switch {
+			case c < 100:
+				out = append(out, byte('0'+c/10), byte('0'+c%10))
+			case c == 100:
+				set = 'B'
+			case c == 101:
+				set = 'A'
+			}
+		case 'A', 'B':
+			switch {
+			case c < 64 || (cur == 'B' && c < 96):
+				out = append(out, byte(c+32))
+			case c < 96:
+				out = append(out, byte(c-64))
+			case c == 98:
+				shift = set != 'C'
+			case c == 99:
+				set = 'C'
+			case c == 100 && cur == 'A':
+				set = 'B'
+			case c == 101 && cur == 'B':
+				set = 'A'
+			}
+			// FNC2, FNC3 and FNC4 carry no text here
+		}
+	}
+	return string(out), gs1, len(out) > 0
+}
+
+const code39Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%"
+
+// code39Patterns mark the wide elements of each character of code39Alphabet,
+// first element in the highest of nine bits; code39Star is the start and
+// stop character
+var code39Patterns = [43]int{
+	0x034, 0x121, 0x061, 0x160, 0x031, 0x130, 0x070, 0x025, 0x124, 0x064,
+	0x109, 0x049, 0x148, 0x019, 0x118, 0x058, 0x00D, 0x10C, 0x04C, 0x01C,
+	0x103, 0x043, 0x142, 0x013, 0x112, 0x052, 0x007, 0x106, 0x046, 0x016,
+	0x181, 0x0C1, 0x1C0, 0x091, 0x190, 0x0D0, 0x085, 0x184, 0x0C4, 0x0A8,
+	0x0A2, 0x08A, 0x02A,
+}
+
+const code39Star = 0x094
+
+// code39Bits classifies nine runs as narrow or wide; exactly three are wide
+func code39Bits(runs []int) (bits int, narrow float64, ok bool) {
+	sorted := append([]int(nil), runs[:9]...)
+	sort.Ints(sorted)
+	if float64(sorted[6]) < 1.5*float64(sorted[5]) {
+		return 0, 0, false
+	}
+	for _, r := range runs[:9] {
+		bits <<= 1
+		if r >= sorted[6] {
+			bits |= 1
+		}
+	}
+	return bits, float64(sumRuns(sorted[:6])) / 6, true
+}
+
+// decodeCode39 reads a Code 39 code starting at runs[i]
+func decodeCode39(runs []int, i int) (string, int, bool) {
+	if i+9 > len(runs) {
+		return "", 0, false
+	}
+	bits, narrow, ok := code39Bits(runs[i : i+9])
+	if !ok || bits != code39Star || !quietZone(runs, i-1, narrow, 5) {
+		return "", 0, false
+	}
+	var text []byte
+	for k := i + 10; ; k += 10 {
+		// Characters are separated by a narrow gap
+		if k+9 > len(runs) || float64(runs[k-1]) > 3*narrow || len(text) > 80 {
+			return "", 0, false
+		}
+		if bits, narrow, ok = code39Bits(runs[k : k+9]); !ok {
+			return "", 0, false
+		}
+		if bits == code39Star {
+			if len(text) == 0 || !quietZone(runs, k+9, narrow, 5) {
+				return "", 0, false
+			}
+			return string(text), k + 9, true
+		}
+		c := -1
+		for j, p := range code39Patterns {
+			if p == bits {
+				c = j
+			}
+		}
+		if c < 0 {
+			return "", 0, false
+		}
+		text = append(text, code39Alphabet[c])
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// POST /api/v1/infer/batch takes many images in one multipart/form-data
+// request and answers with NDJSON: a line per image, in request order, as
+// soon as it is done, then a summary line:
+//
+//	{"type": "result", "index": 0, "filename": "a.jpg", "result_url": "/results/...", "result": {...}}
+//	{"type": "error", "index": 1, "filename": "notes.txt", "error": "content is not an image ..."}
+//	{"type": "summary", "images": 2, "succeeded": 1, "failed": 1, "detections": 3, "duration_ms": 850}
+//
+// Every file part is an image of at most INGEST_MAX_MB, up to
+// INFER_BATCH_MAX_IMAGES (default 1000) per request. The body is read as it
+// streams in, so overrides of the inference settings come as query fields
+// (model, threshold, classes, ...; see options.go). Each image is inferred as
+// soon as it has arrived, so over a slow link the first results come back
+// while later images are still uploading; a client that disconnects stops
+// the batch after the current image. A body that breaks off, or goes past
+// the image limit, ends the stream with the summary's error set. Results
+// are stored with source "batch".
+
+// BatchLine is the NDJSON line of one image of a batch
+type BatchLine struct {
+	Type      string           `json:"type"` // "result" or "error"
+	Index     int              `json:"index"`
+	Filename  string           `json:"filename"`
+	ResultURL string           `json:"result_url,omitempty"`
+	Result    *InferenceResult `json:"result,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// BatchSummary is the last NDJSON line of a batch
+type BatchSummary struct {
+	Type       string `json:"type"` // "summary"
+	Images     int    `json:"images"`
+	Succeeded  int    `json:"succeeded"`
+	Failed     int    `json:"failed"`
+	Detections int    `json:"detections"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// batchImage is a received image waiting for inference, or the reason it was rejected
+type batchImage struct {
+	index      int
+	name       string
+	id, path   string
+	err        error
+	receivedAt time.Time
+	uploadMS   float64
+}
+
+var batchImages = newCounterVec("yolo_batch_images_total",
+	"Images received on /api/v1/infer/batch by outcome.", "status")
+
+// inferBatchHandler serves POST /api/v1/infer/batch
+func inferBatchHandler(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	opts, err := optionsFrom(r.URL.Query().Get)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+
+	// HTTP/1 needs full duplex to answer while the body is still arriving;
+	// without it every image is read before the first is inferred
+	rc := http.NewResponseController(w)
+	duplex := r.ProtoMajor >= 2 || rc.EnableFullDuplex() == nil
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	emit := func(line interface{}) {
+		enc.Encode(line)
+		rc.Flush()
+	}
+
+	summary := BatchSummary{Type: "summary"}
+	run := func(img batchImage) {
+		summary.Images++
+		if img.err != nil {
+			summary.Failed++
+			batchImages.inc("rejected")
+			emit(BatchLine{Type: "error", Index: img.index, Filename: img.name, Error: img.err.Error()})
+			return
+		}
+		timing := ResultTiming{UploadMS: img.uploadMS, QueueWaitMS: millis(time.Since(img.receivedAt))}
+		result := processImageAs(img.id, img.path, "batch", true, opts, timing)
+		os.RemoveAll(filepath.Dir(img.path))
+		if result.Error != "" {
+			summary.Failed++
+			batchImages.inc("failed")
+		} else {
+			summary.Succeeded++
+			summary.Detections += len(result.Detections)
+			batchImages.inc("success")
+		}
+		emit(BatchLine{Type: "result", Index: img.index, Filename: img.name, ResultURL: basePath(r) + "/results/" + result.ID, Result: &result})
+	}
+
+	var pending []batchImage
+	var readErr error
+	for index := 0; ; {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		if index >= config().InferBatchMaxImages {
+			part.Close()
+			readErr = fmt.Errorf("at most %d images per batch", config().InferBatchMaxImages)
+			break
+		}
+		partStart := time.Now()
+		img := batchImage{index: index, name: part.FileName()}
+		index++
+		data, err := io.ReadAll(io.LimitReader(part, config().IngestMaxBytes+1))
+		part.Close()
+		if err != nil {
+			readErr = err
+			break
+		}
+		img.receivedAt = time.Now()
+		img.uploadMS = millis(img.receivedAt.Sub(partStart))
+		if int64(len(data)) > config().IngestMaxBytes {
+			img.err = fmt.Errorf("image is larger than %d MB", config().IngestMaxBytes>>20)
+		} else {
+			img.id, img.path, img.err = saveIngested(img.name, data)
+		}
+		if !duplex {
+			pending = append(pending, img)
+			continue
+		}
+		if r.Context().Err() != nil {
+			discardBatch([]batchImage{img})
+			return
+		}
+		run(img)
+	}
+	for i, img := range pending {
+		if r.Context().Err() != nil {
+			discardBatch(pending[i:])
+			return
+		}
+		run(img)
+	}
+	if readErr != nil {
+		summary.Error = readErr.Error()
+	}
+	summary.DurationMS = time.Since(started).Milliseconds()
+	emit(summary)
+}
+
+// discardBatch removes images received for a batch that will not be inferred
+func discardBatch(imgs []batchImage) {
+	for _, img := range imgs {
+		if img.path != "" {
+			os.RemoveAll(filepath.Dir(img.path))
+		}
+	}
+}
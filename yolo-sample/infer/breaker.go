@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The inference backend (the Python process started per image) is guarded by
+// a circuit breaker. After BREAKER_FAILURES consecutive backend failures (a
+// crash or unparseable output, not a per-image error reported by the model)
+// the breaker opens and inference fails fast with "Inference backend down"
+// instead of queueing work behind a broken backend. While open, a probe
+// image is run every BREAKER_COOLDOWN; one success closes the breaker again.
+//
+// When FALLBACK_INFERENCE_URL is set, requests that the local backend cannot
+// serve are sent there instead: the image is POSTed as the request body and
+// the response must be the same JSON that infer.py prints. The minimum
+// confidence is sent in X-Confidence-Threshold, and a request's imgsz,
+// iou_threshold and max_detections in X-Image-Size, X-IoU-Threshold and
+// X-Max-Detections.
+
+// Breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open" // a probe is running
+)
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+}
+
+var backendBreaker = &circuitBreaker{state: breakerClosed}
+
+var (
+	breakerState = newGaugeVec("yolo_inference_backend_up",
+		"1 while the inference backend circuit breaker is closed.")
+	breakerTrips = newCounterVec("yolo_inference_breaker_trips_total",
+		"Times the inference backend circuit breaker opened.")
+	fallbackInferences = newCounterVec("yolo_fallback_inferences_total",
+		"Inferences sent to FALLBACK_INFERENCE_URL by outcome.", "status")
+)
+
+var fallbackClient = &http.Client{Timeout: 60 * time.Second}
+
+// allow reports whether a request may use the local backend
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerClosed
+}
+
+// record notes the outcome of a local backend call
+func (b *circuitBreaker) record(failed bool, errMsg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.state != breakerClosed {
+			log.Printf("Inference backend recovered")
+			bus.publish(eventBackendStatus, BackendEvent{Status: "up"})
+		}
+		b.state, b.failures = breakerClosed, 0
+		breakerState.set(1)
+		return
+	}
+	b.failures++
+	b.lastError = errMsg
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= config().BreakerFailures) {
+		if b.state == breakerClosed {
+			log.Printf("Warning: inference backend down after %d consecutive failures: %s", b.failures, errMsg)
+			breakerTrips.inc()
+			bus.publish(eventBackendStatus, BackendEvent{Status: "down", Error: errMsg})
+		}
+		b.state, b.openedAt = breakerOpen, time.Now()
+		breakerState.set(0)
+	}
+}
+
+// status returns "up", "down" or "recovering" for the status bar
+func (b *circuitBreaker) status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return "down"
+	case breakerHalfOpen:
+		return "recovering"
+	}
+	return "up"
+}
+
+// downError is the result error while the breaker is open
+func (b *circuitBreaker) downError() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return "Inference backend down: " + b.lastError
+}
+
+// startProbes periodically runs a probe image through the backend while the breaker is open
+func (b *circuitBreaker) startProbes() {
+	breakerState.set(1)
+	go func() {
+		for range time.Tick(time.Second) {
+			b.mu.Lock()
+			due := b.state == breakerOpen && time.Since(b.openedAt) >= config().BreakerCooldown
+			if due {
+				b.state = breakerHalfOpen
+			}
+			b.mu.Unlock()
+			if !due {
+				continue
+			}
+			path, err := probeImage()
+			if err != nil {
+				log.Printf("Warning: cannot create backend probe image: %v", err)
+				b.record(true, err.Error())
+				continue
+			}
+			result, failed := runLocalInference(path, activeModelVersion(), backendParams{Threshold: config().InferenceThreshold})
+			b.record(failed, result.Error)
+		}
+	}()
+}
+
+// probeImage returns the path of a small blank JPEG for backend probes
+func probeImage() (string, error) {
+	path := filepath.Join(config().StateDir, "backend-probe.jpg")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA(x, y, color.RGBA{128, 128, 128, 255})
+		}
+	}
+	if err := os.MkdirAll(config().StateDir, 0755); err != nil {
+		return "", err
+	}
+	return path, writeJPEG(path, img)
+}
+
+// runInference runs the model on an image through the circuit breaker,
+// falling back to FALLBACK_INFERENCE_URL when the local backend is unavailable.
+// The result is validated (see validate.go) and its classes mapped (see classmap.go).
+func runInference(imagePath, version string) InferenceResult {
+	return runInferenceThreshold(imagePath, version, config().InferenceThreshold)
+}
+
+// runInferenceThreshold is runInference with a minimum detection confidence
+// other than INFERENCE_THRESHOLD
+func runInferenceThreshold(imagePath, version string, threshold float64) InferenceResult {
+	return runInferenceParams(imagePath, version, backendParams{Threshold: threshold})
+}
+
+// runInferenceParams is runInference with the backend settings of a request
+func runInferenceParams(imagePath, version string, params backendParams) InferenceResult {
+	result := checkResult(inferThroughBreaker(imagePath, version, params), imagePath)
+	applyClassMap(&result)
+	return result
+}
+
+func inferThroughBreaker(imagePath, version string, params backendParams) InferenceResult {
+	if !backendBreaker.allow() {
+		if config().FallbackInferenceURL != "" {
+			return runFallbackInference(imagePath, params)
+		}
+		return InferenceResult{Error: backendBreaker.downError()}
+	}
+	result, failed := runLocalInference(imagePath, version, params)
+	backendBreaker.record(failed, result.Error)
+	if failed && config().FallbackInferenceURL != "" {
+		return runFallbackInference(imagePath, params)
+	}
+	return result
+}
+
+// runFallbackInference posts the image to the secondary backend
+func runFallbackInference(imagePath string, params backendParams) InferenceResult {
+	result, err := postFallbackInference(imagePath, params)
+	if err != nil {
+		fallbackInferences.inc("error")
+		return InferenceResult{Error: "Fallback inference failed: " + err.Error()}
+	}
+	fallbackInferences.inc("success")
+	if result.Attributes == nil {
+		result.Attributes = map[string]interface{}{}
+	}
+	result.Attributes["backend"] = "fallback"
+	return result
+}
+
+func postFallbackInference(imagePath string, params backendParams) (InferenceResult, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, config().FallbackInferenceURL, bytes.NewReader(data))
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(data))
+	req.Header.Set("X-Image-Name", filepath.Base(imagePath))
+	req.Header.Set("X-Confidence-Threshold", strconv.FormatFloat(params.Threshold, 'g', -1, 64))
+	if params.ImgSz > 0 {
+		req.Header.Set("X-Image-Size", strconv.Itoa(params.ImgSz))
+	}
+	if params.IoUThreshold > 0 {
+		req.Header.Set("X-IoU-Threshold", strconv.FormatFloat(params.IoUThreshold, 'g', -1, 64))
+	}
+	if params.MaxDetections > 0 {
+		req.Header.Set("X-Max-Detections", strconv.Itoa(params.MaxDetections))
+	}
+	resp, err := fallbackClient.Do(req)
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return InferenceResult{}, fmt.Errorf("%s returned %s", config().FallbackInferenceURL, resp.Status)
+	}
+	result, err := parseReply(body)
+	if err == errNotResult {
+		return InferenceResult{}, fmt.Errorf("response is not a result")
+	}
+	return result, err
+}
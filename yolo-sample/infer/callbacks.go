@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A training pipeline tells the node a new model is ready with a callback,
+// signed with TRAINING_CALLBACK_SECRET the way webhook deliveries are (see
+// signing.go); unsigned, stale or replayed callbacks are refused with 401:
+//
+//	POST /api/v1/callbacks/training  {"model": "v4", "ref": "ghcr.io/acme/models/yolo:v4", "job": "train-118"}
+//	GET  /api/v1/callbacks/training  recent rollouts, newest first
+//
+// The node answers 202 and rolls the model out in the background, publishing
+// training.rollout as it goes:
+//
+//  1. The model is pulled from ref (else from its MODEL_OCI_REFS entry) as
+//     version "model"; with neither, its file must already be on the node.
+//  2. With TRAINING_EVAL_DIR set, it is evaluated on that dataset along with
+//     the active model, and rejected below TRAINING_MIN_MAP50 or more than
+//     TRAINING_MAX_MAP50_DROP below the active model.
+//  3. TRAINING_PROMOTION=canary (the default) starts a canary of it at
+//     TRAINING_CANARY_PERCENT of traffic, to be promoted as usual; "direct"
+//     makes it the active model; "off" leaves it for an operator.
+//
+// A callback with "status": "failed" is recorded without a rollout.
+
+// maxRollouts bounds the rollouts kept for the API
+const maxRollouts = 20
+
+// TrainingCallback is the body of a training callback
+type TrainingCallback struct {
+	Model  string `json:"model"`
+	Ref    string `json:"ref,omitempty"`
+	Job    string `json:"job,omitempty"`
+	Status string `json:"status,omitempty"` // "succeeded" (default) or "failed"
+}
+
+// TrainingRollout is the progress of the model of one callback; it is also
+// the payload of training.rollout
+type TrainingRollout struct {
+	ID          string     `json:"id"`
+	Model       string     `json:"model"`
+	Ref         string     `json:"ref,omitempty"`
+	Job         string     `json:"job,omitempty"`
+	State       string     `json:"state"` // "pulling", "evaluating", "promoting", then "completed", "rejected" or "failed"
+	Promotion   string     `json:"promotion"`
+	ModelMAP50  *float64   `json:"model_map50,omitempty"`
+	ActiveModel string     `json:"active_model,omitempty"`
+	ActiveMAP50 *float64   `json:"active_map50,omitempty"`
+	Detail      string     `json:"detail,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+var rollouts = struct {
+	sync.Mutex
+	list []*TrainingRollout // oldest first
+}{}
+
+var trainingCallbacks = newCounterVec("yolo_training_callbacks_total",
+	"Training callbacks by rollout outcome.", "outcome")
+
+// setRolloutState moves a rollout on and publishes it
+func setRolloutState(ro *TrainingRollout, state, detail string) {
+	rollouts.Lock()
+	ro.State, ro.Detail = state, detail
+	if state == "completed" || state == "rejected" || state == "failed" {
+		now := time.Now().UTC()
+		ro.FinishedAt = &now
+	}
+	ev := *ro
+	rollouts.Unlock()
+	bus.publish(eventTrainingRollout, ev)
+	if ev.FinishedAt != nil {
+		trainingCallbacks.inc(state)
+		log.Printf("Rollout of model %s from training callback %s: %s", ro.Model, ro.ID, strings.TrimSpace(state+" "+detail))
+	}
+}
+
+// runRollout pulls, evaluates and promotes the model of a callback
+func runRollout(ro *TrainingRollout) {
+	cfg := config()
+	ref := ro.Ref
+	if ref == "" {
+		if refs, err := parseModelRefs(cfg.ModelOCIRefs); err == nil && refs[ro.Model].registry != "" {
+			ref = refs[ro.Model].String()
+		}
+	}
+	if ref != "" {
+		parsed, err := parseOCIRef(ref)
+		if err == nil {
+			err = pullModel(ro.Model, parsed)
+		}
+		if err != nil {
+			modelPulls.inc("error")
+			setRolloutState(ro, "failed", "pull: "+err.Error())
+			return
+		}
+	}
+	if !modelExists(ro.Model) {
+		setRolloutState(ro, "failed", fmt.Sprintf("model %q is not on the node and has no ref to pull it from", ro.Model))
+		return
+	}
+	if err := checkModelSignature(ro.Model, "rollout"); err != nil {
+		setRolloutState(ro, "rejected", err.Error())
+		return
+	}
+
+	if cfg.TrainingEvalDir != "" {
+		setRolloutState(ro, "evaluating", "")
+		active := activeModelVersion()
+		candidate, err := evaluateModel(cfg.TrainingEvalDir, ro.Model)
+		if err != nil {
+			setRolloutState(ro, "failed", "evaluation: "+err.Error())
+			return
+		}
+		rollouts.Lock()
+		ro.ModelMAP50 = &candidate
+		rollouts.Unlock()
+		if candidate < cfg.TrainingMinMAP50 {
+			setRolloutState(ro, "rejected", fmt.Sprintf("mAP50 %.3f is below TRAINING_MIN_MAP50 %.3f", candidate, cfg.TrainingMinMAP50))
+			return
+		}
+		if active != ro.Model {
+			baseline, err := evaluateModel(cfg.TrainingEvalDir, active)
+			if err != nil {
+				setRolloutState(ro, "failed", "evaluation of the active model: "+err.Error())
+				return
+			}
+			rollouts.Lock()
+			ro.ActiveModel, ro.ActiveMAP50 = active, &baseline
+			rollouts.Unlock()
+			if candidate < baseline-cfg.TrainingMaxMAP50Drop {
+				setRolloutState(ro, "rejected", fmt.Sprintf("mAP50 %.3f is more than %.3f below %.3f of the active model %s",
+					candidate, cfg.TrainingMaxMAP50Drop, baseline, active))
+				return
+			}
+		}
+	}
+
+	setRolloutState(ro, "promoting", "")
+	switch ro.Promotion {
+	case "canary":
+		if err := canary.start(ro.Model, cfg.TrainingCanaryPercent); err != nil {
+			setRolloutState(ro, "failed", "canary: "+err.Error())
+			return
+		}
+		setRolloutState(ro, "completed", fmt.Sprintf("canary started at %g%% of traffic", cfg.TrainingCanaryPercent))
+	case "direct":
+		if err := setActiveModelVersion(ro.Model); err != nil {
+			setRolloutState(ro, "failed", "promotion: "+err.Error())
+			return
+		}
+		setRolloutState(ro, "completed", "promoted to the active model")
+	default:
+		setRolloutState(ro, "completed", "left for an operator to promote")
+	}
+}
+
+// evaluateModel evaluates model on dir and returns its mAP50
+func evaluateModel(dir, model string) (float64, error) {
+	rep, err := newEvaluation(dir, model, 0)
+	if err != nil {
+		return 0, err
+	}
+	runEvaluation(rep)
+	evaluations.Lock()
+	defer evaluations.Unlock()
+	if rep.Status != "completed" {
+		return 0, fmt.Errorf("%s (evaluation %s)", rep.Error, rep.ID)
+	}
+	return rep.MAP50, nil
+}
+
+// trainingCallbackHandler serves /api/v1/callbacks/training
+func trainingCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	secret := config().TrainingCallbackSecret
+	if secret == "" {
+		writeJSONError(w, http.StatusNotFound, "Training callbacks are not enabled; set TRAINING_CALLBACK_SECRET")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rollouts.Lock()
+		list := make([]TrainingRollout, 0, len(rollouts.list))
+		for i := len(rollouts.list) - 1; i >= 0; i-- {
+			list = append(list, *rollouts.list[i])
+		}
+		rollouts.Unlock()
+		writeJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		body, err := verifySignedRequest(w, r, secret)
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, "Callback refused: "+err.Error())
+			return
+		}
+		var cb TrainingCallback
+		if err := json.Unmarshal(body, &cb); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if cb.Model == "" || strings.ContainsAny(cb.Model, `/\`) {
+			writeJSONError(w, http.StatusBadRequest, "model must be a model version name")
+			return
+		}
+		if cb.Ref != "" {
+			if _, err := parseOCIRef(cb.Ref); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		ro := &TrainingRollout{
+			ID: newID(), Model: cb.Model, Ref: cb.Ref, Job: cb.Job,
+			State: "pulling", Promotion: config().TrainingPromotion, CreatedAt: time.Now().UTC(),
+		}
+		rollouts.Lock()
+		for _, other := range rollouts.list {
+			if other.Model == cb.Model && other.FinishedAt == nil {
+				rollouts.Unlock()
+				writeJSONError(w, http.StatusConflict, fmt.Sprintf("A rollout of model %s is already running", cb.Model))
+				return
+			}
+		}
+		rollouts.list = append(rollouts.list, ro)
+		if len(rollouts.list) > maxRollouts {
+			rollouts.list = rollouts.list[len(rollouts.list)-maxRollouts:]
+		}
+		rollouts.Unlock()
+
+		if cb.Status == "failed" {
+			setRolloutState(ro, "failed", "training job reported failure")
+		} else {
+			bus.publish(eventTrainingRollout, *ro)
+			go runRollout(ro)
+		}
+		rollouts.Lock()
+		resp := *ro
+		rollouts.Unlock()
+		writeJSON(w, http.StatusAccepted, resp)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
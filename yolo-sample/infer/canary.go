@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds how many recent latencies are kept per version for percentiles
+const maxLatencySamples = 500
+
+// versionStats tracks the traffic observed by one model version during a canary
+type versionStats struct {
+	Requests  int
+	Errors    int
+	latencies []float64 // recent latencies in ms, oldest first
+}
+
+func (s *versionStats) record(latency time.Duration, failed bool) {
+	s.Requests++
+	if failed {
+		s.Errors++
+	}
+	s.latencies = append(s.latencies, float64(latency.Milliseconds()))
+	if len(s.latencies) > maxLatencySamples {
+		s.latencies = s.latencies[len(s.latencies)-maxLatencySamples:]
+	}
+}
+
+func (s *versionStats) errorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// percentile returns the p-th percentile (0-100) of the recent latencies
+func (s *versionStats) percentile(p float64) float64 {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), s.latencies...)
+	sort.Float64s(sorted)
+	idx := int(p / 100 * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+// VersionStatsView is the API representation of versionStats
+type VersionStatsView struct {
+	Version      string  `json:"version"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyP50Ms float64 `json:"latency_p50_ms"`
+	LatencyP95Ms float64 `json:"latency_p95_ms"`
+}
+
+// CanaryStatus is returned by the canary API
+type CanaryStatus struct {
+	State          string            `json:"state"` // "idle", "running", "promoted", "aborted"
+	Candidate      string            `json:"candidate,omitempty"`
+	Percent        float64           `json:"percent"`
+	StartedAt      *time.Time        `json:"started_at,omitempty"`
+	EndedAt        *time.Time        `json:"ended_at,omitempty"`
+	Baseline       *VersionStatsView `json:"baseline,omitempty"`
+	CandidateStats *VersionStatsView `json:"candidate_stats,omitempty"`
+	Recommendation string            `json:"recommendation,omitempty"` // "wait", "promote", "abort"
+	Reason         string            `json:"reason,omitempty"`
+}
+
+type canaryController struct {
+	mu        sync.Mutex
+	state     string
+	candidate string
+	baseline  string
+	percent   float64
+	startedAt time.Time
+	endedAt   time.Time
+	stats     map[string]*versionStats
+}
+
+var canary = &canaryController{state: "idle"}
+
+// pickModel chooses the model version for the next inference request
+func (c *canaryController) pickModel() (version string, isCanary bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == "running" && rand.Float64()*100 < c.percent {
+		return c.candidate, true
+	}
+	return activeModelVersion(), false
+}
+
+// record feeds the outcome of an inference request into the canary stats
+func (c *canaryController) record(version string, latency time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != "running" {
+		return
+	}
+	s, ok := c.stats[version]
+	if !ok {
+		return
+	}
+	s.record(latency, failed)
+}
+
+func (c *canaryController) start(candidate string, percent float64) error {
+	if !modelExists(candidate) {
+		return fmt.Errorf("model %q not found in %s", candidate, config().ModelDir)
+	}
+	if err := checkModelSignature(candidate, "canary"); err != nil {
+		return err
+	}
+	if err := checkGoldenGate(candidate); err != nil {
+		return err
+	}
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("percent must be in (0, 100], got %g", percent)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == "running" {
+		return fmt.Errorf("canary for %q is already running; promote or abort it first", c.candidate)
+	}
+	baseline := activeModelVersion()
+	if candidate == baseline {
+		return fmt.Errorf("model %q is already the active model", candidate)
+	}
+
+	c.state = "running"
+	c.candidate = candidate
+	c.baseline = baseline
+	c.percent = percent
+	c.startedAt = time.Now()
+	c.endedAt = time.Time{}
+	c.stats = map[string]*versionStats{
+		baseline:  {},
+		candidate: {},
+	}
+	return nil
+}
+
+func (c *canaryController) setPercent(percent float64) error {
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("percent must be in (0, 100], got %g", percent)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != "running" {
+		return fmt.Errorf("no canary is running")
+	}
+	c.percent = percent
+	return nil
+}
+
+// finish ends the running canary, promoting the candidate to the active model if promote is set
+func (c *canaryController) finish(promote, force bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != "running" {
+		return fmt.Errorf("no canary is running")
+	}
+	if promote {
+		if rec, reason := c.recommendLocked(); rec != "promote" && !force {
+			return fmt.Errorf("canary not ready for promotion (%s: %s); pass force=true to override", rec, reason)
+		}
+		if err := setActiveModelVersion(c.candidate); err != nil {
+			return err
+		}
+		c.state = "promoted"
+	} else {
+		c.state = "aborted"
+	}
+	c.endedAt = time.Now()
+	return nil
+}
+
+// recommendLocked compares candidate against baseline using the configured guard rails
+func (c *canaryController) recommendLocked() (string, string) {
+	base, cand := c.stats[c.baseline], c.stats[c.candidate]
+	if cand.Requests < config().CanaryMinSamples {
+		return "wait", fmt.Sprintf("candidate has %d of %d required samples", cand.Requests, config().CanaryMinSamples)
+	}
+	if cand.errorRate() > config().CanaryMaxErrorRate && cand.errorRate() > base.errorRate() {
+		return "abort", fmt.Sprintf("candidate error rate %.3f exceeds limit %.3f", cand.errorRate(), config().CanaryMaxErrorRate)
+	}
+	if bp95 := base.percentile(95); bp95 > 0 && cand.percentile(95) > bp95*config().CanaryMaxLatencyRatio {
+		return "abort", fmt.Sprintf("candidate p95 latency %.0fms exceeds %.1fx baseline (%.0fms)", cand.percentile(95), config().CanaryMaxLatencyRatio, bp95)
+	}
+	return "promote", "candidate is within error and latency limits"
+}
+
+func (c *canaryController) status() CanaryStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := CanaryStatus{State: c.state, Candidate: c.candidate, Percent: c.percent}
+	if c.state == "idle" {
+		return st
+	}
+	started := c.startedAt
+	st.StartedAt = &started
+	if !c.endedAt.IsZero() {
+		ended := c.endedAt
+		st.EndedAt = &ended
+	}
+	if s := c.stats[c.baseline]; s != nil {
+		st.Baseline = statsView(c.baseline, s)
+	}
+	if s := c.stats[c.candidate]; s != nil {
+		st.CandidateStats = statsView(c.candidate, s)
+	}
+	if c.state == "running" {
+		st.Recommendation, st.Reason = c.recommendLocked()
+	}
+	return st
+}
+
+func statsView(version string, s *versionStats) *VersionStatsView {
+	return &VersionStatsView{
+		Version:      version,
+		Requests:     s.Requests,
+		Errors:       s.Errors,
+		ErrorRate:    s.errorRate(),
+		LatencyP50Ms: s.percentile(50),
+		LatencyP95Ms: s.percentile(95),
+	}
+}
+
+// canaryHandler serves /api/v1/canary and its promote/abort actions
+//
+//	GET  /api/v1/canary           current canary status and per-version metrics
+//	POST /api/v1/canary           start a canary: {"candidate": "v2", "percent": 10}
+//	PUT  /api/v1/canary           adjust the traffic split: {"percent": 25}
+//	POST /api/v1/canary/promote   promote the candidate (?force=true skips the guard rails)
+//	POST /api/v1/canary/abort     stop routing traffic to the candidate
+func canaryHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/canary"), "/")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, canary.status())
+
+	case action == "" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+		var req struct {
+			Candidate string  `json:"candidate"`
+			Percent   float64 `json:"percent"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		var err error
+		if r.Method == http.MethodPost {
+			err = canary.start(req.Candidate, req.Percent)
+		} else {
+			err = canary.setPercent(req.Percent)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, canary.status())
+
+	case (action == "promote" || action == "abort") && r.Method == http.MethodPost:
+		force := r.URL.Query().Get("force") == "true"
+		if err := canary.finish(action == "promote", force); err != nil {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, canary.status())
+
+	case action == "" || action == "promote" || action == "abort":
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Capture backends turn a camera source into a stream of JPEG frames:
+//
+//	http(s)://  polled at the source's FPS; each request must return one JPEG snapshot
+//	rtsp(s)://  decoded by ffmpeg (FFMPEG_PATH), which resamples to the source's FPS
+//	v4l2://     a local video device, also through ffmpeg (see v4l2.go)
+
+// maxFrameSize bounds a single captured frame
+const maxFrameSize = 20 << 20
+
+// frameSink receives each captured JPEG frame
+type frameSink func(frame []byte)
+
+var snapshotClient = &http.Client{Timeout: 10 * time.Second}
+
+// captureFrames reads frames from src until ctx is cancelled or the stream fails
+func captureFrames(ctx context.Context, src CameraSource, sink frameSink) error {
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return pollSnapshots(ctx, src, sink)
+	case "rtsp", "rtsps":
+		return ffmpegFrames(ctx, []string{"-rtsp_transport", "tcp", "-i", src.URL}, src.FPS, sink)
+	case "v4l2":
+		return v4l2Frames(ctx, u, src.FPS, sink)
+	default:
+		return fmt.Errorf("unsupported source URL scheme %q", u.Scheme)
+	}
+}
+
+// frameInterval is the time between frames at fps, kept within the FPS a
+// source may have (throttling may take it lower)
+func frameInterval(fps float64) time.Duration {
+	if !(fps >= minSourceFPS) {
+		fps = minSourceFPS
+	} else if fps > maxSourceFPS {
+		fps = maxSourceFPS
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// pollSnapshots fetches a still image from src.URL once per frame interval
+func pollSnapshots(ctx context.Context, src CameraSource, sink frameSink) error {
+	ticker := time.NewTicker(frameInterval(src.FPS))
+	defer ticker.Stop()
+	for {
+		frame, err := fetchSnapshot(ctx, src.URL)
+		if err != nil {
+			return err
+		}
+		sink(frame)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetchSnapshot(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := snapshotClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("snapshot request returned %s", resp.Status)
+	}
+	frame, err := io.ReadAll(io.LimitReader(resp.Body, maxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) > maxFrameSize {
+		return nil, fmt.Errorf("snapshot exceeds %d bytes", maxFrameSize)
+	}
+	if !bytes.HasPrefix(frame, []byte{0xFF, 0xD8}) {
+		return nil, fmt.Errorf("snapshot is not a JPEG image")
+	}
+	return frame, nil
+}
+
+// ffmpegFrames runs ffmpeg on the given input and splits its MJPEG output into frames
+func ffmpegFrames(ctx context.Context, input []string, fps float64, sink frameSink) error {
+	args := append([]string{"-nostdin", "-loglevel", "error"}, input...)
+	args = append(args, "-vf", fmt.Sprintf("fps=%g", fps), "-f", "image2pipe", "-c:v", "mjpeg", "-q:v", "3", "-")
+	cmd := exec.CommandContext(ctx, config().FFmpegPath, args...)
+	stderr := &limitedBuffer{limit: 4096}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	readErr := readJPEGStream(bufio.NewReader(stdout), sink)
+	waitErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if waitErr != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	return fmt.Errorf("ffmpeg: stream ended")
+}
+
+// readJPEGStream splits concatenated JPEG images on their SOI/EOI markers.
+// Entropy-coded data escapes 0xFF bytes, so EOI only appears at the end of a frame.
+func readJPEGStream(r *bufio.Reader, sink frameSink) error {
+	var frame []byte
+	var prev byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case frame == nil:
+			if prev == 0xFF && b == 0xD8 {
+				frame = []byte{0xFF, 0xD8}
+			}
+		case len(frame) >= maxFrameSize:
+			frame = nil // runaway frame; resynchronise on the next SOI
+		default:
+			frame = append(frame, b)
+			if prev == 0xFF && b == 0xD9 {
+				sink(frame)
+				frame = nil
+				b = 0
+			}
+		}
+		prev = b
+	}
+}
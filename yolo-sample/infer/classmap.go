@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// CLASS_MAP names a JSON file that maps model classes to what a deployment
+// wants to call them, e.g. for a retail site:
+//
+//	{
+//	  "tvmonitor": {"display": "Screen", "category": "electronics", "color": "#ff9800"},
+//	  "person":    {"display": "Shopper", "category": "people"}
+//	}
+//
+// The display name and category are added to each detection after inference
+// (class_name stays the model's class, which feedback, privacy and metrics
+// use). Colors are used for the boxes drawn over result images and in the
+// detection list; classes without one get a stable color from a palette.
+
+// ClassMapping is the CLASS_MAP entry for one model class
+type ClassMapping struct {
+	Display  string `json:"display,omitempty"`
+	Category string `json:"category,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// classMap holds the map[string]ClassMapping in effect
+var classMap atomic.Value
+
+// classPalette colors classes that have no color in the class map
+var classPalette = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4",
+	"#46f0f0", "#f032e6", "#bcf60c", "#008080", "#9a6324",
+}
+
+// loadClassMap reads CLASS_MAP; a broken file is logged and ignored
+func loadClassMap() {
+	if config().ClassMapFile == "" {
+		return
+	}
+	m, err := readClassMap(config().ClassMapFile)
+	if err != nil {
+		log.Printf("Warning: ignoring class map %s: %v", config().ClassMapFile, err)
+		return
+	}
+	classMap.Store(m)
+	log.Printf("Loaded class map with %d classes from %s", len(m), config().ClassMapFile)
+}
+
+func readClassMap(path string) (map[string]ClassMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]ClassMapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for class, cm := range m {
+		if cm.Color != "" {
+			if _, err := parseHexColor(cm.Color); err != nil {
+				return nil, fmt.Errorf("class %q: invalid color %q", class, cm.Color)
+			}
+		}
+	}
+	return m, nil
+}
+
+// classMapping returns the class map entry of a model class
+func classMapping(class string) ClassMapping {
+	m, _ := classMap.Load().(map[string]ClassMapping)
+	return m[class]
+}
+
+// applyClassMap sets the display name and category of each detection
+func applyClassMap(result *InferenceResult) {
+	for i := range result.Detections {
+		d := &result.Detections[i]
+		cm := classMapping(d.ClassName)
+		d.DisplayName, d.Category = cm.Display, cm.Category
+	}
+}
+
+// classColor returns the annotation color of a model class
+func classColor(class string) string {
+	if c := classMapping(class).Color; c != "" {
+		return c
+	}
+	h := fnv.New32a()
+	h.Write([]byte(class))
+	return classPalette[h.Sum32()%uint32(len(classPalette))]
+}
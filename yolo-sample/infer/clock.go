@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Edge nodes boot without a battery-backed clock or lose NTP for days, so
+// wall-clock timestamps cannot be taken at face value. Every result and bus
+// event carries a node-wide sequence number that only grows, across restarts
+// too (STATE_DIR/sequence reserves blocks of sequenceBlock), so consumers can
+// order what a node produced whatever its clock said:
+//
+//	result   "sequence": 1042, "clock_unsynchronized": true
+//	event    CloudEvents sequence extension attribute, "sequence": "1043"
+//
+// Every CLOCK_CHECK_INTERVAL (default 1m) the node reads the kernel's NTP
+// state (adjtimex: unsynchronized flag and estimated error) and, with
+// CLOCK_CHECK_URL set, compares its clock with the Date header of that URL.
+// The clock counts as unsynchronized when the kernel says so or the skew
+// exceeds CLOCK_MAX_SKEW (default 5s); results produced meanwhile are flagged.
+// Changes publish clock.status and the state is reported at /api/v1/system.
+
+// sequenceBlock is how many sequence numbers are reserved per state write
+const sequenceBlock = 1000
+
+// Kernel timex status bits and clock state
+const (
+	staUnsync = 0x0040
+	timeError = 5
+)
+
+// ClockStatus is the node's view of its clock, the payload of clock.status
+type ClockStatus struct {
+	Synchronized bool      `json:"synchronized"`
+	Kernel       string    `json:"kernel"`                 // "synchronized", "unsynchronized" or "unknown"
+	EstErrorMS   float64   `json:"est_error_ms,omitempty"` // kernel estimated error
+	SkewSeconds  *float64  `json:"skew_seconds,omitempty"` // local minus CLOCK_CHECK_URL time
+	Reason       string    `json:"reason,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+var clock = struct {
+	sync.Mutex
+	status  ClockStatus
+	checked bool
+}{status: ClockStatus{Synchronized: true, Kernel: "unknown"}}
+
+var sequence = struct {
+	sync.Mutex
+	loaded   bool
+	next     uint64
+	reserved uint64 // first number not covered by STATE_DIR/sequence
+}{}
+
+var (
+	clockSynchronized = newGaugeVec("yolo_clock_synchronized",
+		"1 while the node clock is considered synchronized.")
+	clockSkew = newGaugeVec("yolo_clock_skew_seconds",
+		"Local clock minus CLOCK_CHECK_URL time.")
+)
+
+func sequencePath() string {
+	return filepath.Join(config().StateDir, "sequence")
+}
+
+// nextSequence returns the next node-wide sequence number
+func nextSequence() uint64 {
+	sequence.Lock()
+	defer sequence.Unlock()
+	if !sequence.loaded {
+		sequence.loaded = true
+		if data, err := os.ReadFile(sequencePath()); err == nil {
+			sequence.next, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		}
+		if sequence.next == 0 {
+			sequence.next = 1
+		}
+		sequence.reserved = sequence.next
+	}
+	if sequence.next >= sequence.reserved {
+		// The numbers of a block cut short by a restart are skipped, never reused
+		sequence.reserved = sequence.next + sequenceBlock
+		if err := writeFileAtomic(sequencePath(), []byte(strconv.FormatUint(sequence.reserved, 10)+"\n")); err != nil {
+			log.Printf("Warning: failed to save sequence: %v", err)
+		}
+	}
+	n := sequence.next
+	sequence.next++
+	return n
+}
+
+// clockSynced reports whether the clock is currently trusted
+func clockSynced() bool {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.status.Synchronized
+}
+
+func clockView() ClockStatus {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.status
+}
+
+// startClockCheck checks the clock now and then every CLOCK_CHECK_INTERVAL
+func startClockCheck() {
+	go func() {
+		for {
+			checkClock()
+			interval := config().ClockCheckInterval
+			if interval <= 0 {
+				interval = time.Minute
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func checkClock() {
+	cfg := config()
+	st := ClockStatus{Synchronized: true, Kernel: "unknown", CheckedAt: time.Now().UTC()}
+
+	var tx syscall.Timex
+	if state, err := syscall.Adjtimex(&tx); err == nil {
+		st.EstErrorMS = float64(tx.Esterror) / 1000
+		if tx.Status&staUnsync != 0 || state == timeError {
+			st.Kernel = "unsynchronized"
+			st.Synchronized = false
+			st.Reason = "kernel clock is not synchronized by NTP"
+		} else {
+			st.Kernel = "synchronized"
+		}
+	}
+
+	if cfg.ClockCheckURL != "" {
+		if skew, err := referenceSkew(cfg.ClockCheckURL); err != nil {
+			log.Printf("Warning: clock check against %s failed: %v", cfg.ClockCheckURL, err)
+		} else {
+			st.SkewSeconds = &skew
+			clockSkew.set(skew)
+			if math.Abs(skew) > cfg.ClockMaxSkew.Seconds() {
+				st.Synchronized = false
+				st.Reason = "clock is " + strconv.FormatFloat(skew, 'f', 1, 64) + "s off " + cfg.ClockCheckURL
+			}
+		}
+	}
+
+	clock.Lock()
+	first := !clock.checked
+	changed := first || clock.status.Synchronized != st.Synchronized
+	clock.status, clock.checked = st, true
+	clock.Unlock()
+	if st.Synchronized {
+		clockSynchronized.set(1)
+	} else {
+		clockSynchronized.set(0)
+	}
+	if changed {
+		if !st.Synchronized {
+			log.Printf("Warning: %s; new results are flagged as unsynchronized", st.Reason)
+		} else if !first {
+			log.Printf("Clock is synchronized again")
+		}
+		bus.publish(eventClockStatus, st)
+	}
+}
+
+// referenceSkew compares the local clock with the Date header of url, taking
+// the middle of the request as the moment the header was written
+func referenceSkew(url string) (float64, error) {
+	start := time.Now()
+	resp, err := webhookClient.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	end := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, err
+	}
+	local := start.Add(end.Sub(start) / 2)
+	// Date has a resolution of one second; count from the middle of it
+	return local.Sub(date.Add(500 * time.Millisecond)).Seconds(), nil
+}
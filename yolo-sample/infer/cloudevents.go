@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events leave the node (EVENT_WEBHOOK_URL, /api/v1/events) as CloudEvents 1.0
+// in the structured JSON format, so event platforms such as Knative or Event
+// Grid can route them without an adapter:
+//
+//	{
+//	  "specversion": "1.0",
+//	  "id": "9f2c4e1a7b3d5c60",
+//	  "source": "/yolo/nodes/edge-dock-1/sources/dock",
+//	  "type": "yolo.v1.alert.fired",
+//	  "subject": "dock-vehicles",
+//	  "time": "2026-10-14T18:32:51.52Z",
+//	  "datacontenttype": "application/json",
+//	  "data": {"rule": "dock-vehicles", "label": "vehicle", "count": 3, ...}
+//	}
+//
+// type is CLOUDEVENTS_TYPE_PREFIX followed by the bus event type (see
+// events.go). source is CLOUDEVENTS_SOURCE, by default /yolo/nodes/<NODE_NAME>,
+// extended with the camera source for events about one. subject names what the
+// event is about: the result, alert rule, job or camera source.
+
+// cloudEventsContentType is the media type of a structured-mode CloudEvent
+const cloudEventsContentType = "application/cloudevents+json"
+
+// CloudEvent is a bus event in the CloudEvents 1.0 JSON format
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Sequence        string      `json:"sequence"` // sequence extension; see clock.go
+	Data            interface{} `json:"data"`
+}
+
+// eventSubject is implemented by event payloads that concern one thing;
+// source is the camera source they came from, if any
+type eventSubject interface {
+	eventSubject() (source, subject string)
+}
+
+func (r InferenceResult) eventSubject() (string, string) { return r.Source, r.ID }
+func (a Alert) eventSubject() (string, string)           { return a.Source, a.Rule }
+func (e JobEvent) eventSubject() (string, string)        { return "", e.JobID }
+func (e SourceEvent) eventSubject() (string, string)     { return e.Source, e.Source }
+
+// cloudEventSource returns the CloudEvents source of this node
+func cloudEventSource() string {
+	if s := config().CloudEventsSource; s != "" {
+		return s
+	}
+	return "/yolo/nodes/" + url.PathEscape(getEnv("NODE_NAME", "unknown"))
+}
+
+// toCloudEvent wraps a bus event for delivery off the node
+func toCloudEvent(ev Event) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              ev.ID,
+		Source:          cloudEventSource(),
+		Type:            config().CloudEventsTypePrefix + ev.Type,
+		Time:            ev.Time,
+		DataContentType: "application/json",
+		Sequence:        strconv.FormatUint(ev.Sequence, 10),
+		Data:            ev.Data,
+	}
+	if s, ok := ev.Data.(eventSubject); ok {
+		source, subject := s.eventSubject()
+		if source != "" {
+			ce.Source = strings.TrimSuffix(ce.Source, "/") + "/sources/" + url.PathEscape(source)
+		}
+		ce.Subject = subject
+	}
+	return ce
+}
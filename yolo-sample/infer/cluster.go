@@ -0,0 +1,555 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Nodes of a busy site can share the inference of uploads. With QUEUE_URL
+// naming a Redis server they all reach, the upload jobs a replica receives
+// go on a shared queue, and the UPLOAD_WORKERS of every replica take jobs
+// from it, whichever replica received them:
+//
+//	QUEUE_URL=redis://:secret@10.0.4.5:6379/0
+//	REPLICA_URL=http://10.0.4.11:6767   how the other replicas reach this one
+//
+// A job's image travels through Redis with it. The replica that runs a job
+// stores its result and images and owns them from then on; the others look
+// the owner up and forward requests for /results/{id}, /images/{id} and
+// /api/v1/results/{id} to it, so a load balancer may send any request to any
+// replica. Results are also indexed in Redis, which gives every replica the
+// same history at /api/v1/results, and job stages are shared, so
+// /events/jobs/{id} follows a job from any replica.
+//
+// Replicas announce themselves every few seconds. The jobs of a replica that
+// stops announcing go back on the queue, and a replica that restarts puts
+// its own back at once; either way the interrupted run counts against
+// JOB_MAX_ATTEMPTS, as in jobstore.go. Queued jobs live in Redis rather than
+// STATE_DIR/jobs, so they survive what the Redis server survives.
+//
+//	GET /api/v1/cluster  the replicas, the jobs each is running and the queue depth
+
+// Redis keys of the shared queue
+const (
+	clusterQueueKey    = "yolo:queue"      // list of queued job IDs
+	clusterHistoryKey  = "yolo:history"    // sorted set of result IDs by creation time
+	clusterResultsKey  = "yolo:results"    // result ID -> result JSON
+	clusterOwnersKey   = "yolo:owners"     // result ID -> replica
+	clusterReplicasKey = "yolo:replicas"   // replica -> URL
+	clusterStages      = "yolo:job-stages" // channel of job.stage events
+	clusterForwarded   = "X-Yolo-Replica"  // marks requests forwarded by a replica
+)
+
+const (
+	clusterHeartbeat  = 5 * time.Second
+	clusterReplicaTTL = 20 * time.Second
+	// clusterTakeWait is how long a worker blocks waiting for a job
+	clusterTakeWait = 5 * time.Second
+)
+
+func clusterJobKey(id string) string          { return "yolo:job:" + id }
+func clusterProcessingKey(name string) string { return "yolo:processing:" + name }
+func clusterAliveKey(name string) string      { return "yolo:alive:" + name }
+
+// ReplicaView describes a replica for /api/v1/cluster
+type ReplicaView struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Alive   bool   `json:"alive"`
+	Running int64  `json:"running"` // jobs taken and not finished
+	Self    bool   `json:"self,omitempty"`
+}
+
+// ClusterView is returned by /api/v1/cluster
+type ClusterView struct {
+	Replica  string        `json:"replica"`
+	Queued   int64         `json:"queued"`
+	Replicas []ReplicaView `json:"replicas"`
+}
+
+// clusterStage is a job.stage event shared between replicas
+type clusterStage struct {
+	Replica string   `json:"replica"`
+	Event   JobEvent `json:"event"`
+}
+
+type clusterState struct {
+	redis *redisClient
+	name  string
+	url   string
+
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy // by replica URL
+	down    bool                              // Redis unreachable, logged once
+}
+
+// cluster is nil unless QUEUE_URL is set
+var cluster *clusterState
+
+var clusterJobs = newCounterVec("yolo_cluster_jobs_total",
+	"Shared queue jobs by outcome: taken, requeued or forwarded requests.", "outcome")
+
+func clusterOn() bool {
+	return cluster != nil
+}
+
+// replicaName is REPLICA_NAME, else NODE_NAME, else the hostname
+func replicaName(cfg *Config) string {
+	if cfg.ReplicaName != "" {
+		return cfg.ReplicaName
+	}
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// startCluster joins the shared queue of QUEUE_URL; it must run before the
+// job queue starts
+func startCluster() {
+	cfg := config()
+	if cfg.QueueURL == "" {
+		return
+	}
+	client, err := newRedisClient(cfg.QueueURL)
+	if err != nil {
+		log.Fatalf("QUEUE_URL: %v", err)
+	}
+	cluster = &clusterState{redis: client, name: replicaName(cfg), url: cfg.ReplicaURL, proxies: map[string]*httputil.ReverseProxy{}}
+	// Jobs this replica was running when it stopped start over
+	if n := cluster.requeue(cluster.name); n > 0 {
+		log.Printf("Requeued %d jobs interrupted by the restart", n)
+	}
+	log.Printf("Sharing the upload queue as replica %s", cluster.name)
+	go cluster.announce()
+	go cluster.followStages()
+}
+
+// reachable logs the first failure to reach Redis and the recovery
+func (c *clusterState) reachable(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil && !c.down {
+		log.Printf("Warning: shared queue unreachable: %v", err)
+	} else if err == nil && c.down {
+		log.Printf("Shared queue reachable again")
+	}
+	c.down = err != nil
+}
+
+// announce keeps the replica registered and requeues the jobs of replicas
+// that stopped announcing
+func (c *clusterState) announce() {
+	for {
+		_, err := c.redis.do("SET", clusterAliveKey(c.name), c.url, "PX", clusterReplicaTTL.Milliseconds())
+		if err == nil {
+			_, err = c.redis.do("HSET", clusterReplicasKey, c.name, c.url)
+		}
+		c.reachable(err)
+		if err == nil {
+			if depth, err := redisInt(c.redis.do("LLEN", clusterQueueKey)); err == nil {
+				uploadQueueDepth.set(float64(depth))
+			}
+			replicas, _ := redisMap(c.redis.do("HGETALL", clusterReplicasKey))
+			for name := range replicas {
+				if name == c.name {
+					continue
+				}
+				if alive, err := redisInt(c.redis.do("EXISTS", clusterAliveKey(name))); err == nil && alive == 0 {
+					if n := c.requeue(name); n > 0 {
+						log.Printf("Replica %s stopped; requeued %d of its jobs", name, n)
+					}
+				}
+			}
+		}
+		time.Sleep(clusterHeartbeat)
+	}
+}
+
+// requeue puts the jobs a replica was running back on the queue
+func (c *clusterState) requeue(name string) int {
+	n := 0
+	for {
+		id, err := redisString(c.redis.do("LMOVE", clusterProcessingKey(name), clusterQueueKey, "RIGHT", "RIGHT"))
+		if err != nil {
+			return n
+		}
+		c.redis.do("HSET", clusterJobKey(id), "stage", jobQueued)
+		c.publishStage(JobEvent{JobID: id, Stage: jobQueued})
+		clusterJobs.inc("requeued")
+		n++
+	}
+}
+
+// enqueue puts a job on the shared queue with its image, which it then removes
+func (c *clusterState) enqueue(j *uploadJob) error {
+	if depth, err := redisInt(c.redis.do("LLEN", clusterQueueKey)); err != nil {
+		return fmt.Errorf("shared queue unreachable: %v", err)
+	} else if depth >= int64(config().UploadQueueSize) {
+		return errQueueFull
+	}
+	image, err := os.ReadFile(j.path)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(jobRecord{
+		ID: j.id, Image: filepath.Base(j.path), Source: j.source, Options: j.opts,
+		Stage: jobQueued, QueuedAt: j.queuedAt, UploadMS: j.uploadMS,
+	})
+	if _, err := c.redis.do("HSET", clusterJobKey(j.id), "record", data, "image", image, "stage", jobQueued, "attempts", 0); err != nil {
+		return fmt.Errorf("shared queue unreachable: %v", err)
+	}
+	if _, err := c.redis.do("LPUSH", clusterQueueKey, j.id); err != nil {
+		c.redis.do("DEL", clusterJobKey(j.id))
+		return fmt.Errorf("shared queue unreachable: %v", err)
+	}
+	os.RemoveAll(filepath.Dir(j.path))
+	return nil
+}
+
+// take waits for a job and claims it for this replica, writing its image
+// to its own directory under uploadDir; it returns nil when none came
+func (c *clusterState) take() (*uploadJob, error) {
+	id, err := redisString(c.redis.doTimeout(clusterTakeWait+redisTimeout,
+		"BLMOVE", clusterQueueKey, clusterProcessingKey(c.name), "RIGHT", "LEFT", int(clusterTakeWait.Seconds())))
+	if err == errRedisNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := redisMap(c.redis.do("HGETALL", clusterJobKey(id)))
+	var rec jobRecord
+	if err == nil {
+		err = json.Unmarshal([]byte(fields["record"]), &rec)
+	}
+	if err != nil || rec.ID != id {
+		c.finish(id)
+		return nil, fmt.Errorf("job %s is unreadable: %v", id, err)
+	}
+	attempts, _ := redisInt(c.redis.do("HINCRBY", clusterJobKey(id), "attempts", 1))
+	dir := filepath.Join(uploadDir, id)
+	path := filepath.Join(dir, filepath.Base(rec.Image))
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		err = os.WriteFile(path, []byte(fields["image"]), 0644)
+	}
+	if err != nil {
+		c.redis.do("LMOVE", clusterProcessingKey(c.name), clusterQueueKey, "LEFT", "RIGHT")
+		return nil, err
+	}
+	clusterJobs.inc("taken")
+	return &uploadJob{
+		id: id, path: path, source: rec.Source, opts: rec.Options, stage: jobQueued,
+		attempts: int(attempts), queuedAt: rec.QueuedAt, uploadMS: rec.UploadMS,
+	}, nil
+}
+
+// finish releases a job this replica ran; its stage stays readable for
+// jobRetention
+func (c *clusterState) finish(id string) {
+	c.redis.do("LREM", clusterProcessingKey(c.name), 0, id)
+	c.redis.do("HDEL", clusterJobKey(id), "image")
+	c.redis.do("PEXPIRE", clusterJobKey(id), jobRetention.Milliseconds())
+}
+
+// shareStage records a stage this replica set and tells the others
+func (c *clusterState) shareStage(ev JobEvent) {
+	if _, err := c.redis.do("HSET", clusterJobKey(ev.JobID), "stage", ev.Stage, "error", ev.Error); err != nil {
+		return
+	}
+	c.publishStage(ev)
+}
+
+func (c *clusterState) publishStage(ev JobEvent) {
+	data, _ := json.Marshal(clusterStage{Replica: c.name, Event: ev})
+	c.redis.do("PUBLISH", clusterStages, data)
+}
+
+// followStages applies the job stages other replicas set
+func (c *clusterState) followStages() {
+	for {
+		rc, err := c.redis.dial()
+		if err == nil {
+			err = rc.send("SUBSCRIBE", clusterStages)
+		}
+		for err == nil {
+			rc.conn.SetDeadline(time.Time{})
+			var reply interface{}
+			if reply, err = rc.receive(); err != nil {
+				break
+			}
+			msg, _ := reply.([]interface{})
+			if len(msg) != 3 || msg[0] != "message" {
+				continue
+			}
+			var st clusterStage
+			payload, _ := msg[2].(string)
+			if json.Unmarshal([]byte(payload), &st) == nil && st.Replica != c.name && jobs != nil {
+				jobs.applyStage(st.Event)
+			}
+		}
+		if rc != nil {
+			rc.close()
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// lookupJob returns the stage of a job of another replica
+func (c *clusterState) lookupJob(id string) (*uploadJob, bool) {
+	fields, err := redisMap(c.redis.do("HGETALL", clusterJobKey(id)))
+	if err != nil || fields["stage"] == "" {
+		return nil, false
+	}
+	j := &uploadJob{id: id, stage: fields["stage"], err: fields["error"], remote: true}
+	if j.stage == jobDone || j.stage == jobFailed {
+		j.finishedAt = time.Now()
+	}
+	return j, true
+}
+
+// clusterWork is an inference worker of the shared queue
+func (q *jobQueue) clusterWork(n int) {
+	for {
+		thermalWait(n)
+		j, err := cluster.take()
+		cluster.reachable(err)
+		if err != nil {
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		if j == nil {
+			continue
+		}
+		q.mu.Lock()
+		if known, ok := q.jobs[j.id]; ok {
+			// Received by this replica or followed from another
+			known.path, known.source, known.opts = j.path, j.source, j.opts
+			known.attempts, known.remote = j.attempts, false
+			j = known
+		} else {
+			q.jobs[j.id] = j
+		}
+		q.mu.Unlock()
+		if j.attempts > config().JobMaxAttempts {
+			log.Printf("Warning: job %s failed: interrupted %d times", j.id, j.attempts-1)
+			os.RemoveAll(filepath.Dir(j.path))
+			q.setStage(j, jobFailed, "inference was interrupted by restarts too many times")
+			cluster.finish(j.id)
+			continue
+		}
+		q.run(j)
+		cluster.finish(j.id)
+	}
+}
+
+// share moves a job recovered from STATE_DIR/jobs to the shared queue
+func (q *jobQueue) share(j *uploadJob) {
+	q.mu.Lock()
+	j.durable = ""
+	q.mu.Unlock()
+	if err := cluster.enqueue(j); err != nil {
+		log.Printf("Warning: job %s could not be shared, it fails: %v", j.id, err)
+		q.setStage(j, jobFailed, "the job could not be queued after a restart")
+	}
+}
+
+// applyStage takes a job stage set by another replica
+func (q *jobQueue) applyStage(ev JobEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[ev.JobID]
+	if !ok {
+		return
+	}
+	j.stage, j.err, j.remote = ev.Stage, ev.Error, true
+	if ev.Stage == jobDone || ev.Stage == jobFailed {
+		j.finishedAt = time.Now()
+	}
+	bus.publish(eventJobStage, ev)
+}
+
+// adopt registers a job of another replica so it can be followed here
+func (q *jobQueue) adopt(id string) bool {
+	if !clusterOn() {
+		return false
+	}
+	j, ok := cluster.lookupJob(id)
+	if !ok {
+		return false
+	}
+	q.mu.Lock()
+	if _, exists := q.jobs[id]; !exists {
+		q.jobs[id] = j
+	}
+	q.mu.Unlock()
+	return true
+}
+
+// indexResult records a stored result as this replica's, for the shared history
+func (c *clusterState) indexResult(r *InferenceResult) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	if _, err := c.redis.do("HSET", clusterOwnersKey, r.ID, c.name); err != nil {
+		log.Printf("Warning: result %s is not in the shared history: %v", r.ID, err)
+		return
+	}
+	c.redis.do("HSET", clusterResultsKey, r.ID, data)
+	c.redis.do("ZADD", clusterHistoryKey, r.CreatedAt.UnixMilli(), r.ID)
+}
+
+// forgetResults removes deleted results from the shared history
+func (c *clusterState) forgetResults(deleted []InferenceResult) {
+	for _, r := range deleted {
+		c.redis.do("ZREM", clusterHistoryKey, r.ID)
+		c.redis.do("HDEL", clusterResultsKey, r.ID)
+		c.redis.do("HDEL", clusterOwnersKey, r.ID)
+	}
+}
+
+// historyList returns up to limit results that keep accepts, newest first:
+// the shared history of all replicas, or this node's results without one
+func historyList(limit int, keep func(InferenceResult) bool) []InferenceResult {
+	if clusterOn() {
+		list, err := cluster.history(limit, keep)
+		if err == nil {
+			return list
+		}
+		log.Printf("Warning: shared history unavailable, listing this replica's results: %v", err)
+	}
+	var out []InferenceResult
+	for _, res := range results.list(0) {
+		if keep(res) {
+			out = append(out, res)
+			if len(out) == limit {
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (c *clusterState) history(limit int, keep func(InferenceResult) bool) ([]InferenceResult, error) {
+	const page = 200
+	var out []InferenceResult
+	for start := 0; ; start += page {
+		ids, err := redisStrings(c.redis.do("ZREVRANGE", clusterHistoryKey, start, start+page-1))
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return out, nil
+		}
+		args := []interface{}{"HMGET", clusterResultsKey}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		data, err := redisStrings(c.redis.do(args...))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range data {
+			var res InferenceResult
+			if d == "" || json.Unmarshal([]byte(d), &res) != nil || !keep(res) {
+				continue
+			}
+			out = append(out, res)
+			if len(out) == limit {
+				return out, nil
+			}
+		}
+	}
+}
+
+// forwardToOwner proxies a request for a result this replica does not have
+// to the replica owning it, reporting whether it did
+func forwardToOwner(w http.ResponseWriter, r *http.Request, id string) bool {
+	if !clusterOn() || id == "" || r.Header.Get(clusterForwarded) != "" {
+		return false
+	}
+	if _, ok := results.get(id); ok {
+		return false
+	}
+	owner, err := redisString(cluster.redis.do("HGET", clusterOwnersKey, id))
+	if err != nil || owner == cluster.name {
+		return false
+	}
+	target, err := redisString(cluster.redis.do("HGET", clusterReplicasKey, owner))
+	if err != nil {
+		return false
+	}
+	proxy, err := cluster.proxy(target)
+	if err != nil {
+		log.Printf("Warning: replica %s has an invalid REPLICA_URL %q", owner, target)
+		return false
+	}
+	clusterJobs.inc("forwarded")
+	r.Header.Set(clusterForwarded, cluster.name)
+	proxy.ServeHTTP(w, r)
+	return true
+}
+
+func (c *clusterState) proxy(target string) (*httputil.ReverseProxy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.proxies[target]; ok {
+		return p, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid replica URL %q", target)
+	}
+	p := httputil.NewSingleHostReverseProxy(u)
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		writeJSONError(w, http.StatusBadGateway, "The replica holding this result is unreachable: "+err.Error())
+	}
+	c.proxies[target] = p
+	return p, nil
+}
+
+// clusterHandler serves GET /api/v1/cluster
+func clusterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !clusterOn() {
+		writeJSONError(w, http.StatusNotFound, "This node does not share a queue; set QUEUE_URL")
+		return
+	}
+	replicas, err := redisMap(cluster.redis.do("HGETALL", clusterReplicasKey))
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "Shared queue unreachable: "+err.Error())
+		return
+	}
+	view := ClusterView{Replica: cluster.name, Replicas: []ReplicaView{}}
+	view.Queued, _ = redisInt(cluster.redis.do("LLEN", clusterQueueKey))
+	for name, u := range replicas {
+		rv := ReplicaView{Name: name, URL: u, Self: name == cluster.name}
+		alive, _ := redisInt(cluster.redis.do("EXISTS", clusterAliveKey(name)))
+		rv.Alive = alive > 0
+		rv.Running, _ = redisInt(cluster.redis.do("LLEN", clusterProcessingKey(name)))
+		view.Replicas = append(view.Replicas, rv)
+	}
+	sortReplicas(view.Replicas)
+	writeJSON(w, http.StatusOK, view)
+}
+
+func sortReplicas(list []ReplicaView) {
+	for i := 1; i < len(list); i++ {
+		for k := i; k > 0 && list[k].Name < list[k-1].Name; k-- {
+			list[k], list[k-1] = list[k-1], list[k]
+		}
+	}
+}
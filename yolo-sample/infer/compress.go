@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Responses with text-like content types (JSON, HTML, JavaScript, SVG) are
+// zstd-, gzip- or deflate-compressed when the client accepts it, and request
+// bodies sent with Content-Encoding zstd, gzip or deflate are decompressed
+// before the handlers see them. Handlers apply their size limits to the
+// decompressed body, so a small compressed upload cannot expand past them.
+// zstd comes from zstd.go, as the standard library has none.
+
+// compressibleTypes are the Content-Type prefixes worth compressing; images
+// other than SVG are already compressed
+var compressibleTypes = []string{
+	"application/json", "application/geo+json", "application/x-ndjson", "application/javascript",
+	"application/manifest+json", "text/html", "text/plain", "text/csv", "image/svg+xml",
+}
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+	return w
+}}
+
+var zstdWriters = sync.Pool{New: func() interface{} {
+	return newZstdWriter(io.Discard)
+}}
+
+// encodingRank breaks ties between equally acceptable encodings
+var encodingRank = map[string]int{"zstd": 3, "gzip": 2, "deflate": 1}
+
+// acceptedEncoding picks zstd, gzip or deflate from an Accept-Encoding
+// header, or ""
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		// Prefer zstd on ties, then gzip, which every client supports
+		if encodingRank[name] > 0 && q > 0 && (q > bestQ || (q == bestQ && encodingRank[name] > encodingRank[best])) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses the body once the handler's headers show a
+// compressible response
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         io.WriteCloser // nil until compression is chosen
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		switch w.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstdWriter)
+			zw.Reset(w.ResponseWriter)
+			w.enc = zw
+		case "gzip":
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.enc = gz
+		default:
+			fl, _ := flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+			w.enc = fl
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush pushes buffered compressed data to the client, for streamed responses
+func (w *compressWriter) Flush() {
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// FlushError is Flush reporting whether the data reached the connection
+func (w *compressWriter) FlushError() error {
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets upgraded connections bypass compression
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	switch enc := w.enc.(type) {
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		gzipWriters.Put(enc)
+	case *zstdWriter:
+		enc.Reset(io.Discard)
+		zstdWriters.Put(enc)
+	}
+}
+
+func compressible(ctype string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(ctype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCompression negotiates response compression and decodes compressed request bodies
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid gzip request body")
+				return
+			}
+			defer gz.Close()
+			r.Body = gz
+		case "deflate":
+			fl := flate.NewReader(r.Body)
+			defer fl.Close()
+			r.Body = fl
+		case "zstd":
+			zr, err := newZstdReader(r.Body)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid zstd request body")
+				return
+			}
+			r.Body = zr
+		default:
+			w.Header().Set("Accept-Encoding", "zstd, gzip, deflate")
+			writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding "+enc)
+			return
+		}
+		if enc := r.Header.Get("Content-Encoding"); enc != "" {
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if !config().CompressResponses || encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,832 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the runtime settings for the web UI, read from the environment
+// and CONFIG_FILE
+type Config struct {
+	// Settings file watched for changes; see reload.go. Only read from the environment.
+	ConfigFile           string
+	ConfigReloadInterval time.Duration
+
+	ModelDir  string
+	StateDir  string
+	EvalRoot  string // evaluation datasets must live under this directory
+	PublicURL string // base URL for links shared off the node; defaults to the request's host
+
+	// Canary guard rails used when recommending promote/abort
+	CanaryMinSamples      int
+	CanaryMaxErrorRate    float64
+	CanaryMaxLatencyRatio float64
+
+	// Drift detection on incoming image statistics
+	DriftReferenceDir  string
+	DriftReferenceSize int
+	DriftWindow        int
+	DriftMinWindow     int
+	DriftThreshold     float64
+
+	// Golden-result sets of model versions; see golden.go
+	GoldenDir                 string
+	GoldenIoUTolerance        float64
+	GoldenConfidenceTolerance float64
+	GoldenGate                bool
+
+	// Scheduled tasks; an empty schedule disables the task
+	ScheduleBatchInference string
+	ScheduleRetrain        string
+	ScheduleRetention      string
+	ScheduleSync           string
+	ScheduleFleetConfig    string
+	ScheduleModelPull      string
+	ScheduleSelfUpdate     string
+	ScheduleDailyReport    string
+	ScheduleWeeklyReport   string
+	ScheduleStorageGC      string
+	ScheduleReconcile      string
+	BatchDir               string
+	RetentionDays          int
+	HeatmapRetentionDays   int
+	StatsArchiveDays       int
+	SyncURL                string
+
+	// Signed configuration bundles pulled from a fleet management server; see fleet.go
+	FleetConfigURL       string
+	FleetConfigPublicKey string // base64 ed25519 public key
+
+	// Desired state the node converges to; see desired.go
+	DesiredStateSource string
+
+	// Privacy mode redacts these classes in stored/displayed images and discards raw uploads
+	PrivacyMode    bool
+	PrivacyClasses string
+	PrivacyMethod  string // "blur" or "mask"
+
+	// Image retention ("full", "thumbnail" or "none"), with per-source overrides
+	ImageRetention        string
+	ImageRetentionSources string // "source=mode,..."
+	ImageDerivatives      bool   // make the small and medium copies when a result is stored
+	SyncThumbnails        bool
+	MaxImagePixels        int // largest image decoded in Go (see decodeImage)
+
+	// WebAssembly post-processing plugins
+	WasmPluginDir   string
+	WasmRuntime     string // command template; see wasm.go
+	WasmTimeout     time.Duration
+	WasmMaxMemoryMB int
+	WasmFuel        int64
+
+	// Branding and theme of the web UI
+	BrandTitle        string
+	BrandLogoURL      string
+	BrandPrimaryColor string
+	ThemeDefault      string // "light", "dark" or "auto"
+
+	// Display names, categories and colors of model classes; see classmap.go
+	ClassMapFile string
+	// Label hierarchy over model classes and the alert rules using it
+	TaxonomyFile   string
+	AlertRulesFile string
+	// Camera sources declared in configuration, in addition to the API's
+	SourcesFile string
+
+	// Language of the UI and API errors when Accept-Language has no supported match
+	DefaultLanguage string
+	LocaleDir       string // extra <lang>.json message catalogs
+
+	// Uploads are queued for this many inference workers
+	UploadWorkers   int
+	UploadQueueSize int
+	UploadMaxBytes  int64 // largest resumable upload
+	JobMaxAttempts  int   // inference attempts of a job interrupted by restarts; see jobstore.go
+
+	// Upload queue shared with other replicas through Redis; see cluster.go
+	QueueURL    string
+	ReplicaName string
+	ReplicaURL  string // how the other replicas reach this one
+
+	// Result cache, rate-limit counters and sessions; see kvstore.go
+	CacheURL       string // Redis; in memory when unset and QUEUE_URL is too
+	ResultCacheTTL time.Duration
+	RateLimit      int // inference requests a minute per client
+	SessionTTL     time.Duration
+	SecureCookies  bool // Secure cookies over plain HTTP, behind a TLS-terminating proxy
+
+	// Content-Security-Policy and friends; see securityheaders.go
+	SecurityHeaders bool
+	FrameAncestors  string // origins that may frame the pages
+
+	// Serving behind an ingress; see proxy.go
+	BaseURL        string // sub-path the UI is published at, e.g. /yolo/
+	TrustedProxies string // CIDRs whose X-Forwarded-* headers are believed
+
+	// Images submitted by URL or as base64
+	IngestMaxBytes      int64
+	InferBatchMaxImages int
+	IngestAllowPrivate  bool // allow fetching from private and local addresses
+
+	// Lifecycle of API v1; see apiversion.go
+	APIV1DeprecatedAt *time.Time
+	APIV1SunsetAt     *time.Time
+
+	// How long responses are kept for replay by Idempotency-Key
+	IdempotencyWindow time.Duration
+
+	// zstd/gzip/deflate response compression, and gzip for result sync uploads
+	CompressResponses bool
+	SyncGzip          bool
+
+	// Cross-origin browser clients of the API and gRPC-Web service
+	CORSAllowedOrigins   string // comma-separated origins, or "*"
+	CORSAllowCredentials bool
+
+	// Inference process command and its supervision; see worker.go
+	InferenceBackend    string // "process" or "mock"; see mock.go
+	InferenceCommand    string
+	InferenceThreshold  float64
+	InferenceTimeout    time.Duration
+	WorkerBackoffMax    time.Duration
+	WorkerMaxRestarts   int
+	WorkerRestartWindow time.Duration
+	MockSeed            int
+	MockLatency         time.Duration
+
+	// Bounds of a request's imgsz and max_detections; see options.go
+	InferenceMaxImgSz      int
+	InferenceMaxDetections int
+
+	// Recording of inference traffic for replays; see replay.go
+	TrafficRecording     bool
+	TrafficRecordingDays int
+
+	// Barcode and QR decoding; see barcode.go
+	BarcodeDecode  string // "off", "image" or "boxes"
+	BarcodeClasses string // comma-separated, for "boxes"
+
+	// Image quality gate; see quality.go
+	QualityGate          string // "off", "flag" or "reject"
+	QualityMinWidth      int
+	QualityMinHeight     int
+	QualityMinBrightness float64
+	QualityMaxBrightness float64
+	QualityMinSharpness  float64
+
+	// Encryption of stored images and results; see encryption.go
+	EncryptionKeyFile    string // mounted secret holding the key
+	EncryptionKeyCommand string // prints the key, e.g. a KMS decrypt
+
+	// Sandbox of the inference process; see sandbox.go
+	InferWorkDir        string
+	InferEnvPassthrough string // comma-separated variable names
+	InferMemoryMB       int
+	InferCPUs           float64
+	InferNice           int
+	InferCgroup         string // delegated cgroup v2 directory
+
+	// Inference backend circuit breaker and optional secondary backend
+	BreakerFailures      int
+	BreakerCooldown      time.Duration
+	FallbackInferenceURL string
+
+	// Internal events POSTed to a webhook; see events.go
+	EventWebhookURL    string
+	EventWebhookTypes  string // comma-separated types or "prefix." families
+	EventWebhookSecret string // signs deliveries; see signing.go
+	SignatureTolerance time.Duration
+
+	// Listeners for the web UI and API; see unixsock.go
+	ListenAddr      string // TCP address, or "none"
+	UnixSocket      string // path, or "@name" for an abstract socket
+	UnixSocketMode  string // octal
+	UnixSocketGroup string
+
+	// TLS, HTTP/2 and connection tuning of those listeners; see httpserver.go
+	TLSCertFile           string
+	TLSKeyFile            string
+	H2C                   bool // HTTP/2 without TLS
+	HTTP2Push             bool
+	HTTP2MaxStreams       int
+	HTTPReadHeaderTimeout time.Duration
+	HTTPIdleTimeout       time.Duration
+	HTTP2PingInterval     time.Duration
+	HTTP2PingTimeout      time.Duration
+	HTTP2WriteTimeout     time.Duration
+
+	// Event streams; see streams.go
+	StreamKeepalive    time.Duration
+	StreamWriteTimeout time.Duration
+
+	// Zeroconf advertisement of the API; see mdns.go
+	MDNS          bool
+	MDNSName      string
+	MDNSInterface string
+
+	// Diagnostics listener; see diagnostics.go
+	AdminAddr      string
+	AdminToken     string
+	FaultInjection bool // allow /admin/faults; see faults.go
+
+	// Log entries kept in memory and their viewer; see logs.go
+	LogBufferLines int
+	LogViewer      bool
+
+	// Log sinks off the node; see logforward.go
+	LogForwardLevel string
+	LogSyslog       string // "local" or a udp://, tcp:// or tls:// address
+	LogSyslogCA     string
+	LogSyslogTag    string
+	LogShipURL      string
+	LogShipInterval time.Duration
+	LogShipSpoolMax int64
+
+	// Self-update of the binary on bare-metal installs; see update.go
+	UpdateURL          string
+	UpdateSigningKeys  string
+	UpdateRestart      string // "exec", "exit" or "flag"
+	UpdateFlagFile     string
+	UpdateDrainTimeout time.Duration
+
+	// Crash reports of recovered handler panics; see crash.go
+	CrashReportURL string
+	CrashReportMax int
+
+	// Clock synchronization checks; see clock.go
+	ClockCheckInterval time.Duration
+	ClockCheckURL      string
+	ClockMaxSkew       time.Duration
+
+	// Thermal and power throttling; see thermal.go
+	ThermalZoneDir       string
+	ThermalThrottleTemp  float64 // °C, 0 for each zone's passive trip point
+	ThermalHysteresis    float64
+	ThermalCheckInterval time.Duration
+	ThermalFPSFactor     float64
+	ThermalWorkers       int
+
+	// Battery and UPS status and the low-power policy; see power.go
+	PowerProvider       string
+	PowerSupplyDir      string
+	ApcupsdAddr         string
+	NUTUPS              string // name@host[:port]
+	PowerCheckInterval  time.Duration
+	LowPowerPolicy      string
+	LowPowerKeepSources string
+
+	// Liveness reports to a fleet endpoint; see heartbeat.go
+	HeartbeatURL      string
+	HeartbeatInterval time.Duration
+	HeartbeatBuffer   int
+
+	// Retention of the built-in time series by resolution; see timeseries.go
+	TimeseriesMinuteRetention time.Duration
+	TimeseriesHourRetention   time.Duration
+	TimeseriesDayRetention    time.Duration
+
+	// Stored summary reports and their email delivery; see reports.go
+	ReportRetentionDays int
+	ReportEmailTo       string // comma-separated addresses
+	ReportEmailFrom     string
+	SMTPAddr            string // host:port
+	SMTPUsername        string
+	SMTPPassword        string
+
+	// Retries and dead letters of webhook and MQTT event deliveries; see deliveries.go
+	DeliveryRetries         int
+	DeliveryRetryBackoff    time.Duration
+	DeliveryRetryMaxBackoff time.Duration
+	DeadLetterMax           int
+	// CloudEvents attributes of events sent off the node; see cloudevents.go
+	CloudEventsSource     string
+	CloudEventsTypePrefix string
+
+	// Trusted model signing keys and what to do with unverified models; see modelsign.go
+	ModelSigningKeys   string
+	ModelSignatureMode string
+
+	// Models pulled from OCI registries; see oci.go
+	ModelOCIRefs      string
+	RegistryAuthFile  string
+	RegistryPlainHTTP string // comma-separated registry hosts without TLS
+	ModelPeers        string // comma-separated base URLs of sibling nodes; see peers.go
+
+	// MQTT broker connection and Home Assistant discovery; see mqtt.go and homeassistant.go
+	MQTTURL           string
+	MQTTUsername      string // instead of the credentials in MQTTURL
+	MQTTPassword      string
+	MQTTClientID      string
+	MQTTTopicPrefix   string
+	MQTTEventTypes    string // bus events published as CloudEvents; none by default
+	HADiscovery       bool
+	HADiscoveryPrefix string
+	HAClasses         string // comma-separated classes or taxonomy labels
+	HADetectionHold   time.Duration
+
+	// Experimental capabilities by flag name; see flags.go
+	FeatureFlags map[string]FeatureFlag
+
+	// Camera capture
+	FFmpegPath string
+
+	// Training jobs are created from this CronJob in Namespace, or outside
+	// Kubernetes by running TrainingCommand
+	TrainingCronJob string
+	Namespace       string
+	TrainingCommand string
+	// Placement of training Jobs on nodes that can train; see placement.go
+	TrainingPlacement       bool
+	TrainingGPULabels       string
+	TrainingScheduleTimeout time.Duration
+
+	// Rollout of models announced by training callbacks; see callbacks.go
+	TrainingCallbackSecret string
+	TrainingEvalDir        string // under EVAL_ROOT
+	TrainingMinMAP50       float64
+	TrainingMaxMAP50Drop   float64
+	TrainingPromotion      string // "canary", "direct" or "off"
+	TrainingCanaryPercent  float64
+
+	// Network status outside Kubernetes; see netstatus.go
+	NetworkStatusProvider string
+	NetworkStatusFile     string
+	NetworkProbeURL       string
+	NetworkProbeInterval  time.Duration
+	NetworkStatus         string
+	NetworkStatusMaxStale time.Duration // how long the last node label stands in for one kubectl cannot read
+
+	// Secrets providers; see secrets.go. VAULT_TOKEN is only read there.
+	SecretsRefreshInterval time.Duration
+	VaultAddr              string
+	VaultNamespace         string
+	VaultTokenFile         string
+	VaultRole              string // Kubernetes auth role, without a token
+	VaultAuthPath          string
+}
+
+// dataDir returns fallback, or sub of the directory systemd's StateDirectory=
+// provides (see systemd.go)
+func dataDir(sub, fallback string) string {
+	if dir, _, _ := strings.Cut(os.Getenv("STATE_DIRECTORY"), ":"); dir != "" {
+		return filepath.Join(dir, sub)
+	}
+	return fallback
+}
+
+// defaultStateDir is STATE_DIR's default
+func defaultStateDir() string {
+	return dataDir("state", "/tmp/state")
+}
+
+// currentConfig is replaced as a whole when the configuration is reloaded;
+// see reload.go
+var currentConfig = func() *atomic.Pointer[Config] {
+	p := new(atomic.Pointer[Config])
+	cfg, _ := loadConfig(withDesiredSettings(withFleetSettings(readConfigFileOrWarn())))
+	p.Store(cfg)
+	return p
+}()
+
+// config returns the configuration in effect
+func config() *Config {
+	return currentConfig.Load()
+}
+
+// loadConfig reads the configuration from CONFIG_FILE settings and environment
+// variables, falling back to defaults. It also returns the settings that did not
+// parse, which are logged and left at their defaults.
+func loadConfig(file map[string]string) (*Config, []string) {
+	s := &settings{file: file}
+	batchDir := s.lookup("BATCH_DIR")
+	syncURL := s.lookup("SYNC_URL")
+	fleetURL := s.lookup("FLEET_CONFIG_URL")
+	modelRefs := s.lookup("MODEL_OCI_REFS")
+	updateURL := s.lookup("UPDATE_URL")
+	desiredSource := s.lookup("DESIRED_STATE_SOURCE")
+
+	cfg := &Config{
+		ConfigFile:           os.Getenv("CONFIG_FILE"),
+		ConfigReloadInterval: (&settings{}).getEnvDuration("CONFIG_RELOAD_INTERVAL", 10*time.Second),
+
+		ModelDir:              s.getEnv("MODEL_DIR", dataDir("models", "/data/models")),
+		StateDir:              s.getEnv("STATE_DIR", defaultStateDir()),
+		EvalRoot:              s.getEnv("EVAL_ROOT", dataDir("", "/data")),
+		PublicURL:             strings.TrimSuffix(s.lookup("PUBLIC_URL"), "/"),
+		CanaryMinSamples:      s.getEnvInt("CANARY_MIN_SAMPLES", 20),
+		CanaryMaxErrorRate:    s.getEnvFloat("CANARY_MAX_ERROR_RATE", 0.05),
+		CanaryMaxLatencyRatio: s.getEnvFloat("CANARY_MAX_LATENCY_RATIO", 1.5),
+		DriftReferenceDir:     s.lookup("DRIFT_REFERENCE_DIR"),
+		DriftReferenceSize:    s.getEnvInt("DRIFT_REFERENCE_SIZE", 100),
+		DriftWindow:           s.getEnvInt("DRIFT_WINDOW", 50),
+		DriftMinWindow:        s.getEnvInt("DRIFT_MIN_WINDOW", 10),
+		DriftThreshold:        s.getEnvFloat("DRIFT_THRESHOLD", 2.0),
+
+		GoldenDir:                 s.getEnv("GOLDEN_DIR", dataDir("golden", "/data/golden")),
+		GoldenIoUTolerance:        s.getEnvFloat("GOLDEN_IOU_TOLERANCE", 0.9),
+		GoldenConfidenceTolerance: s.getEnvFloat("GOLDEN_CONFIDENCE_TOLERANCE", 0.05),
+		GoldenGate:                s.getEnvBool("GOLDEN_GATE", false),
+
+		ScheduleBatchInference: s.getEnv("SCHEDULE_BATCH_INFERENCE", defaultIf(batchDir != "", "0 2 * * *")),
+		ScheduleRetrain:        s.lookup("SCHEDULE_RETRAIN"),
+		ScheduleRetention:      s.getEnv("SCHEDULE_RETENTION", "30 3 * * *"),
+		ScheduleSync:           s.getEnv("SCHEDULE_SYNC", defaultIf(syncURL != "", "*/15 * * * *")),
+		ScheduleFleetConfig:    s.getEnv("SCHEDULE_FLEET_CONFIG", defaultIf(fleetURL != "", "*/5 * * * *")),
+		ScheduleModelPull:      s.getEnv("SCHEDULE_MODEL_PULL", defaultIf(modelRefs != "", "*/30 * * * *")),
+		ScheduleSelfUpdate:     s.getEnv("SCHEDULE_SELF_UPDATE", defaultIf(updateURL != "", "0 4 * * *")),
+		ScheduleDailyReport:    s.getEnv("SCHEDULE_DAILY_REPORT", "10 0 * * *"),
+		ScheduleWeeklyReport:   s.getEnv("SCHEDULE_WEEKLY_REPORT", "20 0 * * 1"),
+		ScheduleStorageGC:      s.getEnv("SCHEDULE_STORAGE_GC", "45 3 * * *"),
+		ScheduleReconcile:      s.getEnv("SCHEDULE_RECONCILE", defaultIf(desiredSource != "", "* * * * *")),
+		BatchDir:               batchDir,
+		RetentionDays:          s.getEnvInt("RETENTION_DAYS", 30),
+		HeatmapRetentionDays:   s.getEnvInt("HEATMAP_RETENTION_DAYS", 30),
+		StatsArchiveDays:       s.getEnvInt("STATS_ARCHIVE_DAYS", 1825),
+		SyncURL:                syncURL,
+
+		FleetConfigURL:       fleetURL,
+		FleetConfigPublicKey: s.lookup("FLEET_CONFIG_PUBLIC_KEY"),
+
+		DesiredStateSource: desiredSource,
+
+		PrivacyMode:    s.getEnvBool("PRIVACY_MODE", false),
+		PrivacyClasses: s.getEnv("PRIVACY_CLASSES", "person,face,license_plate"),
+		PrivacyMethod:  s.getEnv("PRIVACY_METHOD", "blur"),
+
+		ImageRetention:        s.getEnv("IMAGE_RETENTION", "full"),
+		ImageRetentionSources: s.lookup("IMAGE_RETENTION_SOURCES"),
+		ImageDerivatives:      s.getEnvBool("IMAGE_DERIVATIVES", true),
+		SyncThumbnails:        s.getEnvBool("SYNC_THUMBNAILS", false),
+		MaxImagePixels:        s.getEnvInt("MAX_IMAGE_PIXELS", 50000000),
+
+		WasmPluginDir:   s.lookup("WASM_PLUGIN_DIR"),
+		WasmRuntime:     s.getEnv("WASM_RUNTIME", "wasmtime run -W max-memory-size={{.MemoryBytes}} -W fuel={{.Fuel}} {{.Module}}"),
+		WasmTimeout:     s.getEnvDuration("WASM_TIMEOUT", 2*time.Second),
+		WasmMaxMemoryMB: s.getEnvInt("WASM_MAX_MEMORY_MB", 64),
+		WasmFuel:        int64(s.getEnvInt("WASM_FUEL", 1000000000)),
+
+		BrandTitle:        s.getEnv("BRAND_TITLE", "YOLO Inference"),
+		BrandLogoURL:      s.lookup("BRAND_LOGO_URL"),
+		BrandPrimaryColor: s.getEnv("BRAND_PRIMARY_COLOR", "#4CAF50"),
+		ThemeDefault:      s.getEnv("THEME_DEFAULT", "light"),
+
+		ClassMapFile:   s.lookup("CLASS_MAP"),
+		TaxonomyFile:   s.lookup("TAXONOMY"),
+		AlertRulesFile: s.lookup("ALERT_RULES"),
+		SourcesFile:    s.lookup("SOURCES_FILE"),
+
+		DefaultLanguage: s.getEnv("DEFAULT_LANGUAGE", "en"),
+		LocaleDir:       s.lookup("LOCALE_DIR"),
+
+		UploadWorkers:   s.getEnvInt("UPLOAD_WORKERS", 2),
+		UploadQueueSize: s.getEnvInt("UPLOAD_QUEUE_SIZE", 32),
+		JobMaxAttempts:  s.getEnvInt("JOB_MAX_ATTEMPTS", 2),
+		UploadMaxBytes:  int64(s.getEnvInt("UPLOAD_MAX_MB", 50)) << 20,
+
+		QueueURL:    s.lookup("QUEUE_URL"),
+		ReplicaName: s.lookup("REPLICA_NAME"),
+		ReplicaURL:  s.lookup("REPLICA_URL"),
+
+		CacheURL:       s.lookup("CACHE_URL"),
+		ResultCacheTTL: s.getEnvDuration("RESULT_CACHE_TTL", 0),
+		RateLimit:      s.getEnvInt("RATE_LIMIT", 0),
+		SessionTTL:     s.getEnvDuration("SESSION_TTL", 12*time.Hour),
+		SecureCookies:  s.getEnvBool("SECURE_COOKIES", false),
+
+		SecurityHeaders: s.getEnvBool("SECURITY_HEADERS", true),
+		FrameAncestors:  s.getEnv("FRAME_ANCESTORS", "'self'"),
+
+		BaseURL:        s.lookup("BASE_URL"),
+		TrustedProxies: s.getEnv("TRUSTED_PROXIES", defaultTrustedProxies),
+
+		IngestMaxBytes:      int64(s.getEnvInt("INGEST_MAX_MB", 20)) << 20,
+		InferBatchMaxImages: s.getEnvInt("INFER_BATCH_MAX_IMAGES", 1000),
+		IngestAllowPrivate:  s.getEnvBool("INGEST_ALLOW_PRIVATE", false),
+
+		APIV1DeprecatedAt: s.getEnvDate("API_V1_DEPRECATED_AT"),
+		APIV1SunsetAt:     s.getEnvDate("API_V1_SUNSET_AT"),
+
+		IdempotencyWindow: s.getEnvDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+
+		CompressResponses: s.getEnvBool("COMPRESS_RESPONSES", true),
+		SyncGzip:          s.getEnvBool("SYNC_GZIP", false),
+
+		CORSAllowedOrigins:   s.lookup("CORS_ALLOWED_ORIGINS"),
+		CORSAllowCredentials: s.getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+
+		InferenceBackend:    s.getEnv("INFERENCE_BACKEND", backendProcess),
+		InferenceCommand:    s.getEnv("INFERENCE_COMMAND", "python /app/infer.py --serve --conf {{.Threshold}}"),
+		InferenceThreshold:  s.getEnvFloat("INFERENCE_THRESHOLD", 0.25),
+		InferenceTimeout:    s.getEnvDuration("INFERENCE_TIMEOUT", 60*time.Second),
+		WorkerBackoffMax:    s.getEnvDuration("WORKER_BACKOFF_MAX", time.Minute),
+		WorkerMaxRestarts:   s.getEnvInt("WORKER_MAX_RESTARTS", 5),
+		WorkerRestartWindow: s.getEnvDuration("WORKER_RESTART_WINDOW", 10*time.Minute),
+		MockSeed:            s.getEnvInt("MOCK_SEED", 1),
+		MockLatency:         s.getEnvDuration("MOCK_LATENCY", 0),
+
+		InferenceMaxImgSz:      s.getEnvInt("INFERENCE_MAX_IMGSZ", 1280),
+		InferenceMaxDetections: s.getEnvInt("INFERENCE_MAX_DETECTIONS", 1000),
+
+		TrafficRecording:     s.getEnvBool("TRAFFIC_RECORDING", false),
+		TrafficRecordingDays: s.getEnvInt("TRAFFIC_RECORDING_DAYS", 7),
+
+		BarcodeDecode:  s.getEnv("BARCODE_DECODE", "off"),
+		BarcodeClasses: s.getEnv("BARCODE_CLASSES", "barcode,qr_code,label"),
+
+		QualityGate:          s.getEnv("QUALITY_GATE", "off"),
+		QualityMinWidth:      s.getEnvInt("QUALITY_MIN_WIDTH", 160),
+		QualityMinHeight:     s.getEnvInt("QUALITY_MIN_HEIGHT", 120),
+		QualityMinBrightness: s.getEnvFloat("QUALITY_MIN_BRIGHTNESS", 25),
+		QualityMaxBrightness: s.getEnvFloat("QUALITY_MAX_BRIGHTNESS", 235),
+		QualityMinSharpness:  s.getEnvFloat("QUALITY_MIN_SHARPNESS", 20),
+
+		EncryptionKeyFile:    s.lookup("ENCRYPTION_KEY_FILE"),
+		EncryptionKeyCommand: s.lookup("ENCRYPTION_KEY_COMMAND"),
+
+		InferWorkDir:        s.getEnv("INFER_WORK_DIR", "/tmp/infer-sandbox"),
+		InferEnvPassthrough: s.getEnv("INFER_ENV_PASSTHROUGH", "CUDA_VISIBLE_DEVICES,NVIDIA_VISIBLE_DEVICES,LD_LIBRARY_PATH,PYTHONPATH"),
+		InferMemoryMB:       s.getEnvInt("INFER_MEMORY_MB", 0),
+		InferCPUs:           s.getEnvFloat("INFER_CPUS", 0),
+		InferNice:           s.getEnvInt("INFER_NICE", 0),
+		InferCgroup:         s.lookup("INFER_CGROUP"),
+
+		BreakerFailures:      s.getEnvInt("BREAKER_FAILURES", 5),
+		BreakerCooldown:      s.getEnvDuration("BREAKER_COOLDOWN", 30*time.Second),
+		FallbackInferenceURL: s.lookup("FALLBACK_INFERENCE_URL"),
+
+		EventWebhookURL:    s.lookup("EVENT_WEBHOOK_URL"),
+		EventWebhookTypes:  s.lookup("EVENT_WEBHOOK_TYPES"),
+		EventWebhookSecret: s.getSecret("EVENT_WEBHOOK_SECRET"),
+		SignatureTolerance: s.getEnvDuration("SIGNATURE_TOLERANCE", 5*time.Minute),
+
+		ListenAddr:      s.getEnv("LISTEN_ADDR", ":6767"),
+		UnixSocket:      s.lookup("UNIX_SOCKET"),
+		UnixSocketMode:  s.getEnv("UNIX_SOCKET_MODE", "0660"),
+		UnixSocketGroup: s.lookup("UNIX_SOCKET_GROUP"),
+
+		TLSCertFile:           s.lookup("TLS_CERT_FILE"),
+		TLSKeyFile:            s.lookup("TLS_KEY_FILE"),
+		H2C:                   s.getEnvBool("H2C", false),
+		HTTP2Push:             s.getEnvBool("HTTP2_PUSH", false),
+		HTTP2MaxStreams:       s.getEnvInt("HTTP2_MAX_STREAMS", 250),
+		HTTPReadHeaderTimeout: s.getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HTTPIdleTimeout:       s.getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		HTTP2PingInterval:     s.getEnvDuration("HTTP2_PING_INTERVAL", 30*time.Second),
+		HTTP2PingTimeout:      s.getEnvDuration("HTTP2_PING_TIMEOUT", 15*time.Second),
+		HTTP2WriteTimeout:     s.getEnvDuration("HTTP2_WRITE_TIMEOUT", time.Minute),
+
+		StreamKeepalive:    s.getEnvDuration("STREAM_KEEPALIVE", 15*time.Second),
+		StreamWriteTimeout: s.getEnvDuration("STREAM_WRITE_TIMEOUT", 30*time.Second),
+
+		MDNS:          s.getEnvBool("MDNS", false),
+		MDNSName:      s.lookup("MDNS_NAME"),
+		MDNSInterface: s.lookup("MDNS_INTERFACE"),
+
+		AdminAddr:      s.lookup("ADMIN_ADDR"),
+		AdminToken:     s.getSecret("ADMIN_TOKEN"),
+		FaultInjection: s.getEnvBool("FAULT_INJECTION", false),
+
+		LogBufferLines: s.getEnvInt("LOG_BUFFER_LINES", 2000),
+		LogViewer:      s.getEnvBool("LOG_VIEWER", true),
+
+		LogForwardLevel: s.getEnv("LOG_FORWARD_LEVEL", logInfo),
+		LogSyslog:       s.lookup("LOG_SYSLOG"),
+		LogSyslogCA:     s.lookup("LOG_SYSLOG_CA"),
+		LogSyslogTag:    s.getEnv("LOG_SYSLOG_TAG", "yolo-webui"),
+		LogShipURL:      s.lookup("LOG_SHIP_URL"),
+		LogShipInterval: s.getEnvDuration("LOG_SHIP_INTERVAL", 30*time.Second),
+		LogShipSpoolMax: int64(s.getEnvInt("LOG_SHIP_SPOOL_MAX", 64<<20)),
+
+		UpdateURL:          updateURL,
+		UpdateSigningKeys:  s.lookup("UPDATE_SIGNING_KEYS"),
+		UpdateRestart:      s.getEnv("UPDATE_RESTART", updateRestartExec),
+		UpdateFlagFile:     s.lookup("UPDATE_FLAG_FILE"),
+		UpdateDrainTimeout: s.getEnvDuration("UPDATE_DRAIN_TIMEOUT", 2*time.Minute),
+
+		CrashReportURL: s.lookup("CRASH_REPORT_URL"),
+		CrashReportMax: s.getEnvInt("CRASH_REPORT_MAX", 50),
+
+		ClockCheckInterval: s.getEnvDuration("CLOCK_CHECK_INTERVAL", time.Minute),
+		ClockCheckURL:      s.lookup("CLOCK_CHECK_URL"),
+		ClockMaxSkew:       s.getEnvDuration("CLOCK_MAX_SKEW", 5*time.Second),
+
+		ThermalZoneDir:       s.getEnv("THERMAL_ZONE_DIR", "/sys/class/thermal"),
+		ThermalThrottleTemp:  s.getEnvFloat("THERMAL_THROTTLE_TEMP", 0),
+		ThermalHysteresis:    s.getEnvFloat("THERMAL_HYSTERESIS", 5),
+		ThermalCheckInterval: s.getEnvDuration("THERMAL_CHECK_INTERVAL", 10*time.Second),
+		ThermalFPSFactor:     s.getEnvFloat("THERMAL_FPS_FACTOR", 0.5),
+		ThermalWorkers:       s.getEnvInt("THERMAL_WORKERS", 1),
+
+		PowerProvider:       s.getEnv("POWER_PROVIDER", powerProviderAuto),
+		PowerSupplyDir:      s.getEnv("POWER_SUPPLY_DIR", "/sys/class/power_supply"),
+		ApcupsdAddr:         s.getEnv("APCUPSD_ADDR", "127.0.0.1:3551"),
+		NUTUPS:              s.getEnv("NUT_UPS", "ups@127.0.0.1:3493"),
+		PowerCheckInterval:  s.getEnvDuration("POWER_CHECK_INTERVAL", 30*time.Second),
+		LowPowerPolicy:      s.getEnv("LOW_POWER_POLICY", defaultLowPowerPolicy),
+		LowPowerKeepSources: s.lookup("LOW_POWER_KEEP_SOURCES"),
+
+		HeartbeatURL:      s.lookup("HEARTBEAT_URL"),
+		HeartbeatInterval: s.getEnvDuration("HEARTBEAT_INTERVAL", time.Minute),
+		HeartbeatBuffer:   s.getEnvInt("HEARTBEAT_BUFFER", 1440),
+
+		TimeseriesMinuteRetention: s.getEnvDuration("TIMESERIES_MINUTE_RETENTION", 48*time.Hour),
+		TimeseriesHourRetention:   s.getEnvDuration("TIMESERIES_HOUR_RETENTION", 30*24*time.Hour),
+		TimeseriesDayRetention:    s.getEnvDuration("TIMESERIES_DAY_RETENTION", 365*24*time.Hour),
+
+		ReportRetentionDays: s.getEnvInt("REPORT_RETENTION_DAYS", 90),
+		ReportEmailTo:       s.lookup("REPORT_EMAIL_TO"),
+		ReportEmailFrom:     s.getEnv("REPORT_EMAIL_FROM", "yolo@"+getEnv("NODE_NAME", "localhost")),
+		SMTPAddr:            s.lookup("SMTP_ADDR"),
+		SMTPUsername:        s.lookup("SMTP_USERNAME"),
+		SMTPPassword:        s.getSecret("SMTP_PASSWORD"),
+
+		DeliveryRetries:         s.getEnvInt("DELIVERY_RETRIES", 5),
+		DeliveryRetryBackoff:    s.getEnvDuration("DELIVERY_RETRY_BACKOFF", 2*time.Second),
+		DeliveryRetryMaxBackoff: s.getEnvDuration("DELIVERY_RETRY_MAX_BACKOFF", 5*time.Minute),
+		DeadLetterMax:           s.getEnvInt("DEAD_LETTER_MAX", 1000),
+
+		CloudEventsSource:     s.lookup("CLOUDEVENTS_SOURCE"),
+		CloudEventsTypePrefix: s.getEnv("CLOUDEVENTS_TYPE_PREFIX", "yolo.v1."),
+
+		ModelSigningKeys:   s.lookup("MODEL_SIGNING_KEYS"),
+		ModelSignatureMode: s.lookup("MODEL_SIGNATURE_MODE"),
+
+		ModelOCIRefs:      modelRefs,
+		RegistryAuthFile:  s.lookup("REGISTRY_AUTH_FILE"),
+		RegistryPlainHTTP: s.lookup("REGISTRY_PLAIN_HTTP"),
+		ModelPeers:        s.lookup("MODEL_PEERS"),
+
+		MQTTURL:           s.lookup("MQTT_URL"),
+		MQTTUsername:      s.getSecret("MQTT_USERNAME"),
+		MQTTPassword:      s.getSecret("MQTT_PASSWORD"),
+		MQTTClientID:      s.getEnv("MQTT_CLIENT_ID", "yolo-"+getEnv("NODE_NAME", "unknown")),
+		MQTTTopicPrefix:   s.getEnv("MQTT_TOPIC_PREFIX", "yolo/"+getEnv("NODE_NAME", "unknown")),
+		MQTTEventTypes:    s.lookup("MQTT_EVENT_TYPES"),
+		HADiscovery:       s.getEnvBool("HA_DISCOVERY", true),
+		HADiscoveryPrefix: s.getEnv("HA_DISCOVERY_PREFIX", "homeassistant"),
+		HAClasses:         s.getEnv("HA_CLASSES", "person,car,dog,cat"),
+		HADetectionHold:   s.getEnvDuration("HA_DETECTION_HOLD", 30*time.Second),
+
+		FeatureFlags: s.getEnvFlags("FEATURE_FLAGS"),
+
+		FFmpegPath: s.getEnv("FFMPEG_PATH", "ffmpeg"),
+
+		TrainingCronJob: s.getEnv("TRAINING_CRONJOB", "edge-training-job"),
+		Namespace:       s.lookup("POD_NAMESPACE"),
+		TrainingCommand: s.lookup("TRAINING_COMMAND"),
+
+		TrainingPlacement:       s.getEnvBool("TRAINING_PLACEMENT", true),
+		TrainingGPULabels:       s.getEnv("TRAINING_GPU_LABELS", "nvidia.com/gpu.present=true"),
+		TrainingScheduleTimeout: s.getEnvDuration("TRAINING_SCHEDULE_TIMEOUT", 2*time.Minute),
+
+		TrainingCallbackSecret: s.getSecret("TRAINING_CALLBACK_SECRET"),
+		TrainingEvalDir:        s.lookup("TRAINING_EVAL_DIR"),
+		TrainingMinMAP50:       s.getEnvFloat("TRAINING_MIN_MAP50", 0),
+		TrainingMaxMAP50Drop:   s.getEnvFloat("TRAINING_MAX_MAP50_DROP", 0.01),
+		TrainingPromotion:      s.getEnv("TRAINING_PROMOTION", "canary"),
+		TrainingCanaryPercent:  s.getEnvFloat("TRAINING_CANARY_PERCENT", 10),
+
+		NetworkStatusProvider: s.getEnv("NETWORK_STATUS_PROVIDER", networkProviderAuto),
+		NetworkStatusFile:     s.lookup("NETWORK_STATUS_FILE"),
+		NetworkProbeURL:       s.lookup("NETWORK_PROBE_URL"),
+		NetworkProbeInterval:  s.getEnvDuration("NETWORK_PROBE_INTERVAL", 30*time.Second),
+		NetworkStatus:         s.lookup("NETWORK_STATUS"),
+		NetworkStatusMaxStale: s.getEnvDuration("NETWORK_STATUS_MAX_STALE", time.Hour),
+
+		SecretsRefreshInterval: s.getEnvDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute),
+		VaultAddr:              s.lookup("VAULT_ADDR"),
+		VaultNamespace:         s.lookup("VAULT_NAMESPACE"),
+		VaultTokenFile:         s.lookup("VAULT_TOKEN_FILE"),
+		VaultRole:              s.lookup("VAULT_ROLE"),
+		VaultAuthPath:          s.getEnv("VAULT_AUTH_PATH", "kubernetes"),
+	}
+	return cfg, s.invalid
+}
+
+// defaultIf returns def when cond holds, otherwise the empty string
+func defaultIf(cond bool, def string) string {
+	if cond {
+		return def
+	}
+	return ""
+}
+
+// settings looks up configuration keys, in CONFIG_FILE first and then the
+// environment, and collects the keys whose values do not parse
+type settings struct {
+	file    map[string]string
+	invalid []string
+}
+
+func (s *settings) lookup(key string) string {
+	if v, ok := s.file[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// warn logs an unparseable value and notes the key
+func (s *settings) warn(key, v, fallback string) {
+	s.invalid = append(s.invalid, key)
+	log.Printf("Warning: invalid value for %s (%q), %s", key, v, fallback)
+}
+
+// getEnv reads a variable from the environment only
+func getEnv(key, def string) string {
+	return (&settings{}).getEnv(key, def)
+}
+
+func (s *settings) getEnv(key, def string) string {
+	if v := s.lookup(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (s *settings) getEnvInt(key string, def int) int {
+	v := s.lookup(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		s.warn(key, v, fmt.Sprintf("using default %d", def))
+		return def
+	}
+	return n
+}
+
+func (s *settings) getEnvFloat(key string, def float64) float64 {
+	v := s.lookup(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.warn(key, v, fmt.Sprintf("using default %g", def))
+		return def
+	}
+	return f
+}
+
+func (s *settings) getEnvDuration(key string, def time.Duration) time.Duration {
+	v := s.lookup(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		s.warn(key, v, fmt.Sprintf("using default %s", def))
+		return def
+	}
+	return d
+}
+
+// getEnvDate parses a YYYY-MM-DD or RFC 3339 time; unset or invalid values are nil
+func (s *settings) getEnvDate(key string) *time.Time {
+	v := s.lookup(key)
+	if v == "" {
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t
+		}
+	}
+	s.warn(key, v, "ignoring it")
+	return nil
+}
+
+// getEnvFlags parses a FEATURE_FLAGS JSON object; invalid values set no flags
+func (s *settings) getEnvFlags(key string) map[string]FeatureFlag {
+	v := s.lookup(key)
+	if v == "" {
+		return nil
+	}
+	var flags map[string]FeatureFlag
+	if err := json.Unmarshal([]byte(v), &flags); err != nil {
+		s.warn(key, v, "setting no flags")
+		return nil
+	}
+	return flags
+}
+
+func (s *settings) getEnvBool(key string, def bool) bool {
+	v := s.lookup(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.warn(key, v, fmt.Sprintf("using default %t", def))
+		return def
+	}
+	return b
+}
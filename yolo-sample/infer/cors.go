@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Browser apps served from elsewhere on the site LAN may call the API when
+// their origin is listed in CORS_ALLOWED_ORIGINS ("*" allows any origin, but
+// never with credentials). Only the API, job events and gRPC-Web routes are
+// shared; the HTML pages are not.
+
+// corsAllowedHeaders are the request headers API clients may send
+const corsAllowedHeaders = "Accept, Accept-Language, Authorization, Content-Type, Content-Encoding, Upload-Offset, X-Grpc-Web, X-User-Agent, Grpc-Timeout"
+
+// corsExposedHeaders are the response headers readable by API clients
+const corsExposedHeaders = "Content-Language, Location, Upload-Offset, Upload-Length, Grpc-Status, Grpc-Message"
+
+// corsShared reports whether path is served to cross-origin callers
+func corsShared(path string) bool {
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/events/") || strings.HasPrefix(path, grpcServicePath)
+}
+
+// corsOrigin returns the value for Access-Control-Allow-Origin, or "" when
+// origin is not allowed
+func corsOrigin(origin string) string {
+	for _, allowed := range strings.Split(config().CORSAllowedOrigins, ",") {
+		allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+		switch {
+		case allowed == "":
+			continue
+		case allowed == "*" && !config().CORSAllowCredentials:
+			return "*"
+		case strings.EqualFold(allowed, origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS adds CORS headers for allowed origins and answers preflight requests
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || config().CORSAllowedOrigins == "" || !corsShared(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allow := corsOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allow == "" {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", allow)
+		if config().CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE")
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
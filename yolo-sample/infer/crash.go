@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A panic in one handler must not take the request's connection (or, with
+// net/http's default, a confusing empty reply) down with it. withRecovery
+// catches it, answers 500 with the request's ID and writes a crash report:
+//
+//	STATE_DIR/crashes/<time>-<request id>.json   the last CRASH_REPORT_MAX (default 50)
+//	CRASH_REPORT_URL                             POSTed the same JSON, if set
+//
+// Every request gets an ID, taken from a well-formed X-Request-ID header or
+// generated, and echoed in the X-Request-ID response header so a user can
+// quote it. Reports carry the panic value and stack, a summary of the request
+// (method, path, query parameter names, remote address, user agent; never
+// bodies, cookies or credentials) and the build. They are included in the
+// /debug/dump bundle.
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// CrashReport is the JSON written for a recovered panic
+type CrashReport struct {
+	RequestID string       `json:"request_id"`
+	Time      time.Time    `json:"time"`
+	Panic     string       `json:"panic"`
+	Stack     string       `json:"stack"`
+	Request   crashRequest `json:"request"`
+	Build     BuildInfo    `json:"build"`
+	Node      string       `json:"node"`
+	Model     string       `json:"model,omitempty"`
+}
+
+type crashRequest struct {
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	QueryKeys  []string `json:"query_keys,omitempty"`
+	RemoteAddr string   `json:"remote_addr"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+}
+
+var httpPanics = newCounterVec("yolo_http_panics_total",
+	"Handler panics recovered, by route.", "route")
+
+// recoveryWriter records whether the response has started
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoveryWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoveryWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recoveryWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *recoveryWriter) FlushError() error {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withRecovery assigns the request ID and turns handler panics into a 500
+// and a crash report
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+			httpPanics.inc(routeLabel(r.URL.Path))
+			log.Printf("Warning: panic serving %s %s (request %s): %v", r.Method, r.URL.Path, id, v)
+			writeCrashReport(newCrashReport(id, r, v, stack))
+			if rw.wroteHeader {
+				// Too late for a clean error; drop the connection rather than
+				// leave the client with a truncated body it could take as whole
+				panic(http.ErrAbortHandler)
+			}
+			if wantsJSON(r) {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{
+					"error":      translate(responseLanguage(w), "Internal server error"),
+					"request_id": id,
+				})
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			renderError(w, r, fmt.Sprintf(translate(responseLanguage(w), "Internal server error (request %s)"), id))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// validRequestID accepts client-supplied IDs that are safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// wantsJSON reports whether an error for r should be JSON rather than a page
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// routeLabel keeps the metric's cardinality bounded: /api/v1/<resource> for
+// the API, the first path segment otherwise
+func routeLabel(path string) string {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	if parts[0] != "api" && len(parts) > 1 {
+		parts = parts[:1]
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func newCrashReport(id string, r *http.Request, v interface{}, stack []byte) CrashReport {
+	keys := make([]string, 0, len(r.URL.Query()))
+	for k := range r.URL.Query() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return CrashReport{
+		RequestID: id,
+		Time:      time.Now().UTC(),
+		Panic:     fmt.Sprint(v),
+		Stack:     string(stack),
+		Request: crashRequest{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			QueryKeys:  keys,
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+		},
+		Build: currentBuild(),
+		Node:  getEnv("NODE_NAME", "unknown"),
+		Model: activeModelVersion(),
+	}
+}
+
+func crashDir() string {
+	return filepath.Join(config().StateDir, "crashes")
+}
+
+// writeCrashReport stores the report, prunes old ones and forwards it to
+// CRASH_REPORT_URL in the background
+func writeCrashReport(rep CrashReport) {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		log.Printf("Warning: failed to encode crash report: %v", err)
+		return
+	}
+	dir := crashDir()
+	name := rep.Time.Format("20060102-150405.000") + "-" + rep.RequestID + ".json"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Warning: failed to save crash report: %v", err)
+	} else if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+		log.Printf("Warning: failed to save crash report: %v", err)
+	} else {
+		pruneCrashReports(dir, config().CrashReportMax)
+	}
+	if url := config().CrashReportURL; url != "" {
+		go func() {
+			resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+			if err != nil {
+				log.Printf("Warning: failed to send crash report %s: %v", rep.RequestID, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("Warning: crash report endpoint answered %s", resp.Status)
+			}
+		}()
+	}
+}
+
+// pruneCrashReports keeps the newest max reports; names sort by time
+func pruneCrashReports(dir string, max int) {
+	names := crashReportNames(dir)
+	if max <= 0 || len(names) <= max {
+		return
+	}
+	for _, name := range names[:len(names)-max] {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
+
+func crashReportNames(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events leaving the node (the EVENT_WEBHOOK_URL and MQTT sinks) are retried
+// when a delivery fails, so a WAN outage delays alerts instead of losing them.
+// A failed delivery is attempted again after DELIVERY_RETRY_BACKOFF (default
+// 2s), doubling up to DELIVERY_RETRY_MAX_BACKOFF (5m), until DELIVERY_RETRIES
+// (default 5; 0 disables retries) are used up. It then becomes a dead letter.
+// Both waiting retries and dead letters are kept in STATE_DIR/deliveries, so
+// they survive restarts; at most DEAD_LETTER_MAX (default 1000) dead letters
+// are kept, oldest dropped first.
+//
+//	GET    /api/v1/deadletters                    dead letters, newest first (?sink=webhook)
+//	GET    /api/v1/deadletters/{id}               one dead letter with its payload
+//	POST   /api/v1/deadletters/{id}/redeliver     deliver it again now
+//	POST   /api/v1/deadletters/redeliver          deliver all again (?sink=)
+//	DELETE /api/v1/deadletters/{id}               discard it
+//
+// A redelivered dead letter that fails again stays dead. Retried events can
+// arrive after later ones; receivers can order them by the CloudEvent time.
+// Webhook redeliveries go to the current EVENT_WEBHOOK_URL, MQTT ones to the
+// topic of the original message.
+
+// Delivery sinks
+const (
+	sinkWebhook = "webhook"
+	sinkMQTT    = "mqtt"
+)
+
+// Delivery is one event on its way to a sink
+type Delivery struct {
+	ID        string          `json:"id"`
+	Sink      string          `json:"sink"`
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Topic     string          `json:"topic,omitempty"` // MQTT topic
+	Body      json.RawMessage `json:"body,omitempty"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	NextTry   *time.Time      `json:"next_attempt_at,omitempty"` // unset on dead letters
+	DeadAt    *time.Time      `json:"dead_at,omitempty"`
+}
+
+type deliveryQueue struct {
+	mu     sync.Mutex
+	loaded bool
+	retry  map[string]*Delivery
+	dead   map[string]*Delivery
+}
+
+var deliveries = &deliveryQueue{retry: map[string]*Delivery{}, dead: map[string]*Delivery{}}
+
+var (
+	deliveryRetries = newCounterVec("yolo_delivery_retries_total",
+		"Delivery retries by sink and outcome.", "sink", "status")
+	deadLetterCount = newGaugeVec("yolo_dead_letters",
+		"Undelivered events kept as dead letters, by sink.", "sink")
+)
+
+// deliverySenders send a delivery to its sink once
+var deliverySenders = map[string]func(d *Delivery) error{
+	sinkWebhook: sendWebhookDelivery,
+	sinkMQTT: func(d *Delivery) error {
+		return mqtt.publish(d.Topic, d.Body, false)
+	},
+}
+
+func sendWebhookDelivery(d *Delivery) error {
+	target := config().EventWebhookURL
+	if target == "" {
+		return fmt.Errorf("EVENT_WEBHOOK_URL is not set")
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	if secret := config().EventWebhookSecret; secret != "" {
+		req.Header.Set(signatureHeader, signPayload(secret, time.Now(), d.Body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func deliveriesDir(kind string) string {
+	return filepath.Join(config().StateDir, "deliveries", kind)
+}
+
+// startDeliveryRetries loads waiting retries and dead letters and retries the
+// former as they fall due
+func startDeliveryRetries() {
+	deliveries.mu.Lock()
+	deliveries.loadLocked()
+	deliveries.mu.Unlock()
+	go func() {
+		for range time.Tick(time.Second) {
+			deliveries.retryDue()
+		}
+	}()
+}
+
+func (q *deliveryQueue) loadLocked() {
+	if q.loaded {
+		return
+	}
+	q.loaded = true
+	for kind, into := range map[string]map[string]*Delivery{"retry": q.retry, "dead": q.dead} {
+		entries, _ := os.ReadDir(deliveriesDir(kind))
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(deliveriesDir(kind), e.Name()))
+			if err != nil {
+				continue
+			}
+			var d Delivery
+			if err := json.Unmarshal(data, &d); err != nil || d.ID == "" {
+				log.Printf("Warning: ignoring corrupt delivery %s: %v", e.Name(), err)
+				continue
+			}
+			into[d.ID] = &d
+		}
+	}
+	q.countDeadLocked()
+}
+
+// send delivers an event to a sink, scheduling a retry if it fails
+func (q *deliveryQueue) send(sink string, ev Event, topic string, body []byte) error {
+	d := &Delivery{
+		ID: newID(), Sink: sink, EventID: ev.ID, EventType: ev.Type, Topic: topic,
+		Body: body, CreatedAt: time.Now().UTC(),
+	}
+	err := q.attempt(d)
+	if err != nil {
+		q.mu.Lock()
+		q.failedLocked(d, err)
+		q.mu.Unlock()
+	}
+	return err
+}
+
+func (q *deliveryQueue) attempt(d *Delivery) error {
+	send, ok := deliverySenders[d.Sink]
+	if !ok {
+		return fmt.Errorf("unknown sink %q", d.Sink)
+	}
+	d.Attempts++
+	return send(d)
+}
+
+// failedLocked schedules the next try of a failed delivery or, with retries
+// used up, turns it into a dead letter
+func (q *deliveryQueue) failedLocked(d *Delivery, err error) {
+	cfg := config()
+	d.LastError = err.Error()
+	if d.Attempts <= cfg.DeliveryRetries {
+		backoff := cfg.DeliveryRetryBackoff << (d.Attempts - 1)
+		if backoff > cfg.DeliveryRetryMaxBackoff || backoff <= 0 {
+			backoff = cfg.DeliveryRetryMaxBackoff
+		}
+		next := time.Now().UTC().Add(backoff)
+		d.NextTry = &next
+		q.retry[d.ID] = d
+		q.saveLocked("retry", d)
+		return
+	}
+	delete(q.retry, d.ID)
+	os.Remove(filepath.Join(deliveriesDir("retry"), d.ID+".json"))
+	now := time.Now().UTC()
+	d.NextTry, d.DeadAt = nil, &now
+	q.dead[d.ID] = d
+	q.saveLocked("dead", d)
+	log.Printf("Warning: %s delivery of %s event %s failed %d times, kept as dead letter %s: %v",
+		d.Sink, d.EventType, d.EventID, d.Attempts, d.ID, err)
+	q.trimDeadLocked()
+	q.countDeadLocked()
+}
+
+func (q *deliveryQueue) saveLocked(kind string, d *Delivery) {
+	data, _ := json.Marshal(d)
+	if err := writeFileAtomic(filepath.Join(deliveriesDir(kind), d.ID+".json"), data); err != nil {
+		log.Printf("Warning: failed to save delivery %s: %v", d.ID, err)
+	}
+}
+
+// retryDue retries the deliveries whose next attempt has come, outside the lock
+func (q *deliveryQueue) retryDue() {
+	now := time.Now()
+	q.mu.Lock()
+	var due []*Delivery
+	for _, d := range q.retry {
+		if d.NextTry != nil && !now.Before(*d.NextTry) {
+			d.NextTry = nil // claimed
+			due = append(due, d)
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(due, func(a, b int) bool { return due[a].CreatedAt.Before(due[b].CreatedAt) })
+	for _, d := range due {
+		err := q.attempt(d)
+		q.mu.Lock()
+		if err != nil {
+			deliveryRetries.inc(d.Sink, "error")
+			q.failedLocked(d, err)
+		} else {
+			deliveryRetries.inc(d.Sink, "success")
+			delete(q.retry, d.ID)
+			os.Remove(filepath.Join(deliveriesDir("retry"), d.ID+".json"))
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *deliveryQueue) trimDeadLocked() {
+	limit := config().DeadLetterMax
+	if len(q.dead) <= limit {
+		return
+	}
+	list := q.deadListLocked("")
+	for _, d := range list[limit:] {
+		delete(q.dead, d.ID)
+		os.Remove(filepath.Join(deliveriesDir("dead"), d.ID+".json"))
+	}
+}
+
+func (q *deliveryQueue) countDeadLocked() {
+	counts := map[string]int{sinkWebhook: 0, sinkMQTT: 0}
+	for _, d := range q.dead {
+		counts[d.Sink]++
+	}
+	for sink, n := range counts {
+		deadLetterCount.set(float64(n), sink)
+	}
+}
+
+// deadListLocked returns the dead letters of sink (all if empty), newest first
+func (q *deliveryQueue) deadListLocked(sink string) []*Delivery {
+	list := make([]*Delivery, 0, len(q.dead))
+	for _, d := range q.dead {
+		if sink == "" || d.Sink == sink {
+			list = append(list, d)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].DeadAt.After(*list[b].DeadAt) })
+	return list
+}
+
+// redeliver sends a dead letter again, removing it if that succeeds
+func (q *deliveryQueue) redeliver(id string) (*Delivery, error) {
+	q.mu.Lock()
+	d, ok := q.dead[id]
+	q.mu.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	err := q.attempt(d)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		d.LastError = err.Error()
+		q.saveLocked("dead", d)
+		return d, err
+	}
+	delete(q.dead, id)
+	os.Remove(filepath.Join(deliveriesDir("dead"), id+".json"))
+	q.countDeadLocked()
+	log.Printf("Redelivered dead letter %s (%s event %s)", id, d.EventType, d.EventID)
+	return d, nil
+}
+
+func (q *deliveryQueue) discard(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.dead[id]; !ok {
+		return false
+	}
+	delete(q.dead, id)
+	os.Remove(filepath.Join(deliveriesDir("dead"), id+".json"))
+	q.countDeadLocked()
+	return true
+}
+
+// deadLettersHandler serves the dead letter API
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/deadletters"), "/"), "/")
+	sink := r.URL.Query().Get("sink")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		deliveries.mu.Lock()
+		list := deliveries.deadListLocked(sink)
+		views := make([]Delivery, len(list))
+		for i, d := range list {
+			views[i] = *d
+			views[i].Body = nil
+		}
+		deliveries.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dead_letters": views, "count": len(views)})
+
+	case len(parts) == 1 && parts[0] == "redeliver" && r.Method == http.MethodPost:
+		deliveries.mu.Lock()
+		list := deliveries.deadListLocked(sink)
+		deliveries.mu.Unlock()
+		delivered, failed := 0, 0
+		for i := len(list) - 1; i >= 0; i-- {
+			if _, err := deliveries.redeliver(list[i].ID); err != nil {
+				failed++
+			} else {
+				delivered++
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"delivered": delivered, "failed": failed})
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		deliveries.mu.Lock()
+		d, ok := deliveries.dead[parts[0]]
+		var view Delivery
+		if ok {
+			view = *d
+		}
+		deliveries.mu.Unlock()
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Dead letter not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if !deliveries.discard(parts[0]) {
+			writeJSONError(w, http.StatusNotFound, "Dead letter not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "redeliver" && r.Method == http.MethodPost:
+		d, err := deliveries.redeliver(parts[0])
+		if os.IsNotExist(err) {
+			writeJSONError(w, http.StatusNotFound, "Dead letter not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, "Redelivery failed: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": d.ID, "status": "delivered"})
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A model artifact can carry binary deltas next to the full model, so a node
+// on a slow link downloads only what changed between versions. A delta is a
+// layer titled <model file>.bsdiff, made with bsdiff (BSDIFF40 format) from an
+// earlier model layer, whose digest it names in an annotation:
+//
+//	oras push ghcr.io/acme/models/yolo:v4 production.pt \
+//	    production.pt.bsdiff:application/vnd.quietstorm.model.bsdiff \
+//	    --annotation-file deltas.json  # {"production.pt.bsdiff": {"io.quietstorm.model.delta-base": "sha256:..."}}
+//
+// When the model layer is not in the blob cache but the base of one of its
+// deltas is, the delta is downloaded and applied to the cached base. The
+// patched file must match the model layer's size and digest before it enters
+// the cache; otherwise, or when no delta applies, the full layer is downloaded
+// as before. zstd-dictionary patches are not supported.
+
+// deltaBaseAnnotation names the layer digest a delta applies to
+const deltaBaseAnnotation = "io.quietstorm.model.delta-base"
+
+// deltaExt is the title suffix of delta layers
+const deltaExt = ".bsdiff"
+
+// bsdiffMaxOutput bounds the size of a patched model
+const bsdiffMaxOutput = 4 << 30
+
+var deltaBytesSaved = newCounterVec("yolo_model_delta_bytes_saved_total",
+	"Bytes not downloaded thanks to model deltas.", "model")
+
+// fetchModelBlob puts the model layer into the blob cache, from a delta
+// against a cached layer when one applies, else by downloading it in full
+func (c *registryClient) fetchModelBlob(version string, model ociDescriptor, deltas []ociDescriptor) (string, error) {
+	if fi, err := os.Stat(ociBlobPath(model.Digest)); err == nil && fi.Size() == model.Size {
+		return "", nil
+	}
+	// A sibling on the LAN beats a delta over the WAN
+	if fetchBlobFromPeers(model) {
+		return "", nil
+	}
+	for _, d := range deltas {
+		base := d.Annotations[deltaBaseAnnotation]
+		if !ociDigest.MatchString(base) || base == model.Digest {
+			continue
+		}
+		if _, err := os.Stat(ociBlobPath(base)); err != nil {
+			continue
+		}
+		if d.Size >= model.Size {
+			continue
+		}
+		err := c.applyDelta(model, d, base)
+		if err == nil {
+			modelPulls.inc("delta")
+			deltaBytesSaved.add(float64(model.Size-d.Size), version)
+			log.Printf("Patched model %s from %s with a %d byte delta (%d bytes saved)", version, base, d.Size, model.Size-d.Size)
+			return base, nil
+		}
+		log.Printf("Warning: model %s delta against %s failed, downloading in full: %v", version, base, err)
+	}
+	return "", c.fetchBlob(model)
+}
+
+// applyDelta downloads delta d, patches the cached base blob with it and
+// stores the result as the model blob if it matches its digest
+func (c *registryClient) applyDelta(model, d ociDescriptor, base string) error {
+	if err := c.fetchBlob(d); err != nil {
+		return err
+	}
+	patch, err := os.ReadFile(ociBlobPath(d.Digest))
+	if err != nil {
+		return err
+	}
+	old, err := os.ReadFile(ociBlobPath(base))
+	if err != nil {
+		return err
+	}
+	patched, err := bspatch(old, patch)
+	if err != nil {
+		return err
+	}
+	if int64(len(patched)) != model.Size {
+		return fmt.Errorf("patched model is %d bytes, manifest says %d", len(patched), model.Size)
+	}
+	sum := sha256.Sum256(patched)
+	if "sha256:"+hex.EncodeToString(sum[:]) != model.Digest {
+		return fmt.Errorf("patched model does not match digest %s", model.Digest)
+	}
+	path := ociBlobPath(model.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, patched)
+}
+
+// isDeltaLayer reports whether a layer title names a model delta
+func isDeltaLayer(title string) bool {
+	return strings.HasSuffix(strings.ToLower(title), deltaExt)
+}
+
+// bspatch applies a BSDIFF40 patch: a 32 byte header (magic, control and diff
+// block lengths, new size) followed by three bzip2 blocks. The control block is
+// a list of (diff length, extra length, seek) triples; diff bytes are added to
+// the old file, extra bytes copied as they are.
+func bspatch(old, patch []byte) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != "BSDIFF40" {
+		return nil, fmt.Errorf("not a BSDIFF40 patch")
+	}
+	ctrlLen, diffLen, newSize := offtin(patch[8:16]), offtin(patch[16:24]), offtin(patch[24:32])
+	// Each length is checked against what is left, so no sum can overflow
+	body := int64(len(patch)) - 32
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || newSize > bsdiffMaxOutput ||
+		ctrlLen > body || diffLen > body-ctrlLen {
+		return nil, fmt.Errorf("corrupt patch header")
+	}
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[32 : 32+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen : 32+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen+diffLen:]))
+
+	out := make([]byte, newSize)
+	var oldPos, newPos int64
+	var triple [24]byte
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return nil, fmt.Errorf("corrupt patch control block: %v", err)
+		}
+		add, copyLen, seek := offtin(triple[0:8]), offtin(triple[8:16]), offtin(triple[16:24])
+		if add < 0 || copyLen < 0 || add > newSize-newPos {
+			return nil, fmt.Errorf("corrupt patch")
+		}
+		if _, err := io.ReadFull(diff, out[newPos:newPos+add]); err != nil {
+			return nil, fmt.Errorf("corrupt patch diff block: %v", err)
+		}
+		for i := int64(0); i < add; i++ {
+			if p := oldPos + i; p >= 0 && p < int64(len(old)) {
+				out[newPos+i] += old[p]
+			}
+		}
+		newPos += add
+		oldPos += add
+		if copyLen > newSize-newPos {
+			return nil, fmt.Errorf("corrupt patch")
+		}
+		if _, err := io.ReadFull(extra, out[newPos:newPos+copyLen]); err != nil {
+			return nil, fmt.Errorf("corrupt patch extra block: %v", err)
+		}
+		newPos += copyLen
+		oldPos += seek
+	}
+	return out, nil
+}
+
+// offtin decodes bsdiff's sign-magnitude little-endian 64-bit integers
+func offtin(b []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
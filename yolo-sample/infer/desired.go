@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instead of a fleet tool calling every node's API and hoping the calls
+// stick, a node can be told what it should run and converge to it by itself.
+// DESIRED_STATE_SOURCE names where the desired state is declared:
+//
+//	/etc/yolo/desired                                  a file, or a mounted ConfigMap with a desired-state.json key
+//	configmap:[namespace/]name                         the desired-state.json key of a ConfigMap, read with kubectl
+//	crd:resource/[namespace/]name                      the spec of a custom resource, e.g. crd:edgenodes.yolo.quietstorm.io/dock-1
+//	https://git.example.com/fleet/raw/main/dock-1.json a file served over HTTP, such as the raw view of a Git repository
+//	git+https://git.example.com/fleet.git#main:nodes/dock-1.json  a file of a Git branch, fetched with git
+//
+// The document, every field of which is optional:
+//
+//	{"revision": "42", "model": "v4", "settings": {"INFERENCE_THRESHOLD": "0.4"},
+//	 "config_hash": "9c1e...", "rules": [{"name": "person-seen", "label": "person"}]}
+//
+// The reconcile task (SCHEDULE_RECONCILE, every minute when a source is set)
+// reads it and, when it changed, layers its settings over CONFIG_FILE and the
+// fleet bundle through a configuration reload, so they are validated the
+// same way; its rules replace those of ALERT_RULES. A document that fails
+// is not kept. Every run then converges the node: model is made the active
+// model, pulled from its MODEL_OCI_REFS entry if it is not on the node, which
+// also undoes a promotion made through the API meanwhile. config_hash pins
+// the hash of all the settings the node should run with (reported as
+// config_hash in the status), catching local settings the document does not
+// name. HTTP and Git sources are only read while the node is online; offline,
+// the node keeps converging to the document it has.
+//
+// What cannot be converged, such as a setting that needs a restart or a model
+// that cannot be pulled, is drift, reported in a Kubernetes-style Synced
+// condition:
+//
+//	GET /api/v1/desired-state  the desired state, what the node runs, drift and the condition
+//
+// A change of the condition is published as desired.status and, for a crd:
+// source, patched into the status of the resource. The applied document is
+// kept in STATE_DIR/desired-state.json and used again at startup.
+
+// desiredStateKey is the ConfigMap key, or file in a directory, holding the document
+const desiredStateKey = "desired-state.json"
+
+// desiredMaxBytes bounds the size of a desired state document
+const desiredMaxBytes = 1 << 20
+
+// DesiredState is the document of DESIRED_STATE_SOURCE
+type DesiredState struct {
+	Revision   string            `json:"revision,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	Settings   map[string]string `json:"settings,omitempty"`
+	ConfigHash string            `json:"config_hash,omitempty"`
+	Rules      []AlertRule       `json:"rules,omitempty"` // absent leaves ALERT_RULES in effect; [] removes all rules
+}
+
+// DesiredDrift is a part of the desired state the node does not match
+type DesiredDrift struct {
+	Field   string `json:"field"` // "model", "settings.<KEY>", "config_hash" or "rules"
+	Desired string `json:"desired"`
+	Actual  string `json:"actual,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DesiredCondition is the Synced condition; it is camel-cased as it is
+// also written to the status of a custom resource
+type DesiredCondition struct {
+	Type               string    `json:"type"`   // "Synced"
+	Status             string    `json:"status"` // "True", "False" or "Unknown"
+	Reason             string    `json:"reason"` // "Converged", "Drifted", "Rejected" or "SourceUnavailable"
+	Message            string    `json:"message,omitempty"`
+	ObservedRevision   string    `json:"observedRevision,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// DesiredStateView is returned by /api/v1/desired-state and is the payload
+// of desired.status
+type DesiredStateView struct {
+	Source     string            `json:"source,omitempty"`
+	Desired    *DesiredState     `json:"desired,omitempty"`
+	Model      string            `json:"model"`       // the active model
+	ConfigHash string            `json:"config_hash"` // of the settings in effect
+	Condition  *DesiredCondition `json:"condition,omitempty"`
+	Drift      []DesiredDrift    `json:"drift,omitempty"`
+	Corrected  []string          `json:"corrected,omitempty"` // fields the last run put back
+	LastCheck  *time.Time        `json:"last_check,omitempty"`
+	LastError  string            `json:"last_error,omitempty"`
+}
+
+// desiredSource is a parsed DESIRED_STATE_SOURCE
+type desiredSource struct {
+	kind      string // "file", "configmap", "crd", "http" or "git"
+	path      string // file: path; git: file in the repository
+	namespace string
+	resource  string // crd: the resource type
+	name      string
+	url       string // http, git: the URL; git without "git+"
+	ref       string // git: branch or tag
+}
+
+type desiredStateStore struct {
+	mu        sync.Mutex
+	loaded    bool
+	data      []byte // the applied document
+	state     *DesiredState
+	etag      string
+	condition *DesiredCondition
+	drift     []DesiredDrift
+	corrected []string
+	lastCheck time.Time
+	lastErr   error
+	reported  []byte // status last patched into a custom resource
+}
+
+var desired = &desiredStateStore{}
+
+var desiredClient = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	desiredDrift = newGaugeVec("yolo_desired_state_drift",
+		"Fields of the desired state the node has not converged to.")
+	desiredCorrections = newCounterVec("yolo_desired_state_corrections_total",
+		"Fields put back to their desired state, by field.", "field")
+)
+
+func desiredStatePath(stateDir string) string {
+	return filepath.Join(stateDir, "desired-state.json")
+}
+
+// parseDesiredSource parses a DESIRED_STATE_SOURCE; namespace is the default
+// namespace of configmap: and crd: sources
+func parseDesiredSource(src, namespace string) (desiredSource, error) {
+	withNamespace := func(ref string) (string, string) {
+		if ns, name, ok := strings.Cut(ref, "/"); ok {
+			return ns, name
+		}
+		return namespace, ref
+	}
+	switch {
+	case strings.HasPrefix(src, "configmap:"):
+		ns, name := withNamespace(strings.TrimPrefix(src, "configmap:"))
+		if name == "" {
+			return desiredSource{}, errors.New("want configmap:[namespace/]name")
+		}
+		return desiredSource{kind: "configmap", namespace: ns, name: name}, nil
+	case strings.HasPrefix(src, "crd:"):
+		resource, ref, _ := strings.Cut(strings.TrimPrefix(src, "crd:"), "/")
+		ns, name := withNamespace(ref)
+		if resource == "" || name == "" {
+			return desiredSource{}, errors.New("want crd:resource/[namespace/]name")
+		}
+		return desiredSource{kind: "crd", resource: resource, namespace: ns, name: name}, nil
+	case strings.HasPrefix(src, "git+"):
+		repo, fragment, _ := strings.Cut(strings.TrimPrefix(src, "git+"), "#")
+		ref, path, ok := strings.Cut(fragment, ":")
+		if repo == "" || !ok || ref == "" || path == "" {
+			return desiredSource{}, errors.New("want git+<repository URL>#<branch>:<path>")
+		}
+		return desiredSource{kind: "git", url: repo, ref: ref, path: path}, nil
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		return desiredSource{kind: "http", url: src}, nil
+	default:
+		return desiredSource{kind: "file", path: src}, nil
+	}
+}
+
+// remote reports whether reading the source needs the network
+func (s desiredSource) remote() bool {
+	return s.kind == "http" || s.kind == "git"
+}
+
+// kubectlArgs adds the namespace of the source to kubectl arguments
+func (s desiredSource) kubectlArgs(args ...string) []string {
+	if s.namespace != "" {
+		args = append(args, "-n", s.namespace)
+	}
+	return args
+}
+
+// parseDesiredState reads and checks a desired state document
+func parseDesiredState(data []byte) (*DesiredState, error) {
+	var st DesiredState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+	if strings.ContainsAny(st.Model, `/\`) {
+		return nil, fmt.Errorf("model must be a model version name")
+	}
+	for key := range st.Settings {
+		if !settingKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid setting name %q", key)
+		}
+		if contains(fleetOnlySettings, key) {
+			return nil, fmt.Errorf("%s cannot be set by the desired state", key)
+		}
+	}
+	if err := checkAlertRules(st.Rules); err != nil {
+		return nil, fmt.Errorf("rules: %v", err)
+	}
+	if st.Revision == "" {
+		sum := sha256.Sum256(data)
+		st.Revision = hex.EncodeToString(sum[:6])
+	}
+	return &st, nil
+}
+
+// withDesiredSettings layers the settings of the applied desired state over
+// file, reading the stored document at startup. Without
+// DESIRED_STATE_SOURCE the document is ignored.
+func withDesiredSettings(file map[string]string) map[string]string {
+	s := &settings{file: file}
+	desired.mu.Lock()
+	defer desired.mu.Unlock()
+	if !desired.loaded {
+		desired.loaded = true
+		path := desiredStatePath(s.getEnv("STATE_DIR", defaultStateDir()))
+		if data, err := os.ReadFile(path); err == nil {
+			st, err := parseDesiredState(data)
+			if err != nil {
+				log.Printf("Warning: ignoring stored desired state %s: %v", path, err)
+			} else {
+				desired.data, desired.state = data, st
+			}
+		}
+	}
+	if desired.state == nil || len(desired.state.Settings) == 0 || s.lookup("DESIRED_STATE_SOURCE") == "" {
+		return file
+	}
+	merged := make(map[string]string, len(file)+len(desired.state.Settings))
+	for k, v := range file {
+		merged[k] = v
+	}
+	for k, v := range desired.state.Settings {
+		merged[k] = v
+	}
+	return merged
+}
+
+// desiredRules returns the alert rules of the desired state, if it has any
+func desiredRules(cfg *Config) ([]AlertRule, bool) {
+	desired.mu.Lock()
+	defer desired.mu.Unlock()
+	if desired.state == nil || desired.state.Rules == nil || cfg.DesiredStateSource == "" {
+		return nil, false
+	}
+	return desired.state.Rules, true
+}
+
+// settingsHash identifies a set of settings independently of their order
+func settingsHash(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, settings[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// runKubectl runs kubectl and returns its output
+func runKubectl(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// fetch reads the document of src; it returns the applied document when an
+// HTTP source has not changed
+func (d *desiredStateStore) fetch(src desiredSource, stateDir string) ([]byte, error) {
+	switch src.kind {
+	case "configmap":
+		out, err := runKubectl(src.kubectlArgs("get", "configmap", src.name,
+			"-o", "jsonpath={.data."+strings.ReplaceAll(desiredStateKey, ".", `\.`)+"}")...)
+		if err == nil && len(bytes.TrimSpace(out)) == 0 {
+			err = fmt.Errorf("configmap %s has no key %s", src.name, desiredStateKey)
+		}
+		return out, err
+	case "crd":
+		out, err := runKubectl(src.kubectlArgs("get", src.resource, src.name, "-o", "jsonpath={.spec}")...)
+		if err == nil && len(bytes.TrimSpace(out)) == 0 {
+			err = fmt.Errorf("%s %s has no spec", src.resource, src.name)
+		}
+		return out, err
+	case "git":
+		return fetchGitFile(src, filepath.Join(stateDir, "desired-repo"))
+	case "http":
+		return d.fetchHTTP(src.url)
+	}
+	path := src.path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, desiredStateKey)
+	}
+	return os.ReadFile(path)
+}
+
+func (d *desiredStateStore) fetchHTTP(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	etag, current := d.etag, d.data
+	d.mu.Unlock()
+	if etag != "" && current != nil {
+		req.Header.Set("If-None-Match", etag)
+	}
+	req.Header.Set("X-Node-Name", getEnv("NODE_NAME", "unknown"))
+	resp, err := desiredClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return current, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("desired state source returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, desiredMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > desiredMaxBytes {
+		return nil, fmt.Errorf("desired state exceeds %d bytes", desiredMaxBytes)
+	}
+	d.mu.Lock()
+	d.etag = resp.Header.Get("ETag")
+	d.mu.Unlock()
+	return data, nil
+}
+
+// fetchGitFile fetches the branch of src shallowly into the repository at
+// dir and returns its file
+func fetchGitFile(src desiredSource, dir string) ([]byte, error) {
+	git := func(args ...string) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if _, err := git("init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := git("fetch", "-q", "--depth", "1", src.url, src.ref); err != nil {
+		return nil, err
+	}
+	return git("show", "FETCH_HEAD:"+src.path)
+}
+
+// reconcileDesiredState reads DESIRED_STATE_SOURCE and converges the node to it
+func reconcileDesiredState() error {
+	cfg := config()
+	if cfg.DesiredStateSource == "" {
+		return fmt.Errorf("DESIRED_STATE_SOURCE is not configured")
+	}
+	err := desired.reconcile(cfg)
+	desired.mu.Lock()
+	desired.lastCheck, desired.lastErr = time.Now().UTC(), err
+	desired.mu.Unlock()
+	return err
+}
+
+func (d *desiredStateStore) reconcile(cfg *Config) error {
+	src, err := parseDesiredSource(cfg.DesiredStateSource, cfg.Namespace)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if !src.remote() || getNodeStatus().NetworkStatus == "online" {
+		if data, err = d.fetch(src, cfg.StateDir); err != nil {
+			err = fmt.Errorf("cannot read desired state: %v", err)
+		}
+	}
+	d.mu.Lock()
+	current, revision := d.data, ""
+	if d.state != nil {
+		revision = d.state.Revision
+	}
+	d.mu.Unlock()
+	if err != nil && current == nil {
+		d.setCondition(src, "Unknown", "SourceUnavailable", err.Error(), "")
+		return err
+	}
+	fetchErr := err
+
+	if data != nil && !bytes.Equal(data, current) {
+		st, err := parseDesiredState(data)
+		if err == nil {
+			err = d.apply(cfg, data, st)
+		}
+		if err != nil {
+			d.setCondition(src, "False", "Rejected", err.Error(), revision)
+			return fmt.Errorf("desired state not applied: %v", err)
+		}
+		log.Printf("Applied desired state revision %s", st.Revision)
+	}
+
+	d.mu.Lock()
+	st := d.state
+	d.mu.Unlock()
+	corrected := convergeDesiredState(st)
+	drift := observeDrift(st)
+	desiredDrift.set(float64(len(drift)))
+
+	d.mu.Lock()
+	d.drift, d.corrected = drift, corrected
+	d.mu.Unlock()
+	switch {
+	case len(drift) > 0:
+		var parts []string
+		for _, dr := range drift {
+			parts = append(parts, dr.Field+": "+dr.Reason)
+		}
+		d.setCondition(src, "False", "Drifted", strings.Join(parts, "; "), st.Revision)
+	case fetchErr != nil:
+		d.setCondition(src, "True", "SourceUnavailable", fetchErr.Error()+"; converged to the last desired state read", st.Revision)
+	default:
+		d.setCondition(src, "True", "Converged", "", st.Revision)
+	}
+	return fetchErr
+}
+
+// apply puts a new document in effect through a configuration reload and
+// stores it; on failure the previous one stays in effect
+func (d *desiredStateStore) apply(cfg *Config, data []byte, st *DesiredState) error {
+	d.mu.Lock()
+	prevData, prevState := d.data, d.state
+	d.data, d.state = data, st
+	d.mu.Unlock()
+
+	err := reloader.reload("desired")
+	if err == nil {
+		err = writeFileAtomic(desiredStatePath(cfg.StateDir), data)
+	}
+	if err != nil {
+		d.mu.Lock()
+		d.data, d.state = prevData, prevState
+		d.mu.Unlock()
+		// Put the previous settings and rules back
+		reloader.reload("desired")
+	}
+	return err
+}
+
+// convergeDesiredState makes the desired model active, returning the fields
+// it put back
+func convergeDesiredState(st *DesiredState) []string {
+	if st == nil || st.Model == "" || activeModelVersion() == st.Model {
+		return nil
+	}
+	if !modelExists(st.Model) {
+		refs, err := parseModelRefs(config().ModelOCIRefs)
+		if err != nil || refs[st.Model].registry == "" || getNodeStatus().NetworkStatus != "online" {
+			return nil
+		}
+		if err := pullModel(st.Model, refs[st.Model]); err != nil {
+			log.Printf("Warning: cannot pull desired model %s: %v", st.Model, err)
+			return nil
+		}
+	}
+	previous := activeModelVersion()
+	if err := setActiveModelVersion(st.Model); err != nil {
+		log.Printf("Warning: cannot activate desired model %s: %v", st.Model, err)
+		return nil
+	}
+	log.Printf("Desired state: switched the active model from %s to %s", previous, st.Model)
+	desiredCorrections.inc("model")
+	return []string{"model"}
+}
+
+// observeDrift compares the node with the desired state
+func observeDrift(st *DesiredState) []DesiredDrift {
+	if st == nil {
+		return nil
+	}
+	var drift []DesiredDrift
+	if active := activeModelVersion(); st.Model != "" && active != st.Model {
+		reason := "cannot be activated"
+		if !modelExists(st.Model) {
+			reason = "not on the node and cannot be pulled"
+		}
+		drift = append(drift, DesiredDrift{Field: "model", Desired: st.Model, Actual: active, Reason: reason})
+	}
+
+	reloader.mu.Lock()
+	inEffect, pending, reloadErr := reloader.settings, reloader.status.PendingRestart, reloader.status.Error
+	reloader.mu.Unlock()
+	keys := make([]string, 0, len(st.Settings))
+	for k := range st.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch {
+		case inEffect[k] != st.Settings[k]:
+			drift = append(drift, DesiredDrift{Field: "settings." + k, Desired: st.Settings[k], Actual: inEffect[k],
+				Reason: "not applied: " + reloadErr})
+		case contains(pending, k):
+			drift = append(drift, DesiredDrift{Field: "settings." + k, Desired: st.Settings[k], Reason: "needs a restart"})
+		}
+	}
+	if hash := settingsHash(inEffect); st.ConfigHash != "" && hash != st.ConfigHash {
+		drift = append(drift, DesiredDrift{Field: "config_hash", Desired: st.ConfigHash, Actual: hash,
+			Reason: "the settings in effect differ from the desired configuration"})
+	}
+
+	if st.Rules != nil {
+		alerting.Lock()
+		rules := alerting.rules
+		alerting.Unlock()
+		if !reflect.DeepEqual(rules, st.Rules) && (len(rules) > 0 || len(st.Rules) > 0) {
+			drift = append(drift, DesiredDrift{Field: "rules", Desired: fmt.Sprintf("%d rules", len(st.Rules)),
+				Actual: fmt.Sprintf("%d rules", len(rules)), Reason: "not applied: " + reloadErr})
+		}
+	}
+	return drift
+}
+
+// setCondition updates the Synced condition, publishing it and writing it
+// to the status of a custom resource when it changes
+func (d *desiredStateStore) setCondition(src desiredSource, status, reason, message, revision string) {
+	d.mu.Lock()
+	prev := d.condition
+	cond := &DesiredCondition{Type: "Synced", Status: status, Reason: reason, Message: message,
+		ObservedRevision: revision, LastTransitionTime: time.Now().UTC()}
+	if prev != nil && prev.Status == status {
+		cond.LastTransitionTime = prev.LastTransitionTime
+	}
+	d.condition = cond
+	d.mu.Unlock()
+	if prev != nil && *prev == *cond {
+		return
+	}
+	if prev == nil || prev.Status != status {
+		log.Printf("Desired state: Synced is %s (%s) %s", status, reason, message)
+	}
+	view := d.view()
+	bus.publish(eventDesiredState, view)
+	if src.kind == "crd" {
+		d.reportStatus(src, view)
+	}
+}
+
+// reportStatus patches the condition into the status of the custom resource
+func (d *desiredStateStore) reportStatus(src desiredSource, view DesiredStateView) {
+	patch, _ := json.Marshal(map[string]interface{}{"status": map[string]interface{}{
+		"conditions":       []DesiredCondition{*view.Condition},
+		"observedRevision": view.Condition.ObservedRevision,
+		"activeModel":      view.Model,
+		"configHash":       view.ConfigHash,
+		"drift":            view.Drift,
+	}})
+	d.mu.Lock()
+	unchanged := bytes.Equal(patch, d.reported)
+	d.mu.Unlock()
+	if unchanged {
+		return
+	}
+	if _, err := runKubectl(src.kubectlArgs("patch", src.resource, src.name,
+		"--subresource=status", "--type=merge", "-p", string(patch))...); err != nil {
+		log.Printf("Warning: cannot write the status of %s %s: %v", src.resource, src.name, err)
+		return
+	}
+	d.mu.Lock()
+	d.reported = patch
+	d.mu.Unlock()
+}
+
+func (d *desiredStateStore) view() DesiredStateView {
+	reloader.mu.Lock()
+	hash := settingsHash(reloader.settings)
+	reloader.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := DesiredStateView{Source: effectiveConfig().DesiredStateSource, Model: activeModelVersion(), ConfigHash: hash,
+		Drift: d.drift, Corrected: d.corrected}
+	if d.state != nil && v.Source != "" {
+		st := *d.state
+		v.Desired = &st
+	}
+	if d.condition != nil {
+		c := *d.condition
+		v.Condition = &c
+	}
+	if !d.lastCheck.IsZero() {
+		t := d.lastCheck
+		v.LastCheck = &t
+	}
+	if d.lastErr != nil {
+		v.LastError = d.lastErr.Error()
+	}
+	return v
+}
+
+// desiredStateHandler serves GET /api/v1/desired-state
+func desiredStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, desired.view())
+}
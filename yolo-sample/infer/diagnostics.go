@@ -0,0 +1,199 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+// Runtime diagnostics for remote support are served on a separate admin
+// listener, ADMIN_ADDR (e.g. 127.0.0.1:6768; unset disables it), never on the
+// main port:
+//
+//	/debug/pprof/   net/http/pprof profiles (goroutine, heap, profile, trace, ...)
+//	/debug/vars     expvar: memstats, cmdline and the node's state
+//	/debug/dump     a .tar.gz bundle for a support ticket: goroutine stacks,
+//	                heap profile, recent log lines, the effective configuration
+//	                (credentials redacted), worker, clock and job queue state
+//	                and the kept crash reports (see crash.go)
+//	/admin/         diagnostic actions for a browser (see admin.go)
+//
+// With ADMIN_TOKEN set every request needs "Authorization: Bearer <token>";
+// a token is required unless ADMIN_ADDR binds a loopback address (reach it
+// with kubectl port-forward or ssh). The bundle includes the log entries
+// kept in memory (see logs.go).
+
+// startAdmin captures log output and serves the admin listener, if configured
+func startAdmin() {
+	if n := config().LogBufferLines; n > 0 {
+		recentLogs.entries = make([]LogEntry, n)
+	}
+	log.SetOutput(secretScrubber{io.MultiWriter(os.Stderr, recentLogs)})
+	addr := config().AdminAddr
+	if addr == "" {
+		return
+	}
+	if err := validateAdmin(config()); err != nil {
+		log.Printf("Warning: not serving diagnostics: %v", err)
+		return
+	}
+	expvar.Publish("worker", expvar.Func(func() interface{} { return worker.view() }))
+	expvar.Publish("clock", expvar.Func(func() interface{} { return clockView() }))
+	expvar.Publish("build", expvar.Func(func() interface{} { return currentBuild() }))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/dump", debugDumpHandler)
+	registerAdminPage(mux)
+	go func() {
+		log.Printf("Serving diagnostics on %s", addr)
+		if err := http.ListenAndServe(addr, withForwarded(withSecurityHeaders(withSession(withCSRF(withAdminAuth(mux)))))); err != nil {
+			log.Printf("Warning: admin listener on %s stopped: %v", addr, err)
+		}
+	}()
+}
+
+// withAdminAuth requires ADMIN_TOKEN when one is set; the admin page sends
+// browsers to its sign-in form instead
+func withAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) && r.URL.Path != "/admin/login" {
+			if r.URL.Path == "/admin/" || r.URL.Path == "/admin" {
+				redirectTo(w, r, "/admin/login", http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withoutDebug hides the /debug/ handlers net/http/pprof and expvar register
+// on the default mux from the main listener
+func withoutDebug(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/") {
+			writeJSONError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateAdmin refuses an admin listener reachable off the node without a token
+func validateAdmin(cfg *Config) error {
+	if cfg.AdminAddr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(cfg.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("ADMIN_ADDR must be host:port: %v", err)
+	}
+	if ip := net.ParseIP(host); (ip == nil || !ip.IsLoopback()) && host != "localhost" && cfg.AdminToken == "" {
+		return fmt.Errorf("ADMIN_ADDR %s is not a loopback address and needs ADMIN_TOKEN", cfg.AdminAddr)
+	}
+	return nil
+}
+
+// debugDumpHandler serves GET /debug/dump as a .tar.gz support bundle
+func debugDumpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	now := time.Now().UTC()
+	node := getEnv("NODE_NAME", "unknown")
+	name := fmt.Sprintf("yolo-dump-%s-%s", node, now.Format("20060102-150405"))
+
+	files := []struct {
+		name string
+		data func() []byte
+	}{
+		{"goroutines.txt", func() []byte { return profileBytes("goroutine", 2) }},
+		{"heap.pprof", func() []byte { return profileBytes("heap", 0) }},
+		{"logs.txt", recentLogs.snapshot},
+		{"config.json", func() []byte { return dumpJSON(effectiveConfig()) }},
+		{"state.json", func() []byte {
+			status := getNodeStatus()
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+			return dumpJSON(map[string]interface{}{
+				"node":           node,
+				"time":           now,
+				"build":          currentBuild(),
+				"goroutines":     runtime.NumGoroutine(),
+				"heap_bytes":     mem.HeapAlloc,
+				"network_status": status.NetworkStatus,
+				"backend":        status.Backend,
+				"model":          activeModelVersion(),
+				"worker":         worker.view(),
+				"clock":          clockView(),
+				"reload":         reloader.view(),
+				"upload_queue":   len(jobs.queue),
+			})
+		}},
+	}
+
+	for _, crash := range crashReportNames(crashDir()) {
+		path := filepath.Join(crashDir(), crash)
+		files = append(files, struct {
+			name string
+			data func() []byte
+		}{"crashes/" + crash, func() []byte { data, _ := os.ReadFile(path); return data }})
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.tar.gz"`)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		data := f.data()
+		hdr := &tar.Header{Name: name + "/" + f.name, Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return
+		}
+		if _, err := tw.Write(data); err != nil {
+			return
+		}
+	}
+	tw.Close()
+	gz.Close()
+	log.Printf("Served diagnostics dump %s to %s", name, r.RemoteAddr)
+}
+
+func profileBytes(name string, debug int) []byte {
+	var buf bytes.Buffer
+	if p := runtimepprof.Lookup(name); p != nil {
+		p.WriteTo(&buf, debug)
+	}
+	return buf.Bytes()
+}
+
+func dumpJSON(v interface{}) []byte {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return data
+}
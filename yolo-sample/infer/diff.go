@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// GET /api/v1/results/diff?a={id}&b={id} compares two results, typically
+// the same scene before and after (an inspection, a shelf at opening and
+// closing). Detections of the same class are paired by the Hungarian
+// algorithm, maximizing their total IoU; pairs overlapping less than
+// min_iou (default 0.1) are not the same object. A pair overlapping at least
+// moved_iou (default 0.8) is unchanged, otherwise the object moved, by the
+// shift of its box's center. Detections of a left unpaired were removed,
+// those of b added.
+
+// DiffMatch is an object found in both results
+type DiffMatch struct {
+	Class string    `json:"class"`
+	A     Detection `json:"a"`
+	B     Detection `json:"b"`
+	IoU   float64   `json:"iou"`
+	DX    float64   `json:"dx"` // shift of the box center, in pixels
+	DY    float64   `json:"dy"`
+}
+
+// ResultDiff is the comparison of two results
+type ResultDiff struct {
+	A         string      `json:"a"`
+	B         string      `json:"b"`
+	MinIoU    float64     `json:"min_iou"`
+	MovedIoU  float64     `json:"moved_iou"`
+	Unchanged []DiffMatch `json:"unchanged"`
+	Moved     []DiffMatch `json:"moved"`
+	Added     []Detection `json:"added"`
+	Removed   []Detection `json:"removed"`
+}
+
+// diffResults pairs the detections of a and b
+func diffResults(a, b InferenceResult, minIoU, movedIoU float64) ResultDiff {
+	d := ResultDiff{A: a.ID, B: b.ID, MinIoU: minIoU, MovedIoU: movedIoU,
+		Unchanged: []DiffMatch{}, Moved: []DiffMatch{}, Added: []Detection{}, Removed: []Detection{}}
+
+	pairA, pairB := map[int]bool{}, map[int]bool{}
+	for _, p := range hungarianMatch(a.Detections, b.Detections, minIoU) {
+		da, db := a.Detections[p.a], b.Detections[p.b]
+		pairA[p.a], pairB[p.b] = true, true
+		m := DiffMatch{Class: da.ClassName, A: da, B: db, IoU: p.iou,
+			DX: (db.BBox.X1 + db.BBox.X2 - da.BBox.X1 - da.BBox.X2) / 2,
+			DY: (db.BBox.Y1 + db.BBox.Y2 - da.BBox.Y1 - da.BBox.Y2) / 2}
+		if p.iou >= movedIoU {
+			d.Unchanged = append(d.Unchanged, m)
+		} else {
+			d.Moved = append(d.Moved, m)
+		}
+	}
+	for i, det := range a.Detections {
+		if !pairA[i] {
+			d.Removed = append(d.Removed, det)
+		}
+	}
+	for i, det := range b.Detections {
+		if !pairB[i] {
+			d.Added = append(d.Added, det)
+		}
+	}
+	return d
+}
+
+// hungarianMatch pairs detections of a and b of the same class overlapping
+// by at least minIoU, maximizing the total IoU of the pairs
+func hungarianMatch(a, b []Detection, minIoU float64) []detectionPair {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	// Rows are the smaller side; a pair that may not match costs as much as no pair
+	rows, cols, swapped := a, b, false
+	if len(a) > len(b) {
+		rows, cols, swapped = b, a, true
+	}
+	overlap := make([][]float64, len(rows))
+	cost := make([][]float64, len(rows))
+	for i, r := range rows {
+		overlap[i], cost[i] = make([]float64, len(cols)), make([]float64, len(cols))
+		for j, c := range cols {
+			cost[i][j] = 1
+			if r.ClassName == c.ClassName {
+				if v := iou(r.BBox, c.BBox); v >= minIoU {
+					overlap[i][j], cost[i][j] = v, 1-v
+				}
+			}
+		}
+	}
+	var pairs []detectionPair
+	for i, j := range hungarian(cost) {
+		if cost[i][j] >= 1 {
+			continue
+		}
+		p := detectionPair{i, j, overlap[i][j]}
+		if swapped {
+			p.a, p.b = j, i
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+// hungarian solves the assignment problem for a cost matrix with at least
+// as many columns as rows, returning the column of each row
+func hungarian(cost [][]float64) []int {
+	n, m := len(cost), len(cost[0])
+	// Potentials of rows and columns and the row holding each column, 1-based
+	u, v := make([]float64, n+1), make([]float64, m+1)
+	p, way := make([]int, m+1), make([]int, m+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, m+1)
+		used := make([]bool, m+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				if cur := cost[i0-1][j-1] - u[i0] - v[j]; cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	assign := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			assign[p[j]-1] = j - 1
+		}
+	}
+	return assign
+}
+
+// resultsDiffHandler serves GET /api/v1/results/diff
+func resultsDiffHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	a, okA := results.get(q.Get("a"))
+	b, okB := results.get(q.Get("b"))
+	if !okA || !okB {
+		writeJSONError(w, http.StatusNotFound, "Result not found")
+		return
+	}
+	minIoU, movedIoU := 0.1, 0.8
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"min_iou", &minIoU}, {"moved_iou", &movedIoU}} {
+		if s := q.Get(f.name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v <= 0 || v > 1 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("%s must be in (0, 1]", f.name))
+				return
+			}
+			*f.dst = v
+		}
+	}
+	writeJSON(w, http.StatusOK, diffResults(a, b, minIoU, movedIoU))
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Drift detection compares statistics of recent uploads against a reference
+// set (either a directory of known-good frames or the first uploads seen) and
+// raises an alert when the scene shifts: the camera moved, the lighting
+// changed, the lens got dirty, or the stream resolution changed.
+
+// embeddingGrid is the side length of the coarse RGB grid used as an image embedding
+const embeddingGrid = 4
+
+// ImageStats are the per-image features tracked for drift
+type ImageStats struct {
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Brightness float64   `json:"brightness"` // mean luma, 0-255
+	Blur       float64   `json:"blur"`       // variance of the Laplacian; lower is blurrier
+	Embedding  []float64 `json:"embedding,omitempty"`
+}
+
+// driftReference summarizes the reference distribution
+type driftReference struct {
+	Source         string         `json:"source"` // "dir" or "bootstrap"
+	Images         int            `json:"images"`
+	BrightnessMean float64        `json:"brightness_mean"`
+	BrightnessStd  float64        `json:"brightness_std"`
+	LogBlurMean    float64        `json:"log_blur_mean"`
+	LogBlurStd     float64        `json:"log_blur_std"`
+	Centroid       []float64      `json:"centroid"`
+	DistanceMean   float64        `json:"distance_mean"`
+	DistanceStd    float64        `json:"distance_std"`
+	Resolutions    map[string]int `json:"resolutions"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// DriftStatus is returned by /api/v1/drift
+type DriftStatus struct {
+	State      string             `json:"state"` // "collecting_reference", "monitoring", "drift"
+	Reference  *driftReference    `json:"reference,omitempty"`
+	Window     int                `json:"window"`
+	Scores     map[string]float64 `json:"scores,omitempty"`
+	Threshold  float64            `json:"threshold"`
+	Drifted    []string           `json:"drifted,omitempty"`
+	AlertSince *time.Time         `json:"alert_since,omitempty"`
+}
+
+type driftDetector struct {
+	mu         sync.Mutex
+	pending    []ImageStats // bootstrap samples until the reference is built
+	reference  *driftReference
+	window     []ImageStats
+	scores     map[string]float64
+	drifted    []string
+	alertSince time.Time
+}
+
+var drift = &driftDetector{}
+
+var (
+	driftScore = newGaugeVec("yolo_drift_score",
+		"Drift score of recent images versus the reference set, by feature.", "feature")
+	driftAlert = newGaugeVec("yolo_drift_alert",
+		"1 when incoming image statistics have drifted beyond the threshold.")
+)
+
+func driftReferencePath() string {
+	return filepath.Join(config().StateDir, "drift-reference.json")
+}
+
+// computeImageStats decodes an image and computes its drift features on a
+// sampled grid of at most 256x256 pixels
+func computeImageStats(path string) (ImageStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageStats{}, err
+	}
+	defer f.Close()
+	img, err := decodeImage(f)
+	if err != nil {
+		return ImageStats{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return imageStats(img), nil
+}
+
+func imageStats(img image.Image) ImageStats {
+	b := img.Bounds()
+	stats := ImageStats{Width: b.Dx(), Height: b.Dy()}
+	if stats.Width == 0 || stats.Height == 0 {
+		return stats
+	}
+
+	sw, sh := minInt(stats.Width, 256), minInt(stats.Height, 256)
+	gray := make([]float64, sw*sh)
+	emb := make([]float64, embeddingGrid*embeddingGrid*3)
+	cellCount := make([]float64, embeddingGrid*embeddingGrid)
+
+	var sum float64
+	for y := 0; y < sh; y++ {
+		py := b.Min.Y + y*stats.Height/sh
+		for x := 0; x < sw; x++ {
+			px := b.Min.X + x*stats.Width/sw
+			r, g, bl, _ := img.At(px, py).RGBA()
+			rf, gf, bf := float64(r>>8), float64(g>>8), float64(bl>>8)
+			luma := 0.299*rf + 0.587*gf + 0.114*bf
+			gray[y*sw+x] = luma
+			sum += luma
+
+			cell := (y*embeddingGrid/sh)*embeddingGrid + x*embeddingGrid/sw
+			emb[cell*3] += rf
+			emb[cell*3+1] += gf
+			emb[cell*3+2] += bf
+			cellCount[cell]++
+		}
+	}
+	stats.Brightness = sum / float64(len(gray))
+
+	// Variance of the 4-neighbour Laplacian
+	var lsum, lsq float64
+	n := 0
+	for y := 1; y < sh-1; y++ {
+		for x := 1; x < sw-1; x++ {
+			i := y*sw + x
+			l := gray[i-sw] + gray[i+sw] + gray[i-1] + gray[i+1] - 4*gray[i]
+			lsum += l
+			lsq += l * l
+			n++
+		}
+	}
+	if n > 0 {
+		mean := lsum / float64(n)
+		stats.Blur = lsq/float64(n) - mean*mean
+	}
+
+	for cell, c := range cellCount {
+		if c > 0 {
+			for ch := 0; ch < 3; ch++ {
+				emb[cell*3+ch] /= c * 255
+			}
+		}
+	}
+	stats.Embedding = emb
+	return stats
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func resolutionKey(s ImageStats) string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+func euclidean(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		if i < len(b) {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+	}
+	return math.Sqrt(sum)
+}
+
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+// buildReference summarizes a set of image stats into a reference distribution
+func buildReference(samples []ImageStats, source string) *driftReference {
+	ref := &driftReference{
+		Source:      source,
+		Images:      len(samples),
+		Resolutions: map[string]int{},
+		CreatedAt:   time.Now().UTC(),
+	}
+	var brightness, logBlur []float64
+	centroid := make([]float64, embeddingGrid*embeddingGrid*3)
+	for _, s := range samples {
+		brightness = append(brightness, s.Brightness)
+		logBlur = append(logBlur, math.Log1p(s.Blur))
+		ref.Resolutions[resolutionKey(s)]++
+		for i := range centroid {
+			if i < len(s.Embedding) {
+				centroid[i] += s.Embedding[i] / float64(len(samples))
+			}
+		}
+	}
+	ref.BrightnessMean, ref.BrightnessStd = meanStd(brightness)
+	ref.LogBlurMean, ref.LogBlurStd = meanStd(logBlur)
+	ref.Centroid = centroid
+
+	var dists []float64
+	for _, s := range samples {
+		dists = append(dists, euclidean(s.Embedding, centroid))
+	}
+	ref.DistanceMean, ref.DistanceStd = meanStd(dists)
+	return ref
+}
+
+// loadReference restores the saved reference, or builds one from DRIFT_REFERENCE_DIR
+func (d *driftDetector) loadReference() {
+	if config().DriftReferenceDir != "" {
+		images, err := listEvalImages(config().DriftReferenceDir)
+		if err != nil {
+			log.Printf("Warning: failed to read drift reference dir: %v", err)
+		}
+		var samples []ImageStats
+		for _, img := range images {
+			s, err := computeImageStats(img)
+			if err != nil {
+				log.Printf("Warning: skipping drift reference image %s: %v", img, err)
+				continue
+			}
+			samples = append(samples, s)
+		}
+		if len(samples) > 0 {
+			d.mu.Lock()
+			d.reference = buildReference(samples, "dir")
+			d.mu.Unlock()
+			log.Printf("Built drift reference from %d images in %s", len(samples), config().DriftReferenceDir)
+			return
+		}
+	}
+
+	data, err := os.ReadFile(driftReferencePath())
+	if err != nil {
+		return
+	}
+	var ref driftReference
+	if err := json.Unmarshal(data, &ref); err != nil {
+		log.Printf("Warning: ignoring corrupt drift reference: %v", err)
+		return
+	}
+	d.mu.Lock()
+	d.reference = &ref
+	d.mu.Unlock()
+}
+
+func (d *driftDetector) saveReferenceLocked() {
+	data, err := json.MarshalIndent(d.reference, "", "  ")
+	if err != nil {
+		return
+	}
+	os.MkdirAll(config().StateDir, 0755)
+	if err := os.WriteFile(driftReferencePath(), data, 0644); err != nil {
+		log.Printf("Warning: failed to save drift reference: %v", err)
+	}
+}
+
+// observe adds an uploaded image's stats and re-evaluates drift
+func (d *driftDetector) observe(s ImageStats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.reference == nil {
+		d.pending = append(d.pending, s)
+		if len(d.pending) >= config().DriftReferenceSize {
+			d.reference = buildReference(d.pending, "bootstrap")
+			d.pending = nil
+			d.saveReferenceLocked()
+			log.Printf("Built drift reference from the first %d uploads", d.reference.Images)
+		}
+		return
+	}
+
+	d.window = append(d.window, s)
+	if len(d.window) > config().DriftWindow {
+		d.window = d.window[len(d.window)-config().DriftWindow:]
+	}
+	if len(d.window) < config().DriftMinWindow {
+		return
+	}
+	d.evaluateLocked()
+}
+
+// evaluateLocked scores the window against the reference. Brightness, blur, and
+// embedding scores are the shift of the window mean in units of the reference
+// standard deviation; the resolution score is the fraction of window images at
+// a resolution never seen in the reference, scaled to the same threshold.
+func (d *driftDetector) evaluateLocked() {
+	ref := d.reference
+	var brightness, logBlur, dists []float64
+	unseen := 0
+	for _, s := range d.window {
+		brightness = append(brightness, s.Brightness)
+		logBlur = append(logBlur, math.Log1p(s.Blur))
+		dists = append(dists, euclidean(s.Embedding, ref.Centroid))
+		if ref.Resolutions[resolutionKey(s)] == 0 {
+			unseen++
+		}
+	}
+	bMean, _ := meanStd(brightness)
+	lbMean, _ := meanStd(logBlur)
+	dMean, _ := meanStd(dists)
+
+	d.scores = map[string]float64{
+		"brightness": shiftScore(bMean, ref.BrightnessMean, ref.BrightnessStd),
+		"blur":       shiftScore(lbMean, ref.LogBlurMean, ref.LogBlurStd),
+		"embedding":  math.Max(0, dMean-ref.DistanceMean) / math.Max(ref.DistanceStd, 1e-3),
+		"resolution": float64(unseen) / float64(len(d.window)) * 2 * config().DriftThreshold,
+	}
+
+	var drifted []string
+	for _, feature := range []string{"brightness", "blur", "embedding", "resolution"} {
+		driftScore.set(d.scores[feature], feature)
+		if d.scores[feature] > config().DriftThreshold {
+			drifted = append(drifted, feature)
+		}
+	}
+
+	switch {
+	case len(drifted) > 0 && d.alertSince.IsZero():
+		d.alertSince = time.Now().UTC()
+		log.Printf("Warning: image drift detected (%s); the scene or camera may have changed and the model may need retraining", strings.Join(drifted, ", "))
+		driftAlert.set(1)
+	case len(drifted) == 0 && !d.alertSince.IsZero():
+		d.alertSince = time.Time{}
+		log.Println("Image drift cleared")
+		driftAlert.set(0)
+	}
+	d.drifted = drifted
+}
+
+// shiftScore is |mean - refMean| in units of refStd, with a floor on the std
+// so a perfectly uniform reference doesn't make every change infinite
+func shiftScore(mean, refMean, refStd float64) float64 {
+	return math.Abs(mean-refMean) / math.Max(refStd, 1e-3*math.Max(math.Abs(refMean), 1))
+}
+
+// resetReference discards the reference so the next uploads rebuild it
+func (d *driftDetector) resetReference() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reference = nil
+	d.pending = nil
+	d.window = nil
+	d.scores = nil
+	d.drifted = nil
+	d.alertSince = time.Time{}
+	driftAlert.set(0)
+	os.Remove(driftReferencePath())
+}
+
+func (d *driftDetector) status() DriftStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st := DriftStatus{Window: len(d.window), Threshold: config().DriftThreshold, Scores: d.scores, Drifted: d.drifted}
+	switch {
+	case d.reference == nil:
+		st.State = "collecting_reference"
+		st.Window = len(d.pending)
+	case !d.alertSince.IsZero():
+		st.State = "drift"
+		since := d.alertSince
+		st.AlertSince = &since
+	default:
+		st.State = "monitoring"
+	}
+	if d.reference != nil {
+		ref := *d.reference
+		st.Reference = &ref
+	}
+	return st
+}
+
+// driftHandler serves the drift API
+//
+//	GET  /api/v1/drift        current scores, reference summary, and alert state
+//	POST /api/v1/drift/reset  rebuild the reference from the next uploads (e.g. after re-aiming a camera)
+func driftHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/drift"), "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, drift.status())
+	case action == "reset" && r.Method == http.MethodPost:
+		drift.resetReference()
+		if config().DriftReferenceDir != "" {
+			drift.loadReference()
+		}
+		writeJSON(w, http.StatusOK, drift.status())
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
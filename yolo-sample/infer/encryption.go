@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Images and results can be encrypted at rest with AES-256-GCM, so that a
+// stolen node or a pulled SD card does not give away what its cameras saw.
+// The 32-byte key is read once at startup, from ENCRYPTION_KEY_FILE (a
+// mounted secret, raw or in hex or base64) or from the output of
+// ENCRYPTION_KEY_COMMAND, which can unwrap a data key with a KMS:
+//
+//	ENCRYPTION_KEY_COMMAND=aws kms decrypt --ciphertext-blob fileb:///etc/yolo/data-key.enc --query Plaintext --output text
+//
+// A node configured with a key it cannot read does not start, rather than
+// store anything in the clear. Encrypted are the stored results, the objects
+// of the object store and their derivatives, and the reference images of
+// sources; an encrypted file starts with encryptedMagic, followed by the
+// nonce and the sealed data. Files written before encryption was turned on
+// stay readable as they are, and are not rewritten. Uploads waiting in the
+// job queue and images handed to the inference backend are plaintext for as
+// long as they are processed. Object names are hashes of the plaintext, so
+// they reveal whether a node holds a given image, but not what it shows.
+
+// encryptedMagic starts every encrypted file, and is authenticated with it
+const encryptedMagic = "YOLOENC1"
+
+// dataCipher is the AEAD of the encryption key, nil without one
+var dataCipher cipher.AEAD
+
+// loadEncryptionKey reads the encryption key, exiting if one is configured
+// but cannot be used
+func loadEncryptionKey() {
+	cfg := config()
+	var raw []byte
+	switch {
+	case cfg.EncryptionKeyFile != "":
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			log.Fatalf("Cannot read ENCRYPTION_KEY_FILE: %v", err)
+		}
+		raw = data
+	case strings.TrimSpace(cfg.EncryptionKeyCommand) != "":
+		args := strings.Fields(cfg.EncryptionKeyCommand)
+		cmd := exec.Command(args[0], args[1:]...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			log.Fatalf("ENCRYPTION_KEY_COMMAND failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		raw = out
+	default:
+		return
+	}
+	key, err := parseEncryptionKey(raw)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	if dataCipher, err = cipher.NewGCM(block); err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	log.Printf("Encrypting stored images and results")
+}
+
+// parseEncryptionKey accepts a 32-byte key as raw bytes, hex or base64
+func parseEncryptionKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("need 32 bytes, raw or as hex or base64")
+}
+
+func encryptionOn() bool {
+	return dataCipher != nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// sealData encrypts data for storage, or returns it as-is without a key
+func sealData(data []byte) []byte {
+	if dataCipher == nil {
+		return data
+	}
+	nonce := make([]byte, dataCipher.NonceSize())
+	rand.Read(nonce)
+	out := append([]byte(encryptedMagic), nonce...)
+	return dataCipher.Seal(out, nonce, data, []byte(encryptedMagic))
+}
+
+// openData decrypts data read from storage; data that was stored
+// unencrypted is returned as-is
+func openData(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	if dataCipher == nil {
+		return nil, errors.New("file is encrypted and no encryption key is configured")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < dataCipher.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	plain, err := dataCipher.Open(nil, data[:dataCipher.NonceSize()], data[dataCipher.NonceSize():], []byte(encryptedMagic))
+	if err != nil {
+		return nil, errors.New("cannot decrypt file: wrong key or corrupted data")
+	}
+	return plain, nil
+}
+
+// readStoredFile reads a file written by writeStoredFile
+func readStoredFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openData(data)
+}
+
+// writeStoredFile writes data to path, encrypted with a key
+func writeStoredFile(path string, data []byte) error {
+	return writeFileAtomic(path, sealData(data))
+}
+
+// writeStoredJPEG is writeJPEG for stored images
+func writeStoredJPEG(path string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	return writeStoredFile(path, buf.Bytes())
+}
+
+// serveStoredFile serves a stored file, decrypting it
+func serveStoredFile(w http.ResponseWriter, r *http.Request, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := readStoredFile(path)
+	if err != nil {
+		log.Printf("Warning: cannot read %s: %v", path, err)
+		http.Error(w, "image unavailable", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), bytes.NewReader(data))
+}
+
+// plainCopy returns a path to the plaintext of a stored file for the
+// inference backend, which reads images by path; cleanup removes the copy
+// made of an encrypted one
+func plainCopy(path string) (string, func(), error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !isEncrypted(data) {
+		return path, func() {}, nil
+	}
+	if data, err = openData(data); err != nil {
+		return "", nil, err
+	}
+	f, err := os.CreateTemp(uploadDir, "plain-*"+filepath.Ext(path))
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An evaluation runs a model over a labeled test set laid out like a YOLO dataset:
+// images in <dir>/images with labels in <dir>/labels/<name>.txt, or images and
+// .txt labels side by side in <dir>. Each label line is
+// "<class_id> <cx> <cy> <w> <h>" in coordinates normalized to the image size.
+
+// ClassMetrics holds the evaluation scores for one class
+type ClassMetrics struct {
+	ClassID        int     `json:"class_id"`
+	ClassName      string  `json:"class_name"`
+	GroundTruth    int     `json:"ground_truth"`
+	Predictions    int     `json:"predictions"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	AP             float64 `json:"ap"`
+}
+
+// EvaluationReport is the stored outcome of one evaluation run
+type EvaluationReport struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"` // "running", "completed", "failed"
+	Dir          string         `json:"dir"`
+	Model        string         `json:"model"`
+	IoUThreshold float64        `json:"iou_threshold"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   *time.Time     `json:"finished_at,omitempty"`
+	Images       int            `json:"images"`
+	Failed       int            `json:"failed_images"`
+	Precision    float64        `json:"precision"`
+	Recall       float64        `json:"recall"`
+	MAP50        float64        `json:"map50"`
+	Classes      []ClassMetrics `json:"classes"`
+	Errors       []string       `json:"errors,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type groundTruthBox struct {
+	classID int
+	box     BBox
+}
+
+type evalPrediction struct {
+	image      int
+	confidence float64
+	box        BBox
+}
+
+// maxReportedErrors bounds per-image errors kept in a report
+const maxReportedErrors = 20
+
+var evaluations = struct {
+	sync.Mutex
+	reports map[string]*EvaluationReport
+}{reports: map[string]*EvaluationReport{}}
+
+func evaluationsDir() string {
+	return filepath.Join(config().StateDir, "evaluations")
+}
+
+// loadEvaluations restores stored reports from the state dir
+func loadEvaluations() {
+	files, _ := filepath.Glob(filepath.Join(evaluationsDir(), "*.json"))
+	evaluations.Lock()
+	defer evaluations.Unlock()
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var rep EvaluationReport
+		if err := json.Unmarshal(data, &rep); err != nil {
+			log.Printf("Warning: skipping unreadable evaluation %s: %v", f, err)
+			continue
+		}
+		if rep.Status == "running" {
+			rep.Status = "failed"
+			rep.Error = "interrupted by restart"
+		}
+		evaluations.reports[rep.ID] = &rep
+	}
+}
+
+func saveEvaluation(rep *EvaluationReport) {
+	evaluations.Lock()
+	data, err := json.MarshalIndent(rep, "", "  ")
+	evaluations.Unlock()
+	if err != nil {
+		log.Printf("Warning: failed to encode evaluation %s: %v", rep.ID, err)
+		return
+	}
+	if err := os.MkdirAll(evaluationsDir(), 0755); err != nil {
+		log.Printf("Warning: failed to create evaluations dir: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(evaluationsDir(), rep.ID+".json"), data, 0644); err != nil {
+		log.Printf("Warning: failed to store evaluation %s: %v", rep.ID, err)
+	}
+}
+
+// resolveEvalDir checks that dir is inside the configured evaluation root
+func resolveEvalDir(dir string) (string, error) {
+	root, err := filepath.Abs(config().EvalRoot)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	dir = filepath.Clean(dir)
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("dir must be inside %s", root)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("dir %s is not a readable directory", dir)
+	}
+	return dir, nil
+}
+
+// startEvaluation validates the request and runs the evaluation in the background
+func startEvaluation(dir, model string, iou float64) (*EvaluationReport, error) {
+	rep, err := newEvaluation(dir, model, iou)
+	if err != nil {
+		return nil, err
+	}
+	go runEvaluation(rep)
+	return rep, nil
+}
+
+// newEvaluation validates the request and records the evaluation as running
+func newEvaluation(dir, model string, iou float64) (*EvaluationReport, error) {
+	dir, err := resolveEvalDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = activeModelVersion()
+	}
+	if !modelExists(model) {
+		return nil, fmt.Errorf("model %q not found in %s", model, config().ModelDir)
+	}
+	if iou == 0 {
+		iou = 0.5
+	}
+	if iou <= 0 || iou >= 1 {
+		return nil, fmt.Errorf("iou_threshold must be in (0, 1), got %g", iou)
+	}
+
+	rep := &EvaluationReport{
+		ID:           newID(),
+		Status:       "running",
+		Dir:          dir,
+		Model:        model,
+		IoUThreshold: iou,
+		StartedAt:    time.Now().UTC(),
+	}
+	evaluations.Lock()
+	evaluations.reports[rep.ID] = rep
+	evaluations.Unlock()
+	saveEvaluation(rep)
+	return rep, nil
+}
+
+func runEvaluation(rep *EvaluationReport) {
+	log.Printf("Evaluation %s: model %s on %s", rep.ID, rep.Model, rep.Dir)
+
+	images, err := listEvalImages(rep.Dir)
+	if err == nil && len(images) == 0 {
+		err = fmt.Errorf("no images found in %s", rep.Dir)
+	}
+	if err != nil {
+		finishEvaluation(rep, nil, err)
+		return
+	}
+
+	gt := map[int][]groundTruthBox{} // image index -> boxes
+	preds := map[int][]evalPrediction{}
+	names := map[int]string{}
+	var imageErrors []string
+	failed := 0
+
+	for i, img := range images {
+		boxes, err := loadGroundTruth(img)
+		if err == nil {
+			result := runInference(img, rep.Model)
+			if result.Error != "" {
+				err = fmt.Errorf("%s", result.Error)
+			} else {
+				gt[i] = boxes
+				for _, d := range result.Detections {
+					names[d.ClassID] = d.ClassName
+					preds[d.ClassID] = append(preds[d.ClassID], evalPrediction{image: i, confidence: d.Confidence, box: d.BBox})
+				}
+			}
+		}
+		if err != nil {
+			failed++
+			if len(imageErrors) < maxReportedErrors {
+				imageErrors = append(imageErrors, filepath.Base(img)+": "+err.Error())
+			}
+		}
+	}
+
+	evaluations.Lock()
+	rep.Images = len(images)
+	rep.Failed = failed
+	rep.Errors = imageErrors
+	evaluations.Unlock()
+
+	if failed == len(images) {
+		finishEvaluation(rep, nil, fmt.Errorf("inference failed on all %d images", failed))
+		return
+	}
+	finishEvaluation(rep, computeClassMetrics(gt, preds, names, rep.IoUThreshold), nil)
+}
+
+func finishEvaluation(rep *EvaluationReport, classes []ClassMetrics, err error) {
+	now := time.Now().UTC()
+
+	evaluations.Lock()
+	rep.FinishedAt = &now
+	if err != nil {
+		rep.Status = "failed"
+		rep.Error = err.Error()
+	} else {
+		rep.Status = "completed"
+		rep.Classes = classes
+		var tp, fp, totalGT int
+		var apSum float64
+		scored := 0
+		for _, c := range classes {
+			tp += c.TruePositives
+			fp += c.FalsePositives
+			totalGT += c.GroundTruth
+			// Like COCO, classes absent from the ground truth don't count toward mAP
+			if c.GroundTruth > 0 {
+				apSum += c.AP
+				scored++
+			}
+		}
+		if tp+fp > 0 {
+			rep.Precision = float64(tp) / float64(tp+fp)
+		}
+		if totalGT > 0 {
+			rep.Recall = float64(tp) / float64(totalGT)
+		}
+		if scored > 0 {
+			rep.MAP50 = apSum / float64(scored)
+		}
+	}
+	evaluations.Unlock()
+
+	saveEvaluation(rep)
+	log.Printf("Evaluation %s finished: %s", rep.ID, rep.Status)
+}
+
+// computeClassMetrics matches predictions to ground truth per class (greedy by
+// confidence, as in VOC/COCO) and computes precision, recall, and all-point AP
+func computeClassMetrics(gt map[int][]groundTruthBox, preds map[int][]evalPrediction, names map[int]string, iouThreshold float64) []ClassMetrics {
+	gtByClass := map[int]map[int][]BBox{} // class -> image -> boxes
+	for img, boxes := range gt {
+		for _, b := range boxes {
+			if gtByClass[b.classID] == nil {
+				gtByClass[b.classID] = map[int][]BBox{}
+			}
+			gtByClass[b.classID][img] = append(gtByClass[b.classID][img], b.box)
+		}
+	}
+
+	classIDs := map[int]bool{}
+	for c := range gtByClass {
+		classIDs[c] = true
+	}
+	for c := range preds {
+		classIDs[c] = true
+	}
+
+	var out []ClassMetrics
+	for classID := range classIDs {
+		m := ClassMetrics{ClassID: classID, ClassName: names[classID]}
+		if m.ClassName == "" {
+			m.ClassName = "class_" + strconv.Itoa(classID)
+		}
+
+		matched := map[int][]bool{}
+		for img, boxes := range gtByClass[classID] {
+			m.GroundTruth += len(boxes)
+			matched[img] = make([]bool, len(boxes))
+		}
+
+		// Only predictions on images that produced a result are scored
+		var ps []evalPrediction
+		for _, p := range preds[classID] {
+			if _, ok := gt[p.image]; ok {
+				ps = append(ps, p)
+			}
+		}
+		sort.Slice(ps, func(i, j int) bool { return ps[i].confidence > ps[j].confidence })
+		m.Predictions = len(ps)
+
+		var precisions, recalls []float64
+		for _, p := range ps {
+			best, bestIoU := -1, iouThreshold
+			for j, g := range gtByClass[classID][p.image] {
+				if matched[p.image][j] {
+					continue
+				}
+				if v := iou(p.box, g); v >= bestIoU {
+					best, bestIoU = j, v
+				}
+			}
+			if best >= 0 {
+				matched[p.image][best] = true
+				m.TruePositives++
+			} else {
+				m.FalsePositives++
+			}
+			precisions = append(precisions, float64(m.TruePositives)/float64(m.TruePositives+m.FalsePositives))
+			if m.GroundTruth > 0 {
+				recalls = append(recalls, float64(m.TruePositives)/float64(m.GroundTruth))
+			} else {
+				recalls = append(recalls, 0)
+			}
+		}
+
+		if m.Predictions > 0 {
+			m.Precision = float64(m.TruePositives) / float64(m.Predictions)
+		}
+		if m.GroundTruth > 0 {
+			m.Recall = float64(m.TruePositives) / float64(m.GroundTruth)
+			m.AP = averagePrecision(precisions, recalls)
+		}
+		out = append(out, m)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ClassID < out[j].ClassID })
+	return out
+}
+
+// averagePrecision computes the area under the interpolated precision-recall curve
+func averagePrecision(precisions, recalls []float64) float64 {
+	if len(precisions) == 0 {
+		return 0
+	}
+	// Make precision monotonically decreasing from right to left
+	interp := append([]float64(nil), precisions...)
+	for i := len(interp) - 2; i >= 0; i-- {
+		if interp[i+1] > interp[i] {
+			interp[i] = interp[i+1]
+		}
+	}
+	ap, prevRecall := 0.0, 0.0
+	for i, r := range recalls {
+		ap += (r - prevRecall) * interp[i]
+		prevRecall = r
+	}
+	return ap
+}
+
+// iou returns the intersection-over-union of two boxes
+func iou(a, b BBox) float64 {
+	ix := minFloat(a.X2, b.X2) - maxFloat(a.X1, b.X1)
+	iy := minFloat(a.Y2, b.Y2) - maxFloat(a.Y1, b.Y1)
+	if ix <= 0 || iy <= 0 {
+		return 0
+	}
+	inter := ix * iy
+	union := (a.X2-a.X1)*(a.Y2-a.Y1) + (b.X2-b.X1)*(b.Y2-b.Y1) - inter
+	if union <= 0 {
+		return 0
+	}
+	return inter / union
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// listEvalImages returns the image files of a dataset directory
+func listEvalImages(dir string) ([]string, error) {
+	imgDir := dir
+	if fi, err := os.Stat(filepath.Join(dir, "images")); err == nil && fi.IsDir() {
+		imgDir = filepath.Join(dir, "images")
+	}
+	entries, err := os.ReadDir(imgDir)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			images = append(images, filepath.Join(imgDir, e.Name()))
+		}
+	}
+	return images, nil
+}
+
+// labelPath returns the YOLO label file for an image
+func labelPath(imagePath string) string {
+	base := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath)) + ".txt"
+	dir := filepath.Dir(imagePath)
+	if filepath.Base(dir) == "images" {
+		return filepath.Join(filepath.Dir(dir), "labels", base)
+	}
+	return filepath.Join(dir, base)
+}
+
+// loadGroundTruth reads an image's YOLO labels as pixel-space boxes.
+// A missing label file means the image has no objects.
+func loadGroundTruth(imagePath string) ([]groundTruthBox, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %v", err)
+	}
+	w, h := float64(cfg.Width), float64(cfg.Height)
+
+	lf, err := os.Open(labelPath(imagePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer lf.Close()
+
+	var boxes []groundTruthBox
+	scanner := bufio.NewScanner(lf)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("label line %d: expected 5 fields, got %d", line, len(fields))
+		}
+		classID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("label line %d: invalid class id %q", line, fields[0])
+		}
+		var v [4]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return nil, fmt.Errorf("label line %d: invalid coordinate %q", line, fields[i+1])
+			}
+		}
+		cx, cy, bw, bh := v[0]*w, v[1]*h, v[2]*w, v[3]*h
+		boxes = append(boxes, groundTruthBox{
+			classID: classID,
+			box:     BBox{X1: cx - bw/2, Y1: cy - bh/2, X2: cx + bw/2, Y2: cy + bh/2},
+		})
+	}
+	return boxes, scanner.Err()
+}
+
+// evaluationsHandler serves the evaluation API
+//
+//	GET  /api/v1/evaluations       all reports, newest first
+//	POST /api/v1/evaluations       start: {"dir": "eval/set1", "model": "v2", "iou_threshold": 0.5}
+//	GET  /api/v1/evaluations/{id}  a single report
+func evaluationsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/evaluations"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		evaluations.Lock()
+		list := make([]EvaluationReport, 0, len(evaluations.reports))
+		for _, rep := range evaluations.reports {
+			list = append(list, *rep)
+		}
+		evaluations.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+		writeJSON(w, http.StatusOK, list)
+
+	case id == "" && r.Method == http.MethodPost:
+		var req struct {
+			Dir          string  `json:"dir"`
+			Model        string  `json:"model"`
+			IoUThreshold float64 `json:"iou_threshold"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		rep, err := startEvaluation(req.Dir, req.Model, req.IoUThreshold)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		evaluations.Lock()
+		resp := *rep
+		evaluations.Unlock()
+		writeJSON(w, http.StatusAccepted, resp)
+
+	case id != "" && r.Method == http.MethodGet:
+		evaluations.Lock()
+		rep, ok := evaluations.reports[id]
+		var resp EvaluationReport
+		if ok {
+			resp = *rep
+		}
+		evaluations.Unlock()
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Evaluation not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modules announce what happens on the node by publishing events on an
+// in-process bus instead of calling each other; consumers (alert rules, job
+// progress streams, the /api/v1/events stream and the EVENT_WEBHOOK_URL sink)
+// subscribe to the event types they need. A new sink only needs a subscription.
+// Sinks off the node send events as CloudEvents; see cloudevents.go.
+//
+//	result.created    InferenceResult   a result was stored
+//	alert.fired       Alert             an alert rule matched a result
+//	job.stage         JobEvent          an upload job moved to a new stage
+//	backend.status    BackendEvent      the inference backend went down or came back
+//	worker.state      WorkerEvent       the inference process (re)started or exited
+//	source.status     SourceEvent       a camera source connected or disconnected
+//	sync.completed    SyncEvent         a result sync run finished, with or without errors
+//	config.reloaded   ReloadStatus      a configuration reload was attempted
+//	clock.status      ClockStatus       the node clock lost or regained synchronization
+//	update.status     UpdateView        a self-update was found, installed or failed
+//	node.maintenance  MaintenanceView   maintenance mode was switched on or off
+//	model.activated   ModelEvent        regular traffic switched to another model version
+//	image.quality     QualityEvent      a camera source's images started or stopped failing the quality gate
+//	training.rollout  TrainingRollout   a model announced by a training callback moved through its rollout
+//	desired.status    DesiredStateView  the node's Synced condition with its desired state changed
+//	training.job      TrainingJob       a training Job was placed, scheduled or found unschedulable
+//	node.throttle     ThermalStatus     the node started or stopped being throttled for heat or power
+//	power.status      PowerStatus       the node went on battery or back on mains power
+//
+// Delivery is in order per subscriber and never blocks the publisher: a
+// subscriber that falls behind by more than its buffer loses its oldest events.
+
+// Event types
+const (
+	eventResultCreated   = "result.created"
+	eventAlertFired      = "alert.fired"
+	eventJobStage        = "job.stage"
+	eventBackendStatus   = "backend.status"
+	eventWorkerState     = "worker.state"
+	eventSourceStatus    = "source.status"
+	eventSyncCompleted   = "sync.completed"
+	eventConfigReloaded  = "config.reloaded"
+	eventClockStatus     = "clock.status"
+	eventUpdateStatus    = "update.status"
+	eventMaintenance     = "node.maintenance"
+	eventModelActivated  = "model.activated"
+	eventImageQuality    = "image.quality"
+	eventTrainingRollout = "training.rollout"
+	eventDesiredState    = "desired.status"
+	eventTrainingJob     = "training.job"
+	eventThrottle        = "node.throttle"
+	eventPowerStatus     = "power.status"
+)
+
+// Event is one message on the bus
+type Event struct {
+	ID       string      `json:"id"`
+	Sequence uint64      `json:"sequence"` // node-wide order; see clock.go
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+// BackendEvent is the payload of backend.status
+type BackendEvent struct {
+	Status string `json:"status"` // "up", "down" or "recovering"
+	Error  string `json:"error,omitempty"`
+}
+
+// WorkerEvent is the payload of worker.state
+type WorkerEvent struct {
+	State    string `json:"state"`
+	LastExit string `json:"last_exit,omitempty"`
+}
+
+// SourceEvent is the payload of source.status
+type SourceEvent struct {
+	Source    string `json:"source"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SyncEvent is the payload of sync.completed
+type SyncEvent struct {
+	Sent  int    `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+// ModelEvent is the payload of model.activated
+type ModelEvent struct {
+	Version  string `json:"version"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// subscription receives the events that match it on C
+type subscription struct {
+	name   string
+	types  []string // exact types or "prefix." patterns; empty means all
+	filter func(Event) bool
+	C      chan Event
+}
+
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+var bus = &eventBus{subs: map[*subscription]struct{}{}}
+
+var (
+	eventsPublished = newCounterVec("yolo_events_published_total",
+		"Events published on the internal bus, by type.", "type")
+	eventsDropped = newCounterVec("yolo_events_dropped_total",
+		"Events a slow subscriber lost, by subscriber.", "subscriber")
+)
+
+// subscribe registers a subscriber for the given types ("job.stage", or
+// "job." for every job event; none for everything). filter, when set, further
+// selects events. buffer is how many events may wait before the oldest is dropped.
+func (b *eventBus) subscribe(name string, types []string, filter func(Event) bool, buffer int) *subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &subscription{name: name, types: types, filter: filter, C: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+// publish sends an event to every matching subscriber
+func (b *eventBus) publish(eventType string, data interface{}) {
+	ev := Event{ID: newID(), Sequence: nextSequence(), Type: eventType, Time: time.Now().UTC(), Data: data}
+	eventsPublished.inc(eventType)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.matches(ev) {
+			continue
+		}
+		select {
+		case s.C <- ev:
+		default:
+			select {
+			case <-s.C:
+				eventsDropped.inc(s.name)
+			default:
+			}
+			select {
+			case s.C <- ev:
+			default:
+				eventsDropped.inc(s.name)
+			}
+		}
+	}
+}
+
+func (s *subscription) matches(ev Event) bool {
+	if !matchesEventType(s.types, ev.Type) {
+		return false
+	}
+	return s.filter == nil || s.filter(ev)
+}
+
+// matchesEventType reports whether an event type is selected by a list of
+// types and "prefix." patterns; an empty list selects everything
+func matchesEventType(types []string, eventType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == eventType || (strings.HasSuffix(t, ".") && strings.HasPrefix(eventType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// consume runs handle for each event of a subscription, for the life of the process
+func (s *subscription) consume(handle func(Event)) {
+	go func() {
+		for ev := range s.C {
+			handle(ev)
+		}
+	}()
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+var webhookDeliveries = newCounterVec("yolo_event_webhook_deliveries_total",
+	"Events POSTed to EVENT_WEBHOOK_URL, by outcome.", "status")
+
+// startEventWebhook POSTs each event of EVENT_WEBHOOK_TYPES (comma-separated,
+// default all) to EVENT_WEBHOOK_URL as a CloudEvent, signed with
+// EVENT_WEBHOOK_SECRET if set (see signing.go); all follow configuration reloads.
+// Failed deliveries are retried and eventually dead-lettered; see deliveries.go.
+func startEventWebhook() {
+	bus.subscribe("webhook", nil, nil, 256).consume(func(ev Event) {
+		target := config().EventWebhookURL
+		if target == "" || !matchesEventType(splitList(config().EventWebhookTypes), ev.Type) {
+			return
+		}
+		body, err := json.Marshal(toCloudEvent(ev))
+		if err != nil {
+			return
+		}
+		if err := deliveries.send(sinkWebhook, ev, "", body); err != nil {
+			webhookDeliveries.inc("error")
+			log.Printf("Warning: event webhook delivery of %s failed: %v", ev.Type, err)
+			return
+		}
+		webhookDeliveries.inc("success")
+	})
+}
+
+// eventsHandler serves GET /api/v1/events as a text/event-stream of bus
+// events as CloudEvents, each SSE event named by its bus type and carrying the
+// CloudEvent id; ?type=result.created,alert. selects types (a trailing "."
+// matches a family).
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	stream, ok := openEventStream(w, "events")
+	if !ok {
+		return
+	}
+	sub := bus.subscribe("sse", splitList(r.URL.Query().Get("type")), nil, 64)
+	defer bus.unsubscribe(sub)
+
+	if err := stream.send(": connected\n\n"); err != nil {
+		stream.close("write_error")
+		return
+	}
+	stream.serve(r, sub.C, func(ev Event) (bool, error) {
+		data, err := json.Marshal(toCloudEvent(ev))
+		if err != nil {
+			return true, nil
+		}
+		return true, stream.send("id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Uploads from drones and field cameras usually carry EXIF metadata with the
+// capture time and GPS position. Only JPEG (APP1) EXIF is read; images without
+// it simply produce no metadata.
+
+// GeoPoint is a WGS84 position from EXIF GPS tags
+type GeoPoint struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // metres above sea level
+}
+
+// ImageMetadata is the subset of EXIF kept with a result
+type ImageMetadata struct {
+	CapturedAt *time.Time
+	Location   *GeoPoint
+}
+
+// EXIF tags used below
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+	tagGPSAltitudeRef     = 0x0005
+	tagGPSAltitude        = 0x0006
+)
+
+// readImageMetadata extracts capture time and location from a JPEG's EXIF block
+func readImageMetadata(path string) (ImageMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	defer f.Close()
+
+	tiff, err := findExif(bufio.NewReader(f))
+	if err != nil || tiff == nil {
+		return ImageMetadata{}, err
+	}
+	return parseExif(tiff)
+}
+
+// findExif returns the TIFF payload of the JPEG's EXIF APP1 segment, or nil if
+// the file is not a JPEG or has no EXIF
+func findExif(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, nil
+	}
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, nil
+		}
+		if marker != 0xFF {
+			return nil, fmt.Errorf("malformed JPEG segment")
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, nil
+		}
+		if kind == 0xFF {
+			r.UnreadByte() // fill byte
+			continue
+		}
+		if kind == 0xDA || kind == 0xD9 {
+			return nil, nil // start of scan: no metadata past this point
+		}
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil || size < 2 {
+			return nil, nil
+		}
+		seg := make([]byte, size-2)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return nil, nil
+		}
+		if kind == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:], nil
+		}
+	}
+}
+
+// exifReader walks IFDs in a TIFF-structured EXIF payload
+type exifReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // raw value bytes, resolved from the offset when larger than 4
+}
+
+func parseExif(tiff []byte) (ImageMetadata, error) {
+	var meta ImageMetadata
+	if len(tiff) < 8 {
+		return meta, fmt.Errorf("truncated EXIF header")
+	}
+	x := &exifReader{data: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		x.order = binary.LittleEndian
+	case "MM":
+		x.order = binary.BigEndian
+	default:
+		return meta, fmt.Errorf("invalid EXIF byte order")
+	}
+
+	ifd0 := x.ifd(x.order.Uint32(tiff[4:]))
+	var exif, gps map[uint16]ifdEntry
+	if e, ok := ifd0[tagExifIFD]; ok {
+		exif = x.ifd(x.uint(e, 0))
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		gps = x.ifd(x.uint(e, 0))
+	}
+
+	// Prefer the original capture time; fall back to the file modification time tag
+	stamp, offset := x.ascii(exif[tagDateTimeOriginal]), x.ascii(exif[tagOffsetTimeOriginal])
+	if stamp == "" {
+		stamp = x.ascii(ifd0[tagDateTime])
+	}
+	if t, ok := parseExifTime(stamp, offset); ok {
+		meta.CapturedAt = &t
+	}
+
+	lat, latOK := x.degrees(gps[tagGPSLatitude])
+	lon, lonOK := x.degrees(gps[tagGPSLongitude])
+	if latOK && lonOK && !(lat == 0 && lon == 0) {
+		if x.ascii(gps[tagGPSLatitudeRef]) == "S" {
+			lat = -lat
+		}
+		if x.ascii(gps[tagGPSLongitudeRef]) == "W" {
+			lon = -lon
+		}
+		meta.Location = &GeoPoint{Latitude: lat, Longitude: lon}
+		if alt, ok := x.rational(gps[tagGPSAltitude], 0); ok {
+			if ref, ok := gps[tagGPSAltitudeRef]; ok && len(ref.value) > 0 && ref.value[0] == 1 {
+				alt = -alt
+			}
+			meta.Location.Altitude = &alt
+		}
+	}
+	return meta, nil
+}
+
+// exifTypeSize is the byte size of each EXIF value type, indexed by type
+var exifTypeSize = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// ifd reads the directory at offset; malformed entries are skipped
+func (x *exifReader) ifd(offset uint32) map[uint16]ifdEntry {
+	entries := map[uint16]ifdEntry{}
+	if offset == 0 || uint64(offset)+2 > uint64(len(x.data)) {
+		return entries
+	}
+	n := int(x.order.Uint16(x.data[offset:]))
+	for i := 0; i < n; i++ {
+		p := int(offset) + 2 + i*12
+		if p+12 > len(x.data) {
+			break
+		}
+		typ := x.order.Uint16(x.data[p+2:])
+		count := x.order.Uint32(x.data[p+4:])
+		if int(typ) >= len(exifTypeSize) || exifTypeSize[typ] == 0 {
+			continue
+		}
+		size := uint64(exifTypeSize[typ]) * uint64(count)
+		value := x.data[p+8 : p+12]
+		if size > 4 {
+			off := uint64(x.order.Uint32(x.data[p+8:]))
+			if off+size > uint64(len(x.data)) {
+				continue
+			}
+			value = x.data[off : off+size]
+		}
+		entries[x.order.Uint16(x.data[p:])] = ifdEntry{typ: typ, count: count, value: value}
+	}
+	return entries
+}
+
+// uint returns the i-th SHORT or LONG value of e
+func (x *exifReader) uint(e ifdEntry, i int) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2*(i+1):
+		return uint32(x.order.Uint16(e.value[2*i:]))
+	case e.typ == 4 && len(e.value) >= 4*(i+1):
+		return x.order.Uint32(e.value[4*i:])
+	}
+	return 0
+}
+
+func (x *exifReader) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// rational returns the i-th unsigned RATIONAL value of e
+func (x *exifReader) rational(e ifdEntry, i int) (float64, bool) {
+	if e.typ != 5 || len(e.value) < 8*(i+1) {
+		return 0, false
+	}
+	num := x.order.Uint32(e.value[8*i:])
+	den := x.order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// degrees converts a degrees/minutes/seconds GPS triple to decimal degrees
+func (x *exifReader) degrees(e ifdEntry) (float64, bool) {
+	var dms [3]float64
+	for i := range dms {
+		v, ok := x.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		dms[i] = v
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if math.IsNaN(deg) || deg > 180 {
+		return 0, false
+	}
+	return deg, true
+}
+
+// parseExifTime parses an EXIF "2006:01:02 15:04:05" timestamp. EXIF times are
+// local to the camera; without an offset tag they are assumed to be UTC.
+func parseExifTime(stamp, offset string) (time.Time, bool) {
+	if stamp == "" || strings.HasPrefix(stamp, "0000") {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", stamp+offset); err == nil {
+			return t.UTC(), true
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With FAULT_INJECTION set, /admin/faults on the admin listener injects the
+// failures an edge node meets, to see the circuit breaker, the fallback, the
+// spools and the UI handle them before the field does:
+//
+//	POST /admin/faults {"inference_delay": "3s", "backend_failure_percent": "50", "network_status": "offline", "for": "10m"}
+//	POST /admin/faults {"clear": "true"}
+//	POST /admin/faults {}    the active faults
+//
+// inference_delay holds every call to the local backend; of the calls,
+// backend_failure_percent fail as a crashed backend would, which counts
+// towards BREAKER_FAILURES; network_status replaces what the network status
+// provider says, gating training, sync and pulls. Setting faults replaces
+// the previous ones. They end after "for" (default 10m, at most 24h) and
+// with the process, so a forgotten test never outlives a restart.
+
+// faultMaxDuration bounds how long injected faults last
+const faultMaxDuration = 24 * time.Hour
+
+// FaultsView is the injected faults
+type FaultsView struct {
+	Active                bool       `json:"active"`
+	InferenceDelay        string     `json:"inference_delay,omitempty"`
+	BackendFailurePercent float64    `json:"backend_failure_percent,omitempty"`
+	NetworkStatus         string     `json:"network_status,omitempty"`
+	Until                 *time.Time `json:"until,omitempty"`
+}
+
+var faults = struct {
+	sync.Mutex
+	delay   time.Duration
+	percent float64
+	network string
+	until   time.Time
+}{}
+
+var faultsInjected = newCounterVec("yolo_faults_injected_total",
+	"Faults injected through /admin/faults by kind.", "fault")
+
+// faultsActiveLocked reports whether faults are set and not expired
+func faultsActiveLocked() bool {
+	return time.Now().Before(faults.until)
+}
+
+func faultsView() FaultsView {
+	faults.Lock()
+	defer faults.Unlock()
+	if !faultsActiveLocked() {
+		return FaultsView{}
+	}
+	v := FaultsView{Active: true, BackendFailurePercent: faults.percent, NetworkStatus: faults.network}
+	if faults.delay > 0 {
+		v.InferenceDelay = faults.delay.String()
+	}
+	until := faults.until.UTC()
+	v.Until = &until
+	return v
+}
+
+// injectInferenceFault delays a local backend call and may fail it
+func injectInferenceFault() error {
+	faults.Lock()
+	active := faultsActiveLocked()
+	delay, percent := faults.delay, faults.percent
+	faults.Unlock()
+	if !active {
+		return nil
+	}
+	if delay > 0 {
+		faultsInjected.inc("inference_delay")
+		time.Sleep(delay)
+	}
+	if percent > 0 && rand.Float64()*100 < percent {
+		faultsInjected.inc("backend_failure")
+		return fmt.Errorf("injected backend failure")
+	}
+	return nil
+}
+
+// injectedNetworkStatus returns the network status set by a fault, if any
+func injectedNetworkStatus() (string, bool) {
+	faults.Lock()
+	defer faults.Unlock()
+	if !faultsActiveLocked() || faults.network == "" {
+		return "", false
+	}
+	return faults.network, true
+}
+
+// adminFaults sets, clears or shows the injected faults
+func adminFaults(ctx context.Context, args map[string]string) (interface{}, error) {
+	if !config().FaultInjection {
+		return nil, fmt.Errorf("fault injection is disabled; set FAULT_INJECTION=true")
+	}
+	if args["clear"] == "true" {
+		faults.Lock()
+		faults.delay, faults.percent, faults.network, faults.until = 0, 0, "", time.Time{}
+		faults.Unlock()
+		log.Printf("Injected faults cleared")
+		return faultsView(), nil
+	}
+	if len(args) == 0 {
+		return faultsView(), nil
+	}
+
+	var delay time.Duration
+	var percent float64
+	var err error
+	if s := args["inference_delay"]; s != "" {
+		if delay, err = time.ParseDuration(s); err != nil || delay < 0 {
+			return nil, fmt.Errorf("inference_delay must be a duration such as 500ms")
+		}
+	}
+	if s := args["backend_failure_percent"]; s != "" {
+		if percent, err = strconv.ParseFloat(s, 64); err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("backend_failure_percent must be between 0 and 100")
+		}
+	}
+	network := strings.TrimSpace(args["network_status"])
+	duration := 10 * time.Minute
+	if s := args["for"]; s != "" {
+		if duration, err = time.ParseDuration(s); err != nil || duration <= 0 || duration > faultMaxDuration {
+			return nil, fmt.Errorf("for must be a duration of at most %s", faultMaxDuration)
+		}
+	}
+	if delay == 0 && percent == 0 && network == "" {
+		return nil, fmt.Errorf("set inference_delay, backend_failure_percent or network_status")
+	}
+
+	faults.Lock()
+	faults.delay, faults.percent, faults.network = delay, percent, network
+	faults.until = time.Now().Add(duration)
+	faults.Unlock()
+	if network != "" {
+		faultsInjected.inc("network_status")
+	}
+	log.Printf("Warning: injecting faults for %s: inference delay %s, %g%% backend failures, network status %q",
+		duration, delay, percent, network)
+	return faultsView(), nil
+}
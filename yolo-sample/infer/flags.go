@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Feature flags gate experimental capabilities. FEATURE_FLAGS is a JSON object
+// of flag settings, set in the environment, CONFIG_FILE or a fleet bundle (see
+// fleet.go), so flags change with a configuration reload:
+//
+//	{
+//	  "tta":                {"rollout": 25, "nodes": ["edge-dock-1"]},
+//	  "annotation-overlay": {"enabled": false}
+//	}
+//
+// A flag is on for nodes listed in Nodes, otherwise when Enabled is set, and
+// otherwise for Rollout percent of nodes, picked by a stable hash of the flag
+// and NODE_NAME so a node keeps its answer as the rollout grows. Unset flags take
+// their default from featureFlags. /api/v1/flags shows what this node runs.
+
+// FeatureFlag is the FEATURE_FLAGS setting of one flag
+type FeatureFlag struct {
+	Enabled *bool    `json:"enabled,omitempty"`
+	Rollout float64  `json:"rollout,omitempty"` // percent of nodes, 0-100
+	Nodes   []string `json:"nodes,omitempty"`
+}
+
+// featureFlags are the flags this node knows, with their defaults
+var featureFlags = map[string]struct {
+	description string
+	def         bool
+}{
+	"tta":                {"Test-time augmentation: slower, flip/scale-averaged inference", false},
+	"annotation-overlay": {"Draw detection boxes over the image on the result page", true},
+}
+
+// FeatureFlagView is the API representation of a flag on this node
+type FeatureFlagView struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Enabled     bool         `json:"enabled"`
+	Default     bool         `json:"default"`
+	Reason      string       `json:"reason"` // "default", "node", "enabled", "rollout"
+	Setting     *FeatureFlag `json:"setting,omitempty"`
+}
+
+// validateFeatureFlags checks the FEATURE_FLAGS settings; flags this node does
+// not know are logged, since a fleet may run several versions
+func validateFeatureFlags(flags map[string]FeatureFlag) error {
+	for name, f := range flags {
+		if f.Rollout < 0 || f.Rollout > 100 {
+			return fmt.Errorf("FEATURE_FLAGS: rollout of %s must be between 0 and 100", name)
+		}
+		if _, known := featureFlags[name]; !known {
+			log.Printf("Warning: FEATURE_FLAGS sets unknown flag %q", name)
+		}
+	}
+	return nil
+}
+
+// featureEnabled reports whether a flag is on for this node
+func featureEnabled(name string) bool {
+	on, _ := evaluateFlag(name, getEnv("NODE_NAME", "unknown"))
+	return on
+}
+
+func evaluateFlag(name, node string) (bool, string) {
+	f, set := config().FeatureFlags[name]
+	if !set {
+		return featureFlags[name].def, "default"
+	}
+	for _, n := range f.Nodes {
+		if n == node {
+			return true, "node"
+		}
+	}
+	if f.Enabled != nil {
+		return *f.Enabled, "enabled"
+	}
+	if f.Rollout > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(name + "/" + node))
+		return float64(h.Sum32()%10000) < f.Rollout*100, "rollout"
+	}
+	return featureFlags[name].def, "default"
+}
+
+// flagsHandler serves GET /api/v1/flags: the known flags and whether each is on here
+func flagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	node := getEnv("NODE_NAME", "unknown")
+	out := make([]FeatureFlagView, 0, len(featureFlags))
+	for name, known := range featureFlags {
+		v := FeatureFlagView{Name: name, Description: known.description, Default: known.def}
+		v.Enabled, v.Reason = evaluateFlag(name, node)
+		if f, ok := config().FeatureFlags[name]; ok {
+			v.Setting = &f
+		}
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"node": node, "flags": out})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fleet configuration lets a management server configure many nodes centrally.
+// On the fleet-config schedule (SCHEDULE_FLEET_CONFIG, every 5 minutes when
+// FLEET_CONFIG_URL is set) an online node GETs FLEET_CONFIG_URL, sending its
+// applied version in If-None-Match, and expects 304 or a signed envelope:
+//
+//	{"bundle": "<base64 bundle JSON>", "signature": "<base64 ed25519 signature of the bundle bytes>"}
+//
+// where the bundle is
+//
+//	{"version": "2026-10-14.1", "issued_at": "2026-10-14T09:30:00Z", "settings": {"INFERENCE_THRESHOLD": "0.4", "RETENTION_DAYS": "14"}}
+//
+// The signature is checked against FLEET_CONFIG_PUBLIC_KEY. A pulled bundle
+// must be issued after the applied one, so replaying an older signed bundle
+// cannot revert the settings. Bundle settings
+// take precedence over CONFIG_FILE and the environment and are applied through
+// a configuration reload (see reload.go), so they are validated the same way;
+// a bundle that fails is not kept. The outcome is POSTed back to
+// FLEET_CONFIG_URL as a fleetReport. The applied bundle is stored in
+// STATE_DIR/fleet-config.json and verified again at startup.
+
+// fleetOnlySettings cannot be set by a bundle, nor by the desired state
+var fleetOnlySettings = []string{"STATE_DIR", "CONFIG_FILE", "CONFIG_RELOAD_INTERVAL", "FLEET_CONFIG_URL", "FLEET_CONFIG_PUBLIC_KEY", "SCHEDULE_FLEET_CONFIG", "UPDATE_SIGNING_KEYS", "DESIRED_STATE_SOURCE", "SCHEDULE_RECONCILE"}
+
+// fleetMaxBytes bounds the size of a bundle envelope
+const fleetMaxBytes = 1 << 20
+
+// fleetEnvelope is a bundle and its signature as served by FLEET_CONFIG_URL
+type fleetEnvelope struct {
+	Bundle    []byte `json:"bundle"`    // base64 in JSON
+	Signature []byte `json:"signature"` // base64 in JSON
+}
+
+// FleetBundle is a versioned set of settings from the fleet management server
+type FleetBundle struct {
+	Version  string            `json:"version"`
+	IssuedAt time.Time         `json:"issued_at"`
+	Settings map[string]string `json:"settings"`
+}
+
+// fleetReport is sent to FLEET_CONFIG_URL after a bundle is tried
+type fleetReport struct {
+	Node    string `json:"node"`
+	Version string `json:"version"`
+	Status  string `json:"status"` // "applied" or "rejected"
+	Error   string `json:"error,omitempty"`
+	Applied string `json:"applied_version,omitempty"` // version in effect afterwards
+	Build   string `json:"build"`                     // node software version, see version.go
+}
+
+// FleetView is the API representation of the fleet configuration state
+type FleetView struct {
+	URL       string     `json:"url,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	Settings  []string   `json:"settings,omitempty"` // keys set by the bundle
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type fleetState struct {
+	mu        sync.Mutex
+	loaded    bool
+	envelope  []byte
+	bundle    *FleetBundle
+	appliedAt time.Time
+	lastCheck time.Time
+	lastErr   error
+}
+
+var fleet = &fleetState{}
+
+var fleetClient = &http.Client{Timeout: 30 * time.Second}
+
+var fleetPulls = newCounterVec("yolo_fleet_config_pulls_total",
+	"Fleet configuration pulls by outcome.", "status")
+
+func fleetBundlePath(stateDir string) string {
+	return filepath.Join(stateDir, "fleet-config.json")
+}
+
+// verifyFleetEnvelope checks an envelope's signature and returns its bundle
+func verifyFleetEnvelope(data []byte, publicKey string) (*FleetBundle, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("FLEET_CONFIG_PUBLIC_KEY is not a base64 ed25519 public key")
+	}
+	var env fleetEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %v", err)
+	}
+	if !ed25519.Verify(key, env.Bundle, env.Signature) {
+		return nil, fmt.Errorf("bad signature")
+	}
+	var b FleetBundle
+	if err := json.Unmarshal(env.Bundle, &b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if b.Version == "" {
+		return nil, fmt.Errorf("bundle has no version")
+	}
+	for key := range b.Settings {
+		if !settingKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid setting name %q", key)
+		}
+		if contains(fleetOnlySettings, key) {
+			return nil, fmt.Errorf("%s cannot be set by a bundle", key)
+		}
+	}
+	return &b, nil
+}
+
+// withFleetSettings layers the applied bundle's settings over file. At
+// startup the stored bundle is read first, from the STATE_DIR and with the
+// public key that file and the environment give.
+func withFleetSettings(file map[string]string) map[string]string {
+	fleet.mu.Lock()
+	defer fleet.mu.Unlock()
+	if !fleet.loaded {
+		fleet.loaded = true
+		s := &settings{file: file}
+		path := fleetBundlePath(s.getEnv("STATE_DIR", defaultStateDir()))
+		if data, err := os.ReadFile(path); err == nil {
+			b, err := verifyFleetEnvelope(data, s.lookup("FLEET_CONFIG_PUBLIC_KEY"))
+			if err != nil {
+				log.Printf("Warning: ignoring stored fleet bundle %s: %v", path, err)
+			} else {
+				fleet.envelope, fleet.bundle = data, b
+				if fi, err := os.Stat(path); err == nil {
+					fleet.appliedAt = fi.ModTime().UTC()
+				}
+				log.Printf("Using fleet configuration version %s", b.Version)
+			}
+		}
+	}
+	if fleet.bundle == nil {
+		return file
+	}
+	merged := make(map[string]string, len(file)+len(fleet.bundle.Settings))
+	for k, v := range file {
+		merged[k] = v
+	}
+	for k, v := range fleet.bundle.Settings {
+		merged[k] = v
+	}
+	return merged
+}
+
+// applied returns the applied bundle's version and issue time
+func (f *fleetState) applied() (string, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bundle == nil {
+		return "", time.Time{}
+	}
+	return f.bundle.Version, f.bundle.IssuedAt
+}
+
+func (f *fleetState) version() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bundle == nil {
+		return ""
+	}
+	return f.bundle.Version
+}
+
+// pullFleetConfig fetches, verifies and applies the bundle at FLEET_CONFIG_URL
+func pullFleetConfig() error {
+	cfg := config()
+	if cfg.FleetConfigURL == "" {
+		return fmt.Errorf("FLEET_CONFIG_URL is not configured")
+	}
+	if status := getNodeStatus(); status.NetworkStatus != "online" {
+		return fmt.Errorf("skipping fleet configuration pull: network status is %s", status.NetworkStatus)
+	}
+	err := fleet.pull(cfg)
+	fleet.mu.Lock()
+	fleet.lastCheck, fleet.lastErr = time.Now().UTC(), err
+	fleet.mu.Unlock()
+	if err != nil {
+		fleetPulls.inc("error")
+	}
+	return err
+}
+
+func (f *fleetState) pull(cfg *Config) error {
+	req, err := http.NewRequest(http.MethodGet, cfg.FleetConfigURL, nil)
+	if err != nil {
+		return err
+	}
+	current, issued := f.applied()
+	if current != "" {
+		req.Header.Set("If-None-Match", `"`+current+`"`)
+	}
+	req.Header.Set("X-Node-Name", getEnv("NODE_NAME", "unknown"))
+	resp, err := fleetClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fleet configuration pull failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		fleetPulls.inc("unchanged")
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fleet configuration server returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fleetMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > fleetMaxBytes {
+		return fmt.Errorf("fleet bundle exceeds %d bytes", fleetMaxBytes)
+	}
+	b, err := verifyFleetEnvelope(data, cfg.FleetConfigPublicKey)
+	if err != nil {
+		// An unverified bundle is never reported as a version
+		return fmt.Errorf("rejected fleet bundle: %v", err)
+	}
+	if b.Version == current {
+		fleetPulls.inc("unchanged")
+		return nil
+	}
+	if b.IssuedAt.IsZero() {
+		return fmt.Errorf("rejected fleet bundle %s: bundle has no issued_at", b.Version)
+	}
+	if !b.IssuedAt.After(issued) {
+		return fmt.Errorf("rejected fleet bundle %s: issued %s, not after the applied bundle %s (issued %s)",
+			b.Version, b.IssuedAt.Format(time.RFC3339), current, issued.Format(time.RFC3339))
+	}
+
+	f.mu.Lock()
+	prevEnvelope, prevBundle, prevApplied := f.envelope, f.bundle, f.appliedAt
+	f.envelope, f.bundle, f.appliedAt = data, b, time.Now().UTC()
+	f.mu.Unlock()
+
+	err = reloader.reload("fleet")
+	if err == nil {
+		err = writeFileAtomic(fleetBundlePath(cfg.StateDir), data)
+	}
+	if err != nil {
+		f.mu.Lock()
+		f.envelope, f.bundle, f.appliedAt = prevEnvelope, prevBundle, prevApplied
+		f.mu.Unlock()
+		reportFleetConfig(cfg, b.Version, err)
+		return fmt.Errorf("fleet bundle %s not applied: %v", b.Version, err)
+	}
+	log.Printf("Applied fleet configuration version %s", b.Version)
+	fleetPulls.inc("applied")
+	reportFleetConfig(cfg, b.Version, nil)
+	return nil
+}
+
+// reportFleetConfig tells the management server whether a bundle was applied
+func reportFleetConfig(cfg *Config, version string, applyErr error) {
+	report := fleetReport{Node: getEnv("NODE_NAME", "unknown"), Version: version, Status: "applied", Applied: fleet.version(), Build: buildVersion}
+	if applyErr != nil {
+		report.Status, report.Error = "rejected", applyErr.Error()
+	}
+	body, _ := json.Marshal(report)
+	resp, err := fleetClient.Post(cfg.FleetConfigURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: failed to report fleet configuration status: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Warning: fleet configuration server rejected status report: %s", resp.Status)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *fleetState) view() FleetView {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v := FleetView{URL: config().FleetConfigURL}
+	if f.bundle != nil {
+		v.Version = f.bundle.Version
+		for k := range f.bundle.Settings {
+			v.Settings = append(v.Settings, k)
+		}
+		sort.Strings(v.Settings)
+	}
+	if !f.appliedAt.IsZero() {
+		t := f.appliedAt
+		v.AppliedAt = &t
+	}
+	if !f.lastCheck.IsZero() {
+		t := f.lastCheck
+		v.LastCheck = &t
+	}
+	if f.lastErr != nil {
+		v.LastError = f.lastErr.Error()
+	}
+	return v
+}
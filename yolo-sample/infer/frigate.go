@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// /api/events mirrors the event API of the Frigate NVR, so dashboards and
+// integrations written for Frigate can read this node's detections as they are:
+//
+//	GET /api/events                        events, newest first
+//	GET /api/events/{id}                   one event
+//	GET /api/events/{id}/snapshot.jpg      the stored image; ?bbox=1 draws the box,
+//	                                       ?crop=1 crops to it, ?h= scales to a height
+//	GET /api/events/{id}/thumbnail.jpg     a small crop around the object
+//	GET /api/events/{id}/clip.mp4          always 404: the node keeps stills, not video
+//
+// Frigate tracks one object per event, so each detection of a result is an
+// event, with ID <result id>-<detection index>. The camera is the result
+// source and start and end time are both the result time. The list takes
+// Frigate's camera, label (comma-separated, or "all"), after, before (Unix
+// seconds), min_score, has_snapshot, has_clip, include_thumbnails and limit
+// (default 100) parameters. An incorrect verdict recorded as feedback on a
+// detection (see results.go) shows as false_positive.
+
+// frigateThumbnailSize is the longest side of an event thumbnail, as in Frigate
+const frigateThumbnailSize = 175
+
+// FrigateEvent is an event in the shape of Frigate's events API
+type FrigateEvent struct {
+	ID                 string           `json:"id"`
+	Camera             string           `json:"camera"`
+	Label              string           `json:"label"`
+	SubLabel           *string          `json:"sub_label"`
+	Zones              []string         `json:"zones"`
+	StartTime          float64          `json:"start_time"`
+	EndTime            float64          `json:"end_time"`
+	HasClip            bool             `json:"has_clip"`
+	HasSnapshot        bool             `json:"has_snapshot"`
+	RetainIndefinitely bool             `json:"retain_indefinitely"`
+	FalsePositive      *bool            `json:"false_positive"`
+	TopScore           float64          `json:"top_score"`
+	PlusID             *string          `json:"plus_id"`
+	Thumbnail          string           `json:"thumbnail,omitempty"` // base64 JPEG
+	Data               FrigateEventData `json:"data"`
+}
+
+// FrigateEventData holds the object details of a FrigateEvent
+type FrigateEventData struct {
+	Box        []float64 `json:"box"`    // x, y, width, height as fractions of the frame
+	Region     []float64 `json:"region"` // the area the object was detected in
+	Score      float64   `json:"score"`
+	TopScore   float64   `json:"top_score"`
+	Attributes []string  `json:"attributes"`
+	Type       string    `json:"type"`
+}
+
+// frigateError writes an error in Frigate's response shape
+func frigateError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"success": false, "message": msg})
+}
+
+// frameSize is the size of the image a result's boxes refer to, or zero when unknown
+func frameSize(res InferenceResult) image.Point {
+	if res.ImageWidth > 0 && res.ImageHeight > 0 {
+		return image.Point{X: res.ImageWidth, Y: res.ImageHeight}
+	}
+	// Results stored before image sizes were recorded: a full copy has the original size
+	if res.StoredImage != "" && res.ImageRetention != retentionThumbnail {
+		if size, err := imageSize(storedImagePath(res)); err == nil {
+			return size
+		}
+	}
+	return image.Point{}
+}
+
+// frigateEvent converts detection i of res
+func frigateEvent(res InferenceResult, i int, size image.Point) FrigateEvent {
+	d := res.Detections[i]
+	t := float64(res.CreatedAt.UnixNano()) / 1e9
+	ev := FrigateEvent{
+		ID:          res.ID + "-" + strconv.Itoa(i),
+		Camera:      res.Source,
+		Label:       d.ClassName,
+		Zones:       []string{},
+		StartTime:   t,
+		EndTime:     t,
+		HasSnapshot: res.StoredImage != "",
+		TopScore:    d.Confidence,
+		Data: FrigateEventData{
+			Score:      d.Confidence,
+			TopScore:   d.Confidence,
+			Attributes: []string{},
+			Type:       "object",
+		},
+	}
+	if size.X > 0 && size.Y > 0 {
+		w, h := float64(size.X), float64(size.Y)
+		ev.Data.Box = []float64{d.BBox.X1 / w, d.BBox.Y1 / h, (d.BBox.X2 - d.BBox.X1) / w, (d.BBox.Y2 - d.BBox.Y1) / h}
+		ev.Data.Region = []float64{0, 0, 1, 1}
+	}
+	for _, fb := range res.Feedback {
+		if fb.Detection != nil && *fb.Detection == i && fb.Verdict != "missed" {
+			fp := fb.Verdict == "incorrect"
+			ev.FalsePositive = &fp
+		}
+	}
+	return ev
+}
+
+// frigateEventByID finds the result and detection index of an event ID
+func frigateEventByID(id string) (InferenceResult, int, bool) {
+	cut := strings.LastIndex(id, "-")
+	if cut < 0 {
+		return InferenceResult{}, 0, false
+	}
+	i, err := strconv.Atoi(id[cut+1:])
+	res, ok := results.get(id[:cut])
+	if err != nil || !ok || i < 0 || i >= len(res.Detections) {
+		return InferenceResult{}, 0, false
+	}
+	return res, i, true
+}
+
+// frigateFilter holds the list parameters of /api/events
+type frigateFilter struct {
+	cameras, labels map[string]bool
+	after, before   float64
+	minScore        float64
+	hasSnapshot     string // "", "0" or "1"
+	hasClip         string
+}
+
+func parseFrigateFilter(r *http.Request) frigateFilter {
+	q := r.URL.Query()
+	set := func(name string) map[string]bool {
+		v := q.Get(name)
+		if v == "" || v == "all" {
+			return nil
+		}
+		m := map[string]bool{}
+		for _, s := range splitList(v) {
+			m[s] = true
+		}
+		return m
+	}
+	f := frigateFilter{cameras: set("camera"), labels: set("label"), hasSnapshot: q.Get("has_snapshot"), hasClip: q.Get("has_clip")}
+	f.after, _ = strconv.ParseFloat(q.Get("after"), 64)
+	f.before, _ = strconv.ParseFloat(q.Get("before"), 64)
+	f.minScore, _ = strconv.ParseFloat(q.Get("min_score"), 64)
+	return f
+}
+
+func (f frigateFilter) matches(ev FrigateEvent) bool {
+	switch {
+	case f.cameras != nil && !f.cameras[ev.Camera],
+		f.labels != nil && !f.labels[ev.Label],
+		f.after > 0 && ev.StartTime <= f.after,
+		f.before > 0 && ev.StartTime >= f.before,
+		ev.TopScore < f.minScore,
+		f.hasSnapshot == "1" && !ev.HasSnapshot,
+		f.hasSnapshot == "0" && ev.HasSnapshot,
+		f.hasClip == "1":
+		return false
+	}
+	return true
+}
+
+// frigateEventsHandler serves the Frigate-compatible /api/events routes
+func frigateEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		frigateError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/events"), "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		frigateListEvents(w, r)
+		return
+	}
+	res, i, ok := frigateEventByID(parts[0])
+	if !ok {
+		frigateError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		writeJSON(w, http.StatusOK, frigateEvent(res, i, frameSize(res)))
+	case len(parts) == 2 && parts[1] == "snapshot.jpg":
+		frigateSnapshot(w, r, res, i)
+	case len(parts) == 2 && parts[1] == "thumbnail.jpg":
+		img, err := frigateThumbnail(res, i)
+		if err != nil {
+			frigateError(w, http.StatusNotFound, "Thumbnail not available")
+			return
+		}
+		writeFrigateJPEG(w, img, 70)
+	case len(parts) == 2 && parts[1] == "clip.mp4":
+		frigateError(w, http.StatusNotFound, "Clip not available")
+	default:
+		frigateError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func frigateListEvents(w http.ResponseWriter, r *http.Request) {
+	f := parseFrigateFilter(r)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	thumbnails := r.URL.Query().Get("include_thumbnails") != "0"
+
+	out := []FrigateEvent{}
+	for _, res := range results.list(0) {
+		if res.Error != "" || len(res.Detections) == 0 || (f.cameras != nil && !f.cameras[res.Source]) {
+			continue
+		}
+		size := frameSize(res)
+		var frame image.Image
+		for i := range res.Detections {
+			ev := frigateEvent(res, i, size)
+			if !f.matches(ev) {
+				continue
+			}
+			if thumbnails && ev.HasSnapshot {
+				if frame == nil {
+					frame, _ = decodeImageFile(storedImagePath(res))
+				}
+				if frame != nil {
+					var buf bytes.Buffer
+					if jpeg.Encode(&buf, eventCrop(frame, res, i, size), &jpeg.Options{Quality: 70}) == nil {
+						ev.Thumbnail = base64.StdEncoding.EncodeToString(buf.Bytes())
+					}
+				}
+			}
+			out = append(out, ev)
+			if len(out) == limit {
+				writeJSON(w, http.StatusOK, out)
+				return
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// scaledBox maps detection i of res onto the stored image, which is smaller
+// than the original frame for thumbnail retention
+func scaledBox(res InferenceResult, i int, size image.Point, stored image.Rectangle) image.Rectangle {
+	d := res.Detections[i].BBox
+	sx, sy := 1.0, 1.0
+	if size.X > 0 && size.Y > 0 {
+		sx, sy = float64(stored.Dx())/float64(size.X), float64(stored.Dy())/float64(size.Y)
+	}
+	return image.Rect(
+		stored.Min.X+int(d.X1*sx), stored.Min.Y+int(d.Y1*sy),
+		stored.Min.X+int(d.X2*sx), stored.Min.Y+int(d.Y2*sy),
+	).Intersect(stored)
+}
+
+// eventCrop returns a thumbnail of the object of detection i, padded around its box
+func eventCrop(frame image.Image, res InferenceResult, i int, size image.Point) image.Image {
+	box := scaledBox(res, i, size, frame.Bounds())
+	pad := maxInt(box.Dx(), box.Dy()) / 4
+	region := image.Rect(box.Min.X-pad, box.Min.Y-pad, box.Max.X+pad, box.Max.Y+pad).Intersect(frame.Bounds())
+	if region.Empty() {
+		region = frame.Bounds()
+	}
+	crop := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(crop, crop.Bounds(), frame, region.Min, draw.Src)
+	return resizeImage(crop, frigateThumbnailSize)
+}
+
+func frigateThumbnail(res InferenceResult, i int) (image.Image, error) {
+	frame, err := decodeImageFile(storedImagePath(res))
+	if err != nil {
+		return nil, err
+	}
+	return eventCrop(frame, res, i, frameSize(res)), nil
+}
+
+func frigateSnapshot(w http.ResponseWriter, r *http.Request, res InferenceResult, i int) {
+	if res.StoredImage == "" {
+		frigateError(w, http.StatusNotFound, "Snapshot not available")
+		return
+	}
+	frame, err := decodeImageFile(storedImagePath(res))
+	if err != nil {
+		frigateError(w, http.StatusNotFound, "Snapshot not available")
+		return
+	}
+	q := r.URL.Query()
+	size := frameSize(res)
+	out := image.NewRGBA(frame.Bounds())
+	draw.Draw(out, out.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	var img image.Image = out
+	if q.Get("bbox") == "1" {
+		box := scaledBox(res, i, size, out.Bounds())
+		d := res.Detections[i]
+		d.BBox = BBox{X1: float64(box.Min.X - out.Bounds().Min.X), Y1: float64(box.Min.Y - out.Bounds().Min.Y), X2: float64(box.Max.X - out.Bounds().Min.X), Y2: float64(box.Max.Y - out.Bounds().Min.Y)}
+		drawBoxes(out, []Detection{d})
+	}
+	if q.Get("crop") == "1" {
+		img = eventCrop(out, res, i, size)
+	}
+	if h, err := strconv.Atoi(q.Get("h")); err == nil && h > 0 && h < img.Bounds().Dy() {
+		b := img.Bounds()
+		img = resizeImage(img, maxInt(h, b.Dx()*h/b.Dy()))
+	}
+	quality, err := strconv.Atoi(q.Get("quality"))
+	if err != nil || quality < 1 || quality > 100 {
+		quality = 70
+	}
+	writeFrigateJPEG(w, img, quality)
+}
+
+func writeFrigateJPEG(w http.ResponseWriter, img image.Image, quality int) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		frigateError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(buf.Bytes())
+}
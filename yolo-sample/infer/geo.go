@@ -0,0 +1,220 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geotagged results (see exif.go) are exposed as GeoJSON for GIS tools and
+// plotted on a simple map page. The page draws an SVG scatter plot rather than
+// map tiles, since edge nodes are often offline; each point links to
+// OpenStreetMap for context when a connection is available.
+
+// geoLimit bounds how many results are returned or plotted
+const geoLimit = 1000
+
+// geotaggedResults returns up to geoLimit results with a location, newest first
+func geotaggedResults() []InferenceResult {
+	var out []InferenceResult
+	for _, r := range results.list(0) {
+		if r.Location != nil {
+			out = append(out, r)
+			if len(out) == geoLimit {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// detectionSummary returns "2 person, 1 car" style counts for a result
+func detectionSummary(r InferenceResult) string {
+	counts := map[string]int{}
+	for _, d := range r.Detections {
+		counts[d.ClassName]++
+	}
+	classes := make([]string, 0, len(counts))
+	for c := range counts {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = strconv.Itoa(counts[c]) + " " + c
+	}
+	if len(parts) == 0 {
+		return "no detections"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// geoHandler serves GET /api/v1/geo: geotagged results as a GeoJSON FeatureCollection
+func geoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	features := make([]map[string]interface{}, 0)
+	for _, res := range geotaggedResults() {
+		coords := []float64{res.Location.Longitude, res.Location.Latitude}
+		if res.Location.Altitude != nil {
+			coords = append(coords, *res.Location.Altitude)
+		}
+		classes := make([]string, len(res.Detections))
+		for i, d := range res.Detections {
+			classes[i] = d.ClassName
+		}
+		features = append(features, map[string]interface{}{
+			"type":     "Feature",
+			"geometry": map[string]interface{}{"type": "Point", "coordinates": coords},
+			"properties": map[string]interface{}{
+				"id":          res.ID,
+				"created_at":  res.CreatedAt,
+				"captured_at": res.CapturedAt,
+				"model":       res.Model,
+				"source":      res.Source,
+				"count":       res.Count,
+				"classes":     classes,
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+// mapPoint is a geotagged result projected into the map's SVG viewport
+type mapPoint struct {
+	X, Y     float64
+	Result   InferenceResult
+	Summary  string
+	Lat, Lon float64
+}
+
+const mapWidth, mapHeight, mapPad = 760.0, 480.0, 20.0
+
+// mapHandler serves GET /map
+func mapHandler(w http.ResponseWriter, r *http.Request) {
+	geo := geotaggedResults()
+
+	// Fit an equirectangular projection to the points' bounding box
+	minLat, maxLat, minLon, maxLon := 90.0, -90.0, 180.0, -180.0
+	for _, res := range geo {
+		minLat, maxLat = minFloat(minLat, res.Location.Latitude), maxFloat(maxLat, res.Location.Latitude)
+		minLon, maxLon = minFloat(minLon, res.Location.Longitude), maxFloat(maxLon, res.Location.Longitude)
+	}
+	span := maxFloat(maxFloat(maxLat-minLat, maxLon-minLon), 1e-4)
+	scale := minFloat((mapWidth-2*mapPad)/span, (mapHeight-2*mapPad)/span)
+
+	points := make([]mapPoint, len(geo))
+	for i, res := range geo {
+		points[i] = mapPoint{
+			X:       mapPad + (res.Location.Longitude-minLon)*scale,
+			Y:       mapHeight - mapPad - (res.Location.Latitude-minLat)*scale,
+			Result:  res,
+			Summary: translate(negotiateLanguage(r), detectionSummary(res)),
+			Lat:     res.Location.Latitude,
+			Lon:     res.Location.Longitude,
+		}
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Map"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .map {
+            background: white;
+            padding: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        svg {
+            background-color: #e3f2fd;
+            border-radius: 4px;
+        }
+        circle {
+            fill: #4CAF50;
+            stroke: white;
+            stroke-width: 1.5;
+        }
+        circle.empty {
+            fill: #9e9e9e;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            margin-top: 20px;
+            font-size: 14px;
+        }
+        td, th {
+            text-align: left;
+            padding: 6px;
+            border-bottom: 1px solid #eee;
+        }
+        a {
+            color: #4CAF50;
+        }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Geotagged Detections"}}</h1>
+    <div class="map">
+        {{if .}}
+        <svg width="760" height="480" viewBox="0 0 760 480">
+            {{range .}}
+            <a href="https://www.openstreetmap.org/?mlat={{.Lat}}&mlon={{.Lon}}#map=17/{{.Lat}}/{{.Lon}}" target="_blank">
+                <circle cx="{{printf "%.1f" .X}}" cy="{{printf "%.1f" .Y}}" r="6"{{if not .Result.Count}} class="empty"{{end}}>
+                    <title>{{.Summary}} · {{printf "%.5f" .Lat}}, {{printf "%.5f" .Lon}}</title>
+                </circle>
+            </a>
+            {{end}}
+        </svg>
+        <table>
+            <tr><th>{{t "Captured"}}</th><th>{{t "Position"}}</th><th>{{t "Detections"}}</th><th></th></tr>
+            {{range .}}
+            <tr>
+                <td>{{if .Result.CapturedAt}}{{.Result.CapturedAt.Format "2006-01-02 15:04"}}{{else}}{{.Result.CreatedAt.Format "2006-01-02 15:04"}}{{end}}</td>
+                <td>{{printf "%.5f" .Lat}}, {{printf "%.5f" .Lon}}</td>
+                <td>{{.Summary}}</td>
+                <td>{{if .Result.StoredImage}}<a href="{{base}}/images/{{.Result.ID}}">{{t "image"}}</a>{{end}}</td>
+            </tr>
+            {{end}}
+        </table>
+        {{else}}
+        <p>{{t "No geotagged results yet. Upload images with GPS EXIF data to see them here."}}</p>
+        {{end}}
+    </div>
+    <br>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("map").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, points)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A golden set freezes the detections a model version gives on the reference
+// images in GOLDEN_DIR, so a later build of the same model, an export to
+// ONNX or TFLite, or the same file on other hardware can be checked to still
+// give them:
+//
+//	GET    /api/v1/golden                 the golden sets
+//	GET    /api/v1/golden/{model}         one set, to copy to other nodes
+//	PUT    /api/v1/golden/{model}         store a set frozen elsewhere
+//	DELETE /api/v1/golden/{model}
+//	POST   /api/v1/golden/{model}/freeze  run the model over GOLDEN_DIR and store the set
+//	POST   /api/v1/golden/{model}/verify  check the model: {"golden": "v2", "iou_tolerance": 0.9, "confidence_tolerance": 0.05}
+//
+// Verification runs the model over the set's images (found in GOLDEN_DIR by
+// name, and refused if their SHA-256 changed) at the threshold the set was
+// frozen at, and matches each detection to the frozen ones of its class.
+// A deviation is a frozen detection with no match of at least the IoU
+// tolerance, a new detection, or a match whose confidence moved by more than
+// the confidence tolerance. Detections within the confidence tolerance of the
+// threshold may come and go without a deviation. "golden" verifies against
+// another version's set, e.g. an ONNX export against its PyTorch original.
+// `webui verify -model v2` calls the endpoint and exits 1 on a deviation, for
+// a release pipeline; with GOLDEN_GATE set a canary is refused for a model
+// that fails its golden set.
+
+// GoldenImage is the frozen result of one reference image
+type GoldenImage struct {
+	Name       string      `json:"name"`
+	SHA256     string      `json:"sha256"`
+	Detections []Detection `json:"detections"`
+}
+
+// GoldenSet is the frozen results of a model version on the reference images
+type GoldenSet struct {
+	Model     string        `json:"model"`
+	FrozenAt  time.Time     `json:"frozen_at"`
+	Threshold float64       `json:"threshold"`
+	Images    []GoldenImage `json:"images"`
+}
+
+// GoldenDeviation is one difference from a golden set
+type GoldenDeviation struct {
+	Image    string     `json:"image"`
+	Kind     string     `json:"kind"` // "missing", "extra", "confidence", "image", "failed"
+	Class    string     `json:"class,omitempty"`
+	Expected *Detection `json:"expected,omitempty"`
+	Got      *Detection `json:"got,omitempty"`
+	IoU      float64    `json:"iou,omitempty"`
+	Detail   string     `json:"detail,omitempty"`
+}
+
+// GoldenReport is the outcome of verifying a model against a golden set
+type GoldenReport struct {
+	Model               string            `json:"model"`
+	Golden              string            `json:"golden"`
+	FrozenAt            time.Time         `json:"frozen_at"`
+	IoUTolerance        float64           `json:"iou_tolerance"`
+	ConfidenceTolerance float64           `json:"confidence_tolerance"`
+	Images              int               `json:"images"`
+	Detections          int               `json:"detections"` // frozen
+	Passed              bool              `json:"passed"`
+	Deviations          []GoldenDeviation `json:"deviations"`
+	DurationMS          float64           `json:"duration_ms"`
+}
+
+var errNoGoldenSet = errors.New("no golden set")
+
+func goldenDir() string {
+	return filepath.Join(config().StateDir, "golden")
+}
+
+func goldenPath(model string) string {
+	return filepath.Join(goldenDir(), model+".json")
+}
+
+// validGoldenName reports whether name can be a model version's set
+func validGoldenName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && name != "." && name != ".."
+}
+
+func loadGoldenSet(model string) (*GoldenSet, error) {
+	data, err := os.ReadFile(goldenPath(model))
+	if os.IsNotExist(err) {
+		return nil, errNoGoldenSet
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set GoldenSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("golden set of %s: %v", model, err)
+	}
+	return &set, nil
+}
+
+func saveGoldenSet(set *GoldenSet) error {
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(goldenDir(), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(goldenPath(set.Model), data)
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// freezeGoldenSet runs model over GOLDEN_DIR and stores its detections
+func freezeGoldenSet(model string) (*GoldenSet, error) {
+	if !modelExists(model) {
+		return nil, fmt.Errorf("model %q not found in %s", model, config().ModelDir)
+	}
+	paths, err := listEvalImages(config().GoldenDir)
+	if err == nil && len(paths) == 0 {
+		err = fmt.Errorf("no images found in %s", config().GoldenDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	set := &GoldenSet{Model: model, FrozenAt: time.Now().UTC(), Threshold: config().InferenceThreshold}
+	for _, p := range paths {
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return nil, err
+		}
+		result := runInferenceThreshold(p, model, set.Threshold)
+		if result.Error != "" {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(p), result.Error)
+		}
+		name, _ := filepath.Rel(config().GoldenDir, p)
+		set.Images = append(set.Images, GoldenImage{Name: filepath.ToSlash(name), SHA256: sum, Detections: result.Detections})
+	}
+	if err := saveGoldenSet(set); err != nil {
+		return nil, err
+	}
+	log.Printf("Golden set of %s frozen on %d images", model, len(set.Images))
+	return set, nil
+}
+
+// verifyGolden runs model over the images of golden's set and compares;
+// zero tolerances take the configured ones
+func verifyGolden(model, golden string, iouTol, confTol float64) (*GoldenReport, error) {
+	if golden == "" {
+		golden = model
+	}
+	if !modelExists(model) {
+		return nil, fmt.Errorf("model %q not found in %s", model, config().ModelDir)
+	}
+	if !validGoldenName(golden) {
+		return nil, fmt.Errorf("invalid golden set name %q", golden)
+	}
+	if iouTol == 0 {
+		iouTol = config().GoldenIoUTolerance
+	}
+	if confTol == 0 {
+		confTol = config().GoldenConfidenceTolerance
+	}
+	if iouTol <= 0 || iouTol > 1 {
+		return nil, fmt.Errorf("iou_tolerance must be in (0, 1], got %g", iouTol)
+	}
+	if confTol < 0 || confTol > 1 {
+		return nil, fmt.Errorf("confidence_tolerance must be between 0 and 1, got %g", confTol)
+	}
+	set, err := loadGoldenSet(golden)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	rep := &GoldenReport{Model: model, Golden: golden, FrozenAt: set.FrozenAt, IoUTolerance: iouTol,
+		ConfidenceTolerance: confTol, Images: len(set.Images), Deviations: []GoldenDeviation{}}
+	// Detections this close to the threshold may fall either side of it
+	borderline := func(d Detection) bool { return d.Confidence < set.Threshold+confTol }
+	for _, img := range set.Images {
+		rep.Detections += len(img.Detections)
+		deviate := func(dev GoldenDeviation) {
+			dev.Image = img.Name
+			rep.Deviations = append(rep.Deviations, dev)
+		}
+		path := filepath.Join(config().GoldenDir, filepath.FromSlash(img.Name))
+		sum, err := fileSHA256(path)
+		if err != nil || sum != img.SHA256 {
+			detail := "reference image changed since the set was frozen"
+			if err != nil {
+				detail = err.Error()
+			}
+			deviate(GoldenDeviation{Kind: "image", Detail: detail})
+			continue
+		}
+		result := runInferenceThreshold(path, model, set.Threshold)
+		if result.Error != "" {
+			deviate(GoldenDeviation{Kind: "failed", Detail: result.Error})
+			continue
+		}
+
+		pairs := matchDetections(img.Detections, result.Detections, iouTol)
+		seenOld, seenNew := map[int]bool{}, map[int]bool{}
+		for _, p := range pairs {
+			seenOld[p.a], seenNew[p.b] = true, true
+			want, got := img.Detections[p.a], result.Detections[p.b]
+			if math.Abs(want.Confidence-got.Confidence) > confTol {
+				deviate(GoldenDeviation{Kind: "confidence", Class: want.ClassName, Expected: &want, Got: &got, IoU: p.iou,
+					Detail: fmt.Sprintf("confidence %.3f, frozen %.3f", got.Confidence, want.Confidence)})
+			}
+		}
+		for i, d := range img.Detections {
+			if !seenOld[i] && !borderline(d) {
+				d := d
+				deviate(GoldenDeviation{Kind: "missing", Class: d.ClassName, Expected: &d})
+			}
+		}
+		for i, d := range result.Detections {
+			if !seenNew[i] && !borderline(d) {
+				d := d
+				deviate(GoldenDeviation{Kind: "extra", Class: d.ClassName, Got: &d})
+			}
+		}
+	}
+	rep.Passed = len(rep.Deviations) == 0
+	rep.DurationMS = millis(time.Since(started))
+	return rep, nil
+}
+
+// checkGoldenGate applies GOLDEN_GATE to a model about to take traffic: a
+// model with a golden set must pass it
+func checkGoldenGate(version string) error {
+	if !config().GoldenGate {
+		return nil
+	}
+	rep, err := verifyGolden(version, "", 0, 0)
+	if err == errNoGoldenSet {
+		log.Printf("Warning: model %q has no golden set to verify", version)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("model %q golden set: %v", version, err)
+	}
+	if !rep.Passed {
+		return fmt.Errorf("model %q deviates from its golden set in %d places", version, len(rep.Deviations))
+	}
+	return nil
+}
+
+// goldenSummary is a golden set in the list
+type goldenSummary struct {
+	Model      string    `json:"model"`
+	FrozenAt   time.Time `json:"frozen_at"`
+	Threshold  float64   `json:"threshold"`
+	Images     int       `json:"images"`
+	Detections int       `json:"detections"`
+}
+
+// goldenHandler serves the golden set API
+func goldenHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/golden"), "/")
+	model, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case model == "" && r.Method == http.MethodGet:
+		files, _ := filepath.Glob(filepath.Join(goldenDir(), "*.json"))
+		list := []goldenSummary{}
+		for _, f := range files {
+			set, err := loadGoldenSet(strings.TrimSuffix(filepath.Base(f), ".json"))
+			if err != nil {
+				continue
+			}
+			s := goldenSummary{Model: set.Model, FrozenAt: set.FrozenAt, Threshold: set.Threshold, Images: len(set.Images)}
+			for _, img := range set.Images {
+				s.Detections += len(img.Detections)
+			}
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+		writeJSON(w, http.StatusOK, list)
+
+	case !validGoldenName(model):
+		writeJSONError(w, http.StatusNotFound, "Not found")
+
+	case action == "" && r.Method == http.MethodGet:
+		set, err := loadGoldenSet(model)
+		if err == errNoGoldenSet {
+			writeJSONError(w, http.StatusNotFound, "Golden set not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, set)
+
+	case action == "" && r.Method == http.MethodPut:
+		var set GoldenSet
+		if err := decodeJSONLimit(w, r, &set, 16<<20); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if len(set.Images) == 0 || set.Threshold < 0 || set.Threshold > 1 {
+			writeJSONError(w, http.StatusBadRequest, "A golden set needs images and a threshold between 0 and 1")
+			return
+		}
+		for _, img := range set.Images {
+			if !filepath.IsLocal(filepath.FromSlash(img.Name)) {
+				writeJSONError(w, http.StatusBadRequest, "Golden image names must be relative to GOLDEN_DIR")
+				return
+			}
+		}
+		set.Model = model
+		if set.FrozenAt.IsZero() {
+			set.FrozenAt = time.Now().UTC()
+		}
+		if err := saveGoldenSet(&set); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, set)
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := os.Remove(goldenPath(model)); err != nil {
+			writeJSONError(w, http.StatusNotFound, "Golden set not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "freeze" && r.Method == http.MethodPost:
+		set, err := freezeGoldenSet(model)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, set)
+
+	case action == "verify" && r.Method == http.MethodPost:
+		var req struct {
+			Golden              string  `json:"golden"`
+			IoUTolerance        float64 `json:"iou_tolerance"`
+			ConfidenceTolerance float64 `json:"confidence_tolerance"`
+		}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(w, r, &req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+				return
+			}
+		}
+		rep, err := verifyGolden(model, req.Golden, req.IoUTolerance, req.ConfidenceTolerance)
+		if err == errNoGoldenSet {
+			writeJSONError(w, http.StatusNotFound, "Golden set not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, rep)
+
+	case action == "" || action == "freeze" || action == "verify":
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// runVerify implements `webui verify`, returning the exit code
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	base := fs.String("url", "http://127.0.0.1:6767", "base URL of the instance")
+	model := fs.String("model", "", "model version to verify")
+	golden := fs.String("golden", "", "golden set to verify against (default: the model's own)")
+	iouTol := fs.Float64("iou", 0, "IoU a detection must keep with its frozen box (default: GOLDEN_IOU_TOLERANCE)")
+	confTol := fs.Float64("confidence", 0, "how far a confidence may move (default: GOLDEN_CONFIDENCE_TOLERANCE)")
+	token := fs.String("token", "", "bearer token sent with every request, for an authenticating proxy")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *model == "" || !validGoldenName(*model) {
+		fmt.Fprintln(os.Stderr, "verify needs -model")
+		return 2
+	}
+	st := &smoketest{base: strings.TrimRight(*base, "/"), token: *token}
+	req := map[string]interface{}{"golden": *golden, "iou_tolerance": *iouTol, "confidence_tolerance": *confTol}
+	status, body, err := st.call(http.MethodPost, "/api/v1/golden/"+*model+"/verify", req, 10*time.Minute)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d: %s", status, smoketestError(body))
+	}
+	var rep GoldenReport
+	if err == nil {
+		err = json.Unmarshal(body, &rep)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, d := range rep.Deviations {
+			line := fmt.Sprintf("%-10s %s", d.Kind, d.Image)
+			if d.Class != "" {
+				line += "  " + d.Class
+			}
+			if d.Detail != "" {
+				line += "  " + d.Detail
+			}
+			fmt.Println(line)
+		}
+		verdict := "passed"
+		if !rep.Passed {
+			verdict = fmt.Sprintf("FAILED with %d deviations", len(rep.Deviations))
+		}
+		fmt.Printf("%s against the golden set of %s (%d images, %d detections, IoU %g, confidence ±%g): %s\n",
+			rep.Model, rep.Golden, rep.Images, rep.Detections, rep.IoUTolerance, rep.ConfidenceTolerance, verdict)
+	}
+	if !rep.Passed {
+		return 1
+	}
+	return 0
+}
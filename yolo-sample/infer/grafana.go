@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// /api/v1/grafana speaks the SimpleJSON datasource protocol (also served by
+// Grafana's JSON API and Infinity plugins), so a site running Grafana can
+// chart this node's time series (see timeseries.go) without a Prometheus:
+//
+//	GET  /api/v1/grafana               connection test
+//	POST /api/v1/grafana/search        metric names containing the request's target
+//	POST /api/v1/grafana/query         datapoints of each target over the range
+//	POST /api/v1/grafana/annotations   always empty
+//	POST /api/v1/grafana/tag-keys      "source", for ad hoc filters
+//	POST /api/v1/grafana/tag-values    the sources with data
+//
+// A target is [source/]metric, where metric is inferences, errors,
+// detections, latency_avg_ms, latency_max_ms or detections:<class>, summed
+// over every source unless one is named in the target or in an ad hoc
+// source filter. The resolution is the finest of minute, hour and day that
+// still covers the range within its retention and within maxDataPoints.
+// Infinity can also read /api/v1/timeseries directly, with "points" as the
+// rows root.
+
+// grafanaMetrics are the metrics of each series, besides detections:<class>
+var grafanaMetrics = []string{"inferences", "errors", "detections", "latency_avg_ms", "latency_max_ms"}
+
+// grafanaQuery is the part of a SimpleJSON query request the node uses
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"` // timeserie (default) or table
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+	AdhocFilters []struct {
+		Key      string `json:"key"`
+		Operator string `json:"operator"`
+		Value    string `json:"value"`
+	} `json:"adhocFilters"`
+}
+
+// grafanaHandler serves /api/v1/grafana and its endpoints
+func grafanaHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/grafana"), "/")
+	if endpoint == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	switch endpoint {
+	case "search":
+		var req struct {
+			Target string `json:"target"`
+		}
+		// Grafana sends more fields than the node reads, and some versions no body at all
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil && err != io.EOF {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, grafanaSearch(req.Target))
+	case "query":
+		var req grafanaQuery
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if req.Range.From.IsZero() || req.Range.To.IsZero() || req.Range.From.After(req.Range.To) {
+			writeJSONError(w, http.StatusBadRequest, "range must have from before to")
+			return
+		}
+		out, err := grafanaResults(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	case "annotations":
+		writeJSON(w, http.StatusOK, []interface{}{})
+	case "tag-keys":
+		writeJSON(w, http.StatusOK, []map[string]string{{"type": "string", "text": "source"}})
+	case "tag-values":
+		values := []map[string]string{}
+		for _, s := range timeseriesSources() {
+			values = append(values, map[string]string{"text": s})
+		}
+		writeJSON(w, http.StatusOK, values)
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// grafanaSearch lists the targets containing filter: every metric, summed
+// and per source, and detections of every class seen
+func grafanaSearch(filter string) []string {
+	metrics := append([]string{}, grafanaMetrics...)
+	classes := map[string]bool{}
+	timeseries.Lock()
+	for _, bySource := range timeseries.data {
+		for _, buckets := range bySource {
+			for _, b := range buckets {
+				for class := range b.Classes {
+					classes[class] = true
+				}
+			}
+		}
+	}
+	timeseries.Unlock()
+	var sorted []string
+	for class := range classes {
+		sorted = append(sorted, "detections:"+class)
+	}
+	sort.Strings(sorted)
+	metrics = append(metrics, sorted...)
+
+	out := []string{}
+	for _, prefix := range append([]string{""}, timeseriesSources()...) {
+		if prefix != "" {
+			prefix += "/"
+		}
+		for _, m := range metrics {
+			if strings.Contains(prefix+m, filter) {
+				out = append(out, prefix+m)
+			}
+		}
+	}
+	return out
+}
+
+// grafanaResolution picks the finest resolution whose buckets still reach
+// back to from and number at most maxPoints
+func grafanaResolution(from, to time.Time, interval time.Duration, maxPoints int) string {
+	if maxPoints <= 0 || maxPoints > maxTimeseriesPoints {
+		maxPoints = maxTimeseriesPoints
+	}
+	cfg := config()
+	for _, resolution := range []string{resolutionMinute, resolutionHour} {
+		step := resolutionStep[resolution]
+		if interval < 2*step && int(to.Sub(from)/step) < maxPoints &&
+			!from.Before(time.Now().Add(-timeseriesRetention(cfg, resolution))) {
+			return resolution
+		}
+	}
+	return resolutionDay
+}
+
+// grafanaResults answers the targets of a query, in order
+func grafanaResults(req grafanaQuery) ([]interface{}, error) {
+	from, to := req.Range.From, req.Range.To
+	resolution := grafanaResolution(from, to, time.Duration(req.IntervalMs)*time.Millisecond, req.MaxDataPoints)
+	if int(to.Sub(from)/resolutionStep[resolution]) >= maxTimeseriesPoints {
+		return nil, fmt.Errorf("at most %d %s buckets per query", maxTimeseriesPoints, resolution)
+	}
+	filter := ""
+	for _, f := range req.AdhocFilters {
+		if f.Key == "source" && f.Operator == "=" {
+			filter = f.Value
+		}
+	}
+
+	out := []interface{}{}
+	points := map[string][]TimeseriesPoint{}
+	for _, t := range req.Targets {
+		if t.Hide || t.Target == "" {
+			continue
+		}
+		source, metric := filter, t.Target
+		if i := strings.Index(metric, "/"); i >= 0 {
+			source, metric = metric[:i], metric[i+1:]
+		}
+		value, ok := grafanaValue(metric)
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q", metric)
+		}
+		if _, done := points[source]; !done {
+			points[source] = queryTimeseries(resolution, source, from, to)
+		}
+		if t.Type == "table" {
+			rows := [][]interface{}{}
+			for _, p := range points[source] {
+				rows = append(rows, []interface{}{p.Time.UnixMilli(), value(p)})
+			}
+			out = append(out, map[string]interface{}{
+				"type": "table",
+				"columns": []map[string]string{
+					{"text": "Time", "type": "time"},
+					{"text": t.Target, "type": "number"},
+				},
+				"rows": rows,
+			})
+			continue
+		}
+		datapoints := [][2]float64{}
+		for _, p := range points[source] {
+			datapoints = append(datapoints, [2]float64{value(p), float64(p.Time.UnixMilli())})
+		}
+		out = append(out, map[string]interface{}{"target": t.Target, "datapoints": datapoints})
+	}
+	return out, nil
+}
+
+// grafanaValue returns the reader of metric from a point
+func grafanaValue(metric string) (func(TimeseriesPoint) float64, bool) {
+	if class := strings.TrimPrefix(metric, "detections:"); class != metric {
+		return func(p TimeseriesPoint) float64 { return float64(p.Classes[class]) }, true
+	}
+	switch metric {
+	case "inferences":
+		return func(p TimeseriesPoint) float64 { return float64(p.Inferences) }, true
+	case "errors":
+		return func(p TimeseriesPoint) float64 { return float64(p.Errors) }, true
+	case "detections":
+		return func(p TimeseriesPoint) float64 { return float64(p.Detections) }, true
+	case "latency_avg_ms":
+		return func(p TimeseriesPoint) float64 { return p.LatencyAvgMS }, true
+	case "latency_max_ms":
+		return func(p TimeseriesPoint) float64 { return p.LatencyMaxMS }, true
+	}
+	return nil, false
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A gRPC-Web endpoint lets browser clients generated from inference.proto
+// call the node directly, without an Envoy proxy in front of it. gRPC-Web
+// runs over plain HTTP/1.1: each message is framed as a flag byte, a 4-byte
+// big-endian length and the protobuf payload, and the status trailers
+// follow as a final frame with flag 0x80. The "-text" content types carry
+// the same bytes base64 encoded. Only unary calls are offered, so the
+// protobuf messages are encoded by hand rather than through generated code.
+//
+//	POST /yolo.v1.Inference/Infer      InferRequest  -> InferResult
+//	POST /yolo.v1.Inference/GetResult  ResultRequest -> InferResult
+
+const grpcServicePath = "/yolo.v1.Inference/"
+
+// gRPC status codes used here
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// grpcWebHandler serves the gRPC-Web methods of yolo.v1.Inference
+func grpcWebHandler(w http.ResponseWriter, r *http.Request) {
+	ctype := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(ctype, "application/grpc-web-text")
+	if r.Method != http.MethodPost || !strings.HasPrefix(ctype, "application/grpc-web") {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "gRPC-Web requests must be POSTed as application/grpc-web+proto or application/grpc-web-text+proto")
+		return
+	}
+
+	var resp []byte
+	msg, err := readGRPCWebRequest(w, r, text)
+	if err == nil {
+		switch strings.TrimPrefix(r.URL.Path, grpcServicePath) {
+		case "Infer":
+			resp, err = grpcInfer(r, msg)
+		case "GetResult":
+			resp, err = grpcGetResult(msg)
+		default:
+			err = &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+		}
+	}
+
+	status, message := grpcOK, ""
+	if err != nil {
+		var gerr *grpcError
+		if !errors.As(err, &gerr) {
+			gerr = &grpcError{grpcInternal, err.Error()}
+		}
+		status, message = gerr.code, translate(responseLanguage(w), gerr.msg)
+	}
+
+	var body bytes.Buffer
+	if err == nil {
+		writeGRPCFrame(&body, 0x00, resp)
+	}
+	trailers := fmt.Sprintf("grpc-status:%d\r\ngrpc-message:%s\r\n", status, url.PathEscape(message))
+	writeGRPCFrame(&body, 0x80, []byte(trailers))
+
+	out := body.Bytes()
+	if text {
+		w.Header().Set("Content-Type", "application/grpc-web-text+proto")
+		out = []byte(base64.StdEncoding.EncodeToString(out))
+	} else {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// readGRPCWebRequest reads the single message frame of a unary call
+func readGRPCWebRequest(w http.ResponseWriter, r *http.Request, text bool) ([]byte, error) {
+	// The request carries one image, base64 encoded in text mode
+	limit := config().IngestMaxBytes + 4096
+	if text {
+		limit = limit*4/3 + 4
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("request exceeds %d bytes", limit)}
+	}
+	if text {
+		if raw, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw))); err != nil {
+			return nil, &grpcError{grpcInvalidArgument, "request is not valid base64"}
+		}
+	}
+	if len(raw) < 5 {
+		return nil, &grpcError{grpcInvalidArgument, "request has no message frame"}
+	}
+	if raw[0]&0x01 != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(raw[1:5])
+	if uint64(n) > uint64(len(raw)-5) {
+		return nil, &grpcError{grpcInvalidArgument, "truncated message frame"}
+	}
+	return raw[5 : 5+n], nil
+}
+
+func writeGRPCFrame(w *bytes.Buffer, flag byte, payload []byte) {
+	var header [5]byte
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	w.Write(header[:])
+	w.Write(payload)
+}
+
+// grpcInfer handles InferRequest{bytes image = 1; string filename = 2},
+// queueing the image like an upload and waiting for its result
+func grpcInfer(r *http.Request, msg []byte) ([]byte, error) {
+	if maintenanceOn() {
+		return nil, &grpcError{grpcUnavailable, "node is in maintenance"}
+	}
+	received := time.Now()
+	var image []byte
+	var filename string
+	err := decodeProto(msg, func(field int, wire int, v uint64, b []byte) {
+		switch {
+		case field == 1 && wire == 2:
+			image = b
+		case field == 2 && wire == 2:
+			filename = string(b)
+		}
+	})
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if len(image) == 0 {
+		return nil, &grpcError{grpcInvalidArgument, "image is required"}
+	}
+
+	id, path, err := saveIngested(filename, image)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if err := jobs.submit(id, path, "grpc", InferenceOptions{}, received); err != nil {
+		os.RemoveAll(filepath.Dir(path))
+		return nil, &grpcError{grpcUnavailable, err.Error()}
+	}
+
+	current, sub, ok := jobs.subscribe(id)
+	if ok {
+		defer bus.unsubscribe(sub)
+	}
+	for ok && current.Stage != jobDone && current.Stage != jobFailed {
+		select {
+		case ev := <-sub.C:
+			current = ev.Data.(JobEvent)
+		case <-r.Context().Done():
+			return nil, &grpcError{grpcUnavailable, "request cancelled; the result will be stored as " + id}
+		}
+	}
+	if current.Stage == jobFailed {
+		return nil, &grpcError{grpcInternal, current.Error}
+	}
+	res, ok := results.get(id)
+	if !ok {
+		return nil, &grpcError{grpcInternal, "the result could not be stored"}
+	}
+	return encodeInferResult(res), nil
+}
+
+// grpcGetResult handles ResultRequest{string id = 1}
+func grpcGetResult(msg []byte) ([]byte, error) {
+	var id string
+	err := decodeProto(msg, func(field int, wire int, v uint64, b []byte) {
+		if field == 1 && wire == 2 {
+			id = string(b)
+		}
+	})
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	res, ok := results.get(id)
+	if !ok {
+		return nil, &grpcError{grpcNotFound, "Result not found"}
+	}
+	return encodeInferResult(res), nil
+}
+
+// encodeInferResult encodes InferResult as declared in inference.proto
+func encodeInferResult(r InferenceResult) []byte {
+	var e protoEncoder
+	e.string(1, r.ID)
+	for _, d := range r.Detections {
+		var de protoEncoder
+		de.varint(1, uint64(int64(d.ClassID)))
+		de.string(2, d.ClassName)
+		de.double(3, d.Confidence)
+		var be protoEncoder
+		be.double(1, d.BBox.X1)
+		be.double(2, d.BBox.Y1)
+		be.double(3, d.BBox.X2)
+		be.double(4, d.BBox.Y2)
+		de.message(4, be.buf)
+		e.message(2, de.buf)
+	}
+	e.varint(3, uint64(int64(r.Count)))
+	e.string(4, r.Model)
+	e.string(5, r.Source)
+	e.varint(6, uint64(r.CreatedAt.UnixMilli()))
+	e.string(7, r.Error)
+	return e.buf
+}
+
+// protoEncoder appends protobuf fields, skipping zero values as proto3 does
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) tag(field, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field<<3|wire))
+}
+
+func (e *protoEncoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, 0)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *protoEncoder) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, 1)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *protoEncoder) bytes(field int, b []byte) {
+	e.tag(field, 2)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	if s != "" {
+		e.bytes(field, []byte(s))
+	}
+}
+
+func (e *protoEncoder) message(field int, b []byte) {
+	e.bytes(field, b)
+}
+
+// decodeProto calls fn for each field of a protobuf message: v holds varint
+// and fixed-width values, b the contents of length-delimited fields
+func decodeProto(msg []byte, fn func(field, wire int, v uint64, b []byte)) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("malformed protobuf message")
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&7)
+		switch wire {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("malformed protobuf message")
+			}
+			fn(field, wire, v, nil)
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("malformed protobuf message")
+			}
+			fn(field, wire, binary.LittleEndian.Uint64(msg), nil)
+			msg = msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return errors.New("malformed protobuf message")
+			}
+			fn(field, wire, 0, msg[n:n+int(l)])
+			msg = msg[n+int(l):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("malformed protobuf message")
+			}
+			fn(field, wire, uint64(binary.LittleEndian.Uint32(msg)), nil)
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// With HEARTBEAT_URL set the node POSTs a small heartbeat every
+// HEARTBEAT_INTERVAL (default 1m), so fleet operators see which sites are
+// alive without scraping each one:
+//
+//	{"node": "dock-3", "heartbeats": [{"time": ..., "status": "ok",
+//	  "network_status": "online", "worker": "running", "model": "production",
+//	  "build": "1.4.0", "inferences_per_minute": 12, "error_rate": 0,
+//	  "disk_free_bytes": 51234567890, "disk_free_ratio": 0.62, "uptime_seconds": 86400}]}
+//
+// Rates cover the interval since the previous heartbeat; status is "degraded"
+// while the inference worker is not running or its circuit breaker is open.
+// While the node is offline, or when a POST fails, heartbeats are buffered in
+// STATE_DIR/heartbeats.json (at most HEARTBEAT_BUFFER, default 1440, oldest
+// dropped) and sent, oldest first, with the next one that gets through.
+
+// heartbeatBatch bounds the heartbeats per POST
+const heartbeatBatch = 500
+
+// Heartbeat is one report of the node's liveness
+type Heartbeat struct {
+	Time          time.Time  `json:"time"`
+	Status        string     `json:"status"` // "ok" or "degraded"
+	NetworkStatus string     `json:"network_status"`
+	StaleSince    *time.Time `json:"network_status_stale_since,omitempty"`
+	Worker        string     `json:"worker"`
+	Model         string     `json:"model"`
+	Build         string     `json:"build"`
+	InferenceRate float64    `json:"inferences_per_minute"`
+	ErrorRate     float64    `json:"error_rate"` // failed / all inferences
+	DiskFree      uint64     `json:"disk_free_bytes"`
+	DiskFreeRatio float64    `json:"disk_free_ratio"`
+	Uptime        float64    `json:"uptime_seconds"`
+}
+
+// HeartbeatView is the API representation of the heartbeat state
+type HeartbeatView struct {
+	URL       string     `json:"url"`
+	LastSent  *time.Time `json:"last_sent,omitempty"`
+	Buffered  int        `json:"buffered"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var heartbeat = struct {
+	sync.Mutex
+	buffer    []Heartbeat
+	lastSent  time.Time
+	lastError string
+	// inference counters at the previous heartbeat
+	prevAt              time.Time
+	prevTotal, prevErrs float64
+}{}
+
+var processStart = time.Now()
+
+var heartbeatClient = &http.Client{Timeout: 15 * time.Second}
+
+var heartbeatsBuffered = newGaugeVec("yolo_heartbeats_buffered",
+	"Heartbeats waiting for HEARTBEAT_URL to be reachable.")
+
+func heartbeatBufferPath() string {
+	return filepath.Join(config().StateDir, "heartbeats.json")
+}
+
+// startHeartbeat sends heartbeats every HEARTBEAT_INTERVAL, if configured
+func startHeartbeat() {
+	if config().HeartbeatURL == "" {
+		return
+	}
+	if err := validateHeartbeat(config()); err != nil {
+		log.Printf("Warning: not sending heartbeats: %v", err)
+		return
+	}
+	heartbeat.Lock()
+	if data, err := os.ReadFile(heartbeatBufferPath()); err == nil {
+		json.Unmarshal(data, &heartbeat.buffer)
+	}
+	heartbeat.prevAt = processStart
+	heartbeatsBuffered.set(float64(len(heartbeat.buffer)))
+	heartbeat.Unlock()
+	go func() {
+		for {
+			interval := config().HeartbeatInterval
+			if interval <= 0 {
+				interval = time.Minute
+			}
+			time.Sleep(interval)
+			if config().HeartbeatURL != "" {
+				sendHeartbeat()
+			}
+		}
+	}()
+}
+
+// validateHeartbeat checks the heartbeat settings
+func validateHeartbeat(cfg *Config) error {
+	if cfg.HeartbeatURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(cfg.HeartbeatURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("HEARTBEAT_URL must be an http:// or https:// URL")
+	}
+	if cfg.HeartbeatInterval < time.Second {
+		return fmt.Errorf("HEARTBEAT_INTERVAL must be at least 1s")
+	}
+	return nil
+}
+
+// currentHeartbeat measures the node; the caller holds heartbeat's lock
+func currentHeartbeat() Heartbeat {
+	now := time.Now()
+	status := getNodeStatus()
+	wv := worker.view()
+	hb := Heartbeat{
+		Time:          now.UTC(),
+		Status:        "ok",
+		NetworkStatus: status.NetworkStatus,
+		StaleSince:    staleSinceJSON(status.StaleSince),
+		Worker:        wv.State,
+		Model:         activeModelVersion(),
+		Build:         buildVersion,
+		Uptime:        now.Sub(processStart).Seconds(),
+	}
+	if wv.State != workerRunning || !backendBreaker.allow() {
+		hb.Status = "degraded"
+	}
+	total := inferenceRequests.sumWhere("status", "success") + inferenceRequests.sumWhere("status", "error")
+	errs := inferenceRequests.sumWhere("status", "error")
+	if minutes := now.Sub(heartbeat.prevAt).Minutes(); minutes > 0 {
+		hb.InferenceRate = (total - heartbeat.prevTotal) / minutes
+	}
+	if n := total - heartbeat.prevTotal; n > 0 {
+		hb.ErrorRate = (errs - heartbeat.prevErrs) / n
+	}
+	heartbeat.prevAt, heartbeat.prevTotal, heartbeat.prevErrs = now, total, errs
+	hb.DiskFree, hb.DiskFreeRatio, _ = diskFree(config().StateDir)
+	return hb
+}
+
+// sendHeartbeat sends a heartbeat with the buffered ones, or buffers it.
+// Only the heartbeat goroutine changes the buffer, so it is not locked while
+// POSTing.
+func sendHeartbeat() {
+	cfg := config()
+	heartbeat.Lock()
+	hb := currentHeartbeat()
+	heartbeat.buffer = append(heartbeat.buffer, hb)
+	if max := cfg.HeartbeatBuffer; max > 0 && len(heartbeat.buffer) > max {
+		heartbeat.buffer = heartbeat.buffer[len(heartbeat.buffer)-max:]
+	}
+	pending := heartbeat.buffer
+	heartbeat.Unlock()
+
+	var err error
+	if hb.NetworkStatus != "online" {
+		err = fmt.Errorf("node is %s", hb.NetworkStatus)
+	}
+	sent := 0
+	for err == nil && sent < len(pending) {
+		batch := pending[sent:]
+		if len(batch) > heartbeatBatch {
+			batch = batch[:heartbeatBatch]
+		}
+		if err = postHeartbeats(cfg, batch); err == nil {
+			sent += len(batch)
+		}
+	}
+
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	heartbeat.buffer = heartbeat.buffer[sent:]
+	if sent > 0 {
+		heartbeat.lastSent = time.Now().UTC()
+	}
+	if err != nil {
+		if hb.NetworkStatus == "online" && heartbeat.lastError == "" {
+			log.Printf("Warning: heartbeat to %s failed, buffering: %v", redactedURL(cfg.HeartbeatURL), err)
+		}
+		heartbeat.lastError = err.Error()
+	} else {
+		heartbeat.lastError = ""
+	}
+
+	heartbeatsBuffered.set(float64(len(heartbeat.buffer)))
+	if len(heartbeat.buffer) == 0 {
+		os.Remove(heartbeatBufferPath())
+	} else if data, err := json.Marshal(heartbeat.buffer); err == nil {
+		if err := writeFileAtomic(heartbeatBufferPath(), data); err != nil {
+			log.Printf("Warning: failed to save buffered heartbeats: %v", err)
+		}
+	}
+}
+
+func postHeartbeats(cfg *Config, batch []Heartbeat) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"node":       getEnv("NODE_NAME", "unknown"),
+		"heartbeats": batch,
+	})
+	resp, err := heartbeatClient.Post(cfg.HeartbeatURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", redactedURL(cfg.HeartbeatURL), resp.Status)
+	}
+	return nil
+}
+
+// heartbeatView returns the heartbeat state, nil when heartbeats are off
+func heartbeatView() *HeartbeatView {
+	cfg := config()
+	if cfg.HeartbeatURL == "" {
+		return nil
+	}
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	v := &HeartbeatView{URL: redactedURL(cfg.HeartbeatURL), Buffered: len(heartbeat.buffer), LastError: heartbeat.lastError}
+	if !heartbeat.lastSent.IsZero() {
+		sent := heartbeat.lastSent
+		v.LastSent = &sent
+	}
+	return v
+}
+
+// diskFree returns the bytes available to the node on path's file system
+// and their share of its size
+func diskFree(path string) (uint64, float64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	free := fs.Bavail * uint64(fs.Bsize)
+	if fs.Blocks == 0 {
+		return free, 0, nil
+	}
+	return free, float64(fs.Bavail) / float64(fs.Blocks), nil
+}
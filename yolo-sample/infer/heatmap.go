@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each camera source's detections are counted into a coarse spatial
+// histogram of their box centres, one per hour, so operators can see where
+// activity concentrates:
+//
+//	GET /api/v1/sources/{name}/heatmap?from=2026-10-01T00:00:00Z&to=...&class=person
+//
+// returns a PNG of the source's latest frame with the heatmap blended over it
+// (?base=false for the overlay alone, on a transparent background; always
+// so for sources whose IMAGE_RETENTION is not full), or the counts as JSON
+// with ?format=json. from and to default to the last 24 hours; class limits
+// the heatmap to a class or taxonomy label. Hours are kept for
+// HEATMAP_RETENTION_DAYS (default 30) in STATE_DIR/heatmaps/<source>.json.
+
+const (
+	heatmapCols = 64
+	heatmapRows = 36
+	// The size of the image when no frame is available
+	heatmapWidth, heatmapHeight = 640, 360
+)
+
+// heatmapHour is one hour of a source's histogram. Cells maps the cell index
+// (row*heatmapCols + col) to its count, overall and by class.
+type heatmapHour struct {
+	Cells   map[int]int            `json:"cells"`
+	Classes map[string]map[int]int `json:"classes,omitempty"`
+}
+
+// HeatmapView is the JSON form of a heatmap
+type HeatmapView struct {
+	Source string    `json:"source"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Class  string    `json:"class,omitempty"`
+	Cols   int       `json:"cols"`
+	Rows   int       `json:"rows"`
+	Total  int       `json:"total"`
+	Counts [][]int   `json:"counts"` // [row][col]
+}
+
+var heatmaps = struct {
+	sync.Mutex
+	hours map[string]map[int64]*heatmapHour // by source, then hour (Unix seconds)
+	dirty map[string]bool
+}{hours: map[string]map[int64]*heatmapHour{}, dirty: map[string]bool{}}
+
+func heatmapDir() string {
+	return filepath.Join(config().StateDir, "heatmaps")
+}
+
+// startHeatmaps counts the detections of each new camera result and saves the
+// histograms every minute
+func startHeatmaps() {
+	bus.subscribe("heatmap", []string{eventResultCreated}, nil, 256).consume(func(ev Event) {
+		recordHeatmap(ev.Data.(InferenceResult))
+	})
+	go func() {
+		for range time.Tick(time.Minute) {
+			saveHeatmaps()
+		}
+	}()
+}
+
+// heatmapLocked returns the hours of source, reading them from disk the first
+// time; the caller holds heatmaps' lock
+func heatmapLocked(source string) map[int64]*heatmapHour {
+	if h, ok := heatmaps.hours[source]; ok {
+		return h
+	}
+	h := map[int64]*heatmapHour{}
+	if data, err := os.ReadFile(filepath.Join(heatmapDir(), source+".json")); err == nil {
+		if err := json.Unmarshal(data, &h); err != nil {
+			log.Printf("Warning: ignoring unreadable heatmap of source %s: %v", source, err)
+			h = map[int64]*heatmapHour{}
+		}
+	}
+	heatmaps.hours[source] = h
+	return h
+}
+
+func recordHeatmap(r InferenceResult) {
+	if r.Error != "" || len(r.Detections) == 0 || r.ImageWidth <= 0 || r.ImageHeight <= 0 {
+		return
+	}
+	if _, ok := sources.get(r.Source); !ok {
+		return
+	}
+	hour := r.CreatedAt.Truncate(time.Hour).Unix()
+	heatmaps.Lock()
+	defer heatmaps.Unlock()
+	hours := heatmapLocked(r.Source)
+	h := hours[hour]
+	if h == nil {
+		h = &heatmapHour{Cells: map[int]int{}}
+		hours[hour] = h
+	}
+	for _, d := range r.Detections {
+		cx := (d.BBox.X1 + d.BBox.X2) / 2 / float64(r.ImageWidth)
+		cy := (d.BBox.Y1 + d.BBox.Y2) / 2 / float64(r.ImageHeight)
+		if cx < 0 || cx > 1 || cy < 0 || cy > 1 {
+			continue
+		}
+		cell := minInt(int(cy*heatmapRows), heatmapRows-1)*heatmapCols + minInt(int(cx*heatmapCols), heatmapCols-1)
+		h.Cells[cell]++
+		if h.Classes == nil {
+			h.Classes = map[string]map[int]int{}
+		}
+		if h.Classes[d.ClassName] == nil {
+			h.Classes[d.ClassName] = map[int]int{}
+		}
+		h.Classes[d.ClassName][cell]++
+	}
+	heatmaps.dirty[r.Source] = true
+}
+
+// saveHeatmaps drops hours past the retention and writes the changed histograms
+func saveHeatmaps() {
+	cutoff := time.Now().AddDate(0, 0, -config().HeatmapRetentionDays).Unix()
+	heatmaps.Lock()
+	defer heatmaps.Unlock()
+	for source, hours := range heatmaps.hours {
+		for hour := range hours {
+			if hour < cutoff {
+				delete(hours, hour)
+				heatmaps.dirty[source] = true
+			}
+		}
+	}
+	if len(heatmaps.dirty) == 0 {
+		return
+	}
+	if err := os.MkdirAll(heatmapDir(), 0755); err != nil {
+		log.Printf("Warning: failed to save heatmaps: %v", err)
+		return
+	}
+	for source := range heatmaps.dirty {
+		path := filepath.Join(heatmapDir(), source+".json")
+		var err error
+		if len(heatmaps.hours[source]) == 0 {
+			if err = os.Remove(path); os.IsNotExist(err) {
+				err = nil
+			}
+		} else if data, merr := json.Marshal(heatmaps.hours[source]); merr != nil {
+			err = merr
+		} else {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			log.Printf("Warning: failed to save heatmap of source %s: %v", source, err)
+			continue
+		}
+		delete(heatmaps.dirty, source)
+	}
+}
+
+// heatmapCounts sums source's hours overlapping [from, to) into a grid,
+// counting only detections matching label when it is set
+func heatmapCounts(source string, from, to time.Time, label string) ([][]int, int) {
+	tax := labels()
+	counts := make([][]int, heatmapRows)
+	for i := range counts {
+		counts[i] = make([]int, heatmapCols)
+	}
+	total := 0
+	add := func(cells map[int]int) {
+		for cell, n := range cells {
+			if cell >= 0 && cell < heatmapRows*heatmapCols {
+				counts[cell/heatmapCols][cell%heatmapCols] += n
+				total += n
+			}
+		}
+	}
+	heatmaps.Lock()
+	defer heatmaps.Unlock()
+	for hour, h := range heatmapLocked(source) {
+		if t := time.Unix(hour, 0); !t.Add(time.Hour).After(from) || !t.Before(to) {
+			continue
+		}
+		if label == "" {
+			add(h.Cells)
+			continue
+		}
+		for class, cells := range h.Classes {
+			if tax.matches(class, label) {
+				add(cells)
+			}
+		}
+	}
+	return counts, total
+}
+
+// sourceHeatmapHandler serves GET /api/v1/sources/{name}/heatmap
+func sourceHeatmapHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := sources.get(name); !ok {
+		writeJSONError(w, http.StatusNotFound, "Source not found")
+		return
+	}
+	q := r.URL.Query()
+	to, from := time.Now().UTC(), time.Time{}
+	for key, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := q.Get(key); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, key+" must be an RFC 3339 time")
+				return
+			}
+			*t = parsed
+		}
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	if !from.Before(to) {
+		writeJSONError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	class := strings.TrimSpace(q.Get("class"))
+	counts, total := heatmapCounts(name, from, to, class)
+
+	if q.Get("format") == "json" {
+		writeJSON(w, http.StatusOK, HeatmapView{
+			Source: name, From: from, To: to, Class: class,
+			Cols: heatmapCols, Rows: heatmapRows, Total: total, Counts: counts,
+		})
+		return
+	}
+
+	withBase := true
+	if s := q.Get("base"); s != "" {
+		withBase, _ = strconv.ParseBool(s)
+	}
+	var base image.Image
+	if withBase {
+		base = heatmapBase(name)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderHeatmap(counts, base)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Detection-Count", strconv.Itoa(total))
+	w.Write(buf.Bytes())
+}
+
+// heatmapBase is the source's latest frame, or nil. Only sources whose
+// images are kept in full have one; in privacy mode it is the last processed
+// frame, redacted like a snapshot, and none until a frame was processed.
+func heatmapBase(name string) image.Image {
+	wk := sources.worker(name)
+	if wk == nil || imageRetentionFor(name) != retentionFull {
+		return nil
+	}
+	privacy := config().PrivacyMode
+	wk.mu.Lock()
+	frame, detections := wk.latest, wk.lastUnfiltered
+	if privacy {
+		frame = wk.lastProcessed
+	}
+	wk.mu.Unlock()
+	if frame == nil {
+		return nil
+	}
+	img, err := decodeImage(bytes.NewReader(frame))
+	if err != nil {
+		return nil
+	}
+	if privacy {
+		return redactImage(img, detections)
+	}
+	return img
+}
+
+// renderHeatmap smooths the counts and blends them over base, or a
+// transparent image of heatmapWidth x heatmapHeight when base is nil
+func renderHeatmap(counts [][]int, base image.Image) *image.RGBA {
+	// Two passes of a 3x3 box blur keep single detections from looking like
+	// hard squares
+	grid := make([][]float64, heatmapRows)
+	for y := range grid {
+		grid[y] = make([]float64, heatmapCols)
+		for x := range grid[y] {
+			grid[y][x] = float64(counts[y][x])
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		next := make([][]float64, heatmapRows)
+		for y := range next {
+			next[y] = make([]float64, heatmapCols)
+			for x := range next[y] {
+				sum, n := 0.0, 0.0
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if yy, xx := y+dy, x+dx; yy >= 0 && yy < heatmapRows && xx >= 0 && xx < heatmapCols {
+							sum += grid[yy][xx]
+							n++
+						}
+					}
+				}
+				next[y][x] = sum / n
+			}
+		}
+		grid = next
+	}
+	max := 0.0
+	for _, row := range grid {
+		for _, v := range row {
+			max = math.Max(max, v)
+		}
+	}
+
+	bounds := image.Rect(0, 0, heatmapWidth, heatmapHeight)
+	if base != nil {
+		bounds = image.Rect(0, 0, base.Bounds().Dx(), base.Bounds().Dy())
+	}
+	out := image.NewRGBA(bounds)
+	if base != nil {
+		draw.Draw(out, bounds, base, base.Bounds().Min, draw.Src)
+	}
+	if max == 0 {
+		return out
+	}
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	for py := 0; py < bounds.Dy(); py++ {
+		for px := 0; px < bounds.Dx(); px++ {
+			v := bilinear(grid, (float64(px)+0.5)/w*heatmapCols-0.5, (float64(py)+0.5)/h*heatmapRows-0.5) / max
+			if v < 0.02 {
+				continue
+			}
+			c, a := heatColor(v), (80+140*v)/255
+			i := out.PixOffset(px, py)
+			p := out.Pix[i : i+4 : i+4]
+			p[0] = uint8(float64(c.R)*a + float64(p[0])*(1-a))
+			p[1] = uint8(float64(c.G)*a + float64(p[1])*(1-a))
+			p[2] = uint8(float64(c.B)*a + float64(p[2])*(1-a))
+			p[3] = uint8(255*a + float64(p[3])*(1-a))
+		}
+	}
+	return out
+}
+
+// bilinear samples grid at fractional cell coordinates
+func bilinear(grid [][]float64, x, y float64) float64 {
+	clamp := func(v float64, n int) (int, int, float64) {
+		v = math.Max(0, math.Min(v, float64(n-1)))
+		i := int(v)
+		return i, minInt(i+1, n-1), v - float64(i)
+	}
+	x0, x1, fx := clamp(x, heatmapCols)
+	y0, y1, fy := clamp(y, heatmapRows)
+	top := grid[y0][x0]*(1-fx) + grid[y0][x1]*fx
+	bottom := grid[y1][x0]*(1-fx) + grid[y1][x1]*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// heatColor maps 0-1 to blue, green, yellow, red
+func heatColor(v float64) color.RGBA {
+	stops := []color.RGBA{{0, 0, 255, 0}, {0, 255, 0, 0}, {255, 255, 0, 0}, {255, 0, 0, 0}}
+	v = math.Max(0, math.Min(v, 1)) * float64(len(stops)-1)
+	i := minInt(int(v), len(stops)-2)
+	f := v - float64(i)
+	mix := func(a, b uint8) uint8 { return uint8(float64(a)*(1-f) + float64(b)*f) }
+	return color.RGBA{mix(stops[i].R, stops[i+1].R), mix(stops[i].G, stops[i+1].G), mix(stops[i].B, stops[i+1].B), 0}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// With MQTT_URL set and HA_DISCOVERY on (the default), the node announces
+// itself to Home Assistant through MQTT discovery, as one device with two
+// entities per class or taxonomy label of HA_CLASSES:
+//
+//	binary_sensor  "<class> detected"  ON when a result has the class, OFF after HA_DETECTION_HOLD
+//	sensor         "<class> count"     detections of the class in the latest result
+//
+// Discovery configs are retained under HA_DISCOVERY_PREFIX (homeassistant, as
+// Home Assistant expects by default) and republished on every connection and
+// reload; entities of classes dropped from HA_CLASSES are removed. States are
+// published below MQTT_TOPIC_PREFIX:
+//
+//	<MQTT_TOPIC_PREFIX>/<class>/detected   "ON"
+//	<MQTT_TOPIC_PREFIX>/<class>/count      "3", retained
+//
+// Availability follows the MQTT status topic, so the entities go unavailable
+// when the node drops off the broker. ESPHome displays and other MQTT
+// consumers can read the same topics.
+
+// haObjectID matches characters Home Assistant does not allow in object IDs
+var haObjectID = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// haDiscovery is the discovery payload of one entity
+type haDiscovery struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	ObjectID          string   `json:"object_id"`
+	StateTopic        string   `json:"state_topic"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	OffDelay          int      `json:"off_delay,omitempty"` // seconds
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	Icon              string   `json:"icon,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+var homeAssistant = struct {
+	sync.Mutex
+	announced map[string]bool // discovery topics currently published
+}{announced: map[string]bool{}}
+
+// startHomeAssistant publishes discovery on connect and reload, and class
+// states for every result
+func startHomeAssistant() {
+	mqtt.onConnected(func() {
+		homeAssistant.Lock()
+		homeAssistant.announced = map[string]bool{}
+		homeAssistant.Unlock()
+		announceHomeAssistant()
+	})
+	bus.subscribe("homeassistant", []string{eventResultCreated, eventConfigReloaded}, nil, 256).consume(func(ev Event) {
+		if ev.Type == eventConfigReloaded {
+			announceHomeAssistant()
+			return
+		}
+		publishHomeAssistantState(ev.Data.(InferenceResult))
+	})
+}
+
+func haNodeID() string {
+	return haObjectID.ReplaceAllString(getEnv("NODE_NAME", "unknown"), "_")
+}
+
+// announceHomeAssistant publishes the discovery configs of HA_CLASSES and
+// clears those of classes no longer listed
+func announceHomeAssistant() {
+	cfg := config()
+	homeAssistant.Lock()
+	defer homeAssistant.Unlock()
+	want := map[string][]byte{}
+	if cfg.HADiscovery {
+		node := haNodeID()
+		device := haDevice{
+			Identifiers:  []string{"yolo_" + node},
+			Name:         "YOLO detector " + getEnv("NODE_NAME", "unknown"),
+			Manufacturer: "quietstorm",
+			Model:        "sample-edge-workload",
+			SWVersion:    activeModelVersion(),
+		}
+		for _, class := range splitList(cfg.HAClasses) {
+			id := node + "_" + haObjectID.ReplaceAllString(class, "_")
+			detected := haDiscovery{
+				Name:              class + " detected",
+				UniqueID:          "yolo_" + id + "_detected",
+				ObjectID:          "yolo_" + id + "_detected",
+				StateTopic:        mqttTopic(class, "detected"),
+				AvailabilityTopic: mqttTopic("status"),
+				DeviceClass:       "occupancy",
+				OffDelay:          int(cfg.HADetectionHold.Seconds()),
+				Device:            device,
+			}
+			count := haDiscovery{
+				Name:              class + " count",
+				UniqueID:          "yolo_" + id + "_count",
+				ObjectID:          "yolo_" + id + "_count",
+				StateTopic:        mqttTopic(class, "count"),
+				AvailabilityTopic: mqttTopic("status"),
+				StateClass:        "measurement",
+				Icon:              "mdi:counter",
+				Device:            device,
+			}
+			for component, entity := range map[string]haDiscovery{"binary_sensor": detected, "sensor": count} {
+				body, err := json.Marshal(entity)
+				if err != nil {
+					continue
+				}
+				want[cfg.HADiscoveryPrefix+"/"+component+"/yolo_"+node+"/"+entity.UniqueID+"/config"] = body
+			}
+		}
+	}
+	for topic := range homeAssistant.announced {
+		if _, ok := want[topic]; !ok {
+			mqtt.publish(topic, nil, true)
+			delete(homeAssistant.announced, topic)
+		}
+	}
+	for topic, body := range want {
+		mqtt.publish(topic, body, true)
+		homeAssistant.announced[topic] = true
+	}
+}
+
+// publishHomeAssistantState updates the class entities from a new result
+func publishHomeAssistantState(r InferenceResult) {
+	cfg := config()
+	if !cfg.HADiscovery || r.Error != "" {
+		return
+	}
+	tax := labels()
+	for _, class := range splitList(cfg.HAClasses) {
+		n := 0
+		for _, d := range r.Detections {
+			if tax.matches(d.ClassName, class) {
+				n++
+			}
+		}
+		if n > 0 {
+			mqtt.publish(mqttTopic(class, "detected"), []byte("ON"), false)
+		}
+		mqtt.publish(mqttTopic(class, "count"), []byte(strconv.Itoa(n)), true)
+	}
+}
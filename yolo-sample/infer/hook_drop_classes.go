@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// dropClassesHook removes detections of the classes listed in HOOK_DROP_CLASSES,
+// e.g. to hide classes that are irrelevant or sensitive at a site
+type dropClassesHook struct {
+	NopHook
+}
+
+func init() {
+	registerHook(dropClassesHook{})
+}
+
+func (dropClassesHook) Name() string { return "drop-classes" }
+
+func (dropClassesHook) AfterInference(result *InferenceResult) error {
+	drop := map[string]bool{}
+	for _, c := range strings.Split(os.Getenv("HOOK_DROP_CLASSES"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			drop[c] = true
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+
+	kept := result.Detections[:0]
+	for _, d := range result.Detections {
+		if !drop[d.ClassName] {
+			kept = append(kept, d)
+		}
+	}
+	result.Detections = kept
+	result.Count = len(kept)
+	return nil
+}
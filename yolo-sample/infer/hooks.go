@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Inference hooks let integrators inject redaction, business logic, or
+// enrichment into the pipeline without forking the handlers. A hook is
+// compiled in by adding a file that calls registerHook from init(), and is
+// switched on by listing its name in HOOKS (comma-separated, run in order):
+//
+//	func init() { registerHook(myHook{}) }
+//
+//	type myHook struct{ NopHook }
+//
+//	func (myHook) Name() string { return "my-hook" }
+//	func (myHook) AfterInference(r *InferenceResult) error {
+//		r.Attributes["site"] = "dock-7"
+//		return nil
+//	}
+
+// HookImage is the image about to be sent to the model. Hooks may rewrite the
+// file at Path in place, e.g. to mask regions before inference.
+type HookImage struct {
+	Path   string
+	Source string
+}
+
+// InferenceHook is implemented by pipeline plugins
+type InferenceHook interface {
+	Name() string
+	// BeforeInference runs before the model; an error rejects the image
+	BeforeInference(img *HookImage) error
+	// AfterInference may modify the result; an error is logged and the result kept
+	AfterInference(result *InferenceResult) error
+}
+
+// NopHook provides no-op implementations so hooks only override what they need
+type NopHook struct{}
+
+func (NopHook) BeforeInference(*HookImage) error      { return nil }
+func (NopHook) AfterInference(*InferenceResult) error { return nil }
+
+var hookRegistry = struct {
+	sync.Mutex
+	registered map[string]InferenceHook
+	enabled    []InferenceHook
+}{registered: map[string]InferenceHook{}}
+
+var hookErrors = newCounterVec("yolo_hook_errors_total",
+	"Errors returned by inference hooks, by hook and phase.", "hook", "phase")
+
+// registerHook makes a hook available; call it from init()
+func registerHook(h InferenceHook) {
+	hookRegistry.Lock()
+	defer hookRegistry.Unlock()
+	if _, dup := hookRegistry.registered[h.Name()]; dup {
+		panic("duplicate inference hook: " + h.Name())
+	}
+	hookRegistry.registered[h.Name()] = h
+}
+
+// enableHooks activates the hooks named in the comma-separated list, in order
+func enableHooks(names string) error {
+	hookRegistry.Lock()
+	defer hookRegistry.Unlock()
+
+	var enabled []InferenceHook
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		h, ok := hookRegistry.registered[name]
+		if !ok {
+			return fmt.Errorf("unknown inference hook %q", name)
+		}
+		enabled = append(enabled, h)
+	}
+	hookRegistry.enabled = enabled
+	if len(enabled) > 0 {
+		log.Printf("Enabled inference hooks: %s", names)
+	}
+	return nil
+}
+
+func enabledHooks() []InferenceHook {
+	hookRegistry.Lock()
+	defer hookRegistry.Unlock()
+	return hookRegistry.enabled
+}
+
+// runBeforeHooks runs BeforeInference for every enabled hook, stopping at the first rejection
+func runBeforeHooks(img *HookImage) error {
+	for _, h := range enabledHooks() {
+		if err := h.BeforeInference(img); err != nil {
+			hookErrors.inc(h.Name(), "before")
+			return fmt.Errorf("rejected by %s: %v", h.Name(), err)
+		}
+	}
+	return nil
+}
+
+// runAfterHooks runs AfterInference for every enabled hook
+func runAfterHooks(result *InferenceResult) {
+	if result.Attributes == nil {
+		result.Attributes = map[string]interface{}{}
+	}
+	for _, h := range enabledHooks() {
+		if err := h.AfterInference(result); err != nil {
+			hookErrors.inc(h.Name(), "after")
+			log.Printf("Warning: hook %s failed on %s: %v", h.Name(), result.Image, err)
+		}
+	}
+	if len(result.Attributes) == 0 {
+		result.Attributes = nil
+	}
+}
+
+// hooksHandler serves GET /api/v1/hooks: registered hooks and which are enabled
+func hooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	type hookView struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+		Order   int    `json:"order,omitempty"`
+	}
+
+	hookRegistry.Lock()
+	order := map[string]int{}
+	for i, h := range hookRegistry.enabled {
+		order[h.Name()] = i + 1
+	}
+	var views []hookView
+	for name := range hookRegistry.registered {
+		views = append(views, hookView{Name: name, Enabled: order[name] > 0, Order: order[name]})
+	}
+	hookRegistry.Unlock()
+
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	writeJSON(w, http.StatusOK, views)
+}
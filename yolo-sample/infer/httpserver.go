@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The web UI and API speak HTTP/2 wherever the client can: over TLS on
+// LISTEN_ADDR when TLS_CERT_FILE and TLS_KEY_FILE are set (negotiated with
+// ALPN, HTTP/1.1 otherwise), and in cleartext with H2C=true for traffic
+// inside a cluster, from an ingress or mesh sidecar that talks h2c to its
+// backends. UNIX_SOCKET stays cleartext. The certificate is re-read when its
+// file changes, so a renewal needs no restart.
+//
+// Event streams hold their connection for as long as the client watches, so
+// there is no overall write timeout (see streams.go); instead the knobs below
+// decide how quickly a connection an edge link has silently dropped is
+// noticed and closed:
+//
+//	HTTP_READ_HEADER_TIMEOUT  a client has this long to send request headers
+//	HTTP_IDLE_TIMEOUT         keep-alive connections without requests close
+//	HTTP2_MAX_STREAMS         concurrent requests and streams per connection
+//	HTTP2_PING_INTERVAL       an HTTP/2 connection quiet this long is pinged
+//	HTTP2_PING_TIMEOUT        and closed if the ping is not answered in time
+//	HTTP2_WRITE_TIMEOUT       or if a write makes no progress this long
+//
+// With HTTP2_PUSH=true, pages answered over HTTP/2 push the manifest and the
+// app icon along with them, for clients that still accept pushes.
+
+var (
+	certMu      sync.Mutex
+	certModTime time.Time
+	certCurrent *tls.Certificate
+)
+
+// serverCertificate returns the certificate of TLS_CERT_FILE, reloading it
+// when either file has changed
+func serverCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cfg := config()
+	modTime := time.Time{}
+	for _, path := range []string{cfg.TLSCertFile, cfg.TLSKeyFile} {
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	certMu.Lock()
+	defer certMu.Unlock()
+	if certCurrent != nil && !modTime.After(certModTime) {
+		return certCurrent, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		if certCurrent != nil {
+			log.Printf("Warning: keeping the previous TLS certificate: %v", err)
+			return certCurrent, nil
+		}
+		return nil, err
+	}
+	if certCurrent != nil {
+		log.Printf("Reloaded the TLS certificate from %s", cfg.TLSCertFile)
+	}
+	certCurrent, certModTime = &cert, modTime
+	return certCurrent, nil
+}
+
+// newHTTPServer returns the server of the web UI and API listeners
+func newHTTPServer(h http.Handler) *http.Server {
+	cfg := config()
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		Protocols:         new(http.Protocols),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.HTTP2MaxStreams,
+			SendPingTimeout:      cfg.HTTP2PingInterval,
+			PingTimeout:          cfg.HTTP2PingTimeout,
+			WriteByteTimeout:     cfg.HTTP2WriteTimeout,
+		},
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(cfg.H2C)
+	if cfg.TLSCertFile != "" {
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: serverCertificate,
+		}
+	}
+	return srv
+}
+
+// serveHTTP serves h on the TCP listener l, over TLS when a certificate is
+// configured
+func serveHTTP(l net.Listener, h http.Handler) error {
+	srv := newHTTPServer(h)
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// httpProtocols describes what the listeners speak, for the startup log
+func httpProtocols(cfg *Config) string {
+	switch {
+	case cfg.TLSCertFile != "":
+		return "HTTPS, HTTP/2"
+	case cfg.H2C:
+		return "HTTP/1.1, h2c"
+	}
+	return "HTTP/1.1"
+}
+
+// withPush pushes the manifest and app icon with pages served over HTTP/2
+func withPush(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pusher, ok := w.(http.Pusher)
+		if ok && config().HTTP2Push && r.Method == http.MethodGet && r.ProtoMajor == 2 &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") {
+			opts := &http.PushOptions{Header: http.Header{"Accept-Encoding": r.Header.Values("Accept-Encoding")}}
+			for _, path := range []string{"/manifest.webmanifest", "/icons/icon-192.png"} {
+				if err := pusher.Push(basePath(r)+path, opts); err != nil {
+					break // the client refused pushes
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateHTTPServer checks the TLS and connection settings
+func validateHTTPServer(cfg *Config) error {
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return fmt.Errorf("TLS_CERT_FILE: %v", err)
+		}
+	}
+	if cfg.HTTPReadHeaderTimeout < 0 || cfg.HTTPIdleTimeout < 0 || cfg.HTTP2PingInterval < 0 ||
+		cfg.HTTP2PingTimeout < 0 || cfg.HTTP2WriteTimeout < 0 || cfg.HTTP2MaxStreams < 0 {
+		return fmt.Errorf("HTTP_* and HTTP2_* settings must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Web UI text and API error messages are translated through message catalogs
+// keyed by the English text. A key may be an fmt format string ("model %q not
+// found in %s"): messages built from it are matched against the pattern and
+// their arguments carried into the translation, in order or by explicit index
+// (%[2]s). "Prefix: detail" messages fall back to translating each half.
+//
+// Built-in catalogs live in messages_<lang>.go; LOCALE_DIR may hold
+// <lang>.json files with the same shape to add languages or override entries.
+// The language is negotiated per request from Accept-Language, falling back to
+// DEFAULT_LANGUAGE. English is the source language and needs no catalog.
+
+type catalog map[string]string
+
+var builtinCatalogs = map[string]catalog{
+	"es": catalogES,
+	"fr": catalogFR,
+}
+
+type messagePattern struct {
+	re          *regexp.Regexp
+	literal     int // length of the key's literal text, to try specific patterns first
+	translation string
+}
+
+type compiledCatalog struct {
+	exact    map[string]string
+	patterns []messagePattern
+}
+
+// translations holds the compiled catalogs by language; filled by loadCatalogs
+var translations = map[string]*compiledCatalog{}
+
+var formatVerb = regexp.MustCompile(`%(?:\[(\d+)\])?[-+# 0]*[0-9]*(?:\.[0-9]+)?[vsqdgfxtT]`)
+
+// loadCatalogs compiles the built-in catalogs merged with any from LOCALE_DIR
+func loadCatalogs() {
+	merged := map[string]catalog{}
+	for lang, c := range builtinCatalogs {
+		merged[lang] = catalog{}
+		for k, v := range c {
+			merged[lang][k] = v
+		}
+	}
+	if config().LocaleDir != "" {
+		files, _ := filepath.Glob(filepath.Join(config().LocaleDir, "*.json"))
+		for _, f := range files {
+			lang := strings.ToLower(strings.TrimSuffix(filepath.Base(f), ".json"))
+			data, err := os.ReadFile(f)
+			var c catalog
+			if err == nil {
+				err = json.Unmarshal(data, &c)
+			}
+			if err != nil {
+				log.Printf("Warning: skipping message catalog %s: %v", f, err)
+				continue
+			}
+			if merged[lang] == nil {
+				merged[lang] = catalog{}
+			}
+			for k, v := range c {
+				merged[lang][k] = v
+			}
+		}
+	}
+
+	for lang, c := range merged {
+		cc := &compiledCatalog{exact: map[string]string{}}
+		for key, value := range c {
+			if !formatVerb.MatchString(key) {
+				cc.exact[key] = value
+				continue
+			}
+			cc.patterns = append(cc.patterns, messagePattern{
+				re:          compileMessagePattern(key),
+				literal:     len(formatVerb.ReplaceAllString(key, "")),
+				translation: value,
+			})
+			// Keys are also used directly as format strings by the templates
+			cc.exact[key] = value
+		}
+		sort.Slice(cc.patterns, func(i, j int) bool { return cc.patterns[i].literal > cc.patterns[j].literal })
+		translations[lang] = cc
+	}
+}
+
+// compileMessagePattern turns a format string into a regexp capturing each verb's text
+func compileMessagePattern(format string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range formatVerb.FindAllStringIndex(format, -1) {
+		b.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		b.WriteString("(.*?)")
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(format[last:]))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// fillMessage substitutes already-formatted arguments for the verbs in translation
+func fillMessage(translation string, args []string) string {
+	next := 0
+	return formatVerb.ReplaceAllStringFunc(translation, func(verb string) string {
+		i := next
+		if m := formatVerb.FindStringSubmatch(verb); m[1] != "" {
+			i, _ = strconv.Atoi(m[1])
+			i--
+		} else {
+			next++
+		}
+		if i < 0 || i >= len(args) {
+			return verb
+		}
+		return args[i]
+	})
+}
+
+// translate returns msg in lang, or msg unchanged when there is no translation
+func translate(lang, msg string) string {
+	c := translations[lang]
+	if c == nil || msg == "" {
+		return msg
+	}
+	if t, ok := c.exact[msg]; ok {
+		return t
+	}
+	for _, p := range c.patterns {
+		if m := p.re.FindStringSubmatch(msg); m != nil {
+			return fillMessage(p.translation, m[1:])
+		}
+	}
+	if prefix, rest, ok := strings.Cut(msg, ": "); ok {
+		if t, ok := c.exact[prefix]; ok {
+			return t + ": " + translate(lang, rest)
+		}
+	}
+	return msg
+}
+
+// supportedLanguage maps a language tag to a language with a catalog, or ""
+func supportedLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	base, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	for _, t := range []string{tag, base} {
+		if t == "en" || translations[t] != nil {
+			return t
+		}
+	}
+	return ""
+}
+
+// negotiateLanguage picks the best supported language from Accept-Language
+func negotiateLanguage(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			} else {
+				q = 0
+			}
+		}
+		if lang := supportedLanguage(tag); lang != "" && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if lang := supportedLanguage(config().DefaultLanguage); lang != "" {
+		return lang
+	}
+	return "en"
+}
+
+// langResponseWriter carries the negotiated language to helpers that only see
+// the ResponseWriter, such as writeJSONError
+type langResponseWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+func (w *langResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// FlushError is Flush for http.ResponseController, reporting a client gone
+func (w *langResponseWriter) FlushError() error {
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *langResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseLanguage returns the language negotiated for w
+func responseLanguage(w http.ResponseWriter) string {
+	for {
+		switch ww := w.(type) {
+		case *langResponseWriter:
+			return ww.lang
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return "en"
+		}
+	}
+}
+
+// withLanguage negotiates the response language for every request
+func withLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := negotiateLanguage(r)
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(&langResponseWriter{ResponseWriter: w, lang: lang}, r)
+	})
+}
+
+// pageFuncs returns the template functions available to every page: branding
+// and theme (see theme.go), {{buildFooter}} (see version.go), plus
+// {{t "text"}} / {{t "format %s" arg}}, {{lang}}, and {{base}}, the sub-path
+// every link of the page starts with (see proxy.go)
+func pageFuncs(r *http.Request) template.FuncMap {
+	lang := negotiateLanguage(r)
+	funcs := themeFuncs(r)
+	funcs["lang"] = func() string { return lang }
+	funcs["pwaHead"] = func() template.HTML { return pwaHead(r) }
+	funcs["cspNonce"] = func() string { return cspNonce(r) }
+	funcs["base"] = func() string { return basePath(r) }
+	funcs["buildFooter"] = func() template.HTML { return versionFooter(lang, basePath(r)) }
+	funcs["classColor"] = classColor
+	funcs["feature"] = featureEnabled
+	funcs["logViewer"] = func() bool { return config().LogViewer }
+	funcs["csrfToken"] = func() string { return csrfToken(r) }
+	funcs["csrfField"] = func() template.HTML {
+		return template.HTML(`<input type="hidden" name="csrf_token" value="` + csrfToken(r) + `">`)
+	}
+	funcs["maintenance"] = func() *MaintenanceView {
+		if v := maintenanceView(); v.Enabled {
+			return &v
+		}
+		return nil
+	}
+	funcs["throttled"] = func() *ThermalStatus {
+		if v := thermalView(); v.Throttled {
+			return &v
+		}
+		return nil
+	}
+	funcs["onBattery"] = func() *PowerStatus {
+		if v := powerView(); v.OnBattery {
+			return &v
+		}
+		return nil
+	}
+	funcs["t"] = func(msg string, args ...interface{}) string {
+		if len(args) == 0 {
+			return translate(lang, msg)
+		}
+		return fmt.Sprintf(translate(lang, msg), args...)
+	}
+	return funcs
+}
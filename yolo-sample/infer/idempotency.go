@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// POSTs that start expensive work (inference, training, evaluations) accept
+// an Idempotency-Key header. The first response for a key is kept for
+// IDEMPOTENCY_WINDOW, and a retry with the same key gets that response
+// replayed, marked with Idempotent-Replayed: true, instead of running the
+// work again. A key belongs to the client that sent it, its browser session or
+// else its address, and is bound to the method and path it was first used with.
+// Server errors (5xx) and 429s are not kept, so a retry after one runs anew.
+// Keys live in memory and are forgotten on restart.
+
+// idempotencyMaxBody bounds the response size kept for replay
+const idempotencyMaxBody = 1 << 20
+
+type idempotentResponse struct {
+	method, path string
+	createdAt    time.Time
+	done         bool
+	status       int
+	header       http.Header
+	body         []byte
+}
+
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+var idempotencyKeys = &idempotencyStore{entries: map[string]*idempotentResponse{}}
+
+var idempotentReplays = newCounterVec("yolo_idempotent_replays_total",
+	"Requests answered from a stored response for a repeated Idempotency-Key, by route.", "route")
+
+// idempotencyClient identifies the sender of r, so that clients that happen
+// to pick the same key do not get each other's responses
+func idempotencyClient(r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return sessionKey(c.Value)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// begin claims key for a new request, or returns the existing entry
+func (s *idempotencyStore) begin(key, method, path string) (entry *idempotentResponse, fresh bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.entries {
+		if e.done && now.Sub(e.createdAt) > config().IdempotencyWindow {
+			delete(s.entries, k)
+		}
+	}
+	if e, ok := s.entries[key]; ok {
+		return e, false
+	}
+	e := &idempotentResponse{method: method, path: path, createdAt: now}
+	s.entries[key] = e
+	return e, true
+}
+
+// finish stores the response for key, or releases the key when it should not be kept
+func (s *idempotencyStore) finish(key string, rec *idempotencyRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[key]
+	if rec.status == 0 || rec.status >= 500 || rec.status == http.StatusTooManyRequests || rec.overflow {
+		delete(s.entries, key)
+		return
+	}
+	e.done, e.status, e.header, e.body = true, rec.status, rec.Header().Clone(), rec.body.Bytes()
+}
+
+// idempotencyRecorder passes a response through while keeping a copy
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > idempotencyMaxBody {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotent wraps a handler so its POSTs honour Idempotency-Key
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeJSONError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		key = idempotencyClient(r) + "\x00" + key
+		// Versions share handlers but not response schemas, so bind the key to both
+		target := requestAPIVersion(r) + " " + r.URL.Path
+		entry, fresh := idempotencyKeys.begin(key, r.Method, target)
+		if !fresh {
+			idempotencyKeys.mu.Lock()
+			done, method, path := entry.done, entry.method, entry.path
+			idempotencyKeys.mu.Unlock()
+			switch {
+			case method != r.Method || path != target:
+				writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			case !done:
+				w.Header().Set("Retry-After", "1")
+				writeJSONError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				// The registered pattern, as several routes are subtrees
+				route := r.Pattern
+				if route == "" {
+					route = routeLabel(r.URL.Path)
+				}
+				idempotentReplays.inc(route)
+				h := w.Header()
+				for k, v := range entry.header {
+					h[k] = v
+				}
+				h.Set("Idempotent-Replayed", "true")
+				h.Set("Content-Length", strconv.Itoa(len(entry.body)))
+				h.Del("Content-Encoding")
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		defer func() { idempotencyKeys.finish(key, rec) }()
+		next(rec, r)
+	}
+}
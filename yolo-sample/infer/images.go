@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Each result keeps a copy of its image in the object store (see objects.go)
+// so it can be displayed later; in privacy mode that copy is the redacted one.
+// Sources can opt down to a thumbnail or to no image at all (see below).
+//
+// A full copy comes with downscaled JPEG derivatives, made from it (so
+// redacted along with it) in STATE_DIR/images/derived when the result is
+// stored, or on first request with IMAGE_DERIVATIVES=false. Listings, previews and synced
+// payloads use them instead of the full frame:
+//
+//	GET /images/{result id}              the stored copy
+//	GET /images/{result id}?size=medium  at most 1024px on the longest side
+//	GET /images/{result id}?size=small   at most 320px
+//
+// A thumbnail-only result answers every size with its thumbnail.
+
+func imagesDir() string {
+	return filepath.Join(config().StateDir, "images")
+}
+
+// Image retention modes, selectable globally (IMAGE_RETENTION) and per source
+// (IMAGE_RETENTION_SOURCES="batch=none,dock=thumbnail"):
+//
+//	full       keep the image (redacted in privacy mode)
+//	thumbnail  keep only a low-resolution, always-redacted thumbnail
+//	none       keep detection metadata only; the frame is never persisted
+const (
+	retentionFull      = "full"
+	retentionThumbnail = "thumbnail"
+	retentionNone      = "none"
+)
+
+// thumbnailMaxDim is the longest side of a retention thumbnail in pixels
+const thumbnailMaxDim = 160
+
+// imageDerivatives are the derivative sizes by name, each made from the
+// previous one
+var imageDerivatives = []struct {
+	name   string
+	maxDim int
+}{{"medium", 1024}, {"small", 320}}
+
+// derivativesMu serializes the derivatives made on request, so concurrent
+// requests for a new one do not write it twice
+var derivativesMu sync.Mutex
+
+// imageRetentionFor returns the retention mode for a result source
+func imageRetentionFor(source string) string {
+	for _, pair := range strings.Split(config().ImageRetentionSources, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.TrimSpace(k) == source {
+			return strings.TrimSpace(v)
+		}
+	}
+	return config().ImageRetention
+}
+
+// storeResultImage saves the image for result according to its source's
+// retention mode. owned means the file at path is a temporary upload that this
+// node may move or delete; batch sources are only ever read.
+func storeResultImage(result *InferenceResult, path string, owned bool) error {
+	mode := imageRetentionFor(result.Source)
+	result.ImageRetention = mode
+
+	// Redacted images need the detections of the whole frame; without them
+	// nothing is kept rather than an image with people in the clear
+	redacting := mode == retentionThumbnail || (mode == retentionFull && config().PrivacyMode)
+	if redacting && !result.inferred {
+		result.ImageRetention = retentionNone
+		if owned {
+			os.Remove(path)
+		}
+		return nil
+	}
+
+	switch mode {
+	case retentionNone:
+		if owned {
+			os.Remove(path)
+		}
+		return nil
+	case retentionThumbnail:
+		if owned {
+			defer os.Remove(path)
+		}
+		img, err := decodeImageFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot create thumbnail: %v", err)
+		}
+		// Redact at full resolution, then downscale, so boxes line up
+		name, err := storeImageObject(resizeImage(redactImage(img, result.unfiltered), thumbnailMaxDim))
+		if err != nil {
+			return err
+		}
+		result.StoredImage = name
+		result.Redacted = needsRedaction(result.unfiltered)
+		return nil
+	case retentionFull:
+	default:
+		return fmt.Errorf("unknown image retention mode %q", mode)
+	}
+
+	if config().PrivacyMode {
+		// Raw frames never outlive the request in privacy mode
+		if owned {
+			defer os.Remove(path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		img, err := decodeImage(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot redact undecodable image: %v", err)
+		}
+		// Re-encoding also drops any embedded metadata
+		img = redactImage(img, result.unfiltered)
+		name, err := storeImageObject(img)
+		if err != nil {
+			return err
+		}
+		result.StoredImage = name
+		result.Redacted = needsRedaction(result.unfiltered)
+		if config().ImageDerivatives {
+			return makeDerivatives(*result, img)
+		}
+		return nil
+	}
+
+	name, err := storeObject(path, owned)
+	if err != nil {
+		return err
+	}
+	result.StoredImage = name
+	if config().ImageDerivatives {
+		return makeDerivatives(*result, nil)
+	}
+	return nil
+}
+
+// derivativesDir holds the derivatives of objects, named after their hash
+func derivativesDir() string {
+	return filepath.Join(imagesDir(), "derived")
+}
+
+// derivativePath is the file of res's image at size; results stored before
+// the object store have theirs next to their image
+func derivativePath(res InferenceResult, size string) string {
+	if isObjectName(res.StoredImage) {
+		hash := strings.TrimSuffix(res.StoredImage, filepath.Ext(res.StoredImage))
+		return filepath.Join(derivativesDir(), hash+"."+size+".jpg")
+	}
+	return filepath.Join(imagesDir(), res.ID+"."+size+".jpg")
+}
+
+// makeDerivatives writes the derivatives of res's image unless another
+// result with the same image did, decoding the image if img is nil
+func makeDerivatives(res InferenceResult, img image.Image) error {
+	// The smallest is written last, so it marks a complete set
+	last := derivativePath(res, imageDerivatives[len(imageDerivatives)-1].name)
+	if _, err := os.Stat(last); err == nil {
+		return nil
+	}
+	if img == nil {
+		var err error
+		if img, err = decodeImageFile(storedImagePath(res)); err != nil {
+			return fmt.Errorf("cannot make image derivatives: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(last), 0755); err != nil {
+		return err
+	}
+	for _, d := range imageDerivatives {
+		img = resizeImage(img, d.maxDim)
+		if err := writeStoredJPEG(derivativePath(res, d.name), img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derivativeFile returns the file of res's image at size, making the
+// derivatives if they are missing
+func derivativeFile(res InferenceResult, size string) (string, error) {
+	if res.ImageRetention == retentionThumbnail {
+		return storedImagePath(res), nil
+	}
+	path := derivativePath(res, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	derivativesMu.Lock()
+	defer derivativesMu.Unlock()
+	return path, makeDerivatives(res, nil)
+}
+
+// removeResultImages deletes the image of res and its derivatives, unless
+// they are in the object store, where the storage GC deletes them once no
+// result holds them
+func removeResultImages(res InferenceResult) {
+	if res.StoredImage == "" || isObjectName(res.StoredImage) {
+		return
+	}
+	os.Remove(storedImagePath(res))
+	for _, d := range imageDerivatives {
+		os.Remove(derivativePath(res, d.name))
+	}
+}
+
+// decodeImageFile decodes an image file, stored encrypted or not
+func decodeImageFile(path string) (image.Image, error) {
+	data, err := readStoredFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(bytes.NewReader(data))
+}
+
+// decodeImage decodes an image once its header shows it within
+// MAX_IMAGE_PIXELS, so a small file declaring huge dimensions cannot exhaust
+// memory
+func decodeImage(r io.ReadSeeker) (image.Image, error) {
+	c, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit := config().MaxImagePixels; int64(c.Width)*int64(c.Height) > int64(limit) {
+		return nil, fmt.Errorf("image is %dx%d, over MAX_IMAGE_PIXELS (%d)", c.Width, c.Height, limit)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// imageSize reads the pixel dimensions of an image file without decoding it
+func imageSize(path string) (image.Point, error) {
+	if encryptionOn() {
+		data, err := readStoredFile(path)
+		if err != nil {
+			return image.Point{}, err
+		}
+		c, _, err := image.DecodeConfig(bytes.NewReader(data))
+		return image.Point{X: c.Width, Y: c.Height}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer f.Close()
+	c, _, err := image.DecodeConfig(f)
+	return image.Point{X: c.Width, Y: c.Height}, err
+}
+
+// resizeImage downscales img so its longest side is at most maxDim, averaging
+// the source pixels covered by each output pixel. Smaller images are returned as-is.
+func resizeImage(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+	nw, nh := maxDim, h*maxDim/w
+	if h > w {
+		nw, nh = w*maxDim/h, maxDim
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			o := out.PixOffset(x, y)
+			out.Pix[o] = uint8(r / n >> 8)
+			out.Pix[o+1] = uint8(g / n >> 8)
+			out.Pix[o+2] = uint8(bl / n >> 8)
+			out.Pix[o+3] = uint8(a / n >> 8)
+		}
+	}
+	return out
+}
+
+func writeJPEG(path string, img image.Image) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// storedImagePath is the file of a result's image
+func storedImagePath(res InferenceResult) string {
+	if isObjectName(res.StoredImage) {
+		return objectPath(res.StoredImage)
+	}
+	return filepath.Join(imagesDir(), filepath.Base(res.StoredImage))
+}
+
+// imageHandler serves GET /images/{result id}
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/images/")
+	res, ok := results.get(id)
+	if !ok && forwardToOwner(w, r, id) {
+		return
+	}
+	if !ok || res.StoredImage == "" {
+		http.NotFound(w, r)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		serveStoredFile(w, r, storedImagePath(res))
+		return
+	}
+	known := false
+	for _, d := range imageDerivatives {
+		known = known || d.name == size
+	}
+	if !known {
+		http.Error(w, "unknown size "+strconv.Quote(size), http.StatusBadRequest)
+		return
+	}
+	path, err := derivativeFile(res, size)
+	if err != nil {
+		log.Printf("Warning: cannot make the %s image of result %s: %v", size, id, err)
+		http.Error(w, "image unavailable", http.StatusInternalServerError)
+		return
+	}
+	// A result's image never changes once stored
+	w.Header().Set("Cache-Control", "private, max-age=604800, immutable")
+	w.Header().Set("ETag", strconv.Quote(id+"."+size))
+	serveStoredFile(w, r, path)
+}
+
+// imageURL is the URL of res's image at size, "" for the stored copy, under
+// the sub-path base
+func imageURL(base string, res InferenceResult, size string) string {
+	if size == "" {
+		return base + "/images/" + res.ID
+	}
+	return base + "/images/" + res.ID + "?size=" + size
+}
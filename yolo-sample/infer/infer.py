@@ -1,21 +1,50 @@
 #!/usr/bin/env python3
 """
-Inference Script - Run inference on a single image and return JSON results
+Inference Script - Run inference on a single image and return JSON results,
+or with --serve answer requests from the web UI on stdin/stdout
+
+Protocol (version 1):
+  - stdout carries only results, one JSON object per line, each with
+    "protocol": 1; logs and anything else printed go to stderr
+  - successful results include the model's "classes" as {"<id>": "<name>"}
+  - --serve reads one JSON request per stdin line and answers each with
+    one result line
+  - exit codes: 0 results were printed (a result may still carry an "error"
+    for its image), 2 usage error, 3 the model could not be loaded (an error
+    result is printed first); anything else is a crash
 """
 
+import argparse
 import sys
 import json
 from pathlib import Path
-from ultralytics import YOLO
 import os
 
+PROTOCOL_VERSION = 1
+EXIT_USAGE = 2
+EXIT_MODEL = 3
+
+# Keep the real stdout for results and point file descriptor 1 at stderr, so
+# stray prints, including from native libraries, cannot corrupt the protocol
+RESULTS = os.fdopen(os.dup(1), "w")
+os.dup2(2, 1)
+sys.stdout = sys.stderr
+
+from ultralytics import YOLO
+
 # Configuration
 MODEL_DIR = os.getenv('MODEL_DIR', './models')
+MODEL_PATH = os.getenv('MODEL_PATH')  # explicit model version chosen by the web UI
 
-def load_model():
+def load_model(explicit_path=MODEL_PATH):
     """Load the production model (aggregated from gateway)"""
-    # Use production model (good at everything) instead of local trained model
-    model_path = f'{MODEL_DIR}/production.pt'
+    if explicit_path:
+        if not Path(explicit_path).exists():
+            return None, f"No model found at {explicit_path}"
+        model_path = explicit_path
+    else:
+        # Use production model (good at everything) instead of local trained model
+        model_path = f'{MODEL_DIR}/production.pt'
 
     if not Path(model_path).exists():
         # Fallback to base model if production doesn't exist yet
@@ -29,14 +58,27 @@ def load_model():
     except Exception as e:
         return None, str(e)
 
-def run_inference(model, image_path):
+def run_inference(model, image_path, min_conf=None, augment=False, imgsz=None, iou=None, max_det=None):
     """Run inference on a single image and return results as JSON"""
     if not Path(image_path).exists():
         return {"error": f"Image not found: {image_path}"}
 
     try:
         # Run inference with lower confidence threshold for federated model
-        results = model(image_path, verbose=False)
+        options = {"verbose": False}
+        if min_conf is not None:
+            options["conf"] = min_conf
+        if augment:
+            # Test-time augmentation: predict over flipped and rescaled copies
+            options["augment"] = True
+        # Per-request overrides; unset keeps the model's defaults
+        if imgsz:
+            options["imgsz"] = imgsz
+        if iou:
+            options["iou"] = iou
+        if max_det:
+            options["max_det"] = max_det
+        results = model(image_path, **options)
 
         detections = []
         for r in results:
@@ -57,34 +99,80 @@ def run_inference(model, image_path):
                             "x1": round(xyxy[0], 2),
                             "y1": round(xyxy[1], 2),
                             "x2": round(xyxy[2], 2),
-                            "y3": round(xyxy[3], 2)
+                            "y2": round(xyxy[3], 2)
                         }
                     })
 
         return {
             "image": Path(image_path).name,
             "detections": detections,
-            "count": len(detections)
+            "count": len(detections),
+            # Lets the web UI check class IDs against the model's classes
+            "classes": {str(k): v for k, v in model.names.items()},
+            # Milliseconds per stage, for the result's timing breakdown
+            "speed": dict(results[0].speed) if len(results) > 0 else None
         }
     except Exception as e:
         return {"error": str(e)}
 
-def main():
-    if len(sys.argv) < 2:
-        print(json.dumps({"error": "Usage: python infer.py <image_path>"}))
-        sys.exit(1)
+def emit(result):
+    """Write one result line to the protocol stream"""
+    result["protocol"] = PROTOCOL_VERSION
+    RESULTS.write(json.dumps(result) + "\n")
+    RESULTS.flush()
+
+def serve(default_model, conf):
+    """Answer one JSON request per stdin line, {"image": ..., "model": ..., "conf": ..., "augment": ...},
+    optionally with "imgsz", "iou" and "max_det", with one JSON result line on stdout.
+    Loaded models are kept between requests."""
+    models = {}
+    for line in sys.stdin:
+        try:
+            request = json.loads(line)
+        except ValueError as e:
+            emit({"error": f"Invalid request: {e}"})
+            continue
+
+        model_path = request.get("model") or default_model
+        model = models.get(model_path)
+        if model is None:
+            model, error = load_model(model_path)
+            if error:
+                emit({"error": error})
+                continue
+            models[model_path] = model
+
+        emit(run_inference(model, request.get("image", ""), request.get("conf", conf), request.get("augment", False),
+                           request.get("imgsz"), request.get("iou"), request.get("max_det")))
 
-    image_path = sys.argv[1]
+def main():
+    parser = argparse.ArgumentParser(description="Run YOLO inference and print JSON results")
+    parser.add_argument("image", nargs="?", help="image to run inference on")
+    parser.add_argument("--serve", action="store_true", help="answer JSON requests on stdin instead")
+    parser.add_argument("--model", default=MODEL_PATH, help="model file (default: MODEL_PATH or the production model)")
+    parser.add_argument("--conf", type=float, help="minimum detection confidence")
+    parser.add_argument("--tta", action="store_true", help="use test-time augmentation")
+    parser.add_argument("--imgsz", type=int, help="input size in pixels (default: the model's)")
+    parser.add_argument("--iou", type=float, help="IoU threshold of non-maximum suppression")
+    parser.add_argument("--max-det", type=int, help="most detections per image")
+    args = parser.parse_args()
+
+    if args.serve:
+        serve(args.model, args.conf)
+        return
+
+    if not args.image:
+        parser.print_usage(sys.stderr)
+        sys.exit(EXIT_USAGE)
 
     # Load model
-    model, error = load_model()
+    model, error = load_model(args.model)
     if error:
-        print(json.dumps({"error": error}))
-        sys.exit(1)
+        emit({"error": error})
+        sys.exit(EXIT_MODEL)
 
     # Run inference
-    result = run_inference(model, image_path)
-    print(json.dumps(result, indent=2))
+    emit(run_inference(model, args.image, args.conf, args.tta, args.imgsz, args.iou, args.max_det))
 
 if __name__ == "__main__":
     main()
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Images can also be submitted without a local file: by URL, fetched by the
+// node, or as a base64 payload for clients that hold the image in memory.
+// Both are queued like uploads; see jobs.go. (The upload page sends pasted
+// clipboard images through the resumable upload API like any other file.)
+//
+// URL fetches are an SSRF risk, since the node sits inside the site network.
+// Only http(s) URLs on public addresses are fetched unless
+// INGEST_ALLOW_PRIVATE is set, and the check is made on the address actually
+// dialled, so redirects and DNS rebinding cannot reach internal services.
+
+// ingestTimeout bounds a URL fetch, including redirects
+const ingestTimeout = 15 * time.Second
+
+// errBlockedAddress is returned when a URL resolves to a disallowed address
+var errBlockedAddress = errors.New("url resolves to a private or local address")
+
+var ingestClient = &http.Client{
+	Timeout: ingestTimeout,
+	Transport: &http.Transport{
+		Proxy: nil, // a proxy would dial on our behalf and bypass the address check
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: ingestDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// ingestDialControl rejects connections to non-public addresses
+func ingestDialControl(network, address string, _ syscall.RawConn) error {
+	if config().IngestAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return errBlockedAddress
+	}
+	return nil
+}
+
+// cgnatNet is the carrier-grade NAT range, which net.IP.IsPrivate does not cover
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatNet.Contains(ip))
+}
+
+// saveIngested writes an image into a new job directory, returning the job ID
+// and path. The content must sniff as an image.
+func saveIngested(name string, data []byte) (id, path string, err error) {
+	ctype := http.DetectContentType(data)
+	if !strings.HasPrefix(ctype, "image/") {
+		return "", "", fmt.Errorf("content is not an image (detected %s)", ctype)
+	}
+	name = filepath.Base(name)
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		name = "image"
+	}
+	if filepath.Ext(name) == "" {
+		if exts, _ := mime.ExtensionsByType(ctype); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+
+	id = newID()
+	dir := filepath.Join(uploadDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return id, path, nil
+}
+
+// fetchImage downloads an image of at most config.IngestMaxBytes
+func fetchImage(ctx context.Context, raw string) (name string, data []byte, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := ingestClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return "", nil, errBlockedAddress
+		}
+		return "", nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetch failed: %s", resp.Status)
+	}
+	if resp.ContentLength > config().IngestMaxBytes {
+		return "", nil, fmt.Errorf("image exceeds %d bytes", config().IngestMaxBytes)
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, config().IngestMaxBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	if int64(len(data)) > config().IngestMaxBytes {
+		return "", nil, fmt.Errorf("image exceeds %d bytes", config().IngestMaxBytes)
+	}
+	return filepath.Base(resp.Request.URL.Path), data, nil
+}
+
+// decodeBase64Image accepts plain or URL-safe base64, or a data: URL
+func decodeBase64Image(payload string) ([]byte, error) {
+	if strings.HasPrefix(payload, "data:") {
+		meta, encoded, ok := strings.Cut(payload, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, fmt.Errorf("data URLs must be base64 encoded")
+		}
+		payload = encoded
+	}
+	payload = strings.TrimRight(strings.Join(strings.Fields(payload), ""), "=")
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		if data, err = base64.RawURLEncoding.DecodeString(payload); err != nil {
+			return nil, fmt.Errorf("image is not valid base64")
+		}
+	}
+	if int64(len(data)) > config().IngestMaxBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", config().IngestMaxBytes)
+	}
+	return data, nil
+}
+
+// URLIngestRequest is the body of POST /api/v1/infer/url
+type URLIngestRequest struct {
+	URL string `json:"url"`
+	InferenceOptions
+}
+
+// Base64IngestRequest is the body of POST /api/v1/infer/base64
+type Base64IngestRequest struct {
+	Image    string `json:"image"` // base64 or a data: URL
+	Filename string `json:"filename"`
+	InferenceOptions
+}
+
+// IngestJob is the answer to an accepted image: the queued job and where
+// its progress and result will be
+type IngestJob struct {
+	JobID     string `json:"job_id"`
+	EventsURL string `json:"events_url"`
+	ResultURL string `json:"result_url"`
+}
+
+// inferIngestHandler serves image ingestion without a multipart upload
+//
+//	POST /api/v1/infer/url     {"url": "https://example.com/cam.jpg"}
+//	POST /api/v1/infer/base64  {"image": "<base64 or data: URL>", "filename": "paste.png"}
+//
+// Either body may also override "model", "threshold", "classes", "imgsz",
+// "iou_threshold" and "max_detections"; see options.go.
+//
+// Both answer 202 with the job, as for uploads: {"job_id", "events_url", "result_url"}
+func inferIngestHandler(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var (
+		name, source string
+		data         []byte
+		opts         InferenceOptions
+	)
+	switch strings.TrimPrefix(r.URL.Path, "/api/v1/infer/") {
+	case "url":
+		var req URLIngestRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		opts = req.InferenceOptions
+		var err error
+		if name, data, err = fetchImage(r.Context(), req.URL); err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, errBlockedAddress) || strings.HasPrefix(err.Error(), "url must") {
+				status = http.StatusBadRequest
+			}
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		source = "url"
+
+	case "base64":
+		var req Base64IngestRequest
+		// Base64 inflates by 4/3; allow some room for the JSON around it
+		if err := decodeJSONLimit(w, r, &req, config().IngestMaxBytes*4/3+4096); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		var err error
+		if data, err = decodeBase64Image(req.Image); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		name, source, opts = req.Filename, "base64", req.InferenceOptions
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if err := opts.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, path, err := saveIngested(name, data)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := jobs.submit(id, path, source, opts, received); err != nil {
+		os.RemoveAll(filepath.Dir(path))
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, IngestJob{JobID: id, EventsURL: basePath(r) + "/events/jobs/" + id, ResultURL: basePath(r) + "/results/" + id})
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uploads are processed asynchronously: POST /upload saves the image, queues
+// a job and returns at once, and the result page for the job's ID (which
+// becomes the result's ID) follows its progress over server-sent events at
+// /events/jobs/{id} until the result is stored. Unfinished jobs are kept on
+// disk and picked up again after a restart; see jobstore.go. Replicas
+// sharing a queue take each other's jobs; see cluster.go.
+
+// Job stages, in order; a job that cannot be processed ends in jobFailed
+const (
+	jobUploaded  = "uploaded"
+	jobQueued    = "queued"
+	jobInferring = "inferring"
+	jobDone      = "done"
+	jobFailed    = "failed"
+)
+
+// jobRetention is how long finished jobs are kept for late subscribers
+const jobRetention = 10 * time.Minute
+
+// JobEvent is the payload of a job's SSE events
+type JobEvent struct {
+	JobID string `json:"job_id"`
+	Stage string `json:"stage"`
+	Error string `json:"error,omitempty"`
+}
+
+type uploadJob struct {
+	id         string
+	path       string // the saved upload, removed with its directory when the job ends
+	source     string
+	opts       InferenceOptions // request overrides
+	stage      string
+	err        string
+	durable    string // copy of the image under STATE_DIR/jobs, if it could be made
+	attempts   int    // inference runs started, counting those cut short by restarts
+	remote     bool   // run by another replica sharing the queue
+	uploadMS   float64
+	queuedAt   time.Time
+	finishedAt time.Time
+}
+
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*uploadJob
+	queue chan *uploadJob
+}
+
+var jobs *jobQueue
+
+var (
+	uploadQueueDepth = newGaugeVec("yolo_upload_queue_depth",
+		"Uploads waiting for an inference worker.")
+	uploadJobsTotal = newCounterVec("yolo_upload_jobs_total",
+		"Finished upload jobs by outcome.", "status")
+)
+
+// startJobQueue starts workers inference workers draining a queue of up to size uploads
+func startJobQueue(workers, size int) *jobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &jobQueue{jobs: map[string]*uploadJob{}, queue: make(chan *uploadJob, size)}
+	recovered := q.restore()
+	for i := 0; i < workers; i++ {
+		if clusterOn() {
+			go q.clusterWork(i)
+		} else {
+			go q.work(i)
+		}
+	}
+	// Recovered jobs may outnumber the queue's slots; they wait their turn
+	go func() {
+		for _, j := range recovered {
+			if clusterOn() {
+				q.share(j)
+				continue
+			}
+			q.queue <- j
+			uploadQueueDepth.set(float64(len(q.queue)))
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.prune()
+		}
+	}()
+	return q
+}
+
+// errQueueFull is returned by submit when every queue slot is taken
+var errQueueFull = errors.New("upload queue is full; try again later")
+
+// submit registers a job for the image at path (inside its own directory
+// under uploadDir) and queues it, recording results under source. When the
+// queue is full nothing is registered and the caller keeps ownership of the file.
+// uploadStart is when receiving the image began, for the result's timing.
+func (q *jobQueue) submit(id, path, source string, opts InferenceOptions, uploadStart time.Time) error {
+	j := &uploadJob{id: id, path: path, source: source, opts: opts, stage: jobUploaded, queuedAt: time.Now().UTC()}
+	j.uploadMS = millis(j.queuedAt.Sub(uploadStart))
+	if clusterOn() {
+		if err := cluster.enqueue(j); err != nil {
+			return err
+		}
+		q.mu.Lock()
+		q.jobs[id] = j
+		q.mu.Unlock()
+		q.setStage(j, jobQueued, "")
+		return nil
+	}
+	if err := persistJobImage(j); err != nil {
+		log.Printf("Warning: job %s will not survive a restart: %v", id, err)
+	}
+	q.mu.Lock()
+	q.jobs[id] = j
+	q.mu.Unlock()
+
+	select {
+	case q.queue <- j:
+		q.setStage(j, jobQueued, "")
+		uploadQueueDepth.set(float64(len(q.queue)))
+		return nil
+	default:
+		q.mu.Lock()
+		delete(q.jobs, id)
+		q.mu.Unlock()
+		os.RemoveAll(jobStateDir(id))
+		return errQueueFull
+	}
+}
+
+// work runs upload worker n
+func (q *jobQueue) work(n int) {
+	for {
+		thermalWait(n)
+		j, ok := <-q.queue
+		if !ok {
+			return
+		}
+		uploadQueueDepth.set(float64(len(q.queue)))
+		q.mu.Lock()
+		j.attempts++
+		q.mu.Unlock()
+		q.run(j)
+	}
+}
+
+// run infers j and stores its result
+func (q *jobQueue) run(j *uploadJob) {
+	q.setStage(j, jobInferring, "")
+	timing := ResultTiming{UploadMS: j.uploadMS, QueueWaitMS: millis(time.Since(j.queuedAt))}
+	processImageAs(j.id, j.path, j.source, true, j.opts, timing)
+	os.RemoveAll(filepath.Dir(j.path))
+	if _, ok := results.get(j.id); !ok {
+		q.setStage(j, jobFailed, "the result could not be stored")
+		return
+	}
+	q.setStage(j, jobDone, "")
+}
+
+// setStage moves j to stage and publishes a job.stage event, to the other
+// replicas too when the queue is shared
+func (q *jobQueue) setStage(j *uploadJob, stage, errMsg string) {
+	ev := JobEvent{JobID: j.id, Stage: stage, Error: errMsg}
+	q.mu.Lock()
+	j.stage, j.err = stage, errMsg
+	if stage == jobDone || stage == jobFailed {
+		j.finishedAt = time.Now()
+		uploadJobsTotal.inc(stage)
+		os.RemoveAll(jobStateDir(j.id))
+	} else {
+		saveJobRecord(j)
+	}
+	bus.publish(eventJobStage, ev)
+	q.mu.Unlock()
+	if clusterOn() {
+		cluster.shareStage(ev)
+	}
+}
+
+// subscribe returns the job's current stage and a subscription to its later
+// job.stage events; ok is false for unknown jobs. A slow subscriber loses
+// intermediate stages, never the latest.
+func (q *jobQueue) subscribe(id string) (current JobEvent, sub *subscription, ok bool) {
+	sub = bus.subscribe("job", []string{eventJobStage}, func(ev Event) bool {
+		return ev.Data.(JobEvent).JobID == id
+	}, 4)
+	if !q.known(id) && !q.adopt(id) {
+		bus.unsubscribe(sub)
+		return JobEvent{}, nil, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		bus.unsubscribe(sub)
+		return JobEvent{}, nil, false
+	}
+	return JobEvent{JobID: id, Stage: j.stage, Error: j.err}, sub, true
+}
+
+func (q *jobQueue) known(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.jobs[id]
+	return ok
+}
+
+// has reports whether a job with this ID is known
+func (q *jobQueue) has(id string) bool {
+	_, ok := q.stage(id)
+	return ok
+}
+
+// stage returns the current stage of a known job, including the jobs of
+// other replicas when the queue is shared
+func (q *jobQueue) stage(id string) (string, bool) {
+	if !q.known(id) && !q.adopt(id) {
+		return "", false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return "", false
+	}
+	return j.stage, true
+}
+
+// inferring counts the jobs an inference worker of this node is running
+func (q *jobQueue) inferring() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, j := range q.jobs {
+		if j.stage == jobInferring && !j.remote {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *jobQueue) prune() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, j := range q.jobs {
+		if !j.finishedAt.IsZero() && time.Since(j.finishedAt) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// jobEventsHandler serves GET /events/jobs/{id} as a text/event-stream of
+// "stage" events, ending after done or failed. Jobs that have already been
+// pruned but whose result is stored report done.
+func jobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/events/jobs/")
+	current, sub, ok := jobs.subscribe(id)
+	if !ok {
+		if _, stored := results.get(id); !stored {
+			writeJSONError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		current = JobEvent{JobID: id, Stage: jobDone}
+	} else {
+		defer bus.unsubscribe(sub)
+	}
+
+	stream, ok := openEventStream(w, "job")
+	if !ok {
+		return
+	}
+	lang := negotiateLanguage(r)
+	send := func(ev JobEvent) (bool, error) {
+		ev.Error = translate(lang, ev.Error)
+		data, _ := json.Marshal(ev)
+		if err := stream.send("event: stage\ndata: %s\n\n", data); err != nil {
+			return false, err
+		}
+		return ev.Stage != jobDone && ev.Stage != jobFailed, nil
+	}
+	if more, err := send(current); err != nil || !more {
+		reason := "completed"
+		if err != nil {
+			reason = "write_error"
+		}
+		stream.close(reason)
+		return
+	}
+	stream.serve(r, sub.C, func(ev Event) (bool, error) {
+		return send(ev.Data.(JobEvent))
+	})
+}
+
+// renderJobProgress renders the result page of a job that is still running;
+// it follows the job's events and reloads once the result is stored
+func renderJobProgress(w http.ResponseWriter, r *http.Request, id string) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Results"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .results {
+            background: white;
+            padding: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .stages {
+            list-style: none;
+            padding: 0;
+        }
+        .stages li {
+            padding: 8px 0 8px 28px;
+            position: relative;
+            color: #999;
+        }
+        .stages li::before {
+            content: "";
+            position: absolute;
+            left: 4px;
+            top: 11px;
+            width: 12px;
+            height: 12px;
+            border-radius: 50%;
+            border: 2px solid #ccc;
+        }
+        .stages li.complete {
+            color: #333;
+        }
+        .stages li.complete::before {
+            background: #4CAF50;
+            border-color: #4CAF50;
+        }
+        .stages li.current {
+            color: #333;
+            font-weight: bold;
+        }
+        .stages li.current::before {
+            border-color: #4CAF50;
+            border-top-color: transparent;
+            animation: spin 1s linear infinite;
+        }
+        @keyframes spin {
+            to { transform: rotate(360deg); }
+        }
+        .error {
+            color: #d32f2f;
+            background-color: #ffebee;
+            padding: 15px;
+            border-radius: 4px;
+            display: none;
+        }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Detection Results"}}</h1>
+    <div class="results">
+        <ul class="stages" id="stages">
+            <li data-stage="uploaded">{{t "Image uploaded"}}</li>
+            <li data-stage="queued">{{t "Waiting for an inference worker"}}</li>
+            <li data-stage="inferring">{{t "Running inference..."}}</li>
+            <li data-stage="done">{{t "Done"}}</li>
+        </ul>
+        <div class="error" id="jobError"></div>
+    </div>
+    <a href="{{base}}/">{{t "← Upload Another Image"}}</a>
+    <script nonce="{{cspNonce}}">
+        const order = ['uploaded', 'queued', 'inferring', 'done'];
+        const failedText = {{t "Processing failed: %s"}};
+        const lostText = {{t "Lost contact with the node. Reload the page to check on the result."}};
+        function show(stage) {
+            const reached = order.indexOf(stage);
+            document.querySelectorAll('#stages li').forEach(function(li) {
+                const i = order.indexOf(li.dataset.stage);
+                li.className = i < reached || stage === 'done' ? 'complete' : (i === reached ? 'current' : '');
+            });
+        }
+        function fail(msg) {
+            const box = document.getElementById('jobError');
+            box.textContent = msg;
+            box.style.display = 'block';
+            document.querySelectorAll('#stages li.current').forEach(function(li) { li.className = ''; });
+        }
+        show('uploaded');
+        const events = new EventSource({{base}} + '/events/jobs/' + {{.}});
+        events.addEventListener('stage', function(e) {
+            const ev = JSON.parse(e.data);
+            if (ev.stage === 'failed') {
+                events.close();
+                fail(failedText.replace('%s', ev.error || ''));
+                return;
+            }
+            show(ev.stage);
+            if (ev.stage === 'done') {
+                events.close();
+                location.reload();
+            }
+        });
+        events.onerror = function() {
+            if (events.readyState === EventSource.CLOSED) {
+                fail(lostText);
+            }
+        };
+    </script>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("job").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	t.Execute(w, id)
+}
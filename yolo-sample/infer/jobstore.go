@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Upload jobs outlive the process: each unfinished job has a directory
+// STATE_DIR/jobs/<id> holding a copy of its image (a hard link where the
+// filesystem allows) and job.json, rewritten at every stage. Finished jobs
+// remove theirs. At startup the queue reads these back in submission order:
+//
+//	uploaded, queued  queued again
+//	inferring         the process stopped mid-inference: queued again, unless
+//	                  the job has used up JOB_MAX_ATTEMPTS (default 2), in which
+//	                  case it fails with the reason
+//	(result stored)   done; the process stopped between storing and reporting
+//
+// Failed and done jobs stay visible at /events/jobs/{id} for jobRetention as
+// usual. Training runs are Kubernetes Jobs (see training.go) and survive
+// restarts of this process on their own.
+
+// jobRecord is the on-disk form of an unfinished upload job
+type jobRecord struct {
+	ID       string           `json:"id"`
+	Image    string           `json:"image"` // file name inside the job's directory
+	Source   string           `json:"source"`
+	Options  InferenceOptions `json:"options"`
+	Stage    string           `json:"stage"`
+	Attempts int              `json:"attempts"`
+	QueuedAt time.Time        `json:"queued_at"`
+	UploadMS float64          `json:"upload_ms,omitempty"`
+}
+
+var jobsRecovered = newCounterVec("yolo_upload_jobs_recovered_total",
+	"Upload jobs found unfinished at startup by outcome.", "status")
+
+func jobStateDir(id string) string {
+	return filepath.Join(config().StateDir, "jobs", id)
+}
+
+// persistJobImage keeps a copy of the job's image where it survives a restart
+func persistJobImage(j *uploadJob) error {
+	dir := jobStateDir(j.id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, filepath.Base(j.path))
+	if err := os.Link(j.path, dst); err != nil {
+		if err := copyFile(j.path, dst); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+	j.durable = dst
+	return nil
+}
+
+// saveJobRecord writes the state of an unfinished job; called with q.mu held
+func saveJobRecord(j *uploadJob) {
+	if j.durable == "" {
+		return
+	}
+	data, _ := json.Marshal(jobRecord{
+		ID: j.id, Image: filepath.Base(j.durable), Source: j.source, Options: j.opts,
+		Stage: j.stage, Attempts: j.attempts, QueuedAt: j.queuedAt, UploadMS: j.uploadMS,
+	})
+	if err := writeFileAtomic(filepath.Join(filepath.Dir(j.durable), "job.json"), data); err != nil {
+		log.Printf("Warning: failed to save job %s: %v", j.id, err)
+	}
+}
+
+// restore reads the jobs left unfinished by the previous process, settles
+// those that cannot run again and returns the rest, oldest first
+func (q *jobQueue) restore() []*uploadJob {
+	entries, err := os.ReadDir(filepath.Join(config().StateDir, "jobs"))
+	if err != nil {
+		return nil
+	}
+	var pending []*uploadJob
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(config().StateDir, "jobs", e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "job.json"))
+		var rec jobRecord
+		if err == nil {
+			err = json.Unmarshal(data, &rec)
+		}
+		if err != nil || rec.ID != e.Name() {
+			// Submitted but never queued, or unreadable: nothing to resume
+			os.RemoveAll(dir)
+			continue
+		}
+		j := &uploadJob{
+			id: rec.ID, path: filepath.Join(dir, rec.Image), durable: filepath.Join(dir, rec.Image),
+			source: rec.Source, opts: rec.Options, stage: rec.Stage, attempts: rec.Attempts, queuedAt: rec.QueuedAt,
+			uploadMS: rec.UploadMS,
+		}
+		q.jobs[j.id] = j
+		switch {
+		case rec.Stage == jobInferring && resultStored(j.id):
+			jobsRecovered.inc(jobDone)
+			q.setStage(j, jobDone, "")
+		case rec.Stage == jobInferring && rec.Attempts >= config().JobMaxAttempts:
+			jobsRecovered.inc(jobFailed)
+			log.Printf("Warning: job %s failed: interrupted by restarts %d times", j.id, rec.Attempts)
+			q.setStage(j, jobFailed, "inference was interrupted by restarts too many times")
+		default:
+			jobsRecovered.inc(jobQueued)
+			q.setStage(j, jobQueued, "")
+			pending = append(pending, j)
+		}
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].queuedAt.Before(pending[b].queuedAt) })
+	if len(pending) > 0 {
+		log.Printf("Resuming %d upload jobs left unfinished by the previous run", len(pending))
+	}
+	return pending
+}
+
+func resultStored(id string) bool {
+	_, ok := results.get(id)
+	return ok
+}
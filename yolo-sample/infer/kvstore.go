@@ -0,0 +1,167 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Short-lived state of the node (cached inference results, rate-limit
+// counters and admin page sessions) lives in a key-value store with expiry.
+// By default it is kept in memory and lost with the process; with CACHE_URL
+// naming a Redis server it is kept there, where it survives restarts and is
+// shared by replicas behind one load balancer, so a session started on one
+// replica is honoured by the next. CACHE_URL defaults to QUEUE_URL.
+//
+// Redis being unreachable is not fatal: a cache lookup misses, a rate limit
+// lets the request through and a session is not found, and a warning is
+// logged once per outage.
+
+// kvStore holds string values under keys that expire
+type kvStore interface {
+	get(key string) (string, bool, error)
+	set(key, value string, ttl time.Duration) error
+	del(key string) error
+	// incr adds one to a counter that expires ttl after its creation
+	incr(key string, ttl time.Duration) (int64, error)
+}
+
+// kv is the node's key-value store, set by startKVStore
+var kv kvStore = newMemoryStore()
+
+// startKVStore picks the store CACHE_URL (or QUEUE_URL) names
+func startKVStore() {
+	cfg := config()
+	target := cfg.CacheURL
+	if target == "" {
+		target = cfg.QueueURL
+	}
+	if target == "" {
+		return
+	}
+	client, err := newRedisClient(target)
+	if err != nil {
+		log.Fatalf("CACHE_URL: %v", err)
+	}
+	kv = &redisStore{client: client}
+	log.Printf("Keeping the result cache, rate limits and sessions in Redis at %s", client.host)
+}
+
+type memoryEntry struct {
+	value   string
+	expires time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	swept   time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: map[string]memoryEntry{}, swept: time.Now()}
+}
+
+func (m *memoryStore) get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", false, nil
+	}
+	return e.value, true, nil
+}
+
+func (m *memoryStore) set(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked()
+	m.entries[key] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryStore) del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryStore) incr(key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked()
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		e = memoryEntry{value: "0", expires: time.Now().Add(ttl)}
+	}
+	n, _ := strconv.ParseInt(e.value, 10, 64)
+	n++
+	e.value = strconv.FormatInt(n, 10)
+	m.entries[key] = e
+	return n, nil
+}
+
+// sweepLocked drops expired entries, at most once a minute
+func (m *memoryStore) sweepLocked() {
+	now := time.Now()
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+}
+
+type redisStore struct {
+	client *redisClient
+
+	mu   sync.Mutex
+	down bool
+}
+
+// checked logs the first error of an outage and the recovery
+func (s *redisStore) checked(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && !s.down {
+		log.Printf("Warning: Redis at %s unreachable; caching, rate limits and sessions are degraded: %v", s.client.host, err)
+	} else if err == nil && s.down {
+		log.Printf("Redis at %s reachable again", s.client.host)
+	}
+	s.down = err != nil
+	return err
+}
+
+func (s *redisStore) get(key string) (string, bool, error) {
+	v, err := redisString(s.client.do("GET", key))
+	if err == errRedisNil {
+		return "", false, s.checked(nil)
+	}
+	if err != nil {
+		return "", false, s.checked(err)
+	}
+	return v, true, s.checked(nil)
+}
+
+func (s *redisStore) set(key, value string, ttl time.Duration) error {
+	_, err := s.client.do("SET", key, value, "PX", ttl.Milliseconds())
+	return s.checked(err)
+}
+
+func (s *redisStore) del(key string) error {
+	_, err := s.client.do("DEL", key)
+	return s.checked(err)
+}
+
+func (s *redisStore) incr(key string, ttl time.Duration) (int64, error) {
+	n, err := redisInt(s.client.do("INCR", key))
+	if err == nil && n == 1 {
+		_, err = s.client.do("PEXPIRE", key, ttl.Milliseconds())
+	}
+	return n, s.checked(err)
+}
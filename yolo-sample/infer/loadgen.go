@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `webui loadgen` replays a directory of images against an instance to size
+// edge hardware before a rollout:
+//
+//	webui loadgen -url http://node:6767 -dir ./site-images -concurrency 4 -rps 8 -duration 2m
+//
+// Images (.jpg, .jpeg, .png; a YOLO dataset's images/ directory is used if
+// present) are sent in turn through /api/v1/infer/base64, so they take the
+// same job queue as uploads, and each request follows /events/jobs/{id}
+// until the job is done. Latency is from sending the image to its result.
+// At most -concurrency requests are in flight; -rps caps the rate at which
+// they start (0 sends as fast as they complete). The run ends after
+// -duration or -requests, whichever comes first, and prints throughput,
+// error rates by reason and latency percentiles; -json prints the report as
+// JSON instead. Requests answered 503 were turned away by a full queue,
+// which is the node's capacity showing.
+
+// LoadReport is the outcome of a load run
+type LoadReport struct {
+	URL         string             `json:"url"`
+	Images      int                `json:"images"`
+	Concurrency int                `json:"concurrency"`
+	TargetRPS   float64            `json:"target_rps,omitempty"`
+	DurationMS  int64              `json:"duration_ms"`
+	Requests    int                `json:"requests"`
+	Succeeded   int                `json:"succeeded"`
+	Failed      int                `json:"failed"`
+	ErrorRate   float64            `json:"error_rate"`
+	Throughput  float64            `json:"throughput_rps"` // succeeded per second
+	Errors      map[string]int     `json:"errors,omitempty"`
+	LatencyMS   map[string]float64 `json:"latency_ms,omitempty"` // p50, p90, p95, p99, max of successes
+	SubmitMS    map[string]float64 `json:"submit_ms,omitempty"`  // until the image was accepted
+}
+
+// loadSample is the outcome of one request
+type loadSample struct {
+	total, submit time.Duration
+	err           string // reason of a failure
+}
+
+// loadgenClient is shared by the workers; requests carry their own deadlines
+var loadgenClient = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+// runLoadgen implements `webui loadgen`, returning the exit code
+func runLoadgen(args []string) int {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	base := fs.String("url", "http://127.0.0.1:6767", "base URL of the instance")
+	dir := fs.String("dir", "", "directory of images to replay")
+	concurrency := fs.Int("concurrency", 4, "requests in flight at once")
+	rps := fs.Float64("rps", 0, "requests started per second (0: no limit)")
+	duration := fs.Duration("duration", time.Minute, "how long to run")
+	requests := fs.Int("requests", 0, "stop after this many requests (0: no limit)")
+	timeout := fs.Duration("timeout", time.Minute, "longest wait for one result")
+	model := fs.String("model", "", "model version to infer with (default: the active model)")
+	token := fs.String("token", "", "bearer token sent with every request, for an authenticating proxy")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" || *concurrency < 1 || *rps < 0 {
+		fmt.Fprintln(os.Stderr, "loadgen needs -dir, -concurrency of at least 1 and a non-negative -rps")
+		return 2
+	}
+	paths, err := listEvalImages(*dir)
+	if err == nil && len(paths) == 0 {
+		err = fmt.Errorf("no images found in %s", *dir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var bodies [][]byte
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		req := Base64IngestRequest{Image: base64.StdEncoding.EncodeToString(data), Filename: filepath.Base(p)}
+		req.Model = *model
+		body, _ := json.Marshal(req)
+		bodies = append(bodies, body)
+	}
+
+	lg := &loadgen{base: strings.TrimRight(*base, "/"), token: *token, timeout: *timeout}
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	// Each token starts one request; without a rate the workers take them as fast as they can
+	tokens := make(chan int)
+	go func() {
+		defer close(tokens)
+		var tick <-chan time.Time
+		if *rps > 0 {
+			t := time.NewTicker(time.Duration(float64(time.Second) / *rps))
+			defer t.Stop()
+			tick = t.C
+		}
+		for n := 0; *requests == 0 || n < *requests; n++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case tokens <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	started := time.Now()
+	var (
+		mu      sync.Mutex
+		samples []loadSample
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range tokens {
+				s := lg.send(bodies[n%len(bodies)])
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+			}
+		}()
+	}
+	if !*asJSON {
+		go func() {
+			for range time.Tick(5 * time.Second) {
+				mu.Lock()
+				done, failed := len(samples), 0
+				for _, s := range samples {
+					if s.err != "" {
+						failed++
+					}
+				}
+				mu.Unlock()
+				fmt.Fprintf(os.Stderr, "%s  %d requests, %d failed\n", time.Since(started).Round(time.Second), done, failed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rep := loadReport(samples, time.Since(started))
+	rep.URL, rep.Images, rep.Concurrency, rep.TargetRPS = lg.base, len(bodies), *concurrency, *rps
+	if *asJSON {
+		out, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printLoadReport(rep)
+	}
+	if rep.Succeeded == 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadgen sends images to one instance
+type loadgen struct {
+	base, token string
+	timeout     time.Duration
+}
+
+func (lg *loadgen) request(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, lg.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if lg.token != "" {
+		req.Header.Set("Authorization", "Bearer "+lg.token)
+	}
+	return loadgenClient.Do(req)
+}
+
+// send submits one image and follows its job until it is done
+func (lg *loadgen) send(body []byte) loadSample {
+	ctx, cancel := context.WithTimeout(context.Background(), lg.timeout)
+	defer cancel()
+	start := time.Now()
+	fail := func(reason string) loadSample {
+		if ctx.Err() != nil {
+			reason = "timeout"
+		}
+		return loadSample{total: time.Since(start), err: reason}
+	}
+
+	resp, err := lg.request(ctx, http.MethodPost, "/api/v1/infer/base64", body)
+	if err != nil {
+		return fail("connection: " + loadgenReason(err))
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fail(fmt.Sprintf("%d %s", resp.StatusCode, smoketestError(data)))
+	}
+	var job IngestJob
+	if err := json.Unmarshal(data, &job); err != nil || job.JobID == "" {
+		return fail("no job_id")
+	}
+	submit := time.Since(start)
+
+	resp, err = lg.request(ctx, http.MethodGet, "/events/jobs/"+job.JobID, nil)
+	if err != nil {
+		return fail("events: " + loadgenReason(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Sprintf("events %d", resp.StatusCode))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev JobEvent
+		if json.Unmarshal([]byte(line), &ev) != nil {
+			continue
+		}
+		switch ev.Stage {
+		case jobDone:
+			return loadSample{total: time.Since(start), submit: submit}
+		case jobFailed:
+			return fail("job failed: " + ev.Error)
+		}
+	}
+	return fail("events ended before the job was done")
+}
+
+// loadgenReason shortens a transport error to something worth grouping by
+func loadgenReason(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
+
+// loadReport summarizes the samples of a run that took elapsed
+func loadReport(samples []loadSample, elapsed time.Duration) LoadReport {
+	rep := LoadReport{DurationMS: elapsed.Milliseconds(), Requests: len(samples), Errors: map[string]int{}}
+	var total, submit []float64
+	for _, s := range samples {
+		if s.err != "" {
+			rep.Failed++
+			rep.Errors[s.err]++
+			continue
+		}
+		rep.Succeeded++
+		total = append(total, millis(s.total))
+		submit = append(submit, millis(s.submit))
+	}
+	if rep.Requests > 0 {
+		rep.ErrorRate = float64(rep.Failed) / float64(rep.Requests)
+	}
+	if elapsed > 0 {
+		rep.Throughput = float64(rep.Succeeded) / elapsed.Seconds()
+	}
+	rep.LatencyMS, rep.SubmitMS = loadPercentiles(total), loadPercentiles(submit)
+	return rep
+}
+
+// loadPercentiles returns the nearest-rank percentiles of values
+func loadPercentiles(values []float64) map[string]float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		i := int(p*float64(len(values))+0.999999) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+	return map[string]float64{
+		"p50": at(0.50), "p90": at(0.90), "p95": at(0.95), "p99": at(0.99), "max": values[len(values)-1],
+	}
+}
+
+func printLoadReport(rep LoadReport) {
+	fmt.Printf("%d requests in %s against %s (%d images, concurrency %d",
+		rep.Requests, (time.Duration(rep.DurationMS) * time.Millisecond).Round(time.Millisecond), rep.URL, rep.Images, rep.Concurrency)
+	if rep.TargetRPS > 0 {
+		fmt.Printf(", %g/s", rep.TargetRPS)
+	}
+	fmt.Printf(")\n")
+	fmt.Printf("succeeded   %d (%.1f/s)\n", rep.Succeeded, rep.Throughput)
+	fmt.Printf("failed      %d (%.1f%%)\n", rep.Failed, 100*rep.ErrorRate)
+	reasons := make([]string, 0, len(rep.Errors))
+	for r := range rep.Errors {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return rep.Errors[reasons[i]] > rep.Errors[reasons[j]] })
+	for _, r := range reasons {
+		fmt.Printf("  %6d  %s\n", rep.Errors[r], r)
+	}
+	for _, l := range []struct {
+		name string
+		ms   map[string]float64
+	}{{"latency", rep.LatencyMS}, {"submit", rep.SubmitMS}} {
+		if l.ms == nil {
+			continue
+		}
+		fmt.Printf("%-10s  p50 %.0fms  p90 %.0fms  p95 %.0fms  p99 %.0fms  max %.0fms\n",
+			l.name, l.ms["p50"], l.ms["p90"], l.ms["p95"], l.ms["p99"], l.ms["max"])
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log entries (see logs.go) at LOG_FORWARD_LEVEL (default info) and above can
+// also leave the node, through either or both of:
+//
+//	LOG_SYSLOG    "local" for the node's syslog daemon (/dev/log), or
+//	              udp://host:514, tcp://host:514 or tls://host:6514 for a remote
+//	              collector (RFC 5424; TLS verified against LOG_SYSLOG_CA, if
+//	              set, else the system roots)
+//	LOG_SHIP_URL  an HTTP endpoint that receives POSTs of
+//	              {"node": ..., "entries": [LogEntry, ...]}
+//
+// Syslog is sent as entries come, from a small in-memory queue; a remote
+// collector that is down loses what overflows it. The HTTP shipper spools
+// entries to STATE_DIR/log-spool instead and sends the spool every
+// LOG_SHIP_INTERVAL (default 30s) while the node is online, oldest first, so
+// logs written while the site was disconnected arrive once it is back. The
+// spool keeps at most LOG_SHIP_SPOOL_MAX bytes (default 64 MiB), dropping the
+// oldest entries past that.
+
+// logSpoolSegment bounds a spool file, and so a POST body
+const logSpoolSegment = 1 << 20
+
+var (
+	logForwarded = newCounterVec("yolo_log_forwarded_total",
+		"Log entries sent off the node by sink.", "sink")
+	logForwardDropped = newCounterVec("yolo_log_forward_dropped_total",
+		"Log entries a sink dropped: queue full, or the spool over LOG_SHIP_SPOOL_MAX.", "sink")
+)
+
+// logSink receives the entries to forward
+type logSink struct {
+	name    string
+	entries chan LogEntry
+}
+
+var logSinks atomic.Pointer[[]*logSink]
+
+// forwardLog queues e for every sink without blocking the caller
+func forwardLog(e LogEntry) {
+	sinks := logSinks.Load()
+	if sinks == nil {
+		return
+	}
+	for _, s := range *sinks {
+		select {
+		case s.entries <- e:
+		default:
+			logForwardDropped.inc(s.name)
+		}
+	}
+}
+
+// sinkWarnings limits a failing sink's own warnings, which are log entries
+// it would forward too
+var sinkWarnings = struct {
+	sync.Mutex
+	last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+func sinkWarn(sink, format string, args ...interface{}) {
+	sinkWarnings.Lock()
+	defer sinkWarnings.Unlock()
+	if time.Since(sinkWarnings.last[sink]) < time.Minute {
+		return
+	}
+	sinkWarnings.last[sink] = time.Now()
+	log.Printf("Warning: log sink %s: "+format, append([]interface{}{sink}, args...)...)
+}
+
+// startLogForwarding starts the configured sinks
+func startLogForwarding() {
+	cfg := config()
+	if err := validateLogForwarding(cfg); err != nil {
+		log.Printf("Warning: not forwarding logs: %v", err)
+		return
+	}
+	level := logLevelRank(cfg.LogForwardLevel)
+	var sinks []*logSink
+	if cfg.LogSyslog != "" {
+		s := &logSink{name: "syslog", entries: make(chan LogEntry, 1000)}
+		go runSyslogSink(cfg, s, level)
+		sinks = append(sinks, s)
+	}
+	if cfg.LogShipURL != "" {
+		s := &logSink{name: "http", entries: make(chan LogEntry, 1000)}
+		go runLogShipper(cfg, s, level)
+		sinks = append(sinks, s)
+	}
+	logSinks.Store(&sinks)
+	if cfg.LogSyslog != "" {
+		log.Printf("Forwarding logs to syslog %s", redactedURL(cfg.LogSyslog))
+	}
+	if cfg.LogShipURL != "" {
+		log.Printf("Shipping logs to %s", redactedURL(cfg.LogShipURL))
+	}
+}
+
+// validateLogForwarding checks the log sink settings
+func validateLogForwarding(cfg *Config) error {
+	if logLevelRank(cfg.LogForwardLevel) < 0 {
+		return fmt.Errorf("LOG_FORWARD_LEVEL must be debug, info, warning or error")
+	}
+	if cfg.LogSyslog != "" && cfg.LogSyslog != "local" {
+		u, err := url.Parse(cfg.LogSyslog)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls") || u.Host == "" {
+			return fmt.Errorf("LOG_SYSLOG must be local, or a udp://, tcp:// or tls:// address")
+		}
+	}
+	if cfg.LogSyslogCA != "" {
+		if _, err := syslogTLSConfig(cfg, "localhost"); err != nil {
+			return err
+		}
+	}
+	if cfg.LogShipURL != "" {
+		if u, err := url.Parse(cfg.LogShipURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("LOG_SHIP_URL must be an http:// or https:// URL")
+		}
+	}
+	return nil
+}
+
+func redactedURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
+
+// Syslog severities of the log levels
+var syslogSeverity = map[string]int{logDebug: 7, logInfo: 6, logWarning: 4, logError: 3}
+
+// syslogFacility is "daemon"
+const syslogFacility = 3
+
+// runSyslogSink writes entries to LOG_SYSLOG, reconnecting when the
+// connection fails
+func runSyslogSink(cfg *Config, s *logSink, level int) {
+	var conn net.Conn
+	var framed bool // octet-counted stream (RFC 6587) rather than datagrams
+	hostname, _ := os.Hostname()
+	tag := cfg.LogSyslogTag
+	for e := range s.entries {
+		if logLevelRank(e.Level) < level {
+			continue
+		}
+		if conn == nil {
+			var err error
+			if conn, framed, err = dialSyslog(cfg); err != nil {
+				logForwardDropped.inc(s.name)
+				sinkWarn(s.name, "%v", err)
+				continue
+			}
+		}
+		pri := syslogFacility*8 + syslogSeverity[e.Level]
+		msg := strings.ReplaceAll(e.Message, "\n", " ")
+		var line string
+		if cfg.LogSyslog == "local" {
+			// The local daemon expects the traditional RFC 3164 format
+			line = fmt.Sprintf("<%d>%s %s[%d]: [%s] %s", pri, e.Time.Format(time.Stamp), tag, os.Getpid(), e.Module, msg)
+		} else {
+			line = fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", pri, e.Time.UTC().Format(time.RFC3339Nano), hostname, tag, os.Getpid(), e.Module, msg)
+		}
+		if framed {
+			line = fmt.Sprintf("%d %s", len(line), line)
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := io.WriteString(conn, line); err != nil {
+			conn.Close()
+			conn = nil
+			logForwardDropped.inc(s.name)
+			sinkWarn(s.name, "%v", err)
+			continue
+		}
+		logForwarded.inc(s.name)
+	}
+}
+
+func dialSyslog(cfg *Config) (net.Conn, bool, error) {
+	if cfg.LogSyslog == "local" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			if conn, err := net.Dial("unixgram", path); err == nil {
+				return conn, false, nil
+			}
+		}
+		return nil, false, fmt.Errorf("no local syslog socket")
+	}
+	u, _ := url.Parse(cfg.LogSyslog)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch u.Scheme {
+	case "udp":
+		conn, err := dialer.Dial("udp", hostWithPort(u, "514"))
+		return conn, false, err
+	case "tcp":
+		conn, err := dialer.Dial("tcp", hostWithPort(u, "514"))
+		return conn, true, err
+	}
+	tlsConfig, err := syslogTLSConfig(cfg, u.Hostname())
+	if err != nil {
+		return nil, false, err
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostWithPort(u, "6514"), tlsConfig)
+	return conn, true, err
+}
+
+func syslogTLSConfig(cfg *Config, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: serverName}
+	if cfg.LogSyslogCA != "" {
+		pem, err := os.ReadFile(cfg.LogSyslogCA)
+		if err != nil {
+			return nil, fmt.Errorf("LOG_SYSLOG_CA: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("LOG_SYSLOG_CA: no certificates in %s", cfg.LogSyslogCA)
+		}
+	}
+	return tlsConfig, nil
+}
+
+var logShipClient = &http.Client{Timeout: 30 * time.Second}
+
+func logSpoolDir() string {
+	return filepath.Join(config().StateDir, "log-spool")
+}
+
+// runLogShipper appends entries to the spool and ships it while online
+func runLogShipper(cfg *Config, s *logSink, level int) {
+	dir := logSpoolDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		sinkWarn(s.name, "%v", err)
+		return
+	}
+	current := filepath.Join(dir, "current.jsonl")
+	var f *os.File
+	var w *bufio.Writer
+	var size int64
+	open := func() {
+		var err error
+		if f, err = os.OpenFile(current, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			sinkWarn(s.name, "%v", err)
+			return
+		}
+		fi, _ := f.Stat()
+		size = fi.Size()
+		w = bufio.NewWriter(f)
+	}
+	// seal closes the current file into a segment the shipper sends
+	seal := func() {
+		if f == nil {
+			return
+		}
+		w.Flush()
+		f.Close()
+		f = nil
+		if size > 0 {
+			os.Rename(current, filepath.Join(dir, fmt.Sprintf("%020d.jsonl", time.Now().UnixNano())))
+		}
+	}
+
+	ship := time.NewTicker(cfg.LogShipInterval)
+	flush := time.NewTicker(time.Second)
+	for {
+		select {
+		case e := <-s.entries:
+			if logLevelRank(e.Level) < level {
+				continue
+			}
+			if f == nil {
+				if open(); f == nil {
+					logForwardDropped.inc(s.name)
+					continue
+				}
+			}
+			line, _ := json.Marshal(e)
+			line = append(line, '\n')
+			w.Write(line)
+			size += int64(len(line))
+			if size >= logSpoolSegment {
+				seal()
+			}
+		case <-flush.C:
+			if f != nil {
+				w.Flush()
+			}
+		case <-ship.C:
+			seal()
+			trimLogSpool(dir, cfg.LogShipSpoolMax)
+			if getNodeStatus().NetworkStatus == "online" {
+				shipLogSpool(dir, cfg)
+			}
+		}
+	}
+}
+
+// logSpoolSegments lists the sealed segments, oldest first
+func logSpoolSegments(dir string) []string {
+	names, _ := filepath.Glob(filepath.Join(dir, "[0-9]*.jsonl"))
+	sort.Strings(names)
+	return names
+}
+
+// trimLogSpool drops the oldest segments beyond max bytes
+func trimLogSpool(dir string, max int64) {
+	segments := logSpoolSegments(dir)
+	var total int64
+	sizes := make([]int64, len(segments))
+	for i, path := range segments {
+		if fi, err := os.Stat(path); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > max && i < len(segments); i++ {
+		data, _ := os.ReadFile(segments[i])
+		logForwardDropped.add(float64(bytes.Count(data, []byte("\n"))), "http")
+		os.Remove(segments[i])
+		total -= sizes[i]
+	}
+}
+
+// shipLogSpool sends the sealed segments, stopping at the first failure so
+// they go out in order. It logs nothing on success, which would be shipped in
+// turn.
+func shipLogSpool(dir string, cfg *Config) {
+	node := getEnv("NODE_NAME", "unknown")
+	for _, path := range logSpoolSegments(dir) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entries []json.RawMessage
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			if json.Valid(line) {
+				entries = append(entries, line)
+			}
+		}
+		if len(entries) > 0 {
+			body, _ := json.Marshal(map[string]interface{}{"node": node, "entries": entries})
+			resp, err := logShipClient.Post(cfg.LogShipURL, "application/json", bytes.NewReader(body))
+			if err != nil {
+				sinkWarn("http", "%v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				sinkWarn("http", "%s returned %s", redactedURL(cfg.LogShipURL), resp.Status)
+				return
+			}
+			logForwarded.add(float64(len(entries)), "http")
+		}
+		os.Remove(path)
+	}
+}
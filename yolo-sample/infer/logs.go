@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The last LOG_BUFFER_LINES (default 2000) log entries are kept in memory,
+// each with a level and the module (source file) that logged it, along with
+// the inference process's stderr as module "inference". Technicians at the
+// site read them without the cluster's logging stack:
+//
+//	GET /api/v1/logs?level=warning&module=mqtt,sync&q=text&since=<seq>&limit=200
+//	GET /logs        a page that follows the log with the same filters
+//
+// level is the lowest level shown (debug, info, warning, error), since the
+// seq of the last entry already seen, for polling. The support bundle and the
+// admin page read the same buffer; LOG_VIEWER=false takes the API and page
+// off the main listener, where not everyone on the site network should read
+// logs.
+
+// Log levels, lowest first
+const (
+	logDebug   = "debug"
+	logInfo    = "info"
+	logWarning = "warning"
+	logError   = "error"
+)
+
+var logLevels = []string{logDebug, logInfo, logWarning, logError}
+
+// LogEntry is one kept log line
+type LogEntry struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module"`
+	Message string    `json:"message"`
+}
+
+// logRing keeps the most recent log entries
+type logRing struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+	seq     int64
+}
+
+var recentLogs = &logRing{}
+
+// Write keeps the lines the log package writes
+func (l *logRing) Write(p []byte) (int, error) {
+	module := logModule()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		// The standard flags prefix "2006/01/02 15:04:05 "
+		msg := line
+		if len(line) >= 20 {
+			if _, err := time.Parse("2006/01/02 15:04:05", line[:19]); err == nil {
+				msg = line[20:]
+			}
+		}
+		l.add(logLevel(msg), module, msg)
+	}
+	return len(p), nil
+}
+
+// add keeps an entry and hands it to the log sinks (see logforward.go)
+func (l *logRing) add(level, module, msg string) {
+	if msg == "" {
+		return
+	}
+	l.mu.Lock()
+	l.seq++
+	e := LogEntry{Seq: l.seq, Time: time.Now(), Level: level, Module: module, Message: msg}
+	if size := len(l.entries); size > 0 {
+		l.entries[l.next] = e
+		l.next = (l.next + 1) % size
+		l.full = l.full || l.next == 0
+	}
+	l.mu.Unlock()
+	forwardLog(e)
+}
+
+// list returns the kept entries, oldest first
+func (l *logRing) list() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []LogEntry
+	if l.full {
+		out = append(out, l.entries[l.next:]...)
+	}
+	return append(out, l.entries[:l.next]...)
+}
+
+// snapshot returns the kept entries as log lines
+func (l *logRing) snapshot() []byte {
+	var buf bytes.Buffer
+	for _, e := range l.list() {
+		buf.WriteString(e.line())
+	}
+	return buf.Bytes()
+}
+
+func (e LogEntry) line() string {
+	return e.Time.Format("2006/01/02 15:04:05") + " " + e.Message + "\n"
+}
+
+// logModule names the file of the first caller outside the log package
+func logModule() string {
+	var pcs [16]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		f, more := frames.Next()
+		if strings.HasPrefix(f.Function, "main.") && !strings.HasPrefix(f.Function, "main.(*logRing)") {
+			return strings.TrimSuffix(filepath.Base(f.File), ".go")
+		}
+		if !more {
+			return "main"
+		}
+	}
+}
+
+// logLevel tells the level of a message by the repo's prefixes
+func logLevel(msg string) string {
+	switch {
+	case strings.HasPrefix(msg, "DEBUG:"):
+		return logDebug
+	case strings.HasPrefix(msg, "Warning:"):
+		return logWarning
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "Fatal"), strings.HasPrefix(msg, "panic:"):
+		return logError
+	}
+	return logInfo
+}
+
+func logLevelRank(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// logFilter selects entries for the API
+type logFilter struct {
+	level   int
+	modules map[string]bool
+	query   string
+	since   int64
+	limit   int
+}
+
+func (f logFilter) match(e LogEntry) bool {
+	return e.Seq > f.since && logLevelRank(e.Level) >= f.level &&
+		(len(f.modules) == 0 || f.modules[e.Module]) &&
+		(f.query == "" || strings.Contains(strings.ToLower(e.Message), f.query))
+}
+
+// logsHandler serves GET /api/v1/logs
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	if !config().LogViewer {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	f := logFilter{modules: map[string]bool{}, query: strings.ToLower(q.Get("q")), limit: 200}
+	if level := q.Get("level"); level != "" {
+		if f.level = logLevelRank(level); f.level < 0 {
+			writeJSONError(w, http.StatusBadRequest, "level must be debug, info, warning or error")
+			return
+		}
+	}
+	for _, m := range splitList(q.Get("module")) {
+		f.modules[m] = true
+	}
+	if s := q.Get("since"); s != "" {
+		since, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "since must be an entry seq")
+			return
+		}
+		f.since = since
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > 5000 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and 5000")
+			return
+		}
+		f.limit = limit
+	}
+
+	all := recentLogs.list()
+	entries := []LogEntry{}
+	seen := map[string]bool{}
+	var last int64
+	for _, e := range all {
+		seen[e.Module] = true
+		last = e.Seq
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > f.limit {
+		entries = entries[len(entries)-f.limit:]
+	}
+	modules := make([]string, 0, len(seen))
+	for m := range seen {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries":  entries,
+		"last_seq": last,
+		"modules":  modules,
+	})
+}
+
+// logsPageHandler serves GET /logs, which polls the API
+func logsPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !config().LogViewer {
+		http.NotFound(w, r)
+		return
+	}
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Logs"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 1100px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .filters select, .filters input {
+            padding: 6px;
+            margin-right: 10px;
+        }
+        #log {
+            font-family: monospace;
+            font-size: 12px;
+            height: 60vh;
+            overflow: auto;
+            background: #222;
+            color: #ddd;
+            padding: 10px;
+            white-space: pre-wrap;
+        }
+        .debug { color: #888; }
+        .warning { color: #FF9800; }
+        .error { color: #ef5350; }
+        .module { color: #64b5f6; }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Logs"}}</h1>
+    <div class="panel">
+        <div class="filters">
+            <label>{{t "Level"}}
+                <select id="level">
+                    <option value="debug">debug</option>
+                    <option value="info" selected>info</option>
+                    <option value="warning">warning</option>
+                    <option value="error">error</option>
+                </select>
+            </label>
+            <label>{{t "Module"}}
+                <select id="module"><option value="">{{t "All"}}</option></select>
+            </label>
+            <input type="text" id="query" placeholder="{{t "Filter"}}">
+            <label><input type="checkbox" id="follow" checked> {{t "Follow"}}</label>
+        </div>
+        <div id="log"></div>
+    </div>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+    <script nonce="{{cspNonce}}">
+        const logEl = document.getElementById('log');
+        const moduleEl = document.getElementById('module');
+        let since = 0;
+
+        function params() {
+            const p = new URLSearchParams({level: document.getElementById('level').value, limit: '1000'});
+            if (moduleEl.value) p.set('module', moduleEl.value);
+            const q = document.getElementById('query').value;
+            if (q) p.set('q', q);
+            if (since) p.set('since', since);
+            return p;
+        }
+
+        async function poll() {
+            const resp = await fetch({{base}} + '/api/v1/logs?' + params());
+            if (!resp.ok) return;
+            const data = await resp.json();
+            const atBottom = logEl.scrollHeight - logEl.scrollTop - logEl.clientHeight < 20;
+            data.entries.forEach(e => {
+                const row = document.createElement('div');
+                row.className = e.level;
+                const mod = document.createElement('span');
+                mod.className = 'module';
+                mod.textContent = '[' + e.module + '] ';
+                row.append(new Date(e.time).toLocaleString() + ' ', mod, e.message);
+                logEl.appendChild(row);
+            });
+            while (logEl.childElementCount > 2000) logEl.firstChild.remove();
+            if (atBottom) logEl.scrollTop = logEl.scrollHeight;
+            since = data.last_seq;
+            const current = moduleEl.value;
+            moduleEl.length = 1;
+            data.modules.forEach(m => moduleEl.add(new Option(m, m, false, m === current)));
+        }
+
+        function reload() {
+            since = 0;
+            logEl.textContent = '';
+            poll();
+        }
+        ['level', 'module', 'query'].forEach(id => document.getElementById(id).addEventListener('change', reload));
+        setInterval(() => { if (document.getElementById('follow').checked) poll(); }, 3000);
+        poll();
+    </script>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("logs").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, nil)
+}
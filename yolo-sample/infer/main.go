@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"html/template"
 	"io"
 	"log"
@@ -10,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Detection struct {
@@ -17,6 +17,9 @@ type Detection struct {
 	ClassName  string  `json:"class_name"`
 	Confidence float64 `json:"confidence"`
 	BBox       BBox    `json:"bbox"`
+	// DisplayName and Category come from CLASS_MAP
+	DisplayName string `json:"display_name,omitempty"`
+	Category    string `json:"category,omitempty"`
 }
 
 type BBox struct {
@@ -27,15 +30,56 @@ type BBox struct {
 }
 
 type InferenceResult struct {
+	ID         string      `json:"id,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
 	Image      string      `json:"image"`
 	Detections []Detection `json:"detections"`
 	Count      int         `json:"count"`
-	Error      string      `json:"error,omitempty"`
+	Model      string      `json:"model,omitempty"` // model version that produced the result
+	Canary     bool        `json:"canary,omitempty"`
+	Source     string      `json:"source,omitempty"` // "upload", "batch", ...
+	// StoredImage is the object name of the result's image in the object
+	// store, or its file name in STATE_DIR/images for older results
+	StoredImage string `json:"stored_image,omitempty"`
+	Redacted    bool   `json:"redacted,omitempty"`
+	// ImageWidth and ImageHeight are the pixel size of the image inference saw
+	ImageWidth  int `json:"image_width,omitempty"`
+	ImageHeight int `json:"image_height,omitempty"`
+	// CapturedAt and Location come from the image's EXIF metadata, when present
+	CapturedAt *time.Time `json:"captured_at,omitempty"`
+	Location   *GeoPoint  `json:"location,omitempty"`
+	// ImageRetention is the retention mode applied to this result's image
+	ImageRetention string     `json:"image_retention,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	Feedback       []Feedback `json:"feedback,omitempty"`
+	// Barcodes are the codes read from the image (see barcode.go)
+	Barcodes []Barcode `json:"barcodes,omitempty"`
+	// Attributes carries enrichment added by inference hooks
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// Sequence orders results node-wide; ClockUnsynchronized marks results
+	// produced while CreatedAt could not be trusted (see clock.go)
+	Sequence            uint64 `json:"sequence,omitempty"`
+	ClockUnsynchronized bool   `json:"clock_unsynchronized,omitempty"`
+	// Timing is where the time to this result went (see timing.go)
+	Timing *ResultTiming `json:"timing,omitempty"`
+
+	// classes are the model's class names by ID, when the backend reports them
+	classes map[string]string
+	// speed is the model's own timing, when the backend reports it
+	speed *modelSpeed
+	// unfiltered are the backend's detections before options, zones, the
+	// reference and hooks dropped any; privacy redaction works from them.
+	// inferred is false when there are none to trust: inference failed or
+	// the image was rejected.
+	unfiltered []Detection
+	inferred   bool
 }
 
 type SystemStatus struct {
-	NetworkStatus  string // "online", "offline", or "unknown"
+	NetworkStatus   string    // "online", "offline", or "unknown"
+	StaleSince      time.Time // the network status could not be refreshed since; zero while current
 	TrainingEnabled bool
+	Backend         string // inference backend: "up", "down", or "recovering"
 }
 
 type PageData struct {
@@ -43,15 +87,29 @@ type PageData struct {
 }
 
 type ResultPageData struct {
-	Status SystemStatus
-	Result InferenceResult
+	Status    SystemStatus
+	Result    InferenceResult
+	Permalink string // absolute URL of the stored result, empty if it was not stored
 }
 
 var uploadDir = "/tmp/uploads"
 
-// getNodeStatus queries the node's network-status label using kubectl
+// getNodeStatus reports the node's network status, from the provider
+// NETWORK_STATUS_PROVIDER selects (see netstatus.go), and the backend health
 func getNodeStatus() SystemStatus {
-	log.Println("DEBUG: getNodeStatus() called")
+	status, staleSince := networkStatus()
+	return SystemStatus{
+		NetworkStatus:   status,
+		StaleSince:      staleSince,
+		TrainingEnabled: status == "online" && !lowPower(lowPowerTraining),
+		Backend:         backendBreaker.status(),
+	}
+}
+
+// kubernetesNetworkStatus queries the node's network-status label using
+// kubectl; an error means the API server could not be asked
+func kubernetesNetworkStatus() (string, error) {
+	log.Println("DEBUG: kubernetesNetworkStatus() called")
 	nodeName := os.Getenv("NODE_NAME")
 	labelKey := os.Getenv("NODE_LABEL_KEY")
 
@@ -59,7 +117,7 @@ func getNodeStatus() SystemStatus {
 
 	if nodeName == "" || labelKey == "" {
 		log.Println("Warning: NODE_NAME or NODE_LABEL_KEY not set, defaulting to unknown status")
-		return SystemStatus{NetworkStatus: "unknown", TrainingEnabled: false}
+		return "unknown", nil
 	}
 
 	// Use kubectl to get the node label
@@ -69,14 +127,14 @@ func getNodeStatus() SystemStatus {
 	jsonPath := "jsonpath={.metadata.labels." + escapedLabelKey + "}"
 	log.Printf("DEBUG: Running kubectl command: kubectl get node %s -o %s", nodeName, jsonPath)
 
-	cmd := exec.Command("kubectl", "get", "node", nodeName, "-o", jsonPath)
+	cmd := exec.Command("kubectl", "get", "node", nodeName, "-o", jsonPath, "--request-timeout=10s")
 	output, err := cmd.Output()
 	if err != nil {
 		log.Printf("Warning: Failed to get node status: %v", err)
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			log.Printf("DEBUG: stderr: %s", string(exitErr.Stderr))
 		}
-		return SystemStatus{NetworkStatus: "unknown", TrainingEnabled: false}
+		return "", err
 	}
 
 	status := strings.TrimSpace(string(output))
@@ -87,25 +145,199 @@ func getNodeStatus() SystemStatus {
 		status = "unknown"
 	}
 
-	trainingEnabled := status == "online"
-
-	log.Printf("DEBUG: Final status - NetworkStatus: %s, TrainingEnabled: %t", status, trainingEnabled)
+	log.Printf("DEBUG: Final status - NetworkStatus: %s", status)
+	return status, nil
+}
 
-	return SystemStatus{
-		NetworkStatus:  status,
-		TrainingEnabled: trainingEnabled,
+// systemHandler serves GET /api/v1/system: node status and the health of the
+// inference backend and its supervised process
+func systemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
 	}
+	status := getNodeStatus()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"network_status":             status.NetworkStatus,
+		"network_status_stale_since": staleSinceJSON(status.StaleSince),
+		"training_enabled":           status.TrainingEnabled,
+		"backend":                    status.Backend,
+		"model":                      activeModelVersion(),
+		"worker":                     worker.view(),
+		"clock":                      clockView(),
+		"version":                    buildVersion,
+		"update":                     updateView(),
+		"heartbeat":                  heartbeatView(),
+		"spools":                     spoolCounts(),
+		"maintenance":                maintenanceView(),
+		"thermal":                    thermalView(),
+		"power":                      powerView(),
+	})
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		os.Exit(runInstall(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "smoketest" {
+		os.Exit(runSmoketest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		os.Exit(runLoadgen(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-signature" {
+		os.Exit(runVerifySignature(os.Args[2:]))
+	}
+	if err := parseServerFlags(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if err := validateListeners(config()); err != nil {
+		log.Fatal(err)
+	}
 	// Create upload directory
 	os.MkdirAll(uploadDir, 0755)
+	loadStatusCache()
+	// Admin page sessions live in the key-value store
+	startKVStore()
+	startAdmin()
+	startLogForwarding()
+	recordBuildInfo()
+	clearUpdateFlag()
+	loadCatalogs()
+	startClockCheck()
+	startThermalCheck()
+	startPowerCheck()
+	startHeartbeat()
+	loadClassMap()
+	loadTaxonomy()
+	loadAlertRules()
+	startAlerting()
+	startHeatmaps()
+	startTimeseries()
+	startReports()
+	startStatsArchive()
+	startDeliveryRetries()
+	startEventWebhook()
+	startMQTT()
+	startHomeAssistant()
+	loadActiveModelVersion()
+	startStatusCache()
+	loadEncryptionKey()
+	results = newResultStore(filepath.Join(config().StateDir, "results"))
+	startCluster()
+	jobs = startJobQueue(config().UploadWorkers, config().UploadQueueSize)
+	uploads.startExpiry()
+	worker.supervise()
+	backendBreaker.startProbes()
+	loadEvaluations()
+	loadReplays()
+	migrateRecordingImages()
+	drift.loadReference()
+	registerWasmPlugins()
+	if err := enableHooks(os.Getenv("HOOKS")); err != nil {
+		log.Fatalf("Invalid HOOKS: %v", err)
+	}
+	loadMaintenance()
+	sources.load(filepath.Join(config().StateDir, "sources.json"))
+	loadDeclaredSources()
+	registerBuiltinTasks()
+	tasks.start()
+	startConfigWatch()
+	startSecretRefresh()
+	if config().ModelOCIRefs != "" {
+		go tasks.get("model-pull").execute()
+	}
 
 	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/upload", uploadHandler)
+	http.HandleFunc("/upload", idempotent(uploadHandler))
+	http.HandleFunc("/images/", imageHandler)
+	http.HandleFunc("/results/", resultPageHandler)
+	http.HandleFunc("/theme", themeHandler)
+	http.HandleFunc("/api/v1/models", modelsHandler)
+	http.HandleFunc("/api/v1/models/", modelsHandler)
+	http.HandleFunc("/api/v1/blobs/", peerBlobHandler)
+	http.HandleFunc("/api/v1/canary", canaryHandler)
+	http.HandleFunc("/api/v1/canary/", canaryHandler)
+	http.HandleFunc("/api/v1/results", resultsAPIHandler)
+	http.HandleFunc("/api/v1/results/", resultsAPIHandler)
+	http.HandleFunc("/api/v1/evaluations", idempotent(evaluationsHandler))
+	http.HandleFunc("/api/v1/evaluations/", evaluationsHandler)
+	http.HandleFunc("/api/v1/golden", goldenHandler)
+	http.HandleFunc("/api/v1/golden/", goldenHandler)
+	http.HandleFunc("/api/v1/recordings", recordingsHandler)
+	http.HandleFunc("/api/v1/storage", storageHandler)
+	http.HandleFunc("/api/v1/replays", idempotent(replaysHandler))
+	http.HandleFunc("/api/v1/replays/", replaysHandler)
+	http.HandleFunc("/api/v1/drift", driftHandler)
+	http.HandleFunc("/api/v1/drift/", driftHandler)
+	http.HandleFunc("/api/v1/schedules", schedulesHandler)
+	http.HandleFunc("/api/v1/schedules/", idempotent(schedulesHandler))
+	http.HandleFunc("/api/v1/hooks", hooksHandler)
+	http.HandleFunc("/api/v1/geo", geoHandler)
+	http.HandleFunc("/map", mapHandler)
+	http.HandleFunc("/api/v1/sources", sourcesHandler)
+	http.HandleFunc("/api/v1/sources/", sourcesHandler)
+	http.HandleFunc("/sources", sourcesPageHandler)
+	http.HandleFunc("/api/v1/onvif/", onvifHandler)
+	http.HandleFunc("/events/jobs/", jobEventsHandler)
+	http.HandleFunc("/api/versions", apiVersionsHandler)
+	http.HandleFunc("/sdk/", sdkHandler)
+	http.HandleFunc("/api/v1/infer/", idempotent(inferIngestHandler))
+	http.HandleFunc("/api/v1/infer/batch", idempotent(inferBatchHandler))
+	http.HandleFunc(grpcServicePath, idempotent(grpcWebHandler))
+	http.HandleFunc("/api/v1/uploads", idempotent(uploadsHandler))
+	http.HandleFunc("/api/v1/uploads/", uploadsHandler)
+	http.HandleFunc("/api/v1/training", idempotent(trainingHandler))
+	http.HandleFunc("/api/v1/cluster", clusterHandler)
+	http.HandleFunc("/api/v1/callbacks/training", trainingCallbackHandler)
+	http.HandleFunc("/offline", offlinePageHandler)
+	http.HandleFunc("/api/v1/last-known", lastKnownHandler)
+	http.HandleFunc("/manifest.webmanifest", manifestHandler)
+	http.HandleFunc("/sw.js", serviceWorkerHandler)
+	http.HandleFunc("/icons/", iconHandler)
+	http.HandleFunc("/api/v1/system", systemHandler)
+	http.HandleFunc("/api/v1/version", versionHandler)
+	http.HandleFunc("/api/v1/logs", logsHandler)
+	http.HandleFunc("/logs", logsPageHandler)
+	http.HandleFunc("/api/v1/stats", statsHandler)
+	http.HandleFunc("/api/v1/timeseries", timeseriesHandler)
+	http.HandleFunc("/dashboard", dashboardHandler)
+	http.HandleFunc("/api/v1/grafana", grafanaHandler)
+	http.HandleFunc("/api/v1/grafana/", grafanaHandler)
+	http.HandleFunc("/api/v1/reports", reportsHandler)
+	http.HandleFunc("/api/v1/reports/", reportsHandler)
+	http.HandleFunc("/api/v1/alerts", alertsHandler)
+	http.HandleFunc("/api/v1/flags", flagsHandler)
+	http.HandleFunc("/api/v1/events", eventsHandler)
+	http.HandleFunc("/api/v1/deadletters", deadLettersHandler)
+	http.HandleFunc("/api/v1/deadletters/", idempotent(deadLettersHandler))
+	http.HandleFunc("/api/events", frigateEventsHandler)
+	http.HandleFunc("/api/events/", frigateEventsHandler)
+	http.HandleFunc("/api/v1/config", configHandler)
+	http.HandleFunc("/api/v1/config/", configHandler)
+	http.HandleFunc("/api/v1/desired-state", desiredStateHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 
-	log.Println("Starting YOLO Inference Web UI on :6767")
-	log.Fatal(http.ListenAndServe(":6767", nil))
+	handler := withForwarded(withPush(withSecurityHeaders(withCompression(withLanguage(withCORS(withRecovery(withAPIVersion(withMaintenance(withRateLimit(withSession(withCSRF(withoutDebug(http.DefaultServeMux)))))))))))))
+	if err := startUnixListener(handler); err != nil {
+		log.Fatal(err)
+	}
+	listener, err := listenMain(config().ListenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	startMDNS()
+	if listener == nil {
+		log.Println("Not listening on TCP (LISTEN_ADDR=none)")
+		select {}
+	}
+	log.Printf("Starting YOLO Inference Web UI on %s (%s)", listener.Addr(), httpProtocols(config()))
+	log.Fatal(serveHTTP(listener, handler))
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
@@ -113,9 +345,9 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 	tmpl := `
 <!DOCTYPE html>
-<html>
+<html lang="{{lang}}">
 <head>
-    <title>YOLO Inference</title>
+    <title>{{brandTitle}}</title>
     <style>
         body {
             font-family: Arial, sans-serif;
@@ -170,6 +402,22 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             border-radius: 4px;
             border-left: 4px solid #d32f2f;
         }
+        .maintenance {
+            color: #8a5a00;
+            background-color: #fff8e1;
+            padding: 15px;
+            margin-bottom: 20px;
+            border-radius: 4px;
+            border-left: 4px solid #FF9800;
+        }
+        .throttled {
+            color: #b23c17;
+            background-color: #fbe9e7;
+            padding: 15px;
+            margin-bottom: 20px;
+            border-radius: 4px;
+            border-left: 4px solid #FF5722;
+        }
         .status-bar {
             background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
             padding: 20px 30px;
@@ -227,39 +475,54 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             color: rgba(255,255,255,0.95);
             font-weight: 500;
         }
-        .spinner-overlay {
-            display: none;
-            position: fixed;
-            top: 0;
-            left: 0;
-            width: 100%;
-            height: 100%;
-            background: rgba(0,0,0,0.7);
-            z-index: 9999;
-            justify-content: center;
-            align-items: center;
-            flex-direction: column;
+        .drop-zone {
+            border: 2px dashed #bbb;
+            border-radius: 8px;
+            padding: 20px;
+            text-align: center;
+            color: #666;
+            margin-bottom: 15px;
         }
-        .spinner-overlay.active {
+        .drop-zone.dragover {
+            border-color: #4CAF50;
+            background-color: #e8f5e9;
+        }
+        .upload-row {
+            margin: 10px 0;
+            font-size: 14px;
+        }
+        .upload-row .name {
             display: flex;
+            justify-content: space-between;
+            gap: 10px;
         }
-        .spinner {
-            border: 6px solid #f3f3f3;
-            border-top: 6px solid #667eea;
-            border-radius: 50%;
-            width: 60px;
-            height: 60px;
-            animation: spin 1s linear infinite;
+        .upload-row .state {
+            color: #666;
         }
-        @keyframes spin {
-            0% { transform: rotate(0deg); }
-            100% { transform: rotate(360deg); }
+        .upload-row.failed .state {
+            color: #d32f2f;
         }
-        .spinner-text {
-            color: white;
-            margin-top: 20px;
-            font-size: 18px;
-            font-weight: bold;
+        .url-form {
+            display: flex;
+            gap: 10px;
+            margin-top: 15px;
+        }
+        .url-form input {
+            flex: 1;
+            padding: 10px;
+        }
+        .progress {
+            height: 8px;
+            background: #eee;
+            border-radius: 4px;
+            overflow: hidden;
+            margin-top: 4px;
+        }
+        .progress div {
+            height: 100%;
+            width: 0;
+            background: #4CAF50;
+            transition: width 0.2s;
         }
         .manual-train-btn {
             background-color: #9e9e9e;
@@ -292,54 +555,290 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             background-color: #764ba2;
         }
     </style>
+    {{themeHead}}
+    {{pwaHead}}
 </head>
 <body>
-    <h1>YOLO Object Detection</h1>
+    {{themeHeader}}
+    <h1>{{t "YOLO Object Detection"}}</h1>
+    {{with maintenance}}<div class="maintenance"><strong>{{t "Under maintenance"}}</strong> {{.Reason}}</div>{{end}}
+    {{with onBattery}}<div class="throttled"><strong>{{t "On battery"}}</strong>{{if .ChargePercent}}, {{t "%.0f%% charge" .Charge}}{{end}}{{if .RuntimeSeconds}}, {{t "about %.0f min left" .RuntimeMinutes}}{{end}}. {{t "Non-critical work is paused until mains power returns."}}</div>{{end}}
+    {{with throttled}}<div class="throttled"><strong>{{t "Thermal throttled"}}</strong> {{.Reason}}. {{t "Camera frame rates and inference concurrency are reduced."}}</div>{{end}}
     <div class="status-bar">
         <div class="status-item">
             <span class="status-indicator {{.Status.NetworkStatus}}"></span>
-            <span class="status-label">Network: {{.Status.NetworkStatus}}</span>
+            <span class="status-label">{{t "Network: %s" (t .Status.NetworkStatus)}}{{if not .Status.StaleSince.IsZero}} ({{t "stale since %s" (.Status.StaleSince.Format "2006-01-02 15:04")}}){{end}}</span>
+        </div>
+        <div class="status-item">
+            <span class="training-status">{{if .Status.TrainingEnabled}}{{t "Training: %s" (t "Enabled")}}{{else}}{{t "Training: %s" (t "Disabled")}}{{end}}</span>
         </div>
         <div class="status-item">
-            <span class="training-status">Trading: {{if .Status.TrainingEnabled}}Enabled{{else}}Disabled{{end}}</span>
+            <span class="status-indicator {{if eq .Status.Backend "up"}}online{{else if eq .Status.Backend "down"}}offline{{else}}unknown{{end}}"></span>
+            <span class="status-label">{{t "Inference backend: %s" (t .Status.Backend)}}</span>
         </div>
     </div>
     <div class="upload-form">
-        <h2>Upload an Image</h2>
-        <form action="/upload" method="post" enctype="multipart/form-data" id="uploadForm">
-            <input type="file" name="image" accept="image/*" required>
-            <br>
-            <button type="submit">Run Inference</button>
+        <h2>{{t "Upload an Image"}}</h2>
+        <form action="{{base}}/upload" method="post" enctype="multipart/form-data" id="uploadForm">
+            {{csrfField}}
+            <div class="drop-zone" id="dropZone">
+                {{t "Drop images here, or choose them:"}}<br>
+                <input type="file" name="image" accept="image/*" multiple required id="fileInput">
+            </div>
+            <button type="submit">{{t "Run Inference"}}</button>
+        </form>
+        <form id="urlForm" class="url-form">
+            <input type="url" id="imageURL" placeholder="{{t "…or paste an image, or an image URL to fetch"}}" required>
+            <button type="submit">{{t "Fetch"}}</button>
         </form>
+        <div id="uploadList"></div>
+        <p><a href="{{base}}/map">{{t "View geotagged detections on a map"}}</a> · <a href="{{base}}/sources">{{t "Camera sources"}}</a> · <a href="{{base}}/offline">{{t "Last known results"}}</a> · <a href="{{base}}/dashboard">{{t "Dashboard"}}</a>{{if logViewer}} · <a href="{{base}}/logs">{{t "Logs"}}</a>{{end}}</p>
         <div style="margin-top: 20px; display: flex; gap: 10px; flex-wrap: wrap;">
-            <button class="manual-train-btn {{if .Status.TrainingEnabled}}enabled{{end}}" {{if not .Status.TrainingEnabled}}disabled{{end}} title="Trigger manual training job" id="trainBtn">
-                Trigger Training
+            <button class="manual-train-btn {{if .Status.TrainingEnabled}}enabled{{end}}" {{if not .Status.TrainingEnabled}}disabled{{end}} title="{{t "Trigger manual training job"}}" id="trainBtn">
+                {{t "Trigger Training"}}
             </button>
-            <button class="action-btn" title="Pull latest model from gateway" id="pullBtn">
-                Pull New Model
+            <button class="action-btn" title="{{t "Pull latest model from gateway"}}" id="pullBtn">
+                {{t "Pull New Model"}}
             </button>
-            <button class="action-btn" title="Send trained weights to gateway" id="sendBtn">
-                Send Weights
+            <button class="action-btn" title="{{t "Send trained weights to gateway"}}" id="sendBtn">
+                {{t "Send Weights"}}
             </button>
         </div>
     </div>
 
-    <!-- Spinner overlay -->
-    <div class="spinner-overlay" id="spinnerOverlay">
-        <div class="spinner"></div>
-        <div class="spinner-text">Running inference...</div>
-    </div>
+    <script nonce="{{cspNonce}}">
+        // Images are sent with the resumable upload API (uploads.go) in chunks,
+        // so a flaky connection only costs the current chunk. Unfinished
+        // uploads are remembered by file, and choosing the same file again
+        // after a reload picks up where it stopped.
+        const CHUNK_SIZE = 1 << 20;
+        const PARALLEL = 2;
+        const text = {
+            waiting: {{t "Waiting"}},
+            uploading: {{t "Uploading %s%"}},
+            retrying: {{t "Connection lost, retrying..."}},
+            fetching: {{t "Fetching..."}},
+            queued: {{t "Waiting for an inference worker"}},
+            inferring: {{t "Running inference..."}},
+            done: {{t "View result"}},
+            failed: {{t "Failed: %s"}}
+        };
+
+        function uploadKey(file) {
+            return 'upload:' + file.name + ':' + file.size + ':' + file.lastModified;
+        }
+
+        function sleep(ms) {
+            return new Promise(function(resolve) { setTimeout(resolve, ms); });
+        }
+
+        // Requests carry the session's CSRF token (see sessions.go)
+        const csrfToken = {{csrfToken}};
+        const base = {{base}};
+
+        async function api(method, url, options) {
+            const opts = Object.assign({method: method}, options || {});
+            opts.headers = Object.assign({'X-CSRF-Token': csrfToken}, opts.headers || {});
+            const resp = await fetch(base + url, opts);
+            const body = await resp.json().catch(function() { return {}; });
+            return {status: resp.status, ok: resp.ok, body: body};
+        }
+
+        function addRow(file) {
+            const row = document.createElement('div');
+            row.className = 'upload-row';
+            const name = document.createElement('div');
+            name.className = 'name';
+            const label = document.createElement('span');
+            label.textContent = file.name;
+            const state = document.createElement('span');
+            state.className = 'state';
+            state.textContent = text.waiting;
+            name.appendChild(label);
+            name.appendChild(state);
+            const bar = document.createElement('div');
+            bar.className = 'progress';
+            bar.appendChild(document.createElement('div'));
+            row.appendChild(name);
+            row.appendChild(bar);
+            document.getElementById('uploadList').appendChild(row);
+            return {
+                progress: function(fraction) {
+                    bar.firstChild.style.width = (fraction * 100).toFixed(1) + '%';
+                    state.textContent = text.uploading.replace('%s', Math.floor(fraction * 100));
+                },
+                state: function(msg) { state.textContent = msg; },
+                fail: function(msg) {
+                    row.classList.add('failed');
+                    state.textContent = text.failed.replace('%s', msg);
+                },
+                done: function(url) {
+                    state.textContent = '';
+                    const link = document.createElement('a');
+                    link.href = url;
+                    link.textContent = text.done;
+                    state.appendChild(link);
+                }
+            };
+        }
+
+        // start resumes a remembered upload of file or creates a new one
+        async function start(file) {
+            const saved = localStorage.getItem(uploadKey(file));
+            if (saved) {
+                const existing = await api('GET', '/api/v2/uploads/' + saved).catch(function() { return {ok: false}; });
+                if (existing.ok) {
+                    return existing.body;
+                }
+            }
+            const created = await api('POST', '/api/v2/uploads', {
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({filename: file.name, size: file.size})
+            });
+            if (!created.ok) {
+                throw new Error(created.body.error || created.status);
+            }
+            localStorage.setItem(uploadKey(file), created.body.id);
+            return created.body;
+        }
+
+        async function send(file, row) {
+            let upload = await start(file);
+            let failures = 0;
+            while (!upload.complete || !upload.result_url) {
+                row.progress(upload.offset / upload.size);
+                try {
+                    const resp = await api('PATCH', '/api/v2/uploads/' + upload.id, {
+                        headers: {'Upload-Offset': String(upload.offset)},
+                        body: file.slice(upload.offset, upload.offset + CHUNK_SIZE)
+                    });
+                    if (resp.status === 409) {
+                        upload = resp.body.upload;
+                        await sleep(1000);
+                        continue;
+                    }
+                    if (resp.status === 400 || resp.status === 404) {
+                        throw Object.assign(new Error(resp.body.error || resp.status), {fatal: true});
+                    }
+                    if (!resp.ok) {
+                        throw new Error(resp.status); // retried below
+                    }
+                    upload = resp.body;
+                    failures = 0;
+                } catch (err) {
+                    if (err.fatal) {
+                        localStorage.removeItem(uploadKey(file));
+                        throw err;
+                    }
+                    failures++;
+                    row.state(text.retrying);
+                    await sleep(Math.min(30000, 1000 * Math.pow(2, failures)));
+                    const current = await api('GET', '/api/v2/uploads/' + upload.id).catch(function() { return {ok: false}; });
+                    if (current.ok) {
+                        upload = current.body;
+                    }
+                }
+            }
+            localStorage.removeItem(uploadKey(file));
+            row.progress(1);
+            return upload;
+        }
+
+        function follow(upload, row) {
+            row.state(text.queued);
+            const events = new EventSource(upload.events_url);
+            events.addEventListener('stage', function(e) {
+                const ev = JSON.parse(e.data);
+                if (ev.stage === 'done') {
+                    events.close();
+                    row.done(upload.result_url);
+                } else if (ev.stage === 'failed') {
+                    events.close();
+                    row.fail(ev.error);
+                } else if (text[ev.stage]) {
+                    row.state(text[ev.stage]);
+                }
+            });
+        }
 
-    <script>
-        document.getElementById('uploadForm').addEventListener('submit', function() {
-            document.getElementById('spinnerOverlay').classList.add('active');
+        async function uploadAll(files) {
+            const pendingFiles = files.map(function(file) { return {file: file, row: addRow(file)}; });
+            async function worker() {
+                let next;
+                while ((next = pendingFiles.shift())) {
+                    try {
+                        follow(await send(next.file, next.row), next.row);
+                    } catch (err) {
+                        next.row.fail(err.message || String(err));
+                    }
+                }
+            }
+            const workers = [];
+            for (let i = 0; i < PARALLEL; i++) {
+                workers.push(worker());
+            }
+            await Promise.all(workers);
+        }
+
+        const form = document.getElementById('uploadForm');
+        const input = document.getElementById('fileInput');
+        const zone = document.getElementById('dropZone');
+        form.addEventListener('submit', function(e) {
+            e.preventDefault();
+            uploadAll(Array.from(input.files));
+            form.reset();
+        });
+        ['dragenter', 'dragover'].forEach(function(type) {
+            zone.addEventListener(type, function(e) {
+                e.preventDefault();
+                zone.classList.add('dragover');
+            });
+        });
+        ['dragleave', 'drop'].forEach(function(type) {
+            zone.addEventListener(type, function(e) {
+                e.preventDefault();
+                zone.classList.remove('dragover');
+            });
+        });
+        // Pasted images (screenshots, copied photos) upload like dropped files
+        document.addEventListener('paste', function(e) {
+            const images = Array.from(e.clipboardData.files).filter(function(f) { return f.type.startsWith('image/'); });
+            if (images.length > 0) {
+                e.preventDefault();
+                uploadAll(images);
+            }
+        });
+        document.getElementById('urlForm').addEventListener('submit', async function(e) {
+            e.preventDefault();
+            const field = document.getElementById('imageURL');
+            const row = addRow({name: field.value});
+            row.state(text.fetching);
+            try {
+                const resp = await api('POST', '/api/v2/infer/url', {
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({url: field.value})
+                });
+                if (!resp.ok) {
+                    throw new Error(resp.body.error || resp.status);
+                }
+                field.value = '';
+                follow(resp.body, row);
+            } catch (err) {
+                row.fail(err.message);
+            }
+        });
+        zone.addEventListener('drop', function(e) {
+            const images = Array.from(e.dataTransfer.files).filter(function(f) { return f.type.startsWith('image/'); });
+            if (images.length > 0) {
+                uploadAll(images);
+            }
         });
 
         // Pull New Model button
         document.getElementById('pullBtn').addEventListener('click', function() {
             const btn = this;
             const originalText = btn.textContent;
-            btn.textContent = 'Model Received!';
+            btn.textContent = {{t "Model Received!"}};
             btn.style.backgroundColor = '#4CAF50';
             setTimeout(function() {
                 btn.textContent = originalText;
@@ -351,7 +850,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
         document.getElementById('sendBtn').addEventListener('click', function() {
             const btn = this;
             const originalText = btn.textContent;
-            btn.textContent = 'Weights Sent!';
+            btn.textContent = {{t "Weights Sent!"}};
             btn.style.backgroundColor = '#4CAF50';
             setTimeout(function() {
                 btn.textContent = originalText;
@@ -360,12 +859,20 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
         });
 
         // Trigger Training button
-        document.getElementById('trainBtn').addEventListener('click', function() {
+        // The idempotency key makes a retried click start at most one job
+        let trainKey = null;
+        document.getElementById('trainBtn').addEventListener('click', async function() {
             if (this.classList.contains('enabled')) {
                 const btn = this;
                 const originalText = btn.textContent;
-                btn.textContent = 'Training Started!';
-                btn.style.backgroundColor = '#4CAF50';
+                trainKey = trainKey || Date.now() + '-' + Math.random().toString(36).slice(2);
+                const resp = await api('POST', '/api/v2/training', {headers: {'Idempotency-Key': trainKey}})
+                    .catch(function() { return {ok: false, body: {}}; });
+                if (resp.ok || resp.status < 500) {
+                    trainKey = null;
+                }
+                btn.textContent = resp.ok ? {{t "Training Started!"}} : (resp.body.error || {{t "Error"}});
+                btn.style.backgroundColor = resp.ok ? '#4CAF50' : '#d32f2f';
                 setTimeout(function() {
                     btn.textContent = originalText;
                     btn.style.backgroundColor = '#2196F3';
@@ -373,10 +880,11 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
             }
         });
     </script>
+    {{buildFooter}}
 </body>
 </html>
 `
-	t, err := template.New("home").Parse(tmpl)
+	t, err := template.New("home").Funcs(pageFuncs(r)).Parse(tmpl)
 	if err != nil {
 		log.Printf("Template parse error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -388,6 +896,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -396,67 +905,203 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse multipart form
 	err := r.ParseMultipartForm(10 << 20) // 10 MB max
 	if err != nil {
-		renderError(w, "Failed to parse form: "+err.Error())
+		renderError(w, r, "Failed to parse form: "+err.Error())
 		return
 	}
 
 	// Get uploaded file
 	file, handler, err := r.FormFile("image")
 	if err != nil {
-		renderError(w, "Failed to get image: "+err.Error())
+		renderError(w, r, "Failed to get image: "+err.Error())
 		return
 	}
 	defer file.Close()
 
-	// Save file to disk
-	filePath := filepath.Join(uploadDir, handler.Filename)
+	opts, err := requestOptions(r)
+	if err != nil {
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		renderError(w, r, err.Error())
+		return
+	}
+
+	// Save file to disk, in a directory of its own so queued uploads with the
+	// same name cannot overwrite each other
+	id := newID()
+	jobDir := filepath.Join(uploadDir, id)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		renderError(w, r, "Failed to save image: "+err.Error())
+		return
+	}
+	filePath := filepath.Join(jobDir, filepath.Base(handler.Filename))
 	dst, err := os.Create(filePath)
 	if err != nil {
-		renderError(w, "Failed to save image: "+err.Error())
+		os.RemoveAll(jobDir)
+		renderError(w, r, "Failed to save image: "+err.Error())
 		return
 	}
-	defer dst.Close()
 
 	_, err = io.Copy(dst, file)
+	dst.Close()
 	if err != nil {
-		renderError(w, "Failed to write image: "+err.Error())
+		os.RemoveAll(jobDir)
+		renderError(w, r, "Failed to write image: "+err.Error())
 		return
 	}
 
-	// Run inference
-	result := runInference(filePath)
-
-	// Get current system status
-	status := getNodeStatus()
-
-	// Render results
-	renderResults(w, status, result)
+	// Queue inference; the result page follows the job until the result is stored
+	if err := jobs.submit(id, filePath, "upload", opts, received); err != nil {
+		os.RemoveAll(jobDir)
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderError(w, r, err.Error())
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusAccepted, map[string]string{
+			"job_id":     id,
+			"events_url": basePath(r) + "/events/jobs/" + id,
+			"result_url": basePath(r) + "/results/" + id,
+		})
+		return
+	}
+	redirectTo(w, r, "/results/"+id, http.StatusSeeOther)
 }
 
-func runInference(imagePath string) InferenceResult {
-	cmd := exec.Command("python", "/app/infer.py", imagePath)
-	cmd.Env = os.Environ()
+func processImage(filePath, source string, owned bool) InferenceResult {
+	return processImageAs(newID(), filePath, source, owned, InferenceOptions{}, ResultTiming{})
+}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return InferenceResult{Error: "Inference failed: " + err.Error() + "\n" + string(output)}
+// processImageAs runs the inference pipeline on an image, with the request's
+// overrides of the inference settings, and stores the result under id.
+// timing carries the upload and queue stages, if the image went through them.
+func processImageAs(id, filePath, source string, owned bool, req InferenceOptions, timing ResultTiming) InferenceResult {
+	stageStart := time.Now()
+	// Track image statistics for drift detection and judge the image's quality
+	var problems []qualityProblem
+	if stats, err := computeImageStats(filePath); err == nil {
+		drift.observe(stats)
+		if config().QualityGate != "off" {
+			problems = checkQuality(stats)
+		}
+	} else {
+		log.Printf("Warning: drift stats unavailable for %s: %v", filepath.Base(filePath), err)
 	}
 
+	// Run inference, splitting traffic to the canary model if one is running.
+	// Camera sources and requests may pin a model, which bypasses the canary split.
+	opts := resolveOptions(source, req)
+	version, isCanary := canary.pickModel()
+	camera, fromCamera := sources.get(source)
+	pinned := opts.Model != ""
+	if pinned {
+		version, isCanary = opts.Model, false
+	}
+	start := time.Now()
 	var result InferenceResult
-	err = json.Unmarshal(output, &result)
-	if err != nil {
-		return InferenceResult{Error: "Failed to parse results: " + err.Error()}
+	rejected := config().QualityGate == "reject" && len(problems) > 0
+	if err := runBeforeHooks(&HookImage{Path: filePath, Source: source}); err != nil {
+		result = InferenceResult{Image: filepath.Base(filePath), Error: err.Error()}
+		timing.PreprocessMS = millis(time.Since(stageStart))
+		stageStart = time.Now()
+	} else if rejected {
+		// A rejected image is not the model's failure and does not count against it
+		result = InferenceResult{Image: filepath.Base(filePath)}
+		applyQuality(&result, source, problems)
+		timing.PreprocessMS = millis(time.Since(stageStart))
+		stageStart = time.Now()
+	} else {
+		timing.PreprocessMS = millis(time.Since(stageStart))
+		callStart := time.Now()
+		result = runInferenceCached(filePath, version, opts.params())
+		timing.split(time.Since(callStart), result.speed)
+		stageStart = time.Now()
+		applyQuality(&result, source, problems)
+		if result.Error == "" {
+			result.unfiltered = append([]Detection(nil), result.Detections...)
+			result.inferred = true
+			applyOptions(&result, opts)
+			recordTraffic(id, filePath, source, version, opts, result)
+			if fromCamera {
+				filterZones(&result, filePath, camera.Zones)
+				filterReference(&result, camera, version, opts)
+			}
+			decodeBarcodes(&result, filePath)
+			runAfterHooks(&result)
+		}
+	}
+	elapsed := time.Since(start)
+	if !pinned && !rejected {
+		canary.record(version, elapsed, result.Error != "")
+	}
+	result.Model = version
+	result.Canary = isCanary
+	result.Source = source
+	if !rejected {
+		observeInference(result, elapsed.Seconds())
+	}
+
+	// Read EXIF before the image store moves or re-encodes the file
+	if meta, err := readImageMetadata(filePath); err == nil {
+		result.CapturedAt, result.Location = meta.CapturedAt, meta.Location
+	} else {
+		log.Printf("Warning: unreadable EXIF in %s: %v", filepath.Base(filePath), err)
+	}
+
+	if size, err := imageSize(filePath); err == nil {
+		result.ImageWidth, result.ImageHeight = size.X, size.Y
 	}
 
+	result.ID = id
+	if fromCamera && config().QualityGate != "off" {
+		trackQuality(source, id, problems)
+	}
+	if err := storeResultImage(&result, filePath, owned); err != nil {
+		log.Printf("Warning: failed to store image for result %s: %v", result.ID, err)
+	}
+	timing.PostprocessMS += millis(time.Since(stageStart))
+	timing.total()
+	result.Timing = &timing
+	if err := results.save(&result); err != nil {
+		log.Printf("Warning: failed to store result: %v", err)
+	} else {
+		bus.publish(eventResultCreated, result)
+	}
 	return result
 }
 
-func renderError(w http.ResponseWriter, errorMsg string) {
+// runLocalInference runs an image through the inference process. failed
+// reports a backend failure (the process is down, crashed, timed out or
+// printed unparseable output), as opposed to an error it reported for this image.
+func runLocalInference(imagePath, version string, params backendParams) (result InferenceResult, failed bool) {
+	if err := checkModelSignature(version, "inference"); err != nil {
+		return InferenceResult{Error: err.Error()}, false
+	}
+	if err := injectInferenceFault(); err != nil {
+		return InferenceResult{Error: "Inference failed: " + err.Error()}, true
+	}
+	result, err := worker.infer(imagePath, modelPath(version), params)
+	if err != nil {
+		return InferenceResult{Error: "Inference failed: " + err.Error()}, true
+	}
+	if result.classes != nil {
+		reportedClasses.Store(version, result.classes)
+	}
+	return result, false
+}
+
+func renderError(w http.ResponseWriter, r *http.Request, errorMsg string) {
 	tmpl := `
 <!DOCTYPE html>
-<html>
+<html lang="{{lang}}">
 <head>
-    <title>Error - YOLO Inference</title>
+    <title>{{t "Error"}} - {{brandTitle}}</title>
     <style>
         body {
             font-family: Arial, sans-serif;
@@ -478,15 +1123,19 @@ func renderError(w http.ResponseWriter, errorMsg string) {
             text-decoration: none;
         }
     </style>
+    {{themeHead}}
+    {{pwaHead}}
 </head>
 <body>
-    <h1>Error</h1>
-    <div class="error">{{.}}</div>
-    <a href="/">← Back to Upload</a>
+    {{themeHeader}}
+    <h1>{{t "Error"}}</h1>
+    <div class="error">{{t .}}</div>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+    {{buildFooter}}
 </body>
 </html>
 `
-	t, err := template.New("error").Parse(tmpl)
+	t, err := template.New("error").Funcs(pageFuncs(r)).Parse(tmpl)
 	if err != nil {
 		log.Printf("Template parse error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -495,17 +1144,19 @@ func renderError(w http.ResponseWriter, errorMsg string) {
 	t.Execute(w, errorMsg)
 }
 
-func renderResults(w http.ResponseWriter, status SystemStatus, result InferenceResult) {
-	// Convert confidence to percentage (0-100 range) for display
+func renderResults(w http.ResponseWriter, r *http.Request, status SystemStatus, result InferenceResult) {
+	// Convert confidence to percentage (0-100 range) for display, on a copy so the
+	// stored result keeps the original 0-1 values
+	result.Detections = append([]Detection(nil), result.Detections...)
 	for i := range result.Detections {
 		result.Detections[i].Confidence = result.Detections[i].Confidence * 100
 	}
 
 	tmpl := `
 <!DOCTYPE html>
-<html>
+<html lang="{{lang}}">
 <head>
-    <title>Results - YOLO Inference</title>
+    <title>{{t "Results"}} - {{brandTitle}}</title>
     <style>
         body {
             font-family: Arial, sans-serif;
@@ -537,6 +1188,42 @@ func renderResults(w http.ResponseWriter, status SystemStatus, result InferenceR
             border-radius: 4px;
             border-left: 4px solid #d32f2f;
         }
+        .result-figure {
+            position: relative;
+            display: inline-block;
+            max-width: 100%;
+            margin-bottom: 10px;
+        }
+        .result-image {
+            display: block;
+            max-width: 100%;
+            border-radius: 4px;
+        }
+        .annotations {
+            position: absolute;
+            left: 0;
+            top: 0;
+            width: 100%;
+            height: 100%;
+            pointer-events: none;
+        }
+        .class-swatch {
+            display: inline-block;
+            width: 10px;
+            height: 10px;
+            border-radius: 2px;
+            margin-right: 6px;
+        }
+        .category {
+            font-size: 12px;
+            color: #666;
+            margin-left: 6px;
+        }
+        .redacted-note {
+            font-size: 13px;
+            color: #666;
+            margin-bottom: 15px;
+        }
         .summary {
             font-size: 18px;
             margin-bottom: 20px;
@@ -565,6 +1252,22 @@ func renderResults(w http.ResponseWriter, status SystemStatus, result InferenceR
             color: #666;
             font-size: 14px;
         }
+        .feedback {
+            margin-top: 8px;
+            font-size: 13px;
+            color: #666;
+        }
+        .feedback-btn {
+            background-color: white;
+            border: 1px solid #ccc;
+            border-radius: 4px;
+            padding: 4px 10px;
+            cursor: pointer;
+            font-size: 13px;
+        }
+        .feedback-btn:hover {
+            background-color: #eee;
+        }
         .status-bar {
             background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
             padding: 20px 30px;
@@ -686,48 +1389,205 @@ func renderResults(w http.ResponseWriter, status SystemStatus, result InferenceR
         .action-btn:hover {
             background-color: #764ba2;
         }
+        .timing {
+            margin-top: 15px;
+            font-size: 14px;
+            color: #555;
+        }
+        .timing summary {
+            cursor: pointer;
+        }
+        .timing table {
+            margin-top: 8px;
+            border-collapse: collapse;
+        }
+        .timing td {
+            padding: 2px 16px 2px 0;
+        }
+        .timing td:last-child {
+            text-align: right;
+            font-variant-numeric: tabular-nums;
+        }
+        .timing .total td {
+            border-top: 1px solid #eee;
+            font-weight: bold;
+        }
+        .share {
+            margin-top: 20px;
+            padding-top: 15px;
+            border-top: 1px solid #eee;
+            font-size: 14px;
+        }
+        .share input {
+            width: 70%;
+            padding: 6px;
+        }
+        .share-qr {
+            display: none;
+            margin-top: 10px;
+        }
     </style>
+    {{themeHead}}
+    {{pwaHead}}
 </head>
 <body>
-    <h1>Detection Results</h1>
+    {{themeHeader}}
+    <h1>{{t "Detection Results"}}</h1>
     <div class="status-bar">
         <div class="status-item">
             <span class="status-indicator {{.Status.NetworkStatus}}"></span>
-            <span class="status-label">Network: {{.Status.NetworkStatus}}</span>
+            <span class="status-label">{{t "Network: %s" (t .Status.NetworkStatus)}}{{if not .Status.StaleSince.IsZero}} ({{t "stale since %s" (.Status.StaleSince.Format "2006-01-02 15:04")}}){{end}}</span>
+        </div>
+        <div class="status-item">
+            <span class="training-status">{{if .Status.TrainingEnabled}}{{t "Training: %s" (t "Enabled")}}{{else}}{{t "Training: %s" (t "Disabled")}}{{end}}</span>
         </div>
         <div class="status-item">
-            <span class="training-status">Trading: {{if .Status.TrainingEnabled}}Enabled{{else}}Disabled{{end}}</span>
+            <span class="status-indicator {{if eq .Status.Backend "up"}}online{{else if eq .Status.Backend "down"}}offline{{else}}unknown{{end}}"></span>
+            <span class="status-label">{{t "Inference backend: %s" (t .Status.Backend)}}</span>
         </div>
     </div>
-    <div class="results">
+    <div class="results" data-result-id="{{.Result.ID}}">
         {{if .Result.Error}}
-            <div class="error">{{.Result.Error}}</div>
+            <div class="error">{{t .Result.Error}}</div>
         {{else}}
+            {{if .Result.StoredImage}}
+            <div class="result-figure">
+                <a href="{{base}}/images/{{.Result.ID}}"><img class="result-image" id="resultImage" src="{{base}}/images/{{.Result.ID}}?size=medium" alt="{{.Result.Image}}" data-width="{{.Result.ImageWidth}}" data-height="{{.Result.ImageHeight}}"></a>
+                {{if and (ne .Result.ImageRetention "thumbnail") (feature "annotation-overlay")}}<svg class="annotations" id="annotations" preserveAspectRatio="none"></svg>{{end}}
+            </div>
+            {{if .Result.Redacted}}<div class="redacted-note">{{t "Sensitive regions have been redacted."}}</div>{{end}}
+            {{end}}
             <div class="summary">
-                <strong>Image:</strong> {{.Result.Image}}<br>
-                <strong>Detections Found:</strong> {{.Result.Count}}
+                <strong>{{t "Image:"}}</strong> {{.Result.Image}}<br>
+                <strong>{{t "Detections Found:"}}</strong> {{.Result.Count}}<br>
+                <strong>{{t "Model:"}}</strong> {{.Result.Model}}{{if .Result.Canary}} {{t "(canary)"}}{{end}}
+                {{range .Result.Barcodes}}<br><strong>{{t "Barcode:"}}</strong> <code>{{.Text}}</code> ({{.Format}}{{if .Class}}, {{.Class}}{{end}}){{end}}
             </div>
             {{if gt .Result.Count 0}}
-                {{range .Result.Detections}}
-                <div class="detection">
-                    <div class="class-name">{{.ClassName}}</div>
-                    <div class="confidence">Confidence: {{printf "%.1f" .Confidence}}%</div>
+                {{range $i, $d := .Result.Detections}}
+                <div class="detection" data-index="{{$i}}" data-label="{{or .DisplayName .ClassName}}" data-color="{{classColor .ClassName}}"
+                     data-bbox="{{.BBox.X1}},{{.BBox.Y1}},{{.BBox.X2}},{{.BBox.Y2}}" style="border-left-color: {{classColor .ClassName}};">
+                    <div class="class-name"><span class="class-swatch" style="background-color: {{classColor .ClassName}};"></span>{{or .DisplayName .ClassName}}{{if .Category}}<span class="category">{{.Category}}</span>{{end}}</div>
+                    <div class="confidence">{{t "Confidence: %.1f%%" .Confidence}}</div>
                     <div style="font-size: 12px; color: #999; margin-top: 5px;">
-                        Class ID: {{.ClassID}} |
-                        BBox: ({{printf "%.0f" .BBox.X1}}, {{printf "%.0f" .BBox.Y1}}) to ({{printf "%.0f" .BBox.X2}}, {{printf "%.0f" .BBox.Y2}})
+                        {{t "Class ID: %d" .ClassID}} |
+                        {{t "BBox: (%.0f, %.0f) to (%.0f, %.0f)" .BBox.X1 .BBox.Y1 .BBox.X2 .BBox.Y2}}
+                    </div>
+                    <div class="feedback">
+                        <button class="feedback-btn" data-verdict="correct" title="{{t "Detection is correct"}}">{{t "Correct"}}</button>
+                        <button class="feedback-btn" data-verdict="incorrect" title="{{t "Detection is wrong"}}">{{t "Wrong"}}</button>
                     </div>
                 </div>
                 {{end}}
             {{else}}
-                <p>No objects detected in the image.</p>
+                <p>{{t "No objects detected in the image."}}</p>
             {{end}}
         {{end}}
+        {{with .Result.Timing}}
+        <details class="timing">
+            <summary>{{t "Timing: %.0f ms" .TotalMS}}</summary>
+            <table>
+                {{if .UploadMS}}<tr><td>{{t "Upload"}}</td><td>{{printf "%.1f" .UploadMS}} ms</td></tr>{{end}}
+                {{if .QueueWaitMS}}<tr><td>{{t "Queue wait"}}</td><td>{{printf "%.1f" .QueueWaitMS}} ms</td></tr>{{end}}
+                <tr><td>{{t "Preprocess"}}</td><td>{{printf "%.1f" .PreprocessMS}} ms</td></tr>
+                <tr><td>{{t "Inference"}}</td><td>{{printf "%.1f" .InferenceMS}} ms</td></tr>
+                <tr><td>{{t "Postprocess"}}</td><td>{{printf "%.1f" .PostprocessMS}} ms</td></tr>
+                <tr class="total"><td>{{t "Total"}}</td><td>{{printf "%.1f" .TotalMS}} ms</td></tr>
+            </table>
+        </details>
+        {{end}}
+        {{if .Permalink}}
+        <div class="share">
+            <strong>{{t "Share:"}}</strong>
+            <input type="text" id="permalink" value="{{.Permalink}}" readonly>
+            <button class="action-btn" id="copyLinkBtn">{{t "Copy link"}}</button>
+            <button class="action-btn" id="qrBtn">{{t "QR code"}}</button>
+            <div class="share-qr" id="shareQR"><img src="{{base}}/results/{{.Result.ID}}/qr.svg" alt="{{t "QR code for %s" .Permalink}}" width="200" height="200"></div>
+        </div>
+        {{end}}
     </div>
-    <a href="/">← Upload Another Image</a>
+    <a href="{{base}}/">{{t "← Upload Another Image"}}</a>
+
+    <script nonce="{{cspNonce}}">
+        // Draw the detections' boxes over the image, in the original image's
+        // pixel coordinates: the page shows a downscaled copy
+        const annotations = document.getElementById('annotations');
+        const resultImage = document.getElementById('resultImage');
+        function drawAnnotations() {
+            const w = Number(resultImage.dataset.width) || resultImage.naturalWidth;
+            const h = Number(resultImage.dataset.height) || resultImage.naturalHeight;
+            if (!w || !h) return;
+            const ns = 'http://www.w3.org/2000/svg';
+            annotations.setAttribute('viewBox', '0 0 ' + w + ' ' + h);
+            const stroke = Math.max(2, w / 300), fontSize = Math.max(12, w / 40);
+            document.querySelectorAll('.detection').forEach(function(el) {
+                const b = el.dataset.bbox.split(',').map(Number);
+                const rect = document.createElementNS(ns, 'rect');
+                rect.setAttribute('x', b[0]);
+                rect.setAttribute('y', b[1]);
+                rect.setAttribute('width', b[2] - b[0]);
+                rect.setAttribute('height', b[3] - b[1]);
+                rect.setAttribute('fill', 'none');
+                rect.setAttribute('stroke', el.dataset.color);
+                rect.setAttribute('stroke-width', stroke);
+                const label = document.createElementNS(ns, 'text');
+                label.setAttribute('x', b[0] + stroke);
+                label.setAttribute('y', Math.max(fontSize, b[1] - stroke));
+                label.setAttribute('fill', el.dataset.color);
+                label.setAttribute('font-size', fontSize);
+                label.setAttribute('font-family', 'Arial, sans-serif');
+                label.textContent = el.dataset.label;
+                annotations.appendChild(rect);
+                annotations.appendChild(label);
+            });
+        }
+        if (annotations && resultImage) {
+            if (resultImage.complete) drawAnnotations(); else resultImage.addEventListener('load', drawAnnotations);
+        }
+
+        const copyBtn = document.getElementById('copyLinkBtn');
+        if (copyBtn) {
+            copyBtn.addEventListener('click', function() {
+                const input = document.getElementById('permalink');
+                const done = function() { copyBtn.textContent = {{t "Copied!"}}; };
+                if (navigator.clipboard && window.isSecureContext) {
+                    navigator.clipboard.writeText(input.value).then(done);
+                } else {
+                    // Clipboard API needs HTTPS; fall back for plain-HTTP node access
+                    input.select();
+                    document.execCommand('copy');
+                    done();
+                }
+            });
+            document.getElementById('qrBtn').addEventListener('click', function() {
+                const qr = document.getElementById('shareQR');
+                qr.style.display = qr.style.display === 'block' ? 'none' : 'block';
+            });
+        }
+
+        // Detection feedback feeds the per-class accuracy metrics
+        document.querySelectorAll('.feedback-btn').forEach(function(btn) {
+            btn.addEventListener('click', function() {
+                const resultId = document.querySelector('.results').dataset.resultId;
+                const detection = parseInt(btn.closest('.detection').dataset.index, 10);
+                fetch({{base}} + '/api/v2/results/' + resultId + '/feedback', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json', 'X-CSRF-Token': {{csrfToken}}},
+                    body: JSON.stringify({detection: detection, verdict: btn.dataset.verdict})
+                }).then(function(resp) {
+                    if (resp.ok) {
+                        btn.parentElement.textContent = btn.dataset.verdict === 'correct' ?
+                            {{t "Thanks! Marked correct."}} : {{t "Thanks! Marked incorrect."}};
+                    }
+                });
+            });
+        });
+    </script>
+    {{buildFooter}}
 </body>
 </html>
 `
-	t, err := template.New("results").Parse(tmpl)
+	t, err := template.New("results").Funcs(pageFuncs(r)).Parse(tmpl)
 	if err != nil {
 		log.Printf("Template parse error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -738,6 +1598,9 @@ func renderResults(w http.ResponseWriter, status SystemStatus, result InferenceR
 		Status: status,
 		Result: result,
 	}
+	if _, stored := results.get(result.ID); stored {
+		data.Permalink = permalink(r, result.ID)
+	}
 
 	err = t.Execute(w, data)
 	if err != nil {
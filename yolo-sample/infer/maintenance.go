@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maintenance mode takes the node out of service for on-site work such as a
+// model swap without stopping it. It is switched on the admin listener, from
+// the /admin/ page or with
+//
+//	POST /admin/maintenance  {"enabled": "true", "reason": "swapping the model"}
+//
+// While it is on /readyz answers 503, so Kubernetes stops routing to the pod
+// (/healthz, the liveness probe, stays 200); new inference requests (uploads,
+// ingest, gRPC-Web, evaluations) get 503 with Retry-After, as a page for
+// browsers; camera source workers and batch inference pause; everything else,
+// including the admin and diagnostic endpoints and queued jobs, carries on.
+// The state is kept in STATE_DIR/maintenance.json, so a restart mid-swap
+// stays in maintenance.
+
+// MaintenanceView is the maintenance state and the payload of node.maintenance
+type MaintenanceView struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+var maintenance = struct {
+	sync.Mutex
+	view MaintenanceView
+}{}
+
+// maintenanceRetryAfter is the Retry-After of refused requests, in seconds
+const maintenanceRetryAfter = "60"
+
+func maintenancePath() string {
+	return filepath.Join(config().StateDir, "maintenance.json")
+}
+
+// loadMaintenance restores the maintenance state; call before starting
+// source workers
+func loadMaintenance() {
+	data, err := os.ReadFile(maintenancePath())
+	if err != nil {
+		return
+	}
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	if err := json.Unmarshal(data, &maintenance.view); err != nil {
+		log.Printf("Warning: ignoring unreadable %s: %v", maintenancePath(), err)
+		return
+	}
+	if maintenance.view.Enabled {
+		log.Printf("Node is in maintenance: %s", maintenance.view.Reason)
+	}
+}
+
+func maintenanceOn() bool {
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	return maintenance.view.Enabled
+}
+
+func maintenanceView() MaintenanceView {
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	return maintenance.view
+}
+
+// setMaintenance switches maintenance mode, pausing or resuming the sources
+func setMaintenance(enabled bool, reason string) (MaintenanceView, error) {
+	maintenance.Lock()
+	if maintenance.view.Enabled == enabled && (!enabled || maintenance.view.Reason == reason) {
+		v := maintenance.view
+		maintenance.Unlock()
+		return v, nil
+	}
+	v := MaintenanceView{Enabled: enabled}
+	if enabled {
+		now := time.Now().UTC()
+		if maintenance.view.Enabled {
+			now = *maintenance.view.Since
+		}
+		v.Reason, v.Since = reason, &now
+	}
+	data, _ := json.Marshal(v)
+	if err := writeFileAtomic(maintenancePath(), data); err != nil {
+		maintenance.Unlock()
+		return MaintenanceView{}, fmt.Errorf("saving the maintenance state: %v", err)
+	}
+	wasEnabled := maintenance.view.Enabled
+	maintenance.view = v
+	maintenance.Unlock()
+
+	if enabled {
+		log.Printf("Entering maintenance: %s", reason)
+	} else {
+		log.Printf("Leaving maintenance")
+	}
+	if wasEnabled != enabled {
+		sources.restartAll()
+	}
+	bus.publish(eventMaintenance, v)
+	return v, nil
+}
+
+// adminMaintenance switches maintenance mode from the admin page
+func adminMaintenance(ctx context.Context, args map[string]string) (interface{}, error) {
+	switch args["enabled"] {
+	case "true":
+		reason := strings.TrimSpace(args["reason"])
+		if reason == "" {
+			reason = "maintenance"
+		}
+		return setMaintenance(true, reason)
+	case "false":
+		return setMaintenance(false, "")
+	case "":
+		return maintenanceView(), nil
+	}
+	return nil, fmt.Errorf("enabled must be true or false")
+}
+
+// maintenanceRefuses reports whether r is new inference work
+func maintenanceRefuses(r *http.Request) bool {
+	p := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return false
+	case p == "/upload", strings.HasPrefix(p, "/api/v1/infer/"), p == "/api/v1/evaluations":
+		return true
+	case p == "/api/v1/uploads", strings.HasPrefix(p, "/api/v1/uploads/"):
+		return r.Method != http.MethodDelete
+	}
+	return false
+}
+
+// withMaintenance refuses new inference work during maintenance; gRPC-Web
+// calls are refused by the handler, in gRPC terms
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceOn() || !maintenanceRefuses(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := maintenanceView()
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"error":       translate(responseLanguage(w), "Node is in maintenance"),
+				"maintenance": v,
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderMaintenance(w, r, v)
+	})
+}
+
+func renderMaintenance(w http.ResponseWriter, r *http.Request, v MaintenanceView) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Under maintenance"}} - {{brandTitle}}</title>
+    <meta http-equiv="refresh" content="60;url=/">
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+        }
+        .notice {
+            color: #8a5a00;
+            background-color: #fff8e1;
+            padding: 20px;
+            border-radius: 4px;
+            border-left: 4px solid #FF9800;
+        }
+        a {
+            display: inline-block;
+            margin-top: 20px;
+            color: #1976d2;
+            text-decoration: none;
+        }
+    </style>
+    {{themeHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Under maintenance"}}</h1>
+    <div class="notice">
+        <p>{{t "This node is being serviced and is not accepting images right now. Please try again in a few minutes."}}</p>
+        {{if .Reason}}<p><small>{{.Reason}}</small></p>{{end}}
+    </div>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+</body>
+</html>
+`
+	t, err := template.New("maintenance").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		return
+	}
+	t.Execute(w, v)
+}
+
+// healthzHandler serves GET /healthz, the liveness probe: the process serves
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler serves GET /readyz, the readiness probe: 503 in maintenance
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if maintenanceOn() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With MDNS=true the node advertises itself on the local network by multicast
+// DNS, so kiosks and mobile apps at the site find it without configuration:
+//
+//	dns-sd -B _yolo-infer._tcp              # or avahi-browse -r _yolo-infer._tcp
+//	warehouse-3._yolo-infer._tcp.local  ->  warehouse-3.local:6767
+//	  txtvers=1 model=production version=1.4.0 api=/api/v1 path=/
+//
+// The instance is MDNS_NAME (default NODE_NAME, else the hostname) and its
+// host name <instance>.local resolves to the IPv4 addresses of MDNS_INTERFACE
+// (default: the interface multicast is routed through) or of every interface.
+// The model TXT record follows the active model; changes are announced. Only
+// IPv4 is served, names are not probed for conflicts (keep them unique per
+// site), and nothing is advertised with LISTEN_ADDR=none.
+
+const (
+	mdnsService  = "_yolo-infer._tcp.local."
+	mdnsServices = "_services._dns-sd._udp.local."
+	mdnsTTL      = 120
+)
+
+// DNS record types and classes
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+	dnsFlush    = 0x8000 // cache-flush bit of unique records
+	dnsUnicast  = 0x8000 // QU bit of questions
+	dnsFlagResp = 0x8400 // response, authoritative
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	flush bool
+	data  []byte
+}
+
+type mdnsResponder struct {
+	conn     *net.UDPConn
+	ifi      *net.Interface
+	instance string // fully qualified
+	host     string // fully qualified
+	port     int
+
+	mu  sync.Mutex
+	txt []string
+}
+
+// startMDNS starts the responder when MDNS is enabled
+func startMDNS() {
+	cfg := config()
+	if !cfg.MDNS {
+		return
+	}
+	if cfg.ListenAddr == listenNone {
+		log.Printf("Warning: MDNS needs a TCP listener; not advertising with LISTEN_ADDR=none")
+		return
+	}
+	_, portStr, err := net.SplitHostPort(cfg.ListenAddr)
+	port, _ := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		log.Printf("Warning: MDNS: cannot tell the port of LISTEN_ADDR %s", cfg.ListenAddr)
+		return
+	}
+	var ifi *net.Interface
+	if cfg.MDNSInterface != "" {
+		if ifi, err = net.InterfaceByName(cfg.MDNSInterface); err != nil {
+			log.Printf("Warning: MDNS_INTERFACE: %v", err)
+			return
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, mdnsGroup)
+	if err != nil {
+		log.Printf("Warning: MDNS: %v", err)
+		return
+	}
+	name := cfg.MDNSName
+	if name == "" {
+		name = os.Getenv("NODE_NAME")
+	}
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	label := mdnsLabel(name)
+	m := &mdnsResponder{conn: conn, ifi: ifi, instance: label + "." + mdnsService, host: label + ".local.", port: port, txt: mdnsTXT()}
+	log.Printf("Advertising %s on port %d by mDNS", m.instance, port)
+	go m.serve()
+	go m.announce()
+}
+
+// mdnsLabel makes a single DNS label of name
+func mdnsLabel(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r < ' ' {
+			return '-'
+		}
+		return r
+	}, name)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	if name == "" {
+		name = "yolo"
+	}
+	return name
+}
+
+func mdnsTXT() []string {
+	return []string{
+		"txtvers=1",
+		"model=" + activeModelVersion(),
+		"version=" + buildVersion,
+		"api=/api/v1",
+		"path=/",
+	}
+}
+
+// announce sends the records at startup, as RFC 6762 asks twice, and again
+// whenever the TXT records change
+func (m *mdnsResponder) announce() {
+	m.send(m.records(dnsTypeANY, m.instance), nil, mdnsGroup, 0)
+	time.Sleep(time.Second)
+	m.send(m.records(dnsTypeANY, m.instance), nil, mdnsGroup, 0)
+	for range time.Tick(15 * time.Second) {
+		txt := mdnsTXT()
+		m.mu.Lock()
+		changed := strings.Join(txt, "\x00") != strings.Join(m.txt, "\x00")
+		m.txt = txt
+		m.mu.Unlock()
+		if changed {
+			m.send(m.records(dnsTypeANY, m.instance), nil, mdnsGroup, 0)
+		}
+	}
+}
+
+func (m *mdnsResponder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("Warning: MDNS responder stopped: %v", err)
+			return
+		}
+		m.handle(buf[:n], src)
+	}
+}
+
+// handle answers the questions of one query
+func (m *mdnsResponder) handle(msg []byte, src *net.UDPAddr) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 {
+		return // not a query
+	}
+	// A query from a port other than 5353 is a legacy unicast resolver
+	// (RFC 6762 section 6.7): it wants the ID and questions echoed, a short
+	// TTL, and the answer sent back to it
+	legacy := src.Port != mdnsGroup.Port
+	unicast := legacy
+	var answers []dnsRecord
+	var questions [][]byte
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return
+		}
+		qtype := binary.BigEndian.Uint16(msg[next:])
+		if binary.BigEndian.Uint16(msg[next+2:])&dnsUnicast != 0 {
+			unicast = true
+		}
+		if rr := m.records(qtype, name); len(rr) > 0 {
+			answers = append(answers, rr...)
+			questions = append(questions, append(encodeDNSName(name), msg[next:next+4]...))
+		}
+		off = next + 4
+	}
+	switch {
+	case len(answers) == 0:
+	case legacy:
+		m.sendTTL(answers, questions, src, binary.BigEndian.Uint16(msg), 10)
+	case unicast:
+		m.send(answers, nil, src, 0)
+	default:
+		m.send(answers, nil, mdnsGroup, 0)
+	}
+}
+
+// records returns the answers for a question about name
+func (m *mdnsResponder) records(qtype uint16, name string) []dnsRecord {
+	name = strings.ToLower(strings.TrimSuffix(name, ".") + ".")
+	srv := make([]byte, 6, 64)
+	binary.BigEndian.PutUint16(srv[4:], uint16(m.port))
+	srv = append(srv, encodeDNSName(m.host)...)
+	m.mu.Lock()
+	var txt []byte
+	for _, s := range m.txt {
+		txt = append(txt, byte(len(s)))
+		txt = append(txt, s...)
+	}
+	m.mu.Unlock()
+	service := []dnsRecord{
+		{m.instance, dnsTypeSRV, true, srv},
+		{m.instance, dnsTypeTXT, true, txt},
+	}
+
+	match := func(t uint16) bool { return qtype == t || qtype == dnsTypeANY }
+	var out []dnsRecord
+	switch name {
+	case mdnsServices:
+		if match(dnsTypePTR) {
+			out = append(out, dnsRecord{mdnsServices, dnsTypePTR, false, encodeDNSName(mdnsService)})
+		}
+	case mdnsService:
+		if match(dnsTypePTR) {
+			out = append(out, dnsRecord{mdnsService, dnsTypePTR, false, encodeDNSName(m.instance)})
+			out = append(out, service...)
+			out = append(out, m.addresses()...)
+		}
+	case strings.ToLower(m.instance):
+		for _, rr := range service {
+			if match(rr.rtype) {
+				out = append(out, rr)
+			}
+		}
+		if len(out) > 0 {
+			out = append(out, m.addresses()...)
+		}
+	case strings.ToLower(m.host):
+		if match(dnsTypeA) {
+			out = append(out, m.addresses()...)
+		}
+	}
+	return out
+}
+
+// addresses returns the host's A records
+func (m *mdnsResponder) addresses() []dnsRecord {
+	var ifaces []net.Interface
+	if m.ifi != nil {
+		ifaces = []net.Interface{*m.ifi}
+	} else {
+		ifaces, _ = net.Interfaces()
+	}
+	var out []dnsRecord
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				if ip4 := ipnet.IP.To4(); ip4 != nil {
+					out = append(out, dnsRecord{m.host, dnsTypeA, true, []byte(ip4)})
+				}
+			}
+		}
+	}
+	return out
+}
+
+func (m *mdnsResponder) send(answers []dnsRecord, questions [][]byte, dst *net.UDPAddr, id uint16) {
+	m.sendTTL(answers, questions, dst, id, mdnsTTL)
+}
+
+// sendTTL writes a response with every record in the answer section
+func (m *mdnsResponder) sendTTL(answers []dnsRecord, questions [][]byte, dst *net.UDPAddr, id uint16, ttl uint32) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagResp)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	for _, q := range questions {
+		msg = append(msg, q...)
+	}
+	for _, rr := range answers {
+		class := uint16(dnsClassIN)
+		if rr.flush && dst == mdnsGroup {
+			class |= dnsFlush
+		}
+		msg = append(msg, encodeDNSName(rr.name)...)
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], rr.rtype)
+		binary.BigEndian.PutUint16(fixed[2:], class)
+		binary.BigEndian.PutUint32(fixed[4:], ttl)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(rr.data)))
+		msg = append(msg, fixed[:]...)
+		msg = append(msg, rr.data...)
+	}
+	if _, err := m.conn.WriteToUDP(msg, dst); err != nil {
+		log.Printf("Warning: MDNS: %v", err)
+	}
+}
+
+// encodeDNSName writes name as length-prefixed labels
+func encodeDNSName(name string) []byte {
+	var out []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+// readDNSName reads a possibly compressed name at off, returning it and the
+// offset after it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("truncated name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, fmt.Errorf("truncated pointer")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, fmt.Errorf("truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, fmt.Errorf("too many compression pointers")
+}
@@ -0,0 +1,335 @@
+package main
+
+// catalogES is the Spanish message catalog (see i18n.go)
+var catalogES = catalog{
+	// Home page
+	"YOLO Object Detection":              "Detección de objetos YOLO",
+	"Network: %s":                        "Red: %s",
+	"online":                             "en línea",
+	"offline":                            "sin conexión",
+	"unknown":                            "desconocido",
+	"stale since %s":                     "sin actualizar desde %s",
+	"Training: %s":                       "Entrenamiento: %s",
+	"Enabled":                            "Activado",
+	"Disabled":                           "Desactivado",
+	"Inference backend: %s":              "Backend de inferencia: %s",
+	"up":                                 "activo",
+	"down":                               "caído",
+	"recovering":                         "recuperándose",
+	"Upload an Image":                    "Subir una imagen",
+	"Run Inference":                      "Ejecutar inferencia",
+	"View geotagged detections on a map": "Ver detecciones geoetiquetadas en un mapa",
+	"Camera sources":                     "Fuentes de cámara",
+	"Trigger manual training job":        "Lanzar un entrenamiento manual",
+	"Trigger Training":                   "Lanzar entrenamiento",
+	"Pull latest model from gateway":     "Descargar el último modelo desde la pasarela",
+	"Pull New Model":                     "Descargar modelo nuevo",
+	"Send trained weights to gateway":    "Enviar los pesos entrenados a la pasarela",
+	"Send Weights":                       "Enviar pesos",
+	"Running inference...":               "Ejecutando inferencia...",
+	"Model Received!":                    "¡Modelo recibido!",
+	"Weights Sent!":                      "¡Pesos enviados!",
+	"Training Started!":                  "¡Entrenamiento iniciado!",
+	"Dark mode":                          "Modo oscuro",
+	"Light mode":                         "Modo claro",
+
+	// Results and error pages
+	"Error": "Error",
+	"Capture hours (cron minutes, e.g. %s; blank for always)": "Horas de captura (minutos cron, p. ej. %s; vacío para siempre)",
+	"Alert hours (cron minutes, e.g. %s; blank for always)":   "Horas de alertas (minutos cron, p. ej. %s; vacío para siempre)",
+	"quiet":                  "en horas de silencio",
+	"alerts off":             "alertas desactivadas",
+	"Node is in maintenance": "El nodo está en mantenimiento",
+	"Under maintenance":      "En mantenimiento",
+	"Thermal throttled":      "Limitación térmica",
+	"On battery":             "Con batería",
+	"%.0f%% charge":          "%.0f%% de carga",
+	"about %.0f min left":    "quedan unos %.0f min",
+	"Non-critical work is paused until mains power returns.":                                                "El trabajo no crítico está en pausa hasta que vuelva la red eléctrica.",
+	"Camera frame rates and inference concurrency are reduced.":                                             "Se han reducido la frecuencia de imagen de las cámaras y la concurrencia de inferencia.",
+	"This node is being serviced and is not accepting images right now. Please try again in a few minutes.": "Este nodo está en mantenimiento y no acepta imágenes ahora mismo. Vuelva a intentarlo en unos minutos.",
+	"Maintenance":                           "Mantenimiento",
+	"In maintenance":                        "En mantenimiento",
+	"In service":                            "En servicio",
+	"Reason":                                "Motivo",
+	"Start maintenance":                     "Iniciar mantenimiento",
+	"End maintenance":                       "Terminar mantenimiento",
+	"paused":                                "en pausa",
+	"Logs":                                  "Registros",
+	"Level":                                 "Nivel",
+	"Module":                                "Módulo",
+	"Admin":                                 "Administración",
+	"Admin token":                           "Token de administración",
+	"Sign in":                               "Iniciar sesión",
+	"Sign out":                              "Cerrar sesión",
+	"Wrong token":                           "Token incorrecto",
+	"Readiness":                             "Disponibilidad",
+	"Run checks":                            "Ejecutar comprobaciones",
+	"Central endpoints":                     "Servicios centrales",
+	"All":                                   "Todos",
+	"Ping":                                  "Probar",
+	"No central endpoints are configured.":  "No hay servicios centrales configurados.",
+	"Camera connection":                     "Conexión de cámara",
+	"Grab a frame":                          "Capturar un fotograma",
+	"Recent logs":                           "Registros recientes",
+	"Filter":                                "Filtrar",
+	"Refresh":                               "Actualizar",
+	"Follow":                                "Seguir",
+	"Version":                               "Versión",
+	"built":                                 "compilada",
+	"Internal server error":                 "Error interno del servidor",
+	"Internal server error (request %s)":    "Error interno del servidor (solicitud %s)",
+	"Results":                               "Resultados",
+	"← Back to Upload":                      "← Volver a subir",
+	"← Upload Another Image":                "← Subir otra imagen",
+	"Detection Results":                     "Resultados de la detección",
+	"Sensitive regions have been redacted.": "Las zonas sensibles se han ocultado.",
+	"Image:":                                "Imagen:",
+	"Detections Found:":                     "Detecciones encontradas:",
+	"Model:":                                "Modelo:",
+	"Timing: %.0f ms":                       "Tiempos: %.0f ms",
+	"Upload":                                "Subida",
+	"Queue wait":                            "Espera en cola",
+	"Preprocess":                            "Preprocesado",
+	"Inference":                             "Inferencia",
+	"Postprocess":                           "Posprocesado",
+	"Total":                                 "Total",
+	"(canary)":                              "(canario)",
+	"Confidence: %.1f%%":                    "Confianza: %.1f%%",
+	"Class ID: %d":                          "ID de clase: %d",
+	"BBox: (%.0f, %.0f) to (%.0f, %.0f)":    "Caja: (%.0f, %.0f) a (%.0f, %.0f)",
+	"Detection is correct":                  "La detección es correcta",
+	"Correct":                               "Correcta",
+	"Detection is wrong":                    "La detección es incorrecta",
+	"Wrong":                                 "Incorrecta",
+	"No objects detected in the image.":     "No se detectaron objetos en la imagen.",
+	"Share:":                                "Compartir:",
+	"Copy link":                             "Copiar enlace",
+	"QR code":                               "Código QR",
+	"QR code for %s":                        "Código QR de %s",
+	"Copied!":                               "¡Copiado!",
+	"Thanks! Marked correct.":               "¡Gracias! Marcada como correcta.",
+	"Thanks! Marked incorrect.":             "¡Gracias! Marcada como incorrecta.",
+	"Failed to parse form":                  "No se pudo leer el formulario",
+	"Failed to get image":                   "No se pudo obtener la imagen",
+	"Failed to save image":                  "No se pudo guardar la imagen",
+	"Failed to write image":                 "No se pudo escribir la imagen",
+	"Inference failed":                      "La inferencia falló",
+	"Failed to parse results":               "No se pudieron leer los resultados",
+	"Result not found. It may have been removed by the retention policy.": "Resultado no encontrado. Puede que la política de retención lo haya eliminado.",
+
+	// Upload progress
+	"…or paste an image, or an image URL to fetch": "…o pega una imagen, o la URL de una imagen para descargarla",
+	"Fetch":       "Descargar",
+	"Fetching...": "Descargando...",
+	"url must be an absolute http or https URL":        "url debe ser una URL http o https absoluta",
+	"url resolves to a private or local address":       "la url apunta a una dirección privada o local",
+	"fetch failed: %s":                                 "la descarga falló: %s",
+	"fetch failed: %v":                                 "la descarga falló: %v",
+	"image exceeds %d bytes":                           "la imagen supera los %d bytes",
+	"image is not valid base64":                        "la imagen no es base64 válido",
+	"data URLs must be base64 encoded":                 "las URL data: deben estar codificadas en base64",
+	"content is not an image (detected %s)":            "el contenido no es una imagen (detectado %s)",
+	"Drop images here, or choose them:":                "Suelta aquí las imágenes o elígelas:",
+	"Waiting":                                          "En espera",
+	"Uploading %s%":                                    "Subiendo %s%",
+	"Connection lost, retrying...":                     "Conexión perdida, reintentando...",
+	"View result":                                      "Ver resultado",
+	"Failed: %s":                                       "Error: %s",
+	"Upload not found":                                 "Subida no encontrada",
+	"Upload-Offset header is required":                 "Falta la cabecera Upload-Offset",
+	"Upload-Offset does not match the current offset":  "Upload-Offset no coincide con el desplazamiento actual",
+	"another chunk is being written; retry shortly":    "se está escribiendo otro fragmento; reinténtalo en breve",
+	"Completed uploads cannot be abandoned":            "Las subidas completadas no se pueden abandonar",
+	"filename is required":                             "filename es obligatorio",
+	"size must be between 1 and %d bytes":              "size debe estar entre 1 y %d bytes",
+	"chunk extends past the declared size of %d bytes": "el fragmento supera el tamaño declarado de %d bytes",
+	"Image uploaded":                                   "Imagen subida",
+	"Waiting for an inference worker":                  "Esperando un trabajador de inferencia",
+	"Done":                                             "Listo",
+	"Processing failed: %s":                            "El procesamiento falló: %s",
+	"Lost contact with the node. Reload the page to check on the result.": "Se perdió el contacto con el nodo. Recarga la página para comprobar el resultado.",
+	"Job not found":                                        "Trabajo no encontrado",
+	"Streaming unsupported":                                "Streaming no admitido",
+	"upload queue is full; try again later":                "la cola de subidas está llena; inténtalo más tarde",
+	"the result could not be stored":                       "no se pudo guardar el resultado",
+	"inference was interrupted by restarts too many times": "la inferencia se interrumpió por reinicios demasiadas veces",
+
+	// Offline page
+	"Last known results":                  "Últimos resultados",
+	"Last Known Results":                  "Últimos resultados conocidos",
+	"Loading...":                          "Cargando...",
+	"Results as of %s (node network: %s)": "Resultados a fecha de %s (red del nodo: %s)",
+	"This device is offline; showing the last results it received.": "Este dispositivo no tiene conexión; se muestran los últimos resultados recibidos.",
+	"No results available offline yet.":                             "Todavía no hay resultados disponibles sin conexión.",
+
+	// Map page
+	"Map":                  "Mapa",
+	"Geotagged Detections": "Detecciones geoetiquetadas",
+	"Captured":             "Capturada",
+	"Position":             "Posición",
+	"Detections":           "Detecciones",
+	"image":                "imagen",
+	"no detections":        "sin detecciones",
+	"No geotagged results yet. Upload images with GPS EXIF data to see them here.": "Aún no hay resultados geoetiquetados. Sube imágenes con datos GPS EXIF para verlas aquí.",
+
+	// Camera sources page
+	"Camera Sources":                    "Fuentes de cámara",
+	"Name":                              "Nombre",
+	"Model":                             "Modelo",
+	"Status":                            "Estado",
+	"zone: %s":                          "zona: %s",
+	"active":                            "activo",
+	"connected":                         "conectada",
+	"connecting":                        "conectando",
+	"error":                             "error",
+	"disabled":                          "desactivada",
+	"Edit":                              "Editar",
+	"Delete":                            "Eliminar",
+	"No camera sources configured yet.": "Todavía no hay fuentes de cámara configuradas.",
+	"Add Source":                        "Añadir fuente",
+	"URL (rtsp://, rtsps://, an http(s) JPEG snapshot URL, or v4l2:///dev/videoN)": "URL (rtsp://, rtsps://, una URL http(s) de captura JPEG o v4l2:///dev/videoN)",
+	"Active model": "Modelo activo",
+	"Confidence threshold (blank for the default)":                      "Umbral de confianza (vacío para el predeterminado)",
+	"Classes (comma-separated class or taxonomy labels; blank for all)": "Clases (etiquetas de clase o taxonomía separadas por comas; vacío para todas)",
+	"Zones (JSON, e.g. %s)":                                             "Zonas (JSON, p. ej. %s)",
+	"Save":                                                              "Guardar",
+	"Clear":                                                             "Limpiar",
+	"Discover ONVIF Cameras":                                            "Descubrir cámaras ONVIF",
+	"Searches the local network. Add a camera with its ONVIF credentials to use its first RTSP profile.": "Busca en la red local. Añade una cámara con sus credenciales ONVIF para usar su primer perfil RTSP.",
+	"Discover":                        "Descubrir",
+	"Delete source %s?":               "¿Eliminar la fuente %s?",
+	"Searching...":                    "Buscando...",
+	"No cameras answered.":            "Ninguna cámara respondió.",
+	"Add":                             "Añadir",
+	"Source name":                     "Nombre de la fuente",
+	"ONVIF username (blank for none)": "Usuario ONVIF (vacío si no hay)",
+	"ONVIF password":                  "Contraseña ONVIF",
+	"last frame %ss ago, %s fps":      "último fotograma hace %s s, %s fps",
+
+	// Dashboard
+	"Dashboard":     "Panel",
+	"Range":         "Intervalo",
+	"Last hour":     "Última hora",
+	"Last 24 hours": "Últimas 24 horas",
+	"Last 7 days":   "Últimos 7 días",
+	"Last 30 days":  "Últimos 30 días",
+	"Last year":     "Último año",
+	"Source":        "Fuente",
+	"Inferences":    "Inferencias",
+	"Errors":        "Errores",
+	"Latency (ms)":  "Latencia (ms)",
+	"Average":       "Media",
+	"Maximum":       "Máximo",
+
+	// Reports
+	"Daily report for %s":        "Informe diario del %s",
+	"Weekly report for %s to %s": "Informe semanal del %s al %s",
+	"Report for %s to %s":        "Informe del %s al %s",
+	"Node":                       "Nodo",
+	"Model in use":               "Modelo en uso",
+	"Generated %s":               "Generado el %s",
+	"Activity":                   "Actividad",
+	"Average latency":            "Latencia media",
+	"Maximum latency":            "Latencia máxima",
+	"Detections by class":        "Detecciones por clase",
+	"Detections by source":       "Detecciones por fuente",
+	"Alerts by rule":             "Alertas por regla",
+	"Availability":               "Disponibilidad",
+	"Uptime":                     "Tiempo en servicio",
+	"Starts":                     "Arranques",
+	"Backend outages":            "Caídas del motor de inferencia",
+	"Events":                     "Eventos",
+	"No detections":              "Sin detecciones",
+	"No alerts":                  "Sin alertas",
+	"No events":                  "Sin eventos",
+	"Inference backend":          "Motor de inferencia",
+	"Model activated":            "Modelo activado",
+
+	// Client SDKs
+	"Client SDKs": "SDK de cliente",
+	"Generated from this node's API, version %s. Every build regenerates them, so download them again after an upgrade.": "Generados a partir de la API de este nodo, versión %s. Cada compilación los regenera: descárguelos de nuevo tras una actualización.",
+	"for other languages and API tools": "para otros lenguajes y herramientas de API",
+
+	// API errors
+	"Method not allowed":                      "Método no permitido",
+	"Not found":                               "No encontrado",
+	"Invalid request body":                    "Cuerpo de la petición no válido",
+	"Result not found":                        "Resultado no encontrado",
+	"Evaluation not found":                    "Evaluación no encontrada",
+	"Task not found":                          "Tarea no encontrada",
+	"Source not found":                        "Fuente no encontrada",
+	"Sources cannot be renamed":               "Las fuentes no se pueden renombrar",
+	"Discovery failed":                        "El descubrimiento falló",
+	"Snapshot failed":                         "La captura falló",
+	"Snapshot inference failed":               "La inferencia de la captura falló",
+	"Undecodable frame":                       "Fotograma no decodificable",
+	"No matching profile with an RTSP stream": "No hay ningún perfil con flujo RTSP que coincida",
+	"timeout must be a duration up to 30s":    "timeout debe ser una duración de hasta 30s",
+	"xaddr must be the device service URL":    "xaddr debe ser la URL del servicio del dispositivo",
+	"model %q not found in %s":                "modelo %q no encontrado en %s",
+	"model %q is already the active model":    "el modelo %q ya es el modelo activo",
+	"no canary is running":                    "no hay ningún canario en curso",
+	"canary for %q is already running; promote or abort it first":          "ya hay un canario de %q en curso; promuévelo o cancélalo primero",
+	"canary not ready for promotion (%s: %s); pass force=true to override": "el canario no está listo para promoverse (%s: %s); usa force=true para forzarlo",
+	"percent must be in (0, 100], got %g":                                  "percent debe estar en (0, 100], se recibió %g",
+	"dir must be inside %s":                                                "dir debe estar dentro de %s",
+	"dir %s is not a readable directory":                                   "dir %s no es un directorio legible",
+	"no images found in %s":                                                "no se encontraron imágenes en %s",
+	"iou_threshold must be in (0, 1), got %g":                              "iou_threshold debe estar en (0, 1), se recibió %g",
+	"inference failed on all %d images":                                    "la inferencia falló en las %d imágenes",
+	"result %q not found":                                                  "resultado %q no encontrado",
+	"verdict %q requires a valid detection index":                          "el veredicto %q requiere un índice de detección válido",
+	"verdict \"missed\" requires the class of the missed object":           "el veredicto \"missed\" requiere la clase del objeto omitido",
+	"model %q has no class %q":                                             "el modelo %q no tiene la clase %q",
+	"unknown verdict %q (want correct, incorrect, or missed)":              "veredicto desconocido %q (se espera correct, incorrect o missed)",
+	"name must be 1-63 lowercase letters, digits, '-' or '_'":              "el nombre debe tener 1-63 minúsculas, dígitos, '-' o '_'",
+	"name %q is reserved":                                                  "el nombre %q está reservado",
+	"name %q is reserved by the sources API":                               "el nombre %q está reservado por la API de fuentes",
+	"invalid url %q":                                                       "url no válida %q",
+	"unsupported url scheme %q (want rtsp, rtsps, http, https or v4l2)":    "esquema de url no admitido %q (se espera rtsp, rtsps, http, https o v4l2)",
+	"fps must be between %g and %g":                                        "fps debe estar entre %g y %g",
+	"zones need a name":                                                    "las zonas necesitan un nombre",
+	"zone %q needs at least 3 points":                                      "la zona %q necesita al menos 3 puntos",
+	"zone %q points must be normalised to 0-1":                             "los puntos de la zona %q deben estar normalizados a 0-1",
+	"source %q already exists":                                             "la fuente %q ya existe",
+	"rejected by %s: %v":                                                   "rechazada por %s: %v",
+	"no frame within %s":                                                   "ningún fotograma en %s",
+	"device returned %s":                                                   "el dispositivo respondió %s",
+	"training requires an online node (network status: %s)":                "el entrenamiento requiere un nodo en línea (estado de la red: %s)",
+	"device has no media service":                                          "el dispositivo no tiene servicio de medios",
+	"Report not found":                                                     "Informe no encontrado",
+	"Set period or from and to, not both":                                  "Indique period o from y to, no ambos",
+	"period must be daily, weekly or custom":                               "period debe ser daily, weekly o custom",
+	"REPORT_EMAIL_TO is not configured":                                    "REPORT_EMAIL_TO no está configurado",
+	"Report failed":                                                        "El informe falló",
+	"Replay not found":                                                     "Repetición no encontrada",
+	"Invalid since duration":                                               "Duración de since no válida",
+	"limit must not be negative":                                           "limit no debe ser negativo",
+	"no recorded requests since %s":                                        "no hay peticiones grabadas desde %s",
+	"Golden set not found":                                                 "Conjunto de referencia no encontrado",
+	"A golden set needs images and a threshold between 0 and 1":            "Un conjunto de referencia necesita imágenes y un umbral entre 0 y 1",
+	"Golden image names must be relative to GOLDEN_DIR":                    "Los nombres de las imágenes de referencia deben ser relativos a GOLDEN_DIR",
+	"invalid golden set name %q":                                           "nombre de conjunto de referencia no válido %q",
+	"iou_tolerance must be in (0, 1], got %g":                              "iou_tolerance debe estar en (0, 1], se recibió %g",
+	"confidence_tolerance must be between 0 and 1, got %g":                 "confidence_tolerance debe estar entre 0 y 1, se recibió %g",
+	"imgsz must be a multiple of 32 between 32 and %d":                     "imgsz debe ser un múltiplo de 32 entre 32 y %d",
+	"iou_threshold must be in (0, 1]":                                      "iou_threshold debe estar en (0, 1]",
+	"max_detections must be between 1 and %d":                              "max_detections debe estar entre 1 y %d",
+	"invalid %s %q":                                                        "%s no válido: %q",
+	"%s must be in (0, 1]":                                                 "%s debe estar en (0, 1]",
+	"No reference image":                                                   "Sin imagen de referencia",
+	"reference_iou must be between 0 and 1":                                "reference_iou debe estar entre 0 y 1",
+	"not a decodable image: %v":                                            "no es una imagen decodificable: %v",
+	"cannot redact the reference: %v":                                      "no se puede censurar la referencia: %v",
+	"image is larger than %d MB":                                           "la imagen supera %d MB",
+	"Barcode:":                                                             "Código de barras:",
+	"BARCODE_DECODE must be off, image or boxes":                           "BARCODE_DECODE debe ser off, image o boxes",
+	"Image rejected":                                                       "Imagen rechazada",
+	"image is %dx%d, below the minimum of %dx%d: raise the camera or upload resolution":              "la imagen es de %dx%d, por debajo del mínimo de %dx%d: aumente la resolución de la cámara o de la subida",
+	"image is too dark (brightness %.0f, minimum %.0f): check the lighting or the camera's exposure": "la imagen es demasiado oscura (brillo %.0f, mínimo %.0f): revise la iluminación o la exposición de la cámara",
+	"image is too bright (brightness %.0f, maximum %.0f): reduce the exposure or shade the camera":   "la imagen es demasiado clara (brillo %.0f, máximo %.0f): reduzca la exposición o proteja la cámara del sol",
+	"image is blurry (sharpness %.1f, minimum %.1f): clean the lens or check the focus":              "la imagen está borrosa (nitidez %.1f, mínimo %.1f): limpie la lente o revise el enfoque",
+	"QUALITY_GATE must be off, flag or reject":                                                       "QUALITY_GATE debe ser off, flag o reject",
+	"QUALITY_MIN_BRIGHTNESS must be below QUALITY_MAX_BRIGHTNESS":                                    "QUALITY_MIN_BRIGHTNESS debe ser menor que QUALITY_MAX_BRIGHTNESS",
+}
@@ -0,0 +1,335 @@
+package main
+
+// catalogFR is the French message catalog (see i18n.go)
+var catalogFR = catalog{
+	// Home page
+	"YOLO Object Detection":              "Détection d'objets YOLO",
+	"Network: %s":                        "Réseau : %s",
+	"online":                             "en ligne",
+	"offline":                            "hors ligne",
+	"unknown":                            "inconnu",
+	"stale since %s":                     "non actualisé depuis %s",
+	"Training: %s":                       "Entraînement : %s",
+	"Enabled":                            "Activé",
+	"Disabled":                           "Désactivé",
+	"Inference backend: %s":              "Moteur d’inférence : %s",
+	"up":                                 "actif",
+	"down":                               "en panne",
+	"recovering":                         "en reprise",
+	"Upload an Image":                    "Envoyer une image",
+	"Run Inference":                      "Lancer l'inférence",
+	"View geotagged detections on a map": "Voir les détections géolocalisées sur une carte",
+	"Camera sources":                     "Sources caméra",
+	"Trigger manual training job":        "Lancer un entraînement manuel",
+	"Trigger Training":                   "Lancer l'entraînement",
+	"Pull latest model from gateway":     "Récupérer le dernier modèle depuis la passerelle",
+	"Pull New Model":                     "Récupérer un nouveau modèle",
+	"Send trained weights to gateway":    "Envoyer les poids entraînés à la passerelle",
+	"Send Weights":                       "Envoyer les poids",
+	"Running inference...":               "Inférence en cours...",
+	"Model Received!":                    "Modèle reçu !",
+	"Weights Sent!":                      "Poids envoyés !",
+	"Training Started!":                  "Entraînement lancé !",
+	"Dark mode":                          "Mode sombre",
+	"Light mode":                         "Mode clair",
+
+	// Results and error pages
+	"Error": "Erreur",
+	"Capture hours (cron minutes, e.g. %s; blank for always)": "Heures de capture (minutes cron, p. ex. %s ; vide pour toujours)",
+	"Alert hours (cron minutes, e.g. %s; blank for always)":   "Heures d'alerte (minutes cron, p. ex. %s ; vide pour toujours)",
+	"quiet":                  "en heures calmes",
+	"alerts off":             "alertes désactivées",
+	"Node is in maintenance": "Le nœud est en maintenance",
+	"Under maintenance":      "En maintenance",
+	"Thermal throttled":      "Bridage thermique",
+	"On battery":             "Sur batterie",
+	"%.0f%% charge":          "%.0f%% de charge",
+	"about %.0f min left":    "environ %.0f min restantes",
+	"Non-critical work is paused until mains power returns.":                                                "Les tâches non essentielles sont suspendues jusqu'au retour du secteur.",
+	"Camera frame rates and inference concurrency are reduced.":                                             "La fréquence d'images des caméras et la concurrence d'inférence sont réduites.",
+	"This node is being serviced and is not accepting images right now. Please try again in a few minutes.": "Ce nœud est en maintenance et n'accepte pas d'images pour le moment. Réessayez dans quelques minutes.",
+	"Maintenance":                           "Maintenance",
+	"In maintenance":                        "En maintenance",
+	"In service":                            "En service",
+	"Reason":                                "Motif",
+	"Start maintenance":                     "Démarrer la maintenance",
+	"End maintenance":                       "Terminer la maintenance",
+	"paused":                                "en pause",
+	"Logs":                                  "Journaux",
+	"Level":                                 "Niveau",
+	"Module":                                "Module",
+	"Admin":                                 "Administration",
+	"Admin token":                           "Jeton d'administration",
+	"Sign in":                               "Se connecter",
+	"Sign out":                              "Se déconnecter",
+	"Wrong token":                           "Jeton incorrect",
+	"Readiness":                             "Disponibilité",
+	"Run checks":                            "Lancer les vérifications",
+	"Central endpoints":                     "Services centraux",
+	"All":                                   "Tous",
+	"Ping":                                  "Tester",
+	"No central endpoints are configured.":  "Aucun service central n'est configuré.",
+	"Camera connection":                     "Connexion de la caméra",
+	"Grab a frame":                          "Capturer une image",
+	"Recent logs":                           "Journaux récents",
+	"Filter":                                "Filtrer",
+	"Refresh":                               "Actualiser",
+	"Follow":                                "Suivre",
+	"Version":                               "Version",
+	"built":                                 "compilée le",
+	"Internal server error":                 "Erreur interne du serveur",
+	"Internal server error (request %s)":    "Erreur interne du serveur (requête %s)",
+	"Results":                               "Résultats",
+	"← Back to Upload":                      "← Retour à l'envoi",
+	"← Upload Another Image":                "← Envoyer une autre image",
+	"Detection Results":                     "Résultats de la détection",
+	"Sensitive regions have been redacted.": "Les zones sensibles ont été masquées.",
+	"Image:":                                "Image :",
+	"Detections Found:":                     "Détections trouvées :",
+	"Model:":                                "Modèle :",
+	"Timing: %.0f ms":                       "Durées : %.0f ms",
+	"Upload":                                "Envoi",
+	"Queue wait":                            "Attente en file",
+	"Preprocess":                            "Prétraitement",
+	"Inference":                             "Inférence",
+	"Postprocess":                           "Post-traitement",
+	"Total":                                 "Total",
+	"(canary)":                              "(canari)",
+	"Confidence: %.1f%%":                    "Confiance : %.1f %%",
+	"Class ID: %d":                          "ID de classe : %d",
+	"BBox: (%.0f, %.0f) to (%.0f, %.0f)":    "Boîte : (%.0f, %.0f) à (%.0f, %.0f)",
+	"Detection is correct":                  "La détection est correcte",
+	"Correct":                               "Correcte",
+	"Detection is wrong":                    "La détection est fausse",
+	"Wrong":                                 "Fausse",
+	"No objects detected in the image.":     "Aucun objet détecté dans l'image.",
+	"Share:":                                "Partager :",
+	"Copy link":                             "Copier le lien",
+	"QR code":                               "Code QR",
+	"QR code for %s":                        "Code QR de %s",
+	"Copied!":                               "Copié !",
+	"Thanks! Marked correct.":               "Merci ! Marquée correcte.",
+	"Thanks! Marked incorrect.":             "Merci ! Marquée incorrecte.",
+	"Failed to parse form":                  "Impossible de lire le formulaire",
+	"Failed to get image":                   "Impossible d'obtenir l'image",
+	"Failed to save image":                  "Impossible d'enregistrer l'image",
+	"Failed to write image":                 "Impossible d'écrire l'image",
+	"Inference failed":                      "L'inférence a échoué",
+	"Failed to parse results":               "Impossible de lire les résultats",
+	"Result not found. It may have been removed by the retention policy.": "Résultat introuvable. Il a peut-être été supprimé par la politique de rétention.",
+
+	// Upload progress
+	"…or paste an image, or an image URL to fetch": "…ou collez une image, ou l'URL d'une image à récupérer",
+	"Fetch":       "Récupérer",
+	"Fetching...": "Récupération...",
+	"url must be an absolute http or https URL":        "url doit être une URL http ou https absolue",
+	"url resolves to a private or local address":       "l'url pointe vers une adresse privée ou locale",
+	"fetch failed: %s":                                 "la récupération a échoué : %s",
+	"fetch failed: %v":                                 "la récupération a échoué : %v",
+	"image exceeds %d bytes":                           "l'image dépasse %d octets",
+	"image is not valid base64":                        "l'image n'est pas du base64 valide",
+	"data URLs must be base64 encoded":                 "les URL data: doivent être encodées en base64",
+	"content is not an image (detected %s)":            "le contenu n'est pas une image (détecté : %s)",
+	"Drop images here, or choose them:":                "Déposez les images ici ou choisissez-les :",
+	"Waiting":                                          "En attente",
+	"Uploading %s%":                                    "Envoi %s %",
+	"Connection lost, retrying...":                     "Connexion perdue, nouvel essai...",
+	"View result":                                      "Voir le résultat",
+	"Failed: %s":                                       "Échec : %s",
+	"Upload not found":                                 "Envoi introuvable",
+	"Upload-Offset header is required":                 "L'en-tête Upload-Offset est obligatoire",
+	"Upload-Offset does not match the current offset":  "Upload-Offset ne correspond pas à la position actuelle",
+	"another chunk is being written; retry shortly":    "un autre fragment est en cours d'écriture ; réessayez bientôt",
+	"Completed uploads cannot be abandoned":            "Les envois terminés ne peuvent pas être abandonnés",
+	"filename is required":                             "filename est obligatoire",
+	"size must be between 1 and %d bytes":              "size doit être compris entre 1 et %d octets",
+	"chunk extends past the declared size of %d bytes": "le fragment dépasse la taille déclarée de %d octets",
+	"Image uploaded":                                   "Image envoyée",
+	"Waiting for an inference worker":                  "En attente d'un worker d'inférence",
+	"Done":                                             "Terminé",
+	"Processing failed: %s":                            "Le traitement a échoué : %s",
+	"Lost contact with the node. Reload the page to check on the result.": "Contact perdu avec le nœud. Rechargez la page pour vérifier le résultat.",
+	"Job not found":                                        "Tâche introuvable",
+	"Streaming unsupported":                                "Streaming non pris en charge",
+	"upload queue is full; try again later":                "la file d'envoi est pleine ; réessayez plus tard",
+	"the result could not be stored":                       "le résultat n'a pas pu être enregistré",
+	"inference was interrupted by restarts too many times": "l'inférence a été interrompue par des redémarrages trop de fois",
+
+	// Offline page
+	"Last known results":                  "Derniers résultats",
+	"Last Known Results":                  "Derniers résultats connus",
+	"Loading...":                          "Chargement...",
+	"Results as of %s (node network: %s)": "Résultats au %s (réseau du nœud : %s)",
+	"This device is offline; showing the last results it received.": "Cet appareil est hors ligne ; affichage des derniers résultats reçus.",
+	"No results available offline yet.":                             "Aucun résultat disponible hors ligne pour l'instant.",
+
+	// Map page
+	"Map":                  "Carte",
+	"Geotagged Detections": "Détections géolocalisées",
+	"Captured":             "Capturée",
+	"Position":             "Position",
+	"Detections":           "Détections",
+	"image":                "image",
+	"no detections":        "aucune détection",
+	"No geotagged results yet. Upload images with GPS EXIF data to see them here.": "Aucun résultat géolocalisé pour l'instant. Envoyez des images avec des données GPS EXIF pour les voir ici.",
+
+	// Camera sources page
+	"Camera Sources":                    "Sources caméra",
+	"Name":                              "Nom",
+	"Model":                             "Modèle",
+	"Status":                            "État",
+	"zone: %s":                          "zone : %s",
+	"active":                            "actif",
+	"connected":                         "connectée",
+	"connecting":                        "connexion",
+	"error":                             "erreur",
+	"disabled":                          "désactivée",
+	"Edit":                              "Modifier",
+	"Delete":                            "Supprimer",
+	"No camera sources configured yet.": "Aucune source caméra configurée pour l'instant.",
+	"Add Source":                        "Ajouter une source",
+	"URL (rtsp://, rtsps://, an http(s) JPEG snapshot URL, or v4l2:///dev/videoN)": "URL (rtsp://, rtsps://, une URL de capture JPEG http(s) ou v4l2:///dev/videoN)",
+	"Active model": "Modèle actif",
+	"Confidence threshold (blank for the default)":                      "Seuil de confiance (vide pour la valeur par défaut)",
+	"Classes (comma-separated class or taxonomy labels; blank for all)": "Classes (libellés de classe ou de taxonomie séparés par des virgules ; vide pour toutes)",
+	"Zones (JSON, e.g. %s)":                                             "Zones (JSON, p. ex. %s)",
+	"Save":                                                              "Enregistrer",
+	"Clear":                                                             "Effacer",
+	"Discover ONVIF Cameras":                                            "Découvrir les caméras ONVIF",
+	"Searches the local network. Add a camera with its ONVIF credentials to use its first RTSP profile.": "Recherche sur le réseau local. Ajoutez une caméra avec ses identifiants ONVIF pour utiliser son premier profil RTSP.",
+	"Discover":                        "Découvrir",
+	"Delete source %s?":               "Supprimer la source %s ?",
+	"Searching...":                    "Recherche...",
+	"No cameras answered.":            "Aucune caméra n'a répondu.",
+	"Add":                             "Ajouter",
+	"Source name":                     "Nom de la source",
+	"ONVIF username (blank for none)": "Utilisateur ONVIF (vide si aucun)",
+	"ONVIF password":                  "Mot de passe ONVIF",
+	"last frame %ss ago, %s fps":      "dernière image il y a %s s, %s ips",
+
+	// Dashboard
+	"Dashboard":     "Tableau de bord",
+	"Range":         "Période",
+	"Last hour":     "Dernière heure",
+	"Last 24 hours": "Dernières 24 heures",
+	"Last 7 days":   "7 derniers jours",
+	"Last 30 days":  "30 derniers jours",
+	"Last year":     "Dernière année",
+	"Source":        "Source",
+	"Inferences":    "Inférences",
+	"Errors":        "Erreurs",
+	"Latency (ms)":  "Latence (ms)",
+	"Average":       "Moyenne",
+	"Maximum":       "Maximum",
+
+	// Reports
+	"Daily report for %s":        "Rapport quotidien du %s",
+	"Weekly report for %s to %s": "Rapport hebdomadaire du %s au %s",
+	"Report for %s to %s":        "Rapport du %s au %s",
+	"Node":                       "Nœud",
+	"Model in use":               "Modèle utilisé",
+	"Generated %s":               "Généré le %s",
+	"Activity":                   "Activité",
+	"Average latency":            "Latence moyenne",
+	"Maximum latency":            "Latence maximale",
+	"Detections by class":        "Détections par classe",
+	"Detections by source":       "Détections par source",
+	"Alerts by rule":             "Alertes par règle",
+	"Availability":               "Disponibilité",
+	"Uptime":                     "Temps de fonctionnement",
+	"Starts":                     "Démarrages",
+	"Backend outages":            "Pannes du moteur d'inférence",
+	"Events":                     "Événements",
+	"No detections":              "Aucune détection",
+	"No alerts":                  "Aucune alerte",
+	"No events":                  "Aucun événement",
+	"Inference backend":          "Moteur d'inférence",
+	"Model activated":            "Modèle activé",
+
+	// Client SDKs
+	"Client SDKs": "SDK clients",
+	"Generated from this node's API, version %s. Every build regenerates them, so download them again after an upgrade.": "Générés à partir de l'API de ce nœud, version %s. Chaque build les régénère : téléchargez-les à nouveau après une mise à jour.",
+	"for other languages and API tools": "pour les autres langages et outils d'API",
+
+	// API errors
+	"Method not allowed":                      "Méthode non autorisée",
+	"Not found":                               "Introuvable",
+	"Invalid request body":                    "Corps de requête invalide",
+	"Result not found":                        "Résultat introuvable",
+	"Evaluation not found":                    "Évaluation introuvable",
+	"Task not found":                          "Tâche introuvable",
+	"Source not found":                        "Source introuvable",
+	"Sources cannot be renamed":               "Les sources ne peuvent pas être renommées",
+	"Discovery failed":                        "La découverte a échoué",
+	"Snapshot failed":                         "La capture a échoué",
+	"Snapshot inference failed":               "L'inférence sur la capture a échoué",
+	"Undecodable frame":                       "Image indécodable",
+	"No matching profile with an RTSP stream": "Aucun profil correspondant avec un flux RTSP",
+	"timeout must be a duration up to 30s":    "timeout doit être une durée d'au plus 30s",
+	"xaddr must be the device service URL":    "xaddr doit être l'URL du service de l'appareil",
+	"model %q not found in %s":                "modèle %q introuvable dans %s",
+	"model %q is already the active model":    "le modèle %q est déjà le modèle actif",
+	"no canary is running":                    "aucun canari en cours",
+	"canary for %q is already running; promote or abort it first":          "un canari pour %q est déjà en cours ; promouvez-le ou annulez-le d'abord",
+	"canary not ready for promotion (%s: %s); pass force=true to override": "le canari n'est pas prêt à être promu (%s : %s) ; passez force=true pour forcer",
+	"percent must be in (0, 100], got %g":                                  "percent doit être dans (0, 100], reçu %g",
+	"dir must be inside %s":                                                "dir doit être dans %s",
+	"dir %s is not a readable directory":                                   "dir %s n'est pas un répertoire lisible",
+	"no images found in %s":                                                "aucune image trouvée dans %s",
+	"iou_threshold must be in (0, 1), got %g":                              "iou_threshold doit être dans (0, 1), reçu %g",
+	"inference failed on all %d images":                                    "l'inférence a échoué sur les %d images",
+	"result %q not found":                                                  "résultat %q introuvable",
+	"verdict %q requires a valid detection index":                          "le verdict %q nécessite un index de détection valide",
+	"verdict \"missed\" requires the class of the missed object":           "le verdict \"missed\" nécessite la classe de l'objet manqué",
+	"model %q has no class %q":                                             "le modèle %q n'a pas de classe %q",
+	"unknown verdict %q (want correct, incorrect, or missed)":              "verdict inconnu %q (attendu : correct, incorrect ou missed)",
+	"name must be 1-63 lowercase letters, digits, '-' or '_'":              "le nom doit comporter 1 à 63 minuscules, chiffres, '-' ou '_'",
+	"name %q is reserved":                                                  "le nom %q est réservé",
+	"name %q is reserved by the sources API":                               "le nom %q est réservé par l'API des sources",
+	"invalid url %q":                                                       "url invalide %q",
+	"unsupported url scheme %q (want rtsp, rtsps, http, https or v4l2)":    "schéma d'url non pris en charge %q (attendu : rtsp, rtsps, http, https ou v4l2)",
+	"fps must be between %g and %g":                                        "fps doit être compris entre %g et %g",
+	"zones need a name":                                                    "les zones doivent avoir un nom",
+	"zone %q needs at least 3 points":                                      "la zone %q nécessite au moins 3 points",
+	"zone %q points must be normalised to 0-1":                             "les points de la zone %q doivent être normalisés entre 0 et 1",
+	"source %q already exists":                                             "la source %q existe déjà",
+	"rejected by %s: %v":                                                   "rejetée par %s : %v",
+	"no frame within %s":                                                   "aucune image en %s",
+	"device returned %s":                                                   "l'appareil a répondu %s",
+	"training requires an online node (network status: %s)":                "l'entraînement nécessite un nœud en ligne (état du réseau : %s)",
+	"device has no media service":                                          "l'appareil n'a pas de service média",
+	"Report not found":                                                     "Rapport introuvable",
+	"Set period or from and to, not both":                                  "Indiquez period ou from et to, pas les deux",
+	"period must be daily, weekly or custom":                               "period doit être daily, weekly ou custom",
+	"REPORT_EMAIL_TO is not configured":                                    "REPORT_EMAIL_TO n'est pas configuré",
+	"Report failed":                                                        "Le rapport a échoué",
+	"Replay not found":                                                     "Rejeu introuvable",
+	"Invalid since duration":                                               "Durée since invalide",
+	"limit must not be negative":                                           "limit ne doit pas être négatif",
+	"no recorded requests since %s":                                        "aucune requête enregistrée depuis %s",
+	"Golden set not found":                                                 "Jeu de référence introuvable",
+	"A golden set needs images and a threshold between 0 and 1":            "Un jeu de référence nécessite des images et un seuil entre 0 et 1",
+	"Golden image names must be relative to GOLDEN_DIR":                    "Les noms des images de référence doivent être relatifs à GOLDEN_DIR",
+	"invalid golden set name %q":                                           "nom de jeu de référence invalide %q",
+	"iou_tolerance must be in (0, 1], got %g":                              "iou_tolerance doit être dans (0, 1], reçu %g",
+	"confidence_tolerance must be between 0 and 1, got %g":                 "confidence_tolerance doit être entre 0 et 1, reçu %g",
+	"imgsz must be a multiple of 32 between 32 and %d":                     "imgsz doit être un multiple de 32 entre 32 et %d",
+	"iou_threshold must be in (0, 1]":                                      "iou_threshold doit être dans (0, 1]",
+	"max_detections must be between 1 and %d":                              "max_detections doit être entre 1 et %d",
+	"invalid %s %q":                                                        "%s invalide : %q",
+	"%s must be in (0, 1]":                                                 "%s doit être dans (0, 1]",
+	"No reference image":                                                   "Aucune image de référence",
+	"reference_iou must be between 0 and 1":                                "reference_iou doit être entre 0 et 1",
+	"not a decodable image: %v":                                            "image non décodable : %v",
+	"cannot redact the reference: %v":                                      "impossible de flouter la référence : %v",
+	"image is larger than %d MB":                                           "l'image dépasse %d Mo",
+	"Barcode:":                                                             "Code-barres :",
+	"BARCODE_DECODE must be off, image or boxes":                           "BARCODE_DECODE doit être off, image ou boxes",
+	"Image rejected":                                                       "Image refusée",
+	"image is %dx%d, below the minimum of %dx%d: raise the camera or upload resolution":              "l'image fait %dx%d, sous le minimum de %dx%d : augmentez la résolution de la caméra ou de l'envoi",
+	"image is too dark (brightness %.0f, minimum %.0f): check the lighting or the camera's exposure": "l'image est trop sombre (luminosité %.0f, minimum %.0f) : vérifiez l'éclairage ou l'exposition de la caméra",
+	"image is too bright (brightness %.0f, maximum %.0f): reduce the exposure or shade the camera":   "l'image est trop claire (luminosité %.0f, maximum %.0f) : réduisez l'exposition ou abritez la caméra",
+	"image is blurry (sharpness %.1f, minimum %.1f): clean the lens or check the focus":              "l'image est floue (netteté %.1f, minimum %.1f) : nettoyez l'objectif ou vérifiez la mise au point",
+	"QUALITY_GATE must be off, flag or reject":                                                       "QUALITY_GATE doit valoir off, flag ou reject",
+	"QUALITY_MIN_BRIGHTNESS must be below QUALITY_MAX_BRIGHTNESS":                                    "QUALITY_MIN_BRIGHTNESS doit être inférieur à QUALITY_MAX_BRIGHTNESS",
+}
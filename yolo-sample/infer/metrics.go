@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus text-format metrics. The image only ships the Go standard
+// library, so this implements the handful of metric types we need.
+
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+var metricsRegistry struct {
+	sync.Mutex
+	collectors []collector
+}
+
+func registerCollector(c collector) {
+	metricsRegistry.Lock()
+	metricsRegistry.collectors = append(metricsRegistry.collectors, c)
+	metricsRegistry.Unlock()
+}
+
+// metricVec holds one value series per label combination
+type metricVec struct {
+	name   string
+	help   string
+	kind   string // "counter" or "gauge"
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by labelKey
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	registerCollector(m)
+	return m
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("counter", name, help, labels...)
+}
+
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("gauge", name, help, labels...)
+}
+
+func (m *metricVec) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	return labelKey(labelValues)
+}
+
+// labelEscaper escapes a label value as the Prometheus text format does
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelKey joins the escaped label values with newlines, which escaping
+// leaves none of, so the key splits back into the values it was made from
+func labelKey(labelValues []string) string {
+	escaped := make([]string, len(labelValues))
+	for i, v := range labelValues {
+		escaped[i] = labelEscaper.Replace(v)
+	}
+	return strings.Join(escaped, "\n")
+}
+
+func (m *metricVec) add(v float64, labelValues ...string) {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	m.values[k] += v
+	m.mu.Unlock()
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) set(v float64, labelValues ...string) {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	m.values[k] = v
+	m.mu.Unlock()
+}
+
+// sumWhere adds up the series whose label has value
+func (m *metricVec) sumWhere(label, value string) float64 {
+	i := -1
+	for j, l := range m.labels {
+		if l == label {
+			i = j
+		}
+	}
+	value = labelEscaper.Replace(value)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum float64
+	for k, v := range m.values {
+		if i >= 0 && strings.Split(k, "\n")[i] == value {
+			sum += v
+		}
+	}
+	return sum
+}
+
+func (m *metricVec) get(labelValues ...string) float64 {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[k]
+}
+
+func (m *metricVec) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, k := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, k, ""), formatFloat(m.values[k]))
+	}
+}
+
+// histogramVec is a Prometheus histogram with one series per label combination
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // cumulative per bucket
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	registerCollector(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	k := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, k, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, k, ""), s.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders {a="x",b="y"} from label names and a labelKey,
+// appending le for histogram buckets when non-empty
+func formatLabels(names []string, key, le string) string {
+	var parts []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\n")
+		for i, n := range names {
+			parts = append(parts, n+`="`+values[i]+`"`)
+		}
+	}
+	if le != "" {
+		parts = append(parts, `le="`+le+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// metricsHandler serves all registered metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metricsRegistry.Lock()
+	collectors := append([]collector(nil), metricsRegistry.collectors...)
+	metricsRegistry.Unlock()
+
+	for _, c := range collectors {
+		c.writeTo(w)
+	}
+}
+
+// Inference metrics, broken down by model version and (where meaningful) class
+
+var (
+	inferenceRequests = newCounterVec("yolo_inference_requests_total",
+		"Inference requests by model version and outcome.", "model", "status")
+	inferenceDuration = newHistogramVec("yolo_inference_duration_seconds",
+		"End-to-end inference latency by model version.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30}, "model")
+	detectionsTotal = newCounterVec("yolo_detections_total",
+		"Objects detected by model version and class.", "model", "class")
+	detectionConfidence = newHistogramVec("yolo_detection_confidence",
+		"Confidence of detections by model version and class.",
+		[]float64{0.1, 0.25, 0.5, 0.75, 0.9}, "model", "class")
+	feedbackTotal = newCounterVec("yolo_feedback_total",
+		"Operator feedback on detections by model version, class, and verdict (correct, incorrect, missed).",
+		"model", "class", "verdict")
+	classPrecision = newGaugeVec("yolo_class_precision",
+		"Feedback-derived precision, correct / (correct + incorrect), by model version and class.", "model", "class")
+	classRecall = newGaugeVec("yolo_class_recall",
+		"Feedback-derived recall, correct / (correct + missed), by model version and class.", "model", "class")
+)
+
+// observeInference records request, latency, and per-class detection metrics for one result
+func observeInference(result InferenceResult, seconds float64) {
+	status := "success"
+	if result.Error != "" {
+		status = "error"
+	}
+	inferenceRequests.inc(result.Model, status)
+	inferenceDuration.observe(seconds, result.Model)
+	for _, d := range result.Detections {
+		detectionsTotal.inc(result.Model, d.ClassName)
+		detectionConfidence.observe(d.Confidence, result.Model, d.ClassName)
+	}
+	observeTimeseries(result, seconds)
+}
+
+// observeFeedback records a feedback verdict and refreshes the derived accuracy gauges
+func observeFeedback(model, class, verdict string) {
+	feedbackTotal.inc(model, class, verdict)
+
+	correct := feedbackTotal.get(model, class, "correct")
+	incorrect := feedbackTotal.get(model, class, "incorrect")
+	missed := feedbackTotal.get(model, class, "missed")
+	if correct+incorrect > 0 {
+		classPrecision.set(correct/(correct+incorrect), model, class)
+	}
+	if correct+missed > 0 {
+		classRecall.set(correct/(correct+missed), model, class)
+	}
+}
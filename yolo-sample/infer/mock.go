@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// INFERENCE_BACKEND=mock replaces the inference process with synthetic
+// detections computed in the binary, so the API, UI and everything behind
+// them can be exercised in CI and on laptops without Python, a GPU or model
+// files. Detections are deterministic: the same image, model version and
+// MOCK_SEED always give the same boxes, classes and confidences, and a
+// different seed or model gives different ones. Boxes are scaled to the
+// image's size; confidences below the request's threshold and detections
+// beyond its max_detections are dropped like a real model's. MOCK_LATENCY adds a fixed inference time, for testing
+// timeouts and queueing.
+
+// Inference backends
+const (
+	backendProcess = "process"
+	backendMock    = "mock"
+)
+
+// mockClasses are the COCO classes the mock backend detects, by COCO ID
+var mockClasses = map[int]string{0: "person", 1: "bicycle", 2: "car", 3: "motorcycle", 5: "bus", 7: "truck", 15: "cat", 16: "dog"}
+
+// mockMaxDetections bounds the detections per image, before the threshold
+const mockMaxDetections = 6
+
+func mockBackend() bool {
+	return config().InferenceBackend == backendMock
+}
+
+// mockInfer computes the synthetic result for an image
+func mockInfer(imagePath, model string, params backendParams) (InferenceResult, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	width, height := 640, 480
+	if c, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && c.Width > 0 && c.Height > 0 {
+		width, height = c.Width, c.Height
+	}
+	version := strings.TrimSuffix(filepath.Base(model), filepath.Ext(model))
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00", config().MockSeed, version)
+	h.Write(data)
+	rng := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h.Sum(nil)))))
+
+	ids := make([]int, 0, len(mockClasses))
+	for id := range mockClasses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids) // map order is random; the draw must not be
+
+	start := time.Now()
+	result := InferenceResult{Detections: []Detection{}}
+	for n := rng.Intn(mockMaxDetections + 1); n > 0; n-- {
+		id := ids[rng.Intn(len(ids))]
+		conf := math.Round((0.05+rng.Float64()*0.94)*1e4) / 1e4
+		bw := (0.05 + rng.Float64()*0.4) * float64(width)
+		bh := (0.05 + rng.Float64()*0.4) * float64(height)
+		x1 := rng.Float64() * (float64(width) - bw)
+		y1 := rng.Float64() * (float64(height) - bh)
+		if conf < params.Threshold || (params.MaxDetections > 0 && len(result.Detections) >= params.MaxDetections) {
+			continue
+		}
+		result.Detections = append(result.Detections, Detection{
+			ClassID:    id,
+			ClassName:  mockClasses[id],
+			Confidence: conf,
+			BBox:       BBox{X1: math.Round(x1), Y1: math.Round(y1), X2: math.Round(x1 + bw), Y2: math.Round(y1 + bh)},
+		})
+	}
+	result.Count = len(result.Detections)
+
+	time.Sleep(config().MockLatency)
+	result.classes = map[string]string{}
+	for id, name := range mockClasses {
+		result.classes[strconv.Itoa(id)] = name
+	}
+	result.speed = &modelSpeed{Inference: millis(time.Since(start))}
+	return result, nil
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /api/v1/models/{name}/metadata reads a model file and reports what it
+// actually contains, so operators can check what a node runs without the
+// Python toolchain:
+//
+//	GET /api/v1/models                  model versions, with the active one
+//	GET /api/v1/models/{name}/metadata  format, SHA-256, signature status, inputs
+//	                                    and outputs, classes, opset and quantization
+//
+// ONNX and TFLite files are parsed directly (protobuf and flatbuffers), including
+// the metadata Ultralytics embeds on export (class names, task, image size).
+// PyTorch checkpoints are pickles, so for .pt files the classes are those the
+// inference process last reported for the model, if it has served it. Results
+// are cached until the file changes.
+
+// ModelMetadata describes a model file
+type ModelMetadata struct {
+	Name         string            `json:"name"`
+	File         string            `json:"file"`
+	Format       string            `json:"format"` // "pytorch", "onnx" or "tflite"
+	Size         int64             `json:"size"`
+	SHA256       string            `json:"sha256"`
+	ModifiedAt   time.Time         `json:"modified_at"`
+	Active       bool              `json:"active"`
+	Signature    string            `json:"signature"` // see modelsign.go
+	Inputs       []ModelTensor     `json:"inputs,omitempty"`
+	Outputs      []ModelTensor     `json:"outputs,omitempty"`
+	Classes      map[string]string `json:"classes,omitempty"` // names by class ID
+	ClassSource  string            `json:"class_source,omitempty"`
+	Opset        map[string]int64  `json:"opset,omitempty"`          // ONNX operator set versions by domain
+	Schema       int64             `json:"schema_version,omitempty"` // TFLite schema version
+	Quantization string            `json:"quantization,omitempty"`   // "none", "fp16", "int8" or "dynamic-int8"
+	Producer     string            `json:"producer,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"` // embedded key/value metadata
+}
+
+// ModelTensor is a model input or output; -1 marks a dynamic dimension
+type ModelTensor struct {
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	Shape []int64 `json:"shape"`
+}
+
+// reportedClasses holds the class names the inference process reported, by model version
+var reportedClasses sync.Map
+
+var modelMetaCache = struct {
+	sync.Mutex
+	entries map[string]modelMetaEntry
+}{entries: map[string]modelMetaEntry{}}
+
+type modelMetaEntry struct {
+	size    int64
+	modTime time.Time
+	meta    ModelMetadata
+}
+
+// readModelMetadata inspects the file of a model version
+func readModelMetadata(version string) (ModelMetadata, error) {
+	path := modelPath(version)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ModelMetadata{}, err
+	}
+	modelMetaCache.Lock()
+	cached, ok := modelMetaCache.entries[path]
+	modelMetaCache.Unlock()
+
+	var meta ModelMetadata
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		meta = cached.meta
+	} else {
+		if meta, err = inspectModelFile(path); err != nil {
+			return ModelMetadata{}, err
+		}
+		meta.Name, meta.File, meta.Size, meta.ModifiedAt = version, filepath.Base(path), fi.Size(), fi.ModTime().UTC()
+		modelMetaCache.Lock()
+		modelMetaCache.entries[path] = modelMetaEntry{size: fi.Size(), modTime: fi.ModTime(), meta: meta}
+		modelMetaCache.Unlock()
+	}
+	meta.Active = version == activeModelVersion()
+	meta.Signature, _ = modelSignature(version)
+	if meta.Classes == nil {
+		if c, ok := reportedClasses.Load(version); ok {
+			meta.Classes, meta.ClassSource = c.(map[string]string), "backend"
+		}
+	}
+	return meta, nil
+}
+
+// modelClasses returns the class names of a model version by ID, nil when
+// neither its file nor the backend tells them
+func modelClasses(version string) map[string]string {
+	if meta, err := readModelMetadata(version); err == nil {
+		return meta.Classes
+	}
+	if c, ok := reportedClasses.Load(version); ok {
+		return c.(map[string]string)
+	}
+	return nil
+}
+
+// hasClassName reports whether name is one of classes
+func hasClassName(classes map[string]string, name string) bool {
+	for _, c := range classes {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func inspectModelFile(path string) (ModelMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ModelMetadata{}, err
+	}
+	sum := sha256.Sum256(data)
+	var meta ModelMetadata
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".onnx":
+		meta, err = parseONNX(data)
+	case ".tflite":
+		meta, err = parseTFLite(data)
+	default:
+		meta, err = parsePyTorch(data)
+	}
+	if err != nil {
+		return ModelMetadata{}, fmt.Errorf("cannot parse %s: %v", filepath.Base(path), err)
+	}
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	if names, ok := meta.Properties["names"]; ok && meta.Classes == nil {
+		meta.Classes, meta.ClassSource = parseClassNames(names), "embedded"
+	}
+	return meta, nil
+}
+
+// pythonDictEntry matches one `0: 'person'` entry of a Python dict literal
+var pythonDictEntry = regexp.MustCompile(`(\d+)\s*:\s*(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)")`)
+
+// parseClassNames reads the class names Ultralytics embeds on export, a
+// Python dict literal such as {0: 'person', 1: 'bicycle'}
+func parseClassNames(s string) map[string]string {
+	out := map[string]string{}
+	for _, m := range pythonDictEntry.FindAllStringSubmatch(s, -1) {
+		out[m[1]] = m[2] + m[3]
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// parsePyTorch checks that data is a PyTorch checkpoint: a zip archive, or a
+// bare pickle in the legacy format
+func parsePyTorch(data []byte) (ModelMetadata, error) {
+	meta := ModelMetadata{Format: "pytorch"}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		if len(data) > 0 && data[0] == 0x80 { // pickle protocol marker
+			return meta, nil
+		}
+		return ModelMetadata{}, fmt.Errorf("not a PyTorch checkpoint")
+	}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/version") {
+			if rc, err := f.Open(); err == nil {
+				v, _ := io.ReadAll(io.LimitReader(rc, 64))
+				rc.Close()
+				meta.Properties = map[string]string{"archive_version": strings.TrimSpace(string(v))}
+			}
+		}
+	}
+	return meta, nil
+}
+
+// Protobuf wire format, enough to walk an ONNX ModelProto
+
+// pbWalk calls fn for each field of a protobuf message; v is the value of
+// varint and fixed fields, b the bytes of length-delimited ones
+func pbWalk(b []byte, fn func(field int, v uint64, b []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("bad protobuf key")
+		}
+		b = b[n:]
+		field, wire := int(key>>3), key&7
+		switch wire {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("bad protobuf varint")
+			}
+			fn(field, v, nil)
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated protobuf")
+			}
+			fn(field, binary.LittleEndian.Uint64(b), nil)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return fmt.Errorf("truncated protobuf")
+			}
+			fn(field, 0, b[n:n+int(l)])
+			b = b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return fmt.Errorf("truncated protobuf")
+			}
+			fn(field, uint64(binary.LittleEndian.Uint32(b)), nil)
+			b = b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+	}
+	return nil
+}
+
+// onnxTypes names ONNX TensorProto data types
+var onnxTypes = map[uint64]string{
+	1: "float32", 2: "uint8", 3: "int8", 4: "uint16", 5: "int16", 6: "int32", 7: "int64",
+	8: "string", 9: "bool", 10: "float16", 11: "float64", 12: "uint32", 13: "uint64", 16: "bfloat16",
+}
+
+// onnxQuantizedOps are operators that only appear in quantized graphs
+var onnxQuantizedOps = map[string]bool{
+	"QuantizeLinear": true, "DequantizeLinear": true, "QLinearConv": true, "QLinearMatMul": true,
+	"ConvInteger": true, "MatMulInteger": true, "QLinearAdd": true, "QLinearMul": true,
+}
+
+func parseONNX(data []byte) (ModelMetadata, error) {
+	meta := ModelMetadata{Format: "onnx", Opset: map[string]int64{}, Properties: map[string]string{}}
+	var graph []byte
+	var producer, producerVersion string
+	err := pbWalk(data, func(field int, v uint64, b []byte) {
+		switch field {
+		case 2:
+			producer = string(b)
+		case 3:
+			producerVersion = string(b)
+		case 7:
+			graph = b
+		case 8: // OperatorSetIdProto
+			var domain string
+			var version int64
+			pbWalk(b, func(f int, v uint64, b []byte) {
+				if f == 1 {
+					domain = string(b)
+				} else if f == 2 {
+					version = int64(v)
+				}
+			})
+			if domain == "" {
+				domain = "ai.onnx"
+			}
+			meta.Opset[domain] = version
+		case 14: // StringStringEntryProto
+			var key, value string
+			pbWalk(b, func(f int, v uint64, b []byte) {
+				if f == 1 {
+					key = string(b)
+				} else if f == 2 {
+					value = string(b)
+				}
+			})
+			meta.Properties[key] = value
+		}
+	})
+	if err != nil {
+		return meta, err
+	}
+	if graph == nil {
+		return meta, fmt.Errorf("no graph in ONNX model")
+	}
+	meta.Producer = strings.TrimSpace(producer + " " + producerVersion)
+
+	initializers := map[string]bool{}
+	types := map[string]bool{}
+	quantized, dynamic := false, false
+	var inputs, outputs [][]byte
+	err = pbWalk(graph, func(field int, v uint64, b []byte) {
+		switch field {
+		case 1: // NodeProto
+			pbWalk(b, func(f int, v uint64, b []byte) {
+				if f == 4 {
+					op := string(b)
+					quantized = quantized || onnxQuantizedOps[op]
+					dynamic = dynamic || op == "DynamicQuantizeLinear"
+				}
+			})
+		case 5: // TensorProto
+			pbWalk(b, func(f int, v uint64, b []byte) {
+				switch f {
+				case 2:
+					types[onnxTypes[v]] = true
+				case 8:
+					initializers[string(b)] = true
+				}
+			})
+		case 11:
+			inputs = append(inputs, b)
+		case 12:
+			outputs = append(outputs, b)
+		}
+	})
+	if err != nil {
+		return meta, err
+	}
+	for _, b := range inputs {
+		if t := onnxValueInfo(b); !initializers[t.Name] {
+			meta.Inputs = append(meta.Inputs, t)
+		}
+	}
+	for _, b := range outputs {
+		meta.Outputs = append(meta.Outputs, onnxValueInfo(b))
+	}
+	switch {
+	case dynamic:
+		meta.Quantization = "dynamic-int8"
+	case quantized || types["int8"] || types["uint8"]:
+		meta.Quantization = "int8"
+	case types["float16"]:
+		meta.Quantization = "fp16"
+	default:
+		meta.Quantization = "none"
+	}
+	return meta, nil
+}
+
+// onnxValueInfo reads a ValueInfoProto holding a tensor type
+func onnxValueInfo(b []byte) ModelTensor {
+	t := ModelTensor{Shape: []int64{}}
+	pbWalk(b, func(f int, v uint64, b []byte) {
+		switch f {
+		case 1:
+			t.Name = string(b)
+		case 2: // TypeProto
+			pbWalk(b, func(f int, v uint64, b []byte) {
+				if f != 1 { // tensor_type
+					return
+				}
+				pbWalk(b, func(f int, v uint64, b []byte) {
+					switch f {
+					case 1:
+						t.Type = onnxTypes[v]
+					case 2: // TensorShapeProto
+						pbWalk(b, func(f int, v uint64, b []byte) {
+							if f != 1 {
+								return
+							}
+							dim := int64(-1)
+							pbWalk(b, func(f int, v uint64, b []byte) {
+								if f == 1 {
+									dim = int64(v)
+								}
+							})
+							t.Shape = append(t.Shape, dim)
+						})
+					}
+				})
+			})
+		}
+	})
+	return t
+}
+
+// Flatbuffers, enough to walk a TFLite Model
+
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+// fbRoot returns the root table of a flatbuffer
+func fbRoot(buf []byte) (fbTable, error) {
+	if len(buf) < 8 {
+		return fbTable{}, fmt.Errorf("file too small")
+	}
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}, nil
+}
+
+// field returns the position of field i, or 0 when it is absent or out of bounds
+func (t fbTable) field(i int) int {
+	if t.pos < 0 || t.pos+4 > len(t.buf) {
+		return 0
+	}
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if vt < 0 || vt+4 > len(t.buf) {
+		return 0
+	}
+	vtSize := int(binary.LittleEndian.Uint16(t.buf[vt:]))
+	at := vt + 4 + 2*i
+	if 4+2*i >= vtSize || at+2 > len(t.buf) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[at:]))
+	if off == 0 || t.pos+off+4 > len(t.buf) {
+		return 0
+	}
+	return t.pos + off
+}
+
+func (t fbTable) uint32(i int) int64 {
+	if p := t.field(i); p != 0 {
+		return int64(binary.LittleEndian.Uint32(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t fbTable) int8(i int) int {
+	if p := t.field(i); p != 0 {
+		return int(int8(t.buf[p]))
+	}
+	return 0
+}
+
+// indirect follows the offset stored at field i, returning -1 when absent
+func (t fbTable) indirect(i int) int {
+	p := t.field(i)
+	if p == 0 {
+		return -1
+	}
+	target := p + int(binary.LittleEndian.Uint32(t.buf[p:]))
+	if target+4 > len(t.buf) {
+		return -1
+	}
+	return target
+}
+
+// vector returns the element count and first element position of vector field i
+func (t fbTable) vector(i int) (int, int) {
+	v := t.indirect(i)
+	if v < 0 {
+		return 0, 0
+	}
+	n := int(binary.LittleEndian.Uint32(t.buf[v:]))
+	if n < 0 || v+4+4*n > len(t.buf) {
+		return 0, 0
+	}
+	return n, v + 4
+}
+
+func (t fbTable) str(i int) string {
+	v := t.indirect(i)
+	if v < 0 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(t.buf[v:]))
+	if v+4+n > len(t.buf) {
+		return ""
+	}
+	return string(t.buf[v+4 : v+4+n])
+}
+
+// tables returns the tables of vector field i
+func (t fbTable) tables(i int) []fbTable {
+	n, start := t.vector(i)
+	out := make([]fbTable, 0, n)
+	for k := 0; k < n; k++ {
+		p := start + 4*k
+		out = append(out, fbTable{t.buf, p + int(binary.LittleEndian.Uint32(t.buf[p:]))})
+	}
+	return out
+}
+
+func (t fbTable) ints(i int) []int64 {
+	n, start := t.vector(i)
+	out := make([]int64, n)
+	for k := 0; k < n; k++ {
+		out[k] = int64(int32(binary.LittleEndian.Uint32(t.buf[start+4*k:])))
+	}
+	return out
+}
+
+// tfliteTypes names TFLite TensorType values
+var tfliteTypes = map[int]string{
+	0: "float32", 1: "float16", 2: "int32", 3: "uint8", 4: "int64", 5: "string",
+	6: "bool", 7: "int16", 8: "complex64", 9: "int8", 10: "float64",
+}
+
+func parseTFLite(data []byte) (ModelMetadata, error) {
+	if len(data) < 8 || string(data[4:8]) != "TFL3" {
+		return ModelMetadata{}, fmt.Errorf("not a TFLite flatbuffer")
+	}
+	model, err := fbRoot(data)
+	if err != nil {
+		return ModelMetadata{}, err
+	}
+	meta := ModelMetadata{Format: "tflite", Schema: model.uint32(0), Producer: model.str(3), Properties: map[string]string{}}
+	subgraphs := model.tables(2)
+	if len(subgraphs) == 0 {
+		return meta, fmt.Errorf("no subgraphs in TFLite model")
+	}
+	types := map[string]bool{}
+	for _, g := range subgraphs {
+		for _, t := range g.tables(0) {
+			types[tfliteTypes[t.int8(1)]] = true
+		}
+	}
+	graph := subgraphs[0]
+	tensors := graph.tables(0)
+	tensor := func(i int64) ModelTensor {
+		if i < 0 || int(i) >= len(tensors) {
+			return ModelTensor{Shape: []int64{}}
+		}
+		t := tensors[i]
+		shape := t.ints(7) // shape_signature marks dynamic dimensions with -1
+		if len(shape) == 0 {
+			shape = t.ints(0)
+		}
+		return ModelTensor{Name: t.str(3), Type: tfliteTypes[t.int8(1)], Shape: shape}
+	}
+	for _, i := range graph.ints(1) {
+		meta.Inputs = append(meta.Inputs, tensor(i))
+	}
+	for _, i := range graph.ints(2) {
+		meta.Outputs = append(meta.Outputs, tensor(i))
+	}
+	for _, m := range model.tables(6) {
+		if name := m.str(0); name != "" && name != "TFLITE_METADATA" {
+			meta.Properties[name] = fmt.Sprint(m.uint32(1))
+		}
+	}
+	switch {
+	case types["int8"] || types["uint8"]:
+		meta.Quantization = "int8"
+	case types["float16"]:
+		meta.Quantization = "fp16"
+	default:
+		meta.Quantization = "none"
+	}
+
+	// Ultralytics appends its export metadata as a zip of text files
+	if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				continue
+			}
+			text, _ := io.ReadAll(io.LimitReader(rc, 1<<20))
+			rc.Close()
+			if i := bytes.Index(text, []byte("'names'")); i >= 0 && meta.Classes == nil {
+				meta.Classes, meta.ClassSource = parseClassNames(string(text[i:])), "embedded"
+			}
+		}
+	}
+	return meta, nil
+}
+
+// modelsHandler serves the model inventory and file metadata
+//
+//	GET /api/v1/models                  model versions in MODEL_DIR
+//	GET /api/v1/models/{name}/metadata  what the model file contains
+func modelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/models"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		versions := listModelVersions()
+		type modelEntry struct {
+			Name   string       `json:"name"`
+			File   string       `json:"file"`
+			Active bool         `json:"active"`
+			Pulled *PulledModel `json:"pulled,omitempty"` // registry origin, see oci.go
+		}
+		active := activeModelVersion()
+		out := make([]modelEntry, 0, len(versions))
+		for _, v := range versions {
+			e := modelEntry{Name: v, File: filepath.Base(modelPath(v)), Active: v == active}
+			if p, ok := pulledModel(v); ok && filepath.Dir(modelPath(v)) == pulledModelsDir() {
+				e.Pulled = &p
+			}
+			out = append(out, e)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": active, "models": out})
+
+	case len(parts) == 2 && parts[1] == "metadata":
+		if !modelExists(parts[0]) {
+			writeJSONError(w, http.StatusNotFound, "Model not found")
+			return
+		}
+		meta, err := readModelMetadata(parts[0])
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, meta)
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A model version is the base name of a model file in MODEL_DIR (e.g.
+// "production" for production.pt). PyTorch checkpoints are the norm; exported
+// ONNX and TFLite models are served the same way. Models pulled from a
+// registry (see oci.go) live in STATE_DIR/models and take precedence.
+
+// modelExtensions are the model file types, in order of preference when a
+// version exists in several
+var modelExtensions = []string{".pt", ".onnx", ".tflite"}
+
+var activeModel struct {
+	sync.Mutex
+	version string // explicit override set by promotion; empty means use the default
+}
+
+// modelDirs are the directories holding model files, in order of precedence
+func modelDirs() []string {
+	return []string{pulledModelsDir(), config().ModelDir}
+}
+
+// modelPath returns the on-disk path for a model version; a version that does
+// not exist yet maps to its .pt file in MODEL_DIR
+func modelPath(version string) string {
+	for _, dir := range modelDirs() {
+		for _, ext := range modelExtensions {
+			p := filepath.Join(dir, version+ext)
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return filepath.Join(config().ModelDir, version+".pt")
+}
+
+// modelExists reports whether the model file for version is present
+func modelExists(version string) bool {
+	if version == "" || strings.ContainsAny(version, `/\`) {
+		return false
+	}
+	_, err := os.Stat(modelPath(version))
+	return err == nil
+}
+
+// listModelVersions returns all model versions available in MODEL_DIR
+func listModelVersions() []string {
+	seen := map[string]bool{}
+	var versions []string
+	for _, dir := range modelDirs() {
+		for _, ext := range modelExtensions {
+			matches, _ := filepath.Glob(filepath.Join(dir, "*"+ext))
+			for _, m := range matches {
+				if v := strings.TrimSuffix(filepath.Base(m), ext); !seen[v] {
+					seen[v] = true
+					versions = append(versions, v)
+				}
+			}
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// activeModelVersion returns the model version serving regular traffic.
+// A promoted version takes precedence, then production.pt, then the yolov8n base model.
+func activeModelVersion() string {
+	activeModel.Lock()
+	override := activeModel.version
+	activeModel.Unlock()
+
+	if override != "" && modelExists(override) {
+		return override
+	}
+	if modelExists("production") {
+		return "production"
+	}
+	return "yolov8n"
+}
+
+// setActiveModelVersion switches regular traffic to version and persists the choice
+func setActiveModelVersion(version string) error {
+	if !modelExists(version) {
+		return fmt.Errorf("model %q not found in %s", version, config().ModelDir)
+	}
+	if err := checkModelSignature(version, "promotion"); err != nil {
+		return err
+	}
+
+	previous := activeModelVersion()
+	activeModel.Lock()
+	activeModel.version = version
+	activeModel.Unlock()
+	if version != previous {
+		bus.publish(eventModelActivated, ModelEvent{Version: version, Previous: previous})
+	}
+
+	data, _ := json.Marshal(map[string]string{"version": version})
+	if err := os.MkdirAll(config().StateDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config().StateDir, "active-model.json"), data, 0644)
+}
+
+// loadActiveModelVersion restores a previously promoted model version from the state dir
+func loadActiveModelVersion() {
+	data, err := os.ReadFile(filepath.Join(config().StateDir, "active-model.json"))
+	if err != nil {
+		return
+	}
+	var saved struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: ignoring corrupt active model state: %v", err)
+		return
+	}
+	activeModel.Lock()
+	activeModel.version = saved.Version
+	activeModel.Unlock()
+	log.Printf("Restored active model version: %s", saved.Version)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Model files can be signed so a node only runs models from a trusted
+// pipeline. A model's signature sits next to it as <model file>.sig, holding
+// the base64 signature of the file, as written by
+//
+//	cosign sign-blob --key cosign.key --output-signature production.pt.sig production.pt
+//
+// MODEL_SIGNING_KEYS lists the trusted public keys, comma-separated: PEM files
+// (cosign.pub; ECDSA P-256 or Ed25519) or base64 raw Ed25519 keys. Several keys
+// allow rotation. MODEL_SIGNATURE_MODE decides what happens to a model that is
+// unsigned or fails verification:
+//
+//	off     signatures are not checked (the default without keys)
+//	warn    the model is used and a warning logged (the default with keys)
+//	strict  the model is refused: not loaded for inference, started as a
+//	        canary or promoted
+//
+// Verification results are cached until the model file or its signature
+// changes. Fleet configuration bundles are always signed; see fleet.go.
+
+// Signature modes
+const (
+	signatureOff    = "off"
+	signatureWarn   = "warn"
+	signatureStrict = "strict"
+)
+
+// Signature check outcomes
+const (
+	signatureVerified  = "verified"
+	signatureUnsigned  = "unsigned"
+	signatureInvalid   = "invalid"
+	signatureUnchecked = "unchecked"
+)
+
+var modelVerifications = newCounterVec("yolo_model_signature_checks_total",
+	"Model signature checks by outcome.", "status")
+
+type signatureEntry struct {
+	keys              string
+	size, sigSize     int64
+	modTime, sigMTime time.Time
+	status            string
+	err               error
+	warned            bool // warn mode logged this outcome
+}
+
+var signatureCache = struct {
+	sync.Mutex
+	entries map[string]signatureEntry
+}{entries: map[string]signatureEntry{}}
+
+// parseSigningKeys reads a list of public keys in the MODEL_SIGNING_KEYS
+// format; setting names it in errors
+func parseSigningKeys(setting, list string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, entry := range splitList(list) {
+		data, err := os.ReadFile(entry)
+		if err != nil {
+			raw, decodeErr := base64.StdEncoding.DecodeString(entry)
+			if decodeErr != nil || len(raw) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%s: %s is neither a readable key file nor a base64 Ed25519 key", setting, entry)
+			}
+			keys = append(keys, ed25519.PublicKey(raw))
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: %s is not a PEM public key", setting, entry)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", setting, entry, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("%s: %s: unsupported key type %T", setting, entry, key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// signatureMode returns MODEL_SIGNATURE_MODE with its default applied
+func signatureMode(cfg *Config) string {
+	if cfg.ModelSignatureMode != "" {
+		return cfg.ModelSignatureMode
+	}
+	if cfg.ModelSigningKeys != "" {
+		return signatureWarn
+	}
+	return signatureOff
+}
+
+// validateSigning checks the model signing settings
+func validateSigning(cfg *Config) error {
+	switch signatureMode(cfg) {
+	case signatureOff, signatureWarn, signatureStrict:
+	default:
+		return fmt.Errorf("MODEL_SIGNATURE_MODE must be off, warn or strict")
+	}
+	if signatureMode(cfg) != signatureOff && cfg.ModelSigningKeys == "" {
+		return fmt.Errorf("MODEL_SIGNATURE_MODE %s needs MODEL_SIGNING_KEYS", signatureMode(cfg))
+	}
+	_, err := parseSigningKeys("MODEL_SIGNING_KEYS", cfg.ModelSigningKeys)
+	return err
+}
+
+// modelSignature checks the signature of a model version, returning its
+// status and, unless it verified, why
+func modelSignature(version string) (string, error) {
+	cfg := config()
+	if signatureMode(cfg) == signatureOff {
+		return signatureUnchecked, nil
+	}
+	path := modelPath(version)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return signatureInvalid, err
+	}
+	var sigSize int64
+	var sigMTime time.Time
+	if sfi, err := os.Stat(path + ".sig"); err == nil {
+		sigSize, sigMTime = sfi.Size(), sfi.ModTime()
+	}
+
+	signatureCache.Lock()
+	e, ok := signatureCache.entries[path]
+	signatureCache.Unlock()
+	if ok && e.keys == cfg.ModelSigningKeys && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) &&
+		e.sigSize == sigSize && e.sigMTime.Equal(sigMTime) {
+		return e.status, e.err
+	}
+
+	status, err := verifyModelFile(path, cfg.ModelSigningKeys)
+	modelVerifications.inc(status)
+	signatureCache.Lock()
+	signatureCache.entries[path] = signatureEntry{
+		keys: cfg.ModelSigningKeys, size: fi.Size(), modTime: fi.ModTime(),
+		sigSize: sigSize, sigMTime: sigMTime, status: status, err: err,
+	}
+	signatureCache.Unlock()
+	return status, err
+}
+
+func verifyModelFile(path, keyList string) (string, error) {
+	keys, err := parseSigningKeys("MODEL_SIGNING_KEYS", keyList)
+	if err != nil {
+		return signatureInvalid, err
+	}
+	sigData, err := os.ReadFile(path + ".sig")
+	if os.IsNotExist(err) {
+		return signatureUnsigned, fmt.Errorf("no signature file %s.sig", path)
+	}
+	if err != nil {
+		return signatureInvalid, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil {
+		return signatureInvalid, fmt.Errorf("signature is not base64")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signatureInvalid, err
+	}
+	if verifySignature(keys, data, sig) {
+		return signatureVerified, nil
+	}
+	return signatureInvalid, fmt.Errorf("signature does not match any of MODEL_SIGNING_KEYS")
+}
+
+// verifySignature reports whether sig is a signature of data by one of keys
+func verifySignature(keys []crypto.PublicKey, data, sig []byte) bool {
+	digest := sha256.Sum256(data)
+	for _, key := range keys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, data, sig) {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkModelSignature applies MODEL_SIGNATURE_MODE to a model about to be used
+// for action ("inference", "canary", "promotion"): in strict mode an unverified
+// model is an error, in warn mode it is logged once per model file
+func checkModelSignature(version, action string) error {
+	status, err := modelSignature(version)
+	if status == signatureVerified || status == signatureUnchecked {
+		return nil
+	}
+	if signatureMode(config()) == signatureStrict {
+		return fmt.Errorf("model %q refused for %s: %v", version, action, err)
+	}
+	signatureCache.Lock()
+	e, ok := signatureCache.entries[modelPath(version)]
+	first := ok && !e.warned
+	if first {
+		e.warned = true
+		signatureCache.entries[modelPath(version)] = e
+	}
+	signatureCache.Unlock()
+	if first {
+		log.Printf("Warning: model %q used for %s without a valid signature: %v", version, action, err)
+	}
+	return nil
+}
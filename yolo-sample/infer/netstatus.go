@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Online/offline gates training, syncing, fleet and model pulls. In
+// Kubernetes it is a node label read with kubectl; elsewhere one of the other
+// providers stands in. NETWORK_STATUS_PROVIDER picks one:
+//
+//	kubernetes  the NODE_LABEL_KEY label of node NODE_NAME (kubectl)
+//	file        the first line of NETWORK_STATUS_FILE, e.g. written by a
+//	            NetworkManager dispatcher script; a missing file is "unknown"
+//	probe       "online" while NETWORK_PROBE_URL answers (any status below
+//	            500), else "offline"; checked at most every NETWORK_PROBE_INTERVAL
+//	static      NETWORK_STATUS, for nodes that are always on one side
+//	auto        (default) kubernetes with NODE_NAME and NODE_LABEL_KEY set,
+//	            otherwise file or probe, whichever is configured
+//
+// Statuses other than "online" never enable gated work.
+//
+// When kubectl cannot reach the API server, as happens whenever the site is
+// cut off from the control plane, the kubernetes provider keeps reporting
+// the last label it read, marked stale since the first failed read (the UI
+// and /api/v1/system show when), for up to NETWORK_STATUS_MAX_STALE
+// (default 1h; 0 keeps it for good) before it gives up and reports
+// "unknown". Meanwhile kubectl is retried at most every 15 seconds, so pages
+// are not held up by a control plane that does not answer.
+
+// Network status providers
+const (
+	networkProviderAuto       = "auto"
+	networkProviderKubernetes = "kubernetes"
+	networkProviderFile       = "file"
+	networkProviderProbe      = "probe"
+	networkProviderStatic     = "static"
+)
+
+// nodeLabelRetry spaces the kubectl calls while the API server is unreachable
+const nodeLabelRetry = 15 * time.Second
+
+var nodeLabel = struct {
+	sync.Mutex
+	status     string    // last label read
+	staleSince time.Time // first failed read since the last good one
+	retryAt    time.Time
+}{}
+
+var networkProbeClient = &http.Client{Timeout: 5 * time.Second}
+
+var networkProbe = struct {
+	sync.Mutex
+	status    string
+	checkedAt time.Time
+}{}
+
+// networkProvider resolves NETWORK_STATUS_PROVIDER=auto
+func networkProvider(cfg *Config) string {
+	if cfg.NetworkStatusProvider != networkProviderAuto {
+		return cfg.NetworkStatusProvider
+	}
+	switch {
+	case os.Getenv("NODE_NAME") != "" && os.Getenv("NODE_LABEL_KEY") != "":
+		return networkProviderKubernetes
+	case cfg.NetworkStatusFile != "":
+		return networkProviderFile
+	case cfg.NetworkProbeURL != "":
+		return networkProviderProbe
+	}
+	return networkProviderKubernetes
+}
+
+// networkStatus returns "online", "offline" or another status from the
+// configured provider, and since when it is stale if it could not be
+// refreshed
+func networkStatus() (string, time.Time) {
+	if status, ok := injectedNetworkStatus(); ok {
+		return status, time.Time{}
+	}
+	cfg := config()
+	switch networkProvider(cfg) {
+	case networkProviderFile:
+		return fileNetworkStatus(cfg.NetworkStatusFile), time.Time{}
+	case networkProviderProbe:
+		return probeNetworkStatus(cfg), time.Time{}
+	case networkProviderStatic:
+		return cfg.NetworkStatus, time.Time{}
+	}
+	return informedNetworkStatus(cfg)
+}
+
+// informedNetworkStatus reads the node label, standing in the last one read
+// while the API server cannot be reached
+func informedNetworkStatus(cfg *Config) (string, time.Time) {
+	nodeLabel.Lock()
+	defer nodeLabel.Unlock()
+	now := time.Now()
+	if nodeLabel.staleSince.IsZero() || !now.Before(nodeLabel.retryAt) {
+		status, err := kubernetesNetworkStatus()
+		if err == nil {
+			if !nodeLabel.staleSince.IsZero() {
+				log.Printf("Node status is current again after %s", now.Sub(nodeLabel.staleSince).Round(time.Second))
+			}
+			nodeLabel.status, nodeLabel.staleSince = status, time.Time{}
+			return status, time.Time{}
+		}
+		if nodeLabel.staleSince.IsZero() {
+			nodeLabel.staleSince = now
+			if nodeLabel.status != "" {
+				log.Printf("Warning: API server unreachable; keeping node status %s until it answers", nodeLabel.status)
+			}
+		}
+		nodeLabel.retryAt = now.Add(nodeLabelRetry)
+	}
+	if nodeLabel.status == "" || (cfg.NetworkStatusMaxStale > 0 && now.Sub(nodeLabel.staleSince) > cfg.NetworkStatusMaxStale) {
+		return "unknown", time.Time{}
+	}
+	return nodeLabel.status, nodeLabel.staleSince
+}
+
+// staleSinceJSON is t for API responses, nil while the status is current
+func staleSinceJSON(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+func fileNetworkStatus(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "unknown"
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	if status := strings.TrimSpace(line); status != "" {
+		return status
+	}
+	return "unknown"
+}
+
+// probeNetworkStatus probes NETWORK_PROBE_URL, reusing a recent answer
+func probeNetworkStatus(cfg *Config) string {
+	networkProbe.Lock()
+	defer networkProbe.Unlock()
+	if networkProbe.status != "" && time.Since(networkProbe.checkedAt) < cfg.NetworkProbeInterval {
+		return networkProbe.status
+	}
+	status := "offline"
+	if resp, err := networkProbeClient.Head(cfg.NetworkProbeURL); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 500 {
+			status = "online"
+		}
+	}
+	networkProbe.status, networkProbe.checkedAt = status, time.Now()
+	return status
+}
+
+// validateNetworkStatus checks the network status provider settings
+func validateNetworkStatus(cfg *Config) error {
+	switch cfg.NetworkStatusProvider {
+	case networkProviderAuto, networkProviderKubernetes:
+	case networkProviderFile:
+		if cfg.NetworkStatusFile == "" {
+			return fmt.Errorf("NETWORK_STATUS_PROVIDER file needs NETWORK_STATUS_FILE")
+		}
+	case networkProviderProbe:
+		if u, err := url.Parse(cfg.NetworkProbeURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("NETWORK_STATUS_PROVIDER probe needs NETWORK_PROBE_URL, an http:// or https:// URL")
+		}
+	case networkProviderStatic:
+		if cfg.NetworkStatus == "" {
+			return fmt.Errorf("NETWORK_STATUS_PROVIDER static needs NETWORK_STATUS")
+		}
+	default:
+		return fmt.Errorf("NETWORK_STATUS_PROVIDER must be auto, kubernetes, file, probe or static")
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Images are kept once, by content, in the object store:
+// STATE_DIR/objects/<first two hex digits>/<sha256><ext>. However many
+// results and traffic recordings hold a frame (a camera watching an empty
+// scene, an image uploaded twice, a recorded frame and its result) it takes
+// one copy, and its derivatives (see images.go) are made once. The
+// extension is part of the name because the inference backend and browsers
+// go by it. Model blobs pulled from registries are content-addressed the
+// same way in STATE_DIR/oci/blobs (see oci.go).
+//
+// References are not counted as objects are stored but by the storage GC
+// (SCHEDULE_STORAGE_GC, 03:45 daily, after the retention sweep), which
+// counts the references to every object from results, recordings and pulled
+// models, then deletes the objects nothing holds along with their
+// derivatives. Objects younger than objectGrace are spared, as the result
+// holding a new one may not be saved yet; storing an object that already
+// exists renews it. Images of results stored before the object store stay
+// where they are until the retention sweep removes them.
+//
+//	GET /api/v1/storage  objects, bytes and references by holder, and what deduplication saved
+
+// objectGrace is how long an unreferenced object is kept
+const objectGrace = time.Hour
+
+// objectName matches the names of stored objects
+var objectName = regexp.MustCompile(`^[0-9a-f]{64}(\.[0-9a-z]+)?$`)
+
+// StorageStats describes the objects of one store
+type StorageStats struct {
+	Objects    int   `json:"objects"`
+	Bytes      int64 `json:"bytes"`
+	Shared     int   `json:"shared"`      // objects with more than one reference
+	SavedBytes int64 `json:"saved_bytes"` // bytes extra copies would take
+}
+
+// StorageGC is the outcome of a storage GC run
+type StorageGC struct {
+	At         time.Time `json:"at"`
+	Deleted    int       `json:"deleted"`
+	FreedBytes int64     `json:"freed_bytes"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// StorageReport is returned by /api/v1/storage
+type StorageReport struct {
+	Images     StorageStats   `json:"images"`
+	ModelBlobs StorageStats   `json:"model_blobs"`
+	References map[string]int `json:"references"` // by holder: results, recordings, models
+	LastGC     *StorageGC     `json:"last_gc,omitempty"`
+}
+
+var storage = struct {
+	sync.Mutex
+	lastGC *StorageGC
+}{}
+
+var objectsDeleted = newCounterVec("yolo_storage_objects_deleted_total",
+	"Unreferenced objects deleted by the storage GC, by store.", "store")
+
+func objectsDir() string {
+	return filepath.Join(config().StateDir, "objects")
+}
+
+// isObjectName reports whether a stored image name is an object's
+func isObjectName(name string) bool {
+	return objectName.MatchString(name)
+}
+
+func objectPath(name string) string {
+	return filepath.Join(objectsDir(), name[:2], name)
+}
+
+// storeObject adds the file at path to the object store and returns its
+// object name; move lets the file itself become the object
+func storeObject(path string, move bool) (string, error) {
+	if encryptionOn() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		name, err := storeObjectData(data, filepath.Ext(path))
+		if err == nil && move {
+			os.Remove(path)
+		}
+		return name, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(h.Sum(nil)) + strings.ToLower(filepath.Ext(path))
+	dst := objectPath(name)
+	if renewObject(dst) {
+		if move {
+			os.Remove(path)
+		}
+		return name, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	if move && os.Rename(path, dst) == nil {
+		return name, nil
+	}
+	tmp := dst + ".tmp"
+	if err := copyFile(path, tmp); err != nil {
+		return "", err
+	}
+	if move {
+		os.Remove(path)
+	}
+	return name, os.Rename(tmp, dst)
+}
+
+// storeObjectData adds data to the object store as an object with
+// extension ext, encrypting it with a key
+func storeObjectData(data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + strings.ToLower(ext)
+	dst := objectPath(name)
+	if renewObject(dst) {
+		return name, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	return name, writeStoredFile(dst, data)
+}
+
+// renewObject reports whether the object at path exists, sparing it from
+// the storage GC for another objectGrace if so
+func renewObject(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// storeImageObject encodes img as JPEG into the object store
+func storeImageObject(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return "", err
+	}
+	return storeObjectData(buf.Bytes(), ".jpg")
+}
+
+// migrateRecordingImages moves the images of traffic recordings made before
+// the object store into it; their names are already object names
+func migrateRecordingImages() {
+	dir := filepath.Join(recordingsDir(), "images")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	moved := 0
+	for _, e := range entries {
+		if !isObjectName(e.Name()) {
+			continue
+		}
+		dst := objectPath(e.Name())
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			log.Printf("Warning: cannot move recorded images: %v", err)
+			return
+		}
+		if err := os.Rename(filepath.Join(dir, e.Name()), dst); err != nil {
+			if copyFile(filepath.Join(dir, e.Name()), dst) != nil {
+				continue
+			}
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+		moved++
+	}
+	os.Remove(dir)
+	if moved > 0 {
+		log.Printf("Moved %d recorded images into the object store", moved)
+	}
+}
+
+// objectReferences counts the references to each image object and model
+// blob, and the references by holder
+func objectReferences() (images, blobs, holders map[string]int) {
+	images, blobs, holders = map[string]int{}, map[string]int{}, map[string]int{}
+	for _, res := range results.list(0) {
+		if isObjectName(res.StoredImage) {
+			images[res.StoredImage]++
+			holders["results"]++
+		}
+	}
+	recordings.Lock()
+	loadRecordingsLocked()
+	for _, rec := range recordings.records {
+		images[rec.Image+rec.Ext]++
+		holders["recordings"]++
+	}
+	recordings.Unlock()
+	pulledModels.Lock()
+	loadPulledModelsLocked()
+	for _, m := range pulledModels.byName {
+		for _, digest := range []string{m.Layer, m.Signature} {
+			if digest != "" {
+				blobs[digest]++
+				holders["models"]++
+			}
+		}
+	}
+	pulledModels.Unlock()
+	return images, blobs, holders
+}
+
+// storedObject is a file of a store, by the reference it is known by
+type storedObject struct {
+	ref  string
+	path string
+	info os.FileInfo
+}
+
+// listImageObjects returns the objects of the object store
+func listImageObjects() []storedObject {
+	var out []storedObject
+	dirs, _ := os.ReadDir(objectsDir())
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entries, _ := os.ReadDir(filepath.Join(objectsDir(), d.Name()))
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil && isObjectName(e.Name()) {
+				out = append(out, storedObject{e.Name(), filepath.Join(objectsDir(), d.Name(), e.Name()), info})
+			}
+		}
+	}
+	return out
+}
+
+// listModelBlobs returns the blobs of the model blob cache
+func listModelBlobs() []storedObject {
+	var out []storedObject
+	dir := filepath.Join(config().StateDir, "oci", "blobs", "sha256")
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		// Blobs have no extension; a .tmp file is a download in progress
+		if info, err := e.Info(); err == nil && objectName.MatchString(e.Name()) && filepath.Ext(e.Name()) == "" {
+			out = append(out, storedObject{"sha256:" + e.Name(), filepath.Join(dir, e.Name()), info})
+		}
+	}
+	return out
+}
+
+// storageStats sums up the objects of a store given their references
+func storageStats(objects []storedObject, refs map[string]int) StorageStats {
+	var s StorageStats
+	for _, o := range objects {
+		s.Objects++
+		s.Bytes += o.info.Size()
+		if n := refs[o.ref]; n > 1 {
+			s.Shared++
+			s.SavedBytes += int64(n-1) * o.info.Size()
+		}
+	}
+	return s
+}
+
+// runStorageGC deletes the objects and model blobs nothing references
+func runStorageGC() error {
+	start := time.Now()
+	images, blobs, _ := objectReferences()
+	run := StorageGC{At: start.UTC()}
+	live := map[string]bool{}
+	for _, store := range []struct {
+		name    string
+		objects []storedObject
+		refs    map[string]int
+	}{{"images", listImageObjects(), images}, {"model_blobs", listModelBlobs(), blobs}} {
+		for _, o := range store.objects {
+			if store.refs[o.ref] > 0 || start.Sub(o.info.ModTime()) < objectGrace {
+				live[strings.TrimSuffix(o.ref, filepath.Ext(o.ref))] = true
+				continue
+			}
+			if err := os.Remove(o.path); err != nil {
+				log.Printf("Warning: storage GC cannot delete %s: %v", o.path, err)
+				continue
+			}
+			objectsDeleted.inc(store.name)
+			run.Deleted++
+			run.FreedBytes += o.info.Size()
+		}
+	}
+	// Derivatives are named after the hash of their object
+	derived, _ := os.ReadDir(derivativesDir())
+	for _, e := range derived {
+		hash, _, _ := strings.Cut(e.Name(), ".")
+		if info, err := e.Info(); err == nil && !live[hash] && start.Sub(info.ModTime()) >= objectGrace {
+			if os.Remove(filepath.Join(derivativesDir(), e.Name())) == nil {
+				run.FreedBytes += info.Size()
+			}
+		}
+	}
+	run.DurationMS = millis(time.Since(start))
+	storage.Lock()
+	storage.lastGC = &run
+	storage.Unlock()
+	log.Printf("Storage GC: deleted %d unreferenced objects, freeing %d bytes", run.Deleted, run.FreedBytes)
+	return nil
+}
+
+// storageHandler serves GET /api/v1/storage
+func storageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	images, blobs, holders := objectReferences()
+	report := StorageReport{
+		Images:     storageStats(listImageObjects(), images),
+		ModelBlobs: storageStats(listModelBlobs(), blobs),
+		References: holders,
+	}
+	storage.Lock()
+	report.LastGC = storage.lastGC
+	storage.Unlock()
+	writeJSON(w, http.StatusOK, report)
+}
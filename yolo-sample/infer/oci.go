@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Models can be pulled from a container registry, packaged as OCI artifacts
+// (for example pushed with `oras push ghcr.io/acme/models/yolo:v3 production.pt`).
+// MODEL_OCI_REFS maps model versions to references, comma-separated:
+//
+//	production=ghcr.io/acme/models/yolo:v3,v2=ghcr.io/acme/models/yolo@sha256:4f1c...
+//
+// On the model-pull schedule (SCHEDULE_MODEL_PULL, every 30 minutes when
+// MODEL_OCI_REFS is set, and once at startup) an online node resolves each
+// reference. A digest pins the manifest, which is checked against it; a tag
+// is followed. The layer whose title annotation is a model file is downloaded,
+// checked against its digest and kept in STATE_DIR/oci/blobs, so an unchanged
+// tag costs one manifest request and an artifact shared by several versions is
+// fetched once. The storage GC drops blobs no pulled model uses any more (see
+// objects.go). A <model file>.sig layer is installed with the model for
+// signature checks (see modelsign.go), and deltas against earlier layers
+// spare a full download (see delta.go).
+//
+// Pulled models are installed as STATE_DIR/models/<version><ext> and take
+// precedence over MODEL_DIR. Registry credentials come from a Docker config
+// file, the format of Kubernetes image pull secrets (.dockerconfigjson):
+// REGISTRY_AUTH_FILE, else $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+// Registries listed in REGISTRY_PLAIN_HTTP are spoken to without TLS. Blobs
+// are asked of sibling nodes first when MODEL_PEERS is set (see peers.go).
+
+// ociManifestMaxBytes bounds the size of a manifest
+const ociManifestMaxBytes = 4 << 20
+
+// ociTitleAnnotation carries the file name of an ORAS layer
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+var ociAccept = strings.Join([]string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociRef is a parsed registry reference
+type ociRef struct {
+	registry, repository, tag, digest string
+}
+
+func (r ociRef) String() string {
+	s := r.registry + "/" + r.repository
+	if r.tag != "" {
+		s += ":" + r.tag
+	}
+	if r.digest != "" {
+		s += "@" + r.digest
+	}
+	return s
+}
+
+// PulledModel records where an installed model version came from
+type PulledModel struct {
+	Version   string    `json:"version"`
+	Ref       string    `json:"ref"`
+	Manifest  string    `json:"manifest_digest"`
+	Layer     string    `json:"layer_digest"`
+	Signature string    `json:"signature_digest,omitempty"`
+	DeltaFrom string    `json:"delta_from,omitempty"` // layer the model was patched from
+	File      string    `json:"file"`
+	PulledAt  time.Time `json:"pulled_at"`
+}
+
+var pulledModels = struct {
+	sync.Mutex
+	loaded bool
+	byName map[string]PulledModel
+}{byName: map[string]PulledModel{}}
+
+var ociClient = &http.Client{Timeout: 10 * time.Minute}
+
+var modelPulls = newCounterVec("yolo_model_pulls_total",
+	"Model pulls from OCI registries by outcome.", "status")
+
+func pulledModelsDir() string {
+	return filepath.Join(config().StateDir, "models")
+}
+
+// ociBlobPath is where a blob is cached; digest must match ociDigest
+func ociBlobPath(digest string) string {
+	return filepath.Join(config().StateDir, "oci", "blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func pulledModelsStatePath() string {
+	return filepath.Join(config().StateDir, "oci", "models.json")
+}
+
+// parseOCIRef parses registry/repository[:tag][@sha256:digest]; Docker Hub
+// references need the docker.io prefix
+func parseOCIRef(s string) (ociRef, error) {
+	var r ociRef
+	rest := s
+	if at := strings.Index(rest, "@"); at >= 0 {
+		r.digest, rest = rest[at+1:], rest[:at]
+		if !strings.HasPrefix(r.digest, "sha256:") || len(r.digest) != len("sha256:")+64 {
+			return r, fmt.Errorf("%s: only sha256 digests are supported", s)
+		}
+	}
+	slash := strings.Index(rest, "/")
+	if slash < 0 || !strings.ContainsAny(rest[:slash], ".:") && rest[:slash] != "localhost" {
+		return r, fmt.Errorf("%s: reference must start with a registry host", s)
+	}
+	r.registry, rest = rest[:slash], rest[slash+1:]
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		r.tag, rest = rest[colon+1:], rest[:colon]
+	}
+	r.repository = rest
+	if r.repository == "" {
+		return r, fmt.Errorf("%s: no repository", s)
+	}
+	if r.tag == "" && r.digest == "" {
+		r.tag = "latest"
+	}
+	if r.registry == "docker.io" && !strings.Contains(r.repository, "/") {
+		r.repository = "library/" + r.repository
+	}
+	return r, nil
+}
+
+// parseModelRefs reads MODEL_OCI_REFS into references by model version
+func parseModelRefs(list string) (map[string]ociRef, error) {
+	out := map[string]ociRef{}
+	for _, pair := range splitList(list) {
+		version, ref, ok := strings.Cut(pair, "=")
+		version = strings.TrimSpace(version)
+		if !ok || version == "" || strings.ContainsAny(version, `/\`) {
+			return nil, fmt.Errorf("MODEL_OCI_REFS: invalid entry %q", pair)
+		}
+		r, err := parseOCIRef(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, fmt.Errorf("MODEL_OCI_REFS: %v", err)
+		}
+		out[version] = r
+	}
+	return out, nil
+}
+
+// loadPulledModelsLocked reads the record of installed pulls, once
+func loadPulledModelsLocked() {
+	if pulledModels.loaded {
+		return
+	}
+	pulledModels.loaded = true
+	data, err := os.ReadFile(pulledModelsStatePath())
+	if err != nil {
+		return
+	}
+	var list []PulledModel
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: ignoring corrupt pulled model state: %v", err)
+		return
+	}
+	for _, m := range list {
+		pulledModels.byName[m.Version] = m
+	}
+}
+
+// pulledModel returns the pull record of a model version, if it was pulled
+func pulledModel(version string) (PulledModel, bool) {
+	pulledModels.Lock()
+	defer pulledModels.Unlock()
+	loadPulledModelsLocked()
+	m, ok := pulledModels.byName[version]
+	return m, ok
+}
+
+func recordPulledModel(m PulledModel) error {
+	pulledModels.Lock()
+	defer pulledModels.Unlock()
+	loadPulledModelsLocked()
+	pulledModels.byName[m.Version] = m
+	list := make([]PulledModel, 0, len(pulledModels.byName))
+	for _, p := range pulledModels.byName {
+		list = append(list, p)
+	}
+	data, _ := json.MarshalIndent(list, "", "  ")
+	return writeFileAtomic(pulledModelsStatePath(), data)
+}
+
+// pullModels pulls every model of MODEL_OCI_REFS, continuing past failures
+func pullModels() error {
+	cfg := config()
+	refs, err := parseModelRefs(cfg.ModelOCIRefs)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("MODEL_OCI_REFS is not configured")
+	}
+	if status := getNodeStatus(); status.NetworkStatus != "online" {
+		return fmt.Errorf("skipping model pull: network status is %s", status.NetworkStatus)
+	}
+	var failed []string
+	for version, ref := range refs {
+		if err := pullModel(version, ref); err != nil {
+			modelPulls.inc("error")
+			log.Printf("Warning: pulling model %s from %s failed: %v", version, ref, err)
+			failed = append(failed, version)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to pull %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func pullModel(version string, ref ociRef) error {
+	reg := newRegistryClient(ref)
+	manifestBody, manifestDigest, err := reg.manifest()
+	if err != nil {
+		return err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(manifestBody, &m); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	// Digests become paths in the blob cache, so check them all up front
+	for _, l := range m.Layers {
+		if !ociDigest.MatchString(l.Digest) {
+			return fmt.Errorf("unsupported layer digest %q", l.Digest)
+		}
+	}
+	var model, sig *ociDescriptor
+	var deltas []ociDescriptor
+	for i, l := range m.Layers {
+		title := l.Annotations[ociTitleAnnotation]
+		switch {
+		case isDeltaLayer(title):
+			deltas = append(deltas, l)
+		case strings.HasSuffix(title, ".sig"):
+			sig = &m.Layers[i]
+		case model == nil && contains(modelExtensions, strings.ToLower(filepath.Ext(title))):
+			model = &m.Layers[i]
+		}
+	}
+	if model == nil {
+		return fmt.Errorf("no layer titled as a %s file in %s", strings.Join(modelExtensions, ", "), ref)
+	}
+	ext := strings.ToLower(filepath.Ext(model.Annotations[ociTitleAnnotation]))
+	dst := filepath.Join(pulledModelsDir(), version+ext)
+
+	prev, pulled := pulledModel(version)
+	if pulled && prev.Manifest == manifestDigest && prev.File == filepath.Base(dst) {
+		if _, err := os.Stat(dst); err == nil {
+			modelPulls.inc("unchanged")
+			return nil
+		}
+	}
+	deltaBase, err := reg.fetchModelBlob(version, *model, deltas)
+	if err != nil {
+		return err
+	}
+	if sig != nil {
+		if err := reg.fetchBlob(*sig); err != nil {
+			return err
+		}
+	}
+
+	if err := installBlob(*model, dst); err != nil {
+		return err
+	}
+	os.Remove(dst + ".sig")
+	if sig != nil {
+		if err := installBlob(*sig, dst+".sig"); err != nil {
+			return err
+		}
+	}
+	// A version changing format leaves its old file behind; drop it
+	for _, other := range modelExtensions {
+		if other != ext {
+			os.Remove(filepath.Join(pulledModelsDir(), version+other))
+			os.Remove(filepath.Join(pulledModelsDir(), version+other+".sig"))
+		}
+	}
+	modelPulls.inc("pulled")
+	log.Printf("Pulled model %s from %s (%s)", version, ref, manifestDigest)
+	pm := PulledModel{
+		Version: version, Ref: ref.String(), Manifest: manifestDigest,
+		Layer: model.Digest, DeltaFrom: deltaBase, File: filepath.Base(dst), PulledAt: time.Now().UTC(),
+	}
+	if sig != nil {
+		pm.Signature = sig.Digest
+	}
+	return recordPulledModel(pm)
+}
+
+// installBlob places a cached blob at dst, linking it when the filesystem allows
+func installBlob(d ociDescriptor, dst string) error {
+	if !ociDigest.MatchString(d.Digest) {
+		return fmt.Errorf("unsupported layer digest %q", d.Digest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(ociBlobPath(d.Digest), tmp); err != nil {
+		if err := copyFile(ociBlobPath(d.Digest), tmp); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, dst)
+}
+
+// registryClient speaks the OCI distribution API to one repository
+type registryClient struct {
+	ref   ociRef
+	base  string
+	auth  string // Authorization header for the registry
+	basic string // base64 user:password from the auth file, if any
+}
+
+func newRegistryClient(ref ociRef) *registryClient {
+	scheme := "https"
+	if contains(splitList(config().RegistryPlainHTTP), ref.registry) {
+		scheme = "http"
+	}
+	host := ref.registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return &registryClient{ref: ref, base: scheme + "://" + host + "/v2/" + ref.repository, basic: registryCredentials(ref.registry)}
+}
+
+// registryCredentials finds the base64 user:password for a registry in the
+// Docker config file
+func registryCredentials(registry string) string {
+	path := config().RegistryAuthFile
+	if path == "" {
+		if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+			path = filepath.Join(dir, "config.json")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".docker", "config.json")
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var file struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		log.Printf("Warning: unreadable registry auth file %s: %v", path, err)
+		return ""
+	}
+	for key, a := range file.Auths {
+		host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://"), "/")
+		host, _, _ = strings.Cut(host, "/")
+		if host == registry || (registry == "docker.io" && host == "index.docker.io") {
+			if a.Auth != "" {
+				return a.Auth
+			}
+			if a.Username != "" {
+				return base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password))
+			}
+		}
+	}
+	return ""
+}
+
+// do sends a request, answering one authentication challenge
+func (c *registryClient) do(method, u string, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := ociClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate answers a Basic or Bearer challenge
+func (c *registryClient) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.basic == "" {
+			return fmt.Errorf("registry %s needs credentials", c.ref.registry)
+		}
+		c.auth = "Basic " + c.basic
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	p := map[string]string{}
+	for _, kv := range strings.Split(params, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(kv), "="); ok {
+			p[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	if p["realm"] == "" {
+		return fmt.Errorf("registry challenge has no realm")
+	}
+	q := url.Values{}
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+	scope := p["scope"]
+	if scope == "" {
+		scope = "repository:" + c.ref.repository + ":pull"
+	}
+	q.Set("scope", scope)
+	req, err := http.NewRequest(http.MethodGet, p["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.basic != "" {
+		req.Header.Set("Authorization", "Basic "+c.basic)
+	}
+	resp, err := ociClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("registry token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry token request returned %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return fmt.Errorf("invalid registry token response: %v", err)
+	}
+	if tok.Token == "" {
+		tok.Token = tok.AccessToken
+	}
+	c.auth = "Bearer " + tok.Token
+	return nil
+}
+
+// manifest fetches the manifest of the reference, returning it and its digest
+func (c *registryClient) manifest() ([]byte, string, error) {
+	target := c.ref.tag
+	if c.ref.digest != "" {
+		target = c.ref.digest
+	}
+	resp, err := c.do(http.MethodGet, c.base+"/manifests/"+target, http.Header{"Accept": {ociAccept}})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("manifest request returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ociManifestMaxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > ociManifestMaxBytes {
+		return nil, "", fmt.Errorf("manifest exceeds %d bytes", ociManifestMaxBytes)
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if c.ref.digest != "" && digest != c.ref.digest {
+		return nil, "", fmt.Errorf("manifest digest %s does not match pinned %s", digest, c.ref.digest)
+	}
+	return body, digest, nil
+}
+
+// fetchBlob downloads a layer into the blob cache unless it is already there
+func (c *registryClient) fetchBlob(d ociDescriptor) error {
+	if !ociDigest.MatchString(d.Digest) {
+		return fmt.Errorf("unsupported layer digest %q", d.Digest)
+	}
+	path := ociBlobPath(d.Digest)
+	if fi, err := os.Stat(path); err == nil && fi.Size() == d.Size {
+		return nil
+	}
+	if fetchBlobFromPeers(d) {
+		return nil
+	}
+	resp, err := c.do(http.MethodGet, c.base+"/blobs/"+d.Digest, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("blob %s request returned %s", d.Digest, resp.Status)
+	}
+	return storeBlob(d, resp.Body)
+}
+
+// storeBlob writes a blob into the cache, checking its size and digest
+func storeBlob(d ociDescriptor, r io.Reader) error {
+	path := ociBlobPath(d.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, d.Size+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != d.Size {
+		err = fmt.Errorf("blob %s is %d bytes, manifest says %d", d.Digest, n, d.Size)
+	}
+	if err == nil && "sha256:"+hex.EncodeToString(h.Sum(nil)) != d.Digest {
+		err = fmt.Errorf("blob %s does not match its digest", d.Digest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ONVIF support: WS-Discovery finds cameras on the local network by multicast
+// probe, and the device and media services provide capabilities, profiles and
+// RTSP stream URIs so a camera can be added as a source without hunting for
+// its URL. Multicast discovery only reaches the pod's network, so in
+// Kubernetes it needs hostNetwork; probing a known device address works either way.
+
+const (
+	wsDiscoveryAddr    = "239.255.255.250:3702"
+	defaultDiscoverFor = 3 * time.Second
+)
+
+var onvifClient = &http.Client{Timeout: 10 * time.Second}
+
+// OnvifDevice is a camera that answered a WS-Discovery probe
+type OnvifDevice struct {
+	Address  string   `json:"address"` // endpoint reference, usually urn:uuid:...
+	XAddrs   []string `json:"xaddrs"`  // device service URLs
+	Name     string   `json:"name,omitempty"`
+	Hardware string   `json:"hardware,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// OnvifProfile is a media profile with its RTSP stream
+type OnvifProfile struct {
+	Token     string  `json:"token"`
+	Name      string  `json:"name"`
+	Encoding  string  `json:"encoding,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	StreamURI string  `json:"stream_uri,omitempty"`
+}
+
+// OnvifDeviceInfo describes a probed device
+type OnvifDeviceInfo struct {
+	XAddr        string         `json:"xaddr"`
+	MediaXAddr   string         `json:"media_xaddr"`
+	Capabilities []string       `json:"capabilities"` // services the device offers, e.g. "media", "ptz"
+	Profiles     []OnvifProfile `json:"profiles"`
+}
+
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<e:Header><w:MessageID>uuid:%s</w:MessageID><w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To><w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action></e:Header>
+<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>
+</e:Envelope>`
+
+type probeMatchesEnvelope struct {
+	Matches []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+// discoverOnvif multicasts a WS-Discovery probe and collects answers until timeout
+func discoverOnvif(ctx context.Context, timeout time.Duration) ([]OnvifDevice, error) {
+	group, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := fmt.Sprintf(probeTemplate, uuid4())
+	if _, err := conn.WriteToUDP([]byte(msg), group); err != nil {
+		return nil, fmt.Errorf("sending probe: %v", err)
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	found := map[string]*OnvifDevice{}
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // read deadline reached
+		}
+		var env probeMatchesEnvelope
+		if err := xml.Unmarshal(buf[:n], &env); err != nil {
+			continue
+		}
+		for _, m := range env.Matches {
+			if m.Address == "" || found[m.Address] != nil {
+				continue
+			}
+			dev := &OnvifDevice{Address: m.Address, XAddrs: strings.Fields(m.XAddrs)}
+			for _, scope := range strings.Fields(m.Scopes) {
+				dev.Scopes = append(dev.Scopes, scope)
+				if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/name/"); ok {
+					dev.Name, _ = url.PathUnescape(v)
+				}
+				if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/hardware/"); ok {
+					dev.Hardware, _ = url.PathUnescape(v)
+				}
+			}
+			found[m.Address] = dev
+		}
+	}
+
+	devices := make([]OnvifDevice, 0, len(found))
+	for _, d := range found {
+		devices = append(devices, *d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices, nil
+}
+
+// onvifCall posts a SOAP request to an ONVIF service, authenticating with a
+// WS-Security UsernameToken digest when a username is given, and decodes the
+// response body into out
+func onvifCall(ctx context.Context, xaddr, username, password, body string, out interface{}) error {
+	header := ""
+	if username != "" {
+		nonce := []byte(uuid4())
+		created := time.Now().UTC().Format(time.RFC3339)
+		sum := sha1.Sum(append(append(append([]byte{}, nonce...), created...), password...))
+		header = fmt.Sprintf(`<s:Header><Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"><UsernameToken><Username>%s</Username><Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%s</Password><Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">%s</Nonce><Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">%s</Created></UsernameToken></Security></s:Header>`,
+			xmlEscape(username), base64.StdEncoding.EncodeToString(sum[:]), base64.StdEncoding.EncodeToString(nonce), created)
+	}
+	envelope := `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">` +
+		header + `<s:Body>` + body + `</s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xaddr, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+	resp, err := onvifClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Reason string `xml:"Body>Fault>Reason>Text"`
+		}
+		xml.Unmarshal(data, &fault)
+		if fault.Reason != "" {
+			return fmt.Errorf("%s: %s", resp.Status, fault.Reason)
+		}
+		return fmt.Errorf("device returned %s", resp.Status)
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// probeOnvifDevice fetches capabilities, media profiles and stream URIs from a device service
+func probeOnvifDevice(ctx context.Context, xaddr, username, password string) (OnvifDeviceInfo, error) {
+	info := OnvifDeviceInfo{XAddr: xaddr, Capabilities: []string{}, Profiles: []OnvifProfile{}}
+
+	var caps struct {
+		Caps struct {
+			Analytics *struct{} `xml:"Analytics"`
+			Events    *struct{} `xml:"Events"`
+			Imaging   *struct{} `xml:"Imaging"`
+			Media     struct {
+				XAddr string `xml:"XAddr"`
+			} `xml:"Media"`
+			PTZ *struct{} `xml:"PTZ"`
+		} `xml:"Body>GetCapabilitiesResponse>Capabilities"`
+	}
+	err := onvifCall(ctx, xaddr, username, password,
+		`<tds:GetCapabilities><tds:Category>All</tds:Category></tds:GetCapabilities>`, &caps)
+	if err != nil {
+		return info, fmt.Errorf("GetCapabilities: %v", err)
+	}
+	c := caps.Caps
+	for name, present := range map[string]bool{
+		"analytics": c.Analytics != nil, "events": c.Events != nil, "imaging": c.Imaging != nil,
+		"media": c.Media.XAddr != "", "ptz": c.PTZ != nil,
+	} {
+		if present {
+			info.Capabilities = append(info.Capabilities, name)
+		}
+	}
+	sort.Strings(info.Capabilities)
+	if c.Media.XAddr == "" {
+		return info, fmt.Errorf("device has no media service")
+	}
+	info.MediaXAddr = c.Media.XAddr
+
+	var profiles struct {
+		Profiles []struct {
+			Token   string `xml:"token,attr"`
+			Name    string `xml:"Name"`
+			Encoder struct {
+				Encoding   string  `xml:"Encoding"`
+				Width      int     `xml:"Resolution>Width"`
+				Height     int     `xml:"Resolution>Height"`
+				FrameLimit float64 `xml:"RateControl>FrameRateLimit"`
+			} `xml:"VideoEncoderConfiguration"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	if err := onvifCall(ctx, info.MediaXAddr, username, password, `<trt:GetProfiles/>`, &profiles); err != nil {
+		return info, fmt.Errorf("GetProfiles: %v", err)
+	}
+	for _, p := range profiles.Profiles {
+		profile := OnvifProfile{
+			Token: p.Token, Name: p.Name, Encoding: p.Encoder.Encoding,
+			Width: p.Encoder.Width, Height: p.Encoder.Height, FrameRate: p.Encoder.FrameLimit,
+		}
+		var stream struct {
+			URI string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+		}
+		body := `<trt:GetStreamUri><trt:StreamSetup><tt:Stream>RTP-Unicast</tt:Stream><tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport></trt:StreamSetup><trt:ProfileToken>` +
+			xmlEscape(p.Token) + `</trt:ProfileToken></trt:GetStreamUri>`
+		if err := onvifCall(ctx, info.MediaXAddr, username, password, body, &stream); err == nil {
+			profile.StreamURI = strings.TrimSpace(stream.URI)
+		}
+		info.Profiles = append(info.Profiles, profile)
+	}
+	return info, nil
+}
+
+// withCredentials adds RTSP credentials to a stream URI that lacks them
+func withCredentials(streamURI, username, password string) string {
+	u, err := url.Parse(streamURI)
+	if err != nil || username == "" || u.User != nil {
+		return streamURI
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String()
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// uuid4 returns a random RFC 4122 version 4 UUID
+func uuid4() string {
+	id := newID() + newID()
+	b := []byte(id)
+	b[12] = '4'
+	b[16] = "89ab"[b[16]%4]
+	return fmt.Sprintf("%s-%s-%s-%s-%s", b[0:8], b[8:12], b[12:16], b[16:20], b[20:32])
+}
+
+// onvifDeviceRequest identifies a device and the credentials to use with it
+type onvifDeviceRequest struct {
+	XAddr    string `json:"xaddr"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Used by /add only
+	Name    string  `json:"name,omitempty"`
+	Profile string  `json:"profile,omitempty"` // profile token; defaults to the first with a stream
+	FPS     float64 `json:"fps,omitempty"`
+	Model   string  `json:"model,omitempty"`
+}
+
+// onvifHandler serves ONVIF discovery
+//
+//	GET  /api/v1/onvif/discover?timeout=3s  multicast probe for cameras on the local network
+//	POST /api/v1/onvif/probe                capabilities, profiles and stream URIs of a device
+//	POST /api/v1/onvif/add                  add a device's stream as a camera source
+func onvifHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/onvif"), "/")
+
+	switch {
+	case action == "discover" && r.Method == http.MethodGet:
+		timeout := defaultDiscoverFor
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > 30*time.Second {
+				writeJSONError(w, http.StatusBadRequest, "timeout must be a duration up to 30s")
+				return
+			}
+			timeout = d
+		}
+		devices, err := discoverOnvif(r.Context(), timeout)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Discovery failed: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, devices)
+
+	case (action == "probe" || action == "add") && r.Method == http.MethodPost:
+		var req onvifDeviceRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if u, err := url.Parse(req.XAddr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeJSONError(w, http.StatusBadRequest, "xaddr must be the device service URL")
+			return
+		}
+		info, err := probeOnvifDevice(r.Context(), req.XAddr, req.Username, req.Password)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if action == "probe" {
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+
+		var chosen *OnvifProfile
+		for i, p := range info.Profiles {
+			if p.StreamURI != "" && (req.Profile == "" || p.Token == req.Profile) {
+				chosen = &info.Profiles[i]
+				break
+			}
+		}
+		if chosen == nil {
+			writeJSONError(w, http.StatusBadRequest, "No matching profile with an RTSP stream")
+			return
+		}
+		src := CameraSource{
+			Name:    req.Name,
+			URL:     withCredentials(chosen.StreamURI, req.Username, req.Password),
+			FPS:     req.FPS,
+			Enabled: true,
+		}
+		src.Model = req.Model
+		if err := sources.put(src, true); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v, _ := sources.view(src.Name)
+		writeJSON(w, http.StatusCreated, v)
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
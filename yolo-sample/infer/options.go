@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Inference settings are layered: the global configuration (the active model
+// and canary split, INFERENCE_THRESHOLD, every class), then the camera
+// source's overrides, then the request's. Each layer only sets what it
+// overrides, so a dock camera can pin a model and keep only vehicles while an
+// upload to the same node asks for a lower threshold.
+//
+// Requests pass overrides as form or query fields on /upload
+// (model=v3&threshold=0.4&classes=car,truck), as query fields on
+// /api/v1/infer/batch, and as the same JSON fields on /api/v1/infer/url and
+// /api/v1/infer/base64. Classes may be taxonomy labels.
+//
+// imgsz (the input size, a multiple of 32 up to INFERENCE_MAX_IMGSZ),
+// iou_threshold (of non-maximum suppression) and max_detections (up to
+// INFERENCE_MAX_DETECTIONS) are passed on to the backend; unset, the
+// backend's defaults apply. Confidence, classes and max_detections are also
+// enforced on the result, for backends that ignore them. In privacy mode the
+// backend never runs above INFERENCE_THRESHOLD or with a max_detections cap,
+// so the regions to redact are found whatever the request asks for; its
+// settings then only filter the result.
+
+// InferenceOptions is one layer of inference settings
+type InferenceOptions struct {
+	Model     string   `json:"model,omitempty"`     // model version; empty follows the active model and canary
+	Threshold *float64 `json:"threshold,omitempty"` // minimum detection confidence
+	Classes   []string `json:"classes,omitempty"`   // classes or labels to keep; empty keeps all
+
+	ImgSz         *int     `json:"imgsz,omitempty"`          // input size in pixels
+	IoUThreshold  *float64 `json:"iou_threshold,omitempty"`  // of non-maximum suppression
+	MaxDetections *int     `json:"max_detections,omitempty"` // most detections kept per image
+}
+
+// backendParams are the settings sent to the inference backend with an
+// image; zero values leave the backend's defaults
+type backendParams struct {
+	Threshold     float64
+	ImgSz         int
+	IoUThreshold  float64
+	MaxDetections int
+}
+
+// params returns the backend settings of resolved options
+func (o InferenceOptions) params() backendParams {
+	p := backendParams{Threshold: config().InferenceThreshold}
+	privacy := config().PrivacyMode
+	if o.Threshold != nil && (!privacy || *o.Threshold < p.Threshold) {
+		p.Threshold = *o.Threshold
+	}
+	if o.ImgSz != nil {
+		p.ImgSz = *o.ImgSz
+	}
+	if o.IoUThreshold != nil {
+		p.IoUThreshold = *o.IoUThreshold
+	}
+	if o.MaxDetections != nil && !privacy {
+		p.MaxDetections = *o.MaxDetections
+	}
+	return p
+}
+
+// validate checks the overrides in o
+func (o InferenceOptions) validate() error {
+	if o.Model != "" && !modelExists(o.Model) {
+		return fmt.Errorf("model %q not found in %s", o.Model, config().ModelDir)
+	}
+	if o.Threshold != nil && (*o.Threshold < 0 || *o.Threshold > 1) {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	for _, c := range o.Classes {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("classes must not be empty")
+		}
+	}
+	if max := config().InferenceMaxImgSz; o.ImgSz != nil && (*o.ImgSz < 32 || *o.ImgSz > max || *o.ImgSz%32 != 0) {
+		return fmt.Errorf("imgsz must be a multiple of 32 between 32 and %d", max)
+	}
+	if o.IoUThreshold != nil && (*o.IoUThreshold <= 0 || *o.IoUThreshold > 1) {
+		return fmt.Errorf("iou_threshold must be in (0, 1]")
+	}
+	if max := config().InferenceMaxDetections; o.MaxDetections != nil && (*o.MaxDetections < 1 || *o.MaxDetections > max) {
+		return fmt.Errorf("max_detections must be between 1 and %d", max)
+	}
+	return nil
+}
+
+// over returns o with the unset settings taken from base
+func (o InferenceOptions) over(base InferenceOptions) InferenceOptions {
+	if o.Model == "" {
+		o.Model = base.Model
+	}
+	if o.Threshold == nil {
+		o.Threshold = base.Threshold
+	}
+	if len(o.Classes) == 0 {
+		o.Classes = base.Classes
+	}
+	if o.ImgSz == nil {
+		o.ImgSz = base.ImgSz
+	}
+	if o.IoUThreshold == nil {
+		o.IoUThreshold = base.IoUThreshold
+	}
+	if o.MaxDetections == nil {
+		o.MaxDetections = base.MaxDetections
+	}
+	return o
+}
+
+// resolveOptions layers the request's overrides over the source's and the
+// global settings. The resolved threshold is always set.
+func resolveOptions(source string, req InferenceOptions) InferenceOptions {
+	threshold := config().InferenceThreshold
+	opts := InferenceOptions{Threshold: &threshold}
+	if src, ok := sources.get(source); ok {
+		opts = src.InferenceOptions.over(opts)
+	}
+	return req.over(opts)
+}
+
+// requestOptions reads overrides from the form or query fields model, threshold, classes, ...
+func requestOptions(r *http.Request) (InferenceOptions, error) {
+	return optionsFrom(r.FormValue)
+}
+
+// optionsFrom reads overrides from the fields model, threshold, classes, imgsz,
+// iou_threshold and max_detections of get
+func optionsFrom(get func(string) string) (InferenceOptions, error) {
+	var o InferenceOptions
+	o.Model = strings.TrimSpace(get("model"))
+	for _, f := range []struct {
+		name string
+		dst  **float64
+	}{{"threshold", &o.Threshold}, {"iou_threshold", &o.IoUThreshold}} {
+		if v := strings.TrimSpace(get(f.name)); v != "" {
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s %q", f.name, v)
+			}
+			*f.dst = &t
+		}
+	}
+	for _, f := range []struct {
+		name string
+		dst  **int
+	}{{"imgsz", &o.ImgSz}, {"max_detections", &o.MaxDetections}} {
+		if v := strings.TrimSpace(get(f.name)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s %q", f.name, v)
+			}
+			*f.dst = &n
+		}
+	}
+	for _, c := range strings.Split(get("classes"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			o.Classes = append(o.Classes, c)
+		}
+	}
+	return o, o.validate()
+}
+
+// applyOptions drops detections below the threshold or outside the enabled
+// classes, and the least confident beyond max_detections; backends that
+// ignore the requested settings are filtered here too
+func applyOptions(result *InferenceResult, opts InferenceOptions) {
+	// A new slice: the unfiltered detections are still needed for redaction
+	kept := make([]Detection, 0, len(result.Detections))
+	for _, d := range result.Detections {
+		if opts.Threshold != nil && d.Confidence < *opts.Threshold {
+			continue
+		}
+		if len(opts.Classes) > 0 && !classEnabled(d.ClassName, opts.Classes) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	if opts.MaxDetections != nil && len(kept) > *opts.MaxDetections {
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].Confidence > kept[j].Confidence })
+		kept = kept[:*opts.MaxDetections]
+	}
+	result.Detections = kept
+	result.Count = len(kept)
+}
+
+func classEnabled(class string, enabled []string) bool {
+	for _, c := range enabled {
+		if labels().matches(class, c) {
+			return true
+		}
+	}
+	return false
+}
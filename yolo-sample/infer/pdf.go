@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A small PDF writer for reports: A4 pages of left-aligned Helvetica text,
+// table rows and horizontal bars, enough for a printable summary without a
+// PDF library. Text is encoded as WinAnsi, which covers the UI languages;
+// other characters print as "?".
+
+const (
+	pdfPageWidth  = 595 // A4 in points
+	pdfPageHeight = 842
+	pdfMargin     = 50
+)
+
+// pdfDoc lays out text top to bottom, starting a new page when one fills up
+type pdfDoc struct {
+	pages []*bytes.Buffer // content streams
+	y     float64         // baseline of the next line on the last page
+}
+
+func newPDF() *pdfDoc {
+	d := &pdfDoc{}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// advance moves down by height, breaking the page when it would not fit
+func (d *pdfDoc) advance(height float64) *bytes.Buffer {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+	d.y -= height
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *pdfDoc) text(page *bytes.Buffer, x float64, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(page, "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, d.y, pdfEscape(s))
+}
+
+// title writes a large bold line
+func (d *pdfDoc) title(s string) {
+	d.text(d.advance(26), pdfMargin, 18, true, s)
+}
+
+// heading writes a section heading, with space above it
+func (d *pdfDoc) heading(s string) {
+	d.text(d.advance(30), pdfMargin, 13, true, s)
+}
+
+// line writes a line of body text
+func (d *pdfDoc) line(s string) {
+	d.text(d.advance(15), pdfMargin, 10, false, s)
+}
+
+// row writes cells at the given offsets from the left margin
+func (d *pdfDoc) row(bold bool, cells []string, offsets []float64) {
+	page := d.advance(15)
+	for i, c := range cells {
+		d.text(page, pdfMargin+offsets[i], 10, bold, c)
+	}
+}
+
+// bar writes a label and value with a bar of value/max of the free width
+func (d *pdfDoc) bar(label string, value, max float64) {
+	page := d.advance(15)
+	d.text(page, pdfMargin, 10, false, label)
+	d.text(page, pdfMargin+150, 10, false, fmt.Sprintf("%g", value))
+	if max > 0 && value > 0 {
+		width := (pdfPageWidth - 2*pdfMargin - 210) * value / max
+		fmt.Fprintf(page, "0.4 0.49 0.92 rg %g %g %g 9 re f 0 g\n", float64(pdfMargin+210), d.y-1, width)
+	}
+}
+
+// bytes assembles the document
+func (d *pdfDoc) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 and 4 fonts, then a page and its content per page
+	kids := &bytes.Buffer{}
+	for i := range d.pages {
+		fmt.Fprintf(kids, "%d 0 R ", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", bytes.TrimSpace(kids.Bytes()), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfWinAnsi maps the characters outside Latin-1 that WinAnsi has
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'–': 0x96, '—': 0x97, 'œ': 0x9c, 'Œ': 0x8c,
+}
+
+// pdfEscape encodes s as the inside of a PDF string literal
+func pdfEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case pdfWinAnsi[r] != 0:
+			b.WriteByte(pdfWinAnsi[r])
+		case r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
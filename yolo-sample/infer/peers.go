@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Nodes of one site can share pulled model blobs so an artifact crosses the
+// WAN once. MODEL_PEERS lists the base URLs of sibling nodes, comma-separated:
+//
+//	MODEL_PEERS=http://10.0.4.11:6767,http://10.0.4.12:6767
+//
+// Before downloading a blob from the registry, a node asks each peer for it at
+//
+//	GET /api/v1/blobs/sha256:<hex>
+//
+// which answers from the blob cache (STATE_DIR/oci/blobs) or 404. A blob from a
+// peer is checked against the manifest's size and digest like one from the
+// registry, so a peer needs no more trust than the network between them; any
+// failure moves on to the next peer and finally the registry. The manifest
+// itself always comes from the registry, which keeps pinning and tag
+// resolution authoritative. Nodes only serve blobs while MODEL_PEERS is set.
+
+// ociDigest matches the blob digests the blob cache stores and peers serve
+var ociDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// peerProbeClient bounds how long an unreachable peer holds up a pull
+var peerProbeClient = &http.Client{Timeout: 3 * time.Second}
+
+var peerFetches = newCounterVec("yolo_model_peer_fetches_total",
+	"Model blob requests to sibling nodes by outcome.", "status")
+
+// peerBlobHandler serves cached blobs to sibling nodes
+func peerBlobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if config().ModelPeers == "" {
+		writeJSONError(w, http.StatusNotFound, "Peer blob sharing is disabled")
+		return
+	}
+	digest := strings.TrimPrefix(r.URL.Path, "/api/v1/blobs/")
+	if !ociDigest.MatchString(digest) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid blob digest")
+		return
+	}
+	f, err := os.Open(ociBlobPath(digest))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Blob not found")
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read blob")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// fetchBlobFromPeers tries to copy a blob from a sibling node into the cache
+func fetchBlobFromPeers(d ociDescriptor) bool {
+	for _, peer := range splitList(config().ModelPeers) {
+		u := strings.TrimSuffix(peer, "/") + "/api/v1/blobs/" + d.Digest
+		head, err := peerProbeClient.Head(u)
+		if err != nil {
+			peerFetches.inc("unreachable")
+			continue
+		}
+		head.Body.Close()
+		if head.StatusCode != http.StatusOK || head.ContentLength != d.Size {
+			peerFetches.inc("miss")
+			continue
+		}
+		resp, err := ociClient.Get(u)
+		if err != nil {
+			peerFetches.inc("unreachable")
+			continue
+		}
+		err = storeBlob(d, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			peerFetches.inc("invalid")
+			log.Printf("Warning: blob %s from peer %s rejected: %v", d.Digest, peer, err)
+			continue
+		}
+		peerFetches.inc("hit")
+		log.Printf("Fetched blob %s from peer %s", d.Digest, peer)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Every stored result has a permalink, /results/{id}, rendered from the result
+// store on demand so it can be shared after the upload that produced it. While
+// an upload is still being processed the same URL shows its progress (see jobs.go).
+
+// permalink returns the absolute URL of a result page
+func permalink(r *http.Request, id string) string {
+	base := config().PublicURL
+	if base == "" {
+		scheme := "http"
+		if isHTTPS(r) {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host + basePath(r)
+	}
+	return base + "/results/" + id
+}
+
+// resultPageHandler serves stored results
+//
+//	GET /results/{id}         the result page
+//	GET /results/{id}/qr.svg  a QR code of the result's permalink
+func resultPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, suffix, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/results/"), "/")
+	result, ok := results.get(id)
+	if !ok && forwardToOwner(w, r, id) {
+		return
+	}
+	if !ok && suffix == "" && jobs.has(id) {
+		renderJobProgress(w, r, id)
+		return
+	}
+	if !ok || (suffix != "" && suffix != "qr.svg") {
+		w.WriteHeader(http.StatusNotFound)
+		renderError(w, r, "Result not found. It may have been removed by the retention policy.")
+		return
+	}
+
+	if suffix == "qr.svg" {
+		qr, err := encodeQR([]byte(permalink(r, id)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Write([]byte(qr.svg(4)))
+		return
+	}
+	renderResults(w, r, getNodeStatus(), result)
+}
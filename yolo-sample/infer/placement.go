@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Training Jobs run wherever the cluster puts them, and one that fits no node
+// would pend without a word. So unless TRAINING_PLACEMENT=false, a job is
+// placed from the live state of the cluster: the nodes are read with kubectl
+// and those that can train are kept, namely nodes that
+//
+//   - are Ready and not cordoned,
+//   - carry every TRAINING_GPU_LABELS label (default nvidia.com/gpu.present=true),
+//   - have NODE_LABEL_KEY (default network-status) set to online, since
+//     training needs the gateway, and
+//   - have no NoSchedule or NoExecute taint the job's pod template does not
+//     tolerate.
+//
+// The Job is created from the CronJob's template with a required node
+// affinity for those nodes, added to any the template has, and a preference
+// for the one with the most allocatable GPUs. With no such node no Job is
+// created and the trigger fails, saying why each node was passed over:
+//
+//	no node can run training: edge-1: network-status is offline; gpu-1: cordoned
+//
+// The node then watches the Job's pod for TRAINING_SCHEDULE_TIMEOUT (default
+// 2m). A pod the scheduler cannot place is logged with the scheduler's
+// message and published as training.job, and recent jobs are listed by
+// GET /api/v1/training.
+
+// errNoTrainingNode is returned when no node of the cluster can run training
+var errNoTrainingNode = errors.New("no node can run training")
+
+// maxTrainingJobs bounds the training jobs kept for the API
+const maxTrainingJobs = 20
+
+// TrainingJob is a training Job created by the node; it is also the payload
+// of training.job
+type TrainingJob struct {
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Nodes     []string  `json:"nodes"`               // nodes the affinity allows
+	Preferred string    `json:"preferred,omitempty"` // node with the most GPUs
+	State     string    `json:"state"`               // "pending", "scheduled" or "unschedulable"
+	Node      string    `json:"node,omitempty"`      // where the pod was scheduled
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var trainingJobs = struct {
+	sync.Mutex
+	list []*TrainingJob // oldest first
+}{}
+
+var trainingPlacements = newCounterVec("yolo_training_placements_total",
+	"Training Job placements by outcome.", "outcome")
+
+// kubeNode holds the fields of a cluster node that placement looks at
+type kubeNode struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Unschedulable bool        `json:"unschedulable"`
+		Taints        []kubeTaint `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		Allocatable map[string]string `json:"allocatable"`
+		Conditions  []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type kubeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Effect string `json:"effect"`
+}
+
+type kubeToleration struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+	Effect   string `json:"effect"`
+}
+
+// parseLabelSelector parses "key=value" and "key" (present) entries
+func parseLabelSelector(list string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, entry := range splitList(list) {
+		key, value, _ := strings.Cut(entry, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid label %q", entry)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func tolerates(tolerations []kubeToleration, t kubeTaint) bool {
+	for _, tol := range tolerations {
+		if tol.Effect != "" && tol.Effect != t.Effect {
+			continue
+		}
+		switch {
+		case tol.Key == "" && tol.Operator == "Exists":
+			return true
+		case tol.Key != t.Key:
+		case tol.Operator == "Exists" || tol.Value == t.Value:
+			return true
+		}
+	}
+	return false
+}
+
+// unfitReason says why a node cannot run training, or returns ""
+func unfitReason(n kubeNode, gpuLabels map[string]string, statusLabel string, tolerations []kubeToleration) string {
+	ready := false
+	for _, c := range n.Status.Conditions {
+		if c.Type == "Ready" {
+			ready = c.Status == "True"
+		}
+	}
+	if !ready {
+		return "not Ready"
+	}
+	if n.Spec.Unschedulable {
+		return "cordoned"
+	}
+	keys := make([]string, 0, len(gpuLabels))
+	for k := range gpuLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := n.Metadata.Labels[k]
+		if !ok || (gpuLabels[k] != "" && v != gpuLabels[k]) {
+			return "no label " + strings.TrimSuffix(k+"="+gpuLabels[k], "=")
+		}
+	}
+	if status := n.Metadata.Labels[statusLabel]; status != "online" {
+		if status == "" {
+			status = "unset"
+		}
+		return statusLabel + " is " + status
+	}
+	for _, t := range n.Spec.Taints {
+		if (t.Effect == "NoSchedule" || t.Effect == "NoExecute") && !tolerates(tolerations, t) {
+			return "taint " + t.Key + ":" + t.Effect + " not tolerated"
+		}
+	}
+	return ""
+}
+
+// allocatableGPUs sums the allocatable GPUs of a node, of any vendor
+func allocatableGPUs(n kubeNode) int {
+	total := 0
+	for name, qty := range n.Status.Allocatable {
+		if strings.HasSuffix(name, "/gpu") {
+			if v, err := strconv.Atoi(qty); err == nil {
+				total += v
+			}
+		}
+	}
+	return total
+}
+
+// namespacedArgs adds -n Namespace to kubectl arguments when it is set
+func namespacedArgs(args ...string) []string {
+	if ns := config().Namespace; ns != "" {
+		args = append(args, "-n", ns)
+	}
+	return args
+}
+
+// placeTrainingJob creates Job jobName from the training CronJob with a
+// node affinity for the nodes that can train
+func placeTrainingJob(jobName, reason string) error {
+	cfg := config()
+	gpuLabels, err := parseLabelSelector(cfg.TrainingGPULabels)
+	if err != nil {
+		return fmt.Errorf("TRAINING_GPU_LABELS: %v", err)
+	}
+	statusLabel := os.Getenv("NODE_LABEL_KEY")
+	if statusLabel == "" {
+		statusLabel = "network-status"
+	}
+
+	out, err := runKubectl(namespacedArgs("create", "job", "--from=cronjob/"+cfg.TrainingCronJob, jobName, "--dry-run=client", "-o", "json")...)
+	if err != nil {
+		return fmt.Errorf("cannot render training job: %v", err)
+	}
+	var job map[string]interface{}
+	if err := json.Unmarshal(out, &job); err != nil {
+		return fmt.Errorf("cannot render training job: %v", err)
+	}
+	podSpec, _ := dig(job, "spec", "template", "spec").(map[string]interface{})
+	if podSpec == nil {
+		return fmt.Errorf("training job %s has no pod template", jobName)
+	}
+	var tolerations []kubeToleration
+	if raw, err := json.Marshal(podSpec["tolerations"]); err == nil {
+		json.Unmarshal(raw, &tolerations)
+	}
+
+	out, err = runKubectl("get", "nodes", "-o", "json")
+	if err != nil {
+		return fmt.Errorf("cannot list nodes: %v", err)
+	}
+	var nodes struct {
+		Items []kubeNode `json:"items"`
+	}
+	if err := json.Unmarshal(out, &nodes); err != nil {
+		return fmt.Errorf("cannot list nodes: %v", err)
+	}
+	var fit []kubeNode
+	var unfit []string
+	for _, n := range nodes.Items {
+		if why := unfitReason(n, gpuLabels, statusLabel, tolerations); why != "" {
+			unfit = append(unfit, n.Metadata.Name+": "+why)
+		} else {
+			fit = append(fit, n)
+		}
+	}
+	if len(fit) == 0 {
+		trainingPlacements.inc("no_node")
+		if len(unfit) == 0 {
+			return fmt.Errorf("%w: the cluster has no nodes", errNoTrainingNode)
+		}
+		sort.Strings(unfit)
+		return fmt.Errorf("%w: %s", errNoTrainingNode, strings.Join(unfit, "; "))
+	}
+	sort.SliceStable(fit, func(i, j int) bool { return allocatableGPUs(fit[i]) > allocatableGPUs(fit[j]) })
+
+	tj := &TrainingJob{Name: jobName, Reason: reason, State: "pending", CreatedAt: time.Now().UTC()}
+	var hostnames []interface{}
+	for _, n := range fit {
+		tj.Nodes = append(tj.Nodes, n.Metadata.Name)
+		hostnames = append(hostnames, nodeHostname(n))
+	}
+	tj.Preferred = fit[0].Metadata.Name
+	setTrainingAffinity(podSpec, hostnames, nodeHostname(fit[0]))
+
+	data, _ := json.Marshal(job)
+	cmd := exec.Command("kubectl", namespacedArgs("create", "-f", "-")...)
+	cmd.Stdin = strings.NewReader(string(data))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		trainingPlacements.inc("error")
+		return fmt.Errorf("kubectl create job failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	trainingPlacements.inc("placed")
+
+	trainingJobs.Lock()
+	trainingJobs.list = append(trainingJobs.list, tj)
+	if len(trainingJobs.list) > maxTrainingJobs {
+		trainingJobs.list = trainingJobs.list[len(trainingJobs.list)-maxTrainingJobs:]
+	}
+	trainingJobs.Unlock()
+	bus.publish(eventTrainingJob, *tj)
+	go watchTrainingJob(tj, cfg.TrainingScheduleTimeout)
+	return nil
+}
+
+func nodeHostname(n kubeNode) string {
+	if h := n.Metadata.Labels["kubernetes.io/hostname"]; h != "" {
+		return h
+	}
+	return n.Metadata.Name
+}
+
+// dig returns the value at path in decoded JSON, or nil
+func dig(v interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// setTrainingAffinity requires one of hostnames in every node selector term
+// of the pod spec and prefers preferred
+func setTrainingAffinity(podSpec map[string]interface{}, hostnames []interface{}, preferred string) {
+	requirement := map[string]interface{}{"key": "kubernetes.io/hostname", "operator": "In", "values": hostnames}
+	affinity, _ := podSpec["affinity"].(map[string]interface{})
+	if affinity == nil {
+		affinity = map[string]interface{}{}
+		podSpec["affinity"] = affinity
+	}
+	nodeAffinity, _ := affinity["nodeAffinity"].(map[string]interface{})
+	if nodeAffinity == nil {
+		nodeAffinity = map[string]interface{}{}
+		affinity["nodeAffinity"] = nodeAffinity
+	}
+	required, _ := nodeAffinity["requiredDuringSchedulingIgnoredDuringExecution"].(map[string]interface{})
+	if required == nil {
+		required = map[string]interface{}{}
+		nodeAffinity["requiredDuringSchedulingIgnoredDuringExecution"] = required
+	}
+	// Terms are alternatives, so the hostnames are added to each of them
+	terms, _ := required["nodeSelectorTerms"].([]interface{})
+	if len(terms) == 0 {
+		terms = []interface{}{map[string]interface{}{}}
+	}
+	for _, t := range terms {
+		if term, ok := t.(map[string]interface{}); ok {
+			exprs, _ := term["matchExpressions"].([]interface{})
+			term["matchExpressions"] = append(exprs, requirement)
+		}
+	}
+	required["nodeSelectorTerms"] = terms
+
+	preferences, _ := nodeAffinity["preferredDuringSchedulingIgnoredDuringExecution"].([]interface{})
+	nodeAffinity["preferredDuringSchedulingIgnoredDuringExecution"] = append(preferences, map[string]interface{}{
+		"weight": 100,
+		"preference": map[string]interface{}{"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "kubernetes.io/hostname", "operator": "In", "values": []interface{}{preferred}},
+		}},
+	})
+}
+
+// watchTrainingJob follows the scheduling of a Job's pod until it is
+// scheduled or timeout passes
+func watchTrainingJob(tj *TrainingJob, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		state, node, detail := trainingPodState(tj.Name)
+		if state == "" {
+			continue
+		}
+		trainingJobs.Lock()
+		changed := state != tj.State || detail != tj.Detail
+		tj.State, tj.Node, tj.Detail = state, node, detail
+		ev := *tj
+		trainingJobs.Unlock()
+		if changed {
+			bus.publish(eventTrainingJob, ev)
+			if state == "unschedulable" {
+				log.Printf("Warning: training job %s cannot be scheduled: %s", tj.Name, detail)
+			}
+		}
+		if state == "scheduled" {
+			log.Printf("Training job %s scheduled on %s", tj.Name, node)
+			return
+		}
+	}
+	trainingJobs.Lock()
+	defer trainingJobs.Unlock()
+	if tj.State == "pending" {
+		tj.Detail = fmt.Sprintf("no pod was scheduled within %s", timeout)
+		log.Printf("Warning: training job %s: %s", tj.Name, tj.Detail)
+	}
+}
+
+// trainingPodState reads the scheduling state of a Job's pod; state is ""
+// when it cannot be told yet
+func trainingPodState(job string) (state, node, detail string) {
+	out, err := runKubectl(namespacedArgs("get", "pods", "-l", "job-name="+job, "-o", "json")...)
+	if err != nil {
+		return "", "", ""
+	}
+	var pods struct {
+		Items []struct {
+			Spec struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+			Status struct {
+				Conditions []struct {
+					Type    string `json:"type"`
+					Status  string `json:"status"`
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(out, &pods) != nil || len(pods.Items) == 0 {
+		return "", "", ""
+	}
+	pod := pods.Items[len(pods.Items)-1]
+	for _, c := range pod.Status.Conditions {
+		if c.Type != "PodScheduled" {
+			continue
+		}
+		if c.Status == "True" {
+			return "scheduled", pod.Spec.NodeName, ""
+		}
+		if c.Reason == "Unschedulable" {
+			return "unschedulable", "", c.Message
+		}
+	}
+	return "pending", "", ""
+}
+
+// listTrainingJobs returns the recent training jobs, newest first
+func listTrainingJobs() []TrainingJob {
+	trainingJobs.Lock()
+	defer trainingJobs.Unlock()
+	out := make([]TrainingJob, 0, len(trainingJobs.list))
+	for i := len(trainingJobs.list) - 1; i >= 0; i-- {
+		out = append(out, *trainingJobs.list[i])
+	}
+	return out
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Edge sites on solar or behind a UPS have to stretch what is left in the
+// battery when the mains goes. Every POWER_CHECK_INTERVAL (default 30s) the
+// node asks the provider POWER_PROVIDER selects whether it is on battery:
+//
+//	sysfs    /sys/class/power_supply (POWER_SUPPLY_DIR): a battery discharging,
+//	         or no mains or USB supply online
+//	apcupsd  the apcupsd network information server at APCUPSD_ADDR
+//	         (default 127.0.0.1:3551), STATUS ONBATT
+//	nut      upsd of Network UPS Tools for NUT_UPS (default ups@127.0.0.1:3493),
+//	         ups.status OB
+//	auto     (default) sysfs when the node has a battery
+//	off      never on battery
+//
+// On battery the node follows LOW_POWER_POLICY, a comma-separated list of
+//
+//	sources   pause the camera sources but those in LOW_POWER_KEEP_SOURCES
+//	batch     skip batch inference runs
+//	sync      defer result sync until the mains is back
+//	training  refuse to start training
+//
+// (all of them by default, "none" for none), shows a banner, and sets
+// yolo_on_battery to 1. Changes publish power.status; the state, with the
+// charge and runtime left where the provider knows them, is reported at
+// /api/v1/system.
+
+// POWER_PROVIDER values
+const (
+	powerProviderAuto    = "auto"
+	powerProviderSysfs   = "sysfs"
+	powerProviderApcupsd = "apcupsd"
+	powerProviderNUT     = "nut"
+	powerProviderOff     = "off"
+)
+
+// LOW_POWER_POLICY actions
+const (
+	lowPowerSources  = "sources"
+	lowPowerBatch    = "batch"
+	lowPowerSync     = "sync"
+	lowPowerTraining = "training"
+)
+
+const defaultLowPowerPolicy = "sources,batch,sync,training"
+
+// upsTimeout bounds a query of apcupsd or upsd
+const upsTimeout = 5 * time.Second
+
+// PowerStatus is the node's power supply, the payload of power.status
+type PowerStatus struct {
+	Provider       string     `json:"provider"`
+	OnBattery      bool       `json:"on_battery"`
+	Since          *time.Time `json:"since,omitempty"`            // on battery since
+	ChargePercent  *float64   `json:"charge_percent,omitempty"`   // battery charge
+	RuntimeSeconds *float64   `json:"runtime_seconds,omitempty"`  // estimated runtime left
+	UPSStatus      string     `json:"ups_status,omitempty"`       // as the provider reported it
+	Policy         []string   `json:"low_power_policy,omitempty"` // actions in force
+	Error          string     `json:"error,omitempty"`
+	CheckedAt      time.Time  `json:"checked_at"`
+}
+
+var power = struct {
+	sync.Mutex
+	status PowerStatus
+}{}
+
+var (
+	onBattery = newGaugeVec("yolo_on_battery",
+		"1 while the node runs on battery.")
+	batteryCharge = newGaugeVec("yolo_battery_charge_percent",
+		"Battery charge reported by the power provider.")
+	batteryRuntime = newGaugeVec("yolo_battery_runtime_seconds",
+		"Estimated battery runtime left, from the power provider.")
+)
+
+// Charge and RuntimeMinutes are for the UI banner; they are 0 when unknown
+func (p PowerStatus) Charge() float64 {
+	if p.ChargePercent == nil {
+		return 0
+	}
+	return *p.ChargePercent
+}
+
+func (p PowerStatus) RuntimeMinutes() float64 {
+	if p.RuntimeSeconds == nil {
+		return 0
+	}
+	return *p.RuntimeSeconds / 60
+}
+
+func powerView() PowerStatus {
+	power.Lock()
+	defer power.Unlock()
+	return power.status
+}
+
+// powerProvider resolves POWER_PROVIDER, auto included
+func powerProvider(cfg *Config) string {
+	if cfg.PowerProvider != powerProviderAuto {
+		return cfg.PowerProvider
+	}
+	if len(sysfsSupplies(cfg.PowerSupplyDir, "Battery")) > 0 {
+		return powerProviderSysfs
+	}
+	return powerProviderOff
+}
+
+// parseLowPowerPolicy parses LOW_POWER_POLICY
+func parseLowPowerPolicy(policy string) ([]string, error) {
+	if strings.TrimSpace(policy) == "none" {
+		return nil, nil
+	}
+	var actions []string
+	for _, a := range strings.Split(policy, ",") {
+		switch a = strings.TrimSpace(a); a {
+		case "":
+		case lowPowerSources, lowPowerBatch, lowPowerSync, lowPowerTraining:
+			actions = append(actions, a)
+		default:
+			return nil, fmt.Errorf("unknown action %q", a)
+		}
+	}
+	return actions, nil
+}
+
+// lowPower reports whether the node is on battery and LOW_POWER_POLICY
+// includes action
+func lowPower(action string) bool {
+	power.Lock()
+	defer power.Unlock()
+	if !power.status.OnBattery {
+		return false
+	}
+	for _, a := range power.status.Policy {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// lowPowerPaused reports whether the camera source name is paused on battery
+func lowPowerPaused(name string) bool {
+	if !lowPower(lowPowerSources) {
+		return false
+	}
+	for _, keep := range strings.Split(config().LowPowerKeepSources, ",") {
+		if strings.TrimSpace(keep) == name {
+			return false
+		}
+	}
+	return true
+}
+
+// startPowerCheck checks the power supply now and then every POWER_CHECK_INTERVAL
+func startPowerCheck() {
+	go func() {
+		for {
+			checkPower()
+			interval := config().PowerCheckInterval
+			if interval <= 0 {
+				interval = 30 * time.Second
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func checkPower() {
+	cfg := config()
+	st := PowerStatus{Provider: powerProvider(cfg), CheckedAt: time.Now().UTC()}
+	var err error
+	switch st.Provider {
+	case powerProviderSysfs:
+		err = readSysfsPower(cfg.PowerSupplyDir, &st)
+	case powerProviderApcupsd:
+		err = readApcupsd(cfg.ApcupsdAddr, &st)
+	case powerProviderNUT:
+		err = readNUT(cfg.NUTUPS, &st)
+	}
+
+	power.Lock()
+	prev := power.status
+	if err != nil {
+		// Keep the last known state rather than flap on a failed query
+		st.OnBattery, st.Error = prev.OnBattery, err.Error()
+	}
+	if st.OnBattery {
+		since := st.CheckedAt
+		if prev.Since != nil {
+			since = *prev.Since
+		}
+		st.Since = &since
+		st.Policy, _ = parseLowPowerPolicy(cfg.LowPowerPolicy)
+	}
+	changed := st.OnBattery != prev.OnBattery
+	power.status = st
+	power.Unlock()
+
+	if err != nil && (prev.Error == "" || prev.Provider != st.Provider) {
+		log.Printf("Warning: reading the power supply from %s failed: %v", st.Provider, err)
+	}
+	if st.OnBattery {
+		onBattery.set(1)
+	} else {
+		onBattery.set(0)
+	}
+	if st.ChargePercent != nil {
+		batteryCharge.set(*st.ChargePercent)
+	}
+	if st.RuntimeSeconds != nil {
+		batteryRuntime.set(*st.RuntimeSeconds)
+	}
+	if changed {
+		if st.OnBattery {
+			log.Printf("Warning: node is on battery (%s); low-power policy: %s", st.Provider, strings.Join(st.Policy, ", "))
+		} else {
+			log.Printf("Node is back on mains power")
+		}
+		sources.restartAll()
+		bus.publish(eventPowerStatus, st)
+	}
+}
+
+// sysfsSupplies lists the supplies of a power_supply class directory of kind
+// ("Battery", "Mains", "USB", ...)
+func sysfsSupplies(dir, kind string) []string {
+	if dir == "" {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var out []string
+	for _, path := range paths {
+		if data, err := os.ReadFile(filepath.Join(path, "type")); err == nil && strings.TrimSpace(string(data)) == kind {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+func readSysfsString(path string) string {
+	data, _ := os.ReadFile(path)
+	return strings.TrimSpace(string(data))
+}
+
+// readSysfsPower reads the batteries and external supplies under dir
+func readSysfsPower(dir string, st *PowerStatus) error {
+	batteries := sysfsSupplies(dir, "Battery")
+	if len(batteries) == 0 {
+		return fmt.Errorf("no battery under %s", dir)
+	}
+	for _, b := range batteries {
+		status := readSysfsString(filepath.Join(b, "status"))
+		if st.UPSStatus == "" {
+			st.UPSStatus = status
+		}
+		if status == "Discharging" {
+			st.OnBattery = true
+		}
+		if c, err := readSysfsInt(filepath.Join(b, "capacity")); err == nil && st.ChargePercent == nil {
+			charge := float64(c)
+			st.ChargePercent = &charge
+		}
+	}
+	external := append(sysfsSupplies(dir, "Mains"), sysfsSupplies(dir, "USB")...)
+	online := false
+	for _, s := range external {
+		if readSysfsString(filepath.Join(s, "online")) == "1" {
+			online = true
+		}
+	}
+	if len(external) > 0 && !online {
+		st.OnBattery = true
+	}
+	return nil
+}
+
+// readApcupsd asks apcupsd's network information server for its status.
+// Messages either way are a two-byte big-endian length and the text; the
+// reply is one "KEY : value" line per message, up to an empty message.
+func readApcupsd(addr string, st *PowerStatus) error {
+	conn, err := net.DialTimeout("tcp", addr, upsTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upsTimeout))
+	if _, err := conn.Write(append([]byte{0, 6}, "status"...)); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	vars := map[string]string{}
+	for {
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		if size == 0 {
+			break
+		}
+		line := make([]byte, size)
+		if _, err := io.ReadFull(r, line); err != nil {
+			return err
+		}
+		if k, v, ok := strings.Cut(string(line), ":"); ok {
+			vars[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	status, ok := vars["STATUS"]
+	if !ok {
+		return fmt.Errorf("apcupsd at %s sent no STATUS", addr)
+	}
+	st.UPSStatus = status
+	st.OnBattery = strings.Contains(status, "ONBATT")
+	// "BCHARGE : 100.0 Percent", "TIMELEFT : 45.0 Minutes"
+	if f, ok := leadingFloat(vars["BCHARGE"]); ok {
+		st.ChargePercent = &f
+	}
+	if f, ok := leadingFloat(vars["TIMELEFT"]); ok {
+		f *= 60
+		st.RuntimeSeconds = &f
+	}
+	return nil
+}
+
+// readNUT lists the variables of a UPS from upsd; ups is name@host[:port]
+func readNUT(ups string, st *PowerStatus) error {
+	name, host, _ := strings.Cut(ups, "@")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "3493")
+	}
+	conn, err := net.DialTimeout("tcp", host, upsTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upsTimeout))
+	if _, err := fmt.Fprintf(conn, "LIST VAR %s\n", name); err != nil {
+		return err
+	}
+	vars := map[string]string{}
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "ERR ") {
+			return fmt.Errorf("upsd at %s: %s", host, strings.TrimPrefix(line, "ERR "))
+		}
+		if strings.HasPrefix(line, "END LIST VAR") {
+			break
+		}
+		// VAR <ups> <name> "<value>"
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) == 4 && fields[0] == "VAR" {
+			vars[fields[2]] = strings.Trim(fields[3], `"`)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	status, ok := vars["ups.status"]
+	if !ok {
+		return fmt.Errorf("upsd at %s sent no ups.status for %s", host, name)
+	}
+	st.UPSStatus = status
+	for _, flag := range strings.Fields(status) {
+		if flag == "OB" {
+			st.OnBattery = true
+		}
+	}
+	if f, ok := leadingFloat(vars["battery.charge"]); ok {
+		st.ChargePercent = &f
+	}
+	if f, ok := leadingFloat(vars["battery.runtime"]); ok {
+		st.RuntimeSeconds = &f
+	}
+	return nil
+}
+
+// leadingFloat parses the number a value starts with, e.g. "45.0 Minutes"
+func leadingFloat(v string) (float64, bool) {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(fields[0], 64)
+	return f, err == nil
+}
+
+// validatePower checks the power settings
+func validatePower(cfg *Config) error {
+	switch cfg.PowerProvider {
+	case powerProviderAuto, powerProviderSysfs, powerProviderApcupsd, powerProviderNUT, powerProviderOff:
+	default:
+		return fmt.Errorf("POWER_PROVIDER must be auto, sysfs, apcupsd, nut or off")
+	}
+	if _, err := parseLowPowerPolicy(cfg.LowPowerPolicy); err != nil {
+		return fmt.Errorf("LOW_POWER_POLICY: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// Privacy mode redacts regions of sensitive classes (people, faces, license
+// plates) in every image the node stores or displays, and never keeps the raw
+// upload. Redaction happens after inference, so the model still sees the
+// original frame but nothing unredacted outlives the request.
+
+// privacyClasses returns the configured classes to redact, lowercased
+func privacyClasses() map[string]bool {
+	classes := map[string]bool{}
+	for _, c := range strings.Split(config().PrivacyClasses, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			classes[c] = true
+		}
+	}
+	return classes
+}
+
+// needsRedaction reports whether any detection belongs to a privacy class
+func needsRedaction(detections []Detection) bool {
+	classes := privacyClasses()
+	for _, d := range detections {
+		if classes[strings.ToLower(d.ClassName)] {
+			return true
+		}
+	}
+	return false
+}
+
+// redactImage returns a copy of img with every privacy-class detection blurred
+// (PRIVACY_METHOD=blur) or filled solid black (PRIVACY_METHOD=mask)
+func redactImage(img image.Image, detections []Detection) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+
+	classes := privacyClasses()
+	for _, d := range detections {
+		if !classes[strings.ToLower(d.ClassName)] {
+			continue
+		}
+		// Pad the box a little so edges of faces/plates don't leak
+		padX := (d.BBox.X2 - d.BBox.X1) * 0.05
+		padY := (d.BBox.Y2 - d.BBox.Y1) * 0.05
+		r := image.Rect(
+			b.Min.X+int(d.BBox.X1-padX), b.Min.Y+int(d.BBox.Y1-padY),
+			b.Min.X+int(d.BBox.X2+padX+1), b.Min.Y+int(d.BBox.Y2+padY+1),
+		).Intersect(b)
+		if r.Empty() {
+			continue
+		}
+		if config().PrivacyMethod == "mask" {
+			draw.Draw(out, r, image.NewUniform(color.Black), image.Point{}, draw.Src)
+		} else {
+			blurRegion(out, r)
+		}
+	}
+	return out
+}
+
+// blurRegion applies three passes of a separable box blur (approximately
+// Gaussian) to r, with a radius scaled to the region so details are unrecoverable
+func blurRegion(img *image.RGBA, r image.Rectangle) {
+	radius := maxInt(r.Dx(), r.Dy()) / 8
+	if radius < 8 {
+		radius = 8
+	}
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(img, r, radius, true)
+		boxBlur(img, r, radius, false)
+	}
+}
+
+// boxBlur averages each pixel in r with its neighbours along one axis,
+// sampling only inside r so the blur doesn't pull in surrounding content
+func boxBlur(img *image.RGBA, r image.Rectangle, radius int, horizontal bool) {
+	lines, length := r.Dy(), r.Dx()
+	if !horizontal {
+		lines, length = r.Dx(), r.Dy()
+	}
+	offset := func(line, i int) int {
+		if horizontal {
+			return img.PixOffset(r.Min.X+i, r.Min.Y+line)
+		}
+		return img.PixOffset(r.Min.X+line, r.Min.Y+i)
+	}
+
+	buf := make([]uint8, length*4)
+	for line := 0; line < lines; line++ {
+		for i := 0; i < length; i++ {
+			copy(buf[i*4:i*4+4], img.Pix[offset(line, i):offset(line, i)+4])
+		}
+		var sum [4]int
+		// Running window over [i-radius, i+radius], clamped to the region
+		for i := 0; i <= radius && i < length; i++ {
+			for c := 0; c < 4; c++ {
+				sum[c] += int(buf[i*4+c])
+			}
+		}
+		for i := 0; i < length; i++ {
+			lo, hi := maxInt(i-radius, 0), minInt(i+radius, length-1)
+			n := hi - lo + 1
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				img.Pix[o+c] = uint8(sum[c] / n)
+			}
+			if add := i + radius + 1; add < length {
+				for c := 0; c < 4; c++ {
+					sum[c] += int(buf[add*4+c])
+				}
+			}
+			if drop := i - radius; drop >= 0 {
+				for c := 0; c < 4; c++ {
+					sum[c] -= int(buf[drop*4+c])
+				}
+			}
+		}
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The protocol between the web UI and the inference command (see infer.py):
+//
+//   - stdout carries only results, one JSON object per line with a "protocol"
+//     version, the model's "classes" by ID and optionally its "speed" (ms
+//     spent in preprocess, inference and postprocess); logs go to stderr
+//   - a long-lived command reads one JSON request per stdin line and answers
+//     each with one result line
+//   - exit codes: 0 results were printed, 2 usage error, 3 the model could
+//     not be loaded (after printing an error result); anything else is a crash
+//
+// Non-JSON lines on stdout are kept with the stderr lines rather than parsed.
+// Results without a version are accepted as coming from a pre-protocol
+// command; newer versions are rejected.
+const inferenceProtocol = 1
+
+// Exit codes of the inference command
+const (
+	exitUsage = 2
+	exitModel = 3
+)
+
+// errNotResult marks a stdout line that is not a result
+var errNotResult = errors.New("not a result line")
+
+type protocolReply struct {
+	Protocol *int              `json:"protocol"`
+	Classes  map[string]string `json:"classes"`
+	Speed    *modelSpeed       `json:"speed"`
+	InferenceResult
+}
+
+// parseReply decodes one result line
+func parseReply(line []byte) (InferenceResult, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return InferenceResult{}, errNotResult
+	}
+	var reply protocolReply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return InferenceResult{}, fmt.Errorf("failed to parse results: %v", err)
+	}
+	if reply.Protocol != nil && *reply.Protocol > inferenceProtocol {
+		return InferenceResult{}, fmt.Errorf("unsupported inference protocol version %d (this build speaks %d)",
+			*reply.Protocol, inferenceProtocol)
+	}
+	reply.InferenceResult.classes = reply.Classes
+	reply.InferenceResult.speed = reply.Speed
+	return reply.InferenceResult, nil
+}
+
+// parseOneShot interprets the output and exit status of a one-shot inference command
+func parseOneShot(stdout, stderr []byte, runErr error, stray func(string)) (InferenceResult, error) {
+	var result InferenceResult
+	var parseErr error
+	found := false
+	for _, line := range bytes.Split(stdout, []byte("\n")) {
+		r, err := parseReply(line)
+		switch {
+		case err == errNotResult:
+			if s := strings.TrimSpace(string(line)); s != "" {
+				stray("stdout: " + s)
+			}
+		case err != nil:
+			parseErr = err
+		default:
+			result, found = r, true
+		}
+	}
+
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			switch exitErr.ExitCode() {
+			case exitModel:
+				if found && result.Error != "" {
+					return InferenceResult{}, fmt.Errorf("model unavailable: %s", result.Error)
+				}
+				return InferenceResult{}, fmt.Errorf("model unavailable: %s", lastLine(stderr))
+			case exitUsage:
+				return InferenceResult{}, fmt.Errorf("inference command usage error: %s", lastLine(stderr))
+			}
+		}
+		return InferenceResult{}, fmt.Errorf("%v: %s", runErr, lastLine(stderr))
+	}
+	if !found {
+		if parseErr != nil {
+			return InferenceResult{}, parseErr
+		}
+		return InferenceResult{}, fmt.Errorf("inference command printed no result")
+	}
+	return result, nil
+}
+
+// lastLine returns the last non-empty line of output, for error messages
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
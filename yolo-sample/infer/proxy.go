@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Behind an ingress or reverse proxy the node may be published at a
+// sub-path: BASE_URL=/yolo/ (or --base-url /yolo/, or a full URL whose path
+// is used) makes every link, form, redirect, script fetch, event stream and
+// cookie of the UI point under /yolo/. Requests are accepted with or without
+// the prefix, so it does not matter whether the proxy strips it, and the
+// node still answers on its own address.
+//
+// Requests from TRUSTED_PROXIES (CIDRs; loopback by default, "none" to
+// trust no one) may say what the client saw:
+//
+//	X-Forwarded-For     the client address, for logs and RATE_LIMIT
+//	X-Forwarded-Proto   https makes cookies Secure and permalinks https
+//	X-Forwarded-Host    the host of permalinks and same-site redirects
+//	X-Forwarded-Prefix  the sub-path, when it is not BASE_URL
+//
+// Other clients' X-Forwarded-* headers are ignored. An ingress that is not on
+// the node itself has to be listed: private ranges are not trusted by
+// default, as on an edge LAN they hold every client, and any of them could
+// pick its own rate-limit key or permalink host.
+
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
+
+// forwarded is what withForwarded learnt about a request
+type forwarded struct {
+	base  string // sub-path the client used, "" or e.g. "/yolo"
+	https bool
+}
+
+type forwardedKey struct{}
+
+// normalizeBasePath turns a BASE_URL into the sub-path it names, "" for the root
+func normalizeBasePath(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Host != "" {
+		value = u.Path
+	}
+	value = strings.Trim(strings.TrimSpace(value), "/")
+	if value == "" {
+		return ""
+	}
+	// Escaped, so it is safe to paste into links and scripts
+	return (&url.URL{Path: "/" + value}).EscapedPath()
+}
+
+// basePath returns the sub-path r's links start with
+func basePath(r *http.Request) string {
+	if f, ok := r.Context().Value(forwardedKey{}).(forwarded); ok {
+		return f.base
+	}
+	return normalizeBasePath(config().BaseURL)
+}
+
+// isHTTPS reports whether the client reached the node over HTTPS, directly
+// or through a trusted proxy
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	f, _ := r.Context().Value(forwardedKey{}).(forwarded)
+	return f.https
+}
+
+// parseServerFlags reads the flags of the server itself; --base-url stands
+// in for BASE_URL, which a reload then keeps
+func parseServerFlags(args []string) error {
+	fs := flag.NewFlagSet("webui", flag.ContinueOnError)
+	baseURL := fs.String("base-url", config().BaseURL, "sub-path the UI is published at behind a proxy, e.g. /yolo/ (BASE_URL)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *baseURL != config().BaseURL {
+		os.Setenv("BASE_URL", *baseURL)
+		cfg := *config()
+		cfg.BaseURL = *baseURL
+		currentConfig.Store(&cfg)
+	}
+	return nil
+}
+
+// redirectTo redirects to path, a path of the UI
+func redirectTo(w http.ResponseWriter, r *http.Request, path string, code int) {
+	http.Redirect(w, r, basePath(r)+path, code)
+}
+
+var (
+	trustedMu     sync.Mutex
+	trustedSource string
+	trustedNets   []*net.IPNet
+)
+
+// parseTrustedProxies parses a TRUSTED_PROXIES list; single addresses are
+// taken as /32 or /128
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	if strings.TrimSpace(list) == "none" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether ip is one of TRUSTED_PROXIES
+func trustedProxy(ip net.IP) bool {
+	list := config().TrustedProxies
+	trustedMu.Lock()
+	if list != trustedSource || trustedNets == nil {
+		nets, err := parseTrustedProxies(list)
+		if err != nil {
+			log.Printf("Warning: TRUSTED_PROXIES: %v; trusting no proxy", err)
+		}
+		trustedSource, trustedNets = list, append([]*net.IPNet{}, nets...)
+	}
+	nets := trustedNets
+	trustedMu.Unlock()
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// firstValue returns the first entry of a comma-separated header
+func firstValue(h http.Header, name string) string {
+	v, _, _ := strings.Cut(h.Get(name), ",")
+	return strings.TrimSpace(v)
+}
+
+// forwardedClient returns the client address of an X-Forwarded-For chain:
+// the right-most entry that is not itself a trusted proxy
+func forwardedClient(chain string) net.IP {
+	hops := strings.Split(chain, ",")
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// withForwarded applies the X-Forwarded-* headers of trusted proxies and
+// takes the sub-path off request paths
+func withForwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := forwarded{base: normalizeBasePath(config().BaseURL)}
+		if ip := remoteIP(r.RemoteAddr); ip != nil && trustedProxy(ip) {
+			if chain := r.Header.Get("X-Forwarded-For"); chain != "" {
+				if client := forwardedClient(chain); client != nil {
+					r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+				}
+			}
+			f.https = strings.EqualFold(firstValue(r.Header, "X-Forwarded-Proto"), "https")
+			if host := firstValue(r.Header, "X-Forwarded-Host"); host != "" {
+				r.Host = host
+			}
+			if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" {
+				f.base = normalizeBasePath(prefix)
+			}
+		}
+
+		if f.base != "" {
+			switch path := r.URL.Path; {
+			case path == f.base:
+				target := f.base + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			case strings.HasPrefix(path, f.base+"/"):
+				r.URL.Path = strings.TrimPrefix(path, f.base)
+				r.URL.RawPath = ""
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey{}, f)))
+	})
+}
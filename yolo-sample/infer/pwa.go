@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The web UI is installable as a Progressive Web App so it can be pinned on the
+// tablets used at edge sites. A service worker caches the UI shell and the
+// "last known results" feed; when the tablet loses its connection to the node,
+// pages fall back to their cached copy and anything else to the /offline page,
+// which renders the cached feed. Browsers only register service workers in a
+// secure context, so the UI must be served over HTTPS (or from localhost).
+
+// lastKnownLimit bounds how many results the offline feed carries
+const lastKnownLimit = 50
+
+// pwaVersion names the shell cache; it changes on every start so a deployed
+// update replaces the cached pages instead of serving the old ones
+var pwaVersion = strconv.FormatInt(time.Now().Unix(), 36)
+
+// pwaHead returns the manifest link and service worker registration for the
+// heads of pages rendered for r
+func pwaHead(r *http.Request) template.HTML {
+	base := template.HTMLEscapeString(basePath(r))
+	return template.HTML(`<link rel="manifest" href="` + base + `/manifest.webmanifest">
+    <meta name="theme-color" content="` + brandPrimaryColor() + `">
+    <link rel="apple-touch-icon" href="` + base + `/icons/icon-192.png">
+    <script nonce="` + cspNonce(r) + `">if ('serviceWorker' in navigator) { navigator.serviceWorker.register('` + base + `/sw.js'); }</script>`)
+}
+
+// manifestHandler serves GET /manifest.webmanifest
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	icons := []map[string]string{}
+	for _, size := range []int{192, 512} {
+		icons = append(icons, map[string]string{
+			"src":     basePath(r) + "/icons/icon-" + strconv.Itoa(size) + ".png",
+			"sizes":   strconv.Itoa(size) + "x" + strconv.Itoa(size),
+			"type":    "image/png",
+			"purpose": "any maskable",
+		})
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":             config().BrandTitle,
+		"short_name":       config().BrandTitle,
+		"start_url":        basePath(r) + "/",
+		"scope":            basePath(r) + "/",
+		"display":          "standalone",
+		"background_color": "#f5f5f5",
+		"theme_color":      brandPrimaryColor(),
+		"icons":            icons,
+	})
+}
+
+var (
+	iconMu    sync.Mutex
+	iconCache = map[int][]byte{}
+)
+
+// appIcon renders a size x size PNG: a white detection box on the brand colour,
+// kept inside the central safe zone so it also works as a maskable icon
+func appIcon(size int) []byte {
+	iconMu.Lock()
+	defer iconMu.Unlock()
+	if data, ok := iconCache[size]; ok {
+		return data
+	}
+
+	bg := color.RGBA{0x4C, 0xAF, 0x50, 0xff}
+	if c, err := parseHexColor(brandPrimaryColor()); err == nil {
+		bg = c
+	}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+	fill := func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetRGBA(x, y, white)
+			}
+		}
+	}
+	lo, hi, stroke := size*3/10, size*7/10, size/24+1
+	fill(lo, lo, hi, lo+stroke)
+	fill(lo, hi-stroke, hi, hi)
+	fill(lo, lo, lo+stroke, hi)
+	fill(hi-stroke, lo, hi, hi)
+	fill(lo, lo-size/12, lo+size/5, lo) // label tab
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	iconCache[size] = buf.Bytes()
+	return iconCache[size]
+}
+
+// parseHexColor parses #rgb or #rrggbb
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return color.RGBA{}, strconv.ErrSyntax
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// iconHandler serves GET /icons/icon-{192,512}.png
+func iconHandler(w http.ResponseWriter, r *http.Request) {
+	var size int
+	switch strings.TrimPrefix(r.URL.Path, "/icons/") {
+	case "icon-192.png":
+		size = 192
+	case "icon-512.png":
+		size = 512
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(appIcon(size))
+}
+
+// serviceWorker caches the shell on install, answers page loads network-first
+// with the cached copy or /offline as fallback, and keeps the last known
+// results feed and the images it references in a data cache.
+const serviceWorker = `const SHELL_CACHE = 'shell-{{.Version}}';
+const DATA_CACHE = 'data';
+const BASE = '{{.Base}}';
+const SHELL = [BASE + '/', BASE + '/offline', BASE + '/manifest.webmanifest', BASE + '/icons/icon-192.png', BASE + '/icons/icon-512.png'];
+const MAX_IMAGES = {{.Limit}};
+
+self.addEventListener('install', event => {
+    event.waitUntil(caches.open(SHELL_CACHE).then(c => c.addAll(SHELL)).then(() => self.skipWaiting()));
+});
+
+self.addEventListener('activate', event => {
+    event.waitUntil(caches.keys()
+        .then(keys => Promise.all(keys.filter(k => k.startsWith('shell-') && k !== SHELL_CACHE).map(k => caches.delete(k))))
+        .then(() => self.clients.claim()));
+});
+
+// networkFirst answers from the network, refreshing cacheName, and from the cache when offline
+function networkFirst(request, cacheName) {
+    return fetch(request).then(resp => {
+        if (resp.ok) {
+            const copy = resp.clone();
+            caches.open(cacheName).then(c => c.put(request, copy));
+        }
+        return resp;
+    }).catch(() => caches.match(request));
+}
+
+// trimImages keeps only the newest MAX_IMAGES cached images
+function trimImages() {
+    return caches.open(DATA_CACHE).then(c => c.keys().then(keys => {
+        const images = keys.filter(k => new URL(k.url).pathname.startsWith(BASE + '/images/'));
+        return Promise.all(images.slice(0, Math.max(0, images.length - MAX_IMAGES)).map(k => c.delete(k)));
+    }));
+}
+
+self.addEventListener('fetch', event => {
+    const req = event.request;
+    const url = new URL(req.url);
+    if (req.method !== 'GET' || url.origin !== self.location.origin) {
+        return;
+    }
+    if (url.pathname === BASE + '/api/v2/last-known') {
+        event.respondWith(networkFirst(req, DATA_CACHE).then(resp => resp || new Response('{"results": []}', {headers: {'Content-Type': 'application/json'}})));
+        return;
+    }
+    if (url.pathname.startsWith(BASE + '/images/')) {
+        event.respondWith(caches.match(req).then(hit => hit || fetch(req).then(resp => {
+            if (resp.ok) {
+                const copy = resp.clone();
+                caches.open(DATA_CACHE).then(c => c.put(req, copy)).then(trimImages);
+            }
+            return resp;
+        })));
+        return;
+    }
+    if (req.mode === 'navigate') {
+        const cacheable = SHELL.includes(url.pathname) && !url.search;
+        const online = cacheable ? networkFirst(req, SHELL_CACHE) : fetch(req).catch(() => caches.match(req, {ignoreSearch: true}));
+        event.respondWith(online.then(resp => resp || caches.match(BASE + '/offline')));
+        return;
+    }
+    if (SHELL.includes(url.pathname)) {
+        event.respondWith(caches.match(req).then(hit => hit || fetch(req)));
+    }
+});
+`
+
+var serviceWorkerTmpl = template.Must(template.New("sw").Parse(serviceWorker))
+
+// serviceWorkerHandler serves GET /sw.js; it has to live at the root (of the
+// sub-path, see proxy.go) to control every page
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	serviceWorkerTmpl.Execute(w, map[string]interface{}{"Version": pwaVersion, "Limit": lastKnownLimit, "Base": basePath(r)})
+}
+
+// LastKnownResult is a compact result for the offline feed
+type LastKnownResult struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    string    `json:"source,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Count     int       `json:"count"`
+	Summary   string    `json:"summary"`
+	Image     string    `json:"image,omitempty"` // URL of the stored image, if any
+	Error     string    `json:"error,omitempty"`
+}
+
+// lastKnownHandler serves GET /api/v1/last-known: the newest results and the
+// node status as of now, for the service worker to cache for offline viewing
+func lastKnownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	lang := negotiateLanguage(r)
+	status := getNodeStatus()
+	out := []LastKnownResult{}
+	for _, res := range results.list(lastKnownLimit) {
+		item := LastKnownResult{
+			ID:        res.ID,
+			CreatedAt: res.CreatedAt,
+			Source:    res.Source,
+			Model:     res.Model,
+			Count:     res.Count,
+			Summary:   translate(lang, detectionSummary(res)),
+			Error:     res.Error,
+		}
+		if res.StoredImage != "" {
+			item.Image = imageURL(basePath(r), res, "small")
+		}
+		out = append(out, item)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generated_at":               time.Now().UTC(),
+		"network_status":             status.NetworkStatus,
+		"network_status_stale_since": staleSinceJSON(status.StaleSince),
+		"results":                    out,
+	})
+}
+
+// offlinePageHandler serves GET /offline, which renders whatever copy of the
+// last known results feed the service worker has
+func offlinePageHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Last Known Results"}} - {{brandTitle}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            margin-top: 20px;
+        }
+        .summary {
+            color: #666;
+            font-size: 14px;
+        }
+        .result {
+            display: flex;
+            gap: 12px;
+            align-items: center;
+            padding: 8px 0;
+            border-bottom: 1px solid #eee;
+        }
+        .result img {
+            width: 96px;
+            height: 72px;
+            object-fit: cover;
+            border-radius: 4px;
+        }
+        .error {
+            color: #d32f2f;
+        }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Last Known Results"}}</h1>
+    <p class="summary" id="asOf"></p>
+    <div class="panel" id="results">{{t "Loading..."}}</div>
+    <p><a href="{{base}}/">{{t "← Back to Upload"}}</a></p>
+    <script nonce="{{cspNonce}}">
+        const asOf = {{t "Results as of %s (node network: %s)"}};
+        const offline = {{t "This device is offline; showing the last results it received."}};
+        fetch({{base}} + '/api/v2/last-known').then(r => r.json()).then(feed => {
+            const box = document.getElementById('results');
+            box.textContent = '';
+            let note = feed.generated_at ? asOf.replace('%s', new Date(feed.generated_at).toLocaleString()).replace('%s', feed.network_status) : '';
+            if (!navigator.onLine) {
+                note = offline + ' ' + note;
+            }
+            document.getElementById('asOf').textContent = note;
+            if (!feed.results || feed.results.length === 0) {
+                box.textContent = {{t "No results available offline yet."}};
+                return;
+            }
+            for (const res of feed.results) {
+                const row = document.createElement('div');
+                row.className = 'result';
+                if (res.image) {
+                    const img = document.createElement('img');
+                    img.src = res.image;
+                    img.alt = '';
+                    img.onerror = () => img.remove();
+                    row.appendChild(img);
+                }
+                const text = document.createElement('div');
+                const link = document.createElement('a');
+                link.href = {{base}} + '/results/' + res.id;
+                link.textContent = new Date(res.created_at).toLocaleString();
+                const detail = document.createElement('div');
+                detail.className = res.error ? 'summary error' : 'summary';
+                detail.textContent = [res.source, res.model, res.error || res.summary].filter(Boolean).join(' · ');
+                text.appendChild(link);
+                text.appendChild(detail);
+                row.appendChild(text);
+                box.appendChild(row);
+            }
+        }).catch(() => {
+            document.getElementById('results').textContent = {{t "No results available offline yet."}};
+        });
+    </script>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("offline").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, nil)
+}
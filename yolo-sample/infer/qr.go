@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A small QR code encoder for result permalinks: byte mode, error correction
+// level M, versions 1-10 (up to 213 bytes), which comfortably fits a URL.
+
+// qrVersion describes the error correction block layout of a version at level M
+type qrVersion struct {
+	ecPerBlock int
+	blocks     [][2]int // {count, data codewords per block}
+}
+
+var qrVersions = []qrVersion{
+	1:  {10, [][2]int{{1, 16}}},
+	2:  {16, [][2]int{{1, 28}}},
+	3:  {26, [][2]int{{1, 44}}},
+	4:  {18, [][2]int{{2, 32}}},
+	5:  {24, [][2]int{{2, 43}}},
+	6:  {16, [][2]int{{4, 27}}},
+	7:  {18, [][2]int{{4, 31}}},
+	8:  {22, [][2]int{{2, 38}, {2, 39}}},
+	9:  {22, [][2]int{{3, 36}, {2, 37}}},
+	10: {26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+// qrAlignment returns the alignment pattern centres of a version (1-40)
+func qrAlignment(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	align := make([]int, n)
+	align[0] = 6
+	for i, pos := n-1, 17+4*version-7; i > 0; i, pos = i-1, pos-step {
+		align[i] = pos
+	}
+	return align
+}
+
+func (v qrVersion) dataCodewords() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b[0] * b[1]
+	}
+	return n
+}
+
+// qrCode is a square grid of modules; true is dark
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // modules reserved for finder, timing, alignment and format patterns
+}
+
+// encodeQR returns the QR code for data, or an error if it is too long
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrVersions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("data too long for a QR code (%d bytes)", len(data))
+	}
+	ver := qrVersions[version]
+
+	// Bit stream: byte mode indicator, length, data, terminator, padding
+	var bits []bool
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>uint(i))&1 == 1)
+		}
+	}
+	appendBits(0x4, 4)
+	if version >= 10 {
+		appendBits(len(data), 16)
+	} else {
+		appendBits(len(data), 8)
+	}
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	capacity := 8 * ver.dataCodewords()
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	// Split into blocks, add Reed-Solomon error correction, and interleave
+	var dataBlocks, ecBlocks [][]byte
+	gen := rsGenerator(ver.ecPerBlock)
+	offset := 0
+	for _, group := range ver.blocks {
+		for i := 0; i < group[0]; i++ {
+			block := codewords[offset : offset+group[1]]
+			offset += group[1]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, gen))
+		}
+	}
+	var final []byte
+	for i := 0; ; i++ {
+		added := false
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				final = append(final, b[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	for i := 0; i < ver.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			final = append(final, b[i])
+		}
+	}
+
+	q := newQRCode(version)
+	q.placeData(final)
+
+	// Apply the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // masking is its own inverse
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q, nil
+}
+
+// newQRCode returns an empty symbol of a version (1-40) with its function
+// patterns drawn
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	// Finder patterns with separators
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := maxInt(absInt(dx), absInt(dy))
+				q.setFunction(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	// Alignment patterns, skipping those overlapping the finders
+	align := qrAlignment(version)
+	for i, ax := range align {
+		for j, ay := range align {
+			last := len(align) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(ax+dx, ay+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Reserve the format areas (drawn per mask) and draw version information
+	q.drawFormatBits(0)
+	if version >= 7 {
+		bits := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			q.setFunction(a, b, bit)
+			q.setFunction(b, a, bit)
+		}
+	}
+	return q
+}
+
+// qrVersionBits returns the 18-bit version information of a version (7-40)
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// qrFormatBits returns the 15-bit format information of an error correction
+// level (as encoded: L 01, M 00, Q 11, H 10) and mask
+func qrFormatBits(level, mask int) int {
+	data := level<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormatBits writes the level M format information for mask in both copies
+func (q *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(0, mask) // level M is 00
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true) // dark module
+}
+
+// placeData fills the non-function modules in the standard zigzag order
+func (q *qrCode) placeData(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] {
+					continue
+				}
+				if i < len(data)*8 {
+					q.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 == 1
+				}
+				i++ // remainder bits stay light
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four standard mask evaluation rules
+func (q *qrCode) penalty() int {
+	n := q.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	score := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Rule 1: runs of five or more same-coloured modules
+			for x, run := 0, 0; x < n; x++ {
+				if x > 0 && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					score += 3
+				} else if run > 5 {
+					score++
+				}
+			}
+			// Rule 3: finder-like 1:1:3:1:1 patterns with four light modules on a side
+			for x := 0; x+7 <= n; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if at(x+k, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				lightBefore, lightAfter := true, true
+				for k := 1; k <= 4; k++ {
+					if x-k >= 0 && at(x-k, y, transpose) {
+						lightBefore = false
+					}
+					if x+6+k < n && at(x+6+k, y, transpose) {
+						lightAfter = false
+					}
+				}
+				if lightBefore || lightAfter {
+					score += 40
+				}
+			}
+		}
+	}
+	// Rule 2: 2x2 blocks of one colour
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	// Rule 4: balance of dark and light modules
+	percent := dark * 100 / (n * n)
+	score += absInt(percent-50) / 5 * 10
+	return score
+}
+
+// svg renders the code with a four-module quiet zone, scale pixels per module
+func (q *qrCode) svg(scale int) string {
+	total := (q.size + 8) * scale
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		total, total, q.size+8, q.size+8)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				fmt.Fprintf(&b, "M%d,%dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// Reed-Solomon arithmetic over GF(256) with the QR polynomial x^8+x^4+x^3+x^2+1
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1D
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// rsGenerator returns the coefficients (highest degree first, leading 1 omitted)
+// of the generator polynomial of the given degree
+func rsGenerator(degree int) []byte {
+	gen := make([]byte, degree)
+	gen[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < degree {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return gen
+}
+
+func rsRemainder(data, gen []byte) []byte {
+	rem := make([]byte, len(gen))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+	return rem
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Reading QR codes, the reverse of qr.go. Finder patterns are found as
+// 1:1:3:1:1 runs confirmed across both axes; three of them fix the symbol's
+// position, rotation and version, and the bottom-right alignment pattern
+// corrects for perspective. The sampled modules then go through the format
+// information, unmasking, deinterleaving and Reed-Solomon correction. All
+// versions and error correction levels are read; kanji segments are not.
+
+// qrECCodewords and qrECBlocks are the error correction codewords per block
+// and the number of blocks, by level (L, M, Q, H) and version
+var qrECCodewords = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrECBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+var errQRUnreadable = errors.New("unreadable QR code")
+
+// qrRawModules returns the number of data and error correction modules of a version
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// qrFinder is a finder pattern candidate; count is how many scan lines found it
+type qrFinder struct {
+	x, y   float64
+	module float64
+	count  int
+}
+
+type point struct{ x, y float64 }
+
+func (p point) dist(q point) float64 { return math.Hypot(p.x-q.x, p.y-q.y) }
+
+// finderRatio reports whether five runs are in the 1:1:3:1:1 ratio of a finder pattern
+func finderRatio(r []int) bool {
+	total := 0
+	for _, n := range r[:5] {
+		if n == 0 {
+			return false
+		}
+		total += n
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	tol := m / 2
+	return math.Abs(m-float64(r[0])) < tol && math.Abs(m-float64(r[1])) < tol &&
+		math.Abs(3*m-float64(r[2])) < 3*tol && math.Abs(m-float64(r[3])) < tol && math.Abs(m-float64(r[4])) < tol
+}
+
+// crossCheck measures the finder pattern runs through position t of the
+// column (vertical) or row fixed, returning the pattern's centre along that
+// line and its width. Runs around the centre may be at most maxRun long.
+func (b *bitMatrix) crossCheck(fixed, t int, vertical bool, maxRun int) (float64, int, bool) {
+	n, get := b.w, func(i int) bool { return b.at(i, fixed) }
+	if vertical {
+		n, get = b.h, func(i int) bool { return b.at(fixed, i) }
+	}
+	if t < 0 || t >= n || !get(t) {
+		return 0, 0, false
+	}
+	var r [5]int
+	i := t
+	for ; i >= 0 && get(i); i-- {
+		r[2]++
+	}
+	for ; i >= 0 && !get(i) && r[1] <= maxRun; i-- {
+		r[1]++
+	}
+	if i < 0 || r[1] > maxRun {
+		return 0, 0, false
+	}
+	for ; i >= 0 && get(i) && r[0] <= maxRun; i-- {
+		r[0]++
+	}
+	if r[0] > maxRun {
+		return 0, 0, false
+	}
+	i = t + 1
+	for ; i < n && get(i); i++ {
+		r[2]++
+	}
+	for ; i < n && !get(i) && r[3] <= maxRun; i++ {
+		r[3]++
+	}
+	if i == n || r[3] > maxRun {
+		return 0, 0, false
+	}
+	for ; i < n && get(i) && r[4] <= maxRun; i++ {
+		r[4]++
+	}
+	if r[4] > maxRun || !finderRatio(r[:]) {
+		return 0, 0, false
+	}
+	total := r[0] + r[1] + r[2] + r[3] + r[4]
+	return float64(i-r[4]-r[3]) - float64(r[2])/2, total, true
+}
+
+// findQRFinders scans the rows of b for finder patterns
+func findQRFinders(b *bitMatrix) []qrFinder {
+	var found []qrFinder
+	for y := 0; y < b.h; y++ {
+		runs, starts := lineRuns(b.w, func(x int) bool { return b.at(x, y) })
+		for i := 1; i+4 < len(runs); i += 2 {
+			if !finderRatio(runs[i : i+5]) {
+				continue
+			}
+			total := runs[i] + runs[i+1] + runs[i+2] + runs[i+3] + runs[i+4]
+			cx := float64(starts[i+2]) + float64(runs[i+2])/2
+			cy, vtotal, ok := b.crossCheck(int(cx), y, true, runs[i+2])
+			if !ok || 5*absInt(vtotal-total) >= 2*total {
+				continue
+			}
+			cx, htotal, ok := b.crossCheck(int(cy), int(cx), false, runs[i+2])
+			if !ok || 5*absInt(htotal-total) >= 2*total {
+				continue
+			}
+			found = addFinder(found, cx, cy, float64(total+vtotal+htotal)/21)
+		}
+	}
+	return found
+}
+
+// addFinder merges a sighting into the candidate it belongs to, or adds it
+func addFinder(found []qrFinder, x, y, module float64) []qrFinder {
+	for i, f := range found {
+		if math.Abs(f.x-x) <= f.module && math.Abs(f.y-y) <= f.module && math.Abs(f.module-module) <= math.Max(1, f.module/2) {
+			n := float64(f.count)
+			found[i] = qrFinder{(f.x*n + x) / (n + 1), (f.y*n + y) / (n + 1), (f.module*n + module) / (n + 1), f.count + 1}
+			return found
+		}
+	}
+	return append(found, qrFinder{x, y, module, 1})
+}
+
+// qrTriples returns the triples of finders that may be the corners of one
+// symbol, the most square first
+func qrTriples(finders []qrFinder) [][3]qrFinder {
+	var seen []qrFinder
+	for _, f := range finders {
+		if f.count >= 2 {
+			seen = append(seen, f)
+		}
+	}
+	sort.Slice(seen, func(i, j int) bool { return seen[i].count > seen[j].count })
+	if len(seen) > 15 {
+		seen = seen[:15]
+	}
+
+	type triple struct {
+		f     [3]qrFinder
+		score float64
+	}
+	var triples []triple
+	for i := 0; i < len(seen); i++ {
+		for j := i + 1; j < len(seen); j++ {
+			for k := j + 1; k < len(seen); k++ {
+				f := [3]qrFinder{seen[i], seen[j], seen[k]}
+				lo := math.Min(f[0].module, math.Min(f[1].module, f[2].module))
+				hi := math.Max(f[0].module, math.Max(f[1].module, f[2].module))
+				if hi > 1.6*lo {
+					continue
+				}
+				// Put the corner opposite the longest side first
+				p := [3]point{{f[0].x, f[0].y}, {f[1].x, f[1].y}, {f[2].x, f[2].y}}
+				d := [3]float64{p[1].dist(p[2]), p[0].dist(p[2]), p[0].dist(p[1])}
+				c := 0
+				if d[1] > d[c] {
+					c = 1
+				}
+				if d[2] > d[c] {
+					c = 2
+				}
+				f[0], f[c] = f[c], f[0]
+				d[0], d[c] = d[c], d[0]
+				legs := d[1]*d[1] + d[2]*d[2]
+				skew := math.Abs(d[1]-d[2]) / math.Max(d[1], d[2])
+				angle := math.Abs(d[0]*d[0]-legs) / legs
+				if skew > 0.4 || angle > 0.35 || math.Min(d[1], d[2]) < 12*lo {
+					continue
+				}
+				triples = append(triples, triple{f, skew + angle})
+			}
+		}
+	}
+	sort.Slice(triples, func(i, j int) bool { return triples[i].score < triples[j].score })
+	out := make([][3]qrFinder, len(triples))
+	for i, t := range triples {
+		out[i] = t.f
+	}
+	return out
+}
+
+// readQR decodes the symbol whose top-left finder is f[0], returning its
+// text and the corners of its modules in the image
+func readQR(b *bitMatrix, f [3]qrFinder) (string, [4]point, error) {
+	tl, tr, bl := point{f[0].x, f[0].y}, point{f[1].x, f[1].y}, point{f[2].x, f[2].y}
+	// In image coordinates the top-right finder is clockwise from the bottom-left one
+	if (tr.x-tl.x)*(bl.y-tl.y)-(tr.y-tl.y)*(bl.x-tl.x) < 0 {
+		tr, bl = bl, tr
+	}
+	right, down := unit(tl, tr), unit(tl, bl)
+
+	// The size is best counted along the timing patterns; failing that, it
+	// is estimated from the distance between the finders. Their own module
+	// sizes were measured along the image axes, which overstates them for a
+	// rotated symbol, so they are measured again along the symbol's sides.
+	var versions []int
+	for _, n := range []int{timingModules(b, tl, tr, down), timingModules(b, tl, bl, right)} {
+		if n >= 21 && n <= 177 && n%4 == 1 {
+			versions = append(versions, (n-17)/4)
+		}
+	}
+	across := (moduleAlong(b, tl, right, tl.dist(tr)/2) + moduleAlong(b, tr, point{-right.x, -right.y}, tl.dist(tr)/2)) / 2
+	along := (moduleAlong(b, tl, down, tl.dist(bl)/2) + moduleAlong(b, bl, point{-down.x, -down.y}, tl.dist(bl)/2)) / 2
+	if across > 0 && along > 0 {
+		estimate := int(math.Round(((tl.dist(tr)/across+tl.dist(bl)/along)/2 + 7 - 17) / 4))
+		versions = append(versions, estimate, estimate-1, estimate+1, estimate-2, estimate+2)
+	}
+
+	err := errQRUnreadable
+	tried := map[int]bool{}
+	for _, version := range versions {
+		if version < 1 || version > 40 || tried[version] {
+			continue
+		}
+		tried[version] = true
+		size := float64(17 + 4*version)
+		ux := point{(tr.x - tl.x) / (size - 7), (tr.y - tl.y) / (size - 7)}
+		uy := point{(bl.x - tl.x) / (size - 7), (bl.y - tl.y) / (size - 7)}
+		src := [4]point{{3.5, 3.5}, {size - 3.5, 3.5}, {3.5, size - 3.5}, {size - 3.5, size - 3.5}}
+		dst := [4]point{tl, tr, bl, {tr.x + bl.x - tl.x, tr.y + bl.y - tl.y}}
+		if version >= 2 {
+			at := size - 6.5 - 3.5
+			guess := point{tl.x + at*(ux.x+uy.x), tl.y + at*(ux.y+uy.y)}
+			if p, ok := findAlignment(b, guess, ux, uy); ok {
+				src[3], dst[3] = point{size - 6.5, size - 6.5}, p
+			}
+		}
+		h, ok := homography(src, dst)
+		if !ok {
+			continue
+		}
+		grid, ok := sampleGrid(b, h, int(size))
+		if !ok {
+			continue
+		}
+		var text string
+		if text, err = decodeQRGrid(grid); err == nil {
+			var corners [4]point
+			for i, c := range []point{{0, 0}, {size, 0}, {0, size}, {size, size}} {
+				corners[i] = h.apply(c)
+			}
+			return text, corners, nil
+		}
+	}
+	return "", [4]point{}, err
+}
+
+// unit returns the unit vector from p towards q
+func unit(p, q point) point {
+	d := p.dist(q)
+	return point{(q.x - p.x) / d, (q.y - p.y) / d}
+}
+
+// moduleAlong measures the module size of the finder centred at p along
+// the direction dir, looking at most limit pixels away: the finder's dark
+// core, light ring and dark ring reach 3.5 modules from its centre on
+// either side. It returns 0 if neither side can be measured.
+func moduleAlong(b *bitMatrix, p, dir point, limit float64) float64 {
+	total, n := 0.0, 0
+	for _, sign := range []float64{1, -1} {
+		state := 0 // in the core, the light ring or the dark ring
+		for s := 0.0; s < limit; s++ {
+			x, y := int(p.x+sign*s*dir.x), int(p.y+sign*s*dir.y)
+			if x < 0 || y < 0 || x >= b.w || y >= b.h {
+				break
+			}
+			if b.at(x, y) != (state%2 == 0) {
+				if state++; state == 3 {
+					total += s
+					n++
+					break
+				}
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n) / 3.5
+}
+
+// timingModules counts the modules across a symbol along the timing pattern
+// between the finders centred at p and q, which runs 3 modules from their
+// centres in the direction side. It returns 0 if the line leaves the image.
+func timingModules(b *bitMatrix, p, q, side point) int {
+	mp, mq := moduleAlong(b, p, side, p.dist(q)/2), moduleAlong(b, q, side, p.dist(q)/2)
+	from := point{p.x + 3*mp*side.x, p.y + 3*mp*side.y}
+	to := point{q.x + 3*mq*side.x, q.y + 3*mq*side.y}
+	d := from.dist(to)
+	// From the bottom ring of one finder to the other's, every module
+	// between them is a run of its own
+	changes, last := 0, true
+	for s := 0.0; s <= d; s += 0.5 {
+		x, y := int(from.x+(to.x-from.x)*s/d), int(from.y+(to.y-from.y)*s/d)
+		if x < 0 || y < 0 || x >= b.w || y >= b.h {
+			return 0
+		}
+		if dark := b.at(x, y); dark != last {
+			changes, last = changes+1, dark
+		}
+	}
+	return changes + 13
+}
+
+// findAlignment searches around guess for an alignment pattern whose
+// modules run along ux and uy, returning its centre. The search widens
+// until it finds one, as perspective can move the pattern several modules
+// from where the finders put it and make its modules larger or smaller.
+func findAlignment(b *bitMatrix, guess, ux, uy point) (point, bool) {
+	module := math.Max(math.Hypot(ux.x, ux.y), math.Hypot(uy.x, uy.y))
+	score := func(x, y int, k float64) int {
+		n := 0
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				px := int(float64(x) + 0.5 + k*(float64(dx)*ux.x+float64(dy)*uy.x))
+				py := int(float64(y) + 0.5 + k*(float64(dx)*ux.y+float64(dy)*uy.y))
+				dark := maxInt(absInt(dx), absInt(dy)) != 1
+				if px >= 0 && py >= 0 && px < b.w && py < b.h && b.at(px, py) == dark {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	for _, allowance := range []float64{4, 8, 16} {
+		radius := int(allowance * module)
+		best, bx, by, bk := 0, 0, 0, 1.0
+		for _, k := range []float64{1, 1.25, 0.8} {
+			for y := int(guess.y) - radius; y <= int(guess.y)+radius; y++ {
+				for x := int(guess.x) - radius; x <= int(guess.x)+radius; x++ {
+					s := score(x, y, k)
+					closer := math.Hypot(float64(x)-guess.x, float64(y)-guess.y) < math.Hypot(float64(bx)-guess.x, float64(by)-guess.y)
+					if s > best || (s == best && closer) {
+						best, bx, by, bk = s, x, y, k
+					}
+				}
+			}
+		}
+		if best < 24 {
+			continue
+		}
+		// The centre of the positions that match as well, around the nearest one
+		r := int(module) + 1
+		sx, sy, n := 0, 0, 0
+		for y := by - r; y <= by+r; y++ {
+			for x := bx - r; x <= bx+r; x++ {
+				if score(x, y, bk) == best {
+					sx, sy, n = sx+x, sy+y, n+1
+				}
+			}
+		}
+		return point{float64(sx)/float64(n) + 0.5, float64(sy)/float64(n) + 0.5}, true
+	}
+	return point{}, false
+}
+
+// projective maps module coordinates to image coordinates
+type projective [8]float64
+
+func (h projective) apply(p point) point {
+	w := h[6]*p.x + h[7]*p.y + 1
+	return point{(h[0]*p.x + h[1]*p.y + h[2]) / w, (h[3]*p.x + h[4]*p.y + h[5]) / w}
+}
+
+// homography solves for the projective transform taking src to dst
+func homography(src, dst [4]point) (projective, bool) {
+	var m [8][9]float64
+	for i := 0; i < 4; i++ {
+		u, v, x, y := src[i].x, src[i].y, dst[i].x, dst[i].y
+		m[2*i] = [9]float64{u, v, 1, 0, 0, 0, -u * x, -v * x, x}
+		m[2*i+1] = [9]float64{0, 0, 0, u, v, 1, -u * y, -v * y, y}
+	}
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-9 {
+			return projective{}, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			k := m[r][col] / m[col][col]
+			for c := col; c < 9; c++ {
+				m[r][c] -= k * m[col][c]
+			}
+		}
+	}
+	var h projective
+	for i := range h {
+		h[i] = m[i][8] / m[i][i]
+	}
+	return h, true
+}
+
+// sampleGrid reads the module centres of a size x size symbol
+func sampleGrid(b *bitMatrix, h projective, size int) ([][]bool, bool) {
+	grid := make([][]bool, size)
+	for y := range grid {
+		grid[y] = make([]bool, size)
+		for x := range grid[y] {
+			p := h.apply(point{float64(x) + 0.5, float64(y) + 0.5})
+			px, py := int(p.x), int(p.y)
+			if p.x < 0 || p.y < 0 || px >= b.w || py >= b.h {
+				return nil, false
+			}
+			grid[y][x] = b.at(px, py)
+		}
+	}
+	return grid, true
+}
+
+// decodeQRGrid decodes the text of a symbol's modules, grid[y][x] true for dark
+func decodeQRGrid(grid [][]bool) (string, error) {
+	size := len(grid)
+	version := (size - 17) / 4
+	bit := func(x, y int) int {
+		if grid[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Format information, from whichever copy is closer to a valid code
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(8, i) << uint(i)
+	}
+	first |= bit(8, 7)<<6 | bit(8, 8)<<7 | bit(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(14-i, 8) << uint(i)
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(size-1-i, 8) << uint(i)
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(8, size-15+i) << uint(i)
+	}
+	level, mask, best := 0, 0, 16
+	for l := 0; l < 4; l++ {
+		for m := 0; m < 8; m++ {
+			bits := qrFormatBits(l, m)
+			for _, read := range []int{first, second} {
+				if d := hamming(bits, read); d < best {
+					level, mask, best = l, m, d
+				}
+			}
+		}
+	}
+	if best > 3 {
+		return "", errQRUnreadable
+	}
+
+	// Version information must agree with the symbol's size
+	if version >= 7 {
+		var first, second int
+		for i := 0; i < 18; i++ {
+			first |= bit(size-11+i%3, i/3) << uint(i)
+			second |= bit(i/3, size-11+i%3) << uint(i)
+		}
+		bits := qrVersionBits(version)
+		if hamming(bits, first) > 3 && hamming(bits, second) > 3 {
+			return "", errQRUnreadable
+		}
+	}
+
+	q := newQRCode(version)
+	for y := range grid {
+		copy(q.modules[y], grid[y])
+	}
+	q.applyMask(mask)
+	raw := q.readData()
+
+	// Deinterleave the blocks, correct them and join their data
+	table := level ^ 1 // format bits L 01, M 00, Q 11, H 10; tables L, M, Q, H
+	blocks, ec := qrECBlocks[table][version], qrECCodewords[table][version]
+	short := blocks - len(raw)%blocks
+	shortLen := len(raw) / blocks
+	dataLen := func(b int) int {
+		if b >= short {
+			return shortLen - ec + 1
+		}
+		return shortLen - ec
+	}
+	split := make([][]byte, blocks)
+	k := 0
+	for i := 0; i <= shortLen-ec; i++ {
+		for b := range split {
+			if i < dataLen(b) {
+				split[b] = append(split[b], raw[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < ec; i++ {
+		for b := range split {
+			split[b] = append(split[b], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for b, block := range split {
+		if err := rsCorrect(block, ec); err != nil {
+			return "", err
+		}
+		data = append(data, block[:dataLen(b)]...)
+	}
+	return parseQRSegments(data, version)
+}
+
+// readData reads the codewords from the non-function modules in placement order
+func (q *qrCode) readData() []byte {
+	version := (q.size - 17) / 4
+	data := make([]byte, qrRawModules(version)/8)
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] {
+					continue
+				}
+				if i < len(data)*8 && q.modules[y][x] {
+					data[i/8] |= 0x80 >> uint(i%8)
+				}
+				i++
+			}
+		}
+	}
+	return data
+}
+
+func hamming(a, b int) int {
+	n := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+// parseQRSegments decodes the segments of a symbol's data codewords
+func parseQRSegments(data []byte, version int) (string, error) {
+	pos := 0
+	read := func(n int) (int, bool) {
+		if pos+n > len(data)*8 {
+			return 0, false
+		}
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(data[pos/8]>>uint(7-pos%8)&1)
+			pos++
+		}
+		return v, true
+	}
+	countBits := func(small, medium, large int) int {
+		switch {
+		case version <= 9:
+			return small
+		case version <= 26:
+			return medium
+		}
+		return large
+	}
+
+	var out []byte
+	for {
+		mode, ok := read(4)
+		if !ok || mode == 0 {
+			break
+		}
+		switch mode {
+		case 1: // numeric, three digits in 10 bits
+			n, ok := read(countBits(10, 12, 14))
+			for ok && n > 0 {
+				digits, bits := minInt(n, 3), []int{0, 4, 7, 10}[minInt(n, 3)]
+				var v int
+				if v, ok = read(bits); ok {
+					out = append(out, fmt.Sprintf("%0*d", digits, v)...)
+				}
+				n -= digits
+			}
+			if !ok {
+				return "", errQRUnreadable
+			}
+		case 2: // alphanumeric, two characters in 11 bits
+			n, ok := read(countBits(9, 11, 13))
+			for ok && n > 0 {
+				var v int
+				if n == 1 {
+					if v, ok = read(6); ok && v < 45 {
+						out = append(out, qrAlphanumeric[v])
+					}
+				} else if v, ok = read(11); ok && v < 45*45 {
+					out = append(out, qrAlphanumeric[v/45], qrAlphanumeric[v%45])
+				}
+				n -= 2
+			}
+			if !ok {
+				return "", errQRUnreadable
+			}
+		case 4: // bytes
+			n, ok := read(countBits(8, 16, 16))
+			for ; ok && n > 0; n-- {
+				var v int
+				if v, ok = read(8); ok {
+					out = append(out, byte(v))
+				}
+			}
+			if !ok {
+				return "", errQRUnreadable
+			}
+		case 7: // ECI designator; the bytes are taken as UTF-8 or Latin-1 regardless
+			v, ok := read(8)
+			switch {
+			case ok && v&0x80 == 0:
+			case ok && v&0xC0 == 0x80:
+				_, ok = read(8)
+			case ok && v&0xE0 == 0xC0:
+				_, ok = read(16)
+			default:
+				ok = false
+			}
+			if !ok {
+				return "", errQRUnreadable
+			}
+		case 3: // structured append: position and parity
+			if _, ok := read(16); !ok {
+				return "", errQRUnreadable
+			}
+		case 5: // FNC1 in first position (GS1)
+		case 9: // FNC1 in second position: application indicator
+			if _, ok := read(8); !ok {
+				return "", errQRUnreadable
+			}
+		case 8:
+			return "", fmt.Errorf("kanji QR codes are not supported")
+		default:
+			return "", errQRUnreadable
+		}
+	}
+	if utf8.Valid(out) {
+		return string(out), nil
+	}
+	runes := make([]rune, len(out))
+	for i, c := range out {
+		runes[i] = rune(c) // Latin-1, the QR default
+	}
+	return string(runes), nil
+}
+
+// Reed-Solomon decoding, for the codes of qr.go whose generator has the roots
+// 2^0 ... 2^(ec-1)
+
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]int) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	return exp, log
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+func gfPow(a byte, n int) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]*n%255]
+}
+
+// rsCorrect corrects in place a block of data followed by ec error
+// correction codewords, which fixes up to ec/2 wrong codewords
+func rsCorrect(block []byte, ec int) error {
+	syndromes := func() ([]byte, bool) {
+		s, clean := make([]byte, ec), true
+		for j := range s {
+			for _, c := range block {
+				s[j] = gfMul(s[j], gfExp[j]) ^ c
+			}
+			clean = clean && s[j] == 0
+		}
+		return s, clean
+	}
+	synd, clean := syndromes()
+	if clean {
+		return nil
+	}
+
+	// Error locator polynomial by Berlekamp-Massey, lowest degree first
+	locator, prev := []byte{1}, []byte{1}
+	length, shift, prevDisc := 0, 1, byte(1)
+	for k := 0; k < ec; k++ {
+		d := synd[k]
+		for i := 1; i <= length && i < len(locator); i++ {
+			d ^= gfMul(locator[i], synd[k-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		old := append([]byte(nil), locator...)
+		if n := len(prev) + shift; len(locator) < n {
+			locator = append(locator, make([]byte, n-len(locator))...)
+		}
+		coef := gfDiv(d, prevDisc)
+		for i, c := range prev {
+			locator[i+shift] ^= gfMul(coef, c)
+		}
+		if 2*length <= k {
+			length, prev, prevDisc, shift = k+1-length, old, d, 1
+		} else {
+			shift++
+		}
+	}
+	if 2*length > ec {
+		return fmt.Errorf("QR code has too many errors")
+	}
+
+	// Error positions (as powers, from the end of the block) by Chien search
+	var positions []int
+	for p := 0; p < len(block); p++ {
+		inv := gfExp[(255-p%255)%255]
+		var v byte
+		for i := len(locator) - 1; i >= 0; i-- {
+			v = gfMul(v, inv) ^ locator[i]
+		}
+		if v == 0 {
+			positions = append(positions, p)
+		}
+	}
+	if len(positions) != length {
+		return fmt.Errorf("QR code has too many errors")
+	}
+
+	// Error values by Forney's formula
+	omega := make([]byte, ec)
+	for i := range omega {
+		for j := 0; j <= i && j < len(locator); j++ {
+			omega[i] ^= gfMul(locator[j], synd[i-j])
+		}
+	}
+	for _, p := range positions {
+		inv := gfExp[(255-p%255)%255]
+		var num, den byte
+		for i := ec - 1; i >= 0; i-- {
+			num = gfMul(num, inv) ^ omega[i]
+		}
+		for i := 1; i < len(locator); i += 2 {
+			den ^= gfMul(locator[i], gfPow(inv, i-1))
+		}
+		if den == 0 {
+			return fmt.Errorf("QR code has too many errors")
+		}
+		block[len(block)-1-p] ^= gfMul(gfExp[p%255], gfDiv(num, den))
+	}
+	if _, clean := syndromes(); !clean {
+		return fmt.Errorf("QR code has too many errors")
+	}
+	return nil
+}
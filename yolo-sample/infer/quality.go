@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// QUALITY_GATE checks each image before inference: its resolution against
+// QUALITY_MIN_WIDTH x QUALITY_MIN_HEIGHT, its exposure (mean luma, 0-255)
+// against QUALITY_MIN_BRIGHTNESS and QUALITY_MAX_BRIGHTNESS, and its
+// sharpness (the variance of the Laplacian drift detection also tracks; see
+// drift.go) against QUALITY_MIN_SHARPNESS. Sharpness is not judged on a badly
+// exposed image, which is never sharp. With "reject" a failing image is not
+// inferred and its result carries the first problem as its error, saying what
+// to fix; with "flag" it is inferred as usual and the problem is added to the
+// result's "quality_warning" attribute. Either way the problem codes are in
+// the "quality" attribute and counted in yolo_image_quality_failures_total.
+//
+// A camera source whose last qualityStreak images all failed, or all passed
+// after failing, publishes image.quality: a lens that got dirty or a camera
+// knocked out of focus raises one event rather than one per frame.
+
+// qualityStreak is the number of consecutive images that change a camera
+// source's quality state
+const qualityStreak = 3
+
+// QualityEvent is the payload of image.quality
+type QualityEvent struct {
+	Source   string   `json:"source"`
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	ResultID string   `json:"result_id"`
+}
+
+// qualityProblem is a failed check: a code for machines and a message
+// saying what to fix
+type qualityProblem struct {
+	code    string
+	message string
+}
+
+var qualityFailures = newCounterVec("yolo_image_quality_failures_total",
+	"Images failing the quality gate, by source and check.", "source", "check")
+
+// qualityState tracks the run of passing or failing images of each camera
+// source
+var qualityState = struct {
+	sync.Mutex
+	poor   map[string]bool
+	streak map[string]int // images in a row disagreeing with poor
+}{poor: map[string]bool{}, streak: map[string]int{}}
+
+// checkQuality returns the problems of an image with stats, most
+// actionable first
+func checkQuality(stats ImageStats) []qualityProblem {
+	cfg := config()
+	var problems []qualityProblem
+	if stats.Width < cfg.QualityMinWidth || stats.Height < cfg.QualityMinHeight {
+		problems = append(problems, qualityProblem{"resolution", fmt.Sprintf("image is %dx%d, below the minimum of %dx%d: raise the camera or upload resolution",
+			stats.Width, stats.Height, cfg.QualityMinWidth, cfg.QualityMinHeight)})
+	}
+	exposed := true
+	if stats.Brightness < cfg.QualityMinBrightness {
+		problems = append(problems, qualityProblem{"underexposed", fmt.Sprintf("image is too dark (brightness %.0f, minimum %.0f): check the lighting or the camera's exposure",
+			stats.Brightness, cfg.QualityMinBrightness)})
+		exposed = false
+	} else if stats.Brightness > cfg.QualityMaxBrightness {
+		problems = append(problems, qualityProblem{"overexposed", fmt.Sprintf("image is too bright (brightness %.0f, maximum %.0f): reduce the exposure or shade the camera",
+			stats.Brightness, cfg.QualityMaxBrightness)})
+		exposed = false
+	}
+	if exposed && stats.Blur < cfg.QualityMinSharpness {
+		problems = append(problems, qualityProblem{"blurry", fmt.Sprintf("image is blurry (sharpness %.1f, minimum %.1f): clean the lens or check the focus",
+			stats.Blur, cfg.QualityMinSharpness)})
+	}
+	return problems
+}
+
+// applyQuality records the problems of an image in its result, failing the
+// result when the gate rejects images
+func applyQuality(result *InferenceResult, source string, problems []qualityProblem) {
+	if len(problems) == 0 {
+		return
+	}
+	codes := make([]string, len(problems))
+	for i, p := range problems {
+		codes[i] = p.code
+		qualityFailures.inc(source, p.code)
+	}
+	if result.Attributes == nil {
+		result.Attributes = map[string]interface{}{}
+	}
+	result.Attributes["quality"] = codes
+	if config().QualityGate == "reject" {
+		result.Error = "Image rejected: " + problems[0].message
+		return
+	}
+	result.Attributes["quality_warning"] = problems[0].message
+}
+
+// trackQuality updates the quality state of a camera source with the
+// result of its latest image
+func trackQuality(source, resultID string, problems []qualityProblem) {
+	poor := len(problems) > 0
+	qualityState.Lock()
+	if poor == qualityState.poor[source] {
+		qualityState.streak[source] = 0
+		qualityState.Unlock()
+		return
+	}
+	qualityState.streak[source]++
+	if qualityState.streak[source] < qualityStreak {
+		qualityState.Unlock()
+		return
+	}
+	qualityState.poor[source] = poor
+	qualityState.streak[source] = 0
+	qualityState.Unlock()
+
+	ev := QualityEvent{Source: source, OK: !poor, ResultID: resultID}
+	if poor {
+		ev.Message = problems[0].message
+		for _, p := range problems {
+			ev.Problems = append(ev.Problems, p.code)
+		}
+		log.Printf("Warning: images of source %s fail the quality gate: %s", source, ev.Message)
+	} else {
+		log.Printf("Images of source %s pass the quality gate again", source)
+	}
+	bus.publish(eventImageQuality, ev)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A camera source can have quiet hours. Its schedule holds cron expressions
+// (see cron.go) whose matching minutes, in node local time, are when the
+// source captures and when its results raise alerts; an empty one means
+// always:
+//
+//	"schedule": {"capture": "* 6-21 * * *", "alerts": "* * * * mon-fri"}
+//
+// runs the loading-dock camera from 06:00 to 22:00 and suppresses its alerts
+// at weekends. The scheduler loop applies capture schedules every tick,
+// stopping and starting the source workers; a stopped source shows state
+// "quiet". Overrides switch either on or off regardless of the schedule, for
+// a while or until cleared:
+//
+//	PUT    /api/v1/sources/{name}/override  {"capture": true, "alerts": false, "for": "2h"}
+//	DELETE /api/v1/sources/{name}/override
+//
+// Overrides are kept in STATE_DIR/source-overrides.json; maintenance mode
+// (see maintenance.go) still pauses a source whose capture is overridden on.
+
+// SourceSchedule is when a source captures and alerts
+type SourceSchedule struct {
+	Capture string `json:"capture,omitempty"`
+	Alerts  string `json:"alerts,omitempty"`
+}
+
+// SourceOverride forces a source's capture or alerts on or off
+type SourceOverride struct {
+	Capture *bool      `json:"capture,omitempty"`
+	Alerts  *bool      `json:"alerts,omitempty"`
+	Until   *time.Time `json:"until,omitempty"` // nil: until cleared
+}
+
+var alertsSuppressed = newCounterVec("yolo_alerts_suppressed_total",
+	"Alerts not fired because their result's source was in quiet hours.", "rule")
+
+func (sch *SourceSchedule) validate() error {
+	if sch == nil {
+		return nil
+	}
+	for field, expr := range map[string]string{"capture": sch.Capture, "alerts": sch.Alerts} {
+		if expr == "" {
+			continue
+		}
+		if _, err := parseCron(expr); err != nil {
+			return fmt.Errorf("schedule %s: %v", field, err)
+		}
+	}
+	return nil
+}
+
+// scheduleActive reports whether t falls in the minutes of expr; an empty or
+// unparsable expression is always active
+func scheduleActive(expr string, t time.Time) bool {
+	if expr == "" {
+		return true
+	}
+	sched, err := parseCron(expr)
+	return err != nil || sched.matches(t)
+}
+
+func overridesPath() string {
+	return filepath.Join(config().StateDir, "source-overrides.json")
+}
+
+// loadOverridesLocked reads the persisted overrides; the caller holds s.mu
+func (s *sourceRegistry) loadOverridesLocked() {
+	data, err := os.ReadFile(overridesPath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &s.overrides); err != nil {
+		log.Printf("Warning: ignoring unreadable %s: %v", overridesPath(), err)
+		s.overrides = map[string]SourceOverride{}
+	}
+}
+
+func (s *sourceRegistry) saveOverridesLocked() error {
+	if len(s.overrides) == 0 {
+		if err := os.Remove(overridesPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(overridesPath(), data)
+}
+
+// overrideLocked returns the unexpired override of name; the caller holds s.mu
+func (s *sourceRegistry) overrideLocked(name string, now time.Time) (SourceOverride, bool) {
+	o, ok := s.overrides[name]
+	if ok && o.Until != nil && !now.Before(*o.Until) {
+		return SourceOverride{}, false
+	}
+	return o, ok
+}
+
+// captureActiveLocked reports whether name should be capturing at now,
+// maintenance aside; the caller holds s.mu
+func (s *sourceRegistry) captureActiveLocked(name string, now time.Time) bool {
+	if lowPowerPaused(name) {
+		return false
+	}
+	if o, ok := s.overrideLocked(name, now); ok && o.Capture != nil {
+		return *o.Capture
+	}
+	src := s.sources[name]
+	return src.Schedule == nil || scheduleActive(src.Schedule.Capture, now)
+}
+
+// alertsActive reports whether results from source may raise alerts at now.
+// Results that are not from a camera source always may.
+func (s *sourceRegistry) alertsActive(source string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[source]
+	if !ok {
+		return true
+	}
+	if o, ok := s.overrideLocked(source, now); ok && o.Alerts != nil {
+		return *o.Alerts
+	}
+	return src.Schedule == nil || scheduleActive(src.Schedule.Alerts, now)
+}
+
+// applySchedules starts and stops source workers as their capture schedules
+// and overrides open and close, and drops expired overrides. The scheduler
+// calls it every tick.
+func (s *sourceRegistry) applySchedules(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := false
+	for name, o := range s.overrides {
+		if o.Until != nil && !now.Before(*o.Until) {
+			delete(s.overrides, name)
+			expired = true
+			log.Printf("Override of source %s expired", name)
+		}
+	}
+	if expired {
+		if err := s.saveOverridesLocked(); err != nil {
+			log.Printf("Warning: failed to save source overrides: %v", err)
+		}
+	}
+	if maintenanceOn() {
+		return
+	}
+	for name, src := range s.sources {
+		if !src.Enabled {
+			continue
+		}
+		_, running := s.workers[name]
+		if want := s.captureActiveLocked(name, now); want != running {
+			if want {
+				log.Printf("Source %s leaving quiet hours", name)
+			} else {
+				log.Printf("Source %s entering quiet hours", name)
+			}
+			s.restartLocked(name)
+		}
+	}
+}
+
+// setOverride sets (o non-nil) or clears the override of name
+func (s *sourceRegistry) setOverride(name string, o *SourceOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sources[name]; !ok {
+		return os.ErrNotExist
+	}
+	prev, had := s.overrides[name]
+	if o != nil {
+		s.overrides[name] = *o
+	} else {
+		delete(s.overrides, name)
+	}
+	if err := s.saveOverridesLocked(); err != nil {
+		if had {
+			s.overrides[name] = prev
+		} else {
+			delete(s.overrides, name)
+		}
+		return err
+	}
+	s.restartLocked(name)
+	return nil
+}
+
+// sourceOverrideHandler serves PUT and DELETE /api/v1/sources/{name}/override
+func sourceOverrideHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var o *SourceOverride
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Capture *bool      `json:"capture"`
+			Alerts  *bool      `json:"alerts"`
+			Until   *time.Time `json:"until"`
+			For     string     `json:"for"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if req.Capture == nil && req.Alerts == nil {
+			writeJSONError(w, http.StatusBadRequest, "Set capture or alerts")
+			return
+		}
+		o = &SourceOverride{Capture: req.Capture, Alerts: req.Alerts, Until: req.Until}
+		if req.For != "" {
+			d, err := time.ParseDuration(req.For)
+			if err != nil || d <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "for must be a positive duration such as 2h")
+				return
+			}
+			until := time.Now().Add(d).UTC()
+			o.Until = &until
+		}
+		if o.Until != nil && !o.Until.After(time.Now()) {
+			writeJSONError(w, http.StatusBadRequest, "until is in the past")
+			return
+		}
+	case http.MethodDelete:
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err := sources.setOverride(name, o); err != nil {
+		if os.IsNotExist(err) {
+			writeJSONError(w, http.StatusNotFound, "Source not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	v, _ := sources.view(name)
+	writeJSON(w, http.StatusOK, v)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RATE_LIMIT caps the inference work a client may ask for: each client
+// address gets that many uploads, ingests and evaluations per minute, and
+// requests beyond it are refused with 429 and a Retry-After header until
+// the minute is over. The counters live in the key-value store (see
+// kvstore.go), so with Redis the limit holds across replicas and restarts.
+// Reading results, pages and events is never limited.
+
+var rateLimited = newCounterVec("yolo_rate_limited_total",
+	"Requests refused for exceeding RATE_LIMIT.")
+
+// rateLimitKey is the counter of the client for the current minute
+func rateLimitKey(r *http.Request, now time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return fmt.Sprintf("yolo:rate:%s:%d", host, now.Unix()/60)
+}
+
+// withRateLimit refuses the inference work of clients over RATE_LIMIT
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := config().RateLimit
+		if limit <= 0 || !maintenanceRefuses(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		n, err := kv.incr(rateLimitKey(r, now), time.Minute)
+		if err != nil || n <= int64(limit) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rateLimited.inc()
+		w.Header().Set("Retry-After", strconv.FormatInt(60-now.Unix()%60, 10))
+		msg := fmt.Sprintf("Rate limit of %d requests a minute exceeded", limit)
+		if wantsJSON(r) {
+			writeJSONError(w, http.StatusTooManyRequests, msg)
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderError(w, r, msg)
+	})
+}
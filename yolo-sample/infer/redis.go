@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Redis client speaking what the node needs of RESP2: commands and their
+// replies over a small pool of connections, plus dedicated connections for
+// blocking commands and subscriptions. URLs take the usual form,
+// redis://[user:password@]host:6379/<db>, or rediss:// for TLS.
+
+// redisTimeout bounds dialing and each command that does not block
+const redisTimeout = 5 * time.Second
+
+// redisMaxIdle is the number of idle connections kept in the pool
+const redisMaxIdle = 4
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// errRedisNil is returned by the typed helpers for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+type redisClient struct {
+	host     string
+	user     string
+	password string
+	db       int
+	tls      bool
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient parses a redis:// or rediss:// URL; it does not connect
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, errors.New("want a redis:// or rediss:// URL")
+	}
+	c := &redisClient{host: hostWithPort(u, "6379"), tls: u.Scheme == "rediss"}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			// redis://secret@host is a password, as redis-cli reads it
+			c.user, c.password = "", c.user
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// redactRedisURL is u.Redacted, also masking the password of the
+// redis://secret@host form, which url.Redacted takes for a username
+func redactRedisURL(u *url.URL) string {
+	if (u.Scheme == "redis" || u.Scheme == "rediss") && u.User != nil {
+		if pw, _ := u.User.Password(); pw == "" && u.User.Username() != "" {
+			u.User = url.User("xxxxx")
+		}
+	}
+	return u.Redacted()
+}
+
+// dial opens a connection, authenticated and on the client's database
+func (c *redisClient) dial() (*redisConn, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.host)
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.host, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", c.host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []interface{}{"AUTH", c.password}
+		if c.user != "" {
+			args = []interface{}{"AUTH", c.user, c.password}
+		}
+		if _, err := rc.do(redisTimeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do(redisTimeout, "SELECT", c.db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do runs a command on a pooled connection
+func (c *redisClient) do(args ...interface{}) (interface{}, error) {
+	return c.doTimeout(redisTimeout, args...)
+}
+
+// doTimeout runs a command that may block for up to timeout
+func (c *redisClient) doTimeout(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	var rc *redisConn
+	if n := len(c.idle); n > 0 {
+		rc, c.idle = c.idle[n-1], c.idle[:n-1]
+	}
+	c.mu.Unlock()
+	if rc == nil {
+		var err error
+		if rc, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rc.do(timeout, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may be out of step with the server
+		rc.conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	if len(c.idle) < redisMaxIdle {
+		c.idle = append(c.idle, rc)
+		rc = nil
+	}
+	c.mu.Unlock()
+	if rc != nil {
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// do sends a command and reads its reply within timeout
+func (rc *redisConn) do(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(timeout))
+	if err := rc.send(args...); err != nil {
+		return nil, err
+	}
+	return rc.receive()
+}
+
+func (rc *redisConn) send(args ...interface{}) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		var s string
+		switch v := a.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			s = fmt.Sprint(v)
+		}
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(s), s)
+	}
+	_, err := io.WriteString(rc.conn, b.String())
+	return err
+}
+
+// receive reads one reply: a string, int64, []interface{}, nil or redisError
+func (rc *redisConn) receive() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			if out[i], err = rc.receive(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				out[i] = err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (rc *redisConn) close() {
+	rc.conn.Close()
+}
+
+// redisString returns a string reply, or errRedisNil
+func redisString(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", errRedisNil
+	}
+	return s, nil
+}
+
+// redisInt returns an integer reply
+func redisInt(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, errRedisNil
+}
+
+// redisStrings returns an array reply as strings, "" for nil elements
+func redisStrings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	list, _ := reply.([]interface{})
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[i], _ = v.(string)
+	}
+	return out, nil
+}
+
+// redisMap returns a HGETALL reply as a map
+func redisMap(reply interface{}, err error) (map[string]string, error) {
+	list, err := redisStrings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		out[list[i]] = list[i+1]
+	}
+	return out, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A camera source can keep a reference image of its empty scene: a shelf
+// fully stocked, a yard with nothing in it. With change_detection set, the
+// source reports only detections the reference does not have, so the parked
+// car or the fixture the model mistakes for a person stops showing up in
+// every frame. Each detection is paired with one of the same class in the
+// reference overlapping by at least reference_iou (default 0.5); the
+// unpaired ones are new. The reference is inferred with the same model and
+// settings as the frame, at half the threshold so that objects the model is
+// unsure of in the empty scene do not come and go, and again whenever the
+// model or settings change. Suppressed detections are counted in the
+// result's "reference_suppressed" attribute.
+//
+//	GET    /api/v1/sources/{name}/reference  the reference image
+//	PUT    /api/v1/sources/{name}/reference  set it from the image in the body
+//	POST   /api/v1/sources/{name}/reference  set it from the camera's current frame
+//	DELETE /api/v1/sources/{name}/reference
+//
+// References are stored as JPEG in STATE_DIR/references, redacted in
+// privacy mode and encrypted along with stored images (see encryption.go).
+
+// referenceCache holds the reference detections of a source for one model
+// and settings
+type referenceCache struct {
+	key        string
+	detections []Detection
+}
+
+var references = struct {
+	sync.Mutex
+	cache map[string]referenceCache // by source
+}{cache: map[string]referenceCache{}}
+
+func referencePath(name string) string {
+	return filepath.Join(config().StateDir, "references", name+".jpg")
+}
+
+// referenceTime returns when the reference of a source was set, if it has one
+func referenceTime(name string) *time.Time {
+	info, err := os.Stat(referencePath(name))
+	if err != nil {
+		return nil
+	}
+	t := info.ModTime().UTC()
+	return &t
+}
+
+// setReference stores frame as the reference of src
+func setReference(src CameraSource, frame []byte) error {
+	img, err := decodeImage(bytes.NewReader(frame))
+	if err != nil {
+		return fmt.Errorf("not a decodable image: %v", err)
+	}
+	if config().PrivacyMode {
+		_, detections, err := detectFrame(frame, src)
+		if err != nil {
+			return fmt.Errorf("cannot redact the reference: %v", err)
+		}
+		img = redactImage(img, detections)
+	}
+	path := referencePath(src.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeStoredJPEG(path, img); err != nil {
+		return err
+	}
+	references.Lock()
+	delete(references.cache, src.Name)
+	references.Unlock()
+	log.Printf("Reference image of source %s set", src.Name)
+	return nil
+}
+
+func removeReference(name string) error {
+	references.Lock()
+	delete(references.cache, name)
+	references.Unlock()
+	return os.Remove(referencePath(name))
+}
+
+// referenceDetections returns the detections of the reference of src for
+// version and opts; false when there is no usable reference
+func referenceDetections(src CameraSource, version string, opts InferenceOptions) ([]Detection, bool) {
+	path := referencePath(src.Name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	params := opts.params()
+	params.Threshold /= 2
+	key := fmt.Sprintf("%s %v %d", version, params, info.ModTime().UnixNano())
+
+	references.Lock()
+	c, ok := references.cache[src.Name]
+	references.Unlock()
+	if ok && c.key == key {
+		return c.detections, true
+	}
+	plain, cleanup, err := plainCopy(path)
+	if err != nil {
+		log.Printf("Warning: cannot read the reference of source %s: %v", src.Name, err)
+		return nil, false
+	}
+	result := runInferenceParams(plain, version, params)
+	cleanup()
+	if result.Error != "" {
+		log.Printf("Warning: cannot infer the reference of source %s: %s", src.Name, result.Error)
+		return nil, false
+	}
+	refOpts := opts
+	refOpts.Threshold = &params.Threshold
+	applyOptions(&result, refOpts)
+	references.Lock()
+	references.cache[src.Name] = referenceCache{key: key, detections: result.Detections}
+	references.Unlock()
+	return result.Detections, true
+}
+
+// filterReference drops the detections src's reference also has
+func filterReference(result *InferenceResult, src CameraSource, version string, opts InferenceOptions) {
+	if !src.ChangeDetection || len(result.Detections) == 0 {
+		return
+	}
+	ref, ok := referenceDetections(src, version, opts)
+	if !ok {
+		return
+	}
+	minIoU := src.ReferenceIoU
+	if minIoU == 0 {
+		minIoU = 0.5
+	}
+	known := map[int]bool{}
+	for _, p := range hungarianMatch(ref, result.Detections, minIoU) {
+		known[p.b] = true
+	}
+	kept := result.Detections[:0:0]
+	for i, d := range result.Detections {
+		if !known[i] {
+			kept = append(kept, d)
+		}
+	}
+	if result.Attributes == nil {
+		result.Attributes = map[string]interface{}{}
+	}
+	result.Attributes["reference_suppressed"] = len(result.Detections) - len(kept)
+	result.Detections = kept
+	result.Count = len(kept)
+}
+
+// sourceReferenceHandler serves /api/v1/sources/{name}/reference
+func sourceReferenceHandler(w http.ResponseWriter, r *http.Request, name string) {
+	src, ok := sources.get(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Source not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if referenceTime(name) == nil {
+			writeJSONError(w, http.StatusNotFound, "No reference image")
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		serveStoredFile(w, r, referencePath(name))
+
+	case http.MethodPut, http.MethodPost:
+		var frame []byte
+		if r.Method == http.MethodPut {
+			data, err := io.ReadAll(io.LimitReader(r.Body, config().IngestMaxBytes+1))
+			if err != nil || int64(len(data)) > config().IngestMaxBytes {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("image is larger than %d MB", config().IngestMaxBytes>>20))
+				return
+			}
+			frame = data
+		} else {
+			if wk := sources.worker(name); wk != nil {
+				wk.mu.Lock()
+				if time.Since(wk.latestAt) <= src.staleAfter() {
+					frame = wk.latest
+				}
+				wk.mu.Unlock()
+			}
+			if frame == nil {
+				ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+				defer cancel()
+				var err error
+				if frame, err = grabFrame(ctx, src); err != nil {
+					writeJSONError(w, http.StatusBadGateway, "Snapshot failed: "+err.Error())
+					return
+				}
+			}
+		}
+		if err := setReference(src, frame); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v, _ := sources.view(name)
+		writeJSON(w, http.StatusOK, v)
+
+	case http.MethodDelete:
+		if err := removeReference(name); err != nil {
+			writeJSONError(w, http.StatusNotFound, "No reference image")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
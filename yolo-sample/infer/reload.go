@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CONFIG_FILE names settings that take precedence over the environment, either
+// a file of KEY=VALUE lines ("#" starts a comment) or a directory holding one
+// file per key, as a Kubernetes ConfigMap is mounted:
+//
+//	INFERENCE_THRESHOLD=0.4
+//	RETENTION_DAYS=7
+//	ALERT_RULES=/etc/yolo/rules.json
+//
+// Every CONFIG_RELOAD_INTERVAL (default 10s, 0 disables) the node checks
+// CONFIG_FILE and the files it points at (CLASS_MAP, TAXONOMY, ALERT_RULES and
+// SOURCES_FILE) for changes. A changed configuration is read and validated in
+// full before it replaces the running one, so a bad edit leaves the previous
+// configuration in effect; /api/v1/config shows the outcome.
+//
+// Settings from a fleet configuration bundle (see fleet.go) override both, and
+// those of the desired state (see desired.go) override all of them.
+//
+// Most settings are read where they are used and apply immediately. Those in
+// restartSettings are only read at startup: a reload keeps their running values
+// and lists them as pending a restart. Sandbox settings of the inference process
+// apply when it next starts.
+
+// restartSettings are the settings a reload cannot change
+var restartSettings = []struct{ field, key string }{
+	{"ModelDir", "MODEL_DIR"},
+	{"StateDir", "STATE_DIR"},
+	{"InferenceBackend", "INFERENCE_BACKEND"},
+	{"InferenceCommand", "INFERENCE_COMMAND"},
+	{"UploadWorkers", "UPLOAD_WORKERS"},
+	{"UploadQueueSize", "UPLOAD_QUEUE_SIZE"},
+	{"QueueURL", "QUEUE_URL"},
+	{"ReplicaName", "REPLICA_NAME"},
+	{"ReplicaURL", "REPLICA_URL"},
+	{"CacheURL", "CACHE_URL"},
+	{"BaseURL", "BASE_URL"},
+	{"WasmPluginDir", "WASM_PLUGIN_DIR"},
+	{"DriftReferenceDir", "DRIFT_REFERENCE_DIR"},
+	{"DriftReferenceSize", "DRIFT_REFERENCE_SIZE"},
+	{"LocaleDir", "LOCALE_DIR"},
+	{"ListenAddr", "LISTEN_ADDR"},
+	{"UnixSocket", "UNIX_SOCKET"},
+	{"UnixSocketMode", "UNIX_SOCKET_MODE"},
+	{"UnixSocketGroup", "UNIX_SOCKET_GROUP"},
+	{"TLSCertFile", "TLS_CERT_FILE"},
+	{"TLSKeyFile", "TLS_KEY_FILE"},
+	{"H2C", "H2C"},
+	{"HTTP2MaxStreams", "HTTP2_MAX_STREAMS"},
+	{"HTTPReadHeaderTimeout", "HTTP_READ_HEADER_TIMEOUT"},
+	{"HTTPIdleTimeout", "HTTP_IDLE_TIMEOUT"},
+	{"HTTP2PingInterval", "HTTP2_PING_INTERVAL"},
+	{"HTTP2PingTimeout", "HTTP2_PING_TIMEOUT"},
+	{"HTTP2WriteTimeout", "HTTP2_WRITE_TIMEOUT"},
+	{"MDNS", "MDNS"},
+	{"MDNSName", "MDNS_NAME"},
+	{"MDNSInterface", "MDNS_INTERFACE"},
+	{"AdminAddr", "ADMIN_ADDR"},
+	{"LogBufferLines", "LOG_BUFFER_LINES"},
+	{"LogForwardLevel", "LOG_FORWARD_LEVEL"},
+	{"LogSyslog", "LOG_SYSLOG"},
+	{"LogSyslogCA", "LOG_SYSLOG_CA"},
+	{"LogSyslogTag", "LOG_SYSLOG_TAG"},
+	{"LogShipURL", "LOG_SHIP_URL"},
+	{"LogShipInterval", "LOG_SHIP_INTERVAL"},
+	{"LogShipSpoolMax", "LOG_SHIP_SPOOL_MAX"},
+	{"EncryptionKeyFile", "ENCRYPTION_KEY_FILE"},
+	{"EncryptionKeyCommand", "ENCRYPTION_KEY_COMMAND"},
+	{"SecretsRefreshInterval", "SECRETS_REFRESH_INTERVAL"},
+	{"VaultAddr", "VAULT_ADDR"},
+	{"VaultNamespace", "VAULT_NAMESPACE"},
+	{"VaultTokenFile", "VAULT_TOKEN_FILE"},
+	{"VaultRole", "VAULT_ROLE"},
+	{"VaultAuthPath", "VAULT_AUTH_PATH"},
+}
+
+// ReloadStatus is the outcome of the latest configuration reload
+type ReloadStatus struct {
+	ConfigFile     string     `json:"config_file,omitempty"`
+	Status         string     `json:"status"`            // "startup", "ok" or "error"
+	Trigger        string     `json:"trigger,omitempty"` // "watch", "api", "fleet", "secrets" or "desired"
+	LastAttempt    *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	Changed        []string   `json:"changed,omitempty"`
+	PendingRestart []string   `json:"pending_restart,omitempty"`
+}
+
+type configReloader struct {
+	mu          sync.Mutex
+	settings    map[string]string // CONFIG_FILE settings in effect
+	fileHashes  map[string]string // referenced file key -> content hash
+	fingerprint string
+	status      ReloadStatus
+}
+
+var reloader = &configReloader{status: ReloadStatus{Status: "startup"}}
+
+var configReloads = newCounterVec("yolo_config_reloads_total",
+	"Configuration reloads by outcome.", "status")
+
+var settingKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// readConfigFile reads the settings of a CONFIG_FILE file or directory
+func readConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			// ConfigMap mounts also hold ..data and timestamped directories
+			if !settingKeyPattern.MatchString(e.Name()) {
+				continue
+			}
+			p := filepath.Join(path, e.Name())
+			if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			out[e.Name()] = strings.TrimRight(string(data), "\r\n")
+		}
+		return out, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !settingKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		out[key] = value
+	}
+	return out, scanner.Err()
+}
+
+// readConfigFileOrWarn reads CONFIG_FILE at startup; a broken file is logged
+// and ignored
+func readConfigFileOrWarn() map[string]string {
+	path := os.Getenv("CONFIG_FILE")
+	settings, err := readConfigFile(path)
+	if err != nil {
+		log.Printf("Warning: ignoring config file %s: %v", path, err)
+		return nil
+	}
+	return settings
+}
+
+// validateConfig checks settings that would otherwise only fail when used
+func validateConfig(cfg *Config) error {
+	if cfg.InferenceThreshold < 0 || cfg.InferenceThreshold > 1 {
+		return fmt.Errorf("INFERENCE_THRESHOLD must be between 0 and 1")
+	}
+	if cfg.InferenceBackend != backendProcess && cfg.InferenceBackend != backendMock {
+		return fmt.Errorf("INFERENCE_BACKEND must be process or mock")
+	}
+	if _, err := renderCommand(cfg.InferenceCommand, inferenceCommandData{ImagePath: "x", Model: "x"}); err != nil {
+		return fmt.Errorf("INFERENCE_COMMAND: %v", err)
+	}
+	if cfg.InferenceMaxImgSz < 32 {
+		return fmt.Errorf("INFERENCE_MAX_IMGSZ must be at least 32")
+	}
+	if cfg.InferenceMaxDetections < 1 {
+		return fmt.Errorf("INFERENCE_MAX_DETECTIONS must be at least 1")
+	}
+	if cfg.MockLatency < 0 {
+		return fmt.Errorf("MOCK_LATENCY must not be negative")
+	}
+	if cfg.TrainingPromotion != "canary" && cfg.TrainingPromotion != "direct" && cfg.TrainingPromotion != "off" {
+		return fmt.Errorf("TRAINING_PROMOTION must be canary, direct or off")
+	}
+	if cfg.TrainingCanaryPercent <= 0 || cfg.TrainingCanaryPercent > 100 {
+		return fmt.Errorf("TRAINING_CANARY_PERCENT must be in (0, 100]")
+	}
+	if cfg.SignatureTolerance <= 0 {
+		return fmt.Errorf("SIGNATURE_TOLERANCE must be positive")
+	}
+	if cfg.GoldenIoUTolerance <= 0 || cfg.GoldenIoUTolerance > 1 {
+		return fmt.Errorf("GOLDEN_IOU_TOLERANCE must be in (0, 1]")
+	}
+	if cfg.GoldenConfidenceTolerance < 0 || cfg.GoldenConfidenceTolerance > 1 {
+		return fmt.Errorf("GOLDEN_CONFIDENCE_TOLERANCE must be between 0 and 1")
+	}
+	if cfg.TrafficRecordingDays < 1 {
+		return fmt.Errorf("TRAFFIC_RECORDING_DAYS must be at least 1")
+	}
+	if cfg.BarcodeDecode != "off" && cfg.BarcodeDecode != "image" && cfg.BarcodeDecode != "boxes" {
+		return fmt.Errorf("BARCODE_DECODE must be off, image or boxes")
+	}
+	if cfg.QualityGate != "off" && cfg.QualityGate != "flag" && cfg.QualityGate != "reject" {
+		return fmt.Errorf("QUALITY_GATE must be off, flag or reject")
+	}
+	if cfg.QualityMinBrightness >= cfg.QualityMaxBrightness {
+		return fmt.Errorf("QUALITY_MIN_BRIGHTNESS must be below QUALITY_MAX_BRIGHTNESS")
+	}
+	if cfg.MaxImagePixels < 1 {
+		return fmt.Errorf("MAX_IMAGE_PIXELS must be at least 1")
+	}
+	modes := map[string]bool{retentionFull: true, retentionThumbnail: true, retentionNone: true}
+	if !modes[cfg.ImageRetention] {
+		return fmt.Errorf("IMAGE_RETENTION: unknown mode %q", cfg.ImageRetention)
+	}
+	for _, pair := range strings.Split(cfg.ImageRetentionSources, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		if _, mode, ok := strings.Cut(pair, "="); !ok || !modes[strings.TrimSpace(mode)] {
+			return fmt.Errorf("IMAGE_RETENTION_SOURCES: invalid entry %q", pair)
+		}
+	}
+	if cfg.RetentionDays < 1 {
+		return fmt.Errorf("RETENTION_DAYS must be at least 1")
+	}
+	if cfg.HeatmapRetentionDays < 1 {
+		return fmt.Errorf("HEATMAP_RETENTION_DAYS must be at least 1")
+	}
+	if cfg.InferBatchMaxImages < 1 {
+		return fmt.Errorf("INFER_BATCH_MAX_IMAGES must be at least 1")
+	}
+	if cfg.StatsArchiveDays < 1 {
+		return fmt.Errorf("STATS_ARCHIVE_DAYS must be at least 1")
+	}
+	if cfg.PrivacyMethod != "blur" && cfg.PrivacyMethod != "mask" {
+		return fmt.Errorf("PRIVACY_METHOD must be blur or mask")
+	}
+	switch cfg.ThemeDefault {
+	case "light", "dark", "auto":
+	default:
+		return fmt.Errorf("THEME_DEFAULT must be light, dark or auto")
+	}
+	if err := validateFeatureFlags(cfg.FeatureFlags); err != nil {
+		return err
+	}
+	if err := validateSigning(cfg); err != nil {
+		return err
+	}
+	if err := validateAdmin(cfg); err != nil {
+		return err
+	}
+	if err := validateListeners(cfg); err != nil {
+		return err
+	}
+	if err := validateUpdate(cfg); err != nil {
+		return err
+	}
+	if err := validateNetworkStatus(cfg); err != nil {
+		return err
+	}
+	if err := validateLogForwarding(cfg); err != nil {
+		return err
+	}
+	if err := validateTimeseries(cfg); err != nil {
+		return err
+	}
+	if err := validateReports(cfg); err != nil {
+		return err
+	}
+	if err := validateHeartbeat(cfg); err != nil {
+		return err
+	}
+	if err := validatePower(cfg); err != nil {
+		return err
+	}
+	if cfg.TrainingCommand != "" {
+		if _, err := renderCommand(cfg.TrainingCommand, trainingCommandData{Job: "x", Reason: "x"}); err != nil {
+			return fmt.Errorf("TRAINING_COMMAND: %v", err)
+		}
+	}
+	if cfg.DeliveryRetries < 0 || cfg.DeliveryRetryBackoff <= 0 || cfg.DeliveryRetryMaxBackoff < cfg.DeliveryRetryBackoff {
+		return fmt.Errorf("DELIVERY_RETRIES must be 0 or more and DELIVERY_RETRY_MAX_BACKOFF at least DELIVERY_RETRY_BACKOFF, which must be positive")
+	}
+	if cfg.DeadLetterMax < 1 {
+		return fmt.Errorf("DEAD_LETTER_MAX must be at least 1")
+	}
+	if _, err := parseModelRefs(cfg.ModelOCIRefs); err != nil {
+		return err
+	}
+	for _, peer := range splitList(cfg.ModelPeers) {
+		if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("MODEL_PEERS entry %q must be an http:// or https:// URL", peer)
+		}
+	}
+	if cfg.VaultAddr != "" {
+		if u, err := url.Parse(cfg.VaultAddr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("VAULT_ADDR must be an http:// or https:// URL")
+		}
+	}
+	if _, err := parseLabelSelector(cfg.TrainingGPULabels); err != nil {
+		return fmt.Errorf("TRAINING_GPU_LABELS: %v", err)
+	}
+	if cfg.TrainingScheduleTimeout <= 0 {
+		return fmt.Errorf("TRAINING_SCHEDULE_TIMEOUT must be positive")
+	}
+	if cfg.QueueURL != "" {
+		if _, err := newRedisClient(cfg.QueueURL); err != nil {
+			return fmt.Errorf("QUEUE_URL: %v", err)
+		}
+		if u, err := url.Parse(cfg.ReplicaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("REPLICA_URL must be the http(s) URL other replicas reach this node at when QUEUE_URL is set")
+		}
+	}
+	if cfg.CacheURL != "" {
+		if _, err := newRedisClient(cfg.CacheURL); err != nil {
+			return fmt.Errorf("CACHE_URL: %v", err)
+		}
+	}
+	if cfg.ResultCacheTTL < 0 || cfg.RateLimit < 0 {
+		return fmt.Errorf("RESULT_CACHE_TTL and RATE_LIMIT must not be negative")
+	}
+	if cfg.SessionTTL <= 0 {
+		return fmt.Errorf("SESSION_TTL must be positive")
+	}
+	if strings.ContainsAny(cfg.FrameAncestors, ";,\r\n") {
+		return fmt.Errorf("FRAME_ANCESTORS must be a space-separated list of origins, 'self' or 'none'")
+	}
+	if cfg.NetworkStatusMaxStale < 0 {
+		return fmt.Errorf("NETWORK_STATUS_MAX_STALE must not be negative")
+	}
+	if cfg.ThermalFPSFactor <= 0 || cfg.ThermalFPSFactor > 1 {
+		return fmt.Errorf("THERMAL_FPS_FACTOR must be in (0, 1]")
+	}
+	if cfg.ThermalWorkers < 1 || cfg.ThermalThrottleTemp < 0 || cfg.ThermalHysteresis < 0 {
+		return fmt.Errorf("THERMAL_WORKERS must be at least 1, THERMAL_THROTTLE_TEMP and THERMAL_HYSTERESIS not negative")
+	}
+	if cfg.StreamKeepalive <= 0 || cfg.StreamWriteTimeout < 0 {
+		return fmt.Errorf("STREAM_KEEPALIVE must be positive and STREAM_WRITE_TIMEOUT not negative")
+	}
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	if cfg.DesiredStateSource != "" {
+		if _, err := parseDesiredSource(cfg.DesiredStateSource, cfg.Namespace); err != nil {
+			return fmt.Errorf("DESIRED_STATE_SOURCE: %v", err)
+		}
+	}
+	if cfg.MQTTURL != "" {
+		u, err := url.Parse(cfg.MQTTURL)
+		if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts" && u.Scheme != "tcp" && u.Scheme != "ssl" && u.Scheme != "tls") {
+			return fmt.Errorf("MQTT_URL must be an mqtt:// or mqtts:// URL")
+		}
+	}
+	for name, expr := range builtinSchedules(cfg) {
+		if expr == "" {
+			continue
+		}
+		if _, err := parseCron(expr); err != nil {
+			return fmt.Errorf("schedule of %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// referencedFiles are the settings naming files that are watched along with CONFIG_FILE
+func referencedFiles(cfg *Config) map[string]string {
+	return map[string]string{
+		"CLASS_MAP":    cfg.ClassMapFile,
+		"TAXONOMY":     cfg.TaxonomyFile,
+		"ALERT_RULES":  cfg.AlertRulesFile,
+		"SOURCES_FILE": cfg.SourcesFile,
+	}
+}
+
+// hashPath hashes a file, or the setting files of a directory; missing files hash as empty
+func hashPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	h := sha256.New()
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		settings, _ := readConfigFile(path)
+		keys := make([]string, 0, len(settings))
+		for k := range settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s=%q\n", k, settings[k])
+		}
+	} else if data, err := os.ReadFile(path); err == nil {
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprint identifies the current contents of CONFIG_FILE and the files it names
+func fingerprint(cfg *Config) (string, map[string]string) {
+	hashes := map[string]string{}
+	for key, path := range referencedFiles(cfg) {
+		hashes[key] = hashPath(path)
+	}
+	keys := make([]string, 0, len(hashes))
+	for k := range hashes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	fmt.Fprintf(h, "CONFIG_FILE %s\n", hashPath(cfg.ConfigFile))
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s %s\n", k, hashes[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), hashes
+}
+
+// startConfigWatch polls for configuration changes and reloads them
+func startConfigWatch() {
+	cfg := config()
+	reloader.mu.Lock()
+	reloader.settings = withDesiredSettings(withFleetSettings(readConfigFileOrWarn()))
+	reloader.fingerprint, reloader.fileHashes = fingerprint(cfg)
+	reloader.status.ConfigFile = cfg.ConfigFile
+	reloader.mu.Unlock()
+	if cfg.ConfigReloadInterval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(cfg.ConfigReloadInterval) {
+			reloader.mu.Lock()
+			fp, _ := fingerprint(config())
+			changed := fp != reloader.fingerprint
+			reloader.mu.Unlock()
+			if changed {
+				reloader.reload("watch")
+			}
+		}
+	}()
+}
+
+// reload reads, validates and applies the configuration
+func (rl *configReloader) reload(trigger string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now().UTC()
+	rl.status.Trigger, rl.status.LastAttempt = trigger, &now
+
+	err := rl.applyLocked()
+	// A broken edit is reported once, not on every poll
+	rl.fingerprint, _ = fingerprint(config())
+	if err != nil {
+		rl.status.Status, rl.status.Error = "error", err.Error()
+		configReloads.inc("error")
+		bus.publish(eventConfigReloaded, rl.status)
+		log.Printf("Warning: configuration not reloaded: %v", err)
+		return err
+	}
+	rl.status.Status, rl.status.Error, rl.status.LastSuccess = "ok", "", &now
+	configReloads.inc("ok")
+	bus.publish(eventConfigReloaded, rl.status)
+	if len(rl.status.Changed) > 0 {
+		log.Printf("Configuration reloaded; changed: %s", strings.Join(rl.status.Changed, ", "))
+	}
+	if len(rl.status.PendingRestart) > 0 {
+		log.Printf("Warning: %s only take effect after a restart", strings.Join(rl.status.PendingRestart, ", "))
+	}
+	return nil
+}
+
+func (rl *configReloader) applyLocked() error {
+	old := config()
+	settings, err := readConfigFile(old.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("CONFIG_FILE: %v", err)
+	}
+	settings = withDesiredSettings(withFleetSettings(settings))
+	cfg, invalid := loadConfig(settings)
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid value for %s", strings.Join(invalid, ", "))
+	}
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	classes := map[string]ClassMapping{}
+	if cfg.ClassMapFile != "" {
+		if classes, err = readClassMap(cfg.ClassMapFile); err != nil {
+			return fmt.Errorf("CLASS_MAP: %v", err)
+		}
+	}
+	tax := &taxonomy{children: map[string][]string{}, parents: map[string][]string{}}
+	if cfg.TaxonomyFile != "" {
+		if tax, err = readTaxonomy(cfg.TaxonomyFile); err != nil {
+			return fmt.Errorf("TAXONOMY: %v", err)
+		}
+	}
+	rules, fromDesired := desiredRules(cfg)
+	if cfg.AlertRulesFile != "" && !fromDesired {
+		if rules, err = readAlertRules(cfg.AlertRulesFile); err != nil {
+			return fmt.Errorf("ALERT_RULES: %v", err)
+		}
+	}
+	var declared []CameraSource
+	if cfg.SourcesFile != "" {
+		if declared, err = readSourcesFile(cfg.SourcesFile); err != nil {
+			return fmt.Errorf("SOURCES_FILE: %v", err)
+		}
+	}
+
+	// Startup-only settings keep their running values
+	var pending []string
+	oldV, newV := reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem()
+	for _, s := range restartSettings {
+		f := newV.FieldByName(s.field)
+		if !reflect.DeepEqual(f.Interface(), oldV.FieldByName(s.field).Interface()) {
+			pending = append(pending, s.key)
+			f.Set(oldV.FieldByName(s.field))
+		}
+	}
+
+	_, hashes := fingerprint(cfg)
+	changed := changedSettings(rl.settings, settings)
+	for key, hash := range hashes {
+		if hash != rl.fileHashes[key] && !contains(changed, key) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+
+	currentConfig.Store(cfg)
+	classMap.Store(classes)
+	currentTaxonomy.Store(tax)
+	setAlertRules(rules)
+	oldSchedules := builtinSchedules(old)
+	for name, expr := range builtinSchedules(cfg) {
+		if expr != oldSchedules[name] {
+			if err := tasks.reschedule(name, expr); err != nil {
+				log.Printf("Warning: cannot reschedule %s: %v", name, err)
+			}
+		}
+	}
+	rl.settings, rl.fileHashes = settings, hashes
+	rl.status.Changed, rl.status.PendingRestart = changed, pending
+	return sources.declare(declared)
+}
+
+// changedSettings lists the keys whose value differs between two settings maps
+func changedSettings(old, current map[string]string) []string {
+	var out []string
+	for k, v := range current {
+		if ov, ok := old[k]; !ok || ov != v {
+			out = append(out, k)
+		}
+	}
+	for k := range old {
+		if _, ok := current[k]; !ok {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *configReloader) view() ReloadStatus {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.status
+}
+
+// effectiveConfig returns the configuration in effect with URL credentials
+// and secretSettings redacted
+func effectiveConfig() Config {
+	cfg := *config()
+	redactSecrets(&cfg)
+	for _, u := range []*string{&cfg.SyncURL, &cfg.FallbackInferenceURL, &cfg.FleetConfigURL, &cfg.EventWebhookURL, &cfg.MQTTURL, &cfg.CrashReportURL, &cfg.UpdateURL, &cfg.NetworkProbeURL, &cfg.LogShipURL, &cfg.LogSyslog, &cfg.HeartbeatURL, &cfg.DesiredStateSource, &cfg.QueueURL, &cfg.CacheURL} {
+		if parsed, err := url.Parse(*u); err == nil && *u != "" {
+			*u = redactRedisURL(parsed)
+		}
+	}
+	return cfg
+}
+
+// configHandler serves the configuration API
+//
+//	GET  /api/v1/config         the effective configuration, the last reload and
+//	                            the applied fleet bundle
+//	POST /api/v1/config/reload  reload the configuration now
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/v1/config" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"config": effectiveConfig(),
+			"reload": reloader.view(),
+			"fleet":  fleet.view(),
+		})
+
+	case r.URL.Path == "/api/v1/config/reload" && r.Method == http.MethodPost:
+		if err := reloader.reload("api"); err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, "Configuration not reloaded: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reloader.view())
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
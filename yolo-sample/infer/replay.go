@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With TRAFFIC_RECORDING set, every successful inference is recorded so
+// field traffic can be replayed against another model version before it is
+// promoted. A record references its image by SHA-256, kept once in the
+// object store (see objects.go) however often the frame recurs, and holds the
+// resolved parameters (threshold, classes, ...), the model that answered
+// and its detections, before zones and hooks. A recording is a
+// full-resolution, unredacted copy, so nothing is recorded in privacy mode
+// or from sources whose images are not kept in full (IMAGE_RETENTION). The
+// retention sweep drops records after TRAFFIC_RECORDING_DAYS (default 7),
+// and the storage GC the images nothing references any more.
+//
+//	GET  /api/v1/recordings      what is recorded
+//	GET  /api/v1/replays         replay reports, newest first
+//	POST /api/v1/replays         replay: {"model": "v2", "since": "24h", "source": "", "limit": 500, "iou_threshold": 0.5}
+//	GET  /api/v1/replays/{id}    a single report
+//
+// A replay runs the recorded images through the model with the recorded
+// parameters, without storing results or touching the canary, and matches
+// the new detections against the recorded ones class by class (greedily by
+// IoU, as evaluations do). The report counts identical and changed
+// requests, matched, missing and extra detections per class and lists the
+// most changed requests.
+
+// TrafficRecord is one recorded inference
+type TrafficRecord struct {
+	ResultID   string           `json:"result_id"`
+	At         time.Time        `json:"at"`
+	Source     string           `json:"source"`
+	Image      string           `json:"image"` // SHA-256 of the image, which names its file
+	Ext        string           `json:"ext"`
+	Options    InferenceOptions `json:"options"` // as resolved, threshold always set
+	Model      string           `json:"model"`
+	Detections []Detection      `json:"detections"`
+}
+
+// ReplayClass compares one class across a replay
+type ReplayClass struct {
+	Class    string `json:"class"`
+	Recorded int    `json:"recorded"`
+	Replayed int    `json:"replayed"`
+	Matched  int    `json:"matched"`
+	Missing  int    `json:"missing"` // recorded, not found by the replay
+	Extra    int    `json:"extra"`   // found only by the replay
+}
+
+// ReplayChange is a request whose detections changed
+type ReplayChange struct {
+	ResultID string    `json:"result_id"`
+	At       time.Time `json:"at"`
+	Source   string    `json:"source"`
+	Recorded int       `json:"recorded"`
+	Replayed int       `json:"replayed"`
+	Missing  int       `json:"missing"`
+	Extra    int       `json:"extra"`
+}
+
+// ReplayReport is the stored outcome of one replay
+type ReplayReport struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"` // "running", "completed", "failed"
+	Model        string         `json:"model"`
+	Since        time.Time      `json:"since"`
+	Source       string         `json:"source,omitempty"`
+	IoUThreshold float64        `json:"iou_threshold"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   *time.Time     `json:"finished_at,omitempty"`
+	Requests     int            `json:"requests"`
+	Failed       int            `json:"failed_requests"`
+	Identical    int            `json:"identical"` // every detection matched both ways
+	Changed      int            `json:"changed"`
+	MeanIoU      float64        `json:"mean_iou"` // of matched detections
+	Classes      []ReplayClass  `json:"classes"`
+	Changes      []ReplayChange `json:"changes,omitempty"` // the most changed, up to maxReplayChanges
+	Errors       []string       `json:"errors,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// maxReplayChanges bounds the changed requests listed in a report
+const maxReplayChanges = 50
+
+var recordings = struct {
+	sync.Mutex
+	records []TrafficRecord // oldest first
+	loaded  bool
+}{}
+
+var replays = struct {
+	sync.Mutex
+	reports map[string]*ReplayReport
+}{reports: map[string]*ReplayReport{}}
+
+func recordingsDir() string {
+	return filepath.Join(config().StateDir, "recordings")
+}
+
+func recordingImagePath(rec TrafficRecord) string {
+	return objectPath(rec.Image + rec.Ext)
+}
+
+func replaysDir() string {
+	return filepath.Join(config().StateDir, "replays")
+}
+
+// loadRecordingsLocked reads the records on first use
+func loadRecordingsLocked() {
+	if recordings.loaded {
+		return
+	}
+	recordings.loaded = true
+	f, err := os.Open(filepath.Join(recordingsDir(), "traffic.jsonl"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		var rec TrafficRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // a line cut short by a crash
+		}
+		recordings.records = append(recordings.records, rec)
+	}
+}
+
+// recordTraffic records an inference, when recording is on and the image
+// may be kept as it is
+func recordTraffic(id, imagePath, source, model string, opts InferenceOptions, result InferenceResult) {
+	cfg := config()
+	if !cfg.TrafficRecording || cfg.PrivacyMode || imageRetentionFor(source) != retentionFull {
+		return
+	}
+	opts.Model = "" // recorded as Model
+	rec := TrafficRecord{
+		ResultID:   id,
+		At:         time.Now().UTC(),
+		Source:     source,
+		Ext:        strings.ToLower(filepath.Ext(imagePath)),
+		Options:    opts,
+		Model:      model,
+		Detections: append([]Detection{}, result.Detections...),
+	}
+	if err := saveRecordingImage(&rec, imagePath); err != nil {
+		log.Printf("Warning: failed to record image of %s: %v", id, err)
+		return
+	}
+	line, _ := json.Marshal(rec)
+
+	recordings.Lock()
+	defer recordings.Unlock()
+	loadRecordingsLocked()
+	f, err := os.OpenFile(filepath.Join(recordingsDir(), "traffic.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		log.Printf("Warning: failed to record %s: %v", id, err)
+		return
+	}
+	recordings.records = append(recordings.records, rec)
+}
+
+// saveRecordingImage adds the image to the object store, where it may
+// already be
+func saveRecordingImage(rec *TrafficRecord, path string) error {
+	name, err := storeObject(path, false)
+	if err != nil {
+		return err
+	}
+	rec.Image = strings.TrimSuffix(name, rec.Ext)
+	return nil
+}
+
+// pruneRecordings drops records older than TRAFFIC_RECORDING_DAYS; the
+// storage GC deletes their images once nothing else holds them
+func pruneRecordings() {
+	cutoff := time.Now().AddDate(0, 0, -config().TrafficRecordingDays)
+	recordings.Lock()
+	defer recordings.Unlock()
+	loadRecordingsLocked()
+	keep := 0
+	for keep < len(recordings.records) && recordings.records[keep].At.Before(cutoff) {
+		keep++
+	}
+	if keep > 0 {
+		recordings.records = append([]TrafficRecord(nil), recordings.records[keep:]...)
+		var buf strings.Builder
+		for _, rec := range recordings.records {
+			line, _ := json.Marshal(rec)
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		if err := writeFileAtomic(filepath.Join(recordingsDir(), "traffic.jsonl"), []byte(buf.String())); err != nil {
+			log.Printf("Warning: failed to prune recordings: %v", err)
+			return
+		}
+	}
+
+	if keep > 0 {
+		log.Printf("Recordings: dropped %d records older than %d days", keep, config().TrafficRecordingDays)
+	}
+}
+
+// recordingsView summarizes the recordings for /api/v1/recordings
+func recordingsView() map[string]interface{} {
+	recordings.Lock()
+	defer recordings.Unlock()
+	loadRecordingsLocked()
+	view := map[string]interface{}{
+		"enabled":  config().TrafficRecording,
+		"records":  len(recordings.records),
+		"days":     config().TrafficRecordingDays,
+		"by_model": map[string]int{},
+	}
+	for _, rec := range recordings.records {
+		view["by_model"].(map[string]int)[rec.Model]++
+	}
+	if n := len(recordings.records); n > 0 {
+		view["oldest"], view["newest"] = recordings.records[0].At, recordings.records[n-1].At
+	}
+	var images, bytes int64
+	entries, _ := os.ReadDir(filepath.Join(recordingsDir(), "images"))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			images++
+			bytes += info.Size()
+		}
+	}
+	view["images"], view["image_bytes"] = images, bytes
+	return view
+}
+
+// loadReplays restores stored reports from the state dir
+func loadReplays() {
+	files, _ := filepath.Glob(filepath.Join(replaysDir(), "*.json"))
+	replays.Lock()
+	defer replays.Unlock()
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var rep ReplayReport
+		if err := json.Unmarshal(data, &rep); err != nil {
+			log.Printf("Warning: skipping unreadable replay %s: %v", f, err)
+			continue
+		}
+		if rep.Status == "running" {
+			rep.Status = "failed"
+			rep.Error = "interrupted by restart"
+		}
+		replays.reports[rep.ID] = &rep
+	}
+}
+
+func saveReplay(rep *ReplayReport) {
+	replays.Lock()
+	data, err := json.MarshalIndent(rep, "", "  ")
+	replays.Unlock()
+	if err == nil {
+		err = os.MkdirAll(replaysDir(), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(replaysDir(), rep.ID+".json"), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to store replay %s: %v", rep.ID, err)
+	}
+}
+
+// startReplay validates the request and replays in the background
+func startReplay(model string, since time.Duration, source string, limit int, iouThreshold float64) (*ReplayReport, error) {
+	if !modelExists(model) {
+		return nil, fmt.Errorf("model %q not found in %s", model, config().ModelDir)
+	}
+	if since <= 0 {
+		since = 24 * time.Hour
+	}
+	if iouThreshold == 0 {
+		iouThreshold = 0.5
+	}
+	if iouThreshold <= 0 || iouThreshold >= 1 {
+		return nil, fmt.Errorf("iou_threshold must be in (0, 1), got %g", iouThreshold)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("limit must not be negative")
+	}
+
+	from := time.Now().Add(-since).UTC()
+	var recs []TrafficRecord
+	recordings.Lock()
+	loadRecordingsLocked()
+	for _, rec := range recordings.records {
+		if !rec.At.Before(from) && (source == "" || rec.Source == source) {
+			recs = append(recs, rec)
+		}
+	}
+	recordings.Unlock()
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("no recorded requests since %s", from.Format(time.RFC3339))
+	}
+	if limit > 0 && len(recs) > limit {
+		recs = recs[len(recs)-limit:] // the most recent
+	}
+
+	rep := &ReplayReport{
+		ID:           newID(),
+		Status:       "running",
+		Model:        model,
+		Since:        from,
+		Source:       source,
+		IoUThreshold: iouThreshold,
+		StartedAt:    time.Now().UTC(),
+		Classes:      []ReplayClass{},
+	}
+	replays.Lock()
+	replays.reports[rep.ID] = rep
+	replays.Unlock()
+	saveReplay(rep)
+
+	go runReplay(rep, recs)
+	return rep, nil
+}
+
+func runReplay(rep *ReplayReport, recs []TrafficRecord) {
+	log.Printf("Replay %s: %d recorded requests against model %s", rep.ID, len(recs), rep.Model)
+	classes := map[string]*ReplayClass{}
+	class := func(name string) *ReplayClass {
+		if classes[name] == nil {
+			classes[name] = &ReplayClass{Class: name}
+		}
+		return classes[name]
+	}
+	var changes []ReplayChange
+	var errs []string
+	failed, identical, matched := 0, 0, 0
+	var iouSum float64
+
+	for _, rec := range recs {
+		path, cleanup, err := plainCopy(recordingImagePath(rec))
+		if err != nil {
+			failed++
+			if len(errs) < maxReportedErrors {
+				errs = append(errs, rec.ResultID+": "+err.Error())
+			}
+			continue
+		}
+		result := runInferenceParams(path, rep.Model, rec.Options.params())
+		cleanup()
+		if result.Error != "" {
+			failed++
+			if len(errs) < maxReportedErrors {
+				errs = append(errs, rec.ResultID+": "+result.Error)
+			}
+			continue
+		}
+		applyOptions(&result, rec.Options)
+
+		pairs := matchDetections(rec.Detections, result.Detections, rep.IoUThreshold)
+		change := ReplayChange{ResultID: rec.ResultID, At: rec.At, Source: rec.Source,
+			Recorded: len(rec.Detections), Replayed: len(result.Detections)}
+		seenOld, seenNew := map[int]bool{}, map[int]bool{}
+		for _, p := range pairs {
+			seenOld[p.a], seenNew[p.b] = true, true
+			class(rec.Detections[p.a].ClassName).Matched++
+			iouSum += p.iou
+			matched++
+		}
+		for i, d := range rec.Detections {
+			class(d.ClassName).Recorded++
+			if !seenOld[i] {
+				class(d.ClassName).Missing++
+				change.Missing++
+			}
+		}
+		for i, d := range result.Detections {
+			class(d.ClassName).Replayed++
+			if !seenNew[i] {
+				class(d.ClassName).Extra++
+				change.Extra++
+			}
+		}
+		if change.Missing == 0 && change.Extra == 0 {
+			identical++
+		} else {
+			changes = append(changes, change)
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Missing+changes[i].Extra > changes[j].Missing+changes[j].Extra
+	})
+	now := time.Now().UTC()
+	replays.Lock()
+	rep.Requests, rep.Failed, rep.Identical, rep.Changed = len(recs), failed, identical, len(changes)
+	if matched > 0 {
+		rep.MeanIoU = iouSum / float64(matched)
+	}
+	for _, c := range classes {
+		rep.Classes = append(rep.Classes, *c)
+	}
+	sort.Slice(rep.Classes, func(i, j int) bool { return rep.Classes[i].Class < rep.Classes[j].Class })
+	if len(changes) > maxReplayChanges {
+		changes = changes[:maxReplayChanges]
+	}
+	rep.Changes, rep.Errors, rep.FinishedAt = changes, errs, &now
+	rep.Status = "completed"
+	if failed == len(recs) {
+		rep.Status, rep.Error = "failed", fmt.Sprintf("inference failed on all %d images", failed)
+	}
+	replays.Unlock()
+	saveReplay(rep)
+	log.Printf("Replay %s finished: %s", rep.ID, rep.Status)
+}
+
+// detectionPair is a detection of a matched to one of b
+type detectionPair struct {
+	a, b int
+	iou  float64
+}
+
+// matchDetections pairs detections of the same class whose boxes overlap by
+// at least iouThreshold, best overlaps first; each detection is used once
+func matchDetections(a, b []Detection, iouThreshold float64) []detectionPair {
+	var candidates []detectionPair
+	for i, da := range a {
+		for j, db := range b {
+			if da.ClassName != db.ClassName {
+				continue
+			}
+			if v := iou(da.BBox, db.BBox); v >= iouThreshold {
+				candidates = append(candidates, detectionPair{i, j, v})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].iou > candidates[j].iou })
+	usedA, usedB := map[int]bool{}, map[int]bool{}
+	var pairs []detectionPair
+	for _, c := range candidates {
+		if usedA[c.a] || usedB[c.b] {
+			continue
+		}
+		usedA[c.a], usedB[c.b] = true, true
+		pairs = append(pairs, c)
+	}
+	return pairs
+}
+
+// recordingsHandler serves GET /api/v1/recordings
+func recordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, recordingsView())
+}
+
+// replaysHandler serves the replay API
+func replaysHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/replays"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		replays.Lock()
+		list := make([]ReplayReport, 0, len(replays.reports))
+		for _, rep := range replays.reports {
+			list = append(list, *rep)
+		}
+		replays.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+		writeJSON(w, http.StatusOK, list)
+
+	case id == "" && r.Method == http.MethodPost:
+		var req struct {
+			Model        string  `json:"model"`
+			Since        string  `json:"since"`
+			Source       string  `json:"source"`
+			Limit        int     `json:"limit"`
+			IoUThreshold float64 `json:"iou_threshold"`
+		}
+		if err := decodeJSON(w, r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		var since time.Duration
+		if req.Since != "" {
+			d, err := time.ParseDuration(req.Since)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid since duration")
+				return
+			}
+			since = d
+		}
+		rep, err := startReplay(req.Model, since, req.Source, req.Limit, req.IoUThreshold)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		replays.Lock()
+		resp := *rep
+		replays.Unlock()
+		writeJSON(w, http.StatusAccepted, resp)
+
+	case id != "" && r.Method == http.MethodGet:
+		replays.Lock()
+		rep, ok := replays.reports[id]
+		var resp ReplayReport
+		if ok {
+			resp = *rep
+		}
+		replays.Unlock()
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Replay not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,933 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reports summarize a window of the node's activity for people who do not
+// watch the dashboard: detections by class and source, inference volume and
+// latency (from the time series, see timeseries.go), alerts by rule, uptime,
+// backend outages, maintenance and model changes. Each is stored under
+// STATE_DIR/reports as JSON, standalone HTML and PDF, in DEFAULT_LANGUAGE:
+//
+//	GET    /api/v1/reports            stored reports, newest first
+//	POST   /api/v1/reports            generate one now: {"period": "daily"} (yesterday),
+//	                                  {"period": "weekly"} (the 7 days to last midnight)
+//	                                  or {"from": ..., "to": ...}; "email": true also mails it
+//	GET    /api/v1/reports/{id}       the report as JSON
+//	GET    /api/v1/reports/{id}.html  the HTML report
+//	GET    /api/v1/reports/{id}.pdf   the PDF report
+//	DELETE /api/v1/reports/{id}
+//
+// The daily-report and weekly-report tasks (SCHEDULE_DAILY_REPORT, default
+// 00:10 every day, and SCHEDULE_WEEKLY_REPORT, 00:20 on Mondays) generate
+// them and, with REPORT_EMAIL_TO and SMTP_ADDR set, email the HTML with the
+// PDF attached. Mail that cannot go out, say while the node is offline, is
+// retried on the next run. Reports are deleted after REPORT_RETENTION_DAYS
+// (default 90).
+//
+// Counts come from the hourly buckets, or the daily ones once the window
+// starts before TIMESERIES_HOUR_RETENTION, so a custom window is widened to
+// whole hours or days. Alerts, uptime and the events a report lists are
+// kept in STATE_DIR/report-journal.json for TIMESERIES_DAY_RETENTION.
+
+// Report periods
+const (
+	reportDaily  = "daily"
+	reportWeekly = "weekly"
+	reportCustom = "custom"
+)
+
+// Email delivery states of a report
+const (
+	reportEmailSent    = "sent"
+	reportEmailPending = "pending"
+)
+
+var reportIDPattern = regexp.MustCompile(`^(daily|weekly|custom)-[0-9T-]+$`)
+
+var reportsGenerated = newCounterVec("yolo_reports_total",
+	"Reports generated by period and outcome.", "period", "status")
+
+// Report is a summary of the node's activity over [From, To)
+type Report struct {
+	ID           string         `json:"id"`
+	Period       string         `json:"period"`
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	Node         string         `json:"node"`
+	Model        string         `json:"model"` // serving when the report was generated
+	CreatedAt    time.Time      `json:"created_at"`
+	Inferences   int            `json:"inferences"`
+	Errors       int            `json:"errors"`
+	Detections   int            `json:"detections"`
+	Classes      map[string]int `json:"classes"`
+	Sources      map[string]int `json:"sources"` // detections by source
+	LatencyAvgMS float64        `json:"latency_avg_ms"`
+	LatencyMaxMS float64        `json:"latency_max_ms"`
+	Alerts       map[string]int `json:"alerts"` // by rule
+	Uptime       float64        `json:"uptime"` // fraction of the window the node was running
+	Starts       int            `json:"starts"`
+	Outages      int            `json:"backend_outages"`
+	Events       []JournalEvent `json:"events"` // backend, maintenance and model changes
+	EmailStatus  string         `json:"email_status,omitempty"`
+	EmailError   string         `json:"email_error,omitempty"`
+}
+
+// JournalEvent is a notable event kept for reports
+type JournalEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"` // an event type of events.go
+	Detail string    `json:"detail,omitempty"`
+}
+
+// upInterval is a stretch of time the node was running
+type upInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// journalData is what reports need beyond the time series
+type journalData struct {
+	Up     []upInterval             `json:"up"`
+	Alerts map[int64]map[string]int `json:"alerts"` // hour start (Unix seconds), then rule
+	Events []JournalEvent           `json:"events"`
+}
+
+var journal = struct {
+	sync.Mutex
+	data journalData
+}{data: journalData{Alerts: map[int64]map[string]int{}}}
+
+// reportsMu serializes generating, emailing and deleting stored reports
+var reportsMu sync.Mutex
+
+func reportJournalPath() string {
+	return filepath.Join(config().StateDir, "report-journal.json")
+}
+
+func reportsDir() string {
+	return filepath.Join(config().StateDir, "reports")
+}
+
+// startReports loads the journal, notes this start and records the events
+// reports list
+func startReports() {
+	now := time.Now()
+	journal.Lock()
+	if data, err := os.ReadFile(reportJournalPath()); err == nil {
+		if err := json.Unmarshal(data, &journal.data); err != nil {
+			log.Printf("Warning: ignoring unreadable %s: %v", reportJournalPath(), err)
+			journal.data = journalData{}
+		}
+	}
+	if journal.data.Alerts == nil {
+		journal.data.Alerts = map[int64]map[string]int{}
+	}
+	journal.data.Up = append(journal.data.Up, upInterval{Start: now, End: now})
+	journal.Unlock()
+
+	types := []string{eventAlertFired, eventBackendStatus, eventMaintenance, eventModelActivated}
+	bus.subscribe("reports", types, nil, 256).consume(recordJournalEvent)
+	go func() {
+		for range time.Tick(time.Minute) {
+			saveJournal()
+		}
+	}()
+}
+
+// recordJournalEvent notes an event in the journal
+func recordJournalEvent(ev Event) {
+	journal.Lock()
+	defer journal.Unlock()
+	switch d := ev.Data.(type) {
+	case Alert:
+		hour := ev.Time.Truncate(time.Hour).Unix()
+		if journal.data.Alerts[hour] == nil {
+			journal.data.Alerts[hour] = map[string]int{}
+		}
+		journal.data.Alerts[hour][d.Rule]++
+		return
+	case BackendEvent:
+		// Recovering is a probe between down and up, not worth a line
+		if d.Status == "recovering" {
+			return
+		}
+		detail := d.Status
+		if d.Error != "" {
+			detail += ": " + d.Error
+		}
+		journal.data.Events = append(journal.data.Events, JournalEvent{ev.Time, ev.Type, detail})
+	case MaintenanceView:
+		detail := "off"
+		if d.Enabled {
+			detail = "on"
+			if d.Reason != "" {
+				detail += ": " + d.Reason
+			}
+		}
+		journal.data.Events = append(journal.data.Events, JournalEvent{ev.Time, ev.Type, detail})
+	case ModelEvent:
+		detail := d.Version
+		if d.Previous != "" {
+			detail += " (" + d.Previous + ")"
+		}
+		journal.data.Events = append(journal.data.Events, JournalEvent{ev.Time, ev.Type, detail})
+	}
+}
+
+// saveJournal extends the current up interval, drops entries past
+// TIMESERIES_DAY_RETENTION and writes the journal
+func saveJournal() {
+	now := time.Now()
+	cutoff := now.Add(-config().TimeseriesDayRetention)
+	journal.Lock()
+	defer journal.Unlock()
+	d := &journal.data
+	if n := len(d.Up); n > 0 {
+		d.Up[n-1].End = now
+	}
+	for len(d.Up) > 1 && d.Up[0].End.Before(cutoff) {
+		d.Up = d.Up[1:]
+	}
+	for hour := range d.Alerts {
+		if hour < cutoff.Unix() {
+			delete(d.Alerts, hour)
+		}
+	}
+	for len(d.Events) > 0 && d.Events[0].Time.Before(cutoff) {
+		d.Events = d.Events[1:]
+	}
+	data, err := json.Marshal(d)
+	if err == nil {
+		err = writeFileAtomic(reportJournalPath(), data)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save the report journal: %v", err)
+	}
+}
+
+// validateReports checks the report and email settings
+func validateReports(cfg *Config) error {
+	if cfg.ReportRetentionDays < 1 {
+		return fmt.Errorf("REPORT_RETENTION_DAYS must be at least 1")
+	}
+	if cfg.ReportEmailTo == "" {
+		return nil
+	}
+	if cfg.SMTPAddr == "" {
+		return fmt.Errorf("REPORT_EMAIL_TO needs SMTP_ADDR")
+	}
+	if _, _, err := net.SplitHostPort(cfg.SMTPAddr); err != nil {
+		return fmt.Errorf("SMTP_ADDR must be host:port")
+	}
+	if _, err := mail.ParseAddressList(cfg.ReportEmailTo); err != nil {
+		return fmt.Errorf("REPORT_EMAIL_TO: %v", err)
+	}
+	if _, err := mail.ParseAddress(cfg.ReportEmailFrom); err != nil {
+		return fmt.Errorf("REPORT_EMAIL_FROM: %v", err)
+	}
+	return nil
+}
+
+// reportWindow returns the window of a daily or weekly report generated at
+// now: the day or 7 days up to the last local midnight
+func reportWindow(period string, now time.Time) (time.Time, time.Time) {
+	to := bucketStart(now, resolutionDay)
+	if period == reportWeekly {
+		return to.AddDate(0, 0, -7), to
+	}
+	return to.AddDate(0, 0, -1), to
+}
+
+func reportID(period string, from, to time.Time) string {
+	if period == reportCustom {
+		return fmt.Sprintf("custom-%s-%s", from.Local().Format("20060102T1504"), to.Local().Format("20060102T1504"))
+	}
+	return period + "-" + from.Local().Format("2006-01-02")
+}
+
+// checkReportWindow rejects windows a report cannot cover
+func checkReportWindow(from, to time.Time) error {
+	if !from.Before(to) {
+		return fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from)/(24*time.Hour) >= maxTimeseriesPoints {
+		return fmt.Errorf("at most %d days per report", maxTimeseriesPoints)
+	}
+	return nil
+}
+
+// buildReport summarizes [from, to)
+func buildReport(period string, from, to time.Time) (*Report, error) {
+	if err := checkReportWindow(from, to); err != nil {
+		return nil, err
+	}
+	resolution := resolutionHour
+	if from.Before(time.Now().Add(-config().TimeseriesHourRetention)) || to.Sub(from)/time.Hour >= maxTimeseriesPoints {
+		resolution = resolutionDay
+	}
+	rep := &Report{
+		ID:        reportID(period, from, to),
+		Period:    period,
+		From:      from,
+		To:        to,
+		Node:      getEnv("NODE_NAME", "unknown"),
+		Model:     activeModelVersion(),
+		CreatedAt: time.Now(),
+		Classes:   map[string]int{},
+		Sources:   map[string]int{},
+		Alerts:    map[string]int{},
+	}
+
+	// The last bucket is the one holding the instant before to
+	last := to.Add(-time.Nanosecond)
+	latencySum := 0.0
+	for _, p := range queryTimeseries(resolution, "", from, last) {
+		rep.Inferences += p.Inferences
+		rep.Errors += p.Errors
+		rep.Detections += p.Detections
+		latencySum += p.LatencyAvgMS * float64(p.Inferences)
+		if p.LatencyMaxMS > rep.LatencyMaxMS {
+			rep.LatencyMaxMS = p.LatencyMaxMS
+		}
+		for class, n := range p.Classes {
+			rep.Classes[class] += n
+		}
+	}
+	if rep.Inferences > 0 {
+		rep.LatencyAvgMS = latencySum / float64(rep.Inferences)
+	}
+	for _, source := range timeseriesSources() {
+		n := 0
+		for _, p := range queryTimeseries(resolution, source, from, last) {
+			n += p.Detections
+		}
+		if n > 0 {
+			rep.Sources[source] = n
+		}
+	}
+
+	journal.Lock()
+	defer journal.Unlock()
+	for hour, byRule := range journal.data.Alerts {
+		if at := time.Unix(hour, 0); !at.Before(from) && at.Before(to) {
+			for rule, n := range byRule {
+				rep.Alerts[rule] += n
+			}
+		}
+	}
+	var up time.Duration
+	for _, iv := range journal.data.Up {
+		start, end := iv.Start, iv.End
+		if !start.Before(from) && start.Before(to) {
+			rep.Starts++
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			up += end.Sub(start)
+		}
+	}
+	window := to.Sub(from)
+	if now := time.Now(); to.After(now) {
+		window = now.Sub(from)
+	}
+	if window > 0 {
+		rep.Uptime = float64(up) / float64(window)
+		if rep.Uptime > 1 {
+			rep.Uptime = 1
+		}
+	}
+	rep.Events = []JournalEvent{}
+	for _, ev := range journal.data.Events {
+		if !ev.Time.Before(from) && ev.Time.Before(to) {
+			rep.Events = append(rep.Events, ev)
+			if ev.Type == eventBackendStatus && strings.HasPrefix(ev.Detail, "down") {
+				rep.Outages++
+			}
+		}
+	}
+	return rep, nil
+}
+
+// createReport builds, stores and, when email is set, mails a report
+func createReport(period string, from, to time.Time, email bool) (*Report, error) {
+	rep, err := buildReport(period, from, to)
+	if err != nil {
+		reportsGenerated.inc(period, "error")
+		return nil, err
+	}
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if email {
+		rep.EmailStatus = reportEmailPending
+	}
+	if err := storeReport(rep); err != nil {
+		reportsGenerated.inc(period, "error")
+		return nil, err
+	}
+	reportsGenerated.inc(period, "success")
+	if email {
+		mailReport(rep)
+	}
+	pruneReports()
+	return rep, nil
+}
+
+// runScheduledReport generates the report of the period just ended, then
+// retries the mail of earlier reports that did not go out
+func runScheduledReport(period string) error {
+	from, to := reportWindow(period, time.Now())
+	email := config().ReportEmailTo != ""
+	rep, err := createReport(period, from, to, email)
+	if err != nil {
+		return err
+	}
+	if !email {
+		return nil
+	}
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	for _, r := range listReports() {
+		if r.EmailStatus == reportEmailPending && r.ID != rep.ID {
+			mailReport(&r)
+		}
+	}
+	if rep.EmailStatus != reportEmailSent {
+		return fmt.Errorf("report %s stored but not emailed: %s", rep.ID, rep.EmailError)
+	}
+	return nil
+}
+
+// storeReport writes the JSON, HTML and PDF of rep; the caller holds reportsMu
+func storeReport(rep *Report) error {
+	html, err := renderReportHTML(rep)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(reportsDir(), rep.ID)
+	if err := writeFileAtomic(base+".html", html); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+".pdf", renderReportPDF(rep)); err != nil {
+		return err
+	}
+	return saveReportJSON(rep)
+}
+
+func saveReportJSON(rep *Report) error {
+	data, _ := json.MarshalIndent(rep, "", "  ")
+	return writeFileAtomic(filepath.Join(reportsDir(), rep.ID+".json"), data)
+}
+
+// listReports reads the stored reports, newest first
+func listReports() []Report {
+	paths, _ := filepath.Glob(filepath.Join(reportsDir(), "*.json"))
+	out := []Report{}
+	for _, p := range paths {
+		if rep, err := readReport(strings.TrimSuffix(filepath.Base(p), ".json")); err == nil {
+			out = append(out, *rep)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+func readReport(id string) (*Report, error) {
+	data, err := os.ReadFile(filepath.Join(reportsDir(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var rep Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+func deleteReport(id string) error {
+	err := os.Remove(filepath.Join(reportsDir(), id+".json"))
+	os.Remove(filepath.Join(reportsDir(), id+".html"))
+	os.Remove(filepath.Join(reportsDir(), id+".pdf"))
+	return err
+}
+
+// pruneReports deletes reports past REPORT_RETENTION_DAYS; the caller holds reportsMu
+func pruneReports() {
+	cutoff := time.Now().AddDate(0, 0, -config().ReportRetentionDays)
+	for _, rep := range listReports() {
+		if rep.CreatedAt.Before(cutoff) {
+			deleteReport(rep.ID)
+		}
+	}
+}
+
+// reportText returns the translator into DEFAULT_LANGUAGE reports are written in
+func reportText() func(string, ...interface{}) string {
+	lang := supportedLanguage(config().DefaultLanguage)
+	return func(msg string, args ...interface{}) string {
+		if len(args) == 0 {
+			return translate(lang, msg)
+		}
+		return fmt.Sprintf(translate(lang, msg), args...)
+	}
+}
+
+// reportTitle names the report's window
+func reportTitle(rep *Report) string {
+	t := reportText()
+	from, to := rep.From.Local(), rep.To.Local()
+	switch rep.Period {
+	case reportDaily:
+		return t("Daily report for %s", from.Format("2006-01-02"))
+	case reportWeekly:
+		return t("Weekly report for %s to %s", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	return t("Report for %s to %s", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+}
+
+// eventLabel names a journal event type
+func eventLabel(eventType string) string {
+	switch eventType {
+	case eventBackendStatus:
+		return "Inference backend"
+	case eventMaintenance:
+		return "Maintenance"
+	case eventModelActivated:
+		return "Model activated"
+	}
+	return eventType
+}
+
+// sortedCounts orders counts by count, then name
+func sortedCounts(counts map[string]int) []struct {
+	Name  string
+	Count int
+} {
+	out := make([]struct {
+		Name  string
+		Count int
+	}, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, struct {
+			Name  string
+			Count int
+		}{name, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func renderReportHTML(rep *Report) ([]byte, error) {
+	tmpl := `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <meta charset="utf-8">
+    <title>{{brandTitle}} - {{title}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 30px auto;
+            padding: 20px;
+            color: #333;
+        }
+        h2 {
+            border-bottom: 1px solid #ddd;
+            padding-bottom: 4px;
+        }
+        table {
+            border-collapse: collapse;
+            width: 100%;
+        }
+        td, th {
+            text-align: left;
+            padding: 4px 8px;
+            border-bottom: 1px solid #eee;
+        }
+        .bar {
+            background: #667eea;
+            height: 10px;
+        }
+        .muted {
+            color: #777;
+            font-size: 13px;
+        }
+    </style>
+</head>
+<body>
+    <h1>{{title}}</h1>
+    <p class="muted">{{brandTitle}} · {{t "Node"}} {{.Node}} · {{t "Model in use"}} {{.Model}} · {{t "Generated %s" (time .CreatedAt)}}</p>
+
+    <h2>{{t "Activity"}}</h2>
+    <table>
+        <tr><th>{{t "Inferences"}}</th><td>{{.Inferences}}</td></tr>
+        <tr><th>{{t "Errors"}}</th><td>{{.Errors}}</td></tr>
+        <tr><th>{{t "Detections"}}</th><td>{{.Detections}}</td></tr>
+        <tr><th>{{t "Average latency"}}</th><td>{{printf "%.0f" .LatencyAvgMS}} ms</td></tr>
+        <tr><th>{{t "Maximum latency"}}</th><td>{{printf "%.0f" .LatencyMaxMS}} ms</td></tr>
+    </table>
+
+    <h2>{{t "Detections by class"}}</h2>
+    {{with counts .Classes}}<table>
+        {{range .}}<tr><td>{{.Name}}</td><td>{{.Count}}</td><td style="width: 50%"><div class="bar" style="width: {{percent .Count}}%"></div></td></tr>
+        {{end}}</table>{{else}}<p class="muted">{{t "No detections"}}</p>{{end}}
+
+    <h2>{{t "Detections by source"}}</h2>
+    {{with counts .Sources}}<table>
+        {{range .}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+        {{end}}</table>{{else}}<p class="muted">{{t "No detections"}}</p>{{end}}
+
+    <h2>{{t "Alerts by rule"}}</h2>
+    {{with counts .Alerts}}<table>
+        {{range .}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+        {{end}}</table>{{else}}<p class="muted">{{t "No alerts"}}</p>{{end}}
+
+    <h2>{{t "Availability"}}</h2>
+    <table>
+        <tr><th>{{t "Uptime"}}</th><td>{{printf "%.2f" (uptime .Uptime)}}%</td></tr>
+        <tr><th>{{t "Starts"}}</th><td>{{.Starts}}</td></tr>
+        <tr><th>{{t "Backend outages"}}</th><td>{{.Outages}}</td></tr>
+    </table>
+
+    <h2>{{t "Events"}}</h2>
+    {{with .Events}}<table>
+        {{range .}}<tr><td>{{time .Time}}</td><td>{{t (label .Type)}}</td><td>{{.Detail}}</td></tr>
+        {{end}}</table>{{else}}<p class="muted">{{t "No events"}}</p>{{end}}
+</body>
+</html>
+`
+	maxClass := 0
+	for _, n := range rep.Classes {
+		if n > maxClass {
+			maxClass = n
+		}
+	}
+	funcs := template.FuncMap{
+		"t":          reportText(),
+		"lang":       func() string { return supportedLanguage(config().DefaultLanguage) },
+		"brandTitle": func() string { return config().BrandTitle },
+		"title":      func() string { return reportTitle(rep) },
+		"time":       func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+		"counts":     sortedCounts,
+		"label":      eventLabel,
+		"uptime":     func(f float64) float64 { return f * 100 },
+		"percent":    func(n int) int { return n * 100 / maxClass },
+	}
+	t, err := template.New("report").Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, rep); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderReportPDF(rep *Report) []byte {
+	t := reportText()
+	d := newPDF()
+	d.title(reportTitle(rep))
+	d.line(fmt.Sprintf("%s · %s %s · %s %s · %s", config().BrandTitle, t("Node"), rep.Node,
+		t("Model in use"), rep.Model, t("Generated %s", rep.CreatedAt.Local().Format("2006-01-02 15:04"))))
+
+	d.heading(t("Activity"))
+	d.row(false, []string{t("Inferences"), fmt.Sprint(rep.Inferences)}, []float64{0, 150})
+	d.row(false, []string{t("Errors"), fmt.Sprint(rep.Errors)}, []float64{0, 150})
+	d.row(false, []string{t("Detections"), fmt.Sprint(rep.Detections)}, []float64{0, 150})
+	d.row(false, []string{t("Average latency"), fmt.Sprintf("%.0f ms", rep.LatencyAvgMS)}, []float64{0, 150})
+	d.row(false, []string{t("Maximum latency"), fmt.Sprintf("%.0f ms", rep.LatencyMaxMS)}, []float64{0, 150})
+
+	d.heading(t("Detections by class"))
+	classes := sortedCounts(rep.Classes)
+	for _, c := range classes {
+		d.bar(c.Name, float64(c.Count), float64(classes[0].Count))
+	}
+	if len(classes) == 0 {
+		d.line(t("No detections"))
+	}
+	for _, section := range []struct {
+		heading, empty string
+		counts         map[string]int
+	}{
+		{"Detections by source", "No detections", rep.Sources},
+		{"Alerts by rule", "No alerts", rep.Alerts},
+	} {
+		d.heading(t(section.heading))
+		for _, c := range sortedCounts(section.counts) {
+			d.row(false, []string{c.Name, fmt.Sprint(c.Count)}, []float64{0, 150})
+		}
+		if len(section.counts) == 0 {
+			d.line(t(section.empty))
+		}
+	}
+
+	d.heading(t("Availability"))
+	d.row(false, []string{t("Uptime"), fmt.Sprintf("%.2f%%", rep.Uptime*100)}, []float64{0, 150})
+	d.row(false, []string{t("Starts"), fmt.Sprint(rep.Starts)}, []float64{0, 150})
+	d.row(false, []string{t("Backend outages"), fmt.Sprint(rep.Outages)}, []float64{0, 150})
+
+	d.heading(t("Events"))
+	for _, ev := range rep.Events {
+		d.row(false, []string{ev.Time.Local().Format("2006-01-02 15:04"), t(eventLabel(ev.Type)), ev.Detail}, []float64{0, 100, 230})
+	}
+	if len(rep.Events) == 0 {
+		d.line(t("No events"))
+	}
+	return d.bytes()
+}
+
+// mailReport emails a stored report when the node is online, recording the
+// outcome on it; the caller holds reportsMu
+func mailReport(rep *Report) {
+	rep.EmailStatus, rep.EmailError = reportEmailPending, ""
+	if status := getNodeStatus(); status.NetworkStatus != "online" {
+		rep.EmailError = "node is " + status.NetworkStatus
+	} else if err := sendReportEmail(rep); err != nil {
+		log.Printf("Warning: failed to email report %s: %v", rep.ID, err)
+		rep.EmailError = err.Error()
+	} else {
+		rep.EmailStatus = reportEmailSent
+		log.Printf("Emailed report %s to %s", rep.ID, config().ReportEmailTo)
+	}
+	if err := saveReportJSON(rep); err != nil {
+		log.Printf("Warning: failed to save report %s: %v", rep.ID, err)
+	}
+}
+
+// sendReportEmail mails the HTML of a report with its PDF attached
+func sendReportEmail(rep *Report) error {
+	cfg := config()
+	if cfg.ReportEmailTo == "" || cfg.SMTPAddr == "" {
+		return fmt.Errorf("REPORT_EMAIL_TO and SMTP_ADDR are not configured")
+	}
+	to, err := mail.ParseAddressList(cfg.ReportEmailTo)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(cfg.ReportEmailFrom)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(reportsDir(), rep.ID)
+	html, err := os.ReadFile(base + ".html")
+	if err != nil {
+		return err
+	}
+	pdf, err := os.ReadFile(base + ".pdf")
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	var recipients []string
+	for _, a := range to {
+		recipients = append(recipients, a.String())
+	}
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		from, strings.Join(recipients, ", "),
+		mime.QEncoding.Encode("utf-8", config().BrandTitle+": "+reportTitle(rep)+" ("+rep.Node+")"),
+		time.Now().Format(time.RFC1123Z), mw.Boundary())
+	for _, part := range []struct {
+		header  textproto.MIMEHeader
+		content []byte
+	}{
+		{textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}}, html},
+		{textproto.MIMEHeader{
+			"Content-Type":        {"application/pdf"},
+			"Content-Disposition": {`attachment; filename="` + rep.ID + `.pdf"`},
+		}, pdf},
+	} {
+		part.header.Set("Content-Transfer-Encoding", "base64")
+		w, err := mw.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(part.content)
+		for len(encoded) > 76 {
+			fmt.Fprintf(w, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(w, "%s\r\n", encoded)
+	}
+	mw.Close()
+
+	addrs := make([]string, len(to))
+	for i, a := range to {
+		addrs[i] = a.Address
+	}
+	return smtpSend(cfg, from.Address, addrs, body.Bytes())
+}
+
+// smtpSend delivers a message through SMTP_ADDR, upgrading to TLS when the
+// server offers it and authenticating when SMTP_USERNAME is set
+func smtpSend(cfg *Config, from string, to []string, msg []byte) error {
+	host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+	conn, err := net.DialTimeout("tcp", cfg.SMTPAddr, 15*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// reportsHandler serves /api/v1/reports and the stored reports
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/reports"), "/")
+	if name == "" {
+		switch r.Method {
+		case http.MethodGet:
+			reportsMu.Lock()
+			defer reportsMu.Unlock()
+			writeJSON(w, http.StatusOK, listReports())
+		case http.MethodPost:
+			generateReportHandler(w, r)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	id, ext := name, ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		id, ext = name[:i], name[i:]
+	}
+	if !reportIDPattern.MatchString(id) || (ext != "" && ext != ".html" && ext != ".pdf") {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	rep, err := readReport(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	switch {
+	case r.Method == http.MethodDelete && ext == "":
+		if err := deleteReport(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method != http.MethodGet:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	case ext == "":
+		writeJSON(w, http.StatusOK, rep)
+	default:
+		w.Header().Set("Content-Disposition", `inline; filename="`+id+ext+`"`)
+		http.ServeFile(w, r, filepath.Join(reportsDir(), id+ext))
+	}
+}
+
+// generateReportHandler serves POST /api/v1/reports
+func generateReportHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Period string     `json:"period"`
+		From   *time.Time `json:"from"`
+		To     *time.Time `json:"to"`
+		Email  bool       `json:"email"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	var from, to time.Time
+	switch {
+	case req.Period == reportDaily || req.Period == reportWeekly:
+		if req.From != nil || req.To != nil {
+			writeJSONError(w, http.StatusBadRequest, "Set period or from and to, not both")
+			return
+		}
+		from, to = reportWindow(req.Period, time.Now())
+	case req.Period == "" || req.Period == reportCustom:
+		if req.From == nil || req.To == nil {
+			writeJSONError(w, http.StatusBadRequest, "Set period or from and to, not both")
+			return
+		}
+		req.Period, from, to = reportCustom, *req.From, *req.To
+		if err := checkReportWindow(from, to); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, "period must be daily, weekly or custom")
+		return
+	}
+	if req.Email && config().ReportEmailTo == "" {
+		writeJSONError(w, http.StatusBadRequest, "REPORT_EMAIL_TO is not configured")
+		return
+	}
+	rep, err := createReport(req.Period, from, to, req.Email)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Report failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, rep)
+}
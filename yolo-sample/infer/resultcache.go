@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// With RESULT_CACHE_TTL set, the detections of an image are kept in the
+// key-value store (see kvstore.go) for that long, keyed by the image's
+// content, the model version and the inference parameters. The same image
+// sent again, by a retrying client or a camera watching a still scene, is
+// answered without running the model. Class maps, zones and hooks still
+// apply to every result, so changing them takes effect at once. Only
+// uploads, ingested images and camera frames use the cache; evaluations and
+// golden tests always run the model.
+
+// cachedDetections is what the result cache keeps of an inference
+type cachedDetections struct {
+	Detections []Detection `json:"detections"`
+	Count      int         `json:"count"`
+}
+
+var resultCacheLookups = newCounterVec("yolo_result_cache_total",
+	"Result cache lookups by outcome: hit or miss.", "outcome")
+
+// runInferenceCached is runInferenceParams answering from the result cache
+// when it can
+func runInferenceCached(imagePath, version string, params backendParams) InferenceResult {
+	if config().ResultCacheTTL <= 0 {
+		return runInferenceParams(imagePath, version, params)
+	}
+	key := resultCacheKey(imagePath, version, params)
+	if key == "" {
+		return runInferenceParams(imagePath, version, params)
+	}
+	result, ok := cachedInference(key)
+	if ok {
+		result.Image = filepath.Base(imagePath)
+	} else {
+		result = checkResult(inferThroughBreaker(imagePath, version, params), imagePath)
+		cacheInference(key, result)
+	}
+	applyClassMap(&result)
+	return result
+}
+
+// resultCacheKey identifies an inference of the image at path, or is ""
+// when the image cannot be read
+func resultCacheKey(path, version string, params backendParams) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	p, _ := json.Marshal(params)
+	h.Write([]byte{0})
+	h.Write([]byte(version))
+	h.Write([]byte{0})
+	h.Write(p)
+	return "yolo:cache:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedInference returns the cached detections under key
+func cachedInference(key string) (InferenceResult, bool) {
+	value, ok, _ := kv.get(key)
+	var cached cachedDetections
+	if !ok || json.Unmarshal([]byte(value), &cached) != nil {
+		resultCacheLookups.inc("miss")
+		return InferenceResult{}, false
+	}
+	resultCacheLookups.inc("hit")
+	return InferenceResult{Detections: cached.Detections, Count: cached.Count}, true
+}
+
+// cacheInference keeps the detections of a successful inference
+func cacheInference(key string, result InferenceResult) {
+	if result.Error != "" {
+		return
+	}
+	data, err := json.Marshal(cachedDetections{Detections: result.Detections, Count: result.Count})
+	if err == nil {
+		kv.set(key, string(data), config().ResultCacheTTL)
+	}
+}
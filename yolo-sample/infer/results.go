@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feedback is an operator verdict on a result: a detection was correct or
+// incorrect, or an object of Class was missed entirely
+type Feedback struct {
+	Detection *int      `json:"detection,omitempty"` // index into Detections; unset for "missed"
+	Class     string    `json:"class"`
+	Verdict   string    `json:"verdict"` // "correct", "incorrect", "missed"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// resultStore keeps inference results as one JSON file per result under STATE_DIR/results
+type resultStore struct {
+	mu    sync.RWMutex
+	dir   string
+	byID  map[string]*InferenceResult
+	order []string // IDs, oldest first
+}
+
+var results *resultStore
+
+// newResultStore opens the store in dir, loading any results already on disk
+func newResultStore(dir string) *resultStore {
+	s := &resultStore{dir: dir, byID: map[string]*InferenceResult{}}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Warning: failed to create results dir %s: %v", dir, err)
+		return s
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, f := range files {
+		data, err := readStoredFile(f)
+		if err != nil {
+			log.Printf("Warning: skipping unreadable result %s: %v", f, err)
+			continue
+		}
+		var r InferenceResult
+		if err := json.Unmarshal(data, &r); err != nil || r.ID == "" {
+			log.Printf("Warning: skipping unreadable result %s: %v", f, err)
+			continue
+		}
+		s.byID[r.ID] = &r
+		s.order = append(s.order, r.ID)
+		for _, fb := range r.Feedback {
+			observeFeedback(r.Model, fb.Class, fb.Verdict)
+		}
+	}
+	sort.Slice(s.order, func(i, j int) bool {
+		return s.byID[s.order[i]].CreatedAt.Before(s.byID[s.order[j]].CreatedAt)
+	})
+	log.Printf("Loaded %d stored results from %s", len(s.order), dir)
+	return s
+}
+
+// newID returns a random 16-character hex identifier
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// save assigns an ID to the result (if it has none) and persists it
+func (s *resultStore) save(r *InferenceResult) error {
+	if r.ID == "" {
+		r.ID = newID()
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	if r.Sequence == 0 {
+		r.Sequence = nextSequence()
+		r.ClockUnsynchronized = !clockSynced()
+	}
+
+	s.mu.Lock()
+	if err := s.writeLocked(r); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if _, exists := s.byID[r.ID]; !exists {
+		s.order = append(s.order, r.ID)
+	}
+	stored := *r
+	s.byID[r.ID] = &stored
+	s.mu.Unlock()
+	if clusterOn() {
+		cluster.indexResult(&stored)
+	}
+	return nil
+}
+
+func (s *resultStore) writeLocked(r *InferenceResult) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, r.ID+".json.tmp")
+	if err := os.WriteFile(tmp, sealData(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, r.ID+".json"))
+}
+
+// get returns a copy of the stored result with the given ID
+func (s *resultStore) get(id string) (InferenceResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.byID[id]
+	if !ok {
+		return InferenceResult{}, false
+	}
+	return *r, true
+}
+
+// list returns up to limit results, newest first
+func (s *resultStore) list(limit int) []InferenceResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []InferenceResult
+	for i := len(s.order) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		out = append(out, *s.byID[s.order[i]])
+	}
+	return out
+}
+
+// listSince returns results created after t, oldest first
+func (s *resultStore) listSince(t time.Time) []InferenceResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []InferenceResult
+	for _, id := range s.order {
+		if r := s.byID[id]; r.CreatedAt.After(t) {
+			out = append(out, *r)
+		}
+	}
+	return out
+}
+
+// deleteBefore removes results created before cutoff and returns them
+func (s *resultStore) deleteBefore(cutoff time.Time) []InferenceResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.order[:0]
+	var deleted []InferenceResult
+	for _, id := range s.order {
+		if s.byID[id].CreatedAt.Before(cutoff) {
+			if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: failed to delete result %s: %v", id, err)
+				kept = append(kept, id)
+				continue
+			}
+			removeResultImages(*s.byID[id])
+			deleted = append(deleted, *s.byID[id])
+			delete(s.byID, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+	return deleted
+}
+
+// addFeedback validates fb against the stored result, appends it, and records metrics
+func (s *resultStore) addFeedback(id string, fb Feedback) error {
+	var classes map[string]string
+	if fb.Verdict == "missed" {
+		// Outside the lock: the first read of a model's metadata parses its file
+		if r, ok := s.get(id); ok {
+			classes = modelClasses(r.Model)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("result %q not found", id)
+	}
+	switch fb.Verdict {
+	case "correct", "incorrect":
+		if fb.Detection == nil || *fb.Detection < 0 || *fb.Detection >= len(r.Detections) {
+			return fmt.Errorf("verdict %q requires a valid detection index", fb.Verdict)
+		}
+		fb.Class = r.Detections[*fb.Detection].ClassName
+	case "missed":
+		if fb.Class == "" {
+			return fmt.Errorf("verdict \"missed\" requires the class of the missed object")
+		}
+		// The class becomes a metric label, so only the model's own are taken
+		if !hasClassName(classes, fb.Class) {
+			return fmt.Errorf("model %q has no class %q", r.Model, fb.Class)
+		}
+		fb.Detection = nil
+	default:
+		return fmt.Errorf("unknown verdict %q (want correct, incorrect, or missed)", fb.Verdict)
+	}
+	fb.CreatedAt = time.Now().UTC()
+
+	updated := *r
+	updated.Feedback = append(append([]Feedback(nil), r.Feedback...), fb)
+	if err := s.writeLocked(&updated); err != nil {
+		return err
+	}
+	s.byID[id] = &updated
+	observeFeedback(r.Model, fb.Class, fb.Verdict)
+	if clusterOn() {
+		cluster.indexResult(&updated)
+	}
+	return nil
+}
+
+// resultsAPIHandler serves stored results and operator feedback, in the
+// result schema of the request's API version
+//
+//	GET  /api/v1/results                recent results, newest first;
+//	                                    ?label= keeps those with a detection under
+//	                                    a class or taxonomy label; replicas
+//	                                    sharing a queue list all their results
+//	GET  /api/v1/results/{id}           a single result
+//	GET  /api/v1/results/diff?a=&b=     objects added, removed and moved
+//	                                    between two results (see diff.go)
+//	POST /api/v1/results/{id}/feedback  record a verdict, one of:
+//
+//	{"detection": 0, "verdict": "correct"}
+//	{"detection": 2, "verdict": "incorrect"}
+//	{"class": "person", "verdict": "missed"}
+func resultsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/results"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		label := r.URL.Query().Get("label")
+		list := historyList(100, func(res InferenceResult) bool {
+			return label == "" || hasMatch(res, label)
+		})
+		if list == nil {
+			list = []InferenceResult{}
+		}
+		writeJSON(w, http.StatusOK, resultsForAPI(r, list))
+
+	case len(parts) == 1 && parts[0] == "diff" && r.Method == http.MethodGet:
+		resultsDiffHandler(w, r)
+
+	case len(parts) <= 2 && parts[0] != "" && forwardToOwner(w, r, parts[0]):
+		// Another replica ran the job and holds the result
+
+	case len(parts) == 1 && parts[0] != "" && r.Method == http.MethodGet:
+		res, ok := results.get(parts[0])
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Result not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, resultForAPI(r, res))
+
+	case len(parts) == 2 && parts[1] == "feedback" && r.Method == http.MethodPost:
+		var fb Feedback
+		if err := decodeJSON(w, r, &fb); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if err := results.addFeedback(parts[0], fb); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		res, _ := results.get(parts[0])
+		writeJSON(w, http.StatusOK, resultForAPI(r, res))
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The inference process runs sandboxed so a runaway model cannot take the
+// rest of the pod down with it:
+//
+//   - its working directory and HOME are INFER_WORK_DIR, so caches and
+//     downloads land there rather than next to the web UI
+//   - its environment is scrubbed: only PATH, the model settings and the
+//     variables named in INFER_ENV_PASSTHROUGH are passed on
+//   - INFER_MEMORY_MB caps its data segment (RLIMIT_DATA, which includes
+//     anonymous mappings) and core dumps are disabled
+//   - INFER_NICE lowers its CPU priority
+//   - with INFER_CGROUP, a delegated cgroup v2 directory, it is moved into that
+//     cgroup with memory.max from INFER_MEMORY_MB and cpu.max from INFER_CPUS
+//
+// It runs in its own process group and is killed when the web UI exits.
+
+// sandboxEnvAlways are passed to the inference process whatever INFER_ENV_PASSTHROUGH says
+var sandboxEnvAlways = []string{"PATH", "LANG", "TZ", "MODEL_DIR"}
+
+// sandboxCommand configures cmd to run in the inference sandbox
+func sandboxCommand(cmd *exec.Cmd) error {
+	dir := config().InferWorkDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create sandbox directory: %v", err)
+	}
+	cmd.Dir = dir
+	cmd.Env = sandboxEnv(dir)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	return nil
+}
+
+func sandboxEnv(home string) []string {
+	env := []string{"HOME=" + home, "PYTHONUNBUFFERED=1"}
+	names := append([]string{}, sandboxEnvAlways...)
+	names = append(names, strings.Split(config().InferEnvPassthrough, ",")...)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// confineProcess applies the resource limits to a started inference process
+func confineProcess(pid int) error {
+	if config().InferCgroup != "" {
+		if err := joinCgroup(config().InferCgroup, pid); err != nil {
+			return fmt.Errorf("cgroup %s: %v", config().InferCgroup, err)
+		}
+	}
+	if err := prlimit(pid, syscall.RLIMIT_CORE, 0); err != nil {
+		return err
+	}
+	if config().InferMemoryMB > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_DATA, uint64(config().InferMemoryMB)<<20); err != nil {
+			return fmt.Errorf("memory limit: %v", err)
+		}
+	}
+	if config().InferNice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, config().InferNice); err != nil {
+			return fmt.Errorf("nice: %v", err)
+		}
+	}
+	return nil
+}
+
+// joinCgroup writes the limits into a cgroup v2 directory and moves pid into it
+func joinCgroup(dir string, pid int) error {
+	if config().InferMemoryMB > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(int64(config().InferMemoryMB)<<20, 10)); err != nil {
+			return err
+		}
+		// Fail inside the sandbox rather than swapping the node to a halt
+		writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+	if config().InferCPUs > 0 {
+		const period = 100000
+		quota := strconv.Itoa(int(config().InferCPUs * period))
+		if err := writeCgroupFile(dir, "cpu.max", quota+" "+strconv.Itoa(period)); err != nil {
+			return err
+		}
+	}
+	return writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// prlimit sets both the soft and hard limit of resource for another process
+func prlimit(pid, resource int, value uint64) error {
+	limit := syscall.Rlimit{Cur: value, Max: value}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// killProcessGroup kills the sandboxed process and anything it started
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduledTask is a recurring background job driven by a cron expression
+type scheduledTask struct {
+	name        string
+	description string
+	schedule    *cronSchedule // nil when disabled
+	run         func() error
+
+	mu           sync.Mutex
+	running      bool
+	nextRun      time.Time
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastErr      error
+}
+
+// ScheduledTaskView is the API representation of a scheduled task
+type ScheduledTaskView struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Schedule       string     `json:"schedule,omitempty"`
+	Enabled        bool       `json:"enabled"`
+	Running        bool       `json:"running"`
+	NextRun        *time.Time `json:"next_run,omitempty"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMs int64      `json:"last_duration_ms,omitempty"`
+	LastStatus     string     `json:"last_status,omitempty"` // "success" or "error"
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type scheduler struct {
+	mu    sync.Mutex
+	tasks map[string]*scheduledTask
+}
+
+var tasks = &scheduler{tasks: map[string]*scheduledTask{}}
+
+var scheduledRuns = newCounterVec("yolo_scheduled_task_runs_total",
+	"Scheduled task runs by task and outcome.", "task", "status")
+
+// register adds a task; an empty cron expression registers it disabled, so it is
+// still listed in the API and can be run on demand
+func (s *scheduler) register(name, description, expr string, run func() error) {
+	t := &scheduledTask{name: name, description: description, run: run}
+	if expr != "" {
+		sched, err := parseCron(expr)
+		if err != nil {
+			log.Printf("Warning: task %s disabled: %v", name, err)
+		} else {
+			t.schedule = sched
+			t.nextRun = sched.next(time.Now())
+		}
+	}
+	s.mu.Lock()
+	s.tasks[name] = t
+	s.mu.Unlock()
+}
+
+// reschedule changes the cron expression of a registered task, keeping its
+// run history; an empty expression disables it
+func (s *scheduler) reschedule(name, expr string) error {
+	var sched *cronSchedule
+	if expr != "" {
+		var err error
+		if sched, err = parseCron(expr); err != nil {
+			return err
+		}
+	}
+	t := s.get(name)
+	if t == nil {
+		return fmt.Errorf("task %s not found", name)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.schedule, t.nextRun = sched, time.Time{}
+	if sched != nil {
+		t.nextRun = sched.next(time.Now())
+	}
+	return nil
+}
+
+// start runs the scheduling loop, checking for due tasks and applying the
+// camera sources' quiet hours every few seconds
+func (s *scheduler) start() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.mu.Lock()
+			due := make([]*scheduledTask, 0)
+			for _, t := range s.tasks {
+				t.mu.Lock()
+				if t.schedule != nil && !t.nextRun.IsZero() && !now.Before(t.nextRun) {
+					t.nextRun = t.schedule.next(now)
+					due = append(due, t)
+				}
+				t.mu.Unlock()
+			}
+			s.mu.Unlock()
+			for _, t := range due {
+				go t.execute()
+			}
+			sources.applySchedules(now)
+		}
+	}()
+}
+
+// execute runs the task unless a previous run is still in progress
+func (t *scheduledTask) execute() error {
+	t.mu.Lock()
+	if t.running {
+		t.mu.Unlock()
+		log.Printf("Scheduled task %s still running, skipping this run", t.name)
+		return fmt.Errorf("task %s is already running", t.name)
+	}
+	t.running = true
+	t.mu.Unlock()
+
+	start := time.Now()
+	log.Printf("Running scheduled task %s", t.name)
+	err := t.run()
+
+	t.mu.Lock()
+	t.running = false
+	t.lastRun = start
+	t.lastDuration = time.Since(start)
+	t.lastErr = err
+	t.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Warning: scheduled task %s failed: %v", t.name, err)
+		scheduledRuns.inc(t.name, "error")
+	} else {
+		scheduledRuns.inc(t.name, "success")
+	}
+	return err
+}
+
+func (t *scheduledTask) view() ScheduledTaskView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := ScheduledTaskView{
+		Name:        t.name,
+		Description: t.description,
+		Enabled:     t.schedule != nil,
+		Running:     t.running,
+	}
+	if t.schedule != nil {
+		v.Schedule = t.schedule.String()
+		if !t.nextRun.IsZero() {
+			next := t.nextRun
+			v.NextRun = &next
+		}
+	}
+	if !t.lastRun.IsZero() {
+		last := t.lastRun
+		v.LastRun = &last
+		v.LastDurationMs = t.lastDuration.Milliseconds()
+		v.LastStatus = "success"
+		if t.lastErr != nil {
+			v.LastStatus = "error"
+			v.LastError = t.lastErr.Error()
+		}
+	}
+	return v
+}
+
+func (s *scheduler) list() []ScheduledTaskView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduledTaskView, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		out = append(out, t.view())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *scheduler) get(name string) *scheduledTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tasks[name]
+}
+
+// registerBuiltinTasks wires up the standard recurring jobs from config
+func registerBuiltinTasks() {
+	tasks.register("batch-inference", "Run inference on new images in BATCH_DIR",
+		config().ScheduleBatchInference, runBatchInference)
+	tasks.register("retrain", "Trigger a training job when the node is online",
+		config().ScheduleRetrain, func() error {
+			_, err := triggerTraining("scheduled")
+			return err
+		})
+	tasks.register("retention", "Delete stored results and uploads older than RETENTION_DAYS, archiving their statistics",
+		config().ScheduleRetention, runRetentionSweep)
+	tasks.register("sync", "Upload new results to SYNC_URL when online",
+		config().ScheduleSync, syncResults)
+	tasks.register("fleet-config", "Pull the signed configuration bundle from FLEET_CONFIG_URL when online",
+		config().ScheduleFleetConfig, pullFleetConfig)
+	tasks.register("model-pull", "Pull the models of MODEL_OCI_REFS from their registries when online",
+		config().ScheduleModelPull, pullModels)
+	tasks.register("self-update", "Install a new binary from UPDATE_URL when online",
+		config().ScheduleSelfUpdate, runSelfUpdate)
+	tasks.register("daily-report", "Generate yesterday's report and email it to REPORT_EMAIL_TO",
+		config().ScheduleDailyReport, func() error { return runScheduledReport(reportDaily) })
+	tasks.register("weekly-report", "Generate the report of the last 7 days and email it to REPORT_EMAIL_TO",
+		config().ScheduleWeeklyReport, func() error { return runScheduledReport(reportWeekly) })
+	tasks.register("storage-gc", "Delete stored images and model blobs no result, recording or model references",
+		config().ScheduleStorageGC, runStorageGC)
+	tasks.register("reconcile", "Converge the node to the desired state of DESIRED_STATE_SOURCE",
+		config().ScheduleReconcile, reconcileDesiredState)
+}
+
+// builtinSchedules returns the cron expression of each built-in task in cfg
+func builtinSchedules(cfg *Config) map[string]string {
+	return map[string]string{
+		"batch-inference": cfg.ScheduleBatchInference,
+		"retrain":         cfg.ScheduleRetrain,
+		"retention":       cfg.ScheduleRetention,
+		"sync":            cfg.ScheduleSync,
+		"fleet-config":    cfg.ScheduleFleetConfig,
+		"model-pull":      cfg.ScheduleModelPull,
+		"self-update":     cfg.ScheduleSelfUpdate,
+		"daily-report":    cfg.ScheduleDailyReport,
+		"weekly-report":   cfg.ScheduleWeeklyReport,
+		"storage-gc":      cfg.ScheduleStorageGC,
+		"reconcile":       cfg.ScheduleReconcile,
+	}
+}
+
+// runBatchInference processes images in BATCH_DIR modified since the previous batch run
+func runBatchInference() error {
+	if config().BatchDir == "" {
+		return fmt.Errorf("BATCH_DIR is not configured")
+	}
+	if maintenanceOn() {
+		return fmt.Errorf("skipped: the node is in maintenance")
+	}
+	if lowPower(lowPowerBatch) {
+		return fmt.Errorf("skipped: the node is on battery")
+	}
+	statePath := filepath.Join(config().StateDir, "batch-state.json")
+	var state struct {
+		LastRun time.Time `json:"last_run"`
+	}
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	images, err := listEvalImages(config().BatchDir)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	processed, failed := 0, 0
+	for _, img := range images {
+		fi, err := os.Stat(img)
+		if err != nil || !fi.ModTime().After(state.LastRun) {
+			continue
+		}
+		result := processImage(img, "batch", false)
+		processed++
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	state.LastRun = started
+	data, _ := json.Marshal(state)
+	os.WriteFile(statePath, data, 0644)
+
+	log.Printf("Batch inference: %d images processed, %d failed", processed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, processed)
+	}
+	return nil
+}
+
+// runRetentionSweep deletes results and uploaded images past the retention
+// window, keeping the statistics of the results (see archive.go)
+func runRetentionSweep() error {
+	cutoff := time.Now().AddDate(0, 0, -config().RetentionDays)
+	deleted := results.deleteBefore(cutoff)
+	archiveResults(deleted)
+	if clusterOn() {
+		cluster.forgetResults(deleted)
+	}
+	pruneRecordings()
+
+	removed := 0
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		// Directories are left behind by upload jobs interrupted by a restart
+		if err := os.RemoveAll(filepath.Join(uploadDir, e.Name())); err == nil {
+			removed++
+		}
+	}
+	log.Printf("Retention sweep: deleted %d results and %d uploads older than %d days", len(deleted), removed, config().RetentionDays)
+	return nil
+}
+
+// schedulesHandler serves the scheduler API
+//
+//	GET  /api/v1/schedules             all tasks with schedules and next/last run times
+//	POST /api/v1/schedules/{name}/run  run a task now
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/schedules"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, tasks.list())
+
+	case len(parts) == 2 && parts[1] == "run" && r.Method == http.MethodPost:
+		t := tasks.get(parts[0])
+		if t == nil {
+			writeJSONError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		go t.execute()
+		writeJSON(w, http.StatusAccepted, t.view())
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,870 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"html/template"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Integrators at edge sites script against the node, so it serves client
+// libraries for its JSON API at /sdk/:
+//
+//	GET /sdk/                                   index with install instructions
+//	GET /sdk/openapi.json                       OpenAPI 3 description
+//	GET /sdk/go/yoloclient.zip                  Go module "yoloclient"
+//	GET /sdk/python/yolo_client-{version}-py3-none-any.whl
+//	GET /sdk/typescript/yolo-client.ts          types and a fetch client
+//
+// All of them are generated from sdkEndpoints and, by reflection, the Go
+// types the handlers encode, the first time they are asked for. They are
+// therefore rebuilt with every build of the binary and always match the node
+// they are downloaded from; their version is the build's. The gRPC-Web
+// service is described by inference.proto instead.
+
+// sdkEndpoint is one API call offered by the clients
+type sdkEndpoint struct {
+	Name     string // method name, camelCase
+	Summary  string
+	Method   string
+	Path     string       // {name} marks a path parameter
+	Query    []string     // optional query parameters
+	Body     reflect.Type // JSON request body, nil for none
+	Response reflect.Type
+	List     bool // the response is an array of Response
+	Status   int  // success status
+}
+
+var sdkEndpoints = []sdkEndpoint{
+	{Name: "inferURL", Summary: "Queue an image fetched by the node from a URL",
+		Method: http.MethodPost, Path: "/api/v1/infer/url",
+		Body: reflect.TypeOf(URLIngestRequest{}), Response: reflect.TypeOf(IngestJob{}), Status: http.StatusAccepted},
+	{Name: "inferBase64", Summary: "Queue a base64-encoded image",
+		Method: http.MethodPost, Path: "/api/v1/infer/base64",
+		Body: reflect.TypeOf(Base64IngestRequest{}), Response: reflect.TypeOf(IngestJob{}), Status: http.StatusAccepted},
+	{Name: "listResults", Summary: "Recent results, newest first",
+		Method: http.MethodGet, Path: "/api/v1/results", Query: []string{"label"},
+		Response: reflect.TypeOf(InferenceResult{}), List: true, Status: http.StatusOK},
+	{Name: "getResult", Summary: "A stored result",
+		Method: http.MethodGet, Path: "/api/v1/results/{id}",
+		Response: reflect.TypeOf(InferenceResult{}), Status: http.StatusOK},
+	{Name: "addFeedback", Summary: "Record a verdict on a result",
+		Method: http.MethodPost, Path: "/api/v1/results/{id}/feedback",
+		Body: reflect.TypeOf(Feedback{}), Response: reflect.TypeOf(InferenceResult{}), Status: http.StatusOK},
+	{Name: "getStats", Summary: "Detection counts since a time",
+		Method: http.MethodGet, Path: "/api/v1/stats", Query: []string{"since", "source", "label"},
+		Response: reflect.TypeOf(DetectionStats{}), Status: http.StatusOK},
+	{Name: "getVersion", Summary: "The node's build",
+		Method: http.MethodGet, Path: "/api/v1/version",
+		Response: reflect.TypeOf(BuildInfo{}), Status: http.StatusOK},
+}
+
+// sdkFile is a generated artifact
+type sdkFile struct {
+	contentType string
+	data        []byte
+}
+
+var sdk struct {
+	once      sync.Once
+	files     map[string]sdkFile // by path under /sdk/
+	wheel     string             // file name of the Python wheel
+	generated time.Time
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	sdkPathVars = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// sdkField is a JSON field of a struct
+type sdkField struct {
+	Name     string // JSON name
+	GoName   string
+	Type     reflect.Type
+	Optional bool
+}
+
+// sdkFields lists the fields encoding/json writes for t, with embedded
+// structs flattened
+func sdkFields(t reflect.Type) []sdkField {
+	var out []sdkField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag == "" {
+			out = append(out, sdkFields(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, sdkField{
+			Name:     name,
+			GoName:   f.Name,
+			Type:     f.Type,
+			Optional: strings.Contains(opts, "omitempty") || f.Type.Kind() == reflect.Ptr,
+		})
+	}
+	return out
+}
+
+// sdkStructs lists the structs the endpoints use, each after the structs
+// its fields use
+func sdkStructs() []reflect.Type {
+	seen := map[reflect.Type]bool{}
+	var out []reflect.Type
+	var visit func(reflect.Type)
+	visit = func(t reflect.Type) {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			visit(t.Elem())
+		case reflect.Struct:
+			if t == timeType || seen[t] {
+				return
+			}
+			seen[t] = true
+			for _, f := range sdkFields(t) {
+				visit(f.Type)
+			}
+			out = append(out, t)
+		}
+	}
+	for _, e := range sdkEndpoints {
+		if e.Body != nil {
+			visit(e.Body)
+		}
+		visit(e.Response)
+	}
+	return out
+}
+
+// sdkPathParams lists the path parameters of an endpoint
+func sdkPathParams(path string) []string {
+	var out []string
+	for _, m := range sdkPathVars.FindAllStringSubmatch(path, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+// sdkPathExpr writes path as an expression of its parameters, each wrapped
+// by escape, in a language that joins strings with +
+func sdkPathExpr(path string, escape func(string) string) string {
+	var parts []string
+	for {
+		loc := sdkPathVars.FindStringSubmatchIndex(path)
+		if loc == nil {
+			break
+		}
+		if loc[0] > 0 {
+			parts = append(parts, fmt.Sprintf("%q", path[:loc[0]]))
+		}
+		parts = append(parts, escape(path[loc[2]:loc[3]]))
+		path = path[loc[1]:]
+	}
+	if path != "" {
+		parts = append(parts, fmt.Sprintf("%q", path))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// sdkVersion is the build version as a PEP 440 version for the wheel
+func sdkVersion() string {
+	v := strings.TrimPrefix(buildVersion, "v")
+	if regexp.MustCompile(`^\d+(\.\d+)*$`).MatchString(v) {
+		return v
+	}
+	local := regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(v, ".")
+	return "0.0.0+" + strings.Trim(local, ".")
+}
+
+// generateSDK builds every artifact
+func generateSDK() {
+	sdk.generated = time.Now()
+	sdk.files = map[string]sdkFile{}
+	if data, err := json.MarshalIndent(sdkOpenAPI(), "", "  "); err == nil {
+		sdk.files["openapi.json"] = sdkFile{"application/json", data}
+	}
+	sdk.files["typescript/yolo-client.ts"] = sdkFile{"text/plain; charset=utf-8", []byte(sdkTypeScript())}
+	if data, err := sdkGoModule(); err == nil {
+		sdk.files["go/yoloclient.zip"] = sdkFile{"application/zip", data}
+	} else {
+		log.Printf("Warning: failed to generate the Go client: %v", err)
+	}
+	sdk.wheel = "yolo_client-" + sdkVersion() + "-py3-none-any.whl"
+	if data, err := sdkPythonWheel(); err == nil {
+		sdk.files["python/"+sdk.wheel] = sdkFile{"application/zip", data}
+	} else {
+		log.Printf("Warning: failed to generate the Python client: %v", err)
+	}
+}
+
+// OpenAPI
+
+func sdkSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sdkSchema(t.Elem())
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": sdkSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": sdkSchema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "integer"}
+	}
+}
+
+func sdkOpenAPI() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"error": map[string]string{"type": "string"}},
+		},
+	}
+	for _, t := range sdkStructs() {
+		props := map[string]interface{}{}
+		required := []string{}
+		for _, f := range sdkFields(t) {
+			props[f.Name] = sdkSchema(f.Type)
+			if !f.Optional {
+				required = append(required, f.Name)
+			}
+		}
+		schemas[t.Name()] = map[string]interface{}{"type": "object", "properties": props, "required": required}
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, e := range sdkEndpoints {
+		params := []interface{}{}
+		for _, p := range sdkPathParams(e.Path) {
+			params = append(params, map[string]interface{}{
+				"name": p, "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+			})
+		}
+		for _, q := range e.Query {
+			params = append(params, map[string]interface{}{
+				"name": q, "in": "query", "schema": map[string]string{"type": "string"},
+			})
+		}
+		response := sdkSchema(e.Response)
+		if e.List {
+			response = map[string]interface{}{"type": "array", "items": response}
+		}
+		op := map[string]interface{}{
+			"operationId": e.Name,
+			"summary":     e.Summary,
+			"parameters":  params,
+			"responses": map[string]interface{}{
+				fmt.Sprint(e.Status): map[string]interface{}{
+					"description": http.StatusText(e.Status),
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": response}},
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{"application/json": map[string]interface{}{
+						"schema": map[string]string{"$ref": "#/components/schemas/Error"},
+					}},
+				},
+			},
+		}
+		if e.Body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": sdkSchema(e.Body)}},
+			}
+		}
+		if paths[e.Path] == nil {
+			paths[e.Path] = map[string]interface{}{}
+		}
+		paths[e.Path][strings.ToLower(e.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Edge inference node API",
+			"version": buildVersion,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// TypeScript
+
+func sdkTSType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sdkTSType(t.Elem())
+	case reflect.Slice:
+		return sdkTSType(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Record<string, " + sdkTSType(t.Elem()) + ">"
+	case reflect.Interface:
+		return "unknown"
+	case reflect.Struct:
+		if t == timeType {
+			return "string"
+		}
+		return t.Name()
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+const sdkTSClient = `
+export class YoloError extends Error {
+  status: number;
+
+  constructor(status: number, message: string) {
+    super(message);
+    this.status = status;
+  }
+}
+
+// YoloClient calls one node; init is merged into every fetch, e.g. for headers
+export class YoloClient {
+  baseURL: string;
+  init: RequestInit;
+
+  constructor(baseURL = "", init: RequestInit = {}) {
+    this.baseURL = baseURL;
+    this.init = init;
+  }
+
+  private async call<T>(method: string, path: string, query?: Record<string, string | undefined>, body?: unknown): Promise<T> {
+    const params = new URLSearchParams();
+    for (const [k, v] of Object.entries(query ?? {})) {
+      if (v !== undefined) params.set(k, v);
+    }
+    const qs = params.toString();
+    const resp = await fetch(this.baseURL.replace(/\/$/, "") + path + (qs ? "?" + qs : ""), {
+      ...this.init,
+      method,
+      headers: {
+        ...(this.init.headers as Record<string, string> | undefined),
+        ...(body === undefined ? {} : { "Content-Type": "application/json" }),
+      },
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const data = await resp.json().catch(() => ({}));
+    if (!resp.ok) throw new YoloError(resp.status, data.error ?? resp.statusText);
+    return data as T;
+  }
+
+  // waitResult polls for the result of a queued image until it is stored
+  async waitResult(jobID: string, timeoutMs = 60000): Promise<InferenceResult> {
+    const deadline = Date.now() + timeoutMs;
+    for (;;) {
+      try {
+        return await this.getResult(jobID);
+      } catch (err) {
+        if (!(err instanceof YoloError) || err.status !== 404 || Date.now() > deadline) throw err;
+      }
+      await new Promise((resolve) => setTimeout(resolve, 500));
+    }
+  }
+`
+
+func sdkTypeScript() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Client for the edge inference node's JSON API, version %s.\n", buildVersion)
+	b.WriteString("// Generated by the node from its own types; download it again after upgrading.\n\n")
+	for _, t := range sdkStructs() {
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+		for _, f := range sdkFields(t) {
+			opt := ""
+			if f.Optional {
+				opt = "?"
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.Name, opt, sdkTSType(f.Type))
+		}
+		b.WriteString("}\n\n")
+	}
+	b.WriteString(strings.TrimPrefix(sdkTSClient, "\n"))
+	for _, e := range sdkEndpoints {
+		var args []string
+		for _, p := range sdkPathParams(e.Path) {
+			args = append(args, p+": string")
+		}
+		if e.Body != nil {
+			args = append(args, "body: "+e.Body.Name())
+		}
+		rest := ""
+		if len(e.Query) > 0 {
+			args = append(args, "query: { "+strings.Join(e.Query, "?: string; ")+"?: string } = {}")
+			rest = ", query"
+		}
+		if e.Body != nil {
+			rest = ", undefined, body"
+		}
+		result := sdkTSType(e.Response)
+		if e.List {
+			result += "[]"
+		}
+		fmt.Fprintf(&b, "\n  // %s\n  %s(%s): Promise<%s> {\n    return this.call(%q, %s%s);\n  }\n",
+			e.Summary, e.Name, strings.Join(args, ", "), result, e.Method,
+			sdkPathExpr(e.Path, func(p string) string { return "encodeURIComponent(" + p + ")" }), rest)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Go
+
+func sdkGoType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + sdkGoType(t.Elem())
+	case reflect.Slice:
+		return "[]" + sdkGoType(t.Elem())
+	case reflect.Map:
+		return "map[string]" + sdkGoType(t.Elem())
+	case reflect.Interface:
+		return "interface{}"
+	case reflect.Struct:
+		if t == timeType {
+			return "time.Time"
+		}
+		return t.Name()
+	default:
+		return t.Kind().String()
+	}
+}
+
+const sdkGoClient = `
+// Client calls one node
+type Client struct {
+	BaseURL    string // e.g. http://node:8080
+	HTTPClient *http.Client
+	Header     http.Header // added to every request
+}
+
+// New returns a client of the node at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient, Header: http.Header{}}
+}
+
+// Error is an answer other than success
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string ` + "`json:\"error\"`" + `
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = resp.Status
+		}
+		return &Error{Status: resp.StatusCode, Message: e.Error}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// WaitResult polls for the result of a queued image until it is stored or ctx ends
+func (c *Client) WaitResult(ctx context.Context, jobID string) (*InferenceResult, error) {
+	for {
+		res, err := c.GetResult(ctx, jobID)
+		if e, ok := err.(*Error); !ok || e.Status != http.StatusNotFound {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+`
+
+func sdkGoSource() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Package yoloclient is a client for the edge inference node's JSON API,\n")
+	fmt.Fprintf(&b, "// version %s. It is generated by the node from its own types.\n", buildVersion)
+	b.WriteString("package yoloclient\n\nimport (\n\t\"bytes\"\n\t\"context\"\n\t\"encoding/json\"\n\t\"fmt\"\n\t\"io\"\n" +
+		"\t\"net/http\"\n\t\"net/url\"\n\t\"strings\"\n\t\"time\"\n)\n")
+	for _, t := range sdkStructs() {
+		fmt.Fprintf(&b, "\ntype %s struct {\n", t.Name())
+		for _, f := range sdkFields(t) {
+			opt := ""
+			if f.Optional {
+				opt = ",omitempty"
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:\"%s%s\"`\n", f.GoName, sdkGoType(f.Type), f.Name, opt)
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString(sdkGoClient)
+	for _, e := range sdkEndpoints {
+		args := []string{"ctx context.Context"}
+		for _, p := range sdkPathParams(e.Path) {
+			args = append(args, p+" string")
+		}
+		body, query := "nil", "nil"
+		if e.Body != nil {
+			args = append(args, "body "+e.Body.Name())
+			body = "body"
+		}
+		if len(e.Query) > 0 {
+			args = append(args, "query url.Values")
+			query = "query"
+		}
+		result, ret := "*"+sdkGoType(e.Response), "&out"
+		if e.List {
+			result, ret = "[]"+sdkGoType(e.Response), "out"
+		}
+		name := strings.ToUpper(e.Name[:1]) + e.Name[1:]
+		doc := e.Summary
+		if len(e.Query) > 0 {
+			doc += "; query takes " + strings.Join(e.Query, ", ")
+		}
+		fmt.Fprintf(&b, "\n// %s: %s\nfunc (c *Client) %s(%s) (%s, error) {\n\tvar out %s\n\terr := c.do(ctx, %q, %s, %s, %s, &out)\n\treturn %s, err\n}\n",
+			name, doc, name, strings.Join(args, ", "), result, strings.TrimPrefix(result, "*"), e.Method,
+			sdkPathExpr(e.Path, func(p string) string { return "url.PathEscape(" + p + ")" }), query, body, ret)
+	}
+	return format.Source(b.Bytes())
+}
+
+func sdkGoModule() ([]byte, error) {
+	src, err := sdkGoSource()
+	if err != nil {
+		return nil, err
+	}
+	return sdkZip([][2]string{
+		{"yoloclient/go.mod", "module yoloclient\n\ngo 1.21\n"},
+		{"yoloclient/client.go", string(src)},
+	})
+}
+
+// Python
+
+func sdkPyType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sdkPyType(t.Elem())
+	case reflect.Slice:
+		return "List[" + sdkPyType(t.Elem()) + "]"
+	case reflect.Map:
+		return "Dict[str, " + sdkPyType(t.Elem()) + "]"
+	case reflect.Interface:
+		return "Any"
+	case reflect.Struct:
+		if t == timeType {
+			return "str"
+		}
+		return `"` + t.Name() + `"` // a forward reference, since structs can nest themselves
+	case reflect.String:
+		return "str"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	default:
+		return "int"
+	}
+}
+
+// sdkSnake converts a camelCase name, keeping acronyms together
+func sdkSnake(name string) string {
+	return strings.ToLower(regexp.MustCompile(`([a-z0-9])([A-Z])`).ReplaceAllString(name, "${1}_${2}"))
+}
+
+const sdkPyClient = `
+
+class Error(Exception):
+    def __init__(self, status: int, message: str):
+        super().__init__("%d %s" % (status, message))
+        self.status = status
+        self.message = message
+
+
+class Client:
+    """Calls one node, e.g. Client("http://node:8080")."""
+
+    def __init__(self, base_url: str, headers: Optional[Dict[str, str]] = None, timeout: float = 30):
+        self.base_url = base_url.rstrip("/")
+        self.headers = dict(headers or {})
+        self.timeout = timeout
+
+    def _call(self, method: str, path: str, query: Optional[Dict[str, Optional[str]]] = None, body: Any = None) -> Any:
+        q = {k: v for k, v in (query or {}).items() if v is not None}
+        url = self.base_url + path + ("?" + urllib.parse.urlencode(q) if q else "")
+        headers = dict(self.headers)
+        data = None
+        if body is not None:
+            data = json.dumps(body).encode()
+            headers["Content-Type"] = "application/json"
+        req = urllib.request.Request(url, data=data, method=method, headers=headers)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                return json.load(resp)
+        except urllib.error.HTTPError as e:
+            try:
+                message = json.load(e).get("error") or e.reason
+            except ValueError:
+                message = e.reason
+            raise Error(e.code, message) from None
+
+    def wait_result(self, job_id: str, timeout: float = 60) -> "InferenceResult":
+        """Polls for the result of a queued image until it is stored."""
+        deadline = time.monotonic() + timeout
+        while True:
+            try:
+                return self.get_result(job_id)
+            except Error as e:
+                if e.status != 404 or time.monotonic() > deadline:
+                    raise
+            time.sleep(0.5)
+`
+
+func sdkPythonSource() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\"\"\"Client for the edge inference node's JSON API, version %s.\n\n", buildVersion)
+	b.WriteString("Generated by the node from its own types; download it again after upgrading.\n\"\"\"\n\n")
+	b.WriteString("import json\nimport time\nimport urllib.error\nimport urllib.parse\nimport urllib.request\n" +
+		"from typing import Any, Dict, List, Optional, TypedDict\n")
+	// The functional form allows field names that are Python keywords, like "class"
+	for _, t := range sdkStructs() {
+		fmt.Fprintf(&b, "\n%s = TypedDict(\"%s\", {\n", t.Name(), t.Name())
+		for _, f := range sdkFields(t) {
+			fmt.Fprintf(&b, "    %q: %s,\n", f.Name, sdkPyType(f.Type))
+		}
+		b.WriteString("}, total=False)\n")
+	}
+	b.WriteString(sdkPyClient)
+	for _, e := range sdkEndpoints {
+		args := []string{"self"}
+		for _, p := range sdkPathParams(e.Path) {
+			args = append(args, p+": str")
+		}
+		if e.Body != nil {
+			args = append(args, "body: "+sdkPyType(e.Body))
+		}
+		call := fmt.Sprintf("%q, %s", e.Method, sdkPathExpr(e.Path, func(p string) string {
+			return "urllib.parse.quote(" + p + ", safe=\"\")"
+		}))
+		if len(e.Query) > 0 {
+			var q []string
+			for _, name := range e.Query {
+				args = append(args, name+": Optional[str] = None")
+				q = append(q, fmt.Sprintf("%q: %s", name, name))
+			}
+			call += ", {" + strings.Join(q, ", ") + "}"
+		} else if e.Body != nil {
+			call += ", None"
+		}
+		if e.Body != nil {
+			call += ", body"
+		}
+		result := sdkPyType(e.Response)
+		if e.List {
+			result = "List[" + result + "]"
+		}
+		fmt.Fprintf(&b, "\n    def %s(%s) -> %s:\n        \"\"\"%s.\"\"\"\n        return self._call(%s)\n",
+			sdkSnake(e.Name), strings.Join(args, ", "), result, e.Summary, call)
+	}
+	return b.String()
+}
+
+// sdkPythonWheel packages the client as a pure-Python wheel
+func sdkPythonWheel() ([]byte, error) {
+	version := sdkVersion()
+	info := "yolo_client-" + version + ".dist-info/"
+	files := [][2]string{
+		{"yolo_client/__init__.py", sdkPythonSource()},
+		{info + "METADATA", "Metadata-Version: 2.1\nName: yolo-client\nVersion: " + version +
+			"\nSummary: Client for the edge inference node's JSON API\nRequires-Python: >=3.8\n"},
+		{info + "WHEEL", "Wheel-Version: 1.0\nGenerator: webui " + buildVersion + "\nRoot-Is-Purelib: true\nTag: py3-none-any\n"},
+	}
+	var record strings.Builder
+	for _, f := range files {
+		sum := sha256.Sum256([]byte(f[1]))
+		fmt.Fprintf(&record, "%s,sha256=%s,%d\n", f[0], base64.RawURLEncoding.EncodeToString(sum[:]), len(f[1]))
+	}
+	record.WriteString(info + "RECORD,,\n")
+	return sdkZip(append(files, [2]string{info + "RECORD", record.String()}))
+}
+
+// sdkZip archives name, content pairs
+func sdkZip(files [][2]string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f[0], Method: zip.Deflate, Modified: sdk.generated})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f[1])); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sdkHandler serves /sdk/ and the generated files
+func sdkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	sdk.once.Do(generateSDK)
+	name := strings.TrimPrefix(r.URL.Path, "/sdk/")
+	if name == "" {
+		sdkIndexHandler(w, r)
+		return
+	}
+	f, ok := sdk.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".ts") {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name[strings.LastIndex(name, "/")+1:]+`"`)
+	}
+	http.ServeContent(w, r, name, sdk.generated, bytes.NewReader(f.data))
+}
+
+func sdkIndexHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Client SDKs"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 900px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        pre {
+            background: #f0f0f0;
+            padding: 10px;
+            border-radius: 4px;
+            overflow-x: auto;
+        }
+    </style>
+</head>
+<body>
+    <h1>{{t "Client SDKs"}}</h1>
+    <p>{{t "Generated from this node's API, version %s. Every build regenerates them, so download them again after an upgrade." .Version}}</p>
+    <div class="panel">
+        <h2>Python</h2>
+        <pre>pip install {{.Base}}/sdk/python/{{.Wheel}}</pre>
+        <pre>from yolo_client import Client
+c = Client("{{.Base}}")
+job = c.infer_url({"url": "https://example.com/cam.jpg"})
+print(c.wait_result(job["job_id"])["detections"])</pre>
+    </div>
+    <div class="panel">
+        <h2>Go</h2>
+        <pre>curl -O {{.Base}}/sdk/go/yoloclient.zip && unzip yoloclient.zip
+go mod edit -require yoloclient@v0.0.0 -replace yoloclient=./yoloclient</pre>
+    </div>
+    <div class="panel">
+        <h2>TypeScript</h2>
+        <pre>curl -O {{.Base}}/sdk/typescript/yolo-client.ts</pre>
+    </div>
+    <div class="panel">
+        <h2>OpenAPI</h2>
+        <p><a href="{{base}}/sdk/openapi.json">openapi.json</a> · {{t "for other languages and API tools"}}</p>
+    </div>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("sdk").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	base := strings.TrimSuffix(permalink(r, ""), "/results/")
+	t.Execute(w, map[string]string{"Version": buildVersion, "Wheel": sdk.wheel, "Base": base})
+}
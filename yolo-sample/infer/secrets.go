@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The credentials in secretSettings can be given as a reference to a
+// secrets provider instead of as a value:
+//
+//	SMTP_PASSWORD=file:/var/run/secrets/smtp/password   a file, such as a mounted Kubernetes Secret
+//	SMTP_PASSWORD=env:RELAY_PASSWORD                    another environment variable
+//	MQTT_PASSWORD=k8s:edge-mqtt#password                a key of a Kubernetes Secret in POD_NAMESPACE,
+//	MQTT_PASSWORD=k8s:infra/edge-mqtt#password          or in another namespace (read with kubectl)
+//	ADMIN_TOKEN=vault:secret/data/edge#admin_token      a field of a HashiCorp Vault secret (KV v1 or v2)
+//
+// Vault is reached at VAULT_ADDR (in VAULT_NAMESPACE, if set) with
+// VAULT_TOKEN or the token in VAULT_TOKEN_FILE, or else by logging in with
+// the pod's service account token as VAULT_ROLE of the Kubernetes auth
+// method mounted at VAULT_AUTH_PATH.
+//
+// Resolved values are cached and fetched again every
+// SECRETS_REFRESH_INTERVAL (default 5m); when one has been rotated, the
+// configuration is reloaded (see reload.go) so the new credential is used
+// from then on, and the MQTT connection is remade with it. A reference that
+// cannot be resolved fails a reload, keeping the previous configuration.
+//
+// Secrets never leave the node through it: /api/v1/config and the
+// diagnostics bundle show them as "xxxxx", and any log line containing the
+// value of one has it replaced before it is written or kept.
+
+// secretSettings are the settings holding credentials
+var secretSettings = []struct{ field, key string }{
+	{"AdminToken", "ADMIN_TOKEN"},
+	{"SMTPPassword", "SMTP_PASSWORD"},
+	{"MQTTUsername", "MQTT_USERNAME"},
+	{"MQTTPassword", "MQTT_PASSWORD"},
+	{"EventWebhookSecret", "EVENT_WEBHOOK_SECRET"},
+	{"TrainingCallbackSecret", "TRAINING_CALLBACK_SECRET"},
+}
+
+// secretProviders are the prefixes of secret references
+var secretProviders = []string{"file", "env", "k8s", "vault"}
+
+// minScrubbedSecret is the shortest value replaced in log lines; shorter
+// ones would garble unrelated text
+const minScrubbedSecret = 6
+
+// secretSource is where a reference is resolved: the provider settings in
+// effect when it was first read
+type secretSource struct {
+	namespace      string // of k8s references without one
+	vaultAddr      string
+	vaultNamespace string
+	vaultToken     string
+	vaultTokenFile string
+	vaultRole      string
+	vaultAuthPath  string
+}
+
+type cachedSecret struct {
+	value  string
+	source secretSource
+}
+
+var secrets = struct {
+	sync.Mutex
+	byRef      map[string]cachedSecret
+	vaultToken string // from a Kubernetes login
+	vaultUntil time.Time
+}{byRef: map[string]cachedSecret{}}
+
+var secretFetches = newCounterVec("yolo_secret_fetches_total",
+	"Secret references resolved, by provider and outcome.", "provider", "status")
+
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// isSecretRef reports whether a setting's value is a secret reference
+func isSecretRef(v string) bool {
+	provider, _, ok := strings.Cut(v, ":")
+	return ok && contains(secretProviders, provider)
+}
+
+// getSecret reads a setting that may be a secret reference
+func (s *settings) getSecret(key string) string {
+	v := s.lookup(key)
+	if !isSecretRef(v) {
+		return v
+	}
+	secrets.Lock()
+	cached, ok := secrets.byRef[v]
+	secrets.Unlock()
+	if ok {
+		return cached.value
+	}
+	src := secretSource{
+		namespace:      s.lookup("POD_NAMESPACE"),
+		vaultAddr:      strings.TrimSuffix(s.lookup("VAULT_ADDR"), "/"),
+		vaultNamespace: s.lookup("VAULT_NAMESPACE"),
+		vaultToken:     os.Getenv("VAULT_TOKEN"),
+		vaultTokenFile: s.lookup("VAULT_TOKEN_FILE"),
+		vaultRole:      s.lookup("VAULT_ROLE"),
+		vaultAuthPath:  s.getEnv("VAULT_AUTH_PATH", "kubernetes"),
+	}
+	value, err := fetchSecret(v, src)
+	if err != nil {
+		s.invalid = append(s.invalid, key)
+		log.Printf("Warning: cannot resolve %s from %s: %v", key, v, err)
+		return ""
+	}
+	secrets.Lock()
+	secrets.byRef[v] = cachedSecret{value, src}
+	secrets.Unlock()
+	return value
+}
+
+// fetchSecret resolves a secret reference
+func fetchSecret(ref string, src secretSource) (string, error) {
+	provider, path, _ := strings.Cut(ref, ":")
+	var value string
+	var err error
+	switch provider {
+	case "file":
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+	case "env":
+		var ok bool
+		if value, ok = os.LookupEnv(path); !ok {
+			err = fmt.Errorf("%s is not set", path)
+		}
+	case "k8s":
+		value, err = kubernetesSecret(path, src.namespace)
+	case "vault":
+		value, err = vaultSecret(path, src)
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	secretFetches.inc(provider, status)
+	return value, err
+}
+
+// kubernetesSecret reads "[namespace/]name#key" with kubectl
+func kubernetesSecret(ref, namespace string) (string, error) {
+	name, key, ok := strings.Cut(ref, "#")
+	if !ok || key == "" {
+		return "", errors.New("want k8s:[namespace/]name#key")
+	}
+	if ns, n, ok := strings.Cut(name, "/"); ok {
+		namespace, name = ns, n
+	}
+	// Dots in keys such as tls.crt are escaped in JSONPath
+	args := []string{"get", "secret", name, "-o", "jsonpath={.data." + strings.ReplaceAll(key, ".", `\.`) + "}"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("kubectl: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return "", fmt.Errorf("secret %s has no key %s", name, key)
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %v", name, err)
+	}
+	return string(value), nil
+}
+
+// vaultSecret reads "path#field" from Vault
+func vaultSecret(ref string, src secretSource) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", errors.New("want vault:path#field")
+	}
+	if src.vaultAddr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token, err := vaultLogin(src)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultRequest(src, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), token, nil, &resp); err != nil {
+		return "", err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret under data, next to its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%s has no field %s", path, field)
+	}
+	return value, nil
+}
+
+// vaultLogin returns a Vault token: VAULT_TOKEN, the one in
+// VAULT_TOKEN_FILE, or one from a Kubernetes auth login
+func vaultLogin(src secretSource) (string, error) {
+	if src.vaultToken != "" {
+		return src.vaultToken, nil
+	}
+	if src.vaultTokenFile != "" {
+		data, err := os.ReadFile(src.vaultTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN_FILE: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if src.vaultRole == "" {
+		return "", errors.New("set VAULT_TOKEN, VAULT_TOKEN_FILE or VAULT_ROLE")
+	}
+	secrets.Lock()
+	token, until := secrets.vaultToken, secrets.vaultUntil
+	secrets.Unlock()
+	if token != "" && time.Now().Before(until) {
+		return token, nil
+	}
+	jwt, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+	if err != nil {
+		return "", fmt.Errorf("no service account token to log in to Vault: %v", err)
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": src.vaultRole, "jwt": strings.TrimSpace(string(jwt))}
+	if err := vaultRequest(src, http.MethodPost, "/v1/auth/"+strings.Trim(src.vaultAuthPath, "/")+"/login", "", body, &resp); err != nil {
+		return "", fmt.Errorf("Vault login: %v", err)
+	}
+	// Log in again well before the token expires
+	lease := time.Duration(resp.Auth.LeaseDuration) * time.Second / 2
+	secrets.Lock()
+	secrets.vaultToken, secrets.vaultUntil = resp.Auth.ClientToken, time.Now().Add(lease)
+	secrets.Unlock()
+	return resp.Auth.ClientToken, nil
+}
+
+func vaultRequest(src secretSource, method, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, src.vaultAddr+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if src.vaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", src.vaultNamespace)
+	}
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Vault's error bodies name the problem, never the secret
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// startSecretRefresh fetches the cached secrets again every
+// SECRETS_REFRESH_INTERVAL, reloading the configuration when one changed
+func startSecretRefresh() {
+	interval := config().SecretsRefreshInterval
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			secrets.Lock()
+			cached := make(map[string]cachedSecret, len(secrets.byRef))
+			for ref, c := range secrets.byRef {
+				cached[ref] = c
+			}
+			secrets.Unlock()
+
+			var rotated []string
+			for ref, c := range cached {
+				value, err := fetchSecret(ref, c.source)
+				if err != nil {
+					log.Printf("Warning: cannot refresh secret %s, keeping the current value: %v", ref, err)
+					continue
+				}
+				if value != c.value {
+					secrets.Lock()
+					secrets.byRef[ref] = cachedSecret{value, c.source}
+					secrets.Unlock()
+					rotated = append(rotated, ref)
+				}
+			}
+			if len(rotated) > 0 {
+				log.Printf("Secrets rotated: %s", strings.Join(rotated, ", "))
+				reloader.reload("secrets")
+			}
+		}
+	}()
+}
+
+// redactSecrets replaces the credentials of cfg with "xxxxx"
+func redactSecrets(cfg *Config) {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, s := range secretSettings {
+		if f := v.FieldByName(s.field); f.String() != "" {
+			f.SetString("xxxxx")
+		}
+	}
+}
+
+// secretValues returns the credentials in use, longest first so that a
+// secret containing another is replaced whole
+func secretValues() []string {
+	var out []string
+	cfg := reflect.ValueOf(config()).Elem()
+	for _, s := range secretSettings {
+		out = append(out, cfg.FieldByName(s.field).String())
+	}
+	for _, u := range []string{config().QueueURL, config().CacheURL} {
+		if c, err := newRedisClient(u); err == nil {
+			out = append(out, c.password)
+		}
+	}
+	out = append(out, os.Getenv("VAULT_TOKEN"))
+	secrets.Lock()
+	for _, c := range secrets.byRef {
+		out = append(out, c.value)
+	}
+	out = append(out, secrets.vaultToken)
+	secrets.Unlock()
+	keep := out[:0]
+	for _, v := range out {
+		if len(v) >= minScrubbedSecret {
+			keep = append(keep, v)
+		}
+	}
+	sort.Slice(keep, func(i, j int) bool { return len(keep[i]) > len(keep[j]) })
+	return keep
+}
+
+// secretScrubber removes credentials from the log output written to w
+type secretScrubber struct {
+	w io.Writer
+}
+
+func (s secretScrubber) Write(p []byte) (int, error) {
+	line := p
+	for _, v := range secretValues() {
+		line = bytes.ReplaceAll(line, []byte(v), []byte("xxxxx"))
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A browser opening a page of the UI or the admin page gets a session: the
+// yolo_session cookie carries a random session ID, and the session itself
+// is kept in the key-value store (see kvstore.go) under a hash of the ID for
+// SESSION_TTL (default 12h), extended while the browser keeps using it. The
+// cookie is HttpOnly and SameSite=Lax, and Secure on TLS connections or
+// always with SECURE_COOKIES=true, for nodes behind a TLS-terminating proxy.
+//
+// Each session has a CSRF token, which the pages put in their forms (the
+// csrf_token field) and scripts send as X-CSRF-Token. A POST, PUT, PATCH or
+// DELETE must carry it when it comes with the session cookie or is a form
+// submitted by a browser; others are refused with 403. API clients that send
+// no cookies (curl, the SDK, other services) are not affected.
+//
+// Signing in at /admin/login replaces the session with a new one marked
+// admin, so an ID planted before sign-in is worthless after. An admin
+// session remembers which ADMIN_TOKEN it was started with and ends when the
+// token is rotated.
+
+// sessionCookie carries the session ID
+const sessionCookie = "yolo_session"
+
+// csrfHeader carries the CSRF token of requests made by scripts
+const csrfHeader = "X-CSRF-Token"
+
+// session is the stored state of a browser
+type session struct {
+	Admin       bool      `json:"admin,omitempty"`
+	Token       string    `json:"token,omitempty"` // hash of the ADMIN_TOKEN signed in with
+	CSRF        string    `json:"csrf"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// sessionKeyCtx keys the session withSession found in a request's context
+type sessionKeyCtx struct{}
+
+var csrfRejected = newCounterVec("yolo_csrf_rejected_total",
+	"State-changing browser requests refused for a missing or wrong CSRF token.")
+
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "yolo:session:" + hex.EncodeToString(sum[:])
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte("yolo-session\x00" + token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// saveSession stores s under id and sets its cookie on w
+func saveSession(w http.ResponseWriter, r *http.Request, id string, s session) error {
+	s.RefreshedAt = time.Now().UTC()
+	data, _ := json.Marshal(s)
+	ttl := config().SessionTTL
+	if err := kv.set(sessionKey(id), string(data), ttl); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: id, Path: basePath(r) + "/", MaxAge: int(ttl.Seconds()),
+		HttpOnly: true, SameSite: http.SameSiteLaxMode, Secure: secureCookies(r),
+	})
+	return nil
+}
+
+// secureCookies reports whether cookies set in reply to r are Secure
+func secureCookies(r *http.Request) bool {
+	return isHTTPS(r) || config().SecureCookies
+}
+
+// startSession replaces r's session, if any, with a new one holding s
+func startSession(w http.ResponseWriter, r *http.Request, s session) (string, session, error) {
+	id, err := randomToken()
+	if err == nil {
+		s.CSRF, err = randomToken()
+	}
+	if err != nil {
+		return "", session{}, err
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		kv.del(sessionKey(c.Value))
+	}
+	s.CreatedAt = time.Now().UTC()
+	if err := saveSession(w, r, id, s); err != nil {
+		return "", session{}, err
+	}
+	return id, s, nil
+}
+
+// loadSession returns the session of r's cookie, if it is still valid
+func loadSession(r *http.Request) (string, session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return "", session{}, false
+	}
+	value, ok, _ := kv.get(sessionKey(c.Value))
+	var s session
+	if !ok || json.Unmarshal([]byte(value), &s) != nil || s.CSRF == "" {
+		return "", session{}, false
+	}
+	if s.Admin && s.Token != tokenHash(config().AdminToken) {
+		// Signed in with a token that has since been rotated
+		s.Admin, s.Token = false, ""
+	}
+	return c.Value, s, true
+}
+
+// currentSession returns r's session
+func currentSession(r *http.Request) (session, bool) {
+	if s, ok := r.Context().Value(sessionKeyCtx{}).(session); ok {
+		return s, true
+	}
+	_, s, ok := loadSession(r)
+	return s, ok
+}
+
+// endSession forgets r's session and clears its cookie
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		kv.del(sessionKey(c.Value))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: "", Path: basePath(r) + "/", MaxAge: -1,
+		HttpOnly: true, SameSite: http.SameSiteLaxMode, Secure: secureCookies(r),
+	})
+}
+
+// csrfToken returns the CSRF token pages rendered for r embed
+func csrfToken(r *http.Request) string {
+	s, _ := currentSession(r)
+	return s.CSRF
+}
+
+// withSession gives browsers opening a page a session, and extends the
+// session of those coming back once a quarter of SESSION_TTL has passed
+func withSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, s, ok := loadSession(r)
+		var err error
+		switch {
+		case !ok:
+			id, s, err = startSession(w, r, session{})
+		case time.Since(s.RefreshedAt) > config().SessionTTL/4:
+			err = saveSession(w, r, id, s)
+		}
+		if err != nil {
+			log.Printf("Warning: no session for %s: %v", r.RemoteAddr, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), sessionKeyCtx{}, s)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// needsCSRF reports whether r must carry the session's CSRF token
+func needsCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return true
+	}
+	// A form another site makes the browser submit carries no cookie
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return mode == "navigate"
+	}
+	return r.Header.Get("Origin") != "" && isFormContent(r)
+}
+
+func isFormContent(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return strings.HasPrefix(ct, "application/x-www-form-urlencoded") ||
+		strings.HasPrefix(ct, "multipart/form-data") || strings.HasPrefix(ct, "text/plain")
+}
+
+// withCSRF refuses state-changing browser requests without the CSRF token
+// of their session
+func withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !needsCSRF(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := r.Header.Get(csrfHeader)
+		if got == "" && isFormContent(r) {
+			got = r.FormValue("csrf_token")
+		}
+		s, ok := currentSession(r)
+		if ok && got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.CSRF)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		csrfRejected.inc()
+		log.Printf("Warning: refused %s %s from %s: missing or wrong CSRF token", r.Method, r.URL.Path, r.RemoteAddr)
+		msg := "The form has expired or did not come from this node; reload the page and try again"
+		if wantsJSON(r) || r.Header.Get(csrfHeader) != "" {
+			writeJSONError(w, http.StatusForbidden, msg)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		renderError(w, r, msg)
+	})
+}
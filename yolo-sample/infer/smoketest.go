@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// `webui smoketest` checks a running instance end to end, for a Kubernetes
+// startup probe, a conformance check after a rollout or a field acceptance
+// test:
+//
+//	webui smoketest -url http://node:6767 -budget 5s
+//
+// It waits for /readyz, reads /api/v1/version, submits the sample image
+// through /api/v1/infer/base64, waits for the result and checks it against
+// the v1 result schema. Each check prints a line; the exit code is 0 when
+// all pass, 1 when one fails and 2 for bad arguments. The sample image is
+// drawn by the binary, so nothing needs to be mounted; -image sends another,
+// and -expect-class also requires a detection of a class, which a real
+// image of a known scene can prove the model with.
+
+// smoketestClient is the HTTP client of the checks; each call has its own deadline
+var smoketestClient = &http.Client{}
+
+// runSmoketest implements `webui smoketest`, returning the exit code
+func runSmoketest(args []string) int {
+	fs := flag.NewFlagSet("smoketest", flag.ContinueOnError)
+	base := fs.String("url", "http://127.0.0.1:6767", "base URL of the instance")
+	wait := fs.Duration("wait", time.Minute, "how long to wait for the instance to become ready")
+	budget := fs.Duration("budget", 10*time.Second, "latency budget from submitting the image to its stored result")
+	imagePath := fs.String("image", "", "image to send instead of the built-in sample")
+	expect := fs.String("expect-class", "", "require a detection of this class")
+	model := fs.String("model", "", "model version to infer with (default: the active model)")
+	token := fs.String("token", "", "bearer token sent with every request, for an authenticating proxy")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	st := &smoketest{base: strings.TrimRight(*base, "/"), token: *token}
+
+	img, name := smoketestImage(), "smoketest.jpg"
+	if *imagePath != "" {
+		data, err := os.ReadFile(*imagePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		img, name = data, *imagePath
+	}
+
+	if !st.check("ready", func() error { return st.waitReady(*wait) }) {
+		return 1
+	}
+	st.check("version", func() error {
+		var b BuildInfo
+		if err := st.getJSON("/api/v1/version", &b); err != nil {
+			return err
+		}
+		st.detail = b.Version
+		return nil
+	})
+
+	var job IngestJob
+	submitted := time.Now()
+	if !st.check("submit", func() error {
+		req := Base64IngestRequest{Image: base64.StdEncoding.EncodeToString(img), Filename: name}
+		req.Model = *model
+		status, body, err := st.call(http.MethodPost, "/api/v1/infer/base64", req, 30*time.Second)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusAccepted {
+			return fmt.Errorf("status %d: %s", status, smoketestError(body))
+		}
+		if err := json.Unmarshal(body, &job); err != nil || job.JobID == "" {
+			return fmt.Errorf("no job_id in %s", body)
+		}
+		return nil
+	}) {
+		return 1
+	}
+
+	var raw []byte
+	if !st.check("result", func() error {
+		var err error
+		raw, err = st.waitResult(job.JobID, submitted.Add(*budget))
+		if err != nil {
+			return err
+		}
+		st.detail = fmt.Sprintf("%s of %s budget", time.Since(submitted).Round(time.Millisecond), *budget)
+		return nil
+	}) {
+		return 1
+	}
+	st.check("schema", func() error {
+		problems := smoketestSchema(raw, job.JobID)
+		if len(problems) > 0 {
+			return fmt.Errorf("%s", strings.Join(problems, "; "))
+		}
+		var res InferenceResult
+		json.Unmarshal(raw, &res)
+		st.detail = fmt.Sprintf("%d detections, model %s", len(res.Detections), res.Model)
+		return nil
+	})
+	if *expect != "" {
+		st.check("expect-class", func() error {
+			var res InferenceResult
+			json.Unmarshal(raw, &res)
+			for _, d := range res.Detections {
+				if d.ClassName == *expect {
+					return nil
+				}
+			}
+			return fmt.Errorf("no %q detection", *expect)
+		})
+	}
+
+	if st.failed {
+		return 1
+	}
+	fmt.Println("smoketest passed")
+	return 0
+}
+
+// smoketest runs checks against one instance
+type smoketest struct {
+	base, token string
+	failed      bool
+	detail      string // set by a passing check to print after it
+}
+
+// check runs a named check and prints its outcome
+func (st *smoketest) check(name string, fn func() error) bool {
+	st.detail = ""
+	started := time.Now()
+	err := fn()
+	took := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		st.failed = true
+		fmt.Printf("FAIL %-13s %v (%s)\n", name, err, took)
+		return false
+	}
+	line := fmt.Sprintf("ok   %-13s %s", name, took)
+	if st.detail != "" {
+		line += "  " + st.detail
+	}
+	fmt.Println(line)
+	return true
+}
+
+// call makes a request with an optional JSON body, returning the status and body
+func (st *smoketest) call(method, path string, body interface{}, timeout time.Duration) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, st.base+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if st.token != "" {
+		req.Header.Set("Authorization", "Bearer "+st.token)
+	}
+	resp, err := smoketestClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	return resp.StatusCode, data, err
+}
+
+func (st *smoketest) getJSON(path string, v interface{}) error {
+	status, body, err := st.call(http.MethodGet, path, nil, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%s: status %d: %s", path, status, smoketestError(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// waitReady polls /readyz until it answers 200
+func (st *smoketest) waitReady(wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		status, body, err := st.call(http.MethodGet, "/readyz", nil, 5*time.Second)
+		if err == nil && status == http.StatusOK {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status %d: %s", status, strings.TrimSpace(string(body)))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// waitResult polls for a stored result until deadline
+func (st *smoketest) waitResult(id string, deadline time.Time) ([]byte, error) {
+	for {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, fmt.Errorf("no result within the latency budget")
+		}
+		status, body, err := st.call(http.MethodGet, "/api/v1/results/"+id, nil, timeout)
+		switch {
+		case err != nil && time.Now().After(deadline):
+			return nil, fmt.Errorf("no result within the latency budget")
+		case err != nil:
+			return nil, err
+		case status == http.StatusOK:
+			return body, nil
+		case status != http.StatusNotFound:
+			return nil, fmt.Errorf("status %d: %s", status, smoketestError(body))
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// smoketestError returns the message of an {"error": ...} body, or the body
+func smoketestError(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// smoketestSchema lists how a result body departs from the v1 schema
+func smoketestSchema(body []byte, id string) []string {
+	var res map[string]interface{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return []string{"not a JSON object: " + err.Error()}
+	}
+	var problems []string
+	want := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	if msg, _ := res["error"].(string); msg != "" {
+		problems = append(problems, "inference failed: "+msg)
+	}
+	want(res["id"] == id, "id is %v, want %s", res["id"], id)
+	created, _ := res["created_at"].(string)
+	_, err := time.Parse(time.RFC3339Nano, created)
+	want(err == nil, "created_at %q is not an RFC 3339 time", created)
+	_, ok := res["image"].(string)
+	want(ok, "image is not a string")
+	detections, ok := res["detections"].([]interface{})
+	want(ok, "detections is not an array")
+	count, _ := res["count"].(float64)
+	want(int(count) == len(detections), "count is %v for %d detections", res["count"], len(detections))
+	for i, raw := range detections {
+		d, _ := raw.(map[string]interface{})
+		_, idOK := d["class_id"].(float64)
+		name, _ := d["class_name"].(string)
+		want(idOK && name != "", "detection %d has no class_id and class_name", i)
+		conf, ok := d["confidence"].(float64)
+		want(ok && conf >= 0 && conf <= 1, "detection %d confidence %v is not in 0-1", i, d["confidence"])
+		box, _ := d["bbox"].(map[string]interface{})
+		x1, ok1 := box["x1"].(float64)
+		y1, ok2 := box["y1"].(float64)
+		x2, ok3 := box["x2"].(float64)
+		y2, ok4 := box["y2"].(float64)
+		want(ok1 && ok2 && ok3 && ok4 && x1 <= x2 && y1 <= y2, "detection %d bbox %v is not x1 <= x2, y1 <= y2", i, d["bbox"])
+	}
+	return problems
+}
+
+// smoketestImage draws the sample image: a plain street scene of sky, road
+// and a few boxes, enough for the backend to decode and run on
+func smoketestImage() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			c := color.RGBA{uint8(120 + y/6), uint8(170 + y/8), 235, 255}
+			if y >= 300 {
+				c = color.RGBA{90, 90, uint8(95 + x%40/8), 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	for _, r := range []struct {
+		rect image.Rectangle
+		c    color.RGBA
+	}{
+		{image.Rect(60, 180, 220, 320), color.RGBA{180, 40, 40, 255}},
+		{image.Rect(300, 220, 340, 340), color.RGBA{40, 60, 140, 255}},
+		{image.Rect(420, 150, 600, 310), color.RGBA{200, 200, 200, 255}},
+	} {
+		for y := r.rect.Min.Y; y < r.rect.Max.Y; y++ {
+			for x := r.rect.Min.X; x < r.rect.Max.X; x++ {
+				img.Set(x, y, r.c)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Snapshots return what a camera currently sees, for dashboards and for
+// checking the physical camera. A running source serves its latest frame;
+// otherwise (disabled, or no recent frame) one frame is captured on demand.
+// With ?annotate=true the frame is the most recently processed one with its
+// detection boxes drawn. In privacy mode snapshots are always built from a
+// processed frame so sensitive regions can be redacted.
+
+// snapshotTimeout bounds an on-demand capture, including inference
+const snapshotTimeout = 20 * time.Second
+
+// classColors is the palette used for annotation boxes, picked by class ID
+var classColors = []color.RGBA{
+	{76, 175, 80, 255}, {33, 150, 243, 255}, {255, 152, 0, 255}, {233, 30, 99, 255},
+	{156, 39, 176, 255}, {0, 188, 212, 255}, {255, 235, 59, 255}, {121, 85, 72, 255},
+}
+
+// sourceSnapshotHandler serves GET /api/v1/sources/{name}/snapshot[?annotate=true]
+func sourceSnapshotHandler(w http.ResponseWriter, r *http.Request, name string) {
+	src, ok := sources.get(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Source not found")
+		return
+	}
+	annotate, _ := strconv.ParseBool(r.URL.Query().Get("annotate"))
+	needDetections := annotate || config().PrivacyMode
+
+	var frame []byte
+	var at time.Time
+	var detections, unfiltered []Detection
+	if wk := sources.worker(name); wk != nil {
+		wk.mu.Lock()
+		if needDetections {
+			frame, at = wk.lastProcessed, wk.lastProcessedAt
+			detections, unfiltered = wk.lastDetections, wk.lastUnfiltered
+		} else {
+			frame, at = wk.latest, wk.latestAt
+		}
+		wk.mu.Unlock()
+		if time.Since(at) > src.staleAfter() {
+			frame = nil
+		}
+	}
+
+	if frame == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+		defer cancel()
+		var err error
+		if frame, err = grabFrame(ctx, src); err != nil {
+			writeJSONError(w, http.StatusBadGateway, "Snapshot failed: "+err.Error())
+			return
+		}
+		at = time.Now()
+		if needDetections {
+			if detections, unfiltered, err = detectFrame(frame, src); err != nil {
+				writeJSONError(w, http.StatusBadGateway, "Snapshot inference failed: "+err.Error())
+				return
+			}
+		}
+	}
+
+	if needDetections {
+		img, err := decodeImage(bytes.NewReader(frame))
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, "Undecodable frame: "+err.Error())
+			return
+		}
+		var out *image.RGBA
+		if config().PrivacyMode {
+			out = redactImage(img, unfiltered)
+		} else {
+			out = image.NewRGBA(img.Bounds())
+			draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+		}
+		if annotate {
+			drawBoxes(out, detections)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: 85}); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		frame = buf.Bytes()
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Captured-At", at.UTC().Format(time.RFC3339Nano))
+	w.Write(frame)
+}
+
+// grabFrame captures a single frame from src
+func grabFrame(ctx context.Context, src CameraSource) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var frame []byte
+	err := captureFrames(ctx, src, func(f []byte) {
+		if frame == nil {
+			frame = f
+			cancel()
+		}
+	})
+	if frame != nil {
+		return frame, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no frame within %s", snapshotTimeout)
+	}
+	return nil, err
+}
+
+// detectFrame runs inference on a frame without storing a result. It returns
+// the detections inside the source's zones, and all of them for redaction.
+func detectFrame(frame []byte, src CameraSource) (detections, unfiltered []Detection, err error) {
+	path := filepath.Join(uploadDir, fmt.Sprintf("snapshot-%s-%d.jpg", src.Name, time.Now().UnixNano()))
+	if err := os.WriteFile(path, frame, 0644); err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(path)
+
+	version := src.Model
+	if version == "" {
+		version = activeModelVersion()
+	}
+	result := runInference(path, version)
+	if result.Error != "" {
+		return nil, nil, fmt.Errorf("%s", result.Error)
+	}
+	unfiltered = append([]Detection(nil), result.Detections...)
+	filterZones(&result, path, src.Zones)
+	return result.Detections, unfiltered, nil
+}
+
+// drawBoxes outlines each detection on img
+func drawBoxes(img *image.RGBA, detections []Detection) {
+	b := img.Bounds()
+	thickness := maxInt(2, minInt(b.Dx(), b.Dy())/200)
+	for _, d := range detections {
+		c := image.NewUniform(classColors[((d.ClassID%len(classColors))+len(classColors))%len(classColors)])
+		r := image.Rect(
+			b.Min.X+int(d.BBox.X1), b.Min.Y+int(d.BBox.Y1),
+			b.Min.X+int(d.BBox.X2), b.Min.Y+int(d.BBox.Y2),
+		).Intersect(b)
+		if r.Empty() {
+			continue
+		}
+		for _, edge := range []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+thickness),
+			image.Rect(r.Min.X, r.Max.Y-thickness, r.Max.X, r.Max.Y),
+			image.Rect(r.Min.X, r.Min.Y, r.Min.X+thickness, r.Max.Y),
+			image.Rect(r.Max.X-thickness, r.Min.Y, r.Max.X, r.Max.Y),
+		} {
+			draw.Draw(img, edge.Intersect(r), c, image.Point{}, draw.Src)
+		}
+	}
+}
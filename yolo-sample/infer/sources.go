@@ -0,0 +1,1083 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Camera sources are network cameras the node captures from continuously.
+// Each enabled source runs a worker that keeps the latest frame from its
+// capture backend (see capture.go) and feeds frames through processImage,
+// dropping frames that arrive while the previous one is still being processed.
+// Source definitions are persisted in STATE_DIR/sources.json. Sources can also
+// be declared in the JSON list named by SOURCES_FILE, which is re-applied when
+// the configuration is reloaded (see reload.go).
+
+// Zone is a named polygon in normalised (0-1) image coordinates. When a source
+// has enabled zones, only detections centred inside one of them are kept.
+type Zone struct {
+	Name    string       `json:"name"`
+	Points  [][2]float64 `json:"points"`
+	Enabled bool         `json:"enabled"`
+}
+
+// CameraSource is a configured camera
+type CameraSource struct {
+	Name    string  `json:"name"`
+	URL     string  `json:"url"`
+	FPS     float64 `json:"fps"`
+	Enabled bool    `json:"enabled"`
+	Zones   []Zone  `json:"zones,omitempty"`
+	// Quiet hours; see quiethours.go
+	Schedule *SourceSchedule `json:"schedule,omitempty"`
+	// Report only what the reference image lacks; see reference.go
+	ChangeDetection bool    `json:"change_detection,omitempty"`
+	ReferenceIoU    float64 `json:"reference_iou,omitempty"`
+	// Model, threshold and class overrides; a pinned model bypasses the canary split
+	InferenceOptions
+}
+
+// SourceStatus is the live capture state of a source
+type SourceStatus struct {
+	State        string     `json:"state"` // "disabled", "paused", "quiet", "connecting", "connected", "error"
+	Connected    bool       `json:"connected"`
+	LastFrameAt  *time.Time `json:"last_frame_at,omitempty"`
+	LastFrameAge *float64   `json:"last_frame_age_seconds,omitempty"`
+	FPSAchieved  float64    `json:"fps_achieved"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// SourceView is the API representation of a source
+type SourceView struct {
+	CameraSource
+	Status      SourceStatus    `json:"status"`
+	AlertsQuiet bool            `json:"alerts_quiet,omitempty"`
+	Override    *SourceOverride `json:"override,omitempty"`
+	ReferenceAt *time.Time      `json:"reference_at,omitempty"` // when the reference image was set
+}
+
+const (
+	defaultSourceFPS = 1.0
+	minSourceFPS     = 0.01
+	maxSourceFPS     = 30.0
+)
+
+var sourceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// reservedSources are result sources used by the node itself
+var reservedSources = map[string]bool{"upload": true, "batch": true}
+
+var (
+	sourceConnected = newGaugeVec("yolo_source_connected",
+		"Whether a camera source is currently delivering frames (1) or not (0).", "source")
+	sourceFrames = newCounterVec("yolo_source_frames_total",
+		"Frames captured per camera source, by whether they were processed or dropped.", "source", "status")
+)
+
+// validate normalises src and checks it is a usable source definition
+func (src *CameraSource) validate() error {
+	if !sourceNamePattern.MatchString(src.Name) {
+		return fmt.Errorf("name must be 1-63 lowercase letters, digits, '-' or '_'")
+	}
+	if reservedSources[src.Name] {
+		return fmt.Errorf("name %q is reserved", src.Name)
+	}
+	if src.Name == "devices" {
+		return fmt.Errorf("name %q is reserved by the sources API", src.Name)
+	}
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q", src.URL)
+	}
+	switch u.Scheme {
+	case "http", "https", "rtsp", "rtsps":
+		if u.Host == "" {
+			return fmt.Errorf("invalid url %q", src.URL)
+		}
+	case "v4l2":
+		if err := validateV4L2URL(u); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported url scheme %q (want rtsp, rtsps, http, https or v4l2)", u.Scheme)
+	}
+	if src.FPS == 0 {
+		src.FPS = defaultSourceFPS
+	}
+	if src.FPS < minSourceFPS || src.FPS > maxSourceFPS {
+		return fmt.Errorf("fps must be between %g and %g", minSourceFPS, maxSourceFPS)
+	}
+	if err := src.InferenceOptions.validate(); err != nil {
+		return err
+	}
+	if err := src.Schedule.validate(); err != nil {
+		return err
+	}
+	if src.ReferenceIoU < 0 || src.ReferenceIoU > 1 {
+		return fmt.Errorf("reference_iou must be between 0 and 1")
+	}
+	for _, z := range src.Zones {
+		if z.Name == "" {
+			return fmt.Errorf("zones need a name")
+		}
+		if len(z.Points) < 3 {
+			return fmt.Errorf("zone %q needs at least 3 points", z.Name)
+		}
+		for _, p := range z.Points {
+			if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+				return fmt.Errorf("zone %q points must be normalised to 0-1", z.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sourceRegistry holds the configured sources and their capture workers
+type sourceRegistry struct {
+	mu      sync.Mutex
+	path    string
+	sources map[string]CameraSource
+	workers map[string]*sourceWorker
+
+	declared  map[string]bool // names from SOURCES_FILE
+	overrides map[string]SourceOverride
+}
+
+var sources = &sourceRegistry{sources: map[string]CameraSource{}, workers: map[string]*sourceWorker{}, declared: map[string]bool{}, overrides: map[string]SourceOverride{}}
+
+// load reads the persisted sources and starts workers for the enabled ones
+func (s *sourceRegistry) load(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.loadOverridesLocked()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read sources from %s: %v", path, err)
+		}
+		return
+	}
+	var list []CameraSource
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Warning: ignoring unreadable sources file %s: %v", path, err)
+		return
+	}
+	for _, src := range list {
+		if err := src.validate(); err != nil && src.Enabled {
+			// Saved by an older version that accepted it; it would not capture
+			log.Printf("Warning: disabling source %s: %v", src.Name, err)
+			src.Enabled = false
+		}
+		s.sources[src.Name] = src
+		s.restartLocked(src.Name)
+	}
+	log.Printf("Loaded %d camera sources", len(list))
+}
+
+func (s *sourceRegistry) saveLocked() error {
+	list := make([]CameraSource, 0, len(s.sources))
+	for _, src := range s.sources {
+		list = append(list, src)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// restartLocked stops the worker for name and starts a new one if the source
+// is enabled, the node is not in maintenance and it is not in quiet hours
+func (s *sourceRegistry) restartLocked(name string) {
+	if w, ok := s.workers[name]; ok {
+		w.stop()
+		delete(s.workers, name)
+	}
+	sourceConnected.set(0, name)
+	if src, ok := s.sources[name]; ok && src.Enabled && !maintenanceOn() && s.captureActiveLocked(name, time.Now()) {
+		s.workers[name] = startSourceWorker(src)
+	}
+}
+
+// restartAll restarts every source, pausing or resuming them for maintenance
+func (s *sourceRegistry) restartAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.sources {
+		s.restartLocked(name)
+	}
+}
+
+func (s *sourceRegistry) get(name string) (CameraSource, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[name]
+	return src, ok
+}
+
+// worker returns the running worker for name, or nil
+func (s *sourceRegistry) worker(name string) *sourceWorker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workers[name]
+}
+
+func (s *sourceRegistry) view(name string) (SourceView, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[name]
+	if !ok {
+		return SourceView{}, false
+	}
+	// Never echo stream credentials back through the API
+	if u, err := url.Parse(src.URL); err == nil {
+		src.URL = u.Redacted()
+	}
+	v := SourceView{CameraSource: src, Status: SourceStatus{State: "disabled"}, ReferenceAt: referenceTime(name)}
+	now := time.Now()
+	if w, ok := s.workers[name]; ok {
+		v.Status = w.status()
+	} else if src.Enabled && (maintenanceOn() || lowPowerPaused(name)) {
+		v.Status.State = "paused"
+	} else if src.Enabled {
+		v.Status.State = "quiet"
+	}
+	if o, ok := s.overrideLocked(name, now); ok {
+		v.Override = &o
+	}
+	if o := v.Override; o != nil && o.Alerts != nil {
+		v.AlertsQuiet = !*o.Alerts
+	} else if src.Schedule != nil {
+		v.AlertsQuiet = !scheduleActive(src.Schedule.Alerts, now)
+	}
+	return v, true
+}
+
+func (s *sourceRegistry) list() []SourceView {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	out := make([]SourceView, 0, len(names))
+	for _, name := range names {
+		if v, ok := s.view(name); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// put creates (create=true) or replaces a source and restarts its worker
+func (s *sourceRegistry) put(src CameraSource, create bool) error {
+	if err := src.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.sources[src.Name]
+	if create && exists {
+		return fmt.Errorf("source %q already exists", src.Name)
+	}
+	if !create && !exists {
+		return os.ErrNotExist
+	}
+	prev, hadPrev := s.sources[src.Name]
+	if hadPrev {
+		src.URL = keepRedactedPassword(src.URL, prev.URL)
+	}
+	s.sources[src.Name] = src
+	if err := s.saveLocked(); err != nil {
+		if hadPrev {
+			s.sources[src.Name] = prev
+		} else {
+			delete(s.sources, src.Name)
+		}
+		return err
+	}
+	s.restartLocked(src.Name)
+	return nil
+}
+
+func (s *sourceRegistry) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.sources[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.sources, name)
+	if err := s.saveLocked(); err != nil {
+		s.sources[name] = prev
+		return err
+	}
+	if _, ok := s.overrides[name]; ok {
+		delete(s.overrides, name)
+		if err := s.saveOverridesLocked(); err != nil {
+			log.Printf("Warning: failed to save source overrides: %v", err)
+		}
+	}
+	removeReference(name)
+	s.restartLocked(name)
+	return nil
+}
+
+// loadDeclaredSources applies SOURCES_FILE at startup; a broken file is logged and ignored
+func loadDeclaredSources() {
+	if config().SourcesFile == "" {
+		return
+	}
+	list, err := readSourcesFile(config().SourcesFile)
+	if err == nil {
+		err = sources.declare(list)
+	}
+	if err != nil {
+		log.Printf("Warning: ignoring sources file %s: %v", config().SourcesFile, err)
+		return
+	}
+	log.Printf("Applied %d sources from %s", len(list), config().SourcesFile)
+}
+
+// readSourcesFile reads and validates a SOURCES_FILE list
+func readSourcesFile(path string) ([]CameraSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []CameraSource
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i := range list {
+		if err := list[i].validate(); err != nil {
+			return nil, fmt.Errorf("source %q: %v", list[i].Name, err)
+		}
+		if seen[list[i].Name] {
+			return nil, fmt.Errorf("source %q is declared twice", list[i].Name)
+		}
+		seen[list[i].Name] = true
+	}
+	return list, nil
+}
+
+// declare makes the registry match a SOURCES_FILE list: each source is created
+// or replaced, and sources the previous list declared but this one does not are
+// removed. Sources added through the API are left alone. Workers are only
+// restarted for sources that changed.
+func (s *sourceRegistry) declare(list []CameraSource) error {
+	s.mu.Lock()
+	prev := s.declared
+	s.declared = map[string]bool{}
+	for _, src := range list {
+		s.declared[src.Name] = true
+	}
+	s.mu.Unlock()
+
+	var errs []string
+	for _, src := range list {
+		current, exists := s.get(src.Name)
+		if exists && reflect.DeepEqual(current, src) {
+			continue
+		}
+		if err := s.put(src, !exists); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Name, err))
+		}
+	}
+	for name := range prev {
+		if !s.declared[name] {
+			if err := s.remove(name); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot apply sources: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// staleAfter is how old the latest frame may get before the source counts as
+// disconnected; a few missed frames are allowed
+func (src CameraSource) staleAfter() time.Duration {
+	stale := time.Duration(3 / src.FPS * float64(time.Second))
+	if stale < 5*time.Second {
+		stale = 5 * time.Second
+	}
+	return stale
+}
+
+// keepRedactedPassword restores the previous password when an edit sends back
+// the redacted URL from the API unchanged apart from other fields
+func keepRedactedPassword(newURL, oldURL string) string {
+	u, err := url.Parse(newURL)
+	if err != nil || u.User == nil {
+		return newURL
+	}
+	if pw, ok := u.User.Password(); !ok || pw != "xxxxx" {
+		return newURL
+	}
+	old, err := url.Parse(oldURL)
+	if err != nil || old.User == nil || old.User.Username() != u.User.Username() {
+		return newURL
+	}
+	u.User = old.User
+	return u.String()
+}
+
+// sourceWorker captures from one source and processes its frames
+type sourceWorker struct {
+	src    CameraSource
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan struct{} // signalled when a new frame is waiting
+
+	mu        sync.Mutex
+	pending   []byte // captured frame waiting to be processed
+	latest    []byte // most recent captured frame, kept for snapshots
+	latestAt  time.Time
+	processed []time.Time // recent processing times, for the achieved FPS
+	lastErr   string
+	connected bool // frames have arrived since the capture last failed
+
+	// The most recently processed frame and its detections, for annotated
+	// snapshots, and all the backend found in it, for redacting them
+	lastProcessed   []byte
+	lastProcessedAt time.Time
+	lastDetections  []Detection
+	lastUnfiltered  []Detection
+}
+
+// sourceFPSWindow is how many recent frames the achieved FPS is averaged over
+const sourceFPSWindow = 20
+
+func startSourceWorker(src CameraSource) *sourceWorker {
+	src.FPS = thermalFPS(src.FPS)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &sourceWorker{src: src, cancel: cancel, done: make(chan struct{}), ready: make(chan struct{}, 1)}
+	go w.capture(ctx)
+	go w.process(ctx)
+	return w
+}
+
+func (w *sourceWorker) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// capture runs the backend, reconnecting with exponential backoff on failure
+func (w *sourceWorker) capture(ctx context.Context) {
+	defer close(w.done)
+	backoff := 5 * time.Second
+	for {
+		attempt := time.Now()
+		err := captureFrames(ctx, w.src, w.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		w.mu.Lock()
+		if err != nil {
+			w.lastErr = err.Error()
+		}
+		if w.latestAt.After(attempt) {
+			backoff = 5 * time.Second // the stream worked for a while; retry promptly
+		}
+		wasConnected := w.connected
+		w.connected = false
+		w.mu.Unlock()
+		sourceConnected.set(0, w.src.Name)
+		log.Printf("Warning: source %s disconnected: %v (retrying in %s)", w.src.Name, err, backoff)
+		if wasConnected {
+			ev := SourceEvent{Source: w.src.Name}
+			if err != nil {
+				ev.Error = err.Error()
+			}
+			bus.publish(eventSourceStatus, ev)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// receive stores frame as the latest one, replacing any frame not yet processed
+func (w *sourceWorker) receive(frame []byte) {
+	w.mu.Lock()
+	if w.pending != nil {
+		sourceFrames.inc(w.src.Name, "dropped")
+	}
+	w.pending = frame
+	w.latest = frame
+	w.latestAt = time.Now()
+	w.lastErr = ""
+	reconnected := !w.connected
+	w.connected = true
+	w.mu.Unlock()
+	sourceConnected.set(1, w.src.Name)
+	if reconnected {
+		bus.publish(eventSourceStatus, SourceEvent{Source: w.src.Name, Connected: true})
+	}
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// process runs inference on the latest frame whenever one is waiting
+func (w *sourceWorker) process(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.ready:
+		}
+		w.mu.Lock()
+		frame := w.pending
+		w.pending = nil
+		w.mu.Unlock()
+		if frame == nil {
+			continue
+		}
+
+		path := filepath.Join(uploadDir, fmt.Sprintf("%s-%d.jpg", w.src.Name, time.Now().UnixNano()))
+		if err := os.WriteFile(path, frame, 0644); err != nil {
+			log.Printf("Warning: source %s: failed to write frame: %v", w.src.Name, err)
+			continue
+		}
+		result := processImage(path, w.src.Name, true)
+		sourceFrames.inc(w.src.Name, "processed")
+
+		w.mu.Lock()
+		if result.inferred {
+			w.lastProcessed, w.lastProcessedAt = frame, time.Now()
+			w.lastDetections, w.lastUnfiltered = result.Detections, result.unfiltered
+		}
+		w.processed = append(w.processed, time.Now())
+		if len(w.processed) > sourceFPSWindow {
+			w.processed = w.processed[len(w.processed)-sourceFPSWindow:]
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *sourceWorker) status() SourceStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := SourceStatus{State: "connecting", LastError: w.lastErr}
+	if !w.latestAt.IsZero() {
+		at := w.latestAt
+		age := time.Since(at).Seconds()
+		st.LastFrameAt, st.LastFrameAge = &at, &age
+		st.Connected = time.Since(at) < w.src.staleAfter()
+	}
+	if n := len(w.processed); n >= 2 && time.Since(w.processed[n-1]).Seconds() < 3/w.src.FPS+5 {
+		st.FPSAchieved = float64(n-1) / w.processed[n-1].Sub(w.processed[0]).Seconds()
+	}
+	switch {
+	case st.Connected:
+		st.State = "connected"
+	case w.lastErr != "":
+		st.State = "error"
+	}
+	return st
+}
+
+// filterZones drops detections whose centre falls outside every enabled zone;
+// sources without enabled zones keep all detections
+func filterZones(result *InferenceResult, imagePath string, zones []Zone) {
+	var active []Zone
+	for _, z := range zones {
+		if z.Enabled {
+			active = append(active, z)
+		}
+	}
+	if len(active) == 0 || len(result.Detections) == 0 {
+		return
+	}
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		log.Printf("Warning: cannot apply zones, image size unknown: %v", err)
+		return
+	}
+
+	kept := result.Detections[:0:0]
+	for _, d := range result.Detections {
+		x := (d.BBox.X1 + d.BBox.X2) / 2 / float64(cfg.Width)
+		y := (d.BBox.Y1 + d.BBox.Y2) / 2 / float64(cfg.Height)
+		for _, z := range active {
+			if pointInPolygon(x, y, z.Points) {
+				kept = append(kept, d)
+				break
+			}
+		}
+	}
+	result.Detections = kept
+	result.Count = len(kept)
+}
+
+// pointInPolygon reports whether (x, y) lies inside poly, by ray casting
+func pointInPolygon(x, y float64, poly [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		xi, yi, xj, yj := poly[i][0], poly[i][1], poly[j][0], poly[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// sourcesHandler serves the camera source API
+//
+//	GET    /api/v1/sources         all sources with live status
+//	GET    /api/v1/sources/devices local V4L2 video devices
+//	POST   /api/v1/sources         add a source
+//	GET    /api/v1/sources/{name}  a single source
+//	PUT    /api/v1/sources/{name}  replace a source's settings
+//	DELETE /api/v1/sources/{name}  remove a source
+//	GET    /api/v1/sources/{name}/snapshot  latest frame as JPEG (see snapshot.go)
+//	...    /api/v1/sources/{name}/reference reference image (see reference.go)
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/sources"), "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, sources.list())
+
+	case name == "" && r.Method == http.MethodPost:
+		var src CameraSource
+		if err := decodeJSON(w, r, &src); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if err := sources.put(src, true); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v, _ := sources.view(src.Name)
+		writeJSON(w, http.StatusCreated, v)
+
+	case strings.HasSuffix(name, "/snapshot") && r.Method == http.MethodGet:
+		sourceSnapshotHandler(w, r, strings.TrimSuffix(name, "/snapshot"))
+
+	case strings.HasSuffix(name, "/heatmap") && r.Method == http.MethodGet:
+		sourceHeatmapHandler(w, r, strings.TrimSuffix(name, "/heatmap"))
+
+	case strings.HasSuffix(name, "/reference"):
+		sourceReferenceHandler(w, r, strings.TrimSuffix(name, "/reference"))
+
+	case strings.HasSuffix(name, "/override"):
+		sourceOverrideHandler(w, r, strings.TrimSuffix(name, "/override"))
+
+	case name == "devices" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, listVideoDevices())
+
+	case name != "" && !strings.Contains(name, "/") && r.Method == http.MethodGet:
+		v, ok := sources.view(name)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "Source not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+
+	case name != "" && !strings.Contains(name, "/") && r.Method == http.MethodPut:
+		var src CameraSource
+		if err := decodeJSON(w, r, &src); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if src.Name == "" {
+			src.Name = name
+		}
+		if src.Name != name {
+			writeJSONError(w, http.StatusBadRequest, "Sources cannot be renamed")
+			return
+		}
+		if err := sources.put(src, false); err != nil {
+			if os.IsNotExist(err) {
+				writeJSONError(w, http.StatusNotFound, "Source not found")
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v, _ := sources.view(name)
+		writeJSON(w, http.StatusOK, v)
+
+	case name != "" && !strings.Contains(name, "/") && r.Method == http.MethodDelete:
+		if err := sources.remove(name); err != nil {
+			if os.IsNotExist(err) {
+				writeJSONError(w, http.StatusNotFound, "Source not found")
+				return
+			}
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+type sourcesPageData struct {
+	Sources []SourceView
+	Models  []string
+	Devices []VideoDevice
+}
+
+// sourcesPageHandler serves GET /sources, the camera settings page. Edits go
+// through the JSON API above.
+func sourcesPageHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Camera Sources"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 800px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            font-size: 14px;
+        }
+        td, th {
+            text-align: left;
+            padding: 6px;
+            border-bottom: 1px solid #eee;
+            vertical-align: top;
+        }
+        .state {
+            font-weight: bold;
+        }
+        .state.connected { color: #4CAF50; }
+        .state.error { color: #d32f2f; }
+        .state.connecting { color: #FF9800; }
+        .state.disabled { color: #9e9e9e; }
+        .state.paused, .state.quiet { color: #9e9e9e; }
+        label {
+            display: block;
+            margin: 10px 0 4px;
+        }
+        input[type="text"], input[type="number"], select, textarea {
+            width: 100%;
+            padding: 8px;
+            box-sizing: border-box;
+        }
+        textarea {
+            font-family: monospace;
+            height: 80px;
+        }
+        button {
+            background-color: #4CAF50;
+            color: white;
+            padding: 8px 16px;
+            border: none;
+            border-radius: 4px;
+            cursor: pointer;
+        }
+        button.secondary {
+            background-color: #9e9e9e;
+        }
+        button.danger {
+            background-color: #d32f2f;
+        }
+        .error {
+            color: #d32f2f;
+            margin-top: 10px;
+        }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Camera Sources"}}</h1>
+    <div class="panel">
+        {{if .Sources}}
+        <table>
+            <tr><th>{{t "Name"}}</th><th>URL</th><th>FPS</th><th>{{t "Model"}}</th><th>{{t "Status"}}</th><th></th></tr>
+            {{range .Sources}}
+            <tr data-source="{{.Name}}">
+                <td>{{.Name}}{{range .Zones}}{{if .Enabled}}<br><small>{{t "zone: %s" .Name}}</small>{{end}}{{end}}</td>
+                <td><small>{{.URL}}</small></td>
+                <td>{{.FPS}}</td>
+                <td>{{if .Model}}{{.Model}}{{else}}<i>{{t "active"}}</i>{{end}}</td>
+                <td>
+                    <span class="state {{.Status.State}}">{{t .Status.State}}</span>
+                    <br><small class="detail"></small>
+                </td>
+                <td>
+                    <button class="secondary edit-btn">{{t "Edit"}}</button>
+                    <button class="danger delete-btn">{{t "Delete"}}</button>
+                </td>
+            </tr>
+            {{end}}
+        </table>
+        {{else}}
+        <p>{{t "No camera sources configured yet."}}</p>
+        {{end}}
+    </div>
+    <div class="panel">
+        <h2 id="formTitle">{{t "Add Source"}}</h2>
+        <form id="sourceForm">
+            <label>{{t "Name"}}</label>
+            <input type="text" name="name" pattern="[a-z0-9][a-z0-9_-]*" required>
+            <label>{{t "URL (rtsp://, rtsps://, an http(s) JPEG snapshot URL, or v4l2:///dev/videoN)"}}</label>
+            <input type="text" name="url" list="devices" required>
+            <datalist id="devices">{{range .Devices}}<option value="{{.URL}}">{{.Name}}</option>{{end}}</datalist>
+            <label>FPS</label>
+            <input type="number" name="fps" min="0.01" max="30" step="0.01" value="1">
+            <label>{{t "Model"}}</label>
+            <select name="model">
+                <option value="">{{t "Active model"}}</option>
+                {{range .Models}}<option value="{{.}}">{{.}}</option>{{end}}
+            </select>
+            <label>{{t "Confidence threshold (blank for the default)"}}</label>
+            <input type="number" name="threshold" min="0" max="1" step="0.01">
+            <label>{{t "Classes (comma-separated class or taxonomy labels; blank for all)"}}</label>
+            <input type="text" name="classes">
+            <label>{{t "Zones (JSON, e.g. %s)" "[{\"name\": \"door\", \"points\": [[0,0],[0.5,0],[0.5,1]], \"enabled\": true}]"}}</label>
+            <textarea name="zones">[]</textarea>
+            <label>{{t "Capture hours (cron minutes, e.g. %s; blank for always)" "* 6-21 * * *"}}</label>
+            <input type="text" name="capture">
+            <label>{{t "Alert hours (cron minutes, e.g. %s; blank for always)" "* * * * mon-fri"}}</label>
+            <input type="text" name="alerts">
+            <label><input type="checkbox" name="enabled" checked> {{t "Enabled"}}</label>
+            <br>
+            <button type="submit">{{t "Save"}}</button>
+            <button type="button" class="secondary" id="resetBtn">{{t "Clear"}}</button>
+            <div class="error" id="formError"></div>
+        </form>
+    </div>
+    <div class="panel">
+        <h2>{{t "Discover ONVIF Cameras"}}</h2>
+        <p><small>{{t "Searches the local network. Add a camera with its ONVIF credentials to use its first RTSP profile."}}</small></p>
+        <button type="button" id="discoverBtn">{{t "Discover"}}</button>
+        <table id="discovered"></table>
+        <div class="error" id="discoverError"></div>
+    </div>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+    <script nonce="{{cspNonce}}">
+        const stateLabels = {
+            connected: {{t "connected"}}, connecting: {{t "connecting"}},
+            error: {{t "error"}}, disabled: {{t "disabled"}}, paused: {{t "paused"}},
+            quiet: {{t "quiet"}}
+        };
+        const form = document.getElementById('sourceForm');
+        let editing = null;
+
+        async function api(method, path, body) {
+            const resp = await fetch({{base}} + '/api/v2/sources' + path, {
+                method: method,
+                headers: {'Content-Type': 'application/json', 'X-CSRF-Token': {{csrfToken}}},
+                body: body ? JSON.stringify(body) : undefined
+            });
+            if (!resp.ok) {
+                const data = await resp.json().catch(() => ({}));
+                throw new Error(data.error || resp.statusText);
+            }
+            return resp.status === 204 ? null : resp.json();
+        }
+
+        function resetForm() {
+            editing = null;
+            form.reset();
+            form.name.disabled = false;
+            form.zones.value = '[]';
+            document.getElementById('formTitle').textContent = {{t "Add Source"}};
+            document.getElementById('formError').textContent = '';
+        }
+        document.getElementById('resetBtn').addEventListener('click', resetForm);
+
+        form.addEventListener('submit', async function(e) {
+            e.preventDefault();
+            try {
+                const src = {
+                    name: form.name.value,
+                    url: form.url.value,
+                    fps: parseFloat(form.fps.value) || 0,
+                    model: form.model.value,
+                    threshold: form.threshold.value === '' ? undefined : parseFloat(form.threshold.value),
+                    classes: form.classes.value.split(',').map(c => c.trim()).filter(c => c),
+                    zones: JSON.parse(form.zones.value || '[]'),
+                    schedule: form.capture.value || form.alerts.value ?
+                        {capture: form.capture.value.trim(), alerts: form.alerts.value.trim()} : undefined,
+                    enabled: form.enabled.checked
+                };
+                if (editing) {
+                    await api('PUT', '/' + encodeURIComponent(editing), src);
+                } else {
+                    await api('POST', '', src);
+                }
+                location.reload();
+            } catch (err) {
+                document.getElementById('formError').textContent = err.message;
+            }
+        });
+
+        document.querySelectorAll('.edit-btn').forEach(btn => {
+            btn.addEventListener('click', async function() {
+                const name = this.closest('tr').dataset.source;
+                const src = await api('GET', '/' + encodeURIComponent(name));
+                editing = name;
+                form.name.value = src.name;
+                form.name.disabled = true;
+                form.url.value = src.url;
+                form.fps.value = src.fps;
+                form.model.value = src.model || '';
+                form.threshold.value = src.threshold ?? '';
+                form.classes.value = (src.classes || []).join(', ');
+                form.zones.value = JSON.stringify(src.zones || []);
+                form.capture.value = (src.schedule || {}).capture || '';
+                form.alerts.value = (src.schedule || {}).alerts || '';
+                form.enabled.checked = src.enabled;
+                document.getElementById('formTitle').textContent = {{t "Edit"}} + ' ' + name;
+                form.scrollIntoView();
+            });
+        });
+
+        document.querySelectorAll('.delete-btn').forEach(btn => {
+            btn.addEventListener('click', async function() {
+                const name = this.closest('tr').dataset.source;
+                if (confirm({{t "Delete source %s?" "{name}"}}.replace('{name}', name))) {
+                    await api('DELETE', '/' + encodeURIComponent(name));
+                    location.reload();
+                }
+            });
+        });
+
+        document.getElementById('discoverBtn').addEventListener('click', async function() {
+            const table = document.getElementById('discovered');
+            const errBox = document.getElementById('discoverError');
+            this.disabled = true;
+            this.textContent = {{t "Searching..."}};
+            errBox.textContent = '';
+            table.innerHTML = '';
+            try {
+                const resp = await fetch({{base}} + '/api/v2/onvif/discover');
+                const devices = await resp.json();
+                if (!resp.ok) throw new Error(devices.error || resp.statusText);
+                if (devices.length === 0) errBox.textContent = {{t "No cameras answered."}};
+                devices.forEach(dev => {
+                    const row = table.insertRow();
+                    row.insertCell().textContent = (dev.name || dev.address) + (dev.hardware ? ' (' + dev.hardware + ')' : '');
+                    row.insertCell().textContent = dev.xaddrs[0] || '';
+                    const btn = document.createElement('button');
+                    btn.textContent = {{t "Add"}};
+                    btn.addEventListener('click', async function() {
+                        const name = prompt({{t "Source name"}}, (dev.name || 'camera').toLowerCase().replace(/[^a-z0-9_-]+/g, '-'));
+                        if (!name) return;
+                        const username = prompt({{t "ONVIF username (blank for none)"}}, 'admin') || '';
+                        const password = username ? (prompt({{t "ONVIF password"}}) || '') : '';
+                        const resp = await fetch({{base}} + '/api/v2/onvif/add', {
+                            method: 'POST',
+                            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': {{csrfToken}}},
+                            body: JSON.stringify({xaddr: dev.xaddrs[0], username: username, password: password, name: name})
+                        });
+                        const data = await resp.json();
+                        if (!resp.ok) {
+                            errBox.textContent = data.error || resp.statusText;
+                            return;
+                        }
+                        location.reload();
+                    });
+                    row.insertCell().appendChild(btn);
+                });
+            } catch (err) {
+                errBox.textContent = err.message;
+            }
+            this.disabled = false;
+            this.textContent = {{t "Discover"}};
+        });
+
+        // Keep the status column live
+        setInterval(async function() {
+            const list = await api('GET', '').catch(() => []);
+            list.forEach(src => {
+                const row = document.querySelector('tr[data-source="' + src.name + '"]');
+                if (!row) return;
+                const state = row.querySelector('.state');
+                state.className = 'state ' + src.status.state;
+                state.textContent = stateLabels[src.status.state] || src.status.state;
+                let detail = '';
+                if (src.status.last_frame_age_seconds !== undefined) {
+                    detail = {{t "last frame %ss ago, %s fps" "{age}" "{fps}"}}
+                        .replace('{age}', src.status.last_frame_age_seconds.toFixed(1))
+                        .replace('{fps}', src.status.fps_achieved.toFixed(2));
+                }
+                if (src.status.last_error) {
+                    detail += (detail ? '; ' : '') + src.status.last_error;
+                }
+                if (src.alerts_quiet) {
+                    detail += (detail ? '; ' : '') + {{t "alerts off"}};
+                }
+                row.querySelector('.detail').textContent = detail;
+            });
+        }, 3000);
+    </script>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("sources").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, sourcesPageData{Sources: sources.list(), Models: listModelVersions(), Devices: listVideoDevices()})
+}
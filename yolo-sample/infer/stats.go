@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Detection stats over stored results, with counts rolled up the label
+// taxonomy (see taxonomy.go): a car counts towards "car", "vehicle" and
+// "road-user" alike. Results already deleted by the retention sweep count
+// through the stats archive (see archive.go).
+
+// LabelNode is a taxonomy label with its rolled-up count
+type LabelNode struct {
+	Label    string      `json:"label"`
+	Count    int         `json:"count"`
+	Children []LabelNode `json:"children,omitempty"`
+}
+
+// DetectionStats summarizes the detections in a set of results
+type DetectionStats struct {
+	Since      time.Time      `json:"since"`
+	Results    int            `json:"results"`
+	Detections int            `json:"detections"`
+	Classes    map[string]int `json:"classes"` // by model class
+	Labels     map[string]int `json:"labels"`  // rolled up by taxonomy label
+	Taxonomy   []LabelNode    `json:"taxonomy,omitempty"`
+	Alerts     map[string]int `json:"alerts"`           // by rule
+	Archived   int            `json:"archived_results"` // of Results, counted from the archive
+}
+
+// computeStats counts the detections of results and of archived days,
+// keeping those under label when it is set. Archived results count only
+// without a label: the archive does not know which held a matching detection.
+func computeStats(res []InferenceResult, archived archiveDay, since time.Time, label string) DetectionStats {
+	s := DetectionStats{Since: since, Classes: map[string]int{}, Labels: map[string]int{}, Alerts: map[string]int{}}
+	tax := labels()
+	for class, n := range archived.Classes {
+		if label != "" && !tax.matches(class, label) {
+			continue
+		}
+		s.Detections += n
+		s.Classes[class] += n
+		for _, l := range tax.ancestors(class) {
+			s.Labels[l] += n
+		}
+	}
+	if label == "" {
+		s.Results, s.Archived = archived.Results, archived.Results
+	}
+	for rule, n := range archived.Alerts {
+		s.Alerts[rule] = n
+	}
+	for _, r := range res {
+		counted := false
+		for _, d := range r.Detections {
+			if label != "" && !tax.matches(d.ClassName, label) {
+				continue
+			}
+			counted = true
+			s.Detections++
+			s.Classes[d.ClassName]++
+			for _, l := range tax.ancestors(d.ClassName) {
+				s.Labels[l]++
+			}
+		}
+		if counted || label == "" {
+			s.Results++
+		}
+	}
+	for _, root := range tax.roots() {
+		s.Taxonomy = append(s.Taxonomy, s.labelTree(root, map[string]bool{}))
+	}
+	return s
+}
+
+func (s DetectionStats) labelTree(label string, path map[string]bool) LabelNode {
+	n := LabelNode{Label: label, Count: s.Labels[label]}
+	if _, isLabel := labels().children[label]; !isLabel {
+		n.Count = s.Classes[label]
+	}
+	path[label] = true
+	defer delete(path, label)
+	kids := append([]string{}, labels().children[label]...)
+	sort.Strings(kids)
+	for _, kid := range kids {
+		if !path[kid] {
+			n.Children = append(n.Children, s.labelTree(kid, path))
+		}
+	}
+	return n
+}
+
+// statsHandler serves GET /api/v1/stats; archived days and alerts count
+// from the first day of the window
+//
+//	?since=24h     window, as a duration before now (default 24h)
+//	?label=vehicle only detections under this class or taxonomy label
+//	?source=dock   only results from this source
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid since duration")
+			return
+		}
+		window = d
+	}
+	since := time.Now().Add(-window).UTC()
+	source := r.URL.Query().Get("source")
+
+	var res []InferenceResult
+	for _, result := range results.listSince(since) {
+		if source == "" || result.Source == source {
+			res = append(res, result)
+		}
+	}
+	writeJSON(w, http.StatusOK, computeStats(res, archivedSince(since, source), since, r.URL.Query().Get("label")))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// The node keeps what it last observed of itself in STATE_DIR/status.json:
+// the network status with the provider it came from and when it was last
+// current, the inference backend, the active model and the spools waiting
+// for the network (buffered heartbeats, delivery retries, dead letters and
+// the log spool). It is written when any of them changes, and at least every
+// ten minutes, so flash storage is not worn by a write a second.
+//
+// A node restarted during an outage reads it back before anything asks for
+// its status: the kubernetes provider then reports the saved node label as
+// stale since it was last current (see netstatus.go) instead of "unknown"
+// until the API server answers again, and the startup log says what the
+// node was doing before.
+
+// statusCacheInterval is how often the status is checked for changes;
+// statusCacheRefresh how often it is written regardless
+const (
+	statusCacheInterval = 30 * time.Second
+	statusCacheRefresh  = 10 * time.Minute
+)
+
+// StatusSnapshot is the contents of status.json
+type StatusSnapshot struct {
+	SavedAt         time.Time   `json:"saved_at"`
+	NetworkStatus   string      `json:"network_status"`
+	NetworkProvider string      `json:"network_provider"`
+	NetworkCurrent  time.Time   `json:"network_current_at"` // last time the status was read successfully
+	Backend         string      `json:"backend"`
+	Model           string      `json:"model"`
+	Spools          SpoolCounts `json:"spools"`
+}
+
+// SpoolCounts is what waits on disk for the network
+type SpoolCounts struct {
+	Heartbeats       int   `json:"heartbeats"`
+	DeliveryRetries  int   `json:"delivery_retries"`
+	DeadLetters      int   `json:"dead_letters"`
+	LogSpoolBytes    int64 `json:"log_spool_bytes"`
+	LogSpoolSegments int   `json:"log_spool_segments"`
+}
+
+func statusCachePath() string {
+	return filepath.Join(config().StateDir, "status.json")
+}
+
+// spoolCounts counts the spools
+func spoolCounts() SpoolCounts {
+	var c SpoolCounts
+	heartbeat.Lock()
+	c.Heartbeats = len(heartbeat.buffer)
+	heartbeat.Unlock()
+	deliveries.mu.Lock()
+	c.DeliveryRetries, c.DeadLetters = len(deliveries.retry), len(deliveries.dead)
+	deliveries.mu.Unlock()
+	entries, _ := os.ReadDir(logSpoolDir())
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			c.LogSpoolBytes += info.Size()
+			c.LogSpoolSegments++
+		}
+	}
+	return c
+}
+
+// currentSnapshot observes the node
+func currentSnapshot() StatusSnapshot {
+	status := getNodeStatus()
+	snap := StatusSnapshot{
+		NetworkStatus:   status.NetworkStatus,
+		NetworkProvider: networkProvider(config()),
+		Backend:         status.Backend,
+		Model:           activeModelVersion(),
+		Spools:          spoolCounts(),
+	}
+	switch {
+	case !status.StaleSince.IsZero():
+		snap.NetworkCurrent = status.StaleSince.UTC()
+	case status.NetworkStatus != "unknown":
+		snap.NetworkCurrent = time.Now().UTC()
+	}
+	return snap
+}
+
+// loadStatusCache restores the last saved status; it runs before anything
+// asks for the node's status
+func loadStatusCache() {
+	data, err := os.ReadFile(statusCachePath())
+	if err != nil {
+		return
+	}
+	var snap StatusSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Printf("Warning: ignoring corrupt status cache: %v", err)
+		return
+	}
+	log.Printf("Last status, saved %s ago: network %s, backend %s, model %s; %d heartbeats, %d delivery retries, %d dead letters and %d bytes of logs spooled",
+		time.Since(snap.SavedAt).Round(time.Second), snap.NetworkStatus, snap.Backend, snap.Model,
+		snap.Spools.Heartbeats, snap.Spools.DeliveryRetries, snap.Spools.DeadLetters, snap.Spools.LogSpoolBytes)
+
+	if snap.NetworkProvider == networkProviderKubernetes && networkProvider(config()) == networkProviderKubernetes &&
+		snap.NetworkStatus != "" && snap.NetworkStatus != "unknown" && !snap.NetworkCurrent.IsZero() {
+		nodeLabel.Lock()
+		nodeLabel.status, nodeLabel.staleSince = snap.NetworkStatus, snap.NetworkCurrent
+		nodeLabel.Unlock()
+	}
+}
+
+// startStatusCache saves the status as it changes
+func startStatusCache() {
+	go func() {
+		var last StatusSnapshot
+		for {
+			snap := currentSnapshot()
+			compare := snap
+			compare.SavedAt, compare.NetworkCurrent = last.SavedAt, last.NetworkCurrent
+			if !reflect.DeepEqual(compare, last) || time.Since(last.SavedAt) >= statusCacheRefresh {
+				snap.SavedAt = time.Now().UTC()
+				data, _ := json.MarshalIndent(snap, "", "  ")
+				if err := writeFileAtomic(statusCachePath(), data); err != nil {
+					log.Printf("Warning: failed to save the status cache: %v", err)
+				} else {
+					last = snap
+				}
+			}
+			time.Sleep(statusCacheInterval)
+		}
+	}()
+}
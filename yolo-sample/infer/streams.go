@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The event streams (GET /api/v1/events and /events/jobs/{id}) stay open for
+// as long as a client watches, which on an edge link may end without the
+// connection ever being closed. Each stream sends a comment every
+// STREAM_KEEPALIVE (default 15s) and gives every write STREAM_WRITE_TIMEOUT
+// (default 30s) to reach the client; a write that fails or times out, or the
+// client going away, ends the stream at once, releasing its subscription to
+// the event bus. yolo_active_streams counts the open streams and
+// yolo_streams_closed_total why they ended:
+//
+//	completed    the job being followed finished
+//	client_gone  the client closed the connection
+//	write_error  a write failed or the client stopped reading
+
+var (
+	activeStreams = newGaugeVec("yolo_active_streams",
+		"Event streams currently open, by stream.", "stream")
+	streamsClosed = newCounterVec("yolo_streams_closed_total",
+		"Event streams ended, by stream and reason.", "stream", "reason")
+)
+
+// eventStream is an open text/event-stream response
+type eventStream struct {
+	name string
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+}
+
+// openEventStream starts a text/event-stream response on w, counted as
+// name; it answers 500 and returns false when w cannot stream
+func openEventStream(w http.ResponseWriter, name string) (*eventStream, bool) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	activeStreams.add(1, name)
+	return &eventStream{name: name, w: w, rc: http.NewResponseController(w)}, true
+}
+
+// send writes to the client and flushes, within STREAM_WRITE_TIMEOUT
+func (s *eventStream) send(format string, args ...interface{}) error {
+	if timeout := config().StreamWriteTimeout; timeout > 0 {
+		// Writers without deadlines (ErrNotSupported) rely on the
+		// keepalives failing instead
+		s.rc.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// serve relays events to the client until handle reports the stream is
+// complete, a write fails, or the client goes away. handle writes an event
+// with send and returns whether more are to come.
+func (s *eventStream) serve(r *http.Request, events <-chan Event, handle func(Event) (bool, error)) {
+	keepalive := time.NewTicker(config().StreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			more, err := handle(ev)
+			if err != nil {
+				s.close("write_error")
+				return
+			}
+			if !more {
+				s.close("completed")
+				return
+			}
+		case <-keepalive.C:
+			if err := s.send(": keepalive\n\n"); err != nil {
+				s.close("write_error")
+				return
+			}
+		case <-r.Context().Done():
+			s.close("client_gone")
+			return
+		}
+	}
+}
+
+// close counts the stream as ended for reason
+func (s *eventStream) close(reason string) {
+	activeStreams.add(-1, s.name)
+	streamsClosed.inc(s.name, reason)
+}
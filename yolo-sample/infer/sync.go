@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Result sync uploads results produced since the last successful sync to a
+// central endpoint (SYNC_URL) whenever the node is online. Results stay on the
+// node, so an outage only delays sync; the watermark advances per batch.
+// Only metadata is sent: full-resolution images never leave the node, and
+// retention thumbnails are included only when SYNC_THUMBNAILS is set.
+
+// syncBatchSize bounds how many results are sent per request
+const syncBatchSize = 100
+
+var syncClient = &http.Client{Timeout: 30 * time.Second}
+
+// syncedResult is a result as sent to SYNC_URL
+type syncedResult struct {
+	InferenceResult
+	Thumbnail []byte `json:"thumbnail_jpeg,omitempty"` // base64 in JSON
+}
+
+type syncState struct {
+	LastSynced time.Time `json:"last_synced"` // CreatedAt of the newest result delivered
+}
+
+func syncStatePath() string {
+	return filepath.Join(config().StateDir, "sync-state.json")
+}
+
+func loadSyncState() syncState {
+	var st syncState
+	data, err := os.ReadFile(syncStatePath())
+	if err == nil {
+		json.Unmarshal(data, &st)
+	}
+	return st
+}
+
+func saveSyncState(st syncState) error {
+	data, _ := json.Marshal(st)
+	os.MkdirAll(config().StateDir, 0755)
+	return os.WriteFile(syncStatePath(), data, 0644)
+}
+
+// syncResults pushes unsynced results to SYNC_URL, oldest first
+func syncResults() (err error) {
+	if config().SyncURL == "" {
+		return fmt.Errorf("SYNC_URL is not configured")
+	}
+	if lowPower(lowPowerSync) {
+		return fmt.Errorf("deferred while the node is on battery")
+	}
+	if status := getNodeStatus(); status.NetworkStatus != "online" {
+		return fmt.Errorf("skipping sync while node is %s", status.NetworkStatus)
+	}
+
+	st := loadSyncState()
+	pending := results.listSince(st.LastSynced)
+	sent := 0
+	defer func() {
+		ev := SyncEvent{Sent: sent}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		bus.publish(eventSyncCompleted, ev)
+	}()
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > syncBatchSize {
+			batch = batch[:syncBatchSize]
+		}
+		pending = pending[len(batch):]
+
+		payload := make([]syncedResult, len(batch))
+		for i, res := range batch {
+			payload[i] = syncedResult{InferenceResult: res}
+			if config().SyncThumbnails && res.StoredImage != "" {
+				if path, err := derivativeFile(res, "small"); err == nil {
+					payload[i].Thumbnail, _ = readStoredFile(path)
+				}
+			}
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"node":    getEnv("NODE_NAME", "unknown"),
+			"results": payload,
+		})
+		if err != nil {
+			return err
+		}
+		req, err := newSyncRequest(body)
+		if err != nil {
+			return err
+		}
+		resp, err := syncClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("sync failed after %d results: %v", sent, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("sync failed after %d results: %s returned %s", sent, config().SyncURL, resp.Status)
+		}
+
+		st.LastSynced = batch[len(batch)-1].CreatedAt
+		if err := saveSyncState(st); err != nil {
+			log.Printf("Warning: failed to save sync state: %v", err)
+		}
+		sent += len(batch)
+	}
+	if sent > 0 {
+		log.Printf("Synced %d results to %s", sent, config().SyncURL)
+	}
+	return nil
+}
+
+// newSyncRequest builds the POST of a sync batch, gzip-compressed when SYNC_GZIP is set
+func newSyncRequest(body []byte) (*http.Request, error) {
+	if config().SyncGzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, config().SyncURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config().SyncGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
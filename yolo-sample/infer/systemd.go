@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+)
+
+// Outside Kubernetes the binary runs as a systemd service. `webui install`
+// writes the unit, and with -socket a socket unit, for the running binary:
+//
+//	sudo ./webui install -socket -env-file /etc/yolo-webui/env
+//	sudo systemctl daemon-reload && sudo systemctl enable --now yolo-webui.socket
+//
+// The service gets StateDirectory=<name>, so STATE_DIR, MODEL_DIR and
+// EVAL_ROOT default to /var/lib/<name>/{state,models,} instead of the
+// container paths (see dataDir), and reads its settings from the env file,
+// which install seeds with commented examples unless it exists. Kubernetes
+// specifics have stand-ins: NETWORK_STATUS_PROVIDER (see netstatus.go) and
+// TRAINING_COMMAND (see training.go).
+//
+// With socket activation systemd owns the listening socket (LISTEN_FDS), so
+// the port accepts connections while the service (re)starts, and a self-update
+// re-exec (see update.go) inherits the socket too.
+
+// sdListenFdsStart is the first file descriptor systemd passes
+const sdListenFdsStart = 3
+
+// activatedSocket holds the socket systemd passed; an *os.File closes its
+// descriptor when collected, which would break a re-exec
+var activatedSocket *os.File
+
+const serviceUnit = `[Unit]
+Description=YOLO edge inference web UI
+Wants=network-online.target
+After=network-online.target
+{{- if .Socket}}
+Requires={{.Name}}.socket
+After={{.Name}}.socket
+{{- end}}
+
+[Service]
+ExecStart={{.Binary}}
+EnvironmentFile=-{{.EnvFile}}
+StateDirectory={{.Name}}
+WorkingDirectory=/var/lib/{{.Name}}
+{{- if .User}}
+User={{.User}}
+{{- end}}
+Restart=always
+RestartSec=5
+TimeoutStopSec=30
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const socketUnit = `[Unit]
+Description=YOLO edge inference web UI socket
+
+[Socket]
+ListenStream={{.Listen}}
+
+[Install]
+WantedBy=sockets.target
+`
+
+const envFileTemplate = `# Settings for {{.Name}}.service; see BUILD.md for the full list
+#INFERENCE_COMMAND=python3 /opt/yolo/infer.py --serve --conf {{"{{"}}.Threshold{{"}}"}}
+#NETWORK_STATUS_PROVIDER=probe
+#NETWORK_PROBE_URL=https://example.com/
+#TRAINING_COMMAND=systemctl start --no-block edge-training.service
+#UPDATE_URL=https://releases.example.com/webui/latest.json
+#UPDATE_SIGNING_KEYS=/etc/yolo-webui/release.pub
+`
+
+// installOptions fill the unit templates
+type installOptions struct {
+	Name    string
+	Binary  string
+	EnvFile string
+	User    string
+	Socket  bool
+	Listen  string
+}
+
+// installFile is a file install writes
+type installFile struct {
+	path, tmpl string
+	mode       os.FileMode
+	keep       bool // leave an existing file alone
+}
+
+// runInstall implements `webui install`, returning the exit code
+func runInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	opts := installOptions{}
+	fs.StringVar(&opts.Name, "name", "yolo-webui", "unit name")
+	fs.StringVar(&opts.Binary, "binary", "", "binary to run (default: this one)")
+	fs.StringVar(&opts.EnvFile, "env-file", "/etc/yolo-webui/env", "environment file with the settings")
+	fs.StringVar(&opts.User, "user", "", "user to run as (default: root, which self-update and camera devices may need)")
+	fs.BoolVar(&opts.Socket, "socket", false, "also write a socket unit and let systemd own the port")
+	fs.StringVar(&opts.Listen, "listen", "6767", "ListenStream of the socket unit")
+	unitDir := fs.String("unit-dir", "/etc/systemd/system", "directory to write the units to")
+	dryRun := fs.Bool("print", false, "print the units instead of writing them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.Binary == "" {
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot locate this binary, pass -binary: %v\n", err)
+			return 1
+		}
+		opts.Binary = exe
+	}
+
+	files := []installFile{{filepath.Join(*unitDir, opts.Name+".service"), serviceUnit, 0644, false}}
+	if opts.Socket {
+		files = append(files, installFile{filepath.Join(*unitDir, opts.Name+".socket"), socketUnit, 0644, false})
+	}
+	files = append(files, installFile{opts.EnvFile, envFileTemplate, 0600, true})
+
+	for _, f := range files {
+		var buf bytes.Buffer
+		if err := template.Must(template.New("unit").Parse(f.tmpl)).Execute(&buf, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *dryRun {
+			fmt.Printf("# %s\n%s\n", f.path, buf.String())
+			continue
+		}
+		if _, err := os.Stat(f.path); err == nil && f.keep {
+			fmt.Printf("Kept %s\n", f.path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := os.WriteFile(f.path, buf.Bytes(), f.mode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", f.path)
+	}
+	if !*dryRun {
+		start := opts.Name + ".service"
+		if opts.Socket {
+			start = opts.Name + ".socket"
+		}
+		fmt.Printf("Now run: systemctl daemon-reload && systemctl enable --now %s\n", start)
+	}
+	return 0
+}
+
+// listenMain returns the socket systemd passed, or listens on addr; nil
+// with LISTEN_ADDR=none
+func listenMain(addr string) (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || n < 1 {
+		if addr == listenNone {
+			return nil, nil
+		}
+		return net.Listen("tcp", addr)
+	}
+	if n > 1 {
+		log.Printf("Warning: systemd passed %d sockets, serving on the first", n)
+	}
+	activatedSocket = os.NewFile(sdListenFdsStart, "systemd-socket")
+	l, err := net.FileListener(activatedSocket)
+	if err != nil {
+		return nil, fmt.Errorf("socket passed by systemd: %v", err)
+	}
+	log.Printf("Serving on the socket passed by systemd (%s)", l.Addr())
+	return l, nil
+}
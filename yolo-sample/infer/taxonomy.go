@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// TAXONOMY names a JSON file that groups model classes into labels, which may
+// themselves be grouped:
+//
+//	{
+//	  "vehicle":   ["car", "truck", "bus", "motorcycle"],
+//	  "animal":    ["dog", "cat", "bird"],
+//	  "road-user": ["vehicle", "person", "bicycle"]
+//	}
+//
+// Anywhere a class is accepted — stats (see stats.go), the results filter and
+// alert rules — a label at any level may be used instead and matches all the
+// classes beneath it. A label may have several parents, but not itself.
+
+type taxonomy struct {
+	children map[string][]string
+	parents  map[string][]string
+}
+
+var currentTaxonomy atomic.Pointer[taxonomy]
+
+// labels returns the taxonomy in effect, which is empty without TAXONOMY
+func labels() *taxonomy {
+	if t := currentTaxonomy.Load(); t != nil {
+		return t
+	}
+	return &taxonomy{children: map[string][]string{}, parents: map[string][]string{}}
+}
+
+// loadTaxonomy reads TAXONOMY; a broken file is logged and ignored
+func loadTaxonomy() {
+	if config().TaxonomyFile == "" {
+		return
+	}
+	t, err := readTaxonomy(config().TaxonomyFile)
+	if err != nil {
+		log.Printf("Warning: ignoring taxonomy %s: %v", config().TaxonomyFile, err)
+		return
+	}
+	currentTaxonomy.Store(t)
+	log.Printf("Loaded taxonomy with %d labels from %s", len(t.children), config().TaxonomyFile)
+}
+
+func readTaxonomy(path string) (*taxonomy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var children map[string][]string
+	if err := json.Unmarshal(data, &children); err != nil {
+		return nil, err
+	}
+	t := &taxonomy{children: children, parents: map[string][]string{}}
+	for parent, kids := range children {
+		for _, kid := range kids {
+			t.parents[kid] = append(t.parents[kid], parent)
+		}
+	}
+	for label := range children {
+		for _, a := range t.ancestors(label) {
+			if a == label {
+				return nil, fmt.Errorf("label %q contains itself", label)
+			}
+		}
+	}
+	return t, nil
+}
+
+// ancestors returns every label above class, each once
+func (t *taxonomy) ancestors(class string) []string {
+	var out []string
+	seen := map[string]bool{}
+	queue := append([]string{}, t.parents[class]...)
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		out = append(out, l)
+		queue = append(queue, t.parents[l]...)
+	}
+	return out
+}
+
+// matches reports whether class is label or lies beneath it
+func (t *taxonomy) matches(class, label string) bool {
+	if class == label {
+		return true
+	}
+	for _, a := range t.ancestors(class) {
+		if a == label {
+			return true
+		}
+	}
+	return false
+}
+
+// roots returns the labels that have no parent, sorted
+func (t *taxonomy) roots() []string {
+	var out []string
+	for l := range t.children {
+		if len(t.parents[l]) == 0 {
+			out = append(out, l)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hasMatch reports whether any detection of r falls under label
+func hasMatch(r InferenceResult, label string) bool {
+	for _, d := range r.Detections {
+		if labels().matches(d.ClassName, label) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The web UI can be white-labelled: BRAND_TITLE, BRAND_LOGO_URL and
+// BRAND_PRIMARY_COLOR are injected into every page, and a light/dark theme is
+// picked from the "theme" cookie (set by the header toggle) or THEME_DEFAULT.
+// Pages get the functions from themeFuncs via pageFuncs: {{brandTitle}} in <title>,
+// {{themeHead}} after their own styles, and {{themeHeader}} at the top of <body>.
+
+const themeCookie = "theme"
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// darkCSS restyles the pages' light palette
+const darkCSS = `
+body { background-color: #121212; color: #e0e0e0; }
+h1, h2, h3 { color: #f5f5f5; }
+.upload-form, .results, .panel, .map, .status-bar { background: #1e1e1e !important; color: #e0e0e0; box-shadow: none; }
+.detection { background-color: #1b2a1c; }
+.summary, .status-item { background: transparent; color: #e0e0e0; }
+.error { background-color: #3b1e1e; color: #ff8a80; }
+td, th { border-bottom-color: #333; }
+input, select, textarea { background: #2a2a2a; color: #e0e0e0; border: 1px solid #444; }
+svg { background-color: #263238; }
+`
+
+// brandPrimaryColor returns the configured accent colour, falling back to the default green
+func brandPrimaryColor() string {
+	if hexColorPattern.MatchString(config().BrandPrimaryColor) {
+		return config().BrandPrimaryColor
+	}
+	log.Printf("Warning: invalid BRAND_PRIMARY_COLOR %q, using default", config().BrandPrimaryColor)
+	return "#4CAF50"
+}
+
+// themeMode returns "light", "dark", or "auto" (follow the browser) for a request
+func themeMode(r *http.Request) string {
+	if c, err := r.Cookie(themeCookie); err == nil && (c.Value == "light" || c.Value == "dark") {
+		return c.Value
+	}
+	switch config().ThemeDefault {
+	case "dark", "auto":
+		return config().ThemeDefault
+	}
+	return "light"
+}
+
+// themeFuncs returns the template functions that inject branding and theme for r
+func themeFuncs(r *http.Request) template.FuncMap {
+	mode := themeMode(r)
+	return template.FuncMap{
+		"brandTitle": func() string { return config().BrandTitle },
+		"themeHead": func() template.HTML {
+			primary := brandPrimaryColor()
+			css := `button[type="submit"], .action-btn { background-color: ` + primary + `; }
+.detection { border-left-color: ` + primary + `; }
+a { color: ` + primary + `; }
+.brand { display: flex; align-items: center; justify-content: space-between; gap: 10px; margin-bottom: 10px; font-size: 14px; }
+.brand img { max-height: 40px; }
+.brand form { margin: 0; }
+.brand button { background: none; border: 1px solid #999; color: inherit; padding: 4px 10px; border-radius: 4px; cursor: pointer; font-size: 13px; }`
+			switch mode {
+			case "dark":
+				css += darkCSS
+			case "auto":
+				css += "@media (prefers-color-scheme: dark) {" + darkCSS + "}"
+			}
+			return template.HTML("<style>" + css + "</style>")
+		},
+		"themeHeader": func() template.HTML {
+			var b strings.Builder
+			b.WriteString(`<div class="brand"><a href="` + html.EscapeString(basePath(r)) + `/">`)
+			if config().BrandLogoURL != "" {
+				b.WriteString(`<img src="` + html.EscapeString(config().BrandLogoURL) + `" alt="` + html.EscapeString(config().BrandTitle) + `">`)
+			} else {
+				b.WriteString(html.EscapeString(config().BrandTitle))
+			}
+			label := "Dark mode"
+			if mode == "dark" {
+				label = "Light mode"
+			}
+			label = translate(negotiateLanguage(r), label)
+			b.WriteString(`</a><form method="post" action="` + html.EscapeString(basePath(r)) + `/theme">`)
+			if token := csrfToken(r); token != "" {
+				b.WriteString(`<input type="hidden" name="csrf_token" value="` + token + `">`)
+			}
+			b.WriteString(`<button type="submit">` + html.EscapeString(label) + `</button></form></div>`)
+			return template.HTML(b.String())
+		},
+	}
+}
+
+// themeHandler serves POST /theme, toggling the theme cookie and returning to the previous page
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	next := "light"
+	if themeMode(r) != "dark" {
+		next = "dark"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    next,
+		Path:     basePath(r) + "/",
+		MaxAge:   365 * 24 * 3600,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only redirect back to pages on this host
+	back := basePath(r) + "/"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && strings.HasPrefix(ref.Path, "/") {
+		back = ref.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jetson and Raspberry Pi class boards slow themselves down when they run hot
+// or short of power, and a node that keeps asking for full frame rates then
+// falls behind on everything at once. Every THERMAL_CHECK_INTERVAL (default
+// 10s) the node reads:
+//
+//	thermal zones  THERMAL_ZONE_DIR/thermal_zone*/temp (default /sys/class/thermal)
+//	Raspberry Pi   the firmware's throttled, frequency capped and under-voltage flags
+//	Jetson         the nvpmodel power mode, which is reported but never throttles
+//
+// The node counts as throttled while the firmware says so, or while a zone is
+// at THERMAL_THROTTLE_TEMP (°C; by default the zone's first passive trip point,
+// where the kernel itself starts slowing the CPU) until it has cooled
+// THERMAL_HYSTERESIS (default 5°C) below it. Meanwhile camera sources capture
+// at THERMAL_FPS_FACTOR (default 0.5) of their FPS, only THERMAL_WORKERS
+// (default 1) upload workers take jobs, and the UI shows a banner.
+// yolo_thermal_throttled is 1, changes publish node.throttle and the state is
+// reported at /api/v1/system.
+
+// Raspberry Pi firmware and Jetson power mode files
+const (
+	piThrottledPath   = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+	nvpmodelStatus    = "/var/lib/nvpmodel/status"
+	nvpmodelConfig    = "/etc/nvpmodel.conf"
+	piUnderVoltage    = 1 << 0
+	piFrequencyCapped = 1 << 1
+	piThrottled       = 1 << 2
+	piSoftTempLimit   = 1 << 3
+)
+
+// ThermalZone is one thermal zone reading
+type ThermalZone struct {
+	Zone    string  `json:"zone"`
+	Type    string  `json:"type"`
+	Celsius float64 `json:"celsius"`
+	TripAt  float64 `json:"trip_celsius,omitempty"` // first passive trip point
+}
+
+// ThermalStatus is the node's throttling state, the payload of node.throttle
+type ThermalStatus struct {
+	Throttled bool          `json:"throttled"`
+	Reason    string        `json:"reason,omitempty"`
+	Since     *time.Time    `json:"since,omitempty"`
+	Zones     []ThermalZone `json:"zones,omitempty"`
+	PowerMode string        `json:"power_mode,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+var thermal = struct {
+	sync.Mutex
+	status  ThermalStatus
+	checked bool
+	cooled  chan struct{} // closed when throttling ends
+}{}
+
+var (
+	thermalThrottled = newGaugeVec("yolo_thermal_throttled",
+		"1 while the node is throttled for heat or power.")
+	thermalZoneTemp = newGaugeVec("yolo_thermal_zone_celsius",
+		"Temperature of a thermal zone.", "zone", "type")
+)
+
+func thermalView() ThermalStatus {
+	thermal.Lock()
+	defer thermal.Unlock()
+	return thermal.status
+}
+
+// throttled reports whether the node is currently throttled
+func throttled() bool {
+	thermal.Lock()
+	defer thermal.Unlock()
+	return thermal.status.Throttled
+}
+
+// thermalFPS is the rate a camera source of fps captures at
+func thermalFPS(fps float64) float64 {
+	if throttled() {
+		return fps * config().ThermalFPSFactor
+	}
+	return fps
+}
+
+// thermalWait holds upload worker n (counting from 0) while the node is
+// throttled and n is not one of the first THERMAL_WORKERS
+func thermalWait(n int) {
+	for {
+		thermal.Lock()
+		hot, cooled := thermal.status.Throttled, thermal.cooled
+		thermal.Unlock()
+		if !hot || n < config().ThermalWorkers {
+			return
+		}
+		select {
+		case <-cooled:
+		case <-time.After(time.Minute): // THERMAL_WORKERS may have been reloaded
+		}
+	}
+}
+
+// startThermalCheck checks the node now and then every THERMAL_CHECK_INTERVAL
+func startThermalCheck() {
+	go func() {
+		for {
+			checkThermal()
+			interval := config().ThermalCheckInterval
+			if interval <= 0 {
+				interval = 10 * time.Second
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func checkThermal() {
+	cfg := config()
+	st := ThermalStatus{CheckedAt: time.Now().UTC(), PowerMode: jetsonPowerMode()}
+	was := throttled()
+
+	for _, z := range readThermalZones(cfg.ThermalZoneDir) {
+		st.Zones = append(st.Zones, z)
+		thermalZoneTemp.set(z.Celsius, z.Zone, z.Type)
+		limit := cfg.ThermalThrottleTemp
+		if limit == 0 {
+			limit = z.TripAt
+		}
+		if limit <= 0 {
+			continue
+		}
+		if was {
+			limit -= cfg.ThermalHysteresis
+		}
+		if z.Celsius >= limit && st.Reason == "" {
+			st.Reason = fmt.Sprintf("%s at %.0f°C", z.Type, z.Celsius)
+		}
+	}
+	if flags, err := readPiThrottled(); err == nil && st.Reason == "" {
+		st.Reason = piThrottleReason(flags)
+	}
+	st.Throttled = st.Reason != ""
+
+	thermal.Lock()
+	first := !thermal.checked
+	changed := st.Throttled != thermal.status.Throttled
+	if st.Throttled {
+		since := st.CheckedAt
+		if thermal.status.Since != nil {
+			since = *thermal.status.Since
+		}
+		st.Since = &since
+		if changed {
+			thermal.cooled = make(chan struct{})
+		}
+	} else if changed {
+		close(thermal.cooled)
+	}
+	thermal.status, thermal.checked = st, true
+	thermal.Unlock()
+
+	if st.Throttled {
+		thermalThrottled.set(1)
+	} else {
+		thermalThrottled.set(0)
+	}
+	if first && st.PowerMode != "" {
+		log.Printf("Power mode: %s", st.PowerMode)
+	}
+	if changed {
+		if st.Throttled {
+			log.Printf("Warning: node is throttled (%s); reducing camera frame rates to %g× and upload workers to %d",
+				st.Reason, cfg.ThermalFPSFactor, cfg.ThermalWorkers)
+		} else {
+			log.Printf("Node is no longer throttled")
+		}
+		sources.restartAll() // at the new frame rates
+		bus.publish(eventThrottle, st)
+	}
+}
+
+// readThermalZones reads the zones of a sysfs thermal class directory
+func readThermalZones(dir string) []ThermalZone {
+	if dir == "" {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "thermal_zone*"))
+	sort.Strings(paths)
+	var zones []ThermalZone
+	for _, path := range paths {
+		milli, err := readSysfsInt(filepath.Join(path, "temp"))
+		if err != nil || milli <= -100000 {
+			continue // disabled zones fail to read or report nonsense
+		}
+		z := ThermalZone{Zone: filepath.Base(path), Type: filepath.Base(path), Celsius: float64(milli) / 1000}
+		if data, err := os.ReadFile(filepath.Join(path, "type")); err == nil {
+			z.Type = strings.TrimSpace(string(data))
+		}
+		trips, _ := filepath.Glob(filepath.Join(path, "trip_point_*_type"))
+		for _, trip := range trips {
+			if data, err := os.ReadFile(trip); err != nil || strings.TrimSpace(string(data)) != "passive" {
+				continue
+			}
+			t, err := readSysfsInt(strings.TrimSuffix(trip, "_type") + "_temp")
+			if err == nil && t > 0 && (z.TripAt == 0 || float64(t)/1000 < z.TripAt) {
+				z.TripAt = float64(t) / 1000
+			}
+		}
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+func readSysfsInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readPiThrottled reads the Raspberry Pi firmware's throttling flags
+func readPiThrottled() (uint64, error) {
+	data, err := os.ReadFile(piThrottledPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 32)
+}
+
+// piThrottleReason describes the flags that are active now; the bits above
+// 16 only say what has happened since boot
+func piThrottleReason(flags uint64) string {
+	var reasons []string
+	if flags&piUnderVoltage != 0 {
+		reasons = append(reasons, "under-voltage")
+	}
+	if flags&(piThrottled|piFrequencyCapped) != 0 {
+		reasons = append(reasons, "firmware throttling")
+	}
+	if flags&piSoftTempLimit != 0 {
+		reasons = append(reasons, "soft temperature limit")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+var nvpmodelName = regexp.MustCompile(`POWER_MODEL\s+ID=(\d+)\s+NAME=(\S+)`)
+
+// jetsonPowerMode returns the nvpmodel power mode, e.g. "15W (1)", or ""
+func jetsonPowerMode() string {
+	data, err := os.ReadFile(nvpmodelStatus)
+	if err != nil {
+		return ""
+	}
+	// "pmode:0001 fmode:quiet"
+	var id int
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "pmode:%d", &id); err != nil {
+		return ""
+	}
+	if conf, err := os.ReadFile(nvpmodelConfig); err == nil {
+		for _, m := range nvpmodelName.FindAllStringSubmatch(string(conf), -1) {
+			if n, _ := strconv.Atoi(m[1]); n == id {
+				return fmt.Sprintf("%s (%d)", m[2], id)
+			}
+		}
+	}
+	return strconv.Itoa(id)
+}
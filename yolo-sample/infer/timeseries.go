@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The node keeps its own time series of inference activity, so the dashboard
+// can chart it at sites with no Prometheus to scrape /metrics. Every
+// inference is counted, per source, into minute, hour and day buckets, kept
+// for TIMESERIES_MINUTE_RETENTION (default 48h), TIMESERIES_HOUR_RETENTION
+// (720h) and TIMESERIES_DAY_RETENTION (8760h):
+//
+//	GET /api/v1/timeseries?resolution=hour&from=...&to=...&source=dock
+//
+// returns one point per bucket from from to to, empty buckets included;
+// source defaults to all sources summed, from to 60 buckets before to, to to
+// now. The series are saved to STATE_DIR/timeseries.json every minute. The
+// /dashboard page charts them.
+
+// Time-series resolutions
+const (
+	resolutionMinute = "minute"
+	resolutionHour   = "hour"
+	resolutionDay    = "day"
+)
+
+// maxTimeseriesPoints bounds the buckets of one query
+const maxTimeseriesPoints = 3000
+
+var resolutionStep = map[string]time.Duration{
+	resolutionMinute: time.Minute,
+	resolutionHour:   time.Hour,
+	resolutionDay:    24 * time.Hour,
+}
+
+// tsBucket is the activity of one source in one bucket
+type tsBucket struct {
+	Inferences int            `json:"inferences"`
+	Errors     int            `json:"errors"`
+	Detections int            `json:"detections"`
+	LatencySum float64        `json:"latency_sum_ms"`
+	LatencyMax float64        `json:"latency_max_ms"`
+	Classes    map[string]int `json:"classes,omitempty"`
+}
+
+func (b *tsBucket) merge(o *tsBucket) {
+	b.Inferences += o.Inferences
+	b.Errors += o.Errors
+	b.Detections += o.Detections
+	b.LatencySum += o.LatencySum
+	if o.LatencyMax > b.LatencyMax {
+		b.LatencyMax = o.LatencyMax
+	}
+	for class, n := range o.Classes {
+		if b.Classes == nil {
+			b.Classes = map[string]int{}
+		}
+		b.Classes[class] += n
+	}
+}
+
+// TimeseriesPoint is one bucket of /api/v1/timeseries
+type TimeseriesPoint struct {
+	Time         time.Time      `json:"time"`
+	Inferences   int            `json:"inferences"`
+	Errors       int            `json:"errors"`
+	Detections   int            `json:"detections"`
+	LatencyAvgMS float64        `json:"latency_avg_ms"`
+	LatencyMaxMS float64        `json:"latency_max_ms"`
+	Classes      map[string]int `json:"classes,omitempty"`
+}
+
+// series maps resolution, then source, then bucket start (Unix seconds)
+type series map[string]map[string]map[int64]*tsBucket
+
+var timeseries = struct {
+	sync.Mutex
+	data  series
+	dirty bool
+}{data: series{}}
+
+func timeseriesPath() string {
+	return filepath.Join(config().StateDir, "timeseries.json")
+}
+
+// bucketStart truncates t to its bucket; days start at local midnight
+func bucketStart(t time.Time, resolution string) time.Time {
+	if resolution == resolutionDay {
+		t = t.Local()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return t.Truncate(resolutionStep[resolution])
+}
+
+// startTimeseries loads the saved series and saves them every minute
+func startTimeseries() {
+	if data, err := os.ReadFile(timeseriesPath()); err == nil {
+		timeseries.Lock()
+		if err := json.Unmarshal(data, &timeseries.data); err != nil {
+			log.Printf("Warning: ignoring unreadable %s: %v", timeseriesPath(), err)
+			timeseries.data = series{}
+		}
+		timeseries.Unlock()
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			saveTimeseries()
+		}
+	}()
+}
+
+// observeTimeseries counts one inference; see observeInference
+func observeTimeseries(result InferenceResult, seconds float64) {
+	now := time.Now()
+	b := tsBucket{Inferences: 1, LatencySum: seconds * 1000, LatencyMax: seconds * 1000}
+	if result.Error != "" {
+		b.Errors = 1
+	}
+	for _, d := range result.Detections {
+		if b.Classes == nil {
+			b.Classes = map[string]int{}
+		}
+		b.Detections++
+		b.Classes[d.ClassName]++
+	}
+	source := result.Source
+	if source == "" {
+		source = "upload"
+	}
+	timeseries.Lock()
+	defer timeseries.Unlock()
+	for resolution := range resolutionStep {
+		if timeseries.data[resolution] == nil {
+			timeseries.data[resolution] = map[string]map[int64]*tsBucket{}
+		}
+		buckets := timeseries.data[resolution][source]
+		if buckets == nil {
+			buckets = map[int64]*tsBucket{}
+			timeseries.data[resolution][source] = buckets
+		}
+		at := bucketStart(now, resolution).Unix()
+		if buckets[at] == nil {
+			buckets[at] = &tsBucket{}
+		}
+		buckets[at].merge(&b)
+	}
+	timeseries.dirty = true
+}
+
+func timeseriesRetention(cfg *Config, resolution string) time.Duration {
+	switch resolution {
+	case resolutionMinute:
+		return cfg.TimeseriesMinuteRetention
+	case resolutionHour:
+		return cfg.TimeseriesHourRetention
+	}
+	return cfg.TimeseriesDayRetention
+}
+
+// saveTimeseries drops buckets past their retention and writes the series
+func saveTimeseries() {
+	cfg := config()
+	now := time.Now()
+	timeseries.Lock()
+	defer timeseries.Unlock()
+	for resolution, bySource := range timeseries.data {
+		cutoff := now.Add(-timeseriesRetention(cfg, resolution)).Unix()
+		for source, buckets := range bySource {
+			for at := range buckets {
+				if at < cutoff {
+					delete(buckets, at)
+					timeseries.dirty = true
+				}
+			}
+			if len(buckets) == 0 {
+				delete(bySource, source)
+			}
+		}
+	}
+	if !timeseries.dirty {
+		return
+	}
+	data, err := json.Marshal(timeseries.data)
+	if err == nil {
+		err = writeFileAtomic(timeseriesPath(), data)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save time series: %v", err)
+		return
+	}
+	timeseries.dirty = false
+}
+
+// validateTimeseries checks the retention settings
+func validateTimeseries(cfg *Config) error {
+	for _, s := range []struct {
+		name string
+		d    time.Duration
+		min  time.Duration
+	}{
+		{"TIMESERIES_MINUTE_RETENTION", cfg.TimeseriesMinuteRetention, time.Hour},
+		{"TIMESERIES_HOUR_RETENTION", cfg.TimeseriesHourRetention, 24 * time.Hour},
+		{"TIMESERIES_DAY_RETENTION", cfg.TimeseriesDayRetention, 7 * 24 * time.Hour},
+	} {
+		if s.d < s.min {
+			return fmt.Errorf("%s must be at least %s", s.name, s.min)
+		}
+	}
+	return nil
+}
+
+// queryTimeseries returns one point per bucket of resolution in [from, to],
+// for source or, when it is empty, every source summed
+func queryTimeseries(resolution, source string, from, to time.Time) []TimeseriesPoint {
+	timeseries.Lock()
+	sums := map[int64]*tsBucket{}
+	for s, buckets := range timeseries.data[resolution] {
+		if source != "" && s != source {
+			continue
+		}
+		for at, b := range buckets {
+			if sums[at] == nil {
+				sums[at] = &tsBucket{}
+			}
+			sums[at].merge(b)
+		}
+	}
+	timeseries.Unlock()
+
+	var points []TimeseriesPoint
+	for t := bucketStart(from, resolution); !t.After(to); t = nextBucket(t, resolution) {
+		p := TimeseriesPoint{Time: t.UTC()}
+		if b := sums[t.Unix()]; b != nil {
+			p.Inferences, p.Errors, p.Detections = b.Inferences, b.Errors, b.Detections
+			p.LatencyMaxMS, p.Classes = b.LatencyMax, b.Classes
+			if b.Inferences > 0 {
+				p.LatencyAvgMS = b.LatencySum / float64(b.Inferences)
+			}
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// nextBucket steps by calendar day at day resolution, so DST changes keep
+// buckets on midnight
+func nextBucket(t time.Time, resolution string) time.Time {
+	if resolution == resolutionDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(resolutionStep[resolution])
+}
+
+// timeseriesSources lists the sources with any data
+func timeseriesSources() []string {
+	timeseries.Lock()
+	defer timeseries.Unlock()
+	seen := map[string]bool{}
+	for _, bySource := range timeseries.data {
+		for s := range bySource {
+			seen[s] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for s := range seen {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// timeseriesHandler serves GET /api/v1/timeseries
+func timeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	resolution := q.Get("resolution")
+	if resolution == "" {
+		resolution = resolutionMinute
+	}
+	step, ok := resolutionStep[resolution]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "resolution must be minute, hour or day")
+		return
+	}
+	to, from := time.Now(), time.Time{}
+	for key, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := q.Get(key); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, key+" must be an RFC 3339 time")
+				return
+			}
+			*t = parsed
+		}
+	}
+	if from.IsZero() {
+		from = to.Add(-59 * step)
+	}
+	if from.After(to) {
+		writeJSONError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	if to.Sub(from)/step >= maxTimeseriesPoints {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %d %s buckets per query", maxTimeseriesPoints, resolution))
+		return
+	}
+	source := q.Get("source")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resolution": resolution,
+		"source":     source,
+		"from":       bucketStart(from, resolution).UTC(),
+		"to":         to.UTC(),
+		"retention":  timeseriesRetention(config(), resolution).String(),
+		"points":     queryTimeseries(resolution, source, from, to),
+		"sources":    timeseriesSources(),
+	})
+}
+
+// dashboardHandler serves GET /dashboard, charts of the time series
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <title>{{t "Dashboard"}} - {{brandTitle}}</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            max-width: 1100px;
+            margin: 50px auto;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        h1 {
+            color: #333;
+        }
+        .panel {
+            background: white;
+            padding: 20px;
+            margin-bottom: 20px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .filters select {
+            padding: 6px;
+            margin-right: 10px;
+        }
+        svg {
+            width: 100%;
+            height: 180px;
+        }
+        .axis {
+            font-size: 10px;
+            fill: #777;
+        }
+        .legend span {
+            margin-right: 15px;
+            font-size: 13px;
+        }
+    </style>
+    {{themeHead}}
+    {{pwaHead}}
+</head>
+<body>
+    {{themeHeader}}
+    <h1>{{t "Dashboard"}}</h1>
+    <div class="panel filters">
+        <label>{{t "Range"}}
+            <select id="range">
+                <option value="minute:60">{{t "Last hour"}}</option>
+                <option value="hour:24" selected>{{t "Last 24 hours"}}</option>
+                <option value="hour:168">{{t "Last 7 days"}}</option>
+                <option value="day:30">{{t "Last 30 days"}}</option>
+                <option value="day:365">{{t "Last year"}}</option>
+            </select>
+        </label>
+        <label>{{t "Source"}}
+            <select id="source"><option value="">{{t "All"}}</option></select>
+        </label>
+    </div>
+    <div class="panel">
+        <h2>{{t "Detections"}}</h2>
+        <svg id="detections"></svg>
+    </div>
+    <div class="panel">
+        <h2>{{t "Inferences"}}</h2>
+        <div class="legend"><span style="color:#1976d2">■ {{t "Inferences"}}</span><span style="color:#d32f2f">■ {{t "Errors"}}</span></div>
+        <svg id="inferences"></svg>
+    </div>
+    <div class="panel">
+        <h2>{{t "Latency (ms)"}}</h2>
+        <div class="legend"><span style="color:#4CAF50">■ {{t "Average"}}</span><span style="color:#FF9800">■ {{t "Maximum"}}</span></div>
+        <svg id="latency"></svg>
+    </div>
+    <a href="{{base}}/">{{t "← Back to Upload"}}</a>
+    <script nonce="{{cspNonce}}">
+        const NS = 'http://www.w3.org/2000/svg';
+        const rangeEl = document.getElementById('range');
+        const sourceEl = document.getElementById('source');
+
+        function el(name, attrs, text) {
+            const e = document.createElementNS(NS, name);
+            Object.entries(attrs).forEach(([k, v]) => e.setAttribute(k, v));
+            if (text !== undefined) e.textContent = text;
+            return e;
+        }
+
+        // chart draws each series as bars (first) or lines (the rest)
+        function chart(id, points, series, bars) {
+            const svg = document.getElementById(id);
+            const w = svg.clientWidth, h = svg.clientHeight, left = 40, bottom = 18;
+            svg.textContent = '';
+            svg.setAttribute('viewBox', '0 0 ' + w + ' ' + h);
+            const max = Math.max(1, ...points.flatMap(p => series.map(s => s.value(p))));
+            const x = i => left + (w - left) * i / Math.max(1, points.length);
+            const y = v => (h - bottom) * (1 - v / max);
+            [0, max / 2, max].forEach(v => {
+                svg.appendChild(el('line', {x1: left, x2: w, y1: y(v), y2: y(v), stroke: '#eee'}));
+                svg.appendChild(el('text', {x: 0, y: y(v) + 4, class: 'axis'}, Math.round(v)));
+            });
+            series.forEach((s, n) => {
+                if (bars && n === 0) {
+                    const bw = Math.max(1, (w - left) / Math.max(1, points.length) - 1);
+                    points.forEach((p, i) => {
+                        const v = s.value(p);
+                        if (v > 0) svg.appendChild(el('rect', {x: x(i), y: y(v), width: bw, height: h - bottom - y(v), fill: s.color}));
+                    });
+                } else {
+                    const d = points.map((p, i) => (i ? 'L' : 'M') + (x(i) + 1) + ',' + y(s.value(p))).join(' ');
+                    svg.appendChild(el('path', {d: d, fill: 'none', stroke: s.color, 'stroke-width': 1.5}));
+                }
+            });
+            [0, Math.floor(points.length / 2), points.length - 1].forEach(i => {
+                if (!points[i]) return;
+                const t = new Date(points[i].time);
+                const label = rangeEl.value.startsWith('day') ? t.toLocaleDateString() : t.toLocaleString([], {month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit'});
+                svg.appendChild(el('text', {x: Math.min(x(i), w - 90), y: h - 4, class: 'axis'}, label));
+            });
+        }
+
+        async function load() {
+            const [resolution, buckets] = rangeEl.value.split(':');
+            const step = {minute: 60e3, hour: 3600e3, day: 86400e3}[resolution];
+            const p = new URLSearchParams({resolution: resolution, from: new Date(Date.now() - (buckets - 1) * step).toISOString().replace(/\.\d+Z$/, 'Z')});
+            if (sourceEl.value) p.set('source', sourceEl.value);
+            const resp = await fetch({{base}} + '/api/v1/timeseries?' + p);
+            if (!resp.ok) return;
+            const data = await resp.json();
+            const current = sourceEl.value;
+            sourceEl.length = 1;
+            data.sources.forEach(s => sourceEl.add(new Option(s, s, false, s === current)));
+            chart('detections', data.points, [{value: p => p.detections, color: '#667eea'}], true);
+            chart('inferences', data.points, [
+                {value: p => p.inferences, color: '#1976d2'}, {value: p => p.errors, color: '#d32f2f'}
+            ], true);
+            chart('latency', data.points, [
+                {value: p => p.latency_max_ms, color: '#FF9800'}, {value: p => p.latency_avg_ms, color: '#4CAF50'}
+            ], false);
+        }
+        rangeEl.addEventListener('change', load);
+        sourceEl.addEventListener('change', load);
+        setInterval(load, 60000);
+        load();
+    </script>
+    {{buildFooter}}
+</body>
+</html>
+`
+	t, err := template.New("dashboard").Funcs(pageFuncs(r)).Parse(tmpl)
+	if err != nil {
+		log.Printf("Template parse error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, nil)
+}
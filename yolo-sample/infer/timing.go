@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// ResultTiming breaks down where the time to a result went, in milliseconds:
+//
+//	upload       receiving the image (form upload, URL fetch, resumable upload)
+//	queue_wait   waiting in the upload queue for an inference worker
+//	preprocess   drift statistics, before hooks and the model's pre-processing
+//	inference    the model, including the round trip to the inference process
+//	postprocess  the model's post-processing, options, zones, after hooks,
+//	             metadata and storing the image
+//
+// The model's own stages come from the "speed" the inference command reports
+// (see infer.py); without it the whole call counts as inference. Results of
+// camera sources and batch runs have no upload or queue stage.
+type ResultTiming struct {
+	UploadMS      float64 `json:"upload_ms,omitempty"`
+	QueueWaitMS   float64 `json:"queue_wait_ms,omitempty"`
+	PreprocessMS  float64 `json:"preprocess_ms"`
+	InferenceMS   float64 `json:"inference_ms"`
+	PostprocessMS float64 `json:"postprocess_ms"`
+	TotalMS       float64 `json:"total_ms"`
+}
+
+// modelSpeed is the per-stage time the inference command reports, in ms
+type modelSpeed struct {
+	Preprocess  float64 `json:"preprocess"`
+	Inference   float64 `json:"inference"`
+	Postprocess float64 `json:"postprocess"`
+}
+
+// millis converts a duration to milliseconds with microsecond resolution
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// split attributes an inference call that took call to the timing stages,
+// moving the model's reported pre- and post-processing out of inference
+func (t *ResultTiming) split(call time.Duration, speed *modelSpeed) {
+	t.InferenceMS = millis(call)
+	if speed == nil {
+		return
+	}
+	model := speed.Preprocess + speed.Postprocess
+	if model > t.InferenceMS {
+		return
+	}
+	t.PreprocessMS += speed.Preprocess
+	t.PostprocessMS += speed.Postprocess
+	t.InferenceMS -= model
+}
+
+// total sums the stages, rounding each to microseconds
+func (t *ResultTiming) total() {
+	for _, v := range []*float64{&t.UploadMS, &t.QueueWaitMS, &t.PreprocessMS, &t.InferenceMS, &t.PostprocessMS} {
+		*v = math.Round(*v*1000) / 1000
+	}
+	t.TotalMS = math.Round((t.UploadMS+t.QueueWaitMS+t.PreprocessMS+t.InferenceMS+t.PostprocessMS)*1000) / 1000
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// trainingCommandData is passed to the TRAINING_COMMAND template, for example
+//
+//	systemctl start --no-block edge-training@{{.Reason}}.service
+type trainingCommandData struct {
+	Job    string // the name a Kubernetes Job would get
+	Reason string // "manual", "scheduled", ...
+}
+
+// triggerTraining creates a one-off Job from the suspended training CronJob,
+// the same as `kubectl create job --from=cronjob/edge-training-job`; outside
+// Kubernetes TRAINING_COMMAND runs instead. The Job is placed on a node that
+// can train; see placement.go.
+// Training needs gateway connectivity, so it is refused unless the node is online.
+func triggerTraining(reason string) (string, error) {
+	if lowPower(lowPowerTraining) {
+		return "", fmt.Errorf("training is deferred while the node is on battery")
+	}
+	status := getNodeStatus()
+	if !status.TrainingEnabled {
+		return "", fmt.Errorf("training requires an online node (network status: %s)", status.NetworkStatus)
+	}
+
+	jobName := fmt.Sprintf("%s-%s-%d", config().TrainingCronJob, reason, time.Now().Unix())
+	if len(jobName) > 63 {
+		jobName = jobName[len(jobName)-63:]
+		jobName = strings.TrimLeft(jobName, "-")
+	}
+
+	if tmpl := config().TrainingCommand; tmpl != "" {
+		args, err := renderCommand(tmpl, trainingCommandData{Job: jobName, Reason: reason})
+		if err != nil {
+			return "", fmt.Errorf("TRAINING_COMMAND: %v", err)
+		}
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("training command failed: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		log.Printf("Started training %s with TRAINING_COMMAND (%s)", jobName, reason)
+		return jobName, nil
+	}
+
+	if config().TrainingPlacement {
+		if err := placeTrainingJob(jobName, reason); err != nil {
+			return "", err
+		}
+		log.Printf("Triggered training job %s (%s)", jobName, reason)
+		return jobName, nil
+	}
+	args := []string{"create", "job", "--from=cronjob/" + config().TrainingCronJob, jobName}
+	if config().Namespace != "" {
+		args = append(args, "-n", config().Namespace)
+	}
+	cmd := exec.Command("kubectl", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("kubectl create job failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	log.Printf("Triggered training job %s (%s)", jobName, reason)
+	return jobName, nil
+}
+
+// trainingHandler serves the training API
+//
+//	GET  /api/v1/training  recent training jobs and where they were placed
+//	POST /api/v1/training  trigger a manual training job
+func trainingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, listTrainingJobs())
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	job, err := triggerTraining("manual")
+	if err != nil {
+		status := http.StatusBadGateway
+		if !getNodeStatus().TrainingEnabled || errors.Is(err, errNoTrainingNode) {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"job": job})
+}
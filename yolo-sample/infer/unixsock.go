@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Agents on the same device can reach the API over a unix socket instead of
+// the network. UNIX_SOCKET is a path, created with UNIX_SOCKET_MODE (default
+// 0660) and, with UNIX_SOCKET_GROUP set, owned by that group, so file
+// permissions decide who may connect; or "@name" for a Linux abstract socket,
+// which has no permissions and is reachable from anything in the node's
+// network namespace. It serves the same API as the TCP port and
+// LISTEN_ADDR=none closes the latter, for hardened devices with no open ports:
+//
+//	UNIX_SOCKET=/run/yolo/api.sock UNIX_SOCKET_GROUP=edge-agents LISTEN_ADDR=none
+//	curl --unix-socket /run/yolo/api.sock http://localhost/api/v1/system
+
+// listenNone disables the TCP listener
+const listenNone = "none"
+
+// startUnixListener serves h on UNIX_SOCKET, if set
+func startUnixListener(h http.Handler) error {
+	cfg := config()
+	if cfg.UnixSocket == "" {
+		return nil
+	}
+	l, err := listenUnix(cfg)
+	if err != nil {
+		return fmt.Errorf("UNIX_SOCKET %s: %v", cfg.UnixSocket, err)
+	}
+	go func() {
+		log.Printf("Serving on unix socket %s", cfg.UnixSocket)
+		if err := newHTTPServer(h).Serve(l); err != nil {
+			log.Printf("Warning: unix socket %s stopped: %v", cfg.UnixSocket, err)
+		}
+	}()
+	return nil
+}
+
+func listenUnix(cfg *Config) (net.Listener, error) {
+	path := cfg.UnixSocket
+	if strings.HasPrefix(path, "@") {
+		return net.Listen("unix", path)
+	}
+	mode, gid, err := unixSocketOwnership(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("exists and is not a socket")
+		}
+		// Left behind by a previous run that did not shut down cleanly
+		os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Created owner-only, then opened up to UNIX_SOCKET_MODE, so nobody can
+	// connect in between
+	old := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// unixSocketOwnership parses UNIX_SOCKET_MODE and UNIX_SOCKET_GROUP; gid is
+// -1 without a group
+func unixSocketOwnership(cfg *Config) (os.FileMode, int, error) {
+	mode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, 0, fmt.Errorf("UNIX_SOCKET_MODE must be an octal mode such as 0660")
+	}
+	gid := -1
+	if cfg.UnixSocketGroup != "" {
+		g, err := user.LookupGroup(cfg.UnixSocketGroup)
+		if err != nil {
+			g, err = user.LookupGroupId(cfg.UnixSocketGroup)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("UNIX_SOCKET_GROUP: unknown group %s", cfg.UnixSocketGroup)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return os.FileMode(mode), gid, nil
+}
+
+// validateListeners checks the listener settings
+func validateListeners(cfg *Config) error {
+	if cfg.ListenAddr == listenNone && cfg.UnixSocket == "" {
+		return fmt.Errorf("LISTEN_ADDR none needs UNIX_SOCKET")
+	}
+	if cfg.UnixSocket != "" && !strings.HasPrefix(cfg.UnixSocket, "@") {
+		if _, _, err := unixSocketOwnership(cfg); err != nil {
+			return err
+		}
+	}
+	return validateHTTPServer(cfg)
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Bare-metal installs, where no Kubernetes deployment rolls out new images,
+// can update the binary themselves. With UPDATE_URL set, the self-update task
+// (SCHEDULE_SELF_UPDATE, daily at 04:00 by default) GETs the release manifest
+// there while the node is online:
+//
+//	{"version": "1.5.0",
+//	 "binaries": {"linux/amd64": "webui-1.5.0-linux-amd64", "linux/arm64": "webui-1.5.0-linux-arm64"},
+//	 "checksums": "<contents of SHA256SUMS>",
+//	 "signature": "<base64 signature of the version line and checksums>"}
+//
+// Binary URLs may be relative to UPDATE_URL. checksums is sha256sum output.
+// The signature, by a key in UPDATE_SIGNING_KEYS (the MODEL_SIGNING_KEYS
+// formats), covers the line "version <version>" followed by the checksums:
+//
+//	(echo "version 1.5.0"; cat SHA256SUMS) > release.txt
+//	cosign sign-blob --output-signature release.sig release.txt
+//
+// This platform's binary must be named webui-<version>-<os>-<arch> and
+// appear in the checksums. Only a newer version than the running one is
+// installed (numerically, 1.10.0 after 1.9.2; a release after its -rc
+// pre-releases; a dev build takes any release), so a replayed older release
+// cannot downgrade the node. A release of the running version is left alone.
+//
+// The download is written next to the executable, checked against its
+// checksum and swapped in; the previous binary stays as <executable>.old for
+// a manual rollback. UPDATE_RESTART decides how the new binary starts:
+//
+//	exec  (default) wait up to UPDATE_DRAIN_TIMEOUT for running inference to
+//	      finish, stop the inference process and re-exec in place; queued
+//	      uploads are picked up again (see jobstore.go)
+//	exit  drain the same way and exit, for an init system that restarts the
+//	      service (systemd Restart=always)
+//	flag  keep running and write the new version to UPDATE_FLAG_FILE (default
+//	      STATE_DIR/update-pending) for the init system or an operator to act
+//	      on; the file is removed once that version runs
+//
+// Self-update refuses to run under Kubernetes, where the image is what gets
+// deployed. The state is part of /api/v1/system and changes publish
+// update.status.
+
+// Restart modes
+const (
+	updateRestartExec = "exec"
+	updateRestartExit = "exit"
+	updateRestartFlag = "flag"
+)
+
+// updateMaxManifest and updateMaxBinary bound the release downloads
+const (
+	updateMaxManifest = 1 << 20
+	updateMaxBinary   = 1 << 30
+)
+
+// releaseManifest is what UPDATE_URL serves
+type releaseManifest struct {
+	Version   string            `json:"version"`
+	Binaries  map[string]string `json:"binaries"` // "os/arch" to binary URL
+	Checksums string            `json:"checksums"`
+	Signature string            `json:"signature"`
+}
+
+// UpdateView is the self-update state, the payload of update.status
+type UpdateView struct {
+	State     string     `json:"state"` // "disabled", "idle", "up-to-date", "staged", "restarting" or "error"
+	Current   string     `json:"current"`
+	Available string     `json:"available,omitempty"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var selfUpdate = struct {
+	sync.Mutex
+	running   bool
+	state     string
+	available string
+	lastCheck time.Time
+	lastErr   error
+}{state: "idle"}
+
+// errUpdateStaged reports a release already installed in flag mode
+var errUpdateStaged = errors.New("release is installed and waits for a restart")
+
+var updateClient = &http.Client{Timeout: 30 * time.Minute}
+
+var updateChecks = newCounterVec("yolo_self_update_checks_total",
+	"Self-update checks by outcome.", "status")
+
+// updateFlagPath returns UPDATE_FLAG_FILE with its default applied
+func updateFlagPath(cfg *Config) string {
+	if cfg.UpdateFlagFile != "" {
+		return cfg.UpdateFlagFile
+	}
+	return filepath.Join(cfg.StateDir, "update-pending")
+}
+
+// validateUpdate checks the self-update settings
+func validateUpdate(cfg *Config) error {
+	switch cfg.UpdateRestart {
+	case updateRestartExec, updateRestartExit, updateRestartFlag:
+	default:
+		return fmt.Errorf("UPDATE_RESTART must be exec, exit or flag")
+	}
+	if cfg.UpdateURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(cfg.UpdateURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("UPDATE_URL must be an http:// or https:// URL")
+	}
+	if cfg.UpdateSigningKeys == "" {
+		return fmt.Errorf("UPDATE_URL needs UPDATE_SIGNING_KEYS")
+	}
+	_, err := parseSigningKeys("UPDATE_SIGNING_KEYS", cfg.UpdateSigningKeys)
+	return err
+}
+
+// clearUpdateFlag removes UPDATE_FLAG_FILE once the version it announces runs
+func clearUpdateFlag() {
+	p := updateFlagPath(config())
+	if data, err := os.ReadFile(p); err == nil && strings.TrimSpace(string(data)) == buildVersion {
+		os.Remove(p)
+		log.Printf("Running updated version %s", buildVersion)
+	}
+}
+
+func updateView() UpdateView {
+	selfUpdate.Lock()
+	defer selfUpdate.Unlock()
+	v := UpdateView{State: selfUpdate.state, Current: buildVersion, Available: selfUpdate.available}
+	if config().UpdateURL == "" {
+		v.State = "disabled"
+	}
+	if !selfUpdate.lastCheck.IsZero() {
+		t := selfUpdate.lastCheck
+		v.LastCheck = &t
+	}
+	if selfUpdate.lastErr != nil {
+		v.LastError = selfUpdate.lastErr.Error()
+	}
+	return v
+}
+
+func setUpdateState(state, available string, err error) {
+	selfUpdate.Lock()
+	changed := selfUpdate.state != state || selfUpdate.available != available
+	selfUpdate.state, selfUpdate.available, selfUpdate.lastErr = state, available, err
+	selfUpdate.lastCheck = time.Now().UTC()
+	selfUpdate.Unlock()
+	if changed {
+		bus.publish(eventUpdateStatus, updateView())
+	}
+}
+
+// runSelfUpdate checks UPDATE_URL for a release and installs it; the
+// self-update task
+func runSelfUpdate() error {
+	cfg := config()
+	if cfg.UpdateURL == "" {
+		return fmt.Errorf("UPDATE_URL is not configured")
+	}
+	if err := validateUpdate(cfg); err != nil {
+		return err
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return fmt.Errorf("self-update is disabled under Kubernetes; roll out a new image instead")
+	}
+	if status := getNodeStatus(); status.NetworkStatus != "online" {
+		return fmt.Errorf("skipping self-update check: network status is %s", status.NetworkStatus)
+	}
+	selfUpdate.Lock()
+	if selfUpdate.running {
+		selfUpdate.Unlock()
+		return fmt.Errorf("a self-update is already in progress")
+	}
+	selfUpdate.running = true
+	selfUpdate.Unlock()
+	defer func() {
+		selfUpdate.Lock()
+		selfUpdate.running = false
+		selfUpdate.Unlock()
+	}()
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot locate the running binary: %v", err)
+	}
+	version, err := installRelease(cfg, exe)
+	switch {
+	case err == errUpdateStaged:
+		updateChecks.inc("staged")
+		setUpdateState("staged", version, nil)
+		return nil
+	case err != nil:
+		updateChecks.inc("error")
+		setUpdateState("error", version, err)
+		return err
+	case version == "":
+		updateChecks.inc("up-to-date")
+		setUpdateState("up-to-date", "", nil)
+		return nil
+	}
+	updateChecks.inc("installed")
+	if cfg.UpdateRestart == updateRestartFlag {
+		if err := writeFileAtomic(updateFlagPath(cfg), []byte(version+"\n")); err != nil {
+			setUpdateState("error", version, err)
+			return fmt.Errorf("version %s installed but the update flag could not be written: %v", version, err)
+		}
+		log.Printf("Installed version %s; it runs after the next restart", version)
+		setUpdateState("staged", version, nil)
+		return nil
+	}
+	setUpdateState("restarting", version, nil)
+	return restartForUpdate(cfg, exe, version)
+}
+
+// installRelease downloads the release at UPDATE_URL and swaps it in as exe,
+// returning its version, or "" when the running version is current
+func installRelease(cfg *Config, exe string) (string, error) {
+	rel, err := fetchRelease(cfg.UpdateURL)
+	if err != nil {
+		return "", err
+	}
+	if rel.Version == buildVersion {
+		return "", nil
+	}
+	if !newerVersion(rel.Version, buildVersion) {
+		return rel.Version, fmt.Errorf("rejected release %s: not newer than the running %s", rel.Version, buildVersion)
+	}
+	if data, err := os.ReadFile(updateFlagPath(cfg)); err == nil && strings.TrimSpace(string(data)) == rel.Version {
+		return rel.Version, errUpdateStaged
+	}
+	binURL, sum, err := verifyRelease(cfg, rel)
+	if err != nil {
+		return rel.Version, fmt.Errorf("rejected release %s: %v", rel.Version, err)
+	}
+	log.Printf("Downloading version %s from %s", rel.Version, binURL)
+	staged := exe + ".new"
+	if err := downloadBinary(binURL, sum, staged); err != nil {
+		os.Remove(staged)
+		return rel.Version, fmt.Errorf("release %s: %v", rel.Version, err)
+	}
+	// Keep the running binary for a rollback; a hard link leaves no moment
+	// without an executable in place
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Link(exe, old); err != nil {
+		if err := copyFile(exe, old); err != nil {
+			log.Printf("Warning: could not keep the previous binary as %s: %v", old, err)
+		}
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		os.Remove(staged)
+		return rel.Version, fmt.Errorf("cannot replace %s: %v", exe, err)
+	}
+	log.Printf("Installed version %s over %s (previous binary kept as %s)", rel.Version, buildVersion, old)
+	return rel.Version, nil
+}
+
+func fetchRelease(manifestURL string) (*releaseManifest, error) {
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Node-Name", getEnv("NODE_NAME", "unknown"))
+	req.Header.Set("X-Node-Version", buildVersion)
+	resp, err := fleetClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("release check failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release server returned %s", resp.Status)
+	}
+	var rel releaseManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, updateMaxManifest)).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("invalid release manifest: %v", err)
+	}
+	if rel.Version == "" {
+		return nil, fmt.Errorf("release manifest has no version")
+	}
+	return &rel, nil
+}
+
+// verifyRelease checks the signature of the release checksums and returns
+// this platform's binary URL and expected SHA-256
+func verifyRelease(cfg *Config, rel *releaseManifest) (string, string, error) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	ref, ok := rel.Binaries[platform]
+	if !ok {
+		return "", "", fmt.Errorf("no binary for %s", platform)
+	}
+	base, err := url.Parse(cfg.UpdateURL)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid binary URL %q", ref)
+	}
+	name := path.Base(u.Path)
+	if want := fmt.Sprintf("webui-%s-%s-%s", rel.Version, runtime.GOOS, runtime.GOARCH); name != want {
+		return "", "", fmt.Errorf("binary %s is not named %s", name, want)
+	}
+
+	keys, err := parseSigningKeys("UPDATE_SIGNING_KEYS", cfg.UpdateSigningKeys)
+	if err != nil {
+		return "", "", err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rel.Signature))
+	if err != nil {
+		return "", "", fmt.Errorf("signature is not base64")
+	}
+	signed := "version " + rel.Version + "\n" + rel.Checksums
+	if !verifySignature(keys, []byte(signed), sig) {
+		return "", "", fmt.Errorf("release signature does not match any of UPDATE_SIGNING_KEYS")
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(rel.Checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sha256sum marks binary mode with a leading "*"
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name && len(fields[0]) == sha256.Size*2 {
+			return u.String(), strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", "", fmt.Errorf("%s is not in the signed checksums", name)
+}
+
+// parseVersion splits a release version such as 1.5.0, v1.5 or 1.6.0-rc.1
+// into its numbers and pre-release suffix
+func parseVersion(v string) (nums []int, pre string, ok bool) {
+	v, pre, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	for _, part := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, "", false
+		}
+		nums = append(nums, n)
+	}
+	return nums, pre, true
+}
+
+// newerVersion reports whether the release version v is newer than running;
+// a build without a release version (dev) takes any release
+func newerVersion(v, running string) bool {
+	a, apre, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	b, bpre, ok := parseVersion(running)
+	if !ok {
+		return true
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	switch {
+	case apre == bpre:
+		return false
+	case apre == "":
+		return true // the release after its pre-releases
+	case bpre == "":
+		return false
+	}
+	return newerPrerelease(apre, bpre)
+}
+
+// newerPrerelease compares pre-release tags as semver does: identifier by
+// identifier, numerically when both are numbers (rc.10 after rc.9), numbers
+// before words, and a longer tag after its own prefix
+func newerPrerelease(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		x, xerr := strconv.ParseUint(as[i], 10, 64)
+		y, yerr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case xerr == nil && yerr == nil:
+			return x > y
+		case xerr == nil:
+			return false
+		case yerr == nil:
+			return true
+		}
+		return as[i] > bs[i]
+	}
+	return len(as) > len(bs)
+}
+
+// downloadBinary saves the binary at binURL to dst if its SHA-256 is sum
+func downloadBinary(binURL, sum, dst string) error {
+	resp, err := updateClient.Get(binURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(resp.Body, updateMaxBinary+1))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > updateMaxBinary {
+		return fmt.Errorf("binary exceeds %d bytes", updateMaxBinary)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("binary does not match its checksum (got sha256:%s)", got)
+	}
+	return nil
+}
+
+// restartForUpdate lets running inference finish, stops the inference
+// process and re-execs exe or exits
+func restartForUpdate(cfg *Config, exe, version string) error {
+	deadline := time.Now().Add(cfg.UpdateDrainTimeout)
+	for jobs.inferring() > 0 && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+	}
+	// Holding the request lock keeps new requests off the inference process
+	worker.reqMu.Lock()
+	worker.mu.Lock()
+	cmd := worker.cmd
+	worker.mu.Unlock()
+	if cmd != nil {
+		killProcessGroup(cmd)
+	}
+
+	if cfg.UpdateRestart == updateRestartExit {
+		log.Printf("Exiting to start version %s", version)
+		os.Exit(0)
+	}
+	log.Printf("Restarting as version %s", version)
+	err := syscall.Exec(exe, os.Args, os.Environ())
+	// Still the old binary: carry on with it
+	worker.reqMu.Unlock()
+	setUpdateState("error", version, err)
+	return fmt.Errorf("version %s installed but the restart failed: %v", version, err)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads for flaky site Wi-Fi, modelled on the tus protocol: the
+// client creates an upload with the file's size, then appends chunks with
+// PATCH requests carrying the Upload-Offset they start at. After a dropped
+// connection it asks for the current offset and continues from there. Once
+// the last byte arrives the upload is queued as an inference job with the
+// same ID, so /results/{id} and /events/jobs/{id} work as for form uploads.
+
+// uploadExpiry is how long an unfinished upload may sit idle before it is discarded
+const uploadExpiry = 24 * time.Hour
+
+type chunkedUpload struct {
+	id        string
+	filename  string
+	path      string
+	size      int64
+	createdAt time.Time
+
+	writing sync.Mutex // held while a chunk is written
+
+	mu        sync.Mutex
+	offset    int64
+	updatedAt time.Time
+	queued    bool
+}
+
+// UploadView is the API representation of a resumable upload
+type UploadView struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	Percent   float64   `json:"percent"`
+	Complete  bool      `json:"complete"`
+	Stage     string    `json:"stage,omitempty"` // job stage once complete; see jobs.go
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ResultURL string    `json:"result_url,omitempty"`
+	EventsURL string    `json:"events_url,omitempty"`
+}
+
+type uploadRegistry struct {
+	mu      sync.Mutex
+	uploads map[string]*chunkedUpload
+}
+
+var uploads = &uploadRegistry{uploads: map[string]*chunkedUpload{}}
+
+var uploadBytes = newCounterVec("yolo_upload_bytes_total",
+	"Bytes received through resumable uploads.")
+
+// create registers a new upload and its empty file in a directory of its own
+func (reg *uploadRegistry) create(filename string, size int64) (*chunkedUpload, error) {
+	name := filepath.Base(filename)
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	if size <= 0 || size > config().UploadMaxBytes {
+		return nil, fmt.Errorf("size must be between 1 and %d bytes", config().UploadMaxBytes)
+	}
+	id := newID()
+	dir := filepath.Join(uploadDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	f.Close()
+
+	now := time.Now().UTC()
+	u := &chunkedUpload{id: id, filename: name, path: path, size: size, createdAt: now, updatedAt: now}
+	reg.mu.Lock()
+	reg.uploads[id] = u
+	reg.mu.Unlock()
+	return u, nil
+}
+
+func (reg *uploadRegistry) get(id string) (*chunkedUpload, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	u, ok := reg.uploads[id]
+	return u, ok
+}
+
+// remove forgets an upload, deleting its partial file unless it was queued
+func (reg *uploadRegistry) remove(u *chunkedUpload) {
+	reg.mu.Lock()
+	delete(reg.uploads, u.id)
+	reg.mu.Unlock()
+	u.mu.Lock()
+	queued := u.queued
+	u.mu.Unlock()
+	if !queued {
+		os.RemoveAll(filepath.Dir(u.path))
+	}
+}
+
+func (reg *uploadRegistry) list(base string) []UploadView {
+	reg.mu.Lock()
+	all := make([]*chunkedUpload, 0, len(reg.uploads))
+	for _, u := range reg.uploads {
+		all = append(all, u)
+	}
+	reg.mu.Unlock()
+
+	out := make([]UploadView, 0, len(all))
+	for _, u := range all {
+		out = append(out, u.view(base))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// startExpiry periodically drops idle unfinished uploads, and finished ones
+// once their job is no longer tracked
+func (reg *uploadRegistry) startExpiry() {
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			reg.mu.Lock()
+			var expired []*chunkedUpload
+			for _, u := range reg.uploads {
+				if !u.writing.TryLock() {
+					continue // a chunk is being written
+				}
+				u.mu.Lock()
+				idle := time.Since(u.updatedAt) > uploadExpiry
+				done := u.queued && !jobs.has(u.id)
+				u.mu.Unlock()
+				u.writing.Unlock()
+				if idle || done {
+					expired = append(expired, u)
+				}
+			}
+			reg.mu.Unlock()
+			for _, u := range expired {
+				reg.remove(u)
+			}
+		}
+	}()
+}
+
+// view describes u, with URLs under the sub-path base
+func (u *chunkedUpload) view(base string) UploadView {
+	u.mu.Lock()
+	offset, updated, queued := u.offset, u.updatedAt, u.queued
+	u.mu.Unlock()
+	v := UploadView{
+		ID:        u.id,
+		Filename:  u.filename,
+		Size:      u.size,
+		Offset:    offset,
+		Percent:   float64(offset) * 100 / float64(u.size),
+		Complete:  offset == u.size,
+		CreatedAt: u.createdAt,
+		UpdatedAt: updated,
+	}
+	if queued {
+		v.Stage, _ = jobs.stage(u.id)
+		v.ResultURL = base + "/results/" + u.id
+		v.EventsURL = base + "/events/jobs/" + u.id
+	}
+	return v
+}
+
+// errUploadBusy is returned while another request is writing a chunk
+var errUploadBusy = errors.New("another chunk is being written; retry shortly")
+
+// errOffsetMismatch is returned when a chunk does not start at the current offset
+var errOffsetMismatch = errors.New("Upload-Offset does not match the current offset")
+
+// appendChunk writes body at offset and queues the upload once it is complete.
+// A chunk cut short by a dropped connection keeps the bytes that arrived.
+func (u *chunkedUpload) appendChunk(offset int64, body io.Reader) error {
+	if !u.writing.TryLock() {
+		return errUploadBusy
+	}
+	defer u.writing.Unlock()
+
+	// Only this goroutine changes offset while writing is held
+	u.mu.Lock()
+	current, queued := u.offset, u.queued
+	u.mu.Unlock()
+	if offset != current {
+		return errOffsetMismatch
+	}
+
+	var copyErr error
+	if remaining := u.size - current; remaining > 0 {
+		f, err := os.OpenFile(u.path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		// Read one byte past the remaining size to detect oversized chunks
+		n, err := io.Copy(f, io.LimitReader(body, remaining+1))
+		if n > remaining {
+			f.Truncate(u.size)
+			n = remaining
+			err = fmt.Errorf("chunk extends past the declared size of %d bytes", u.size)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		current += n
+		u.mu.Lock()
+		u.offset, u.updatedAt = current, time.Now().UTC()
+		u.mu.Unlock()
+		uploadBytes.add(float64(n))
+		copyErr = err
+	}
+
+	if current == u.size && !queued {
+		if err := jobs.submit(u.id, u.path, "upload", InferenceOptions{}, u.createdAt); err != nil {
+			return err
+		}
+		u.mu.Lock()
+		u.queued = true
+		u.mu.Unlock()
+	}
+	return copyErr
+}
+
+// uploadsHandler serves resumable uploads
+//
+//	GET    /api/v1/uploads       uploads in progress or recently completed
+//	POST   /api/v1/uploads       start an upload: {"filename": "a.jpg", "size": 123456}
+//	GET    /api/v1/uploads/{id}  progress of an upload (HEAD returns it as Upload-Offset/Upload-Length)
+//	PATCH  /api/v1/uploads/{id}  append the request body at the Upload-Offset header
+//	DELETE /api/v1/uploads/{id}  abandon an unfinished upload
+func uploadsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/uploads"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, uploads.list(basePath(r)))
+		case http.MethodPost:
+			var req struct {
+				Filename string `json:"filename"`
+				Size     int64  `json:"size"`
+			}
+			if err := decodeJSON(w, r, &req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+				return
+			}
+			u, err := uploads.create(req.Filename, req.Size)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			w.Header().Set("Location", basePath(r)+"/api/"+requestAPIVersion(r)+"/uploads/"+u.id)
+			writeJSON(w, http.StatusCreated, u.view(basePath(r)))
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	u, ok := uploads.get(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		v := u.view(basePath(r))
+		w.Header().Set("Upload-Offset", strconv.FormatInt(v.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(v.Size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet:
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, u.view(basePath(r)))
+
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Upload-Offset header is required")
+			return
+		}
+		err = u.appendChunk(offset, r.Body)
+		v := u.view(basePath(r))
+		w.Header().Set("Upload-Offset", strconv.FormatInt(v.Offset, 10))
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, v)
+		case err == errOffsetMismatch || err == errUploadBusy:
+			// The body carries the current offset to resume from
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": translate(responseLanguage(w), err.Error()), "upload": v})
+		case err == errQueueFull:
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		}
+
+	case http.MethodDelete:
+		if u.view(basePath(r)).Complete {
+			writeJSONError(w, http.StatusConflict, "Completed uploads cannot be abandoned")
+			return
+		}
+		uploads.remove(u)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
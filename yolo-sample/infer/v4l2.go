@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Locally attached USB/CSI cameras are captured through V4L2. A source URL of
+// the form
+//
+//	v4l2:///dev/video0?size=1280x720&format=mjpeg
+//
+// is decoded by ffmpeg's v4l2 input; size and format are optional and passed
+// as -video_size and -input_format. In Kubernetes the device has to be mapped
+// into the container (see inference.videoDevices in values.yaml).
+
+// VideoDevice is a V4L2 device node found on the host
+type VideoDevice struct {
+	Path  string `json:"path"`
+	Name  string `json:"name,omitempty"`
+	Index int    `json:"index"` // node index within the physical device; 0 is normally the capture node
+	URL   string `json:"url"`   // source URL to capture from this device
+}
+
+// listVideoDevices enumerates /dev/video* nodes, naming them from sysfs
+func listVideoDevices() []VideoDevice {
+	paths, _ := filepath.Glob("/dev/video*")
+	devices := make([]VideoDevice, 0, len(paths))
+	for _, p := range paths {
+		base := filepath.Base(p)
+		if _, err := strconv.Atoi(strings.TrimPrefix(base, "video")); err != nil {
+			continue
+		}
+		dev := VideoDevice{Path: p, URL: "v4l2://" + p}
+		sys := filepath.Join("/sys/class/video4linux", base)
+		if name, err := os.ReadFile(filepath.Join(sys, "name")); err == nil {
+			dev.Name = strings.TrimSpace(string(name))
+		}
+		if idx, err := os.ReadFile(filepath.Join(sys, "index")); err == nil {
+			dev.Index, _ = strconv.Atoi(strings.TrimSpace(string(idx)))
+		}
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Path < devices[j].Path })
+	return devices
+}
+
+// validateV4L2URL checks a v4l2:// source URL
+func validateV4L2URL(u *url.URL) error {
+	if u.Host != "" || !strings.HasPrefix(u.Path, "/dev/video") {
+		return fmt.Errorf("v4l2 urls look like v4l2:///dev/video0")
+	}
+	q := u.Query()
+	if size := q.Get("size"); size != "" {
+		w, h, ok := strings.Cut(size, "x")
+		if _, err := strconv.Atoi(w); err != nil || !ok {
+			return fmt.Errorf("invalid v4l2 size %q (want WIDTHxHEIGHT)", size)
+		}
+		if _, err := strconv.Atoi(h); err != nil {
+			return fmt.Errorf("invalid v4l2 size %q (want WIDTHxHEIGHT)", size)
+		}
+	}
+	return nil
+}
+
+// v4l2Frames captures from a local video device through ffmpeg
+func v4l2Frames(ctx context.Context, u *url.URL, fps float64, sink frameSink) error {
+	input := []string{"-f", "v4l2"}
+	q := u.Query()
+	if size := q.Get("size"); size != "" {
+		input = append(input, "-video_size", size)
+	}
+	if format := q.Get("format"); format != "" {
+		input = append(input, "-input_format", format)
+	}
+	input = append(input, "-i", u.Path)
+	return ffmpegFrames(ctx, input, fps, sink)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Results from the inference backend are validated before they are stored or
+// rendered. A result that fails is kept with its detections dropped and an
+// error listing every problem, e.g.
+//
+//	Invalid inference result: detection 1: confidence 1.7 outside [0, 1]; count 3 does not match 2 detections
+//
+// Checks: count matches the detections, confidences are within [0, 1], boxes
+// have x1 < x2 and y1 < y2 and lie within the image (with a pixel of slack for
+// rounding), and class IDs are among the model's classes when the backend
+// reports them (see "classes" in protocol.go).
+
+// bboxSlack is how far in pixels a box may extend past the image edge
+const bboxSlack = 1.0
+
+var invalidResults = newCounterVec("yolo_invalid_results_total",
+	"Inference results rejected by validation.")
+
+// validateResult returns every problem found in result for the image at imagePath
+func validateResult(result InferenceResult, imagePath string) []string {
+	var problems []string
+	if result.Count != len(result.Detections) {
+		problems = append(problems, fmt.Sprintf("count %d does not match %d detections", result.Count, len(result.Detections)))
+	}
+
+	width, height := -1.0, -1.0
+	if f, err := os.Open(imagePath); err == nil {
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			width, height = float64(cfg.Width), float64(cfg.Height)
+		}
+		f.Close()
+	}
+
+	for i, d := range result.Detections {
+		var bad []string
+		if math.IsNaN(d.Confidence) || d.Confidence < 0 || d.Confidence > 1 {
+			bad = append(bad, fmt.Sprintf("confidence %v outside [0, 1]", d.Confidence))
+		}
+		b := d.BBox
+		for _, v := range []float64{b.X1, b.Y1, b.X2, b.Y2} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				bad = append(bad, "bbox has non-finite coordinates")
+				break
+			}
+		}
+		if !(b.X1 < b.X2) || !(b.Y1 < b.Y2) {
+			bad = append(bad, fmt.Sprintf("bbox (%g,%g)-(%g,%g) is empty or inverted", b.X1, b.Y1, b.X2, b.Y2))
+		}
+		if b.X1 < -bboxSlack || b.Y1 < -bboxSlack ||
+			(width >= 0 && b.X2 > width+bboxSlack) || (height >= 0 && b.Y2 > height+bboxSlack) {
+			where := "the image"
+			if width >= 0 {
+				where = fmt.Sprintf("the %gx%g image", width, height)
+			}
+			bad = append(bad, fmt.Sprintf("bbox (%g,%g)-(%g,%g) outside %s", b.X1, b.Y1, b.X2, b.Y2, where))
+		}
+		if d.ClassID < 0 {
+			bad = append(bad, fmt.Sprintf("negative class ID %d", d.ClassID))
+		} else if result.classes != nil {
+			name, ok := result.classes[strconv.Itoa(d.ClassID)]
+			switch {
+			case !ok:
+				bad = append(bad, fmt.Sprintf("unknown class ID %d", d.ClassID))
+			case name != d.ClassName:
+				bad = append(bad, fmt.Sprintf("class ID %d is %q, not %q", d.ClassID, name, d.ClassName))
+			}
+		}
+		for _, p := range bad {
+			problems = append(problems, fmt.Sprintf("detection %d: %s", i, p))
+		}
+	}
+	return problems
+}
+
+// checkResult replaces an invalid result's detections with a validation error
+func checkResult(result InferenceResult, imagePath string) InferenceResult {
+	if result.Error != "" {
+		return result
+	}
+	problems := validateResult(result, imagePath)
+	result.classes = nil
+	if len(problems) == 0 {
+		return result
+	}
+	invalidResults.inc()
+	result.Error = "Invalid inference result: " + strings.Join(problems, "; ")
+	result.Detections, result.Count = nil, 0
+	return result
+}
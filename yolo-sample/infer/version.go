@@ -0,0 +1,113 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// The build is stamped with -ldflags so fleet operators can tell exactly what
+// each node runs; the Dockerfiles pass the values as build args:
+//
+//	podman build --build-arg VERSION=1.4.0 --build-arg GIT_SHA=$(git rev-parse HEAD) \
+//	    --build-arg BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ) -t edge-inference:1.4.0 .
+//
+// which run
+//
+//	go build -ldflags "-X main.buildVersion=1.4.0 -X main.buildCommit=... -X main.buildDate=... -X main.buildBackends=cpu"
+//
+// A plain go build inside a checkout still reports the VCS revision Go stamps
+// into the binary. The build is served at /api/v1/version, shown in the page
+// footers ({{buildFooter}}), exported as the yolo_build_info metric and
+// included in crash reports.
+
+// Set with -ldflags "-X main.<name>=<value>"
+var (
+	buildVersion  = "dev"
+	buildCommit   = ""
+	buildDate     = ""
+	buildBackends = "cpu" // comma-separated inference backends built in, e.g. "cuda"
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	Modified  bool     `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+	BuildDate string   `json:"build_date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Backends  []string `json:"backends"`
+}
+
+var buildInfoGauge = newGaugeVec("yolo_build_info",
+	"Always 1; the labels describe the running build.", "version", "commit", "backends")
+
+// currentBuild returns the ldflags values, falling back to what the Go
+// toolchain recorded
+func currentBuild() BuildInfo {
+	b := BuildInfo{
+		Version:   buildVersion,
+		Commit:    buildCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Backends:  splitList(buildBackends),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = s.Value
+				}
+			case "vcs.time":
+				if b.BuildDate == "" {
+					b.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	return b
+}
+
+// shortCommit abbreviates a commit SHA for display
+func shortCommit(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
+// recordBuildInfo sets the yolo_build_info metric
+func recordBuildInfo() {
+	b := currentBuild()
+	buildInfoGauge.set(1, b.Version, shortCommit(b.Commit), buildBackends)
+}
+
+// versionHandler serves GET /api/v1/version
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentBuild())
+}
+
+// versionFooter is the page footer naming the build
+func versionFooter(lang, base string) template.HTML {
+	b := currentBuild()
+	text := translate(lang, "Version") + " " + b.Version
+	if b.Commit != "" {
+		text += " (" + shortCommit(b.Commit) + ")"
+	}
+	if b.BuildDate != "" {
+		text += " · " + translate(lang, "built") + " " + b.BuildDate
+	}
+	return template.HTML(`<footer class="build-info" style="margin-top: 30px; font-size: 12px; color: #888; text-align: center;">` +
+		`<a href="` + html.EscapeString(base) + `/api/v1/version" style="color: inherit;">` + html.EscapeString(text) + `</a></footer>`)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// WebAssembly post-processing plugins. Every *.wasm file in WASM_PLUGIN_DIR is
+// registered as an inference hook named "wasm:<file name without .wasm>" and,
+// like any hook, runs only when listed in HOOKS.
+//
+// A plugin is a WASI command module: it reads the InferenceResult JSON on stdin
+// and writes the transformed InferenceResult JSON to stdout, of which only
+// detections, count and attributes are taken. Modules run in an external WASI
+// runtime (wasmtime by default, installed in both images) with no filesystem
+// preopens, no network, an empty environment, a memory cap, a fuel
+// (instruction) budget, and a wall-clock timeout, so a misbehaving plugin
+// can't affect the host.
+
+// wasmMaxOutput bounds how much a plugin may write to stdout
+const wasmMaxOutput = 1 << 20
+
+type wasmHook struct {
+	NopHook
+	name   string
+	module string
+}
+
+// wasmCommandData are the placeholders available in WASM_RUNTIME
+type wasmCommandData struct {
+	Module      string
+	MemoryBytes int64
+	Fuel        int64
+}
+
+// registerWasmPlugins registers a hook for every module in WASM_PLUGIN_DIR
+func registerWasmPlugins() {
+	if config().WasmPluginDir == "" {
+		return
+	}
+	modules, err := filepath.Glob(filepath.Join(config().WasmPluginDir, "*.wasm"))
+	if err != nil {
+		log.Printf("Warning: failed to list WASM plugins: %v", err)
+		return
+	}
+	for _, m := range modules {
+		name := "wasm:" + strings.TrimSuffix(filepath.Base(m), ".wasm")
+		registerHook(&wasmHook{name: name, module: m})
+		log.Printf("Registered WASM plugin %s", name)
+	}
+}
+
+func (h *wasmHook) Name() string { return h.name }
+
+func (h *wasmHook) AfterInference(result *InferenceResult) error {
+	args, err := renderCommand(config().WasmRuntime, wasmCommandData{
+		Module:      h.module,
+		MemoryBytes: int64(config().WasmMaxMemoryMB) << 20,
+		Fuel:        config().WasmFuel,
+	})
+	if err != nil {
+		return err
+	}
+
+	input, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config().WasmTimeout)
+	defer cancel()
+
+	sandbox, err := os.MkdirTemp("", "wasm-plugin-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(sandbox)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = sandbox
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + sandbox}
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &limitedBuffer{limit: wasmMaxOutput}
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s", config().WasmTimeout)
+		}
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.overflow {
+		return fmt.Errorf("output exceeds %d bytes", wasmMaxOutput)
+	}
+
+	var transformed InferenceResult
+	if err := json.Unmarshal(stdout.Bytes(), &transformed); err != nil {
+		return fmt.Errorf("invalid result JSON from plugin: %v", err)
+	}
+	// Plugins edit the detections and attributes only; the rest of the
+	// result, including what image storage and redaction rely on, stays
+	if transformed.Attributes == nil {
+		transformed.Attributes = map[string]interface{}{}
+	}
+	result.Detections, result.Count, result.Attributes = transformed.Detections, transformed.Count, transformed.Attributes
+	return nil
+}
+
+// renderCommand expands a text/template command line and splits it into arguments
+func renderCommand(tmpl string, data interface{}) ([]string, error) {
+	t, err := template.New("command").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("invalid command template: %v", err)
+	}
+	args := strings.Fields(buf.String())
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command template %q is empty", tmpl)
+	}
+	return args, nil
+}
+
+// limitedBuffer collects output up to limit bytes and records any overflow
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.overflow = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inference runs in one long-lived process started from INFERENCE_COMMAND
+// (by default "python /app/infer.py --serve"), so the model is loaded once
+// rather than per image. Requests are written to its stdin as JSON lines,
+// {"image": ..., "model": ..., "conf": ..., "augment": ...} plus "imgsz",
+// "iou" and "max_det" when a request sets them, and each is answered by one
+// JSON result line on stdout. A command that uses {{.ImagePath}} is instead
+// run once per image and prints its result on stdout; see
+// inferenceCommandData and protocol.go.
+//
+// The process is supervised: when it exits (or a request exceeds
+// INFERENCE_TIMEOUT and it is killed) it is restarted after an exponential
+// backoff, starting at one second and doubling up to WORKER_BACKOFF_MAX. More
+// than WORKER_MAX_RESTARTS restarts within WORKER_RESTART_WINDOW is a restart
+// storm: the worker is left down ("crashloop") until the window has passed.
+// The last stderr lines are kept for /api/v1/system.
+
+// Worker states
+const (
+	workerStarting  = "starting"
+	workerRunning   = "running"
+	workerBackoff   = "backoff"
+	workerCrashLoop = "crashloop"
+	workerOnDemand  = "on-demand" // one process per image
+	workerMock      = "mock"      // no process; see mock.go
+)
+
+const (
+	workerBackoffMin  = time.Second
+	workerStableAfter = time.Minute // a process that ran this long resets the backoff
+	workerStderrLines = 20
+)
+
+type inferenceWorker struct {
+	reqMu sync.Mutex // one request at a time
+
+	mu        sync.Mutex
+	state     string
+	cmd       *exec.Cmd
+	stdin     io.Writer
+	stdout    *bufio.Reader
+	startedAt time.Time
+	restarts  int
+	recent    []time.Time // restart times within the restart window
+	lastExit  string
+	exitedAt  time.Time
+	retryAt   time.Time
+	timedOut  bool // the running process was killed for exceeding INFERENCE_TIMEOUT
+	stderr    []string
+}
+
+// WorkerView is the API representation of the inference worker
+type WorkerView struct {
+	State      string     `json:"state"`
+	PID        int        `json:"pid,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	Restarts   int        `json:"restarts"`
+	LastExit   string     `json:"last_exit,omitempty"`
+	ExitedAt   *time.Time `json:"exited_at,omitempty"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"`
+	LastStderr []string   `json:"last_stderr"`
+}
+
+var worker = &inferenceWorker{state: workerStarting}
+
+// inferenceCommandData is passed to the INFERENCE_COMMAND template, for example
+//
+//	python /app/infer.py --serve --conf {{.Threshold}}
+//	/opt/yolo/detect --model {{.Model}} --conf {{.Threshold}} {{if .Augment}}--tta {{end}}{{.ImagePath}}
+//
+// Model is the model file; for a long-lived process it is the active model at
+// start, requests for other versions name their model in the request line.
+// Augment is the "tta" feature flag, which requests also carry. ImgSz,
+// IoUThreshold and MaxDetections are a request's overrides, zero when unset;
+// a long-lived process gets them as "imgsz", "iou" and "max_det".
+type inferenceCommandData struct {
+	ImagePath     string
+	Model         string
+	Threshold     float64
+	Augment       bool
+	ImgSz         int
+	IoUThreshold  float64
+	MaxDetections int
+}
+
+// oneShot reports whether INFERENCE_COMMAND runs once per image
+func oneShot() bool {
+	return strings.Contains(config().InferenceCommand, ".ImagePath")
+}
+
+// inferenceCommand renders INFERENCE_COMMAND. Paths are substituted after the
+// command line is split, so they may contain spaces.
+func inferenceCommand(data inferenceCommandData) (*exec.Cmd, error) {
+	paths := strings.NewReplacer("\x00image\x00", data.ImagePath, "\x00model\x00", data.Model)
+	args, err := renderCommand(config().InferenceCommand, inferenceCommandData{
+		ImagePath:     "\x00image\x00",
+		Model:         "\x00model\x00",
+		Threshold:     data.Threshold,
+		Augment:       data.Augment,
+		ImgSz:         data.ImgSz,
+		IoUThreshold:  data.IoUThreshold,
+		MaxDetections: data.MaxDetections,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range args {
+		args[i] = paths.Replace(args[i])
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	return cmd, sandboxCommand(cmd)
+}
+
+var (
+	workerUp = newGaugeVec("yolo_inference_worker_up",
+		"1 while the supervised inference process is running.")
+	workerRestarts = newCounterVec("yolo_inference_worker_restarts_total",
+		"Restarts of the inference process by reason.", "reason")
+)
+
+// supervise starts the inference process and keeps restarting it
+func (w *inferenceWorker) supervise() {
+	if mockBackend() {
+		w.mu.Lock()
+		w.state = workerMock
+		w.mu.Unlock()
+		return
+	}
+	if oneShot() {
+		w.mu.Lock()
+		w.state = workerOnDemand
+		w.mu.Unlock()
+		return
+	}
+	go func() {
+		backoff := workerBackoffMin
+		for {
+			started := time.Now()
+			err := w.run()
+
+			w.mu.Lock()
+			w.lastExit, w.exitedAt = err.Error(), time.Now()
+			reason := "exit"
+			if w.timedOut {
+				reason, w.timedOut = "timeout", false
+			}
+			if time.Since(started) >= workerStableAfter {
+				backoff = workerBackoffMin
+			}
+			now := time.Now()
+			w.recent = append(w.recent, now)
+			for len(w.recent) > 0 && now.Sub(w.recent[0]) > config().WorkerRestartWindow {
+				w.recent = w.recent[1:]
+			}
+			delay, storm := backoff, len(w.recent) > config().WorkerMaxRestarts
+			w.state = workerBackoff
+			if storm {
+				delay = config().WorkerRestartWindow - now.Sub(w.recent[0])
+				w.state = workerCrashLoop
+				log.Printf("Warning: inference process restarted %d times in %s, pausing restarts for %s",
+					len(w.recent)-1, config().WorkerRestartWindow, delay.Round(time.Second))
+			} else {
+				log.Printf("Warning: inference process exited (%v), restarting in %s", err, delay)
+			}
+			w.retryAt = now.Add(delay)
+			ev := WorkerEvent{State: w.state, LastExit: w.lastExit}
+			w.mu.Unlock()
+			bus.publish(eventWorkerState, ev)
+
+			time.Sleep(delay)
+			if !storm && backoff < config().WorkerBackoffMax {
+				backoff *= 2
+				if backoff > config().WorkerBackoffMax {
+					backoff = config().WorkerBackoffMax
+				}
+			}
+
+			w.mu.Lock()
+			w.state = workerStarting
+			w.restarts++
+			w.mu.Unlock()
+			workerRestarts.inc(reason)
+		}
+	}()
+}
+
+// run starts one inference process and waits for it to exit
+func (w *inferenceWorker) run() error {
+	cmd, err := inferenceCommand(inferenceCommandData{
+		Model:     modelPath(activeModelVersion()),
+		Threshold: config().InferenceThreshold,
+	})
+	if err != nil {
+		return err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// A plain pipe rather than StderrPipe, so it can be drained after Wait
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stderr.Close()
+	cmd.Stderr = stderrW
+	err = cmd.Start()
+	stderrW.Close()
+	if err != nil {
+		return err
+	}
+	if err := confineProcess(cmd.Process.Pid); err != nil {
+		killProcessGroup(cmd)
+		cmd.Wait()
+		return fmt.Errorf("cannot sandbox inference process: %v", err)
+	}
+
+	w.mu.Lock()
+	w.state, w.cmd, w.startedAt = workerRunning, cmd, time.Now()
+	w.stdin, w.stdout = stdin, bufio.NewReader(stdout)
+	w.mu.Unlock()
+	workerUp.set(1)
+	bus.publish(eventWorkerState, WorkerEvent{State: workerRunning})
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			w.logStderr(scanner.Text())
+		}
+	}()
+	err = cmd.Wait()
+	if err == nil {
+		err = fmt.Errorf("exited")
+	}
+	// Leftover children would keep the stderr pipe open
+	killProcessGroup(cmd)
+	<-drained
+
+	w.mu.Lock()
+	w.cmd, w.stdin, w.stdout = nil, nil, nil
+	w.mu.Unlock()
+	workerUp.set(0)
+	return err
+}
+
+// logStderr keeps a line of the inference process's stderr
+func (w *inferenceWorker) logStderr(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stderr = append(w.stderr, line)
+	recentLogs.add(logInfo, "inference", line)
+	if len(w.stderr) > workerStderrLines {
+		w.stderr = w.stderr[len(w.stderr)-workerStderrLines:]
+	}
+}
+
+// infer sends one image to the inference process and waits for its result
+func (w *inferenceWorker) infer(imagePath, model string, params backendParams) (InferenceResult, error) {
+	if mockBackend() {
+		return mockInfer(imagePath, model, params)
+	}
+	if oneShot() {
+		return w.inferOnce(imagePath, model, params)
+	}
+	w.reqMu.Lock()
+	defer w.reqMu.Unlock()
+
+	w.mu.Lock()
+	cmd, stdin, stdout, state := w.cmd, w.stdin, w.stdout, w.state
+	w.mu.Unlock()
+	if cmd == nil {
+		return InferenceResult{}, fmt.Errorf("inference process is not running (%s)", state)
+	}
+
+	fields := map[string]interface{}{"protocol": inferenceProtocol, "image": imagePath, "model": model, "conf": params.Threshold, "augment": featureEnabled("tta")}
+	if params.ImgSz > 0 {
+		fields["imgsz"] = params.ImgSz
+	}
+	if params.IoUThreshold > 0 {
+		fields["iou"] = params.IoUThreshold
+	}
+	if params.MaxDetections > 0 {
+		fields["max_det"] = params.MaxDetections
+	}
+	req, _ := json.Marshal(fields)
+	if _, err := stdin.Write(append(req, '\n')); err != nil {
+		return InferenceResult{}, err
+	}
+
+	type reply struct {
+		result InferenceResult
+		err    error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		for {
+			line, err := stdout.ReadBytes('\n')
+			if err != nil {
+				done <- reply{err: fmt.Errorf("inference process exited: %v", err)}
+				return
+			}
+			result, err := parseReply(line)
+			if err == errNotResult {
+				w.logStderr("stdout: " + strings.TrimSpace(string(line)))
+				continue
+			}
+			done <- reply{result, err}
+			return
+		}
+	}()
+
+	select {
+	case r := <-done:
+		return r.result, r.err
+	case <-time.After(config().InferenceTimeout):
+		// The supervisor restarts it; a late reply must not answer the next request
+		w.mu.Lock()
+		w.timedOut = true
+		w.mu.Unlock()
+		killProcessGroup(cmd)
+		return InferenceResult{}, fmt.Errorf("no result after %s, inference process killed", config().InferenceTimeout)
+	}
+}
+
+// inferOnce runs the one-shot inference command on an image
+func (w *inferenceWorker) inferOnce(imagePath, model string, params backendParams) (InferenceResult, error) {
+	cmd, err := inferenceCommand(inferenceCommandData{
+		ImagePath:     imagePath,
+		Model:         model,
+		Threshold:     params.Threshold,
+		Augment:       featureEnabled("tta"),
+		ImgSz:         params.ImgSz,
+		IoUThreshold:  params.IoUThreshold,
+		MaxDetections: params.MaxDetections,
+	})
+	if err != nil {
+		return InferenceResult{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return InferenceResult{}, err
+	}
+	if err := confineProcess(cmd.Process.Pid); err != nil {
+		killProcessGroup(cmd)
+		cmd.Wait()
+		return InferenceResult{}, fmt.Errorf("cannot sandbox inference process: %v", err)
+	}
+	timer := time.AfterFunc(config().InferenceTimeout, func() { killProcessGroup(cmd) })
+	err = cmd.Wait()
+	timer.Stop()
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if line != "" {
+			w.logStderr(line)
+		}
+	}
+	if err != nil {
+		w.mu.Lock()
+		w.lastExit, w.exitedAt = err.Error(), time.Now()
+		w.mu.Unlock()
+	}
+	return parseOneShot(stdout.Bytes(), stderr.Bytes(), err, w.logStderr)
+}
+
+func (w *inferenceWorker) view() WorkerView {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v := WorkerView{
+		State:      w.state,
+		Restarts:   w.restarts,
+		LastExit:   w.lastExit,
+		LastStderr: append([]string{}, w.stderr...),
+	}
+	if w.cmd != nil {
+		v.PID = w.cmd.Process.Pid
+		started := w.startedAt
+		v.StartedAt = &started
+	}
+	if !w.exitedAt.IsZero() {
+		exited := w.exitedAt
+		v.ExitedAt = &exited
+	}
+	if w.cmd == nil && !w.retryAt.IsZero() {
+		retry := w.retryAt
+		v.RetryAt = &retry
+	}
+	return v
+}
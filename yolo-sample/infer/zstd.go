@@ -0,0 +1,1650 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// zstd (RFC 8878) for compress.go, which the standard library does not have.
+// The writer is a simple single-pass compressor: LZ77 with hash chains and
+// one step of lazy matching over a 256 KiB window, Huffman-coded literals,
+// and for each sequence code whichever of the predefined table, a single
+// symbol or a table of its own costs least. The reader decodes any frame a
+// real zstd produces (repeat modes and offsets, skippable and concatenated
+// frames) except those needing a dictionary or a window over zstdMaxWindow,
+// and checks content checksums.
+
+const (
+	zstdMagic          = 0xFD2FB528
+	zstdBlockMax       = 128 << 10
+	zstdMaxWindow      = 8 << 20 // bounds the reader's memory
+	zstdHuffmanMaxBits = 11
+	zstdHashLog        = 14
+	zstdMinMatch       = 4
+	zstdChainDepth     = 16
+	zstdWriterWindow   = 2 * zstdBlockMax // a block and the one before it
+)
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// Literal length and match length codes: baseline value and extra bits
+var (
+	zstdLLBase = [36]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536}
+	zstdLLBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = [53]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539}
+	zstdMLBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16}
+)
+
+// The predefined FSE distributions of literal lengths, match lengths and offsets
+var (
+	zstdLLDefault = []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}
+	zstdMLDefault = []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}
+	zstdOFDefault = []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+
+	zstdLLTable = mustFSETable(zstdLLDefault, 6)
+	zstdMLTable = mustFSETable(zstdMLDefault, 6)
+	zstdOFTable = mustFSETable(zstdOFDefault, 5)
+)
+
+// fseState is one FSE decoding state: the symbol it emits and where the
+// next state is, base plus nb bits from the stream
+type fseState struct {
+	sym  uint8
+	nb   uint8
+	base uint16
+}
+
+type fseTable struct {
+	accLog uint8
+	states []fseState
+	enc    [][]uint16 // by symbol and next state, the state to encode in; predefined tables only
+}
+
+// newFSETable spreads a normalized distribution over 1<<accLog states
+func newFSETable(norm []int16, accLog uint8) (*fseTable, error) {
+	size := 1 << accLog
+	t := &fseTable{accLog: accLog, states: make([]fseState, size)}
+	next := make([]int, len(norm))
+	high := size - 1
+	for s, p := range norm {
+		if p == -1 {
+			t.states[high].sym = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(p)
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, p := range norm {
+		for i := 0; i < int(p); i++ {
+			t.states[pos].sym = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	for u := range t.states {
+		s := t.states[u].sym
+		n := next[s]
+		next[s]++
+		nb := int(accLog) - (bits.Len(uint(n)) - 1)
+		t.states[u].nb = uint8(nb)
+		t.states[u].base = uint16(n<<nb - size)
+	}
+	return t, nil
+}
+
+func mustFSETable(norm []int16, accLog uint8) *fseTable {
+	t, err := newFSETable(norm, accLog)
+	if err != nil {
+		panic(err)
+	}
+	return t.withEncoder(len(norm))
+}
+
+// withEncoder fills in t.enc: encoding runs backwards, so a symbol is encoded
+// in the state whose range holds the state that follows it
+func (t *fseTable) withEncoder(nsym int) *fseTable {
+	t.enc = make([][]uint16, nsym)
+	for s := range t.enc {
+		t.enc[s] = make([]uint16, len(t.states))
+	}
+	for u, st := range t.states {
+		for x := int(st.base); x < int(st.base)+1<<st.nb; x++ {
+			t.enc[st.sym][x] = uint16(u)
+		}
+	}
+	return t
+}
+
+// rleFSETable always emits sym and reads no bits
+func rleFSETable(sym uint8) *fseTable {
+	return &fseTable{states: []fseState{{sym: sym}}}
+}
+
+// normalizeFSE scales counts to a distribution over 1<<accLog states, at
+// least one for every symbol that occurs; there must be no more such symbols
+// than states
+func normalizeFSE(counts []int, accLog uint8) []int16 {
+	size, total, last := 1<<accLog, 0, 0
+	for s, c := range counts {
+		total += c
+		if c > 0 {
+			last = s
+		}
+	}
+	norm := make([]int16, last+1)
+	sum := 0
+	for s, c := range counts[:last+1] {
+		if c > 0 {
+			norm[s] = int16(max(1, (c*size+total/2)/total))
+			sum += int(norm[s])
+		}
+	}
+	for sum != size {
+		best := -1
+		for s, p := range norm {
+			if p > 0 && (sum < size || p > 1) && (best < 0 || p > norm[best]) {
+				best = s
+			}
+		}
+		if sum < size {
+			norm[best]++
+			sum++
+		} else {
+			norm[best]--
+			sum--
+		}
+	}
+	return norm
+}
+
+// fseCost estimates the bits needed to code counts with norm, or -1 when a
+// symbol cannot be coded
+func fseCost(counts []int, norm []int16, accLog uint8) float64 {
+	bitsUsed := 0.0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if s >= len(norm) || norm[s] == 0 {
+			return -1
+		}
+		p := max(float64(norm[s]), 1)
+		bitsUsed += float64(c) * (float64(accLog) - math.Log2(p))
+	}
+	return bitsUsed
+}
+
+// appendFSETable appends the description of a table, as readFSETable reads it
+func appendFSETable(out []byte, norm []int16, accLog uint8) []byte {
+	var bw zstdBitWriter
+	bw.out = out
+	bw.add(uint64(accLog-5), 4)
+	remaining := 1 << accLog
+	for s := 0; remaining > 0; s++ {
+		n := uint(bits.Len(uint(remaining + 1)))
+		lower := 1<<(n-1) - 1
+		threshold := 1<<n - 1 - (remaining + 1)
+		val := int(norm[s]) + 1
+		switch {
+		case val < threshold:
+			bw.add(uint64(val), n-1)
+		case val <= lower:
+			bw.add(uint64(val), n)
+		default:
+			bw.add(uint64(val+threshold), n)
+		}
+		if norm[s] < 0 {
+			remaining--
+		} else {
+			remaining -= int(norm[s])
+		}
+		if norm[s] == 0 {
+			zeros := 0
+			for s+1 < len(norm) && norm[s+1] == 0 {
+				s++
+				zeros++
+			}
+			for ; zeros >= 3; zeros -= 3 {
+				bw.add(3, 2)
+			}
+			bw.add(uint64(zeros), 2)
+		}
+	}
+	return bw.flush()
+}
+
+// readFSETable reads a table description; it returns the bytes used
+func readFSETable(b []byte, maxSym int, maxLog uint8) (*fseTable, int, error) {
+	br := zstdForwardReader{b: b}
+	accLog := uint8(br.read(4)) + 5
+	if accLog > maxLog {
+		return nil, 0, errZstdCorrupt
+	}
+	var norm []int16
+	remaining := 1 << accLog
+	for remaining > 0 && len(norm) <= maxSym {
+		n := uint(bits.Len(uint(remaining + 1)))
+		val := int(br.peek(n))
+		lower := 1<<(n-1) - 1
+		threshold := 1<<n - 1 - (remaining + 1)
+		switch {
+		case val&lower < threshold:
+			val &= lower
+			br.skip(n - 1)
+		case val > lower:
+			val -= threshold
+			br.skip(n)
+		default:
+			br.skip(n)
+		}
+		p := int16(val - 1)
+		if p < 0 {
+			remaining += int(p)
+		} else {
+			remaining -= int(p)
+		}
+		norm = append(norm, p)
+		if p == 0 {
+			for {
+				repeat := br.read(2)
+				for i := uint64(0); i < repeat && len(norm) <= maxSym; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+	}
+	used := (br.pos + 7) / 8
+	if remaining != 0 || len(norm) > maxSym+1 || used > len(b) {
+		return nil, 0, errZstdCorrupt
+	}
+	t, err := newFSETable(norm, accLog)
+	return t, used, err
+}
+
+// zstdForwardReader reads a table description, least significant bit first
+type zstdForwardReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *zstdForwardReader) peek(n uint) uint64 {
+	return zstdLoad(r.b, r.pos>>3) >> (r.pos & 7) & (1<<n - 1)
+}
+
+func (r *zstdForwardReader) skip(n uint) {
+	r.pos += int(n)
+}
+
+func (r *zstdForwardReader) read(n uint) uint64 {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+// zstdLoad reads up to 8 bytes little endian from b[i:], zero past the end
+func zstdLoad(b []byte, i int) uint64 {
+	if i+8 <= len(b) {
+		return binary.LittleEndian.Uint64(b[i:])
+	}
+	var v uint64
+	for j := 7; j >= 0; j-- {
+		v <<= 8
+		if i+j < len(b) {
+			v |= uint64(b[i+j])
+		}
+	}
+	return v
+}
+
+// zstdBackReader reads a bitstream from its end towards its start; the last
+// byte's highest set bit marks where the stream begins. Reads past the start
+// return zeros and leave pos negative.
+type zstdBackReader struct {
+	b   []byte
+	pos int // bits left to read
+}
+
+func newZstdBackReader(b []byte) (*zstdBackReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	return &zstdBackReader{b: b, pos: 8*(len(b)-1) + bits.Len8(b[len(b)-1]) - 1}, nil
+}
+
+func (r *zstdBackReader) peek(n uint) uint64 {
+	if n == 0 || r.pos <= 0 {
+		return 0
+	}
+	start := r.pos - int(n)
+	if start < 0 {
+		return zstdLoad(r.b, 0) & (1<<r.pos - 1) << -start
+	}
+	return zstdLoad(r.b, start>>3) >> (start & 7) & (1<<n - 1)
+}
+
+func (r *zstdBackReader) read(n uint) uint64 {
+	v := r.peek(n)
+	r.pos -= int(n)
+	return v
+}
+
+// zstdBitWriter writes bitstreams least significant bit first; close adds the
+// end marker a zstdBackReader starts from
+type zstdBitWriter struct {
+	out  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (w *zstdBitWriter) add(v uint64, n uint) {
+	w.acc |= v << w.nacc
+	w.nacc += n
+	for w.nacc >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nacc -= 8
+	}
+}
+
+func (w *zstdBitWriter) close() []byte {
+	w.add(1, 1)
+	return w.flush()
+}
+
+// flush pads the last byte with zeros
+func (w *zstdBitWriter) flush() []byte {
+	if w.nacc > 0 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc, w.nacc = 0, 0
+	}
+	return w.out
+}
+
+// huffmanTable decodes literals: indexed by the next maxBits bits
+type huffmanTable struct {
+	maxBits uint8
+	entries []fseState // sym and nb only
+}
+
+// huffmanStarts gives each symbol's first index in the decoding table, longest
+// codes first and by symbol within a length; a symbol's code is its start
+// shifted right by maxBits minus its length
+func huffmanStarts(nbits []uint8, maxBits uint8) []int {
+	var count [zstdHuffmanMaxBits + 2]int
+	for _, nb := range nbits {
+		count[nb]++
+	}
+	var next [zstdHuffmanMaxBits + 2]int
+	for nb := int(maxBits); nb > 1; nb-- {
+		next[nb-1] = next[nb] + count[nb]<<(int(maxBits)-nb)
+	}
+	starts := make([]int, len(nbits))
+	for s, nb := range nbits {
+		if nb > 0 {
+			starts[s] = next[nb]
+			next[nb] += 1 << (maxBits - nb)
+		}
+	}
+	return starts
+}
+
+// newHuffmanTable builds a table from weights, completing the implicit last one
+func newHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	total := 0
+	for _, w := range weights {
+		if w > zstdHuffmanMaxBits {
+			return nil, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, errZstdCorrupt
+	}
+	maxBits := uint8(bits.Len(uint(total)))
+	rest := 1<<maxBits - total
+	if maxBits > zstdHuffmanMaxBits || rest&(rest-1) != 0 {
+		return nil, errZstdCorrupt
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+	nbits := make([]uint8, len(weights))
+	for s, w := range weights {
+		if w > 0 {
+			nbits[s] = maxBits + 1 - w
+		}
+	}
+	t := &huffmanTable{maxBits: maxBits, entries: make([]fseState, 1<<maxBits)}
+	for s, start := range huffmanStarts(nbits, maxBits) {
+		if nbits[s] == 0 {
+			continue
+		}
+		for i := start; i < start+1<<(maxBits-nbits[s]); i++ {
+			t.entries[i] = fseState{sym: uint8(s), nb: nbits[s]}
+		}
+	}
+	return t, nil
+}
+
+// readHuffmanTable reads a tree description; it returns the bytes used
+func readHuffmanTable(b []byte) (*huffmanTable, int, error) {
+	if len(b) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	var weights []uint8
+	used := 0
+	if hb := int(b[0]); hb >= 128 {
+		n := hb - 127
+		used = 1 + (n+1)/2
+		if used > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < n; i++ {
+			w := b[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&15)
+		}
+	} else {
+		used = 1 + hb
+		if hb == 0 || used > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		t, n, err := readFSETable(b[1:used], zstdHuffmanMaxBits+1, 6)
+		if err != nil {
+			return nil, 0, err
+		}
+		br, err := newZstdBackReader(b[1+n : used])
+		if err != nil {
+			return nil, 0, err
+		}
+		// Two interleaved states until the stream runs out
+		s1, s2 := int(br.read(uint(t.accLog))), int(br.read(uint(t.accLog)))
+		for len(weights) < 254 {
+			st := t.states[s1]
+			weights = append(weights, st.sym)
+			s1 = int(st.base) + int(br.read(uint(st.nb)))
+			if br.pos < 0 {
+				weights = append(weights, t.states[s2].sym)
+				break
+			}
+			st = t.states[s2]
+			weights = append(weights, st.sym)
+			s2 = int(st.base) + int(br.read(uint(st.nb)))
+			if br.pos < 0 {
+				weights = append(weights, t.states[s1].sym)
+				break
+			}
+		}
+		if br.pos >= 0 {
+			return nil, 0, errZstdCorrupt
+		}
+	}
+	t, err := newHuffmanTable(weights)
+	return t, used, err
+}
+
+// decode fills out from one Huffman stream
+func (t *huffmanTable) decode(out, stream []byte) error {
+	br, err := newZstdBackReader(stream)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		e := t.entries[br.peek(uint(t.maxBits))]
+		out[i] = e.sym
+		br.pos -= int(e.nb)
+	}
+	if br.pos != 0 {
+		return errZstdCorrupt
+	}
+	return nil
+}
+
+// zstdReader decompresses a stream of zstd frames
+type zstdReader struct {
+	r        io.Reader
+	err      error
+	hist     []byte // this frame's output; matches copy from its last window
+	out      int    // hist[out:] is not read yet
+	window   int
+	blockMax int
+	inFrame  bool
+	checksum bool
+	size     int64 // frame content size, or -1
+	written  int64
+	digest   xxh64
+	rep      [3]int
+	huff     *huffmanTable
+	ll       *fseTable
+	of       *fseTable
+	ml       *fseTable
+	block    []byte
+	lits     []byte
+}
+
+// newZstdReader reads the first frame header of r
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	z := &zstdReader{r: r}
+	if err := z.nextFrame(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	for z.out == len(z.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.decodeBlock()
+	}
+	n := copy(p, z.hist[z.out:])
+	z.out += n
+	return n, nil
+}
+
+func (z *zstdReader) Close() error {
+	return nil
+}
+
+// nextFrame reads the next frame header, skipping skippable frames
+func (z *zstdReader) nextFrame() error {
+	var hdr [14]byte
+	for {
+		if _, err := io.ReadFull(z.r, hdr[:4]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errZstdCorrupt
+			}
+			return err
+		}
+		magic := binary.LittleEndian.Uint32(hdr[:])
+		if magic&0xFFFFFFF0 != 0x184D2A50 {
+			if magic != zstdMagic {
+				return fmt.Errorf("zstd: not a zstd frame")
+			}
+			break
+		}
+		if _, err := io.ReadFull(z.r, hdr[:4]); err != nil {
+			return errZstdCorrupt
+		}
+		if _, err := io.CopyN(io.Discard, z.r, int64(binary.LittleEndian.Uint32(hdr[:]))); err != nil {
+			return errZstdCorrupt
+		}
+	}
+	if _, err := io.ReadFull(z.r, hdr[:1]); err != nil {
+		return errZstdCorrupt
+	}
+	fhd := hdr[0]
+	single := fhd&0x20 != 0
+	if fhd&0x08 != 0 {
+		return errZstdCorrupt
+	}
+	didSize := [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && single {
+		fcsSize = 1
+	}
+	n := didSize + fcsSize
+	if !single {
+		n++
+	}
+	if _, err := io.ReadFull(z.r, hdr[:n]); err != nil {
+		return errZstdCorrupt
+	}
+	b := hdr[:n]
+	window := 0
+	if !single {
+		exp, mantissa := int(b[0]>>3), int(b[0]&7)
+		base := 1 << (10 + exp)
+		if exp > 13 {
+			return fmt.Errorf("zstd: window exceeds %d bytes", zstdMaxWindow)
+		}
+		window = base + base/8*mantissa
+		b = b[1:]
+	}
+	if didSize > 0 {
+		if zstdLoad(b[:didSize], 0) != 0 {
+			return fmt.Errorf("zstd: dictionaries are not supported")
+		}
+		b = b[didSize:]
+	}
+	z.size = -1
+	if fcsSize > 0 {
+		z.size = int64(zstdLoad(b[:fcsSize], 0))
+		if fcsSize == 2 {
+			z.size += 256
+		}
+		if z.size < 0 {
+			return errZstdCorrupt
+		}
+		if single {
+			if z.size > zstdMaxWindow {
+				return fmt.Errorf("zstd: window exceeds %d bytes", zstdMaxWindow)
+			}
+			window = int(z.size)
+		}
+	}
+	if window > zstdMaxWindow {
+		return fmt.Errorf("zstd: window exceeds %d bytes", zstdMaxWindow)
+	}
+	z.window, z.blockMax = window, min(window, zstdBlockMax)
+	z.inFrame, z.checksum, z.written = true, fhd&0x04 != 0, 0
+	z.hist, z.out = z.hist[:0], 0
+	z.digest.reset()
+	z.rep = [3]int{1, 4, 8}
+	z.huff, z.ll, z.of, z.ml = nil, nil, nil, nil
+	return nil
+}
+
+// decodeBlock appends the next block's output to hist, moving on to the next
+// frame after the last block
+func (z *zstdReader) decodeBlock() error {
+	if !z.inFrame {
+		if err := z.nextFrame(); err != nil {
+			return err
+		}
+	}
+	// Keep only the window, but move it rarely
+	if len(z.hist) > 2*z.window+zstdBlockMax {
+		n := len(z.hist) - z.window
+		copy(z.hist, z.hist[n:])
+		z.hist = z.hist[:z.window]
+		z.out -= n
+	}
+	var hdr [4]byte
+	if _, err := io.ReadFull(z.r, hdr[:3]); err != nil {
+		return errZstdCorrupt
+	}
+	h := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	last, typ, size := h&1 != 0, h>>1&3, h>>3
+	start := len(z.hist)
+	switch typ {
+	case 0, 2:
+		if size > z.blockMax {
+			return errZstdCorrupt
+		}
+		if cap(z.block) < size {
+			z.block = make([]byte, size)
+		}
+		z.block = z.block[:size]
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return errZstdCorrupt
+		}
+		if typ == 0 {
+			z.hist = append(z.hist, z.block...)
+		} else if err := z.decodeCompressed(z.block); err != nil {
+			return err
+		}
+	case 1:
+		if size > z.blockMax {
+			return errZstdCorrupt
+		}
+		if _, err := io.ReadFull(z.r, hdr[:1]); err != nil {
+			return errZstdCorrupt
+		}
+		for i := 0; i < size; i++ {
+			z.hist = append(z.hist, hdr[0])
+		}
+	default:
+		return errZstdCorrupt
+	}
+	if len(z.hist)-start > z.blockMax {
+		return errZstdCorrupt
+	}
+	z.digest.write(z.hist[start:])
+	z.written += int64(len(z.hist) - start)
+	if z.size >= 0 && z.written > z.size {
+		return errZstdCorrupt
+	}
+	if !last {
+		return nil
+	}
+	z.inFrame = false
+	if z.size >= 0 && z.written != z.size {
+		return errZstdCorrupt
+	}
+	if z.checksum {
+		if _, err := io.ReadFull(z.r, hdr[:4]); err != nil {
+			return errZstdCorrupt
+		}
+		if binary.LittleEndian.Uint32(hdr[:]) != uint32(z.digest.sum()) {
+			return fmt.Errorf("zstd: checksum mismatch")
+		}
+	}
+	return nil
+}
+
+func (z *zstdReader) decodeCompressed(b []byte) error {
+	n, err := z.decodeLiterals(b)
+	if err != nil {
+		return err
+	}
+	b = b[n:]
+	if len(b) == 0 {
+		return errZstdCorrupt
+	}
+	nseq := int(b[0])
+	switch {
+	case nseq == 0:
+		z.hist = append(z.hist, z.lits...)
+		return nil
+	case nseq < 128:
+		b = b[1:]
+	case nseq < 255 && len(b) >= 2:
+		nseq, b = (nseq-128)<<8+int(b[1]), b[2:]
+	case len(b) >= 3:
+		nseq, b = int(b[1])+int(b[2])<<8+0x7F00, b[3:]
+	default:
+		return errZstdCorrupt
+	}
+	if len(b) == 0 || b[0]&3 != 0 {
+		return errZstdCorrupt
+	}
+	modes := b[0]
+	b = b[1:]
+	for _, t := range []struct {
+		table  **fseTable
+		mode   uint8
+		def    *fseTable
+		maxSym int
+		maxLog uint8
+	}{
+		{&z.ll, modes >> 6, zstdLLTable, 35, 9},
+		{&z.of, modes >> 4 & 3, zstdOFTable, 31, 8},
+		{&z.ml, modes >> 2 & 3, zstdMLTable, 52, 9},
+	} {
+		switch t.mode {
+		case 0:
+			*t.table = t.def
+		case 1:
+			if len(b) == 0 || int(b[0]) > t.maxSym {
+				return errZstdCorrupt
+			}
+			*t.table, b = rleFSETable(b[0]), b[1:]
+		case 2:
+			ft, n, err := readFSETable(b, t.maxSym, t.maxLog)
+			if err != nil {
+				return err
+			}
+			*t.table, b = ft, b[n:]
+		case 3:
+			if *t.table == nil {
+				return errZstdCorrupt
+			}
+		}
+	}
+	br, err := newZstdBackReader(b)
+	if err != nil {
+		return err
+	}
+	ll, of, ml := z.ll, z.of, z.ml
+	sll, sof, sml := int(br.read(uint(ll.accLog))), int(br.read(uint(of.accLog))), int(br.read(uint(ml.accLog)))
+	start, lits := len(z.hist), z.lits
+	for i := 0; i < nseq; i++ {
+		llc, ofc, mlc := ll.states[sll].sym, of.states[sof].sym, ml.states[sml].sym
+		if llc > 35 || ofc > 31 || mlc > 52 {
+			return errZstdCorrupt
+		}
+		offset := 1<<ofc + int(br.read(uint(ofc)))
+		mlen := int(zstdMLBase[mlc]) + int(br.read(uint(zstdMLBits[mlc])))
+		llen := int(zstdLLBase[llc]) + int(br.read(uint(zstdLLBits[llc])))
+		if i < nseq-1 {
+			st := ll.states[sll]
+			sll = int(st.base) + int(br.read(uint(st.nb)))
+			st = ml.states[sml]
+			sml = int(st.base) + int(br.read(uint(st.nb)))
+			st = of.states[sof]
+			sof = int(st.base) + int(br.read(uint(st.nb)))
+		}
+		if br.pos < 0 {
+			return errZstdCorrupt
+		}
+
+		if offset > 3 {
+			offset -= 3
+			z.rep = [3]int{offset, z.rep[0], z.rep[1]}
+		} else {
+			if llen == 0 {
+				offset++
+			}
+			switch offset {
+			case 1:
+				offset = z.rep[0]
+			case 2:
+				offset = z.rep[1]
+				z.rep = [3]int{offset, z.rep[0], z.rep[2]}
+			case 3:
+				offset = z.rep[2]
+				z.rep = [3]int{offset, z.rep[0], z.rep[1]}
+			default:
+				offset = z.rep[0] - 1
+				z.rep = [3]int{offset, z.rep[0], z.rep[1]}
+			}
+		}
+
+		if llen > len(lits) || len(z.hist)-start+llen+mlen > z.blockMax {
+			return errZstdCorrupt
+		}
+		z.hist = append(z.hist, lits[:llen]...)
+		lits = lits[llen:]
+		if offset <= 0 || offset > len(z.hist) || offset > z.window {
+			return errZstdCorrupt
+		}
+		from := len(z.hist) - offset
+		for mlen > 0 {
+			n := min(mlen, offset)
+			z.hist = append(z.hist, z.hist[from:from+n]...)
+			from += n
+			mlen -= n
+		}
+	}
+	if br.pos != 0 {
+		return errZstdCorrupt
+	}
+	z.hist = append(z.hist, lits...)
+	return nil
+}
+
+// decodeLiterals fills z.lits; it returns the bytes used
+func (z *zstdReader) decodeLiterals(b []byte) (int, error) {
+	size0 := len(b)
+	if len(b) < 5 {
+		b = append(b[:len(b):len(b)], make([]byte, 5-len(b))...) // headers are at most 5 bytes
+	}
+	typ, format := b[0]&3, b[0]>>2&3
+	if typ < 2 {
+		var size, n int
+		switch format {
+		case 0, 2:
+			size, n = int(b[0]>>3), 1
+		case 1:
+			size, n = int(b[0]>>4)|int(b[1])<<4, 2
+		case 3:
+			size, n = int(b[0]>>4)|int(b[1])<<4|int(b[2])<<12, 3
+		}
+		if size > z.blockMax {
+			return 0, errZstdCorrupt
+		}
+		if typ == 0 {
+			if n+size > size0 {
+				return 0, errZstdCorrupt
+			}
+			z.lits = append(z.lits[:0], b[n:n+size]...)
+			return n + size, nil
+		}
+		if n >= size0 {
+			return 0, errZstdCorrupt
+		}
+		z.lits = z.lits[:0]
+		for i := 0; i < size; i++ {
+			z.lits = append(z.lits, b[n])
+		}
+		return n + 1, nil
+	}
+
+	var size, csize, n int
+	switch format {
+	case 0, 1:
+		v := int(zstdLoad(b[:3], 0))
+		size, csize, n = v>>4&0x3FF, v>>14&0x3FF, 3
+	case 2:
+		v := int(zstdLoad(b[:4], 0))
+		size, csize, n = v>>4&0x3FFF, v>>18&0x3FFF, 4
+	case 3:
+		v := int(zstdLoad(b[:5], 0))
+		size, csize, n = v>>4&0x3FFFF, v>>22&0x3FFFF, 5
+	}
+	if size > z.blockMax || n+csize > size0 {
+		return 0, errZstdCorrupt
+	}
+	data := b[n : n+csize]
+	if typ == 2 {
+		t, used, err := readHuffmanTable(data)
+		if err != nil {
+			return 0, err
+		}
+		z.huff, data = t, data[used:]
+	} else if z.huff == nil {
+		return 0, errZstdCorrupt
+	}
+	if cap(z.lits) < size {
+		z.lits = make([]byte, size)
+	}
+	z.lits = z.lits[:size]
+	if format == 0 {
+		return n + csize, z.huff.decode(z.lits, data)
+	}
+	if len(data) < 6 {
+		return 0, errZstdCorrupt
+	}
+	seg := (size + 3) / 4
+	if 3*seg > size {
+		return 0, errZstdCorrupt
+	}
+	streams := data[6:]
+	for i := 0; i < 4; i++ {
+		ssize := len(streams)
+		if i < 3 {
+			ssize = int(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+		out := z.lits[i*seg : min((i+1)*seg, size)]
+		if ssize > len(streams) {
+			return 0, errZstdCorrupt
+		}
+		if err := z.huff.decode(out, streams[:ssize]); err != nil {
+			return 0, err
+		}
+		streams = streams[ssize:]
+	}
+	return n + csize, nil
+}
+
+// zstdWriter compresses into a single frame; matches reach back as far as
+// the previous block
+type zstdWriter struct {
+	w      io.Writer
+	buf    []byte // the previous block's input, then the next block's
+	start  int    // where the next block begins in buf
+	out    []byte
+	seqs   []zstdSequence
+	lits   []byte
+	digest xxh64
+	head   [1 << zstdHashLog]int32
+	chain  [zstdWriterWindow]int32 // the previous position with the same hash
+	rep    [3]uint32
+	header bool
+	err    error
+}
+
+func newZstdWriter(w io.Writer) *zstdWriter {
+	z := &zstdWriter{}
+	z.Reset(w)
+	return z
+}
+
+// Reset discards the writer's state and starts a new frame on w
+func (z *zstdWriter) Reset(w io.Writer) {
+	z.w, z.buf, z.start, z.header, z.err = w, z.buf[:0], 0, false, nil
+	z.rep = [3]uint32{1, 4, 8}
+	z.digest.reset()
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	z.digest.write(p)
+	for len(p) > 0 {
+		k := min(len(p), zstdBlockMax-(len(z.buf)-z.start))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		if len(z.buf)-z.start == zstdBlockMax {
+			if err := z.writeBlock(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes what is buffered as a block
+func (z *zstdWriter) Flush() error {
+	if z.err != nil || len(z.buf) == z.start {
+		return z.err
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the last block and the content checksum
+func (z *zstdWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if err := z.writeBlock(true); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(z.digest.sum()))
+	_, z.err = z.w.Write(sum[:])
+	if z.err == nil {
+		z.err = errors.New("zstd: writer is closed")
+		return nil
+	}
+	return z.err
+}
+
+func (z *zstdWriter) writeBlock(last bool) error {
+	z.out = z.out[:0]
+	if !z.header {
+		// Content checksum, no size; a 256 KiB window
+		z.out = append(z.out, 0x28, 0xB5, 0x2F, 0xFD, 0x04, 0x40)
+		z.header = true
+	}
+	src := z.buf[z.start:]
+	h := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	typ := 0
+	if len(src) > 0 && allSame(src) {
+		typ = 1
+		z.out = append(z.out, src[0])
+	} else {
+		n := len(z.out)
+		out, rep := z.compressBlock(z.out)
+		if len(out)-n < len(src) {
+			typ, z.out, z.rep = 2, out, rep
+		} else {
+			z.out = append(out[:n], src...)
+		}
+	}
+	size := len(z.out) - h - 3
+	if typ == 1 {
+		size = len(src)
+	}
+	v := size<<3 | typ<<1
+	if last {
+		v |= 1
+	}
+	z.out[h], z.out[h+1], z.out[h+2] = byte(v), byte(v>>8), byte(v>>16)
+
+	// Keep one block of history
+	if n := len(z.buf) - zstdBlockMax; n > 0 {
+		copy(z.buf, z.buf[n:])
+		z.buf = z.buf[:zstdBlockMax]
+	}
+	z.start = len(z.buf)
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+func allSame(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// zstdSequence is literals, then a match; ofv is the offset as coded, 1 for
+// the last offset and otherwise the offset plus 3
+type zstdSequence struct {
+	llen, ofv, mlen uint32
+}
+
+// compressBlock appends the compressed form of buf[start:] to out and returns
+// the repeat offsets after it; the result is only used when it is smaller
+func (z *zstdWriter) compressBlock(out []byte) ([]byte, [3]uint32) {
+	data, rep := z.buf, z.rep
+	hash := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(data[i:]) * 2654435761 >> (32 - zstdHashLog)
+	}
+	insert := func(i int) {
+		if i+4 <= len(data) {
+			h := hash(i)
+			z.chain[i], z.head[h] = z.head[h], int32(i)
+		}
+	}
+	matchLen := func(i, cand int) int {
+		n := 0
+		for i+n+8 <= len(data) {
+			if x := binary.LittleEndian.Uint64(data[i+n:]) ^ binary.LittleEndian.Uint64(data[cand+n:]); x != 0 {
+				return n + bits.TrailingZeros64(x)/8
+			}
+			n += 8
+		}
+		for i+n < len(data) && data[i+n] == data[cand+n] {
+			n++
+		}
+		return n
+	}
+	// find returns the longest match at i, trying the last offset first
+	find := func(i int) (off, mlen int) {
+		if r := int(rep[0]); r <= i {
+			off, mlen = r, matchLen(i, i-r)
+		}
+		cand := z.head[hash(i)]
+		for d := 0; cand >= 0 && d < zstdChainDepth; d++ {
+			if n := matchLen(i, int(cand)); n > mlen {
+				off, mlen = i-int(cand), n
+			}
+			cand = z.chain[cand]
+		}
+		return off, mlen
+	}
+
+	for i := range z.head {
+		z.head[i] = -1
+	}
+	for i := 0; i < z.start; i++ {
+		insert(i)
+	}
+	z.seqs, z.lits = z.seqs[:0], z.lits[:0]
+	anchor, i, end := z.start, z.start, len(data)-8
+	for i < end {
+		off, mlen := find(i)
+		insert(i)
+		if mlen < zstdMinMatch {
+			i += 1 + (i-anchor)>>6 // skip faster through incompressible data
+			continue
+		}
+		// A longer match one byte on is worth a literal
+		for i+1 < end {
+			off1, mlen1 := find(i + 1)
+			if mlen1 <= mlen {
+				break
+			}
+			i, off, mlen = i+1, off1, mlen1
+			insert(i)
+		}
+		for i > anchor && i > off && data[i-1] == data[i-off-1] {
+			i, mlen = i-1, mlen+1
+		}
+		s := zstdSequence{llen: uint32(i - anchor), ofv: uint32(off) + 3, mlen: uint32(mlen)}
+		if s.llen > 0 && uint32(off) == rep[0] {
+			s.ofv = 1
+		} else {
+			rep = [3]uint32{uint32(off), rep[0], rep[1]}
+		}
+		z.lits = append(z.lits, data[anchor:i]...)
+		z.seqs = append(z.seqs, s)
+		for j := i + 1; j < i+mlen; j++ {
+			insert(j)
+		}
+		i += mlen
+		anchor = i
+	}
+	z.lits = append(z.lits, data[anchor:]...)
+
+	out = appendZstdLiterals(out, z.lits)
+	switch n := len(z.seqs); {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if len(z.seqs) == 0 {
+		return out, rep
+	}
+	return appendZstdSequences(out, z.seqs), rep
+}
+
+func zstdLLCode(v uint32) uint8 {
+	if v < 16 {
+		return uint8(v)
+	}
+	c := uint8(35)
+	for zstdLLBase[c] > v {
+		c--
+	}
+	return c
+}
+
+func zstdMLCode(v uint32) uint8 {
+	if v < 35 {
+		return uint8(v - 3)
+	}
+	c := uint8(52)
+	for zstdMLBase[c] > v {
+		c--
+	}
+	return c
+}
+
+// appendZstdSequences appends the sequences section. Each of the three codes
+// gets the predefined table, a single symbol or a table of its own, whichever
+// is smallest.
+func appendZstdSequences(out []byte, seqs []zstdSequence) []byte {
+	codes := make([][3]uint8, len(seqs))
+	var llCounts [36]int
+	var ofCounts [32]int
+	var mlCounts [53]int
+	for i, s := range seqs {
+		c := [3]uint8{zstdLLCode(s.llen), uint8(bits.Len32(s.ofv) - 1), zstdMLCode(s.mlen)}
+		codes[i] = c
+		llCounts[c[0]]++
+		ofCounts[c[1]]++
+		mlCounts[c[2]]++
+	}
+	modes := len(out)
+	out = append(out, 0)
+	var tables [3]*fseTable
+	for i, k := range []struct {
+		counts []int
+		def    *fseTable
+		defN   []int16
+		maxLog uint8
+	}{
+		{llCounts[:], zstdLLTable, zstdLLDefault, 9},
+		{ofCounts[:], zstdOFTable, zstdOFDefault, 8},
+		{mlCounts[:], zstdMLTable, zstdMLDefault, 9},
+	} {
+		var mode byte
+		tables[i], mode, out = chooseFSETable(out, k.counts, k.def, k.defN, k.maxLog, len(seqs))
+		out[modes] |= mode << (6 - 2*i)
+	}
+	return append(out, encodeZstdSequences(seqs, codes, tables[0], tables[1], tables[2])...)
+}
+
+// chooseFSETable picks the table for one code, appending its description
+func chooseFSETable(out []byte, counts []int, def *fseTable, defNorm []int16, maxLog uint8, n int) (*fseTable, byte, []byte) {
+	distinct, last := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			distinct++
+			last = s
+		}
+	}
+	if distinct == 1 && n > 1 {
+		return rleFSETable(uint8(last)).withEncoder(last + 1), 1, append(out, byte(last))
+	}
+	defCost := fseCost(counts, defNorm, def.accLog)
+	if n < 16 && defCost >= 0 {
+		return def, 0, out
+	}
+	accLog := min(maxLog, max(5, uint8(bits.Len(uint(n))), uint8(bits.Len(uint(distinct)))+1))
+	norm := normalizeFSE(counts, accLog)
+	desc := appendFSETable(nil, norm, accLog)
+	if cost := fseCost(counts, norm, accLog) + float64(8*len(desc)); defCost >= 0 && defCost <= cost {
+		return def, 0, out
+	}
+	t, err := newFSETable(norm, accLog)
+	if err != nil {
+		panic(err) // norm is a valid distribution
+	}
+	return t.withEncoder(len(norm)), 2, append(out, desc...)
+}
+
+// encodeZstdSequences writes the sequences bitstream backwards, so that the
+// decoder reads the first sequence first
+func encodeZstdSequences(seqs []zstdSequence, codes [][3]uint8, ll, of, ml *fseTable) []byte {
+	var bw zstdBitWriter
+	var sll, sof, sml uint16
+	for i := len(seqs) - 1; i >= 0; i-- {
+		s := seqs[i]
+		llc, ofc, mlc := codes[i][0], codes[i][1], codes[i][2]
+		if i == len(seqs)-1 {
+			sll, sof, sml = ll.enc[llc][0], of.enc[ofc][0], ml.enc[mlc][0]
+		} else {
+			next := [3]uint16{sof, sml, sll}
+			sof, sml, sll = of.enc[ofc][sof], ml.enc[mlc][sml], ll.enc[llc][sll]
+			for j, t := range []*fseTable{of, ml, ll} {
+				st := t.states[[3]uint16{sof, sml, sll}[j]]
+				bw.add(uint64(next[j]-st.base), uint(st.nb))
+			}
+		}
+		bw.add(uint64(s.llen-zstdLLBase[llc]), uint(zstdLLBits[llc]))
+		bw.add(uint64(s.mlen-zstdMLBase[mlc]), uint(zstdMLBits[mlc]))
+		bw.add(uint64(s.ofv-1<<ofc), uint(ofc))
+	}
+	bw.add(uint64(sml), uint(ml.accLog))
+	bw.add(uint64(sof), uint(of.accLog))
+	bw.add(uint64(sll), uint(ll.accLog))
+	return bw.close()
+}
+
+// appendZstdLiterals appends the literals section: Huffman-coded when that is
+// smaller, else raw or a run of one byte
+func appendZstdLiterals(out, lits []byte) []byte {
+	var freq [256]int
+	distinct, maxSym := 0, 0
+	for _, c := range lits {
+		if freq[c] == 0 {
+			distinct++
+		}
+		freq[c]++
+		maxSym = max(maxSym, int(c))
+	}
+	n := len(lits)
+	if distinct == 1 && n > 1 {
+		return append(appendZstdLiteralsHeader(out, 1, n), lits[0])
+	}
+	if distinct > 1 && n >= 32 {
+		if enc := huffmanLiterals(lits, freq[:maxSym+1]); enc != nil && len(enc) < n {
+			return append(out, enc...)
+		}
+	}
+	return append(appendZstdLiteralsHeader(out, 0, n), lits...)
+}
+
+func appendZstdLiteralsHeader(out []byte, typ, n int) []byte {
+	switch {
+	case n < 32:
+		return append(out, byte(typ|n<<3))
+	case n < 4096:
+		return append(out, byte(typ|1<<2|n<<4), byte(n>>4))
+	default:
+		return append(out, byte(typ|3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+}
+
+// huffmanLiterals returns a compressed literals section, header included
+func huffmanLiterals(lits []byte, freq []int) []byte {
+	nbits := huffmanLengths(freq, zstdHuffmanMaxBits)
+	maxBits := uint8(0)
+	last := 0
+	for s, nb := range nbits {
+		maxBits = max(maxBits, nb)
+		if nb > 0 {
+			last = s
+		}
+	}
+	weights := make([]uint8, last)
+	for s, nb := range nbits[:last] {
+		if nb > 0 {
+			weights[s] = maxBits + 1 - nb
+		}
+	}
+	tree := appendHuffmanWeights(nil, weights)
+	if tree == nil {
+		return nil
+	}
+	starts := huffmanStarts(nbits, maxBits)
+	codes := make([]uint16, len(nbits))
+	for s, nb := range nbits {
+		if nb > 0 {
+			codes[s] = uint16(starts[s] >> (maxBits - nb))
+		}
+	}
+	stream := func(b []byte) []byte {
+		var bw zstdBitWriter
+		for i := len(b) - 1; i >= 0; i-- {
+			bw.add(uint64(codes[b[i]]), uint(nbits[b[i]]))
+		}
+		return bw.close()
+	}
+
+	n := len(lits)
+	body := tree
+	format := 0
+	if n < 1024 {
+		body = append(body, stream(lits)...)
+	} else {
+		seg := (n + 3) / 4
+		var streams []byte
+		jump := make([]byte, 6)
+		for i := 0; i < 4; i++ {
+			s := stream(lits[i*seg : min((i+1)*seg, n)])
+			if i < 3 {
+				if len(s) > 0xFFFF {
+					return nil
+				}
+				binary.LittleEndian.PutUint16(jump[2*i:], uint16(len(s)))
+			}
+			streams = append(streams, s...)
+		}
+		body = append(append(body, jump...), streams...)
+		format = 1
+	}
+	csize := len(body)
+	var hdr []byte
+	switch {
+	case n < 1024 && csize < 1024:
+		v := 2 | format<<2 | n<<4 | csize<<14
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case n < 16384 && csize < 16384:
+		v := 2 | 2<<2 | n<<4 | csize<<18
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	default:
+		v := uint64(2 | 3<<2 | n<<4 | csize<<22)
+		hdr = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32)}
+	}
+	if format == 0 && csize >= 1024 {
+		return nil
+	}
+	return append(hdr, body...)
+}
+
+// appendHuffmanWeights appends a tree description, the weights of all but the
+// last symbol: packed in nibbles when that is smaller or FSE cannot code them,
+// else FSE-coded with two interleaved states. It returns nil when neither fits.
+func appendHuffmanWeights(out []byte, weights []uint8) []byte {
+	n := len(weights)
+	var direct []byte
+	if n <= 128 {
+		direct = append(out, byte(127+n))
+		for i := 0; i < n; i += 2 {
+			b := weights[i] << 4
+			if i+1 < n {
+				b |= weights[i+1]
+			}
+			direct = append(direct, b)
+		}
+	}
+	if n < 2 {
+		return direct
+	}
+	var counts [zstdHuffmanMaxBits + 1]int
+	for _, w := range weights {
+		counts[w]++
+	}
+	const accLog = 6
+	norm := normalizeFSE(counts[:], accLog)
+	t, err := newFSETable(norm, accLog)
+	if err != nil {
+		panic(err) // norm is a valid distribution
+	}
+	t.withEncoder(len(norm))
+
+	// The decoder stops when updating the state of the last but one weight
+	// runs out of bits, so that state must read some
+	st := make([]uint16, n)
+	st[n-1] = t.enc[weights[n-1]][0]
+	found := false
+	for u, s := range t.states {
+		if s.sym == weights[n-2] && s.nb > 0 {
+			st[n-2], found = uint16(u), true
+			break
+		}
+	}
+	if !found {
+		return direct
+	}
+	var bw zstdBitWriter
+	for i := n - 3; i >= 0; i-- {
+		st[i] = t.enc[weights[i]][st[i+2]]
+		s := t.states[st[i]]
+		bw.add(uint64(st[i+2]-s.base), uint(s.nb))
+	}
+	bw.add(uint64(st[1]), accLog)
+	bw.add(uint64(st[0]), accLog)
+	fse := appendFSETable(nil, norm, accLog)
+	fse = append(fse, bw.close()...)
+	if len(fse) >= 128 || (direct != nil && len(direct)-len(out) <= 1+len(fse)) {
+		return direct
+	}
+	return append(append(out, byte(len(fse))), fse...)
+}
+
+// huffmanLengths gives code lengths of at most maxBits with a Kraft sum of
+// exactly one, as zstd requires
+func huffmanLengths(freq []int, maxBits uint8) []uint8 {
+	type node struct {
+		freq        int
+		sym         int // -1 for internal nodes
+		left, right int
+	}
+	var nodes []node
+	var live []int
+	for s, f := range freq {
+		if f > 0 {
+			nodes = append(nodes, node{freq: f, sym: s})
+			live = append(live, len(nodes)-1)
+		}
+	}
+	for len(live) > 1 {
+		sort.Slice(live, func(i, j int) bool { return nodes[live[i]].freq < nodes[live[j]].freq })
+		a, b := live[0], live[1]
+		nodes = append(nodes, node{freq: nodes[a].freq + nodes[b].freq, sym: -1, left: a, right: b})
+		live = append(live[2:], len(nodes)-1)
+	}
+	nbits := make([]uint8, len(freq))
+	var walk func(i, depth int)
+	walk = func(i, depth int) {
+		if nodes[i].sym >= 0 {
+			nbits[nodes[i].sym] = uint8(min(max(depth, 1), int(maxBits)))
+			return
+		}
+		walk(nodes[i].left, depth+1)
+		walk(nodes[i].right, depth+1)
+	}
+	walk(live[0], 0)
+
+	// Clamping may oversubscribe the code: lengthen the rarest short codes
+	// until it fits, then shorten the commonest long ones to fill it up
+	syms := make([]int, 0, len(freq))
+	for s, nb := range nbits {
+		if nb > 0 {
+			syms = append(syms, s)
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return freq[syms[i]] < freq[syms[j]] })
+	full := 1 << maxBits
+	kraft := 0
+	for _, s := range syms {
+		kraft += 1 << (maxBits - nbits[s])
+	}
+	for kraft > full {
+		for _, s := range syms {
+			if nbits[s] < maxBits {
+				nbits[s]++
+				kraft -= 1 << (maxBits - nbits[s])
+				break
+			}
+		}
+	}
+	for kraft < full {
+		for i := len(syms) - 1; i >= 0; i-- {
+			s := syms[i]
+			if nb := nbits[s]; nb > 1 && kraft+1<<(maxBits-nb) <= full {
+				nbits[s]--
+				kraft += 1 << (maxBits - nb)
+				break
+			}
+		}
+	}
+	return nbits
+}
+
+// xxh64 is XXH64 with seed 0, the content checksum of zstd frames
+type xxh64 struct {
+	v     [4]uint64
+	buf   [32]byte
+	nbuf  int
+	total uint64
+}
+
+const (
+	xxhP1 uint64 = 11400714785074694791
+	xxhP2 uint64 = 14029467366897019727
+	xxhP3 uint64 = 1609587929392839161
+	xxhP4 uint64 = 9650029242287828579
+	xxhP5 uint64 = 2870177450012600261
+)
+
+func (x *xxh64) reset() {
+	p1, p2 := xxhP1, xxhP2
+	*x = xxh64{v: [4]uint64{p1 + p2, p2, 0, -p1}}
+}
+
+func xxhRound(acc, in uint64) uint64 {
+	return bits.RotateLeft64(acc+in*xxhP2, 31) * xxhP1
+}
+
+func xxhMerge(acc, v uint64) uint64 {
+	return (acc^xxhRound(0, v))*xxhP1 + xxhP4
+}
+
+func (x *xxh64) write(p []byte) {
+	x.total += uint64(len(p))
+	if x.nbuf > 0 {
+		n := copy(x.buf[x.nbuf:], p)
+		x.nbuf += n
+		p = p[n:]
+		if x.nbuf < 32 {
+			return
+		}
+		x.stripes(x.buf[:])
+		x.nbuf = 0
+	}
+	n := len(p) &^ 31
+	x.stripes(p[:n])
+	x.nbuf = copy(x.buf[:], p[n:])
+}
+
+func (x *xxh64) stripes(p []byte) {
+	for ; len(p) >= 32; p = p[32:] {
+		for i := range x.v {
+			x.v[i] = xxhRound(x.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+		}
+	}
+}
+
+func (x *xxh64) sum() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		v := x.v
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, vi := range v {
+			h = xxhMerge(h, vi)
+		}
+	} else {
+		h = xxhP5
+	}
+	h += x.total
+	p := x.buf[:x.nbuf]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhP1 + xxhP4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhP1
+		h = bits.RotateLeft64(h, 23)*xxhP2 + xxhP3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * xxhP5
+		h = bits.RotateLeft64(h, 11) * xxhP1
+	}
+	h ^= h >> 33
+	h *= xxhP2
+	h ^= h >> 29
+	h *= xxhP3
+	h ^= h >> 32
+	return h
+}